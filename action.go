@@ -0,0 +1,147 @@
+package firewall
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+)
+
+var (
+	_ IActionFirewall   = (*ActionFirewall)(nil)
+	_ IUnbanFirewall    = (*ActionFirewall)(nil)
+	_ IExpiringFirewall = (*ActionFirewall)(nil)
+	_ INetworkFirewall  = (*ActionFirewall)(nil)
+)
+
+// BanAction is how a ban is enforced, chosen by the policy deciding it.
+// The backend decides what it means, e.g. an alias matched by a tarpit
+// rule instead of a block rule.
+type BanAction string
+
+const (
+	// BanActionBlock is a hard ban, the default.
+	BanActionBlock BanAction = ""
+	// BanActionRateLimit limits the rate of the ip instead of blocking it.
+	BanActionRateLimit BanAction = "rate-limit"
+	// BanActionTarpit slows down the connections of the ip.
+	BanActionTarpit BanAction = "tarpit"
+	// BanActionTag tags the ip, e.g. to show it a captcha.
+	BanActionTag BanAction = "tag"
+)
+
+func (a BanAction) String() string {
+	if a == BanActionBlock {
+		return "block"
+	}
+	return string(a)
+}
+
+// ParseBanAction parses the name of an action, "block" or empty is
+// BanActionBlock.
+func ParseBanAction(s string) (BanAction, error) {
+	switch a := BanAction(s); a {
+	case BanActionBlock, BanActionRateLimit, BanActionTarpit, BanActionTag:
+		return a, nil
+	case "block":
+		return BanActionBlock, nil
+	}
+	return "", fmt.Errorf("unknown ban action %q, one of block, rate-limit, tarpit, tag", s)
+}
+
+// IActionFirewall is a backend enforcing bans by their action, see
+// ActionFirewall. Backends not implementing it block the ips of every
+// action. The firewall passes the reasons of every ban for routing.
+type IActionFirewall interface {
+	IErrorFirewall
+	ActionBanIP(ip string, timeoutInMinute int, action BanAction, reasons []string) error
+}
+
+// ActionFirewall sends the bans of an action to the backend of the action,
+// e.g. an OPNsense backend writing to a separate tarpit alias. Blocks and
+// actions without a backend go to the block backend, which can be a
+// Router.
+type ActionFirewall struct {
+	block   IFirewall
+	actions map[BanAction]IFirewall
+	// all are the distinct backends, unbans are sent to all as the action
+	// of a ban is not known after a restart.
+	all *MultiFirewall
+}
+
+// NewActionFirewall returns an ActionFirewall blocking on block and
+// enforcing the other actions on their backend in actions.
+func NewActionFirewall(block IFirewall, actions map[BanAction]IFirewall) *ActionFirewall {
+	all := []IFirewall{block}
+	for _, a := range slices.Sorted(maps.Keys(actions)) {
+		if !slices.Contains(all, actions[a]) {
+			all = append(all, actions[a])
+		}
+	}
+	return &ActionFirewall{
+		block:   block,
+		actions: actions,
+		all:     NewMultiFirewall(all...),
+	}
+}
+
+// Name joins the names of the backends.
+func (f *ActionFirewall) Name() string {
+	return f.all.Name()
+}
+
+// ExpiresBans returns true if all backends expire bans by themselves.
+func (f *ActionFirewall) ExpiresBans() bool {
+	return f.all.ExpiresBans()
+}
+
+// BansNetworks returns true if all backends accept cidr bans.
+func (f *ActionFirewall) BansNetworks() bool {
+	return f.all.BansNetworks()
+}
+
+func (f *ActionFirewall) BanIP(ip string, timeoutInMinute int) {
+	if err := f.TryBanIP(ip, timeoutInMinute); err != nil {
+		log.Println(err)
+	}
+}
+
+// TryBanIP blocks ip.
+func (f *ActionFirewall) TryBanIP(ip string, timeoutInMinute int) error {
+	return f.ActionBanIP(ip, timeoutInMinute, BanActionBlock, nil)
+}
+
+// ActionBanIP bans ip on the backend of action, reasons are passed to an
+// IRoutingFirewall backend.
+func (f *ActionFirewall) ActionBanIP(ip string, timeoutInMinute int, action BanAction, reasons []string) error {
+	fw, ok := f.actions[action]
+	if !ok {
+		fw = f.block
+	}
+	if err := ForwardBan(fw, ip, timeoutInMinute, action, reasons); err != nil {
+		return newBackendError(backendName(fw), "ban", err)
+	}
+	return nil
+}
+
+// UnbanIP unbans ip on all backends implementing IUnbanFirewall.
+func (f *ActionFirewall) UnbanIP(ip string) error {
+	return f.all.UnbanIP(ip)
+}
+
+// ForwardBan bans ip on fw with what it takes of the ban: an IActionFirewall
+// gets the action and the reasons, an IRoutingFirewall the reasons, other
+// backends block ip. Backends wrapping another backend forward their bans
+// with it.
+func ForwardBan(fw IFirewall, ip string, timeoutInMinute int, action BanAction, reasons []string) error {
+	switch b := fw.(type) {
+	case IActionFirewall:
+		return b.ActionBanIP(ip, timeoutInMinute, action, reasons)
+	case IRoutingFirewall:
+		return b.RouteBanIP(ip, timeoutInMinute, reasons)
+	case IErrorFirewall:
+		return b.TryBanIP(ip, timeoutInMinute)
+	}
+	fw.BanIP(ip, timeoutInMinute)
+	return nil
+}
@@ -0,0 +1,117 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActionFirewall(t *testing.T) {
+	block := &MockUnbanFirewall{}
+	tarpit := &MockUnbanFirewall{}
+	captcha := &MockUnbanFirewall{}
+	a := NewActionFirewall(block, map[BanAction]IFirewall{BanActionTarpit: tarpit, BanActionTag: captcha})
+
+	policy := &MockPolicy{Decisions: map[string]Decision{
+		"scanner": {Verdict: VerdictBan, Minutes: 30, BanAction: BanActionTarpit},
+		"bot":     {Verdict: VerdictBan, BanAction: BanActionTag},
+		"flood":   {Verdict: VerdictBan, BanAction: BanActionRateLimit},
+	}}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, a, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}, WithPolicy(policy))
+	require.NoError(t, err)
+	mockLogger.Wg.Add(4)
+	fw.LogIPError("1.2.3.4", "scanner")
+	fw.LogIPError("1.2.3.5", "bot")
+	fw.LogIPError("1.2.3.6", "flood")
+	fw.BanIP("1.2.3.7", 10, "manual")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.4"}, tarpit.BannedIPs)
+	assert.Equal(t, []string{"1.2.3.5"}, captcha.BannedIPs)
+	// actions without a backend are blocks
+	assert.Equal(t, []string{"1.2.3.6", "1.2.3.7"}, block.BannedIPs)
+
+	actions := []BanAction{}
+	for _, e := range mockLogger.Events {
+		actions = append(actions, e.BanAction)
+	}
+	assert.Equal(t, []BanAction{BanActionTarpit, BanActionTag, BanActionRateLimit, BanActionBlock}, actions)
+
+	bans := fw.ListBans()
+	require.Len(t, bans, 4)
+	for _, b := range bans {
+		if b.IP == "1.2.3.4" {
+			assert.Equal(t, BanActionTarpit, b.BanAction)
+		}
+	}
+
+	// unbans go to every backend
+	require.NoError(t, a.UnbanIP("1.2.3.4"))
+	for _, b := range []*MockUnbanFirewall{block, tarpit, captcha} {
+		assert.Equal(t, []string{"1.2.3.4"}, b.UnbannedIPs)
+	}
+}
+
+func TestActionFirewall_Router(t *testing.T) {
+	edge := &MockUnbanFirewall{}
+	local := &MockUnbanFirewall{}
+	tarpit := &MockUnbanFirewall{}
+	a := NewActionFirewall(NewRouter(local, Route{Prefix: "ssh", Backend: edge}), map[BanAction]IFirewall{BanActionTarpit: tarpit})
+
+	require.NoError(t, a.ActionBanIP("1.2.3.4", 10, BanActionBlock, []string{"ssh bad password"}))
+	require.NoError(t, a.ActionBanIP("1.2.3.5", 10, BanActionBlock, []string{"scanner"}))
+	require.NoError(t, a.ActionBanIP("1.2.3.6", 10, BanActionTarpit, []string{"ssh bad password"}))
+
+	assert.Equal(t, []string{"1.2.3.4"}, edge.BannedIPs)
+	assert.Equal(t, []string{"1.2.3.5"}, local.BannedIPs)
+	assert.Equal(t, []string{"1.2.3.6"}, tarpit.BannedIPs)
+}
+
+func TestRouter_Actions(t *testing.T) {
+	edge := &MockUnbanFirewall{}
+	edgeTarpit := &MockUnbanFirewall{}
+	local := &MockUnbanFirewall{}
+	r := NewRouter(local, Route{Prefix: "ssh", Backend: NewActionFirewall(edge, map[BanAction]IFirewall{BanActionTarpit: edgeTarpit})})
+
+	require.NoError(t, r.ActionBanIP("1.2.3.4", 10, BanActionTarpit, []string{"ssh bad password"}))
+	require.NoError(t, r.ActionBanIP("1.2.3.5", 10, BanActionBlock, []string{"ssh bad password"}))
+	require.NoError(t, r.ActionBanIP("1.2.3.6", 10, BanActionTarpit, []string{"scanner"}))
+
+	assert.Equal(t, []string{"1.2.3.4"}, edgeTarpit.BannedIPs)
+	assert.Equal(t, []string{"1.2.3.5"}, edge.BannedIPs)
+	// backends without actions block
+	assert.Equal(t, []string{"1.2.3.6"}, local.BannedIPs)
+}
+
+func TestRestore_BanAction(t *testing.T) {
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, nil, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+	mockLogger.Wg.Add(1)
+	assert.Equal(t, 1, fw.Restore([]BanInfo{{IP: "1.2.3.4", Until: time.Now().Add(time.Hour), Reasons: []string{"r"}, BanAction: BanActionTarpit}}))
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, BanActionTarpit, mockLogger.Events[0].BanAction)
+	bans := fw.ListBans()
+	require.Len(t, bans, 1)
+	assert.Equal(t, BanActionTarpit, bans[0].BanAction)
+}
+
+func TestParseBanAction(t *testing.T) {
+	for _, s := range []string{"", "block"} {
+		a, err := ParseBanAction(s)
+		require.NoError(t, err)
+		assert.Equal(t, BanActionBlock, a)
+	}
+	a, err := ParseBanAction("tarpit")
+	require.NoError(t, err)
+	assert.Equal(t, BanActionTarpit, a)
+	assert.Equal(t, "tarpit", a.String())
+	assert.Equal(t, "block", BanActionBlock.String())
+
+	_, err = ParseBanAction("drop")
+	assert.Error(t, err)
+}
@@ -0,0 +1,186 @@
+// Package adminapi serves an HTTP api to manage a firewall embedded in
+// another program, e.g. on a router, without shelling into it:
+//
+//	GET    /v1/bans          the jailed ips
+//	POST   /v1/unban         {"ip" (or cidr), "reason", "ticket"}
+//	GET    /v1/whitelist     the whitelist rules added at runtime
+//	POST   /v1/whitelist     {"rule", "ttl" (e.g. "8h"), "reason"}
+//	DELETE /v1/whitelist     the rule of the "rule" query parameter
+//	GET    /v1/ips/{ip}      the counters, ban and geo data of an ip
+//	GET    /v1/geo           edition and build time of the geo databases
+//	GET    /v1/geo/{ip}      the geo data of an ip
+//
+// Every request needs the token of an operator as "Authorization: Bearer
+// <token>", see Auth. The daemon serves the same routes on its admin
+// listener with Register.
+package adminapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/internal/httpapi"
+)
+
+// Resolver returns the firewall managed by a request, it writes the error
+// and returns nil if there is none, e.g. for an unknown tenant.
+type Resolver func(w http.ResponseWriter, r *http.Request) *firewall.Firewall
+
+type api struct {
+	fw Resolver
+}
+
+// Handler serves the admin api of fw to requests bearing token. An empty
+// token rejects every request.
+func Handler(fw *firewall.Firewall, token string) http.Handler {
+	auth, err := NewAuth([]Operator{{Name: "admin", Token: token}}, "", nil)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "operator token required", http.StatusUnauthorized)
+		})
+	}
+	mux := http.NewServeMux()
+	Register(mux, func(http.ResponseWriter, *http.Request) *firewall.Firewall { return fw })
+	return auth.Wrap(mux)
+}
+
+// Register adds the routes of the api to mux, managing the firewall
+// returned by fw. The caller authenticates the requests, e.g. with
+// Auth.Wrap.
+func Register(mux *http.ServeMux, fw Resolver) {
+	a := &api{fw: fw}
+	mux.HandleFunc("GET /v1/bans", a.handleBans)
+	mux.HandleFunc("POST /v1/unban", a.handleUnban)
+	mux.HandleFunc("GET /v1/whitelist", a.handleWhitelist)
+	mux.HandleFunc("POST /v1/whitelist", a.handleWhitelistAdd)
+	mux.HandleFunc("DELETE /v1/whitelist", a.handleWhitelistRemove)
+	mux.HandleFunc("GET /v1/ips/{ip}", a.handleIP)
+	mux.HandleFunc("GET /v1/geo", a.handleGeoMetadata)
+	mux.HandleFunc("GET /v1/geo/{ip}", a.handleGeo)
+}
+
+func (a *api) handleBans(w http.ResponseWriter, r *http.Request) {
+	if fw := a.fw(w, r); fw != nil {
+		httpapi.WriteJSON(w, fw.ListBans())
+	}
+}
+
+type unbanRequest struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason,omitempty"`
+	Ticket string `json:"ticket,omitempty"`
+}
+
+// handleUnban unbans an ip or a network, the unban records the operator
+// and the ticket.
+func (a *api) handleUnban(w http.ResponseWriter, r *http.Request) {
+	fw := a.fw(w, r)
+	if fw == nil {
+		return
+	}
+	req := &unbanRequest{}
+	if !httpapi.Decode(w, r, req) || !httpapi.ValidIPOrNetwork(w, &req.IP) {
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "unbanned by admin"
+	}
+
+	if err := fw.UnbanIPBy(req.IP, req.Reason, firewall.Annotation{Operator: OperatorOf(r), Ticket: req.Ticket}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type whitelistRequest struct {
+	Rule string `json:"rule"`
+	// TTL removes the rule after it, never if empty.
+	TTL    string `json:"ttl,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (a *api) handleWhitelist(w http.ResponseWriter, r *http.Request) {
+	if fw := a.fw(w, r); fw != nil {
+		httpapi.WriteJSON(w, fw.RuntimeWhitelist())
+	}
+}
+
+func (a *api) handleWhitelistAdd(w http.ResponseWriter, r *http.Request) {
+	fw := a.fw(w, r)
+	if fw == nil {
+		return
+	}
+	req := &whitelistRequest{}
+	if !httpapi.Decode(w, r, req) {
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl < 0 {
+			http.Error(w, fmt.Sprintf("invalid ttl %q", req.TTL), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := fw.AddTempWhitelist(req.Rule, ttl, req.Reason); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *api) handleWhitelistRemove(w http.ResponseWriter, r *http.Request) {
+	fw := a.fw(w, r)
+	if fw == nil {
+		return
+	}
+	if err := fw.RemoveFromWhitelist(r.URL.Query().Get("rule")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *api) handleIP(w http.ResponseWriter, r *http.Request) {
+	fw := a.fw(w, r)
+	if fw == nil {
+		return
+	}
+	if ip := r.PathValue("ip"); httpapi.ValidIP(w, &ip) {
+		httpapi.WriteJSON(w, fw.InspectIP(ip))
+	}
+}
+
+func (a *api) handleGeoMetadata(w http.ResponseWriter, r *http.Request) {
+	fw := a.fw(w, r)
+	if fw == nil {
+		return
+	}
+	md, ok := fw.GeoMetadata()
+	if !ok {
+		http.Error(w, "no geo database", http.StatusNotFound)
+		return
+	}
+	httpapi.WriteJSON(w, md)
+}
+
+func (a *api) handleGeo(w http.ResponseWriter, r *http.Request) {
+	fw := a.fw(w, r)
+	if fw == nil {
+		return
+	}
+	ip := r.PathValue("ip")
+	if !httpapi.ValidIP(w, &ip) {
+		return
+	}
+	geo := fw.LookupGeo(ip)
+	if geo == nil {
+		http.Error(w, "no geo database", http.StatusNotFound)
+		return
+	}
+	httpapi.WriteJSON(w, geo)
+}
@@ -0,0 +1,192 @@
+package adminapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+	"github.com/charleshuang3/firewall/ipgeo/ipgeotest"
+)
+
+func newTestAPI(t *testing.T) (*firewall.Firewall, http.Handler) {
+	geo := ipgeo.StaticProvider{"1.2.3.0/24": {CountryISO: "DE"}}
+	fw, err := firewall.New(nil, nil, firewall.MultiLogger{}, geo, firewall.ForgivableError{Duration: time.Minute, Count: 3, BanInMinute: 5})
+	require.NoError(t, err)
+	t.Cleanup(func() { fw.Close(t.Context()) })
+	return fw, Handler(fw, "secret")
+}
+
+func do(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	_, h := newTestAPI(t)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/bans", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/bans", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// an empty token rejects everything
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/v1/bans", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	Handler(nil, "").ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestNewAuth(t *testing.T) {
+	for _, c := range []struct {
+		operators []Operator
+		header    string
+		proxies   []string
+	}{
+		{},
+		{operators: []Operator{{Name: "alice"}}},
+		{operators: []Operator{{Name: "alice", Token: "a"}}, header: "X-Forwarded-User"},
+		{operators: []Operator{{Name: "alice", Token: "a"}}, header: "X-Forwarded-User", proxies: []string{"nope"}},
+	} {
+		_, err := NewAuth(c.operators, c.header, c.proxies)
+		assert.Error(t, err, "%+v", c)
+	}
+
+	auth, err := NewAuth([]Operator{{Name: "alice", Token: "token-a"}}, "X-Forwarded-User", []string{"10.0.0.1"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/v1/bans", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	_, ok := auth.identify(req)
+	assert.False(t, ok)
+
+	// the identity header is only trusted from the proxies
+	req.Header.Set("Authorization", "Bearer token-a")
+	req.Header.Set("X-Forwarded-User", "mallory")
+	req.RemoteAddr = "192.0.2.1:1234"
+	name, ok := auth.identify(req)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", name)
+	req.RemoteAddr = "10.0.0.1:1234"
+	name, _ = auth.identify(req)
+	assert.Equal(t, "mallory", name)
+}
+
+func TestBans(t *testing.T) {
+	fw, h := newTestAPI(t)
+	fw.BanIP("1.2.3.4", 10, "scanner")
+	require.Eventually(t, func() bool { return len(fw.ListBans()) == 1 }, time.Second, time.Millisecond)
+
+	w := do(h, http.MethodGet, "/v1/bans", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	bans := []firewall.BanInfo{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &bans))
+	assert.Equal(t, "1.2.3.4", bans[0].IP)
+
+	w = do(h, http.MethodGet, "/v1/ips/::ffff:1.2.3.4", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	info := &firewall.IPInfo{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), info))
+	assert.Equal(t, "1.2.3.4", info.IP)
+	assert.Equal(t, []string{"scanner"}, info.Ban.Reasons)
+	assert.Equal(t, "DE", info.Geo.CountryISO)
+
+	assert.Equal(t, http.StatusBadRequest, do(h, http.MethodPost, "/v1/unban", `{"ip":"x"}`).Code)
+	assert.Equal(t, http.StatusOK, do(h, http.MethodPost, "/v1/unban", `{"ip":"1.2.3.4"}`).Code)
+	assert.Empty(t, fw.ListBans())
+
+	// a network ban is unbanned by its cidr
+	require.NoError(t, fw.BanNetwork("1.2.4.0/24", 10, "scanner"))
+	require.Eventually(t, func() bool { return len(fw.ListBans()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, http.StatusOK, do(h, http.MethodPost, "/v1/unban", `{"ip":"1.2.4.9/24"}`).Code)
+	assert.Empty(t, fw.ListBans())
+}
+
+// downBackend fails to unban.
+type downBackend struct{}
+
+func (downBackend) BanIP(ip string, timeoutInMinute int) {}
+
+func (downBackend) UnbanIP(ip string) error {
+	return errors.New("appliance down")
+}
+
+func TestUnban_BackendError(t *testing.T) {
+	fw, err := firewall.New(nil, downBackend{}, firewall.MultiLogger{}, nil, firewall.ForgivableError{Duration: time.Minute, Count: 3, BanInMinute: 5})
+	require.NoError(t, err)
+	defer fw.Close(t.Context())
+	fw.BanIP("1.2.3.4", 10, "scanner")
+	require.Eventually(t, func() bool { return len(fw.ListBans()) == 1 }, time.Second, time.Millisecond)
+
+	// the ip stays banned
+	w := do(Handler(fw, "secret"), http.MethodPost, "/v1/unban", `{"ip":"1.2.3.4"}`)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, w.Body.String(), "appliance down")
+	assert.Len(t, fw.ListBans(), 1)
+}
+
+func TestWhitelist(t *testing.T) {
+	fw, h := newTestAPI(t)
+
+	assert.Equal(t, http.StatusBadRequest, do(h, http.MethodPost, "/v1/whitelist", `{"rule":"10.0.0.1","ttl":"soon"}`).Code)
+	assert.Equal(t, http.StatusOK, do(h, http.MethodPost, "/v1/whitelist", `{"rule":"10.0.0.1","ttl":"8h","reason":"contractor"}`).Code)
+	assert.True(t, fw.InspectIP("10.0.0.1").Whitelisted)
+
+	w := do(h, http.MethodGet, "/v1/whitelist", "")
+	assert.Contains(t, w.Body.String(), "contractor")
+
+	assert.Equal(t, http.StatusOK, do(h, http.MethodDelete, "/v1/whitelist?rule=10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(h, http.MethodDelete, "/v1/whitelist?rule=10.0.0.1", "").Code)
+}
+
+func TestGeo(t *testing.T) {
+	_, h := newTestAPI(t)
+
+	w := do(h, http.MethodGet, "/v1/geo/1.2.3.4", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"country_iso": "DE"`)
+
+	fw, err := firewall.New(nil, nil, firewall.MultiLogger{}, nil, firewall.ForgivableError{Duration: time.Minute, Count: 3, BanInMinute: 5})
+	require.NoError(t, err)
+	defer fw.Close(t.Context())
+	w = do(Handler(fw, "secret"), http.MethodGet, "/v1/geo/1.2.3.4", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGeoMetadata(t *testing.T) {
+	city, asn, err := ipgeotest.Write(t.TempDir(), map[string]*ipgeo.IPGeo{
+		"1.2.3.0/24": {CountryISO: "KP", Country: "North Korea"},
+	})
+	require.NoError(t, err)
+	geo, err := ipgeo.NewAutoUpdateMMIPGeo(city, city, asn, asn)
+	require.NoError(t, err)
+	defer geo.Close()
+	fw, err := firewall.New(nil, nil, firewall.MultiLogger{}, geo, firewall.ForgivableError{Duration: time.Minute, Count: 3, BanInMinute: 5})
+	require.NoError(t, err)
+	defer fw.Close(t.Context())
+
+	w := do(Handler(fw, "secret"), http.MethodGet, "/v1/geo", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"edition": "GeoLite2-City"`)
+	assert.Contains(t, w.Body.String(), `"edition": "GeoLite2-ASN"`)
+
+	// no metadata of a static provider
+	_, h := newTestAPI(t)
+	assert.Equal(t, http.StatusNotFound, do(h, http.MethodGet, "/v1/geo", "").Code)
+}
@@ -0,0 +1,121 @@
+package adminapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type operatorKey struct{}
+
+// Operator calls the api with its token, bans and unbans record its name.
+type Operator struct {
+	Name  string
+	Token string
+}
+
+// Auth identifies the operator of requests by their token.
+type Auth struct {
+	operators []Operator
+	header    string
+	proxies   []netip.Prefix
+}
+
+// NewAuth returns an Auth accepting the tokens of operators, at least one
+// is required. Behind an authenticating proxy, identityHeader names the
+// operator instead when the request comes from one of trustedProxies, ips
+// or cidrs, with the token of an operator.
+func NewAuth(operators []Operator, identityHeader string, trustedProxies []string) (*Auth, error) {
+	if len(operators) == 0 {
+		return nil, errors.New("operators required")
+	}
+	for _, o := range operators {
+		if o.Name == "" || o.Token == "" {
+			return nil, errors.New("operators require name and token")
+		}
+	}
+	a := &Auth{operators: operators, header: identityHeader}
+	if a.header != "" && len(trustedProxies) == 0 {
+		return nil, errors.New("identity header requires trusted proxies")
+	}
+	for _, it := range trustedProxies {
+		p, err := parsePrefix(it)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy: %w", err)
+		}
+		a.proxies = append(a.proxies, p)
+	}
+	return a, nil
+}
+
+// parsePrefix parses an ip or a cidr.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// Wrap puts the operator of requests in their context, see OperatorOf,
+// requests without the token of an operator get 401.
+func (a *Auth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := a.identify(r)
+		if !ok {
+			http.Error(w, "operator token required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), operatorKey{}, name)))
+	})
+}
+
+func (a *Auth) identify(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	for _, o := range a.operators {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(o.Token)) != 1 {
+			continue
+		}
+		if id := r.Header.Get(a.header); a.header != "" && id != "" && a.fromProxy(r) {
+			return id, true
+		}
+		return o.Name, true
+	}
+	return "", false
+}
+
+// fromProxy returns whether r comes from a trusted proxy.
+func (a *Auth) fromProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range a.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// OperatorOf returns the operator of a request served by Wrap.
+func OperatorOf(r *http.Request) string {
+	name, _ := r.Context().Value(operatorKey{}).(string)
+	return name
+}
@@ -0,0 +1,23 @@
+package firewall
+
+// Annotation records who requested a ban or an unban, e.g. an operator on
+// the admin api, to tell human decisions from automated ones. Automated
+// decisions have none.
+type Annotation struct {
+	// Operator is the identity of the operator, e.g. the name of an admin
+	// token or an OIDC subject.
+	Operator string `json:"operator,omitempty"`
+	// Ticket references the change or incident, e.g. "INC-1234".
+	Ticket string `json:"ticket,omitempty"`
+}
+
+// BanIPBy bans ip like BanIP, the ban and its events carry a.
+func (s *Firewall) BanIPBy(ip string, timeoutInMinute int, reason string, a Annotation) {
+	s.banIP(ip, timeoutInMinute, reason, &a, nil)
+}
+
+// UnbanIPBy unbans ip like UnbanIP, the "unban" event carries a. It
+// returns the error of the backend, the ip stays banned then.
+func (s *Firewall) UnbanIPBy(ip string, reason string, a Annotation) error {
+	return s.unbanIP(ip, reason, &a)
+}
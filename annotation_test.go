@@ -0,0 +1,33 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotation(t *testing.T) {
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, &MockIFirewall{}, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 0, BanInMinute: 10})
+	require.NoError(t, err)
+	a := Annotation{Operator: "alice", Ticket: "INC-1"}
+
+	mockLogger.Wg.Add(3)
+	fw.BanIPBy("1.2.3.4", 10, "abuse report", a)
+	fw.LogIPError("1.2.3.5", "bad password")
+	fw.UnbanIPBy("1.2.3.5", "false positive", Annotation{Operator: "bob"})
+	mockLogger.Wg.Wait()
+
+	require.Len(t, mockLogger.Events, 3)
+	assert.Equal(t, &a, mockLogger.Events[0].Annotation)
+	// automated bans have none
+	assert.Nil(t, mockLogger.Events[1].Annotation)
+	assert.Equal(t, "unban", mockLogger.Events[2].Action)
+	assert.Equal(t, "bob", mockLogger.Events[2].Annotation.Operator)
+
+	bans := fw.ListBans()
+	require.Len(t, bans, 1)
+	assert.Equal(t, &a, bans[0].Annotation)
+}
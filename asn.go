@@ -0,0 +1,115 @@
+package firewall
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+const (
+	defaultASNWindow     = time.Hour
+	defaultASNEscalation = 24 * time.Hour
+)
+
+// ASNPolicy escalates an autonomous system whose ips keep getting banned,
+// e.g. a hosting provider renting out attack boxes: the first error of any
+// of its ips bans it. It requires the geo database with the ASN database.
+type ASNPolicy struct {
+	// MaxIPs escalates an AS when more than MaxIPs distinct ips of it are
+	// banned within Window.
+	MaxIPs int
+	// Window defaults to an hour.
+	Window time.Duration
+	// Escalation is how long an AS stays escalated, default 24 hours.
+	Escalation time.Duration
+}
+
+// asnState aggregates the bans of an autonomous system. It is only
+// accessed in the loop goroutine.
+type asnState struct {
+	// banned are when the distinct ips of the AS were last banned.
+	banned         map[string]time.Time
+	escalatedUntil time.Time
+}
+
+func (p *ASNPolicy) window() time.Duration {
+	if p.Window <= 0 {
+		return defaultASNWindow
+	}
+	return p.Window
+}
+
+func (p *ASNPolicy) escalation() time.Duration {
+	if p.Escalation <= 0 {
+		return defaultASNEscalation
+	}
+	return p.Escalation
+}
+
+// recordASNBan counts the ban of ip against its AS, and escalates the AS
+// when it has too many. It logs "asn-escalate".
+func (s *Firewall) recordASNBan(ip string, geo *ipgeo.IPGeo, now time.Time) {
+	if geo == nil || geo.AutonomousSystemNumber == 0 {
+		return
+	}
+
+	asn := geo.AutonomousSystemNumber
+	st, ok := s.asns[asn]
+	if !ok {
+		st = &asnState{banned: map[string]time.Time{}}
+		s.asns[asn] = st
+	}
+	st.banned[ip] = now
+	st.prune(now.Add(-s.asnPolicy.window()))
+
+	if st.escalatedUntil.After(now) || len(st.banned) <= s.asnPolicy.MaxIPs {
+		return
+	}
+
+	st.escalatedUntil = now.Add(s.asnPolicy.escalation())
+	s.log(&Event{
+		IP:        ip,
+		JailUntil: st.escalatedUntil,
+		Reasons: []string{fmt.Sprintf("%d ips of AS%d %s banned within %s", len(st.banned), asn,
+			geo.AutonomousSystemOrganization, s.asnPolicy.window())},
+		Action: "asn-escalate",
+		Geo:    geo,
+	})
+}
+
+// asnEscalation returns the ban reason of an error of ip if its AS is
+// escalated, empty if not.
+func (s *Firewall) asnEscalation(ip string, ec *errorCounter) string {
+	geo := s.errorGeo(ip, ec)
+	if geo == nil {
+		return ""
+	}
+
+	asn := geo.AutonomousSystemNumber
+	if st, ok := s.asns[asn]; !ok || !st.escalatedUntil.After(s.now()) {
+		return ""
+	}
+	return fmt.Sprintf("AS%d escalated", asn)
+}
+
+// expireASNs drops the state of the autonomous systems without recent bans
+// nor escalation.
+func (s *Firewall) expireASNs(now time.Time) {
+	cutoff := now.Add(-s.asnPolicy.window())
+	for asn, st := range s.asns {
+		st.prune(cutoff)
+		if len(st.banned) == 0 && !st.escalatedUntil.After(now) {
+			delete(s.asns, asn)
+		}
+	}
+}
+
+// prune removes the bans before cutoff.
+func (st *asnState) prune(cutoff time.Time) {
+	for ip, at := range st.banned {
+		if at.Before(cutoff) {
+			delete(st.banned, ip)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+func TestASNPolicy(t *testing.T) {
+	db, err := ipgeo.NewAutoUpdateMMIPGeo("ipgeo/test-data/GeoLite2-City-Test.mmdb", "ipgeo/test-data/GeoLite2-City-Test.mmdb",
+		"ipgeo/test-data/GeoLite2-ASN-Test.mmdb", "ipgeo/test-data/GeoLite2-ASN-Test.mmdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{}, mockFW, mockLogger, db, forgivable, WithASNPolicy(&ASNPolicy{MaxIPs: 2}))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.BanIP("1.0.0.1", 10, "r")
+	fw.BanIP("1.0.0.2", 10, "r")
+	fw.BanIP("1.0.0.1", 10, "r")
+	mockLogger.Wg.Wait()
+
+	// an ip of the escalated AS is not banned yet
+	mockLogger.Wg.Add(1)
+	fw.LogIPError("1.0.0.9", "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "count error", mockLogger.Events[3].Action)
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.0.0.3", 10, "r")
+	mockLogger.Wg.Wait()
+
+	e := mockLogger.Events[5]
+	assert.Equal(t, "asn-escalate", e.Action)
+	assert.Equal(t, "1.0.0.3", e.IP)
+	assert.Equal(t, []string{"3 ips of AS15169 Google Inc. banned within 1h0m0s"}, e.Reasons)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), e.JailUntil, time.Second)
+
+	// the first error of another ip of the AS bans it
+	mockLogger.Wg.Add(1)
+	fw.LogIPError("1.0.0.10", "r")
+	mockLogger.Wg.Wait()
+	e = mockLogger.Events[6]
+	assert.Equal(t, "ban", e.Action)
+	assert.Equal(t, []string{"r", "AS15169 escalated"}, e.Reasons)
+
+	// state without bans in the window is dropped once not escalated
+	fw.do(func() {
+		fw.expireASNs(time.Now().Add(2 * time.Hour))
+		assert.Len(t, fw.asns, 1)
+		fw.expireASNs(time.Now().Add(25 * time.Hour))
+		assert.Empty(t, fw.asns)
+	})
+}
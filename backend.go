@@ -0,0 +1,101 @@
+package firewall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// BackendBan is an ip in the block list of a firewall backend. ExpireAt is
+// zero if the backend does not expire it.
+type BackendBan struct {
+	IP       string
+	ExpireAt time.Time
+}
+
+// IErrorFirewall is implemented by backends which can report whether a ban
+// reached the device. Failures are logged as "backend-error" events.
+type IErrorFirewall interface {
+	IFirewall
+	// Name of the backend, used in log events.
+	Name() string
+	TryBanIP(ip string, timeoutInMinute int) error
+}
+
+// IExpiringFirewall is implemented by backends removing bans by themselves
+// when they expire, e.g. a routeros address list timeout. Other backends
+// implementing IUnbanFirewall are asked to unban an ip when its jail
+// expires.
+type IExpiringFirewall interface {
+	ExpiresBans() bool
+}
+
+// INetworkFirewall is implemented by backends which accept a cidr, e.g.
+// "1.2.3.0/24", as the ip of BanIP and UnbanIP, see Firewall.BanNetwork.
+type INetworkFirewall interface {
+	BansNetworks() bool
+}
+
+// bansNetworks returns whether fw accepts cidr bans.
+func bansNetworks(fw IFirewall) bool {
+	n, ok := fw.(INetworkFirewall)
+	return ok && n.BansNetworks()
+}
+
+// StatusError is returned by http based backends when the device responds
+// with an unexpected status code.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("code = %d, resp = %q", e.Code, e.Body)
+}
+
+// BackendError describes a failed call to a firewall backend.
+type BackendError struct {
+	Backend string
+	Op      string
+	Attempt int
+	Err     error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("%s %s failed (attempt %d, %s): %v", e.Backend, e.Op, e.Attempt, e.Class(), e.Err)
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// Class returns a coarse category of the error: "timeout", "network",
+// "auth", "http", "decode" or "other".
+func (e *BackendError) Class() string {
+	var netErr net.Error
+	if errors.As(e.Err, &netErr) {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+
+	var statusErr *StatusError
+	if errors.As(e.Err, &statusErr) {
+		if statusErr.Code == http.StatusUnauthorized || statusErr.Code == http.StatusForbidden {
+			return "auth"
+		}
+		return "http"
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(e.Err, &syntaxErr) || errors.As(e.Err, &typeErr) {
+		return "decode"
+	}
+
+	return "other"
+}
@@ -0,0 +1,58 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "timeout",
+			err:  fmt.Errorf("get alias failed: %w", &net.OpError{Op: "dial", Err: context.DeadlineExceeded}),
+			want: "timeout",
+		},
+		{
+			name: "network",
+			err:  fmt.Errorf("get alias failed: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}),
+			want: "network",
+		},
+		{
+			name: "auth",
+			err:  fmt.Errorf("get alias failed: %w", &StatusError{Code: 403}),
+			want: "auth",
+		},
+		{
+			name: "http",
+			err:  fmt.Errorf("get alias failed: %w", &StatusError{Code: 500}),
+			want: "http",
+		},
+		{
+			name: "decode",
+			err:  fmt.Errorf("unmarshal get alias response failed: %w", &json.SyntaxError{}),
+			want: "decode",
+		},
+		{
+			name: "other",
+			err:  errors.New("no 'block_list' alias in pfsense"),
+			want: "other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &BackendError{Backend: "mock", Op: "ban", Attempt: 1, Err: tt.err}
+			assert.Equal(t, tt.want, e.Class())
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package firewall
+
+// blacklisted returns the ban reason of an error of ip if it matches a
+// blacklist rule, empty if not.
+func (s *Firewall) blacklisted(ip string) string {
+	match := keyMatcher(ip)
+	if match == nil {
+		return ""
+	}
+	for _, m := range s.blackList {
+		if match(m) {
+			return "blacklisted"
+		}
+	}
+	return ""
+}
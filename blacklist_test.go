@@ -0,0 +1,33 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlacklist(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{"5.6.7.8"}, mockFW, mockLogger, nil, forgivable, WithBlacklist("1.2.3.4", "5.6.7.0/24"))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("1.2.3.5", "r")
+	fw.LogIPError("1.2.3.4", "r")
+	fw.LogIPError("5.6.7.8", "r")
+	fw.LogIPError("5.6.7.9", "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, "count error", mockLogger.Events[0].Action)
+	e := mockLogger.Events[1]
+	assert.Equal(t, "ban", e.Action)
+	assert.Equal(t, "1.2.3.4", e.IP)
+	assert.Equal(t, []string{"r", "blacklisted"}, e.Reasons)
+	// whitelist wins over blacklist
+	assert.Equal(t, "5.6.7.9", mockLogger.Events[2].IP)
+	assert.Equal(t, []string{"1.2.3.4", "5.6.7.9"}, mockFW.BannedIPs)
+}
@@ -0,0 +1,111 @@
+// Package blocklist imports public blocklists, e.g. Spamhaus DROP, FireHOL
+// or AbuseIPDB, into the firewall. Lists are fetched periodically and
+// diffed with the previous fetch, so only new entries are banned and
+// removed ones unbanned; entries still listed are banned again before
+// their ban expires.
+package blocklist
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Known lists by name, used when a list has no URL.
+var Known = map[string]string{
+	"spamhaus-drop":   "https://www.spamhaus.org/drop/drop.txt",
+	"spamhaus-dropv6": "https://www.spamhaus.org/drop/dropv6.txt",
+	"firehol-level1":  "https://iplists.firehol.org/files/firehol_level1.netset",
+	"abuseipdb":       "https://api.abuseipdb.com/api/v2/blacklist?plaintext",
+}
+
+// maxListSize bounds the body of a list.
+const maxListSize = 32 << 20
+
+// Parse reads a list of one ip or cidr per line, the format of the DROP,
+// FireHOL netset and AbuseIPDB plaintext lists. Text after "#" or ";" is a
+// comment, anything else than an ip or cidr is skipped. Cidrs are in their
+// canonical form, the ones of a single address are ips.
+func Parse(r io.Reader) ([]string, error) {
+	res := []string{}
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line, _, _ = strings.Cut(line, ";")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		entry, ok := canonical(fields[0])
+		if !ok || seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		res = append(res, entry)
+	}
+	return res, sc.Err()
+}
+
+// canonical returns the ip or cidr s in canonical form.
+func canonical(s string) (string, bool) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return "", false
+		}
+		return addr.Unmap().String(), true
+	}
+
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return "", false
+	}
+	p = p.Masked()
+	if p.IsSingleIP() {
+		return p.Addr().Unmap().String(), true
+	}
+	return p.String(), true
+}
+
+// fetch downloads the list at url. The key, if set, is sent as the "Key"
+// header, as AbuseIPDB expects. It returns nil entries and no error if the
+// list is not modified since etag.
+func fetch(ctx context.Context, url, key, etag string) (entries []string, newETag string, err error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("new request failed: %w", err)
+	}
+	if key != "" {
+		r.Header.Set("Key", key)
+	}
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch list failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, etag, nil
+	default:
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, "", fmt.Errorf("fetch list failed: %s: %q", resp.Status, b)
+	}
+
+	entries, err = Parse(io.LimitReader(resp.Body, maxListSize))
+	if err != nil {
+		return nil, "", fmt.Errorf("read list failed: %w", err)
+	}
+	return entries, resp.Header.Get("ETag"), nil
+}
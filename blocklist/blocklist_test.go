@@ -0,0 +1,129 @@
+package blocklist
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	entries, err := Parse(strings.NewReader(`; Spamhaus DROP List
+1.10.16.0/20 ; SBL256894
+# FireHOL
+2.56.192.0/22
+10.0.0.7
+10.0.0.7
+10.0.1.5/24
+192.0.2.1/32
+::ffff:198.51.100.1
+2001:db8::/32 ; SBL1
+not an ip
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.10.16.0/20", "2.56.192.0/22", "10.0.0.7", "10.0.1.0/24", "192.0.2.1", "198.51.100.1", "2001:db8::/32"}, entries)
+}
+
+type mockTarget struct {
+	mu       sync.Mutex
+	banned   []string
+	unbanned []string
+	reasons  []string
+	// networkErr fails BanNetwork if set.
+	networkErr error
+}
+
+func (m *mockTarget) BanIP(ip string, timeoutInMinute int, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.banned = append(m.banned, ip)
+	m.reasons = append(m.reasons, reason)
+}
+
+func (m *mockTarget) BanNetwork(cidr string, timeoutInMinute int, reason string) error {
+	if m.networkErr != nil {
+		return m.networkErr
+	}
+	m.BanIP(cidr, timeoutInMinute, reason)
+	return nil
+}
+
+func (m *mockTarget) UnbanIP(ip string, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unbanned = append(m.unbanned, ip)
+}
+
+func TestImporter(t *testing.T) {
+	var mu sync.Mutex
+	list := "10.0.0.1\n10.1.0.0/16\n"
+	etag := `"1"`
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get("Key"))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(list))
+	}))
+	defer srv.Close()
+
+	target := &mockTarget{}
+	p := NewImporter("drop", srv.URL, "secret", target, 60, time.Hour)
+	require.NoError(t, p.Pull(t.Context()))
+	assert.Equal(t, []string{"10.0.0.1", "10.1.0.0/16"}, target.banned)
+	assert.Equal(t, "blocklist: drop", target.reasons[0])
+
+	// not modified, nothing pushed
+	require.NoError(t, p.Pull(t.Context()))
+	assert.Len(t, target.banned, 2)
+	assert.Equal(t, []string{"secret", "secret"}, keys)
+
+	// only the changes are pushed
+	mu.Lock()
+	list, etag = "10.0.0.1\n10.0.0.2\n", `"2"`
+	mu.Unlock()
+	require.NoError(t, p.Pull(t.Context()))
+	assert.Equal(t, []string{"10.0.0.1", "10.1.0.0/16", "10.0.0.2"}, target.banned)
+	assert.Equal(t, []string{"10.1.0.0/16"}, target.unbanned)
+
+	// bans with less than half left are refreshed, even if not modified
+	p.banned["10.0.0.1"] = time.Now().Add(10 * time.Minute)
+	require.NoError(t, p.Pull(t.Context()))
+	assert.Equal(t, []string{"10.0.0.1", "10.1.0.0/16", "10.0.0.2", "10.0.0.1"}, target.banned)
+
+	// an empty list is ignored
+	mu.Lock()
+	list, etag = "", `"3"`
+	mu.Unlock()
+	assert.Error(t, p.Pull(t.Context()))
+	assert.Len(t, target.unbanned, 1)
+}
+
+func TestImporter_NetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("10.1.0.0/16\n10.2.0.0/16\n10.0.0.1\n"))
+	}))
+	defer srv.Close()
+
+	target := &mockTarget{networkErr: errors.New("no networks")}
+	p := NewImporter("drop", srv.URL, "", target, 60, time.Hour)
+	err := p.Pull(t.Context())
+	assert.ErrorContains(t, err, "2 entries not banned, first: ban 10.1.0.0/16 failed: no networks")
+	assert.Equal(t, []string{"10.0.0.1"}, target.banned)
+
+	// failed entries are retried
+	target.networkErr = nil
+	require.NoError(t, p.Pull(t.Context()))
+	assert.Equal(t, []string{"10.0.0.1", "10.1.0.0/16", "10.2.0.0/16"}, target.banned)
+}
@@ -0,0 +1,148 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Target is where entries are banned, a *firewall.Firewall.
+type Target interface {
+	BanIP(ip string, timeoutInMinute int, reason string)
+	BanNetwork(cidr string, timeoutInMinute int, reason string) error
+	UnbanIP(ip string, reason string)
+}
+
+// Importer keeps the entries of a list banned for timeoutInMinute, a long
+// ban refreshed while the entry is listed.
+type Importer struct {
+	name            string
+	url             string
+	key             string
+	target          Target
+	timeoutInMinute int
+	interval        time.Duration
+
+	mu   sync.Mutex
+	etag string
+	// banned entries to ban expire time
+	banned map[string]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewImporter fetches the list name at url every interval, key is the api
+// key of lists requiring one.
+func NewImporter(name, url, key string, target Target, timeoutInMinute int, interval time.Duration) *Importer {
+	return &Importer{
+		name:            name,
+		url:             url,
+		key:             key,
+		target:          target,
+		timeoutInMinute: timeoutInMinute,
+		interval:        interval,
+		banned:          map[string]time.Time{},
+	}
+}
+
+// Name returns the name of the list.
+func (p *Importer) Name() string {
+	return p.name
+}
+
+// Start pulls in background until Close.
+func (p *Importer) Start() {
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			if err := p.Pull(context.Background()); err != nil {
+				log.Printf("pull blocklist %s failed: %v", p.name, err)
+			}
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *Importer) Close() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+}
+
+// Pull fetches the list and pushes the changes since the previous pull: new
+// entries are banned, removed ones unbanned and the ones with less than
+// half of their ban left banned again. A list fetched empty is ignored, a
+// broken mirror would unban everything otherwise.
+func (p *Importer) Pull(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries, etag, err := fetch(ctx, p.url, p.key, p.etag)
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		// not modified, only refresh the bans
+		for e := range p.banned {
+			entries = append(entries, e)
+		}
+	} else if len(entries) == 0 {
+		return errors.New("list is empty, ignored")
+	}
+
+	reason := "blocklist: " + p.name
+	ttl := time.Duration(p.timeoutInMinute) * time.Minute
+	now := time.Now()
+	listed := map[string]bool{}
+	failed := 0
+	var firstErr error
+	for _, e := range entries {
+		listed[e] = true
+		if until, ok := p.banned[e]; ok && until.Sub(now) > ttl/2 {
+			continue
+		}
+
+		if strings.Contains(e, "/") {
+			if err := p.target.BanNetwork(e, p.timeoutInMinute, reason); err != nil {
+				if failed == 0 {
+					firstErr = fmt.Errorf("ban %s failed: %w", e, err)
+				}
+				failed++
+				continue
+			}
+		} else {
+			p.target.BanIP(e, p.timeoutInMinute, reason)
+		}
+		p.banned[e] = now.Add(ttl)
+	}
+
+	for e := range p.banned {
+		if listed[e] {
+			continue
+		}
+		p.target.UnbanIP(e, reason+" delisted")
+		delete(p.banned, e)
+	}
+	p.etag = etag
+
+	if failed > 0 {
+		// likely the same error for all, e.g. a backend not banning networks
+		return fmt.Errorf("%d entries not banned, first: %w", failed, firstErr)
+	}
+	return nil
+}
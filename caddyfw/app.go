@@ -0,0 +1,107 @@
+// Package caddyfw runs the firewall in Caddy. The "firewall" app runs the
+// firewall of a firewalld config, the "firewall" http handler rejects the
+// requests of banned clients and reports their failed requests as errors.
+//
+// Build Caddy with it:
+//
+//	xcaddy build --with github.com/charleshuang3/firewall/caddyfw
+package caddyfw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/daemon"
+	"github.com/charleshuang3/firewall/secrets"
+)
+
+var (
+	_ caddy.App          = (*App)(nil)
+	_ caddy.Provisioner  = (*App)(nil)
+	_ caddy.CleanerUpper = (*App)(nil)
+)
+
+func init() {
+	caddy.RegisterModule(App{})
+	caddy.RegisterModule(Handler{})
+}
+
+// App is the "firewall" Caddy app. Its config is a firewalld config, inline
+// or loaded from ConfigFile, whose daemon section sets the backends,
+// loggers and thresholds. The daemon listeners are optional, leave
+// "listen" empty to serve through Caddy only.
+type App struct {
+	Config     *config.Config `json:"config,omitempty"`
+	ConfigFile string         `json:"config_file,omitempty"`
+
+	d       *daemon.Daemon
+	started bool
+	cancel  context.CancelFunc
+	done    chan error
+}
+
+func (App) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "firewall",
+		New: func() caddy.Module { return new(App) },
+	}
+}
+
+// Provision creates the firewall of the config.
+func (a *App) Provision(ctx caddy.Context) error {
+	c := a.Config
+	if a.ConfigFile != "" {
+		if c != nil {
+			return errors.New("config and config_file both configured, configure one")
+		}
+		var err error
+		c, err = config.Load(a.ConfigFile)
+		if err != nil {
+			return err
+		}
+	}
+	if c == nil {
+		return errors.New("no firewall config")
+	}
+	if err := secrets.ResolveConfig(ctx, c); err != nil {
+		return fmt.Errorf("resolve secrets failed: %w", err)
+	}
+
+	d, err := daemon.New(c)
+	if err != nil {
+		return err
+	}
+	a.d = d
+	return nil
+}
+
+// Start runs the daemon, e.g. its WAL replay and peer feeds.
+func (a *App) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	a.done = make(chan error, 1)
+	a.started = true
+	go func() {
+		a.done <- a.d.Run(ctx)
+	}()
+	return nil
+}
+
+// Stop closes the firewall, queued events are handled first.
+func (a *App) Stop() error {
+	a.cancel()
+	return <-a.done
+}
+
+// Cleanup releases a firewall provisioned but never started, e.g. when
+// Caddy validates the config.
+func (a *App) Cleanup() error {
+	if a.d != nil && !a.started {
+		a.d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package caddyfw
+
+import (
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+var _ caddyfile.Unmarshaler = (*Handler)(nil)
+
+func init() {
+	httpcaddyfile.RegisterGlobalOption("firewall", parseApp)
+	httpcaddyfile.RegisterHandlerDirective("firewall", parseHandler)
+	// it wraps authentication to see its failures.
+	httpcaddyfile.RegisterDirectiveOrder("firewall", httpcaddyfile.Before, "basic_auth")
+}
+
+// parseApp parses the "firewall" global option:
+//
+//	firewall <config_file>
+func parseApp(d *caddyfile.Dispenser, _ any) (any, error) {
+	d.Next() // consume option name
+	app := &App{}
+	if !d.AllArgs(&app.ConfigFile) {
+		return nil, d.ArgErr()
+	}
+	return httpcaddyfile.App{
+		Name:  "firewall",
+		Value: caddyconfig.JSON(app, nil),
+	}, nil
+}
+
+func parseHandler(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	m := &Handler{}
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	return m, err
+}
+
+// UnmarshalCaddyfile parses the "firewall" directive:
+//
+//	firewall {
+//		statuses <status...>
+//		reason <reason>
+//	}
+func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "statuses":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			for _, a := range args {
+				status, err := strconv.Atoi(a)
+				if err != nil {
+					return d.Errf("invalid status %q: %v", a, err)
+				}
+				h.Statuses = append(h.Statuses, status)
+			}
+		case "reason":
+			if !d.AllArgs(&h.Reason) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown subdirective %q", d.Val())
+		}
+	}
+	return nil
+}
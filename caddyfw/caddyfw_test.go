@@ -0,0 +1,93 @@
+package caddyfw
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+type mockFirewall struct {
+	mu     sync.Mutex
+	banned []string
+}
+
+func (m *mockFirewall) BanIP(ip string, timeoutInMinute int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.banned = append(m.banned, ip)
+}
+
+type mockLogger struct {
+	wg sync.WaitGroup
+}
+
+func (m *mockLogger) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	m.wg.Done()
+}
+
+func TestHandler(t *testing.T) {
+	logger := &mockLogger{}
+	forgivable := firewall.ForgivableError{Duration: time.Minute, Count: 1, BanInMinute: 10}
+	fw, err := firewall.New(nil, &mockFirewall{}, logger, nil, forgivable)
+	require.NoError(t, err)
+	h := &Handler{
+		Statuses: defaultStatuses,
+		fw:       fw,
+	}
+
+	serve := func(ip string, status int) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		ctx := context.WithValue(r.Context(), caddyhttp.VarsCtxKey, map[string]any{caddyhttp.ClientIPVarKey: ip})
+		w := httptest.NewRecorder()
+		err := h.ServeHTTP(w, r.WithContext(ctx), caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(status)
+			return nil
+		}))
+		if he := (caddyhttp.HandlerError{}); err != nil {
+			require.ErrorAs(t, err, &he)
+			return he.StatusCode
+		}
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("1.2.3.4", http.StatusOK))
+
+	logger.wg.Add(2)
+	assert.Equal(t, http.StatusUnauthorized, serve("1.2.3.4", http.StatusUnauthorized))
+	assert.Equal(t, http.StatusUnauthorized, serve("1.2.3.4", http.StatusUnauthorized))
+	logger.wg.Wait()
+
+	assert.Equal(t, http.StatusForbidden, serve("1.2.3.4", http.StatusOK))
+	assert.Equal(t, http.StatusOK, serve("1.2.3.5", http.StatusOK))
+}
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	h := &Handler{}
+	d := caddyfile.NewTestDispenser(`firewall {
+		statuses 401 404
+		reason "bad request"
+	}`)
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, []int{401, 404}, h.Statuses)
+	assert.Equal(t, "bad request", h.Reason)
+
+	assert.Error(t, (&Handler{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser(`firewall {
+		statuses x
+	}`)))
+
+	v, err := parseApp(caddyfile.NewTestDispenser(`firewall /etc/firewalld.json`), nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"config_file": "/etc/firewalld.json"}`, string(v.(httpcaddyfile.App).Value))
+}
@@ -0,0 +1,93 @@
+package caddyfw
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+
+	"github.com/charleshuang3/firewall"
+)
+
+var (
+	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
+	_ caddy.Provisioner           = (*Handler)(nil)
+)
+
+// defaultStatuses are the response statuses counted as errors by default.
+var defaultStatuses = []int{http.StatusUnauthorized, http.StatusForbidden}
+
+// Handler is the "http.handlers.firewall" middleware. It rejects the
+// requests of banned clients with 403, and counts the responses of the
+// other clients with a status of Statuses as errors of their ip. The
+// client ip is the one Caddy resolves with its trusted proxies.
+type Handler struct {
+	// Statuses counted as errors, default 401 and 403.
+	Statuses []int `json:"statuses,omitempty"`
+	// Reason of the errors, default "http <status>".
+	Reason string `json:"reason,omitempty"`
+
+	fw *firewall.Firewall
+}
+
+func (Handler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.firewall",
+		New: func() caddy.Module { return new(Handler) },
+	}
+}
+
+// Provision gets the firewall of the "firewall" app.
+func (h *Handler) Provision(ctx caddy.Context) error {
+	app, err := ctx.App("firewall")
+	if err != nil {
+		return fmt.Errorf("load firewall app failed: %w", err)
+	}
+	h.fw = app.(*App).d.Firewall()
+	if len(h.Statuses) == 0 {
+		h.Statuses = defaultStatuses
+	}
+	return nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	ip, _ := caddyhttp.GetVar(r.Context(), caddyhttp.ClientIPVarKey).(string)
+	if ip == "" {
+		return next.ServeHTTP(w, r)
+	}
+	if banned, _ := h.fw.IsBanned(ip); banned {
+		return caddyhttp.Error(http.StatusForbidden, fmt.Errorf("%s is banned", ip))
+	}
+
+	sw := &statusWriter{ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w}}
+	err := next.ServeHTTP(sw, r)
+
+	status := sw.status
+	if he := (caddyhttp.HandlerError{}); errors.As(err, &he) {
+		status = he.StatusCode
+	}
+	if slices.Contains(h.Statuses, status) {
+		reason := h.Reason
+		if reason == "" {
+			reason = fmt.Sprintf("http %d", status)
+		}
+		h.fw.LogIPError(ip, reason)
+	}
+	return err
+}
+
+// statusWriter records the status of the response.
+type statusWriter struct {
+	*caddyhttp.ResponseWriterWrapper
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= http.StatusOK {
+		w.status = code
+	}
+	w.ResponseWriterWrapper.WriteHeader(code)
+}
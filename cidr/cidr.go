@@ -0,0 +1,213 @@
+// Package cidr aggregates ips and networks into the fewest prefixes
+// covering exactly the same addresses, or a few more with AggregateSlack
+// and Limit, e.g. for upstream ACLs and backends with a limit on the
+// number of entries.
+package cidr
+
+import (
+	"math"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// Parse parses an ip, "1.2.3.4", or a cidr, "1.2.3.0/24", as a prefix. A
+// mapped ipv4 is unmapped and the host bits of a cidr are cleared.
+func Parse(s string) (netip.Prefix, bool) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		return normalize(p), true
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	a = a.Unmap().WithZone("")
+	return netip.PrefixFrom(a, a.BitLen()), true
+}
+
+func normalize(p netip.Prefix) netip.Prefix {
+	a := p.Addr()
+	bits := p.Bits()
+	if a.Is4In6() {
+		a = a.Unmap()
+		bits = max(bits-96, 0)
+	}
+	return netip.PrefixFrom(a.WithZone(""), bits).Masked()
+}
+
+// Aggregate returns the fewest prefixes covering exactly the addresses of
+// prefixes, sorted with ipv4 first. Prefixes covered by another one are
+// dropped, and sibling prefixes, e.g. 10.0.0.0/25 and 10.0.0.128/25, are
+// merged into their parent until no siblings remain.
+func Aggregate(prefixes []netip.Prefix) []netip.Prefix {
+	sorted := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		if p.IsValid() {
+			sorted = append(sorted, normalize(p))
+		}
+	}
+	slices.SortFunc(sorted, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		// the wider prefix first, it covers the others
+		return a.Bits() - b.Bits()
+	})
+
+	res := []netip.Prefix{}
+	for _, p := range sorted {
+		if n := len(res); n > 0 && res[n-1].Overlaps(p) {
+			// sorted by address, the previous prefix covers p
+			continue
+		}
+		res = append(res, p)
+		for len(res) >= 2 {
+			a, b := res[len(res)-2], res[len(res)-1]
+			parent, ok := siblings(a, b)
+			if !ok {
+				break
+			}
+			res = append(res[:len(res)-2], parent)
+		}
+	}
+	return res
+}
+
+// siblings returns the parent of a and b if they are its two halves.
+func siblings(a, b netip.Prefix) (netip.Prefix, bool) {
+	if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().Is4() != b.Addr().Is4() || a == b {
+		return netip.Prefix{}, false
+	}
+	pa := netip.PrefixFrom(a.Addr(), a.Bits()-1).Masked()
+	pb := netip.PrefixFrom(b.Addr(), b.Bits()-1).Masked()
+	return pa, pa == pb
+}
+
+// size returns the number of addresses of p as a float, exact up to 2^53
+// and close enough beyond for ratios.
+func size(p netip.Prefix) float64 {
+	return math.Ldexp(1, p.Addr().BitLen()-p.Bits())
+}
+
+// commonParent returns the longest prefix covering a and b, false if they
+// are of different families.
+func commonParent(a, b netip.Prefix) (netip.Prefix, bool) {
+	if a.Addr().Is4() != b.Addr().Is4() {
+		return netip.Prefix{}, false
+	}
+	for bits := min(a.Bits(), b.Bits()); bits >= 0; bits-- {
+		p := netip.PrefixFrom(a.Addr(), bits).Masked()
+		if p.Contains(b.Addr()) {
+			return p, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// covered is an aggregated prefix and the number of addresses of the input
+// it covers.
+type covered struct {
+	prefix netip.Prefix
+	n      float64
+}
+
+// within returns the range of cs contained in parent around i, and the
+// number of addresses they cover.
+func within(cs []covered, i int, parent netip.Prefix) (int, int, float64) {
+	lo, hi := i, i
+	for lo > 0 && parent.Contains(cs[lo-1].prefix.Addr()) {
+		lo--
+	}
+	for hi+1 < len(cs) && parent.Contains(cs[hi+1].prefix.Addr()) {
+		hi++
+	}
+	n := 0.0
+	for _, c := range cs[lo : hi+1] {
+		n += c.n
+	}
+	return lo, hi, n
+}
+
+// absorb replaces the prefixes of cs contained in parent, from i and
+// around it, with parent.
+func absorb(cs []covered, i int, parent netip.Prefix) []covered {
+	lo, hi, n := within(cs, i, parent)
+	cs[lo] = covered{parent, n}
+	return slices.Delete(cs, lo+1, hi+1)
+}
+
+func coverAll(prefixes []netip.Prefix) []covered {
+	exact := Aggregate(prefixes)
+	cs := make([]covered, len(exact))
+	for i, p := range exact {
+		cs[i] = covered{p, size(p)}
+	}
+	return cs
+}
+
+func prefixesOf(cs []covered) []netip.Prefix {
+	res := make([]netip.Prefix, len(cs))
+	for i, c := range cs {
+		res[i] = c.prefix
+	}
+	return res
+}
+
+// AggregateSlack is Aggregate allowing each prefix to cover addresses not
+// in prefixes, up to the fraction slack of its size, e.g. with 0.25 three
+// addresses of a /30 are aggregated into the /30. A slack of 0 is exact.
+func AggregateSlack(prefixes []netip.Prefix, slack float64) []netip.Prefix {
+	cs := coverAll(prefixes)
+	if slack <= 0 {
+		return prefixesOf(cs)
+	}
+
+	res := []covered{}
+	for _, c := range cs {
+		res = append(res, c)
+		for len(res) >= 2 {
+			parent, ok := commonParent(res[len(res)-2].prefix, res[len(res)-1].prefix)
+			if !ok {
+				break
+			}
+			_, _, n := within(res, len(res)-1, parent)
+			if 1-n/size(parent) > slack {
+				break
+			}
+			res = absorb(res, len(res)-1, parent)
+		}
+	}
+	return prefixesOf(res)
+}
+
+// Limit aggregates prefixes into at most n prefixes, e.g. for a backend
+// with a limit on its entries, covering as few extra addresses as
+// possible: it keeps merging the neighbors whose common parent adds the
+// fewest addresses. ipv4 and ipv6 are never merged, so the result has two
+// prefixes if n is 1 and both families are present.
+func Limit(prefixes []netip.Prefix, n int) []netip.Prefix {
+	cs := coverAll(prefixes)
+	for len(cs) > max(n, 1) {
+		best, cost := -1, math.Inf(1)
+		var bestParent netip.Prefix
+		for i := 1; i < len(cs); i++ {
+			parent, ok := commonParent(cs[i-1].prefix, cs[i].prefix)
+			if !ok {
+				continue
+			}
+			_, _, n := within(cs, i, parent)
+			if c := size(parent) - n; c < cost {
+				best, cost, bestParent = i, c, parent
+			}
+		}
+		if best < 0 {
+			break
+		}
+		cs = absorb(cs, best, bestParent)
+	}
+	return prefixesOf(cs)
+}
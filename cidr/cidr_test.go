@@ -0,0 +1,201 @@
+package cidr
+
+import (
+	"math/rand/v2"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func prefixes(ss ...string) []netip.Prefix {
+	res := []netip.Prefix{}
+	for _, s := range ss {
+		p, ok := Parse(s)
+		if !ok {
+			panic(s)
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"1.2.3.4", "1.2.3.4/32", true},
+		{"::ffff:1.2.3.4", "1.2.3.4/32", true},
+		{"1.2.3.4/24", "1.2.3.0/24", true},
+		{"::ffff:1.2.3.0/120", "1.2.3.0/24", true},
+		{"2001:db8::1", "2001:db8::1/128", true},
+		{"fe80::1%eth0", "fe80::1/128", true},
+		{"1.2.3", "", false},
+		{"1.2.3.0/33", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := Parse(tt.in)
+		assert.Equal(t, tt.ok, ok, tt.in)
+		if ok {
+			assert.Equal(t, tt.want, got.String(), tt.in)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"empty", nil, []string{}},
+		{"single", []string{"10.0.0.1"}, []string{"10.0.0.1/32"}},
+		{"siblings", []string{"10.0.0.1", "10.0.0.0"}, []string{"10.0.0.0/31"}},
+		{"not siblings", []string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.1/32", "10.0.0.2/32"}},
+		{"cascade", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}, []string{"10.0.0.0/30"}},
+		{"covered", []string{"10.0.0.7", "10.0.0.0/24", "10.0.0.0/25", "10.0.0.0/24"}, []string{"10.0.0.0/24"}},
+		{"halves", []string{"10.0.0.128/25", "10.0.0.0/25"}, []string{"10.0.0.0/24"}},
+		{"mixed sizes", []string{"10.0.0.0/25", "10.0.0.128/26", "10.0.0.192/26"}, []string{"10.0.0.0/24"}},
+		{"v4 first", []string{"2001:db8::1", "10.0.0.1"}, []string{"10.0.0.1/32", "2001:db8::1/128"}},
+		{"v6", []string{"2001:db8::", "2001:db8::1", "2001:db8::2/127"}, []string{"2001:db8::/126"}},
+		{"v6 halves", []string{"2001:db8::/33", "2001:db8:8000::/33"}, []string{"2001:db8::/32"}},
+		{"mapped", []string{"::ffff:10.0.0.0", "10.0.0.1"}, []string{"10.0.0.0/31"}},
+		{"all v4", []string{"0.0.0.0/1", "128.0.0.0/1"}, []string{"0.0.0.0/0"}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, p := range Aggregate(prefixes(tt.in...)) {
+			got = append(got, p.String())
+		}
+		assert.Equal(t, tt.want, got, tt.name)
+	}
+}
+
+// TestAggregate_Exact checks the aggregate covers exactly the addresses of
+// random sets of a small network, and that no two prefixes can merge.
+func TestAggregate_Exact(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, base := range []string{"10.0.0.0", "2001:db8::"} {
+		start := netip.MustParseAddr(base)
+		for range 200 {
+			in := map[netip.Addr]bool{}
+			ps := []netip.Prefix{}
+			for range r.IntN(64) {
+				a := start
+				for range r.IntN(256) {
+					a = a.Next()
+				}
+				in[a] = true
+				ps = append(ps, netip.PrefixFrom(a, a.BitLen()))
+			}
+
+			got := Aggregate(ps)
+			a := start
+			for range 256 {
+				covered := 0
+				for _, p := range got {
+					if p.Contains(a) {
+						covered++
+					}
+				}
+				if in[a] {
+					assert.Equal(t, 1, covered, a)
+				} else {
+					assert.Zero(t, covered, a)
+				}
+				a = a.Next()
+			}
+			for i := 1; i < len(got); i++ {
+				_, ok := siblings(got[i-1], got[i])
+				assert.False(t, ok, got)
+			}
+		}
+	}
+}
+
+func strs(ps []netip.Prefix) []string {
+	res := []string{}
+	for _, p := range ps {
+		res = append(res, p.String())
+	}
+	return res
+}
+
+func TestAggregateSlack(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    []string
+		slack float64
+		want  []string
+	}{
+		{"exact", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2"}, 0, []string{"10.0.0.0/31", "10.0.0.2/32"}},
+		{"quarter", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2"}, 0.25, []string{"10.0.0.0/30"}},
+		{"too sparse", []string{"10.0.0.0", "10.0.0.3"}, 0.25, []string{"10.0.0.0/32", "10.0.0.3/32"}},
+		{"half", []string{"10.0.0.0", "10.0.0.3"}, 0.5, []string{"10.0.0.0/30"}},
+		{"cascade", []string{"10.0.0.0/26", "10.0.0.64/27", "10.0.0.128/25"}, 0.125, []string{"10.0.0.0/24"}},
+		{"distant", []string{"10.0.0.0", "192.168.0.1"}, 0.5, []string{"10.0.0.0/32", "192.168.0.1/32"}},
+		{"families", []string{"0.0.0.0/1", "::/1"}, 0.9, []string{"0.0.0.0/1", "::/1"}},
+		{"v6", []string{"2001:db8::/64", "2001:db8:0:1::/65", "2001:db8:0:3::/64"}, 0.4, []string{"2001:db8::/62"}},
+		{"v6 sparse", []string{"2001:db8::1", "2001:db8::1:0"}, 0.5, []string{"2001:db8::1/128", "2001:db8::1:0/128"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, strs(AggregateSlack(prefixes(tt.in...), tt.slack)), tt.name)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		n    int
+		want []string
+	}{
+		{"under", []string{"10.0.0.1", "10.0.0.9"}, 2, []string{"10.0.0.1/32", "10.0.0.9/32"}},
+		{"closest", []string{"10.0.0.1", "10.0.0.2", "10.0.0.9"}, 2, []string{"10.0.0.0/30", "10.0.0.9/32"}},
+		{"absorbs", []string{"10.0.0.0/25", "10.0.0.128", "10.0.0.200", "10.1.0.0"}, 2, []string{"10.0.0.0/24", "10.1.0.0/32"}},
+		{"one", []string{"10.0.0.1", "10.0.0.9"}, 1, []string{"10.0.0.0/28"}},
+		{"families", []string{"10.0.0.1", "2001:db8::1", "2001:db8::2"}, 1, []string{"10.0.0.1/32", "2001:db8::/126"}},
+		{"v6", []string{"2001:db8::/64", "2001:db8:0:1::/64", "2001:db8:1::"}, 1, []string{"2001:db8::/47"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, strs(Limit(prefixes(tt.in...), tt.n)), tt.name)
+	}
+}
+
+// TestLimit_Covers checks Limit and AggregateSlack cover every input
+// address with disjoint prefixes.
+func TestLimit_Covers(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	for _, base := range []string{"10.0.0.0", "2001:db8::"} {
+		start := netip.MustParseAddr(base)
+		for range 100 {
+			addrs := []netip.Addr{}
+			ps := []netip.Prefix{}
+			for range 1 + r.IntN(64) {
+				a := start
+				for range r.IntN(1024) {
+					a = a.Next()
+				}
+				addrs = append(addrs, a)
+				ps = append(ps, netip.PrefixFrom(a, a.BitLen()))
+			}
+
+			n := 1 + r.IntN(16)
+			limited := Limit(ps, n)
+			assert.LessOrEqual(t, len(limited), n)
+			for _, got := range [][]netip.Prefix{limited, AggregateSlack(ps, r.Float64())} {
+				for _, a := range addrs {
+					covered := 0
+					for _, p := range got {
+						if p.Contains(a) {
+							covered++
+						}
+					}
+					assert.Equal(t, 1, covered, a)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package cluster encodes the messages firewall instances exchange to share
+// their jails, e.g. over Redis, NATS or memberlist. Messages are protobuf,
+// see cluster.proto, so instances of different versions can read each
+// other's messages, and are authenticated with a shared HMAC key, so an
+// untrusted transport can not inject bans.
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Version is the payload encoding written by this package. Messages of a
+// newer version are rejected with ErrUnsupportedVersion.
+const Version = 1
+
+// DefaultMaxAge rejects messages sent longer ago as replays.
+const DefaultMaxAge = 5 * time.Minute
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported message version")
+	ErrUnknownKey         = errors.New("unknown message key")
+	ErrBadMAC             = errors.New("invalid message mac")
+	ErrStale              = errors.New("stale message")
+)
+
+// Event is a change of the jail of the sending instance.
+type Event struct {
+	// Action is "ban" or "unban".
+	Action string
+	// IP is an ip or a cidr.
+	IP string
+	// Until is the end of a ban.
+	Until         time.Time
+	Reasons       []string
+	CorrelationID string
+}
+
+// Message is the events an instance sends at once.
+type Message struct {
+	Node   string
+	SentAt time.Time
+	Events []Event
+}
+
+// Codec signs and verifies messages with shared keys by id. Keys are
+// rotated by adding the new key to every instance first, then switching
+// KeyID, then removing the old key.
+type Codec struct {
+	// KeyID names the key in Keys signing the messages sent.
+	KeyID string
+	Keys  map[string][]byte
+	// MaxAge rejects messages sent longer ago, DefaultMaxAge if zero.
+	MaxAge time.Duration
+}
+
+// Marshal encodes m signed with the key KeyID.
+func (c *Codec) Marshal(m *Message) ([]byte, error) {
+	key, ok := c.Keys[c.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, c.KeyID)
+	}
+
+	signed := signedFields(Version, c.KeyID, marshalMessage(m))
+	b := protowire.AppendTag(signed, 4, protowire.BytesType)
+	return protowire.AppendBytes(b, mac(key, signed)), nil
+}
+
+// Unmarshal verifies and decodes a message, it fails if the key is
+// unknown, the mac does not match, the version is newer than Version or the
+// message is older than MaxAge.
+func (c *Codec) Unmarshal(b []byte) (*Message, error) {
+	env, err := unmarshalEnvelope(b)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := c.Keys[env.keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, env.keyID)
+	}
+	if !hmac.Equal(env.mac, mac(key, signedFields(env.version, env.keyID, env.payload))) {
+		return nil, ErrBadMAC
+	}
+	// checked after the mac, so the version of a forged message is not
+	// trusted
+	if env.version > Version {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, env.version)
+	}
+
+	m, err := unmarshalMessage(env.payload)
+	if err != nil {
+		return nil, err
+	}
+	maxAge := c.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	if time.Since(m.SentAt) > maxAge {
+		return nil, fmt.Errorf("%w sent at %s", ErrStale, m.SentAt.Format(time.RFC3339))
+	}
+	return m, nil
+}
+
+func mac(key, b []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(b)
+	return h.Sum(nil)
+}
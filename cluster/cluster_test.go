@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var keys = map[string][]byte{
+	"k1": []byte("0123456789abcdef0123456789abcdef"),
+	"k2": []byte("fedcba9876543210fedcba9876543210"),
+}
+
+func testMessage() *Message {
+	now := time.Now()
+	return &Message{
+		Node:   "fw-1",
+		SentAt: now,
+		Events: []Event{
+			{Action: "ban", IP: "1.2.3.4", Until: now.Add(time.Hour), Reasons: []string{"ssh", "scanner"}, CorrelationID: "c1"},
+			{Action: "unban", IP: "2001:db8::/48"},
+		},
+	}
+}
+
+func TestCodec(t *testing.T) {
+	c := &Codec{KeyID: "k1", Keys: keys}
+	m := testMessage()
+
+	b, err := c.Marshal(m)
+	require.NoError(t, err)
+	got, err := c.Unmarshal(b)
+	require.NoError(t, err)
+
+	assert.Equal(t, m.Node, got.Node)
+	assert.True(t, m.SentAt.Equal(got.SentAt))
+	require.Len(t, got.Events, 2)
+	assert.Equal(t, "ban", got.Events[0].Action)
+	assert.Equal(t, "1.2.3.4", got.Events[0].IP)
+	assert.True(t, m.Events[0].Until.Equal(got.Events[0].Until))
+	assert.Equal(t, []string{"ssh", "scanner"}, got.Events[0].Reasons)
+	assert.Equal(t, "c1", got.Events[0].CorrelationID)
+	assert.Equal(t, Event{Action: "unban", IP: "2001:db8::/48"}, got.Events[1])
+
+	_, err = (&Codec{KeyID: "k3", Keys: keys}).Marshal(m)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestCodec_Verify(t *testing.T) {
+	c := &Codec{KeyID: "k1", Keys: keys}
+	b, err := c.Marshal(testMessage())
+	require.NoError(t, err)
+
+	// any flipped byte of the payload fails the mac
+	tampered := append([]byte{}, b...)
+	tampered[20] ^= 1
+	_, err = c.Unmarshal(tampered)
+	assert.Error(t, err)
+
+	forged := protowire.AppendTag(signedFields(Version, "k1", marshalMessage(testMessage())), 4, protowire.BytesType)
+	forged = protowire.AppendBytes(forged, mac([]byte("guessed"), forged))
+	_, err = c.Unmarshal(forged)
+	assert.ErrorIs(t, err, ErrBadMAC)
+
+	// a rotated out key
+	_, err = (&Codec{KeyID: "k2", Keys: map[string][]byte{"k2": keys["k2"]}}).Unmarshal(b)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// during a rotation both keys are accepted
+	b2, err := (&Codec{KeyID: "k2", Keys: keys}).Marshal(testMessage())
+	require.NoError(t, err)
+	_, err = c.Unmarshal(b2)
+	assert.NoError(t, err)
+
+	_, err = c.Unmarshal([]byte{0xff})
+	assert.Error(t, err)
+}
+
+func TestCodec_Stale(t *testing.T) {
+	c := &Codec{KeyID: "k1", Keys: keys, MaxAge: time.Minute}
+	m := testMessage()
+	m.SentAt = time.Now().Add(-2 * time.Minute)
+	b, err := c.Marshal(m)
+	require.NoError(t, err)
+
+	_, err = c.Unmarshal(b)
+	assert.ErrorIs(t, err, ErrStale)
+}
+
+// sign encodes an envelope of payload as a newer writer would, with
+// envelope fields unknown to this version.
+func sign(version uint64, payload []byte) []byte {
+	signed := signedFields(version, "k1", payload)
+	b := protowire.AppendTag(signed, 4, protowire.BytesType)
+	b = protowire.AppendBytes(b, mac(keys["k1"], signed))
+	b = protowire.AppendTag(b, 15, protowire.BytesType)
+	return protowire.AppendString(b, "future envelope field")
+}
+
+func TestCodec_ForwardCompatible(t *testing.T) {
+	c := &Codec{KeyID: "k1", Keys: keys}
+
+	// a newer writer adding fields of every wire type
+	event := marshalEvent(&Event{Action: "ban", IP: "1.2.3.4"})
+	event = protowire.AppendTag(event, 6, protowire.VarintType)
+	event = protowire.AppendVarint(event, 42)
+	event = protowire.AppendTag(event, 7, protowire.Fixed64Type)
+	event = protowire.AppendFixed64(event, 42)
+	event = protowire.AppendTag(event, 8, protowire.Fixed32Type)
+	event = protowire.AppendFixed32(event, 42)
+	payload := marshalMessage(&Message{Node: "fw-2", SentAt: time.Now()})
+	payload = protowire.AppendTag(payload, 3, protowire.BytesType)
+	payload = protowire.AppendBytes(payload, event)
+	payload = protowire.AppendTag(payload, 9, protowire.BytesType)
+	payload = protowire.AppendString(payload, "future message field")
+
+	m, err := c.Unmarshal(sign(Version, payload))
+	require.NoError(t, err)
+	assert.Equal(t, "fw-2", m.Node)
+	assert.Equal(t, []Event{{Action: "ban", IP: "1.2.3.4"}}, m.Events)
+
+	// an incompatible version is rejected instead of misread
+	_, err = c.Unmarshal(sign(Version+1, payload))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
@@ -0,0 +1,165 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type envelope struct {
+	version uint64
+	keyID   string
+	payload []byte
+	mac     []byte
+}
+
+// signedFields encodes the envelope fields covered by the mac.
+func signedFields(version uint64, keyID string, payload []byte) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, version)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, keyID)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	return protowire.AppendBytes(b, payload)
+}
+
+func marshalMessage(m *Message) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendString(b, m.Node)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.SentAt.UnixNano()))
+	for i := range m.Events {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalEvent(&m.Events[i]))
+	}
+	return b
+}
+
+func marshalEvent(e *Event) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendString(b, e.Action)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, e.IP)
+	if !e.Until.IsZero() {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.Until.UnixNano()))
+	}
+	for _, r := range e.Reasons {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, r)
+	}
+	if e.CorrelationID != "" {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, e.CorrelationID)
+	}
+	return b
+}
+
+// field is a decoded field, v is the value of varints and bytes the value
+// of length delimited fields.
+type field struct {
+	num   protowire.Number
+	v     uint64
+	bytes []byte
+}
+
+// fields decodes the fields of a message. Unknown fields are returned
+// too, callers skip them; fields of other wire types than varint and bytes
+// are skipped here.
+func fields(b []byte) ([]field, error) {
+	res := []field{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("decode message failed: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		f := field{num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			f.num = 0
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("decode field %d failed: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if f.num != 0 {
+			res = append(res, f)
+		}
+	}
+	return res, nil
+}
+
+func unmarshalEnvelope(b []byte) (*envelope, error) {
+	fs, err := fields(b)
+	if err != nil {
+		return nil, err
+	}
+	env := &envelope{}
+	for _, f := range fs {
+		switch f.num {
+		case 1:
+			env.version = f.v
+		case 2:
+			env.keyID = string(f.bytes)
+		case 3:
+			env.payload = f.bytes
+		case 4:
+			env.mac = f.bytes
+		}
+	}
+	return env, nil
+}
+
+func unmarshalMessage(b []byte) (*Message, error) {
+	fs, err := fields(b)
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{}
+	for _, f := range fs {
+		switch f.num {
+		case 1:
+			m.Node = string(f.bytes)
+		case 2:
+			m.SentAt = time.Unix(0, int64(f.v))
+		case 3:
+			e, err := unmarshalEvent(f.bytes)
+			if err != nil {
+				return nil, err
+			}
+			m.Events = append(m.Events, *e)
+		}
+	}
+	return m, nil
+}
+
+func unmarshalEvent(b []byte) (*Event, error) {
+	fs, err := fields(b)
+	if err != nil {
+		return nil, err
+	}
+	e := &Event{}
+	for _, f := range fs {
+		switch f.num {
+		case 1:
+			e.Action = string(f.bytes)
+		case 2:
+			e.IP = string(f.bytes)
+		case 3:
+			e.Until = time.Unix(0, int64(f.v))
+		case 4:
+			e.Reasons = append(e.Reasons, string(f.bytes))
+		case 5:
+			e.CorrelationID = string(f.bytes)
+		}
+	}
+	return e, nil
+}
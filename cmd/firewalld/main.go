@@ -0,0 +1,37 @@
+// Command firewalld runs the firewall as a standalone daemon.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/charleshuang3/firewall/daemon"
+	"github.com/charleshuang3/firewall/secrets"
+)
+
+var configFile = flag.String("config", "firewalld.json", "json config file")
+
+func main() {
+	flag.Parse()
+
+	c, err := secrets.Load(context.Background(), *configFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	d, err := daemon.New(c)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := d.Run(ctx); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/opn"
+	"github.com/charleshuang3/firewall/pf"
+	"github.com/charleshuang3/firewall/ros"
+)
+
+// backend is the operations every firewall backend supports.
+type backend interface {
+	firewall.IFirewallV2
+	firewall.IUnbanFirewallV2
+	ListBans() ([]firewall.BackendBan, error)
+	EnsureAlias() error
+	Ping() error
+}
+
+// connFlags are the flags shared by all backends, they override the config
+// file.
+type connFlags struct {
+	address string
+	user    string
+	pass    string
+}
+
+func (f *connFlags) register(fs *pflag.FlagSet) {
+	fs.StringVar(&f.address, "address", "", "backend address")
+	fs.StringVar(&f.user, "user", "", "backend user")
+	fs.StringVar(&f.pass, "pass", "", "backend password")
+}
+
+func (f *connFlags) apply(fs *pflag.FlagSet, address, user, pass *string) {
+	if fs.Changed("address") {
+		*address = f.address
+	}
+	if fs.Changed("user") {
+		*user = f.user
+	}
+	if fs.Changed("pass") {
+		*pass = f.pass
+	}
+}
+
+func opnCmd() *cobra.Command {
+	f := &connFlags{}
+	var list string
+
+	cmd := newBackendCmd("opn", "OPNsense alias backend", func(cmd *cobra.Command) (backend, error) {
+		c, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		o := c.OPN
+		if o == nil {
+			o = &config.OPN{}
+		}
+		f.apply(cmd.Flags(), &o.Address, &o.User, &o.Pass)
+		if cmd.Flags().Changed("list") {
+			o.ListUUID = list
+		}
+		return opn.New(o.Address, o.User, o.Pass, o.ListUUID, o.ListUUIDs...), nil
+	})
+	f.register(cmd.PersistentFlags())
+	cmd.PersistentFlags().StringVar(&list, "list", "", "uuid of the block list alias")
+
+	return cmd
+}
+
+func pfCmd() *cobra.Command {
+	f := &connFlags{}
+
+	cmd := newBackendCmd("pf", "pfSense alias backend", func(cmd *cobra.Command) (backend, error) {
+		c, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		p := c.PF
+		if p == nil {
+			p = &config.PF{}
+		}
+		f.apply(cmd.Flags(), &p.Address, &p.User, &p.Pass)
+		return pf.New(p.Address, p.User, p.Pass), nil
+	})
+	f.register(cmd.PersistentFlags())
+
+	return cmd
+}
+
+func rosCmd() *cobra.Command {
+	f := &connFlags{}
+
+	cmd := newBackendCmd("ros", "RouterOS address list backend", func(cmd *cobra.Command) (backend, error) {
+		c, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		r := c.ROS
+		if r == nil {
+			r = &config.ROS{}
+		}
+		f.apply(cmd.Flags(), &r.Address, &r.User, &r.Pass)
+		return ros.New(r.Address, r.User, r.Pass), nil
+	})
+	f.register(cmd.PersistentFlags())
+
+	return cmd
+}
+
+func newBackendCmd(name, short string, build func(cmd *cobra.Command) (backend, error)) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   name,
+		Short: short,
+	}
+
+	var minutes int
+	banCmd := &cobra.Command{
+		Use:   "ban <ip>",
+		Short: "Add ip to the block list",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := build(cmd)
+			if err != nil {
+				return err
+			}
+			return b.BanIP(cmd.Context(), args[0], time.Duration(minutes)*time.Minute)
+		},
+	}
+	banCmd.Flags().IntVar(&minutes, "minutes", 3, "ban timeout in minutes")
+
+	unbanCmd := &cobra.Command{
+		Use:   "unban <ip>",
+		Short: "Remove ip from the block list",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := build(cmd)
+			if err != nil {
+				return err
+			}
+			return b.UnbanIP(cmd.Context(), args[0])
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List ips in the block list",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := build(cmd)
+			if err != nil {
+				return err
+			}
+			bans, err := b.ListBans()
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "IP\tEXPIRE AT")
+			for _, it := range bans {
+				exp := "never"
+				if !it.ExpireAt.IsZero() {
+					exp = it.ExpireAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%s\t%s\n", it.IP, exp)
+			}
+			return w.Flush()
+		},
+	}
+
+	ensureCmd := &cobra.Command{
+		Use:   "ensure-alias",
+		Short: "Create the block list if it does not exist",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := build(cmd)
+			if err != nil {
+				return err
+			}
+			if err := b.EnsureAlias(); err != nil {
+				return err
+			}
+			if o, ok := b.(*opn.API); ok {
+				fmt.Println("list uuid:", o.ListUUID())
+			}
+			return nil
+		},
+	}
+
+	pingCmd := &cobra.Command{
+		Use:   "ping",
+		Short: "Check the backend is reachable",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := build(cmd)
+			if err != nil {
+				return err
+			}
+			if err := b.Ping(); err != nil {
+				return err
+			}
+			fmt.Println("ok")
+			return nil
+		},
+	}
+
+	cmd.AddCommand(banCmd, unbanCmd, listCmd, ensureCmd, pingCmd)
+	return cmd
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/daemon"
+	"github.com/charleshuang3/firewall/fail2ban"
+	"github.com/charleshuang3/firewall/history"
+)
+
+func importFail2banCmd() *cobra.Command {
+	var dbFile string
+	var historyFile string
+	var defaultBanTime time.Duration
+	var permanentMinutes int
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "import-fail2ban",
+		Short: "Re-issue active bans of a fail2ban database and import it into history",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			bans, err := fail2ban.ReadBans(dbFile, defaultBanTime)
+			if err != nil {
+				return err
+			}
+
+			backends, err := daemon.ConfiguredBackends(c)
+			if err != nil {
+				return err
+			}
+			if len(backends) == 0 {
+				return errors.New("no backend in config")
+			}
+
+			var store *history.Store
+			if historyFile != "" || c.History != "" {
+				store, err = openHistory(c, historyFile)
+				if err != nil {
+					return err
+				}
+				defer store.Close()
+			}
+
+			now := time.Now()
+			var errs []error
+			active := 0
+			for _, b := range bans {
+				if !b.Active(now) {
+					continue
+				}
+				active++
+
+				minutes := permanentMinutes
+				if !b.Permanent() {
+					minutes = int(math.Ceil(b.Until().Sub(now).Minutes()))
+				}
+
+				if dryRun {
+					fmt.Printf("%s\t%s\t%d minutes\n", b.IP, b.Jail, minutes)
+					continue
+				}
+
+				for _, be := range backends {
+					if err := be.TryBanIP(b.IP, minutes); err != nil {
+						errs = append(errs, fmt.Errorf("%s ban %s failed: %w", be.Name(), b.IP, err))
+					}
+				}
+			}
+
+			if store != nil && !dryRun {
+				if err := importHistory(store, bans); err != nil {
+					errs = append(errs, err)
+				}
+			}
+
+			fmt.Printf("%d bans, %d active\n", len(bans), active)
+			return errors.Join(errs...)
+		},
+	}
+	cmd.Flags().StringVar(&dbFile, "db", "/var/lib/fail2ban/fail2ban.sqlite3", "fail2ban sqlite database")
+	cmd.Flags().StringVar(&historyFile, "history", "", "sqlite history store, overrides config")
+	cmd.Flags().DurationVar(&defaultBanTime, "bantime", 10*time.Minute, "ban time of databases not recording it, fail2ban before 0.11")
+	cmd.Flags().IntVar(&permanentMinutes, "permanent-minutes", 365*24*60, "ban timeout in minutes of permanent bans")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "only print the active bans")
+
+	return cmd
+}
+
+// importHistory records bans as "ban" events at their ban time.
+func importHistory(store *history.Store, bans []fail2ban.Ban) error {
+	for _, b := range bans {
+		err := store.Record(b.TimeOfBan, &firewall.Event{
+			IP:        b.IP,
+			JailUntil: b.Until(),
+			Reasons:   []string{fmt.Sprintf("fail2ban %s (ban count %d)", b.Jail, b.Count)},
+			Action:    "ban",
+		})
+		if err != nil {
+			return fmt.Errorf("import %s into history failed: %w", b.IP, err)
+		}
+	}
+	return nil
+}
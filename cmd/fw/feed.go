@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func feedKeygenCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "feed-keygen",
+		Short: "Generate an ed25519 key pair to sign the ban feed",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pub, key, err := ed25519.GenerateKey(nil)
+			if err != nil {
+				return err
+			}
+			fmt.Println("private_key:", base64.StdEncoding.EncodeToString(key))
+			fmt.Println("public_key: ", base64.StdEncoding.EncodeToString(pub))
+			return nil
+		},
+	}
+}
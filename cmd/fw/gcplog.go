@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/gcplog"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+func gcplogCmd() *cobra.Command {
+	var authFile, projectID string
+
+	cmd := &cobra.Command{
+		Use:   "gcplog",
+		Short: "Write a test entry to GCP logging",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			g := c.GCPLog
+			if g == nil {
+				g = &config.GCPLog{AuthFile: ".local/auth.json", Service: "test-service"}
+			}
+			if cmd.Flags().Changed("auth") {
+				g.AuthFile = authFile
+			}
+			if cmd.Flags().Changed("project") {
+				g.ProjectID = projectID
+			}
+
+			logger, err := gcplog.New(g.AuthFile, g.ProjectID, g.Service)
+			if err != nil {
+				return err
+			}
+			defer logger.Close()
+
+			logger.Log("10.0.0.1", time.Now().Add(time.Hour), []string{"for testing"}, "act", &ipgeo.IPGeo{
+				IP: "10.0.0.1",
+			})
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&authFile, "auth", "", "GCP credentials file")
+	cmd.Flags().StringVar(&projectID, "project", "", "GCP project id")
+
+	return cmd
+}
@@ -0,0 +1,46 @@
+// Command fw talks to the firewall backends directly. It is used to manage
+// the block list by hand and as the smoke test for backends.
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/secrets"
+)
+
+var configFile string
+
+func loadConfig() (*config.Config, error) {
+	return secrets.Load(context.Background(), configFile)
+}
+
+func main() {
+	root := &cobra.Command{
+		Use:          "fw",
+		Short:        "Manage firewall backends",
+		SilenceUsage: true,
+	}
+	root.PersistentFlags().StringVarP(&configFile, "config", "c", "", "json config file")
+
+	root.AddCommand(
+		opnCmd(),
+		pfCmd(),
+		rosCmd(),
+		gcplogCmd(),
+		rollbackCmd(),
+		importFail2banCmd(),
+		feedKeygenCmd(),
+		reportCmd(),
+		queryCmd(),
+		snmpPassCmd(),
+		profileCmd(),
+	)
+
+	if err := root.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/peer"
+	"github.com/charleshuang3/firewall/profile"
+)
+
+func profileCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Export and import the whitelist, policies and recipes of the daemon",
+	}
+	cmd.AddCommand(profileExportCmd(), profileImportCmd())
+	return cmd
+}
+
+func profileExportCmd() *cobra.Command {
+	var key, issuer, output string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the daemon section as a signed profile",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if c.Daemon == nil {
+				return errors.New("no daemon section in config")
+			}
+			if key == "" && c.Daemon.Feed != nil {
+				key = c.Daemon.Feed.PrivateKey
+			}
+			if key == "" {
+				return errors.New("--key or feed.private_key is required to sign the profile")
+			}
+			priv, err := peer.ParsePrivateKey(key)
+			if err != nil {
+				return err
+			}
+			if issuer == "" {
+				issuer, _ = os.Hostname()
+			}
+
+			b, err := profile.Sign(profile.FromConfig(issuer, c.Daemon), priv)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				_, err = fmt.Println(string(b))
+				return err
+			}
+			return os.WriteFile(output, append(b, '\n'), 0o600)
+		},
+	}
+	cmd.Flags().StringVar(&key, "key", "", "base64 ed25519 private key, default the feed private_key")
+	cmd.Flags().StringVar(&issuer, "issuer", "", "issuer of the profile, default the hostname")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "profile file, default stdout")
+
+	return cmd
+}
+
+func profileImportCmd() *cobra.Command {
+	var publicKey, output string
+	var overwrite bool
+
+	cmd := &cobra.Command{
+		Use:   "import <profile>",
+		Short: "Merge a signed profile into the config and report the conflicts",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pub, err := peer.ParsePublicKey(publicKey)
+			if err != nil {
+				return err
+			}
+			b, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			p, err := profile.Verify(b, pub)
+			if err != nil {
+				return err
+			}
+
+			// not resolved, so the written config has no secret in clear
+			c, err := config.Load(configFile)
+			if err != nil {
+				return err
+			}
+			if c.Daemon == nil {
+				c.Daemon = &config.Daemon{}
+			}
+
+			conflicts := profile.Apply(c.Daemon, p, overwrite)
+			kept := "kept local"
+			if overwrite {
+				kept = "took profile"
+			}
+			for _, it := range conflicts {
+				fmt.Fprintf(os.Stderr, "conflict %s (%s)\n", it, kept)
+			}
+			fmt.Fprintf(os.Stderr, "imported profile of %s generated %s, %d conflicts\n", p.Issuer, p.Generated.Format("2006-01-02 15:04"), len(conflicts))
+
+			out, err := json.MarshalIndent(c, "", "  ")
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				_, err = fmt.Println(string(out))
+				return err
+			}
+			return os.WriteFile(output, append(out, '\n'), 0o600)
+		},
+	}
+	cmd.Flags().StringVar(&publicKey, "public-key", "", "base64 ed25519 public key of the issuer")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "merged config file, default stdout")
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "take the profile value of conflicting settings")
+
+	return cmd
+}
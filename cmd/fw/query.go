@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/charleshuang3/firewall/history"
+)
+
+// daysDuration is a duration flag also accepting days, e.g. "7d".
+type daysDuration time.Duration
+
+func (d *daysDuration) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *daysDuration) Set(s string) error {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("invalid days %q", s)
+		}
+		*d = daysDuration(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = daysDuration(v)
+	return nil
+}
+
+func (d *daysDuration) Type() string {
+	return "duration"
+}
+
+// queryFlags are shared by the query subcommands.
+type queryFlags struct {
+	historyFile string
+	since       daysDuration
+	format      string
+}
+
+func (f *queryFlags) register(fs *pflag.FlagSet, since time.Duration) {
+	f.since = daysDuration(since)
+	fs.StringVar(&f.historyFile, "history", "", "sqlite history store, overrides config")
+	fs.Var(&f.since, "since", "query events within this duration, e.g. 7d or 12h")
+	fs.StringVarP(&f.format, "output", "o", "table", "output format: table, json or csv")
+}
+
+// run opens the history store and prints the rows fn returns. header and
+// rows are used by the table and csv formats, v by the json format.
+func (f *queryFlags) run(fn func(s *history.Store, from time.Time) (v any, header []string, rows [][]string, err error)) error {
+	switch f.format {
+	case "table", "json", "csv":
+	default:
+		return fmt.Errorf("unknown output format %q", f.format)
+	}
+
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	store, err := openHistory(c, f.historyFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	v, header, rows, err := fn(store, time.Now().Add(-time.Duration(f.since)))
+	if err != nil {
+		return err
+	}
+	return writeRows(os.Stdout, f.format, v, header, rows)
+}
+
+func writeRows(w io.Writer, format string, v any, header []string, rows [][]string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(rows)
+		return cw.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func queryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "query",
+		Short: "Query the history store",
+	}
+	cmd.AddCommand(
+		topOffendersCmd(),
+		byCountryCmd(),
+		timelineCmd(),
+	)
+	return cmd
+}
+
+func topOffendersCmd() *cobra.Command {
+	f := &queryFlags{}
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "top-offenders",
+		Short: "List the ips with the most bans and errors",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return f.run(func(s *history.Store, from time.Time) (any, []string, [][]string, error) {
+				offenders, err := s.TopOffenders(from, limit)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				rows := [][]string{}
+				for _, o := range offenders {
+					rows = append(rows, []string{o.IP, strconv.Itoa(o.Bans), strconv.Itoa(o.Errors), o.Country, formatTime(o.LastSeen)})
+				}
+				return offenders, []string{"IP", "BANS", "ERRORS", "COUNTRY", "LAST SEEN"}, rows, nil
+			})
+		},
+	}
+	f.register(cmd.Flags(), 7*24*time.Hour)
+	cmd.Flags().IntVar(&limit, "limit", 20, "max ips to list")
+	return cmd
+}
+
+func byCountryCmd() *cobra.Command {
+	f := &queryFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "by-country",
+		Short: "Count bans by country",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return f.run(func(s *history.Store, from time.Time) (any, []string, [][]string, error) {
+				stats, err := s.BansByCountry(from)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				rows := [][]string{}
+				for _, c := range stats {
+					country := c.Country
+					if country == "" {
+						country = "-"
+					}
+					rows = append(rows, []string{country, strconv.Itoa(c.Bans), strconv.Itoa(c.IPs)})
+				}
+				return stats, []string{"COUNTRY", "BANS", "IPS"}, rows, nil
+			})
+		},
+	}
+	f.register(cmd.Flags(), 7*24*time.Hour)
+	return cmd
+}
+
+func timelineCmd() *cobra.Command {
+	f := &queryFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "timeline <ip>",
+		Short: "List the events of an ip",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return f.run(func(s *history.Store, from time.Time) (any, []string, [][]string, error) {
+				entries, err := s.Timeline(args[0], from)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				rows := [][]string{}
+				for _, e := range entries {
+					rows = append(rows, []string{formatTime(e.Time), e.Action, formatTime(e.JailUntil), strings.Join(e.Reasons, "; "), e.Operator, e.Ticket, e.CorrelationID})
+				}
+				return entries, []string{"TIME", "ACTION", "JAIL UNTIL", "REASONS", "OPERATOR", "TICKET", "CORRELATION ID"}, rows, nil
+			})
+		},
+	}
+	f.register(cmd.Flags(), 30*24*time.Hour)
+	return cmd
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charleshuang3/firewall/i18n"
+)
+
+func reportCmd() *cobra.Command {
+	var since time.Duration
+	var historyFile string
+	var lang string
+	var catalogFile string
+
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Summarize the events in the history store",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			overrides := []i18n.Catalog{}
+			if catalogFile != "" {
+				cat, err := i18n.LoadCatalog(catalogFile)
+				if err != nil {
+					return err
+				}
+				overrides = append(overrides, cat)
+			}
+			l, err := i18n.New(lang, overrides...)
+			if err != nil {
+				return err
+			}
+
+			store, err := openHistory(c, historyFile)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			now := time.Now()
+			from := now.Add(-since)
+			counts, err := store.ActionCounts(from)
+			if err != nil {
+				return err
+			}
+			bans, err := store.BansSince(from)
+			if err != nil {
+				return err
+			}
+
+			r := &i18n.Report{
+				Since:   from,
+				Until:   now,
+				Actions: i18n.SortedActions(counts),
+			}
+			for _, b := range bans {
+				r.Bans = append(r.Bans, i18n.ReportBan{
+					IP:        b.IP,
+					Time:      b.Time,
+					JailUntil: b.JailUntil,
+					Reasons:   b.Reasons,
+				})
+			}
+
+			text, err := l.Report(r)
+			if err != nil {
+				return err
+			}
+			fmt.Print(text)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&since, "since", 24*time.Hour, "report events within this duration")
+	cmd.Flags().StringVar(&historyFile, "history", "", "sqlite history store, overrides config")
+	cmd.Flags().StringVar(&lang, "lang", i18n.DefaultLanguage, fmt.Sprintf("language of the report, builtin: %v", i18n.Languages()))
+	cmd.Flags().StringVar(&catalogFile, "catalog", "", "json message catalog overriding the builtin messages")
+
+	return cmd
+}
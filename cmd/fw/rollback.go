@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/daemon"
+	"github.com/charleshuang3/firewall/history"
+)
+
+func openHistory(c *config.Config, path string) (*history.Store, error) {
+	if path == "" {
+		path = c.History
+	}
+	if path == "" {
+		return nil, errors.New("no history store, set --history or \"history\" in config")
+	}
+	return history.Open(path)
+}
+
+func rollbackCmd() *cobra.Command {
+	var since time.Duration
+	var historyFile string
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Unban every ip banned in a time window on all backends",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			store, err := openHistory(c, historyFile)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ips, err := store.BannedSince(time.Now().Add(-since))
+			if err != nil {
+				return err
+			}
+
+			backends, err := daemon.ConfiguredBackends(c)
+			if err != nil {
+				return err
+			}
+			if len(backends) == 0 {
+				return errors.New("no backend in config")
+			}
+
+			var errs []error
+			for _, ip := range ips {
+				if dryRun {
+					fmt.Println(ip)
+					continue
+				}
+
+				reasons := []string{fmt.Sprintf("rollback bans since %s", since)}
+				failed := false
+				for _, b := range backends {
+					if err := b.UnbanIP(ip); err != nil {
+						err = fmt.Errorf("%s unban %s failed: %w", b.Name(), ip, err)
+						errs = append(errs, err)
+						reasons = append(reasons, err.Error())
+						failed = true
+					}
+				}
+
+				if err := store.Record(time.Now(), &firewall.Event{
+					IP:      ip,
+					Reasons: reasons,
+					Action:  "rollback",
+				}); err != nil {
+					errs = append(errs, err)
+				}
+				if !failed {
+					fmt.Println("unbanned", ip)
+				}
+			}
+
+			return errors.Join(errs...)
+		},
+	}
+	cmd.Flags().DurationVar(&since, "since", 10*time.Minute, "unban ips banned within this duration")
+	cmd.Flags().StringVar(&historyFile, "history", "", "sqlite history store, overrides config")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "only print the ips")
+
+	return cmd
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/snmp"
+)
+
+func snmpPassCmd() *cobra.Command {
+	var admin, tokenFile string
+	var base string
+	var cacheFor time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "snmp-pass",
+		Short: "Serve firewalld diagnostics to snmpd with the pass_persist protocol",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return fmt.Errorf("read admin token failed: %w", err)
+			}
+			token := strings.TrimSpace(string(b))
+			client := &http.Client{Timeout: 5 * time.Second}
+			url := strings.TrimSuffix(admin, "/") + "/debug/diagnostics"
+
+			var cached []snmp.Var
+			var fetchedAt time.Time
+			s := &snmp.Server{
+				Base: base,
+				Fetch: func() ([]snmp.Var, error) {
+					if cached != nil && time.Since(fetchedAt) < cacheFor {
+						return cached, nil
+					}
+					req, err := http.NewRequest(http.MethodGet, url, nil)
+					if err != nil {
+						return nil, err
+					}
+					req.Header.Set("Authorization", "Bearer "+token)
+					resp, err := client.Do(req)
+					if err != nil {
+						return nil, err
+					}
+					defer resp.Body.Close()
+					if resp.StatusCode != http.StatusOK {
+						return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+					}
+					d := &firewall.Diagnostics{}
+					if err := json.NewDecoder(resp.Body).Decode(d); err != nil {
+						return nil, err
+					}
+					cached, fetchedAt = snmp.DiagnosticsVars(d), time.Now()
+					return cached, nil
+				},
+			}
+			return s.Serve(os.Stdin, os.Stdout)
+		},
+	}
+	cmd.Flags().StringVar(&admin, "admin", "http://127.0.0.1:8081", "address of the firewalld admin listener")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "file of the token of an operator of the admin listener")
+	cmd.MarkFlagRequired("token-file")
+	cmd.Flags().StringVar(&base, "base", ".1.3.6.1.4.1.8072.9999.9999.1", "base OID, same as in snmpd.conf")
+	cmd.Flags().DurationVar(&cacheFor, "cache", 5*time.Second, "reuse diagnostics for this duration, snmpwalk fetches every OID")
+
+	return cmd
+}
@@ -0,0 +1,251 @@
+// Command fwbench simulates attacks against a Firewall with a mock backend
+// and reports throughput, ban latency, memory and the decisions made, to
+// tune the options and to catch performance regressions:
+//
+//	go run ./cmd/fwbench -ips 100000 -rate 50000 -duration 30s -pattern burst
+//	go run ./cmd/fwbench -pattern subnet -subnets 4 -backend-latency 2s -ban-workers 8 -json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+var (
+	ips            = flag.Int("ips", 10000, "number of attacking ips")
+	errRate        = flag.Int("rate", 10000, "errors per second, 0 for as fast as possible")
+	duration       = flag.Duration("duration", 10*time.Second, "how long to send errors")
+	patternName    = flag.String("pattern", "uniform", "uniform, burst or subnet")
+	burstOn        = flag.Duration("burst-on", time.Second, "burst: sending time of a wave")
+	burstOff       = flag.Duration("burst-off", time.Second, "burst: pause between waves")
+	subnets        = flag.Int("subnets", 4, "subnet: number of /24 networks of the attackers")
+	senders        = flag.Int("senders", runtime.GOMAXPROCS(0), "goroutines calling LogIPError")
+	count          = flag.Int("count", 5, "errors forgiven per window")
+	window         = flag.Duration("window", time.Minute, "forgivable window")
+	banMinutes     = flag.Int("ban", 60, "ban in minutes")
+	backendLatency = flag.Duration("backend-latency", 0, "time the mock backend takes per ban")
+	banWorkers     = flag.Int("ban-workers", 0, "see firewall.WithBanWorkers")
+	queueSize      = flag.Int("queue", 0, "see firewall.WithQueue")
+	dropOldest     = flag.Bool("drop-oldest", false, "drop the oldest queued event when the queue is full")
+	jsonOutput     = flag.Bool("json", false, "print the report as json")
+)
+
+// mockBackend bans nothing, it only takes its time.
+type mockBackend struct {
+	latency time.Duration
+	bans    atomic.Uint64
+}
+
+func (m *mockBackend) BanIP(ip string, timeoutInMinute int) {
+	time.Sleep(m.latency)
+	m.bans.Add(1)
+}
+
+// countingLogger counts the events by action.
+type countingLogger struct {
+	mu      sync.Mutex
+	actions map[string]int
+}
+
+func (c *countingLogger) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.actions[action]++
+}
+
+type report struct {
+	Pattern    string         `json:"pattern"`
+	Sent       uint64         `json:"sent"`
+	Elapsed    time.Duration  `json:"elapsed_ns"`
+	PerSecond  float64        `json:"per_second"`
+	Dropped    uint64         `json:"dropped"`
+	Decisions  map[string]int `json:"decisions"`
+	BackendBan uint64         `json:"backend_bans"`
+	Jailed     int            `json:"jailed"`
+
+	QueueAvg   time.Duration `json:"queue_avg_ns"`
+	QueueMax   time.Duration `json:"queue_max_ns"`
+	BackendAvg time.Duration `json:"backend_avg_ns"`
+	BackendMax time.Duration `json:"backend_max_ns"`
+
+	HeapPeak   uint64 `json:"heap_peak_bytes"`
+	HeapEnd    uint64 `json:"heap_end_bytes"`
+	TotalAlloc uint64 `json:"total_alloc_bytes"`
+	NumGC      uint32 `json:"num_gc"`
+}
+
+func main() {
+	flag.Parse()
+
+	p, err := newPattern(*patternName, *ips, *subnets, *burstOn, *burstOff)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	r := run(p)
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	printReport(r)
+}
+
+func run(p pattern) *report {
+	backend := &mockBackend{latency: *backendLatency}
+	logger := &countingLogger{actions: map[string]int{}}
+	opts := []firewall.Option{}
+	if *queueSize > 0 {
+		overflow := firewall.OverflowBlock
+		if *dropOldest {
+			overflow = firewall.OverflowDropOldest
+		}
+		opts = append(opts, firewall.WithQueue(*queueSize, overflow))
+	}
+	if *banWorkers > 0 {
+		opts = append(opts, firewall.WithBanWorkers(*banWorkers))
+	}
+	fw, err := firewall.New(nil, backend, logger, nil, firewall.ForgivableError{
+		Duration:    *window,
+		Count:       *count,
+		BanInMinute: *banMinutes,
+	}, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	stopSampling := sampleHeap()
+
+	limit := rate.Inf
+	if *errRate > 0 {
+		limit = rate.Limit(*errRate)
+	}
+	// a burst of 10ms of errors, waiting for every single token is limited
+	// by the timer resolution
+	limiter := rate.NewLimiter(limit, max(*errRate/100, *senders, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	start := time.Now()
+	sent := atomic.Uint64{}
+	wg := sync.WaitGroup{}
+	for i := range max(*senders, 1) {
+		wg.Go(func() {
+			r := rand.New(rand.NewPCG(uint64(i), uint64(start.UnixNano())))
+			for ctx.Err() == nil {
+				if !p.active(time.Since(start)) {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				if limiter.Wait(ctx) != nil {
+					return
+				}
+				fw.LogIPError(p.ip(r), "bench")
+				sent.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	// the queued errors are handled before Close returns
+	if err := fw.Close(context.Background()); err != nil {
+		log.Println(err)
+	}
+	elapsed := time.Since(start)
+	jailed := fw.JailedCount()
+	peak := stopSampling()
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	stats := fw.EnforcementStats()
+	r := &report{
+		Pattern:    *patternName,
+		Sent:       sent.Load(),
+		Elapsed:    elapsed,
+		PerSecond:  float64(sent.Load()) / elapsed.Seconds(),
+		Dropped:    fw.Dropped(),
+		Decisions:  logger.actions,
+		BackendBan: backend.bans.Load(),
+		Jailed:     jailed,
+		QueueMax:   stats.QueueMax,
+		BackendMax: stats.BackendMax,
+		HeapPeak:   max(peak, after.HeapAlloc),
+		HeapEnd:    after.HeapAlloc,
+		TotalAlloc: after.TotalAlloc - before.TotalAlloc,
+		NumGC:      after.NumGC - before.NumGC,
+	}
+	if stats.Count > 0 {
+		r.QueueAvg = stats.QueueTotal / time.Duration(stats.Count)
+		r.BackendAvg = stats.BackendTotal / time.Duration(stats.Count)
+	}
+	return r
+}
+
+// sampleHeap samples the heap size until the returned func is called, which
+// returns the peak.
+func sampleHeap() func() uint64 {
+	peak := atomic.Uint64{}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(100 * time.Millisecond)
+		defer t.Stop()
+		var m runtime.MemStats
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapAlloc > peak.Load() {
+				peak.Store(m.HeapAlloc)
+			}
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		<-stopped
+		return peak.Load()
+	}
+}
+
+func printReport(r *report) {
+	fmt.Printf("pattern      %s\n", r.Pattern)
+	fmt.Printf("errors       %d in %s, %.0f/s\n", r.Sent, r.Elapsed.Round(time.Millisecond), r.PerSecond)
+	fmt.Printf("dropped      %d\n", r.Dropped)
+	fmt.Printf("bans         %d on the backend, %d jailed at the end\n", r.BackendBan, r.Jailed)
+	fmt.Printf("queue        avg %s, max %s\n", r.QueueAvg, r.QueueMax)
+	fmt.Printf("backend      avg %s, max %s\n", r.BackendAvg, r.BackendMax)
+	fmt.Printf("heap         peak %s, end %s, %s allocated, %d gc\n", mib(r.HeapPeak), mib(r.HeapEnd), mib(r.TotalAlloc), r.NumGC)
+	fmt.Println("decisions")
+	for _, a := range slices.Sorted(maps.Keys(r.Decisions)) {
+		fmt.Printf("  %-11s %d\n", a, r.Decisions[a])
+	}
+}
+
+func mib(b uint64) string {
+	return fmt.Sprintf("%.1fMiB", float64(b)/(1<<20))
+}
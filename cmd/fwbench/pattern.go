@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// pattern picks the source ip of each simulated error.
+type pattern interface {
+	// ip returns the source of the next error.
+	ip(r *rand.Rand) string
+	// active returns whether errors are sent at elapsed since the start,
+	// bursty patterns pause in between.
+	active(elapsed time.Duration) bool
+}
+
+// ipOf returns the i-th ip of 10.0.0.0/8.
+func ipOf(i int) string {
+	return fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+}
+
+// uniform spreads errors evenly over ips attackers.
+type uniform struct {
+	ips int
+}
+
+func (u uniform) ip(r *rand.Rand) string {
+	return ipOf(r.IntN(u.ips))
+}
+
+func (u uniform) active(elapsed time.Duration) bool {
+	return true
+}
+
+// burst is uniform sending for on, then pausing for off, e.g. a botnet
+// scanning in waves.
+type burst struct {
+	uniform
+	on, off time.Duration
+}
+
+func (b burst) active(elapsed time.Duration) bool {
+	return elapsed%(b.on+b.off) < b.on
+}
+
+// subnet concentrates the attackers in a few /24 networks, e.g. a rented
+// server range, the case for network bans and AS escalation.
+type subnet struct {
+	ips     int
+	subnets int
+}
+
+func (s subnet) ip(r *rand.Rand) string {
+	perNet := max(s.ips/s.subnets, 1)
+	return fmt.Sprintf("10.0.%d.%d", r.IntN(s.subnets), 1+r.IntN(min(perNet, 254)))
+}
+
+func (s subnet) active(elapsed time.Duration) bool {
+	return true
+}
+
+func newPattern(name string, ips, subnets int, on, off time.Duration) (pattern, error) {
+	if ips <= 0 || ips > 1<<24 {
+		return nil, fmt.Errorf("ips should be in [1, %d]", 1<<24)
+	}
+	switch name {
+	case "uniform":
+		return uniform{ips: ips}, nil
+	case "burst":
+		if on <= 0 || off < 0 {
+			return nil, fmt.Errorf("burst needs a positive -burst-on and -burst-off")
+		}
+		return burst{uniform: uniform{ips: ips}, on: on, off: off}, nil
+	case "subnet":
+		if subnets <= 0 || subnets > 256 {
+			return nil, fmt.Errorf("subnets should be in [1, 256]")
+		}
+		return subnet{ips: ips, subnets: subnets}, nil
+	}
+	return nil, fmt.Errorf("unknown pattern %q, use uniform, burst or subnet", name)
+}
@@ -0,0 +1,167 @@
+// Package config loads the json config file used by the command line tools
+// and the daemon.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type Config struct {
+	OPN    *OPN    `json:"opn,omitempty"`
+	PF     *PF     `json:"pf,omitempty"`
+	ROS    *ROS    `json:"ros,omitempty"`
+	GCPLog *GCPLog `json:"gcplog,omitempty"`
+	MISP   *MISP   `json:"misp,omitempty"`
+
+	// History is the path of the sqlite history store.
+	History string `json:"history,omitempty"`
+
+	Daemon *Daemon `json:"daemon,omitempty"`
+
+	// Secrets configures the providers of secret:// references, see
+	// package secrets.
+	Secrets *Secrets `json:"secrets,omitempty"`
+}
+
+type OPN struct {
+	Address  string `json:"address"`
+	User     string `json:"user"`
+	Pass     string `json:"pass"`
+	ListUUID string `json:"list_uuid"`
+	// ListUUIDs are more aliases every ban is written to, e.g. of a DMZ
+	// interface.
+	ListUUIDs   []string     `json:"list_uuids,omitempty"`
+	Credentials *Credentials `json:"credentials,omitempty"`
+	// AliasCapacity splits the block list into aliases of at most this many
+	// entries, block_list_1..AliasChunks, see opn.API.WithChunks.
+	AliasCapacity int `json:"alias_capacity,omitempty"`
+	AliasChunks   int `json:"alias_chunks,omitempty"`
+	// ActionListUUIDs are the aliases of bans by a policy with another
+	// action than block, by action, e.g. {"tarpit": "<uuid>"} for an alias
+	// matched by a tarpit rule. Actions without an alias are blocks, so are
+	// all bans if opn is behind routes or more backends.
+	ActionListUUIDs map[string]string `json:"action_list_uuids,omitempty"`
+}
+
+type PF struct {
+	Address     string       `json:"address"`
+	User        string       `json:"user"`
+	Pass        string       `json:"pass"`
+	Credentials *Credentials `json:"credentials,omitempty"`
+	// AliasCapacity splits the block list into aliases of at most this many
+	// entries, block_list_1..AliasChunks, see pf.API.WithChunks.
+	AliasCapacity int `json:"alias_capacity,omitempty"`
+	AliasChunks   int `json:"alias_chunks,omitempty"`
+}
+
+type ROS struct {
+	Address     string       `json:"address"`
+	User        string       `json:"user"`
+	Pass        string       `json:"pass"`
+	Credentials *Credentials `json:"credentials,omitempty"`
+}
+
+// Credentials of a backend replace its user and pass in the daemon. They
+// are read on every backend call, so rotated credentials are picked up
+// without a restart. Set one source.
+type Credentials struct {
+	// File is a json file {"user": "...", "pass": "..."}, read again when
+	// it is modified.
+	File  string `json:"file,omitempty"`
+	Vault *Vault `json:"vault,omitempty"`
+	// User and Pass are secret:// references resolved on every call, and
+	// cached for the ttl of the secrets section.
+	User string `json:"user,omitempty" secrets:"-"`
+	Pass string `json:"pass,omitempty" secrets:"-"`
+}
+
+// Vault reads the credentials from a KV version 2 secret with the keys
+// user and pass, see secrets.VaultCredentials.
+type Vault struct {
+	Address string `json:"address"`
+	// Path of the secret, e.g. "secret/data/opn".
+	Path string `json:"path"`
+	// TokenFile holds the vault token, the VAULT_TOKEN environment
+	// variable is used if empty.
+	TokenFile string `json:"token_file,omitempty"`
+	// TTL is how long the secret is cached, default 5m.
+	TTL Duration `json:"ttl,omitempty"`
+}
+
+// Secrets configures the providers of secret:// references. The env and
+// file providers need no config.
+type Secrets struct {
+	Vault *SecretsVault `json:"vault,omitempty"`
+	GCP   *SecretsGCP   `json:"gcp,omitempty"`
+	// TTL is how long a secret is cached, default 5m.
+	TTL Duration `json:"ttl,omitempty"`
+}
+
+// SecretsVault reads secret://vault/<path>#<key> references of KV version 2
+// secrets.
+type SecretsVault struct {
+	// Address of vault, the VAULT_ADDR environment variable if empty.
+	Address string `json:"address,omitempty"`
+	// TokenFile holds the vault token, the VAULT_TOKEN environment
+	// variable is used if empty.
+	TokenFile string `json:"token_file,omitempty"`
+}
+
+// SecretsGCP reads secret://gcp/projects/<p>/secrets/<s> references of
+// Google Cloud Secret Manager.
+type SecretsGCP struct {
+	// AuthFile is a service account key, the application default
+	// credentials are used if empty.
+	AuthFile string `json:"auth_file,omitempty"`
+}
+
+type GCPLog struct {
+	AuthFile  string `json:"auth_file"`
+	ProjectID string `json:"project_id"`
+	Service   string `json:"service"`
+	// QueueSize bounds the events waiting to be sent, default 10000. When
+	// full, the oldest event which is not a ban is dropped, bans are never
+	// dropped and can exceed it.
+	QueueSize int `json:"queue_size,omitempty"`
+}
+
+// MISP pushes bans to EventID if set, and pulls a blocklist if Pull is set.
+type MISP struct {
+	URL           string `json:"url"`
+	Key           string `json:"key"`
+	RatePerMinute int    `json:"rate_per_minute,omitempty"`
+	EventID       string `json:"event_id,omitempty"`
+	// Tags maps a reason prefix to a MISP tag.
+	Tags        map[string]string `json:"tags,omitempty"`
+	DefaultTags []string          `json:"default_tags,omitempty"`
+	Pull        *MISPPull         `json:"pull,omitempty"`
+}
+
+type MISPPull struct {
+	Tags []string `json:"tags,omitempty"`
+	// Last is how far back attributes are pulled, e.g. "1d".
+	Last        string   `json:"last,omitempty"`
+	BanInMinute int      `json:"ban_in_minute"`
+	Interval    Duration `json:"interval,omitempty"`
+}
+
+// Load reads config from path. An empty path returns an empty config.
+func Load(path string) (*Config, error) {
+	c := &Config{}
+	if path == "" {
+		return c, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config failed: %w", err)
+	}
+
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("unmarshal config %q failed: %w", path, err)
+	}
+
+	return c, nil
+}
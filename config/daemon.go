@@ -0,0 +1,415 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Daemon configures cmd/firewalld.
+type Daemon struct {
+	// Listen is the address of the ingest api, it is not served if empty,
+	// e.g. when the firewall runs in Caddy.
+	Listen string `json:"listen"`
+	// IngestToken is the bearer token required by the ingest api without
+	// Tenants. The daemon does not start with neither when Listen is not a
+	// loopback address.
+	IngestToken string `json:"ingest_token,omitempty"`
+	// Backend is one of "opn", "pf", "ros", the backend config is the
+	// section of the same name.
+	Backend string `json:"backend,omitempty"`
+	// Backends bans on many backends instead of Backend, e.g. ["opn",
+	// "ros"]. A failing backend does not block the others.
+	Backends []string `json:"backends,omitempty"`
+	// Routes send bans with a matching reason to their backend instead of
+	// Backend or Backends, which take the bans matching no route.
+	Routes []Route `json:"routes,omitempty"`
+	// Mirror runs the detection without a backend, e.g. in staging. Bans
+	// are only jailed and recorded to the history store and loggers, the
+	// backend sections, WAL and HA are ignored.
+	Mirror bool `json:"mirror,omitempty"`
+	// Logger is "zerolog" (default, stdout) or "gcplog".
+	Logger string `json:"logger,omitempty"`
+	// WAL is the path of the write-ahead log of bans, bans not reaching
+	// the backend are retried after a crash or a backend outage.
+	WAL string `json:"wal,omitempty"`
+	// WarmUp restores the active bans of the history store and polls the
+	// peer feeds and MISP once before serving, so a restart during an
+	// attack does not let known offenders in.
+	WarmUp bool `json:"warm_up,omitempty"`
+	// WASMPolicy is the path of a detection rule compiled to WebAssembly,
+	// see package wasmpolicy.
+	WASMPolicy string `json:"wasm_policy,omitempty"`
+	// LuaScript is the path of a Lua script with hooks, see package
+	// luahook. Its on_error can not be used with WASMPolicy.
+	LuaScript string `json:"lua_script,omitempty"`
+
+	// Durations scales the minutes of bans by the origin of the ip, it
+	// requires Geo.
+	Durations *Durations `json:"durations,omitempty"`
+	// Stuffing detects credential stuffing from the targets of errors.
+	Stuffing *Stuffing `json:"stuffing,omitempty"`
+	// ASN escalates autonomous systems with many banned ips, it requires
+	// Geo with the ASN database.
+	ASN *ASN `json:"asn,omitempty"`
+	// BanCountries bans the ips of these countries, by ISO code, on their
+	// first error. It requires Geo.
+	BanCountries []string `json:"ban_countries,omitempty"`
+	// AllowCountries bans the ips outside these countries on their first
+	// error. It requires Geo.
+	AllowCountries *AllowCountries `json:"allow_countries,omitempty"`
+	// Blacklist are ips and cidrs banned on their first error.
+	Blacklist []string `json:"blacklist,omitempty"`
+	// NoCountErrorGeo leaves geo data out of "count error" events to save
+	// the lookups, bans still have it.
+	NoCountErrorGeo bool `json:"no_count_error_geo,omitempty"`
+	// NoWhitelistUnban keeps the bans of ips matching a whitelist rule
+	// added at runtime, they are logged as "whitelist-overlap" instead.
+	NoWhitelistUnban bool `json:"no_whitelist_unban,omitempty"`
+	// ForgivableByReason are the thresholds of reason categories, by
+	// prefix of the reason, e.g. {"sql injection": {"count": 0, ...}}.
+	ForgivableByReason map[string]Forgivable `json:"forgivable_by_reason,omitempty"`
+	// Scoring bans by the decaying score of weighted errors instead of the
+	// forgivable thresholds.
+	Scoring *Scoring `json:"scoring,omitempty"`
+	// Queue buffers bans and errors for the firewall loop, so callers do
+	// not wait for a slow backend.
+	Queue *Queue `json:"queue,omitempty"`
+	// BanWorkers calls the backend for bans in this many goroutines, so a
+	// slow backend does not delay counting errors. 0 calls it in the
+	// firewall loop.
+	BanWorkers int `json:"ban_workers,omitempty"`
+	// Retry retries failed backend calls with exponential backoff.
+	Retry *Retry `json:"retry,omitempty"`
+	// DeadManSwitch pauses enforcement when the admin api gets no POST
+	// /v1/heartbeat for this long, e.g. from the config management. It is
+	// resumed by POST /v1/resume.
+	DeadManSwitch Duration `json:"dead_man_switch,omitempty"`
+	// GlobalMaxBanPerMinute pauses the firewall of the daemon and the ones
+	// of the tenants when more bans happen in a minute across them, on top
+	// of their own MaxBanPerMinute.
+	GlobalMaxBanPerMinute int `json:"global_max_ban_per_minute,omitempty"`
+
+	Whitelist       []string   `json:"whitelist,omitempty"`
+	Forgivable      Forgivable `json:"forgivable"`
+	MaxBanPerMinute int        `json:"max_ban_per_minute,omitempty"`
+	Geo             *Geo       `json:"geo,omitempty"`
+	Metrics         *Metrics   `json:"metrics,omitempty"`
+	Admin           *Admin     `json:"admin,omitempty"`
+	Runtime         *Runtime   `json:"runtime,omitempty"`
+	Feed            *Feed      `json:"feed,omitempty"`
+	TAXII           *TAXII     `json:"taxii,omitempty"`
+	Tenants         []Tenant   `json:"tenants,omitempty"`
+	// WhitelistAS are autonomous systems never banned, by number, "AS15169",
+	// or organization substring, "Google". It requires Geo with the ASN
+	// database.
+	WhitelistAS []string `json:"whitelist_as,omitempty"`
+	// WhitelistCountries are countries never banned, by ISO code. It
+	// requires Geo.
+	WhitelistCountries []string `json:"whitelist_countries,omitempty"`
+	// SkipPrivateIPs never bans private, loopback and link local ips.
+	SkipPrivateIPs bool `json:"skip_private_ips,omitempty"`
+	// JailCapacity logs a "jail-capacity" event when more ips are jailed.
+	JailCapacity int `json:"jail_capacity,omitempty"`
+	// Jails are recipes of package recipe, e.g. "sshd", whose log lines are
+	// posted to /v1/log.
+	Jails []Jail `json:"jails,omitempty"`
+	// Blocklists are public lists banned while listed, see package
+	// blocklist.
+	Blocklists []Blocklist `json:"blocklists,omitempty"`
+	// Export serves the jail as a plain-text list at /v1/export of the
+	// ingest listener, see package export.
+	Export *Export `json:"export,omitempty"`
+	// Supervisor sets how the daemon handles failing components.
+	Supervisor *Supervisor `json:"supervisor,omitempty"`
+	// Quota limits each ingest source, a tenant or, without tenants, the
+	// remote host.
+	Quota *Quota `json:"quota,omitempty"`
+	// HA runs the daemon as leader or follower of two instances, only the
+	// leader writes to the backend.
+	HA *HA `json:"ha,omitempty"`
+}
+
+// Route sends bans with a reason starting with Prefix to Backend, e.g.
+// {"prefix": "ssh", "backend": "ros"}.
+type Route struct {
+	Prefix  string `json:"prefix"`
+	Backend string `json:"backend"`
+}
+
+// Jail is a pre-tuned recipe, its patterns and threshold.
+type Jail struct {
+	Recipe string `json:"recipe"`
+	// Forgivable replaces the threshold of the recipe.
+	Forgivable *Forgivable `json:"forgivable,omitempty"`
+}
+
+// Blocklist is a public list of ips and cidrs to ban.
+type Blocklist struct {
+	// Name is in the reasons, and a known list if URL is empty:
+	// spamhaus-drop, spamhaus-dropv6, firehol-level1 or abuseipdb.
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+	// Key is the api key of lists requiring one, e.g. abuseipdb.
+	Key string `json:"key,omitempty"`
+	// BanInMinute of the entries, refreshed while listed, default a week.
+	BanInMinute int `json:"ban_in_minute,omitempty"`
+	// Interval between fetches, default 6h.
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// Export of the jail for other firewalls.
+type Export struct {
+	// Token is required as the "token" query parameter if set, url table
+	// aliases cannot send headers.
+	Token string `json:"token,omitempty"`
+}
+
+// Quota of an ingest source, zero values are unlimited.
+type Quota struct {
+	EventsPerSecond float64 `json:"events_per_second,omitempty"`
+	// Burst of events, default EventsPerSecond.
+	Burst       int `json:"burst,omitempty"`
+	BansPerHour int `json:"bans_per_hour,omitempty"`
+}
+
+// Tenant has its own jail, whitelist and thresholds, selected by the ingest
+// token. Tenants share the backend and loggers, events are labeled with the
+// tenant name.
+type Tenant struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// Whitelist is added to the daemon whitelist.
+	Whitelist []string `json:"whitelist,omitempty"`
+	// Forgivable defaults to the daemon one.
+	Forgivable      *Forgivable `json:"forgivable,omitempty"`
+	MaxBanPerMinute int         `json:"max_ban_per_minute,omitempty"`
+	// Quota defaults to the daemon one.
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// TAXII serves bans in the history store as STIX indicators under /taxii2/
+// of the ingest listener.
+type TAXII struct {
+	Title string `json:"title,omitempty"`
+	// Window is how far back bans are exported, default 24h.
+	Window Duration `json:"window,omitempty"`
+}
+
+// Feed shares bans with trusted peers. The feed of active bans in the
+// history store is served at /v1/feed of the ingest listener if PrivateKey
+// is set.
+type Feed struct {
+	Issuer string `json:"issuer,omitempty"`
+	// PrivateKey is a base64 ed25519 private key, see `fw feed-keygen`.
+	PrivateKey string `json:"private_key,omitempty"`
+	Peers      []Peer `json:"peers,omitempty"`
+	// Interval of polling peers, default 5m.
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// Peer is a feed to consume. Ban applies its bans directly, otherwise each
+// ban counts as Weight errors of the ip.
+type Peer struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	PublicKey string `json:"public_key"`
+	Ban       bool   `json:"ban,omitempty"`
+	Weight    int    `json:"weight,omitempty"`
+}
+
+// Durations are ban duration multipliers, see firewall.DurationPolicy.
+type Durations struct {
+	ASNs          map[uint]float64   `json:"asns,omitempty"`
+	Organizations map[string]float64 `json:"organizations,omitempty"`
+	Countries     map[string]float64 `json:"countries,omitempty"`
+	Proxy         float64            `json:"proxy,omitempty"`
+	Satellite     float64            `json:"satellite,omitempty"`
+}
+
+// Stuffing configures the credential stuffing detector, see package
+// stuffing. Zero thresholds disable their check.
+type Stuffing struct {
+	Window       Duration `json:"window,omitempty"`
+	TargetsPerIP int      `json:"targets_per_ip,omitempty"`
+	IPsPerTarget int      `json:"ips_per_target,omitempty"`
+	MinEntropy   float64  `json:"min_entropy,omitempty"`
+	MinErrors    int      `json:"min_errors,omitempty"`
+	Weight       int      `json:"weight,omitempty"`
+}
+
+// ASN escalates an autonomous system when more than MaxIPs of its ips
+// are banned within Window, see firewall.ASNPolicy.
+type ASN struct {
+	MaxIPs     int      `json:"max_ips"`
+	Window     Duration `json:"window,omitempty"`
+	Escalation Duration `json:"escalation,omitempty"`
+}
+
+// Supervisor sets how the daemon handles failing components: the listeners
+// by name ("ingest", "admin" and "metrics"), "peer-consumer", "misp" and
+// "wal-replay". Only the listeners are critical by default, a critical
+// component failing for good stops the daemon.
+type Supervisor struct {
+	// StrictStartup stops the daemon when any component fails for good
+	// within StartupGrace, default 30s, of the start.
+	StrictStartup bool     `json:"strict_startup,omitempty"`
+	StartupGrace  Duration `json:"startup_grace,omitempty"`
+	// Components override the policies of components by name.
+	Components map[string]Component `json:"components,omitempty"`
+}
+
+// Component is the policy of a component of the daemon.
+type Component struct {
+	// Restart is "never", the default, "on-failure" or "always", to also
+	// restart a component returning early without error.
+	Restart string `json:"restart,omitempty"`
+	// MaxRestarts defaults to 5, negative restarts forever.
+	MaxRestarts int   `json:"max_restarts,omitempty"`
+	Critical    *bool `json:"critical,omitempty"`
+}
+
+// Scoring bans an ip when the weights of its errors, by reason prefix,
+// reach Threshold, see firewall.Scoring.
+type Scoring struct {
+	Weights       map[string]float64 `json:"weights,omitempty"`
+	DefaultWeight float64            `json:"default_weight,omitempty"`
+	HalfLife      Duration           `json:"half_life,omitempty"`
+	Threshold     float64            `json:"threshold"`
+}
+
+// AllowCountries only allows the ips of Countries, by ISO code, see
+// firewall.CountryAllowlist. CrawlerDomains exempt the crawlers whose
+// reverse dns name ends with one of them, e.g. ".googlebot.com".
+type AllowCountries struct {
+	Countries      []string `json:"countries"`
+	CrawlerDomains []string `json:"crawler_domains,omitempty"`
+}
+
+// Queue buffers up to Size bans and Size errors. With DropOldest a full
+// queue drops its oldest event instead of blocking the caller.
+type Queue struct {
+	Size       int  `json:"size"`
+	DropOldest bool `json:"drop_oldest,omitempty"`
+}
+
+// Retry is a firewall.RetryPolicy, zero fields take its defaults: 3
+// attempts, 1s initial and 30s max backoff.
+type Retry struct {
+	MaxAttempts    int      `json:"max_attempts,omitempty"`
+	InitialBackoff Duration `json:"initial_backoff,omitempty"`
+	MaxBackoff     Duration `json:"max_backoff,omitempty"`
+}
+
+// HA elects the leader with one of FileLock, Redis or Lease. The follower
+// mirrors the jails of the leader from Peer, the admin address of the other
+// instance, e.g. "http://10.0.0.2:9090".
+type HA struct {
+	// ID names this instance, default the hostname.
+	ID string `json:"id,omitempty"`
+	// TTL of the lock, default 15s. A failed leader is replaced after it.
+	TTL      Duration `json:"ttl,omitempty"`
+	FileLock string   `json:"file_lock,omitempty"`
+	Redis    *HARedis `json:"redis,omitempty"`
+	Lease    *HALease `json:"lease,omitempty"`
+	Peer     string   `json:"peer,omitempty"`
+	// MirrorInterval of polling the jails of Peer, default 10s.
+	MirrorInterval Duration `json:"mirror_interval,omitempty"`
+	// PeerToken is the token of an operator of the admin api of Peer.
+	PeerToken string `json:"peer_token,omitempty"`
+}
+
+type HARedis struct {
+	Address  string `json:"address"`
+	Password string `json:"password,omitempty"`
+	// Key default "firewall:leader".
+	Key string `json:"key,omitempty"`
+}
+
+// HALease is a Kubernetes Lease, the daemon runs in a pod of the cluster.
+type HALease struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// Admin is the listener for operators, keep it on a private address.
+type Admin struct {
+	Listen string `json:"listen"`
+	// PProf serves net/http/pprof under /debug/pprof/.
+	PProf bool `json:"pprof,omitempty"`
+	// Operators are identified by their bearer token, every request needs
+	// the token of one. Bans and unbans record the operator. At least one
+	// is required.
+	Operators []Operator `json:"operators"`
+	// IdentityHeader is the header of the user authenticated by a proxy in
+	// front of the listener, e.g. "X-Forwarded-User" with the OIDC subject.
+	// It replaces the operator name of requests with a token from
+	// TrustedProxies, and is ignored from other addresses.
+	IdentityHeader string `json:"identity_header,omitempty"`
+	// TrustedProxies are the ips or cidrs of the proxies setting
+	// IdentityHeader, required with it.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+}
+
+type Operator struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+// Runtime tunes the go runtime for small devices, zero values keep the
+// defaults.
+type Runtime struct {
+	GOMAXPROCS int `json:"gomaxprocs,omitempty"`
+	// GCPercent is passed to debug.SetGCPercent.
+	GCPercent int `json:"gc_percent,omitempty"`
+	// MemoryLimit is the soft memory limit, e.g. "200MiB".
+	MemoryLimit string `json:"memory_limit,omitempty"`
+}
+
+type Forgivable struct {
+	Duration    Duration `json:"duration"`
+	Count       int      `json:"count"`
+	BanInMinute int      `json:"ban_in_minute"`
+	// Warn logs a "ban-warning" event when the next error bans the ip.
+	Warn bool `json:"warn,omitempty"`
+}
+
+type Geo struct {
+	CityDB        string `json:"city_db"`
+	UpdatedCityDB string `json:"updated_city_db"`
+	ASNDB         string `json:"asn_db"`
+	UpdatedASNDB  string `json:"updated_asn_db"`
+	Language      string `json:"language,omitempty"`
+}
+
+type Metrics struct {
+	Listen string `json:"listen"`
+	TLS    *TLS   `json:"tls,omitempty"`
+}
+
+// TLS of a listener, ClientCAFile enables mTLS.
+type TLS struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+// Duration is a time.Duration in json as string, e.g. "10m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"10m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
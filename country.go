@@ -0,0 +1,179 @@
+package firewall
+
+import (
+	"context"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	crawlerLookupTimeout = 10 * time.Second
+	// crawlerWorkers verify crawlers, up to crawlerQueue ips wait for them.
+	crawlerWorkers = 4
+	crawlerQueue   = 256
+)
+
+// countryBan returns the ban reason of an error of ip if its country is
+// banned, empty if not.
+func (s *Firewall) countryBan(ip string, ec *errorCounter) string {
+	geo := s.errorGeo(ip, ec)
+	if geo == nil || !s.banCountries[geo.CountryISO] {
+		return ""
+	}
+	return "country " + geo.CountryISO
+}
+
+// CountryAllowlist bans ips outside the allowed countries on their first
+// error, for deployments serving only their own country. Ips of unknown
+// country, e.g. private ones, are not banned. It requires the geo
+// database.
+type CountryAllowlist struct {
+	// Countries are the ISO codes of the allowed countries.
+	Countries []string
+	// CrawlerDomains exempt verified crawlers: ips whose reverse dns name
+	// ends with one of the domains, e.g. ".googlebot.com", and resolves
+	// back to the ip.
+	CrawlerDomains []string
+	// Resolver looks up crawlers, net.DefaultResolver if nil.
+	Resolver Resolver
+}
+
+// Resolver is the part of *net.Resolver verifying crawlers.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// crawlerState is whether the ip of an error counter is a verified
+// crawler.
+type crawlerState int
+
+const (
+	crawlerUnknown crawlerState = iota
+	crawlerVerifying
+	crawlerVerified
+	crawlerRejected
+)
+
+// countryNotAllowed returns the ban reason of an error of ip if its
+// country is not allowed, empty if it is allowed or unknown.
+func (s *Firewall) countryNotAllowed(ip string, ec *errorCounter) string {
+	geo := s.errorGeo(ip, ec)
+	if geo == nil || geo.CountryISO == "" || s.allowCountries[geo.CountryISO] {
+		return ""
+	}
+	return "country " + geo.CountryISO + " not allowed"
+}
+
+// allowlistBan returns the ban reason of an error of ip outside the allowed
+// countries, empty if the error is counted as usual. Crawlers are verified
+// in the background meanwhile, ips failing the verification are banned
+// then. Ips are banned unverified when too many wait for the verification,
+// e.g. in a flood.
+func (s *Firewall) allowlistBan(ip string, ec *errorCounter, minutes int) string {
+	reason := s.countryNotAllowed(ip, ec)
+	if reason == "" || len(s.allowlist.CrawlerDomains) == 0 {
+		return reason
+	}
+
+	switch ec.crawler {
+	case crawlerRejected:
+		return reason
+	case crawlerUnknown:
+		// the counter of an ip forgiven while verifying
+		if _, ok := s.crawlerChecks[ip]; ok {
+			ec.crawler = crawlerVerifying
+			return ""
+		}
+		select {
+		case s.crawlerCh <- ip:
+		default:
+			return reason
+		}
+		s.crawlerChecks[ip] = &crawlerCheck{reason: reason, minutes: minutes}
+		ec.crawler = crawlerVerifying
+	}
+	return ""
+}
+
+// crawlerCheck is the ban of an ip whose crawler verification is pending.
+type crawlerCheck struct {
+	reason  string
+	minutes int
+}
+
+// startCrawlerChecks starts the goroutines verifying crawlers for
+// allowlist.
+func (s *Firewall) startCrawlerChecks() {
+	s.crawlerCh = make(chan string, crawlerQueue)
+	s.crawlerChecks = map[string]*crawlerCheck{}
+	for range crawlerWorkers {
+		go func() {
+			for ip := range s.crawlerCh {
+				verified := s.allowlist.verifyCrawler(ip)
+				s.do(func() {
+					s.crawlerChecked(ip, verified)
+				})
+			}
+		}()
+	}
+}
+
+// crawlerChecked applies the verification of ip to its current error
+// counter, it may have been replaced meanwhile, e.g. by ForgiveIP.
+func (s *Firewall) crawlerChecked(ip string, verified bool) {
+	c := s.crawlerChecks[ip]
+	delete(s.crawlerChecks, ip)
+	ec := s.errorCount[ip]
+	if c == nil || ec == nil {
+		return
+	}
+	if verified {
+		ec.crawler = crawlerVerified
+		return
+	}
+
+	ec.crawler = crawlerRejected
+	now := s.now()
+	if s.inWhitelist(ip) || ec.bannedUntil.After(now) {
+		return
+	}
+	ec.offenses.Offer(Offense{Time: now, Reason: c.reason})
+	s.banCounted(ip, ec, c.minutes, BanActionBlock, now)
+}
+
+// verifyCrawler returns whether the reverse dns name of ip is in a crawler
+// domain and resolves back to ip.
+func (a *CountryAllowlist) verifyCrawler(ip string) bool {
+	var r Resolver = a.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), crawlerLookupTimeout)
+	defer cancel()
+
+	want := net.ParseIP(ip)
+	names, err := r.LookupAddr(ctx, ip)
+	if err != nil {
+		log.Printf("reverse lookup of %s failed: %v", ip, err)
+		return false
+	}
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		if !slices.ContainsFunc(a.CrawlerDomains, func(d string) bool { return strings.HasSuffix(name, d) }) {
+			continue
+		}
+		addrs, err := r.LookupHost(ctx, name)
+		if err != nil {
+			log.Printf("lookup of crawler %s failed: %v", name, err)
+			continue
+		}
+		if slices.ContainsFunc(addrs, func(addr string) bool { return net.ParseIP(addr).Equal(want) }) {
+			return true
+		}
+	}
+	return false
+}
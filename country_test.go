@@ -0,0 +1,235 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+func TestBanCountries(t *testing.T) {
+	db, err := ipgeo.NewAutoUpdateMMIPGeo("ipgeo/test-data/GeoLite2-City-Test.mmdb", "ipgeo/test-data/GeoLite2-City-Test.mmdb",
+		"ipgeo/test-data/GeoLite2-ASN-Test.mmdb", "ipgeo/test-data/GeoLite2-ASN-Test.mmdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{}, mockFW, mockLogger, db, forgivable, WithBanCountries("gb"), WithCountErrorGeo(false))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.LogIPError("1.0.0.1", "r")
+	fw.LogIPError("81.2.69.160", "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, "count error", mockLogger.Events[0].Action)
+	e := mockLogger.Events[1]
+	assert.Equal(t, "ban", e.Action)
+	assert.Equal(t, []string{"r", "country GB"}, e.Reasons)
+	assert.Equal(t, []string{"81.2.69.160"}, mockFW.BannedIPs)
+}
+
+type mockResolver struct {
+	names map[string][]string
+	hosts map[string][]string
+}
+
+func (r *mockResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.names[addr], nil
+}
+
+func (r *mockResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.hosts[host], nil
+}
+
+func TestCountryAllowlist(t *testing.T) {
+	db, err := ipgeo.NewAutoUpdateMMIPGeo("ipgeo/test-data/GeoLite2-City-Test.mmdb", "ipgeo/test-data/GeoLite2-City-Test.mmdb",
+		"ipgeo/test-data/GeoLite2-ASN-Test.mmdb", "ipgeo/test-data/GeoLite2-ASN-Test.mmdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{"89.160.20.0/24"}, mockFW, mockLogger, db, forgivable,
+		WithCountryAllowlist(CountryAllowlist{Countries: []string{"gb"}}), WithCountErrorGeo(false))
+	require.NoError(t, err)
+
+	// unknown and whitelisted ips are not banned
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("81.2.69.160", "r")
+	fw.LogIPError("1.0.0.1", "r")
+	fw.LogIPError("89.160.20.112", "r")
+	fw.LogIPError("216.160.83.56", "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, "count error", mockLogger.Events[0].Action)
+	assert.Equal(t, "count error", mockLogger.Events[1].Action)
+	e := mockLogger.Events[2]
+	assert.Equal(t, "ban", e.Action)
+	assert.Equal(t, []string{"r", "country US not allowed"}, e.Reasons)
+	assert.Equal(t, []string{"216.160.83.56"}, mockFW.BannedIPs)
+}
+
+func TestCountryAllowlist_Crawlers(t *testing.T) {
+	db, err := ipgeo.NewAutoUpdateMMIPGeo("ipgeo/test-data/GeoLite2-City-Test.mmdb", "ipgeo/test-data/GeoLite2-City-Test.mmdb",
+		"ipgeo/test-data/GeoLite2-ASN-Test.mmdb", "ipgeo/test-data/GeoLite2-ASN-Test.mmdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	resolver := &mockResolver{
+		names: map[string][]string{
+			"89.160.20.112": {"crawl-1.googlebot.com."},
+			"89.160.20.128": {"crawl-2.googlebot.com."},
+		},
+		hosts: map[string][]string{
+			"crawl-1.googlebot.com": {"89.160.20.112"},
+			// forged reverse name
+			"crawl-2.googlebot.com": {"66.249.66.1"},
+		},
+	}
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{}, mockFW, mockLogger, db, forgivable, WithCountErrorGeo(false),
+		WithCountryAllowlist(CountryAllowlist{Countries: []string{"GB"}, CrawlerDomains: []string{".googlebot.com"}, Resolver: resolver}))
+	require.NoError(t, err)
+
+	// errors are counted while verifying, the forged crawler is banned then
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("89.160.20.112", "r")
+	fw.LogIPError("89.160.20.128", "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, "count error", mockLogger.Events[0].Action)
+	assert.Equal(t, "count error", mockLogger.Events[1].Action)
+	e := mockLogger.Events[2]
+	assert.Equal(t, "ban", e.Action)
+	assert.Equal(t, "89.160.20.128", e.IP)
+	assert.Equal(t, []string{"r", "country SE not allowed"}, e.Reasons)
+	assert.Equal(t, mockLogger.Events[1].CorrelationID, e.CorrelationID)
+
+	// the verified crawler is counted as usual
+	mockLogger.Wg.Add(1)
+	fw.LogIPError("89.160.20.112", "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "count error", mockLogger.Events[3].Action)
+	assert.Equal(t, []string{"89.160.20.128"}, mockFW.BannedIPs)
+}
+
+// blockingResolver resolves no crawler, lookups wait until release is
+// closed.
+type blockingResolver struct {
+	lookups atomic.Int32
+	release chan struct{}
+}
+
+func (r *blockingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.lookups.Add(1)
+	<-r.release
+	return nil, nil
+}
+
+func (r *blockingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return nil, nil
+}
+
+func TestCountryAllowlist_CrawlerForgiven(t *testing.T) {
+	geo := ipgeo.StaticProvider{"192.0.2.0/24": {CountryISO: "KP"}}
+	resolver := &blockingResolver{release: make(chan struct{})}
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{}, mockFW, mockLogger, geo, forgivable, WithCountErrorGeo(false),
+		WithCountryAllowlist(CountryAllowlist{Countries: []string{"GB"}, CrawlerDomains: []string{".googlebot.com"}, Resolver: resolver}))
+	require.NoError(t, err)
+
+	// the ip is forgiven while verifying, the result applies to its new
+	// counter without a second lookup
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("192.0.2.7", "r")
+	require.NoError(t, fw.ForgiveIP("192.0.2.7", false))
+	fw.LogIPError("192.0.2.7", "r2")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "forgiven", mockLogger.Events[1].Action)
+
+	mockLogger.Wg.Add(1)
+	close(resolver.release)
+	mockLogger.Wg.Wait()
+	e := mockLogger.Events[3]
+	assert.Equal(t, "ban", e.Action)
+	assert.Equal(t, []string{"r2", "country KP not allowed"}, e.Reasons)
+	assert.Equal(t, int32(1), resolver.lookups.Load())
+}
+
+func TestCountryAllowlist_CrawlerQueueFull(t *testing.T) {
+	geo := ipgeo.StaticProvider{"10.0.0.0/16": {CountryISO: "KP"}}
+	resolver := &blockingResolver{release: make(chan struct{})}
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{}, mockFW, mockLogger, geo, forgivable, WithCountErrorGeo(false),
+		WithCountryAllowlist(CountryAllowlist{Countries: []string{"GB"}, CrawlerDomains: []string{".googlebot.com"}, Resolver: resolver}))
+	require.NoError(t, err)
+
+	n := crawlerWorkers + crawlerQueue
+	mockLogger.Wg.Add(n)
+	for i := range n {
+		fw.LogIPError(fmt.Sprintf("10.0.%d.%d", i/256, i%256), "r")
+		if i == crawlerWorkers-1 {
+			require.Eventually(t, func() bool { return resolver.lookups.Load() == crawlerWorkers }, time.Second, time.Millisecond)
+		}
+	}
+	mockLogger.Wg.Wait()
+
+	// the ips are banned unverified once the queue is full
+	mockLogger.Wg.Add(1)
+	fw.LogIPError("10.0.255.1", "r")
+	mockLogger.Wg.Wait()
+	e := mockLogger.Events[n]
+	assert.Equal(t, "ban", e.Action)
+	assert.Equal(t, "10.0.255.1", e.IP)
+	assert.Equal(t, int32(crawlerWorkers), resolver.lookups.Load())
+
+	// the queued ips are verified then
+	mockLogger.Wg.Add(n)
+	close(resolver.release)
+	mockLogger.Wg.Wait()
+	assert.Len(t, mockFW.BannedIPs, n+1)
+}
+
+func TestBanCountries_StaticGeo(t *testing.T) {
+	geo := ipgeo.StaticProvider{"192.0.2.0/24": {CountryISO: "KP"}}
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{}, mockFW, mockLogger, geo, forgivable, WithBanCountries("KP"))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.LogIPError("192.0.2.7", "r")
+	mockLogger.Wg.Wait()
+
+	e := mockLogger.Events[0]
+	assert.Equal(t, "ban", e.Action)
+	assert.Equal(t, []string{"r", "country KP"}, e.Reasons)
+	assert.Equal(t, "192.0.2.7", e.Geo.IP)
+}
+
+func TestNew_NilGeoDatabase(t *testing.T) {
+	var db *ipgeo.AutoUpdateMMIPGeo
+	fw, err := New([]string{}, &MockIFirewall{}, &MockEventLogger{}, db, testForgivable, WithBanCountries("KP"))
+	require.NoError(t, err)
+	assert.Nil(t, fw.ipGeo)
+
+	_, err = fw.UnbanASN(64496)
+	assert.Error(t, err)
+}
@@ -0,0 +1,79 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Credentials authenticate the calls to a backend.
+type Credentials struct {
+	User string `json:"user"`
+	Pass string `json:"pass"`
+}
+
+// ICredentialProvider returns the current credentials of a backend. The
+// backends ask for them on every call, so rotated credentials are picked up
+// without a restart.
+type ICredentialProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// StaticCredentials never change.
+type StaticCredentials Credentials
+
+func (c StaticCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	return Credentials(c), nil
+}
+
+// CredentialsFunc adapts a callback, e.g. fetching a secret manager, to
+// ICredentialProvider.
+type CredentialsFunc func(ctx context.Context) (Credentials, error)
+
+func (f CredentialsFunc) Credentials(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// CredentialsFile reads the credentials from a json file, e.g.
+// {"user": "key", "pass": "secret"}, and reads it again when it is modified.
+type CredentialsFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	creds   Credentials
+}
+
+func NewCredentialsFile(path string) *CredentialsFile {
+	return &CredentialsFile{path: path}
+}
+
+func (f *CredentialsFile) Credentials(ctx context.Context) (Credentials, error) {
+	st, err := os.Stat(f.path)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if st.ModTime().Equal(f.modTime) && st.Size() == f.size {
+		return f.creds, nil
+	}
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	c := Credentials{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Credentials{}, fmt.Errorf("decode credentials %s failed: %w", f.path, err)
+	}
+	f.creds = c
+	f.modTime = st.ModTime()
+	f.size = st.Size()
+	return c, nil
+}
@@ -0,0 +1,30 @@
+package firewall
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "opn.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"user": "key", "pass": "secret-1"}`), 0o600))
+
+	f := NewCredentialsFile(path)
+	c, err := f.Credentials(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{User: "key", Pass: "secret-1"}, c)
+
+	// rotated
+	require.NoError(t, os.WriteFile(path, []byte(`{"user": "key", "pass": "secret-22"}`), 0o600))
+	c, err = f.Credentials(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "secret-22", c.Pass)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{`), 0o600))
+	_, err = f.Credentials(t.Context())
+	assert.Error(t, err)
+}
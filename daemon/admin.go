@@ -0,0 +1,209 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/adminapi"
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/internal/httpapi"
+	"github.com/charleshuang3/firewall/mitigation"
+)
+
+// newAdminServer serves the routes of adminapi and the daemon ones to the
+// operators of c, the admin listener does not start without one.
+func (d *Daemon) newAdminServer(c *config.Admin) (*server, error) {
+	operators := []adminapi.Operator{}
+	for _, o := range c.Operators {
+		operators = append(operators, adminapi.Operator{Name: o.Name, Token: o.Token})
+	}
+	auth, err := adminapi.NewAuth(operators, c.IdentityHeader, c.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("admin: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	adminapi.Register(mux, d.adminFirewall)
+	mux.HandleFunc("GET /debug/diagnostics", d.handleDiagnostics)
+	mux.HandleFunc("GET /debug/quotas", d.handleQuotas)
+	mux.HandleFunc("GET /v1/mitigation/{format}", d.handleMitigation)
+	mux.HandleFunc("POST /v1/ban", d.handleAdminBan)
+	mux.HandleFunc("POST /v1/forgive", d.handleForgive)
+	mux.HandleFunc("GET /v1/pause", d.handlePaused)
+	mux.HandleFunc("POST /v1/pause", d.handlePause)
+	mux.HandleFunc("POST /v1/resume", d.handleResume)
+	mux.HandleFunc("POST /v1/heartbeat", d.handleHeartbeat)
+
+	if c.PProf {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	return &server{
+		name: "admin",
+		srv: &http.Server{
+			Addr:              c.Listen,
+			Handler:           auth.Wrap(mux),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+func (d *Daemon) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	httpapi.WriteJSON(w, d.fw.Diagnostics())
+}
+
+// handleMitigation downloads the jail as a mitigation artifact for upstream
+// providers, the format is "acl", "cloudflare" or "cidr". The optional
+// "slack" and "max" parameters trade exactness for fewer prefixes, see
+// mitigation.Options.
+func (d *Daemon) handleMitigation(w http.ResponseWriter, r *http.Request) {
+	f, ok := mitigation.Formats[r.PathValue("format")]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown format %q", r.PathValue("format")), http.StatusNotFound)
+		return
+	}
+	o := mitigation.Options{}
+	if v := r.URL.Query().Get("slack"); v != "" {
+		slack, err := strconv.ParseFloat(v, 64)
+		if err != nil || slack < 0 || slack >= 1 {
+			http.Error(w, fmt.Sprintf("invalid slack %q", v), http.StatusBadRequest)
+			return
+		}
+		o.Slack = slack
+	}
+	if v := r.URL.Query().Get("max"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid max %q", v), http.StatusBadRequest)
+			return
+		}
+		o.MaxEntries = n
+	}
+	fw := d.adminFirewall(w, r)
+	if fw == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "firewall-"+f.Filename))
+	if err := f.Write(w, fw.ListBans(), o); err != nil {
+		log.Printf("write %s failed: %v", f.Filename, err)
+	}
+}
+
+type adminBanRequest struct {
+	banRequest
+	Ticket string `json:"ticket,omitempty"`
+}
+
+// handleAdminBan bans an ip like the ingest api, the ban records the
+// operator and the ticket.
+func (d *Daemon) handleAdminBan(w http.ResponseWriter, r *http.Request) {
+	fw := d.adminFirewall(w, r)
+	if fw == nil {
+		return
+	}
+	req := &adminBanRequest{}
+	if !httpapi.Decode(w, r, req) || !httpapi.ValidIP(w, &req.IP) {
+		return
+	}
+	if req.Minutes <= 0 {
+		http.Error(w, "minutes should be positive", http.StatusBadRequest)
+		return
+	}
+
+	fw.BanIPBy(req.IP, req.Minutes, req.Reason, firewall.Annotation{Operator: adminapi.OperatorOf(r), Ticket: req.Ticket})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type forgiveRequest struct {
+	IP string `json:"ip"`
+	// Unban also unbans the ip if it is banned.
+	Unban bool `json:"unban,omitempty"`
+}
+
+// handleForgive forgets the errors of an ip, see firewall.ForgiveIP.
+func (d *Daemon) handleForgive(w http.ResponseWriter, r *http.Request) {
+	fw := d.adminFirewall(w, r)
+	if fw == nil {
+		return
+	}
+	req := &forgiveRequest{}
+	if !httpapi.Decode(w, r, req) || !httpapi.ValidIP(w, &req.IP) {
+		return
+	}
+
+	if err := fw.ForgiveIP(req.IP, req.Unban); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// adminFirewall returns the firewall of the "tenant" query parameter, it
+// writes 404 if the tenant is unknown.
+func (d *Daemon) adminFirewall(w http.ResponseWriter, r *http.Request) *firewall.Firewall {
+	name := r.URL.Query().Get("tenant")
+	fw := d.tenantFirewall(name)
+	if fw == nil {
+		http.Error(w, fmt.Sprintf("unknown tenant %q", name), http.StatusNotFound)
+	}
+	return fw
+}
+
+type pauseRequest struct {
+	Reason string `json:"reason"`
+}
+
+// handlePaused reports whether enforcement is paused.
+func (d *Daemon) handlePaused(w http.ResponseWriter, r *http.Request) {
+	if fw := d.adminFirewall(w, r); fw != nil {
+		httpapi.WriteJSON(w, map[string]bool{"paused": fw.Paused()})
+	}
+}
+
+// handlePause pauses enforcement until resumed.
+func (d *Daemon) handlePause(w http.ResponseWriter, r *http.Request) {
+	fw := d.adminFirewall(w, r)
+	if fw == nil {
+		return
+	}
+	req := &pauseRequest{}
+	if !httpapi.DecodeOptional(w, r, req) {
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "paused by admin"
+	}
+
+	fw.Pause(req.Reason)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleResume resumes enforcement paused by the safety valve, the admin or
+// the dead man's switch.
+func (d *Daemon) handleResume(w http.ResponseWriter, r *http.Request) {
+	if fw := d.adminFirewall(w, r); fw != nil {
+		fw.Resume()
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleHeartbeat feeds the dead man's switch of all firewalls, the
+// config source is shared by the tenants.
+func (d *Daemon) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	d.fw.Heartbeat()
+	for _, t := range d.tenants {
+		t.fw.Heartbeat()
+	}
+	w.WriteHeader(http.StatusOK)
+}
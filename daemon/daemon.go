@@ -0,0 +1,799 @@
+// Package daemon runs a Firewall as a service: applications report errors
+// and bans over an http ingest api, metrics are served on a dedicated
+// listener.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	zlog "github.com/rs/zerolog"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/blocklist"
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/export"
+	"github.com/charleshuang3/firewall/gcplog"
+	"github.com/charleshuang3/firewall/history"
+	"github.com/charleshuang3/firewall/ipgeo"
+	"github.com/charleshuang3/firewall/luahook"
+	"github.com/charleshuang3/firewall/metrics"
+	"github.com/charleshuang3/firewall/misp"
+	"github.com/charleshuang3/firewall/opn"
+	"github.com/charleshuang3/firewall/peer"
+	"github.com/charleshuang3/firewall/pf"
+	"github.com/charleshuang3/firewall/recipe"
+	"github.com/charleshuang3/firewall/ros"
+	"github.com/charleshuang3/firewall/secrets"
+	"github.com/charleshuang3/firewall/stuffing"
+	"github.com/charleshuang3/firewall/wal"
+	"github.com/charleshuang3/firewall/wasmpolicy"
+	"github.com/charleshuang3/firewall/zerolog"
+)
+
+const (
+	serviceName     = "firewalld"
+	shutdownTimeout = 10 * time.Second
+
+	defaultMISPInterval = 15 * time.Minute
+	walReplayInterval   = time.Minute
+
+	// blocklists are updated a few times a day, AbuseIPDB allows 5
+	// downloads a day on its free plan.
+	defaultBlocklistInterval = 6 * time.Hour
+	defaultBlocklistBan      = 7 * 24 * 60
+)
+
+type Daemon struct {
+	fw      *firewall.Firewall
+	metrics *metrics.Metrics
+	history *history.Store
+	// feed serves the signed ban feed, nil if not configured.
+	feed     http.Handler
+	consumer *peer.Consumer
+	// taxii serves bans as STIX indicators, nil if not configured.
+	taxii http.Handler
+	// export serves the jail as a plain-text list, nil if not configured.
+	export     http.Handler
+	mispPuller *misp.Puller
+	blocklists []*blocklist.Importer
+	tenants    []*tenant
+	quotas     *quotas
+	wal        *wal.Backend
+	policy     firewall.IPolicy
+	banFilter  firewall.IBanFilter
+	durations  *firewall.DurationPolicy
+	asnPolicy  *firewall.ASNPolicy
+	warmUp     bool
+	// valve is shared by the firewalls of the daemon and the tenants, nil if
+	// not configured.
+	valve *firewall.SharedValve
+	// reasonForgivable are the thresholds by reason, of the jails too.
+	reasonForgivable map[string]firewall.ForgivableError
+	// recipes are of the jails, by name.
+	recipes map[string]*recipe.Recipe
+	// ingestToken is required by the ingest api without tenants if set.
+	ingestToken string
+	// ha elects the leader, nil if not configured.
+	ha         *haState
+	servers    []*server
+	supervisor *supervisor
+	// closers are called in reverse order on shutdown.
+	closers []func()
+}
+
+type server struct {
+	name string
+	srv  *http.Server
+	tls  bool
+}
+
+func New(c *config.Config) (*Daemon, error) {
+	dc := c.Daemon
+	if dc == nil {
+		return nil, errors.New("no daemon section in config")
+	}
+
+	if dc.Runtime != nil {
+		if err := tuneRuntime(dc.Runtime); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkIngestAuth(dc); err != nil {
+		return nil, err
+	}
+
+	sup, err := newSupervisor(dc.Supervisor)
+	if err != nil {
+		return nil, err
+	}
+	d := &Daemon{warmUp: dc.WarmUp, supervisor: sup, ingestToken: dc.IngestToken}
+	ok := false
+	defer func() {
+		if !ok {
+			d.close()
+		}
+	}()
+
+	// a mirror has no backend, nothing to write ahead or to lead
+	var fw Backend
+	if dc.Mirror {
+		log.Println("mirror mode, bans are not sent to the backend")
+	} else if fw, err = newBackends(c, dc); err != nil {
+		return nil, err
+	}
+	// the follower does not replay the wal to the backend either
+	if dc.HA != nil && fw != nil {
+		if fw, err = d.setupHA(dc.HA, fw); err != nil {
+			return nil, err
+		}
+	}
+	if dc.WAL != "" && fw != nil {
+		w, err := wal.Open(dc.WAL, fw)
+		if err != nil {
+			return nil, err
+		}
+		d.closers = append(d.closers, func() { w.Close() })
+		d.wal = w
+		fw = w
+	}
+
+	loggers := firewall.MultiLogger{}
+	var gcp *gcplog.Logger
+	switch dc.Logger {
+	case "", "zerolog":
+		loggers = append(loggers, zerolog.New(zlog.New(os.Stdout).With().Timestamp().Logger(), zlog.InfoLevel, serviceName))
+	case "gcplog":
+		if c.GCPLog == nil {
+			return nil, errors.New("no gcplog section in config")
+		}
+		opts := []gcplog.Option{gcplog.WithDropHook(logDrops("gcplog"))}
+		if c.GCPLog.QueueSize != 0 {
+			opts = append(opts, gcplog.WithQueueSize(c.GCPLog.QueueSize))
+		}
+		l, err := gcplog.New(c.GCPLog.AuthFile, c.GCPLog.ProjectID, c.GCPLog.Service, opts...)
+		if err != nil {
+			return nil, err
+		}
+		d.closers = append(d.closers, l.Close)
+		loggers = append(loggers, l)
+		gcp = l
+	default:
+		return nil, fmt.Errorf("unknown logger %q", dc.Logger)
+	}
+
+	if c.History != "" {
+		h, err := history.Open(c.History)
+		if err != nil {
+			return nil, err
+		}
+		d.closers = append(d.closers, func() { h.Close() })
+		loggers = append(loggers, h)
+		d.history = h
+	}
+
+	var mispClient *misp.Client
+	if m := c.MISP; m != nil {
+		mispClient = misp.NewClient(m.URL, m.Key, m.RatePerMinute)
+		if m.EventID != "" {
+			p := misp.NewPusher(mispClient, m.EventID, m.Tags, m.DefaultTags)
+			d.closers = append(d.closers, p.Close)
+			loggers = append(loggers, p)
+		}
+	}
+
+	var geo *ipgeo.AutoUpdateMMIPGeo
+	if g := dc.Geo; g != nil {
+		opts := []ipgeo.Option{}
+		if g.Language != "" {
+			opts = append(opts, ipgeo.WithLanguage(g.Language))
+		}
+		geo, err = ipgeo.NewAutoUpdateMMIPGeo(g.CityDB, g.UpdatedCityDB, g.ASNDB, g.UpdatedASNDB, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("open geo db failed: %w", err)
+		}
+		d.closers = append(d.closers, geo.Close)
+	}
+
+	if m := dc.Metrics; m != nil {
+		d.metrics = metrics.New()
+		if gcp != nil {
+			d.metrics.WatchLogQueue("gcplog", gcp)
+		}
+		if geo != nil {
+			d.metrics.WatchGeo(geo)
+		}
+		loggers = append(loggers, d.metrics)
+
+		var tc *metrics.TLSConfig
+		if m.TLS != nil {
+			tc = &metrics.TLSConfig{
+				CertFile:     m.TLS.CertFile,
+				KeyFile:      m.TLS.KeyFile,
+				ClientCAFile: m.TLS.ClientCAFile,
+			}
+		}
+		srv, err := metrics.NewServer(m.Listen, d.metrics.Handler(), tc)
+		if err != nil {
+			return nil, err
+		}
+		d.servers = append(d.servers, &server{name: "metrics", srv: srv, tls: tc != nil})
+	}
+
+	if len(dc.Tenants) > 0 && fw != nil {
+		fw = &sharedBackend{b: fw}
+	}
+
+	if dc.WASMPolicy != "" && dc.LuaScript != "" {
+		return nil, errors.New("wasm_policy and lua_script both decide errors, configure one")
+	}
+	if dc.LuaScript != "" {
+		s, err := luahook.Open(dc.LuaScript)
+		if err != nil {
+			return nil, err
+		}
+		d.closers = append(d.closers, s.Close)
+		d.policy = s
+		d.banFilter = s
+		loggers = append(loggers, s)
+	}
+	if dc.WASMPolicy != "" {
+		p, err := wasmpolicy.Open(dc.WASMPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("load wasm policy failed: %w", err)
+		}
+		d.closers = append(d.closers, func() { p.Close() })
+		d.policy = p
+	}
+	if du := dc.Durations; du != nil {
+		if geo == nil {
+			return nil, errors.New("durations requires the geo database")
+		}
+		d.durations = &firewall.DurationPolicy{
+			ASNs:          du.ASNs,
+			Organizations: du.Organizations,
+			Countries:     du.Countries,
+			Proxy:         du.Proxy,
+			Satellite:     du.Satellite,
+		}
+	}
+
+	if a := dc.ASN; a != nil {
+		if geo == nil {
+			return nil, errors.New("asn requires the geo database")
+		}
+		d.asnPolicy = &firewall.ASNPolicy{
+			MaxIPs:     a.MaxIPs,
+			Window:     time.Duration(a.Window),
+			Escalation: time.Duration(a.Escalation),
+		}
+	}
+
+	if len(dc.BanCountries) > 0 && geo == nil {
+		return nil, errors.New("ban_countries requires the geo database")
+	}
+	if dc.AllowCountries != nil && geo == nil {
+		return nil, errors.New("allow_countries requires the geo database")
+	}
+	if len(dc.WhitelistCountries) > 0 && geo == nil {
+		return nil, errors.New("whitelist_countries requires the geo database")
+	}
+	if len(dc.WhitelistAS) > 0 && geo == nil {
+		return nil, errors.New("whitelist_as requires the geo database")
+	}
+
+	if err := d.setupJails(dc); err != nil {
+		return nil, err
+	}
+
+	if dc.Quota != nil || hasTenantQuota(dc.Tenants) {
+		d.quotas = newQuotas(dc.Quota)
+	}
+	if dc.GlobalMaxBanPerMinute > 0 {
+		d.valve = firewall.NewSharedValve(dc.GlobalMaxBanPerMinute)
+	}
+	opts := []firewall.Option{}
+	if dc.MaxBanPerMinute > 0 {
+		opts = append(opts, firewall.WithMaxBanRate(dc.MaxBanPerMinute))
+	}
+	if d.history != nil {
+		opts = append(opts, firewall.WithStateStore(d.history))
+	}
+	opts = append(opts, d.firewallOptions(dc)...)
+	if d.fw, err = firewall.New(dc.Whitelist, fw, loggers, geo, newForgivable(&dc.Forgivable), opts...); err != nil {
+		return nil, err
+	}
+	if d.metrics != nil {
+		d.metrics.Watch("", d.fw)
+	}
+
+	if err := d.setupTenants(dc, fw, loggers, geo); err != nil {
+		return nil, err
+	}
+
+	if d.ha != nil {
+		if err := d.setupMirror(dc.HA); err != nil {
+			return nil, err
+		}
+	}
+
+	if dc.Feed != nil {
+		if err := d.setupFeed(dc.Feed); err != nil {
+			return nil, err
+		}
+	}
+
+	if m := c.MISP; m != nil && m.Pull != nil {
+		interval := time.Duration(m.Pull.Interval)
+		if interval <= 0 {
+			interval = defaultMISPInterval
+		}
+		d.mispPuller = misp.NewPuller(mispClient, d.fw, m.Pull.Tags, m.Pull.Last, m.Pull.BanInMinute, interval)
+	}
+
+	if e := dc.Export; e != nil {
+		d.export = tokenHandler(e.Token, export.Handler(d.fw))
+	}
+
+	for _, b := range dc.Blocklists {
+		url := b.URL
+		if url == "" {
+			url = blocklist.Known[b.Name]
+		}
+		if b.Name == "" || url == "" {
+			return nil, fmt.Errorf("blocklist %q requires name and url", b.Name)
+		}
+		interval := time.Duration(b.Interval)
+		if interval <= 0 {
+			interval = defaultBlocklistInterval
+		}
+		minutes := b.BanInMinute
+		if minutes <= 0 {
+			minutes = defaultBlocklistBan
+		}
+		d.blocklists = append(d.blocklists, blocklist.NewImporter(b.Name, url, b.Key, d.fw, minutes, interval))
+	}
+
+	if dc.TAXII != nil {
+		if err := d.setupTAXII(dc.TAXII); err != nil {
+			return nil, err
+		}
+	}
+
+	if dc.Listen != "" {
+		d.servers = append(d.servers, &server{
+			name: "ingest",
+			srv: &http.Server{
+				Addr:              dc.Listen,
+				Handler:           d.ingestHandler(),
+				ReadHeaderTimeout: 10 * time.Second,
+			},
+		})
+	}
+
+	if dc.Admin != nil {
+		srv, err := d.newAdminServer(dc.Admin)
+		if err != nil {
+			return nil, err
+		}
+		d.servers = append(d.servers, srv)
+	}
+
+	ok = true
+	return d, nil
+}
+
+// Backend is implemented by all backends.
+type Backend interface {
+	firewall.IErrorFirewall
+	firewall.IUnbanFirewall
+}
+
+// ConfiguredBackends returns every backend configured in c, built like the
+// backends of the daemon with their credentials, alias chunks and action
+// lists, e.g. to unban ips everywhere.
+func ConfiguredBackends(c *config.Config) ([]Backend, error) {
+	res := []Backend{}
+	for _, it := range []struct {
+		name string
+		ok   bool
+	}{{"opn", c.OPN != nil}, {"pf", c.PF != nil}, {"ros", c.ROS != nil}} {
+		if !it.ok {
+			continue
+		}
+		b, err := newBackend(c, it.name, nil)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, b)
+	}
+	return res, nil
+}
+
+// newBackends returns the backend of dc, a MultiFirewall if it has many, in
+// a Router if it has routes.
+func newBackends(c *config.Config, dc *config.Daemon) (Backend, error) {
+	if dc.Backend != "" && len(dc.Backends) > 0 {
+		return nil, errors.New("backend and backends both configured, configure one")
+	}
+
+	// backends are shared by routes and the default backend.
+	created := map[string]Backend{}
+	get := func(name string) (Backend, error) {
+		if b, ok := created[name]; ok {
+			return b, nil
+		}
+		b, err := newBackend(c, name, dc.Retry)
+		if err != nil {
+			return nil, err
+		}
+		created[name] = b
+		return b, nil
+	}
+
+	var fallback Backend
+	switch {
+	case len(dc.Backends) > 0:
+		backends := []firewall.IFirewall{}
+		for _, name := range dc.Backends {
+			b, err := get(name)
+			if err != nil {
+				return nil, err
+			}
+			backends = append(backends, b)
+		}
+		fallback = firewall.NewMultiFirewall(backends...)
+	case dc.Backend != "" || len(dc.Routes) == 0:
+		b, err := get(dc.Backend)
+		if err != nil {
+			return nil, err
+		}
+		fallback = b
+	}
+	if len(dc.Routes) == 0 {
+		return fallback, nil
+	}
+
+	routes := []firewall.Route{}
+	for _, r := range dc.Routes {
+		b, err := get(r.Backend)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, firewall.Route{Prefix: r.Prefix, Backend: b})
+	}
+	return firewall.NewRouter(fallback, routes...), nil
+}
+
+func newBackend(c *config.Config, name string, retry *config.Retry) (Backend, error) {
+	switch name {
+	case "opn":
+		if o := c.OPN; o != nil {
+			var creds firewall.ICredentialProvider
+			if o.Credentials != nil {
+				var err error
+				if creds, err = newCredentials(o.Credentials, c.Secrets); err != nil {
+					return nil, err
+				}
+			}
+			newAPI := func(listUUID string, moreUUIDs ...string) *opn.API {
+				api := opn.New(o.Address, o.User, o.Pass, listUUID, moreUUIDs...)
+				if creds != nil {
+					api.WithCredentials(creds)
+				}
+				return api
+			}
+
+			api := newAPI(o.ListUUID, o.ListUUIDs...)
+			if o.AliasCapacity > 0 {
+				api.WithChunks(o.AliasCapacity, o.AliasChunks)
+			}
+			if len(o.ActionListUUIDs) == 0 {
+				return firewall.V1(withRetry(api, retry), 0), nil
+			}
+
+			actions := map[firewall.BanAction]firewall.IFirewall{}
+			for name, uuid := range o.ActionListUUIDs {
+				action, err := firewall.ParseBanAction(name)
+				if err != nil {
+					return nil, fmt.Errorf("opn action_list_uuids: %w", err)
+				}
+				if action == firewall.BanActionBlock {
+					return nil, errors.New("opn action_list_uuids: block bans go to list_uuid")
+				}
+				actions[action] = firewall.V1(withRetry(newAPI(uuid), retry), 0)
+			}
+			return firewall.NewActionFirewall(firewall.V1(withRetry(api, retry), 0), actions), nil
+		}
+	case "pf":
+		if p := c.PF; p != nil {
+			api := pf.New(p.Address, p.User, p.Pass)
+			if p.AliasCapacity > 0 {
+				api.WithChunks(p.AliasCapacity, p.AliasChunks)
+			}
+			if p.Credentials != nil {
+				creds, err := newCredentials(p.Credentials, c.Secrets)
+				if err != nil {
+					return nil, err
+				}
+				api.WithCredentials(creds)
+			}
+			return firewall.V1(withRetry(api, retry), 0), nil
+		}
+	case "ros":
+		if r := c.ROS; r != nil {
+			api := ros.New(r.Address, r.User, r.Pass)
+			if r.Credentials != nil {
+				creds, err := newCredentials(r.Credentials, c.Secrets)
+				if err != nil {
+					return nil, err
+				}
+				api.WithCredentials(creds)
+			}
+			return firewall.V1(withRetry(api, retry), 0), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	return nil, fmt.Errorf("no %s section in config", name)
+}
+
+// withRetry wraps fw to retry its failed calls if retry is set.
+func withRetry(fw firewall.IFirewallV2, retry *config.Retry) firewall.IFirewallV2 {
+	if retry == nil {
+		return fw
+	}
+	return firewall.Retry(fw, firewall.RetryPolicy{
+		MaxAttempts:    retry.MaxAttempts,
+		InitialBackoff: time.Duration(retry.InitialBackoff),
+		MaxBackoff:     time.Duration(retry.MaxBackoff),
+	})
+}
+
+func newOverflow(q *config.Queue) firewall.OverflowPolicy {
+	if q.DropOldest {
+		return firewall.OverflowDropOldest
+	}
+	return firewall.OverflowBlock
+}
+
+// newCredentials returns the provider of the source set in c.
+func newCredentials(c *config.Credentials, sc *config.Secrets) (firewall.ICredentialProvider, error) {
+	refs := c.User != "" || c.Pass != ""
+	switch {
+	case c.File != "" && c.Vault != nil, (c.File != "" || c.Vault != nil) && refs:
+		return nil, errors.New("credentials have more than one of file, vault and user/pass, set one")
+	case c.File != "":
+		return firewall.NewCredentialsFile(c.File), nil
+	case c.Vault != nil:
+		v := c.Vault
+		if v.Address == "" || v.Path == "" {
+			return nil, errors.New("vault credentials require address and path")
+		}
+		return secrets.VaultCredentials(v), nil
+	case refs:
+		return secrets.New(sc).Credentials(c.User, c.Pass), nil
+	}
+	return nil, errors.New("credentials require file, vault or user/pass")
+}
+
+// logDrops returns a drop hook of logger alerting at most once a minute
+// that its queue is full.
+func logDrops(logger string) func(*firewall.Event) {
+	var last atomic.Int64
+	return func(e *firewall.Event) {
+		now := time.Now().UnixNano()
+		if prev := last.Load(); now-prev >= int64(time.Minute) && last.CompareAndSwap(prev, now) {
+			log.Printf("%s queue is full, dropping events, e.g. %s of %s", logger, e.Action, e.IP)
+		}
+	}
+}
+
+// firewallOptions returns the options of dc shared by the firewall of the
+// daemon and the ones of the tenants.
+func (d *Daemon) firewallOptions(dc *config.Daemon) []firewall.Option {
+	opts := []firewall.Option{}
+	policy := d.policy
+	if st := dc.Stuffing; st != nil {
+		// each firewall has its own detector, like its error counts.
+		detector := stuffing.New(stuffing.Config{
+			Window:       time.Duration(st.Window),
+			TargetsPerIP: st.TargetsPerIP,
+			IPsPerTarget: st.IPsPerTarget,
+			MinEntropy:   st.MinEntropy,
+			MinErrors:    st.MinErrors,
+			Weight:       st.Weight,
+		})
+		if policy != nil {
+			policy = firewall.PolicyChain{policy, detector}
+		} else {
+			policy = detector
+		}
+	}
+	if policy != nil {
+		opts = append(opts, firewall.WithPolicy(policy))
+	}
+	if d.valve != nil {
+		opts = append(opts, firewall.WithSharedValve(d.valve))
+	}
+	if d.quotas != nil {
+		opts = append(opts, firewall.WithBanFilter(&quotaFilter{d: d, next: d.banFilter}))
+	} else if d.banFilter != nil {
+		opts = append(opts, firewall.WithBanFilter(d.banFilter))
+	}
+	if d.durations != nil {
+		opts = append(opts, firewall.WithDurationPolicy(d.durations))
+	}
+	if d.asnPolicy != nil {
+		opts = append(opts, firewall.WithASNPolicy(d.asnPolicy))
+	}
+	if len(dc.BanCountries) > 0 {
+		opts = append(opts, firewall.WithBanCountries(dc.BanCountries...))
+	}
+	if a := dc.AllowCountries; a != nil {
+		opts = append(opts, firewall.WithCountryAllowlist(firewall.CountryAllowlist{Countries: a.Countries, CrawlerDomains: a.CrawlerDomains}))
+	}
+	if len(dc.Blacklist) > 0 {
+		opts = append(opts, firewall.WithBlacklist(dc.Blacklist...))
+	}
+	if len(dc.WhitelistAS) > 0 {
+		opts = append(opts, firewall.WithASWhitelist(dc.WhitelistAS...))
+	}
+	if len(dc.WhitelistCountries) > 0 {
+		opts = append(opts, firewall.WithCountryWhitelist(dc.WhitelistCountries...))
+	}
+	if dc.SkipPrivateIPs {
+		opts = append(opts, firewall.WithSkipPrivateIPs(true))
+	}
+	if dc.JailCapacity > 0 {
+		opts = append(opts, firewall.WithJailCapacity(dc.JailCapacity))
+	}
+	if len(d.reasonForgivable) > 0 {
+		opts = append(opts, firewall.WithReasonForgivable(d.reasonForgivable))
+	}
+	if sc := dc.Scoring; sc != nil {
+		opts = append(opts, firewall.WithScoring(newScoring(sc)))
+	}
+	if dc.NoCountErrorGeo {
+		opts = append(opts, firewall.WithCountErrorGeo(false))
+	}
+	if dc.NoWhitelistUnban {
+		opts = append(opts, firewall.WithWhitelistUnban(false))
+	}
+	if q := dc.Queue; q != nil {
+		opts = append(opts, firewall.WithQueue(q.Size, newOverflow(q)))
+	}
+	if dc.BanWorkers > 0 {
+		opts = append(opts, firewall.WithBanWorkers(dc.BanWorkers))
+	}
+	if dc.DeadManSwitch > 0 {
+		opts = append(opts, firewall.WithDeadManSwitch(time.Duration(dc.DeadManSwitch)))
+	}
+	return opts
+}
+
+func newForgivable(f *config.Forgivable) firewall.ForgivableError {
+	return firewall.ForgivableError{
+		Duration:    time.Duration(f.Duration),
+		Count:       f.Count,
+		BanInMinute: f.BanInMinute,
+		Warn:        f.Warn,
+	}
+}
+
+func newReasonForgivable(m map[string]config.Forgivable) map[string]firewall.ForgivableError {
+	res := map[string]firewall.ForgivableError{}
+	for category, f := range m {
+		res[category] = newForgivable(&f)
+	}
+	return res
+}
+
+func newScoring(sc *config.Scoring) firewall.Scoring {
+	return firewall.Scoring{
+		Weights:       sc.Weights,
+		DefaultWeight: sc.DefaultWeight,
+		HalfLife:      time.Duration(sc.HalfLife),
+		Threshold:     sc.Threshold,
+	}
+}
+
+// Firewall returns the firewall of the daemon, not of a tenant.
+func (d *Daemon) Firewall() *firewall.Firewall {
+	return d.fw
+}
+
+// Close releases a daemon which is not run, Run releases it on return.
+func (d *Daemon) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	d.closeFirewalls(ctx)
+	d.close()
+}
+
+// Run serves until ctx is done or a server fails.
+func (d *Daemon) Run(ctx context.Context) error {
+	defer d.close()
+
+	d.dumpDiagnosticsOnSignal(ctx)
+
+	if d.warmUp {
+		d.warmUpBans(ctx)
+	}
+	haDone := make(chan struct{})
+	haCtx, stopHA := context.WithCancel(context.Background())
+	if d.ha != nil {
+		// the lock is released once the firewalls are closed
+		go func() {
+			defer close(haDone)
+			d.runHA(haCtx)
+		}()
+	} else {
+		close(haDone)
+	}
+
+	if d.consumer != nil {
+		d.supervisor.add(startCloseComponent("peer-consumer", d.consumer.Start, d.consumer.Close))
+	}
+	if d.mispPuller != nil {
+		d.supervisor.add(startCloseComponent("misp", d.mispPuller.Start, d.mispPuller.Close))
+	}
+	for _, b := range d.blocklists {
+		d.supervisor.add(startCloseComponent("blocklist-"+b.Name(), b.Start, b.Close))
+	}
+	if d.wal != nil {
+		d.supervisor.add(&component{name: "wal-replay", run: d.replayWAL})
+	}
+	for _, s := range d.servers {
+		d.supervisor.add(serverComponent(s))
+	}
+	err := d.supervisor.run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	d.closeFirewalls(shutdownCtx)
+	stopHA()
+	<-haDone
+
+	return err
+}
+
+// replayWAL retries the bans not reaching the backend until ctx is done.
+func (d *Daemon) replayWAL(ctx context.Context) error {
+	ticker := time.NewTicker(walReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := d.wal.Replay(); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+	}
+}
+
+// closeFirewalls handles the queued events of the firewalls and flushes
+// their loggers, before the loggers are closed.
+func (d *Daemon) closeFirewalls(ctx context.Context) {
+	for _, fw := range d.firewalls() {
+		if err := fw.Close(ctx); err != nil {
+			log.Printf("close firewall failed: %v", err)
+		}
+	}
+}
+
+func (d *Daemon) close() {
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		d.closers[i]()
+	}
+	d.closers = nil
+}
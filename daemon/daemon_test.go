@@ -0,0 +1,926 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/export"
+	"github.com/charleshuang3/firewall/history"
+	"github.com/charleshuang3/firewall/ipgeo"
+	"github.com/charleshuang3/firewall/ipgeo/ipgeotest"
+)
+
+type mockFirewall struct {
+	mu     sync.Mutex
+	banned []string
+}
+
+func (m *mockFirewall) BanIP(ip string, timeoutInMinute int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.banned = append(m.banned, ip)
+}
+
+type mockLogger struct {
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	actions []string
+}
+
+func (m *mockLogger) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.actions = append(m.actions, action)
+	m.wg.Done()
+}
+
+// adminHandler returns the admin api of d, requests are sent with the
+// token of its operator.
+func adminHandler(t *testing.T, d *Daemon) http.Handler {
+	t.Helper()
+	srv, err := d.newAdminServer(&config.Admin{Operators: []config.Operator{{Name: "admin", Token: "admin-token"}}})
+	require.NoError(t, err)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer admin-token")
+		srv.srv.Handler.ServeHTTP(w, r)
+	})
+}
+
+// testForgivable bans an ip on its second error in a minute for 5 minutes.
+var testForgivable = firewall.ForgivableError{Duration: time.Minute, Count: 1, BanInMinute: 5}
+
+// newFirewall returns a firewall without whitelist and geo database.
+func newFirewall(t *testing.T, fw firewall.IFirewall, logger firewall.ILogger, forgivable firewall.ForgivableError, opts ...firewall.Option) *firewall.Firewall {
+	t.Helper()
+	f, err := firewall.New(nil, fw, logger, nil, forgivable, opts...)
+	require.NoError(t, err)
+	return f
+}
+
+func TestIngest(t *testing.T) {
+	fw := &mockFirewall{}
+	logger := &mockLogger{}
+	d := &Daemon{
+		fw: newFirewall(t, fw, logger, testForgivable),
+	}
+	h := d.ingestHandler()
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int
+	}{
+		{"error", "/v1/error", `{"ip":"10.0.0.1","reason":"bad password"}`, http.StatusAccepted},
+		{"ban", "/v1/ban", `{"ip":"10.0.0.2","minutes":5,"reason":"scanner"}`, http.StatusAccepted},
+		{"no unban", "/v1/unban", `{"ip":"10.0.0.3","reason":"false positive"}`, http.StatusNotFound},
+		{"ipv6", "/v1/ban", `{"ip":"2001:db8::1","minutes":5,"reason":"scanner"}`, http.StatusAccepted},
+		{"mapped ipv4", "/v1/ban", `{"ip":"::ffff:10.0.0.4","minutes":5,"reason":"scanner"}`, http.StatusAccepted},
+		{"ip with port", "/v1/ban", `{"ip":"[2001:DB8::5%eth0]:443","minutes":5,"reason":"scanner"}`, http.StatusAccepted},
+		{"invalid ip", "/v1/error", `{"ip":"not ip","reason":"bad password"}`, http.StatusBadRequest},
+		{"invalid json", "/v1/ban", `{`, http.StatusBadRequest},
+		{"no minutes", "/v1/ban", `{"ip":"10.0.0.2"}`, http.StatusBadRequest},
+	}
+
+	logger.wg.Add(5)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+		})
+	}
+	logger.wg.Wait()
+
+	assert.Equal(t, []string{"count error", "ban", "ban", "ban", "ban"}, logger.actions)
+	assert.Equal(t, []string{"10.0.0.2", "2001:db8::1", "10.0.0.4", "2001:db8::5"}, fw.banned)
+}
+
+func TestIngest_Token(t *testing.T) {
+	logger := &mockLogger{}
+	d := &Daemon{
+		fw:          newFirewall(t, &mockFirewall{}, logger, testForgivable),
+		ingestToken: "ingest-token",
+	}
+	h := d.ingestHandler()
+
+	post := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/ban", strings.NewReader(`{"ip":"10.0.0.1","minutes":5,"reason":"scanner"}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post(""))
+	assert.Equal(t, http.StatusUnauthorized, post("wrong"))
+	logger.wg.Add(1)
+	assert.Equal(t, http.StatusAccepted, post("ingest-token"))
+	logger.wg.Wait()
+}
+
+func TestCheckIngestAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		dc   *config.Daemon
+		ok   bool
+	}{
+		{"no listener", &config.Daemon{}, true},
+		{"loopback", &config.Daemon{Listen: "127.0.0.1:8080"}, true},
+		{"ipv6 loopback", &config.Daemon{Listen: "[::1]:8080"}, true},
+		{"localhost", &config.Daemon{Listen: "localhost:8080"}, true},
+		{"all interfaces", &config.Daemon{Listen: ":8080"}, false},
+		{"lan", &config.Daemon{Listen: "10.0.0.1:8080"}, false},
+		{"token", &config.Daemon{Listen: ":8080", IngestToken: "secret"}, true},
+		{"tenants", &config.Daemon{Listen: ":8080", Tenants: []config.Tenant{{Name: "a", Token: "token-a"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkIngestAuth(tt.dc)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestJails(t *testing.T) {
+	d := &Daemon{}
+	err := d.setupJails(&config.Daemon{Jails: []config.Jail{{Recipe: "telnetd"}}})
+	assert.ErrorContains(t, err, `unknown recipe "telnetd"`)
+
+	require.NoError(t, d.setupJails(&config.Daemon{
+		Jails: []config.Jail{{Recipe: "sshd"}, {Recipe: "wordpress", Forgivable: &config.Forgivable{Duration: config.Duration(time.Hour), Count: 1, BanInMinute: 5}}},
+	}))
+	assert.Equal(t, 5, d.reasonForgivable["sshd"].Count)
+	assert.Equal(t, 1, d.reasonForgivable["wordpress"].Count)
+
+	fw := &mockFirewall{}
+	logger := &mockLogger{}
+	d.fw = newFirewall(t, fw, logger, firewall.ForgivableError{Duration: time.Hour, Count: 100, BanInMinute: 5}, firewall.WithReasonForgivable(d.reasonForgivable))
+	defer d.fw.Close(context.Background())
+	h := d.ingestHandler()
+
+	// the second login attempt bans with the wordpress threshold
+	line := `203.0.113.11 - - [02/Jan/2026:03:04:05 +0000] "POST /wp-login.php HTTP/1.1" 200 4521`
+	body, _ := json.Marshal(&logRequest{Recipe: "wordpress", Lines: []string{line, "not a match", line}})
+	logger.wg.Add(2)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/log", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	logger.wg.Wait()
+	assert.Equal(t, []string{"count error", "ban"}, logger.actions)
+	assert.Equal(t, []string{"203.0.113.11"}, fw.banned)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/log", strings.NewReader(`{"recipe":"nextcloud","lines":[]}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestNewBackends(t *testing.T) {
+	c := &config.Config{OPN: &config.OPN{}, ROS: &config.ROS{}}
+
+	b, err := newBackends(c, &config.Daemon{Backends: []string{"opn", "ros"}})
+	require.NoError(t, err)
+	assert.IsType(t, &firewall.MultiFirewall{}, b)
+	assert.Equal(t, "opn,ros", b.Name())
+
+	b, err = newBackends(c, &config.Daemon{Backend: "ros"})
+	require.NoError(t, err)
+	assert.Equal(t, "ros", b.Name())
+
+	_, err = newBackends(c, &config.Daemon{Backends: []string{"opn", "pf"}})
+	assert.EqualError(t, err, "no pf section in config")
+
+	_, err = newBackends(c, &config.Daemon{Backend: "opn", Backends: []string{"ros"}})
+	assert.Error(t, err)
+
+	b, err = newBackends(c, &config.Daemon{Backend: "opn", Routes: []config.Route{{Prefix: "ssh", Backend: "ros"}, {Prefix: "scanner", Backend: "opn"}}})
+	require.NoError(t, err)
+	assert.IsType(t, &firewall.Router{}, b)
+	// backends are shared by routes
+	assert.Equal(t, "opn,ros", b.Name())
+
+	b, err = newBackends(c, &config.Daemon{Routes: []config.Route{{Prefix: "ssh", Backend: "ros"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "ros", b.Name())
+
+	_, err = newBackends(c, &config.Daemon{Backend: "opn", Routes: []config.Route{{Prefix: "ssh", Backend: "pf"}}})
+	assert.EqualError(t, err, "no pf section in config")
+}
+
+func TestConfiguredBackends(t *testing.T) {
+	c := &config.Config{
+		OPN: &config.OPN{ActionListUUIDs: map[string]string{"tarpit": "t"}},
+		ROS: &config.ROS{},
+	}
+	backends, err := ConfiguredBackends(c)
+	require.NoError(t, err)
+	require.Len(t, backends, 2)
+	// the action lists are unbanned with the block list
+	assert.IsType(t, &firewall.ActionFirewall{}, backends[0])
+	assert.Equal(t, "ros", backends[1].Name())
+
+	c.PF = &config.PF{Credentials: &config.Credentials{}}
+	_, err = ConfiguredBackends(c)
+	assert.Error(t, err)
+}
+
+func TestRestore(t *testing.T) {
+	logger := &mockLogger{}
+	newFW := func() *firewall.Firewall {
+		return newFirewall(t, &mockFirewall{}, logger, testForgivable)
+	}
+	d := &Daemon{
+		fw:      newFW(),
+		tenants: []*tenant{{name: "a", fw: newFW()}},
+	}
+
+	until := time.Now().Add(time.Hour)
+	logger.wg.Add(2)
+	n := d.restore([]history.Ban{
+		{IP: "1.2.3.4", JailUntil: until, Labels: map[string]string{}},
+		{IP: "1.2.3.5", JailUntil: until, Labels: map[string]string{"tenant": "a"}},
+		{IP: "1.2.3.6", JailUntil: until, Labels: map[string]string{"tenant": "gone"}},
+	})
+	logger.wg.Wait()
+
+	assert.Equal(t, 2, n)
+	banned, _ := d.fw.IsBanned("1.2.3.4")
+	assert.True(t, banned)
+	banned, _ = d.tenants[0].fw.IsBanned("1.2.3.5")
+	assert.True(t, banned)
+	banned, _ = d.fw.IsBanned("1.2.3.6")
+	assert.False(t, banned)
+	assert.Equal(t, []string{"restore", "restore"}, logger.actions)
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"1024", 1024, false},
+		{"64KiB", 64 << 10, false},
+		{"200MiB", 200 << 20, false},
+		{"1GiB", 1 << 30, false},
+		{"1GB", 0, true},
+		{"-1", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if tt.err {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	fw := &mockFirewall{}
+	logger := &mockLogger{}
+	d := &Daemon{}
+	err := d.setupTenants(&config.Daemon{
+		Forgivable: config.Forgivable{Duration: config.Duration(time.Minute), Count: 1, BanInMinute: 5},
+		Tenants: []config.Tenant{
+			{Name: "a", Token: "token-a"},
+			{Name: "b", Token: "token-b", Forgivable: &config.Forgivable{Duration: config.Duration(time.Minute), Count: 5, BanInMinute: 5}},
+		},
+	}, fw, logger, nil)
+	require.NoError(t, err)
+	h := d.ingestHandler()
+
+	post := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/error", strings.NewReader(`{"ip":"10.0.0.1","reason":"bad password"}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post(""))
+	assert.Equal(t, http.StatusUnauthorized, post("wrong"))
+
+	// a bans after 2 errors, b counts separately
+	logger.wg.Add(4)
+	assert.Equal(t, http.StatusAccepted, post("token-a"))
+	assert.Equal(t, http.StatusAccepted, post("token-a"))
+	assert.Equal(t, http.StatusAccepted, post("token-b"))
+	assert.Equal(t, http.StatusAccepted, post("token-b"))
+	logger.wg.Wait()
+
+	assert.Equal(t, []string{"10.0.0.1"}, fw.banned)
+	assert.Equal(t, 3, countOf(logger.actions, "count error"))
+	assert.Equal(t, 1, countOf(logger.actions, "ban"))
+
+	admin := adminHandler(t, d)
+	bans := func(tenant string) (int, string) {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/bans?tenant="+tenant, nil))
+		return w.Code, w.Body.String()
+	}
+	code, body := bans("a")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"ip": "10.0.0.1"`)
+	code, body = bans("b")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", body)
+	code, _ = bans("c")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestTenants_Stuffing(t *testing.T) {
+	fw := &mockFirewall{}
+	logger := &mockLogger{}
+	d := &Daemon{}
+	err := d.setupTenants(&config.Daemon{
+		Forgivable: config.Forgivable{Duration: config.Duration(time.Minute), Count: 5, BanInMinute: 5},
+		Stuffing:   &config.Stuffing{TargetsPerIP: 2, Weight: 10},
+		Tenants:    []config.Tenant{{Name: "a", Token: "token-a"}, {Name: "b", Token: "token-b"}},
+	}, fw, logger, nil)
+	require.NoError(t, err)
+
+	// the targets of an ip are counted per tenant
+	logger.wg.Add(2)
+	d.tenants[0].fw.LogIPErrorWithMetadata("10.0.0.1", "alice", "bad password", nil)
+	d.tenants[1].fw.LogIPErrorWithMetadata("10.0.0.1", "bob", "bad password", nil)
+	logger.wg.Wait()
+	assert.Equal(t, []string{"count error", "count error"}, logger.actions)
+
+	logger.wg.Add(1)
+	d.tenants[0].fw.LogIPErrorWithMetadata("10.0.0.1", "bob", "bad password", nil)
+	logger.wg.Wait()
+	assert.Equal(t, "ban", logger.actions[2])
+}
+
+func TestSharedBackend_Routes(t *testing.T) {
+	fallback := &mockBackend{}
+	edge := &mockBackend{}
+	s := &sharedBackend{b: firewall.NewRouter(fallback, firewall.Route{Prefix: "ssh", Backend: edge})}
+
+	require.NoError(t, s.RouteBanIP("1.2.3.4", 10, []string{"ssh: invalid user"}))
+	require.NoError(t, s.TryBanIP("1.2.3.5", 10))
+	assert.Equal(t, []string{"1.2.3.4"}, edge.banned)
+	assert.Equal(t, []string{"1.2.3.5"}, fallback.banned)
+
+	plain := &mockBackend{}
+	s = &sharedBackend{b: plain}
+	require.NoError(t, s.RouteBanIP("1.2.3.6", 10, []string{"ssh: invalid user"}))
+	assert.Equal(t, []string{"1.2.3.6"}, plain.banned)
+}
+
+func TestSharedBackend_Actions(t *testing.T) {
+	block := &mockBackend{}
+	tarpit := &mockBackend{}
+	s := &sharedBackend{b: firewall.NewActionFirewall(block, map[firewall.BanAction]firewall.IFirewall{firewall.BanActionTarpit: tarpit})}
+
+	require.NoError(t, s.ActionBanIP("1.2.3.4", 10, firewall.BanActionTarpit, nil))
+	require.NoError(t, s.TryBanIP("1.2.3.5", 10))
+	assert.Equal(t, []string{"1.2.3.4"}, tarpit.banned)
+	assert.Equal(t, []string{"1.2.3.5"}, block.banned)
+}
+
+func countOf(s []string, v string) int {
+	n := 0
+	for _, it := range s {
+		if it == v {
+			n++
+		}
+	}
+	return n
+}
+
+func TestQuota(t *testing.T) {
+	fw := &mockFirewall{}
+	logger := &mockLogger{}
+	d := &Daemon{
+		fw:     newFirewall(t, fw, logger, firewall.ForgivableError{Duration: time.Minute, Count: 100, BanInMinute: 5}),
+		quotas: newQuotas(&config.Quota{EventsPerSecond: 0.001, Burst: 3, BansPerHour: 1}),
+	}
+	h := d.ingestHandler()
+
+	post := func(path, body, remote string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	logger.wg.Add(3)
+	assert.Equal(t, http.StatusAccepted, post("/v1/ban", `{"ip":"10.0.0.2","minutes":5}`, "192.0.2.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, post("/v1/ban", `{"ip":"10.0.0.3","minutes":5}`, "192.0.2.1:1234"))
+	assert.Equal(t, http.StatusAccepted, post("/v1/error", `{"ip":"10.0.0.1"}`, "192.0.2.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, post("/v1/error", `{"ip":"10.0.0.1"}`, "192.0.2.1:1234"))
+	// other sources have their own quota
+	assert.Equal(t, http.StatusAccepted, post("/v1/error", `{"ip":"10.0.0.1"}`, "192.0.2.2:1234"))
+	logger.wg.Wait()
+
+	assert.Equal(t, []QuotaInfo{
+		{Source: "192.0.2.1", Accepted: 2, RejectedEvents: 1, RejectedBans: 1},
+		{Source: "192.0.2.2", Accepted: 1},
+	}, d.quotas.snapshot())
+}
+
+func TestQuota_ErrorBans(t *testing.T) {
+	fw := &mockFirewall{}
+	logger := &mockLogger{}
+	d := &Daemon{quotas: newQuotas(&config.Quota{BansPerHour: 1})}
+	d.fw = newFirewall(t, fw, logger, firewall.ForgivableError{Duration: time.Minute, Count: 0, BanInMinute: 5}, firewall.WithBanFilter(&quotaFilter{d: d}))
+	h := d.ingestHandler()
+
+	post := func(body, remote string) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/error", strings.NewReader(body))
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	// the bans the errors of a source lead to are charged to its quota
+	logger.wg.Add(3)
+	post(`{"ip":"10.0.0.1"}`, "192.0.2.1:1234")
+	post(`{"ip":"10.0.0.2","metadata":{"ingest_source":"192.0.2.9"}}`, "192.0.2.1:1234")
+	post(`{"ip":"10.0.0.3"}`, "192.0.2.2:1234")
+	logger.wg.Wait()
+
+	assert.Equal(t, []string{"ban", "ban-vetoed", "ban"}, logger.actions)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.3"}, fw.banned)
+	assert.Equal(t, []QuotaInfo{
+		{Source: "192.0.2.1", Accepted: 2, RejectedBans: 1},
+		{Source: "192.0.2.2", Accepted: 1},
+	}, d.quotas.snapshot())
+}
+
+func TestQuota_Sweep(t *testing.T) {
+	q := newQuotas(&config.Quota{EventsPerSecond: 1, Burst: 2, BansPerHour: 1})
+	q.configs["tenant"] = &config.Quota{EventsPerSecond: 1}
+	assert.Equal(t, "", q.allow("192.0.2.1", false))
+	assert.Equal(t, "", q.allow("192.0.2.2", true))
+	assert.Equal(t, "", q.allow("tenant", false))
+
+	// sources are kept until their quota is full again
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	q.sweep(now)
+	assert.Len(t, q.sources, 3)
+	q.sweep(now.Add(2 * time.Second))
+	assert.Len(t, q.sources, 2)
+	assert.Contains(t, q.sources, "192.0.2.2")
+	q.sweep(now.Add(time.Hour))
+	assert.Len(t, q.sources, 1)
+	assert.Contains(t, q.sources, "tenant")
+}
+
+func TestWhitelistAdmin(t *testing.T) {
+	logger := &mockLogger{}
+	d := &Daemon{fw: newFirewall(t, &mockFirewall{}, logger, testForgivable)}
+	h := adminHandler(t, d)
+
+	serve := func(method, path, body string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	code, _ := serve(http.MethodPost, "/v1/whitelist", `{"rule":"1.2.3.256"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	logger.wg.Add(2)
+	code, _ = serve(http.MethodPost, "/v1/whitelist", `{"rule":"1.2.3.4","ttl":"8h","reason":"contractor"}`)
+	assert.Equal(t, http.StatusOK, code)
+	code, body := serve(http.MethodGet, "/v1/whitelist", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"rule": "1.2.3.4"`)
+	assert.Contains(t, body, `"reason": "contractor"`)
+
+	code, _ = serve(http.MethodDelete, "/v1/whitelist?rule=1.2.3.4", "")
+	assert.Equal(t, http.StatusOK, code)
+	logger.wg.Wait()
+	assert.Equal(t, []string{"whitelist-add", "whitelist-remove"}, logger.actions)
+
+	code, _ = serve(http.MethodDelete, "/v1/whitelist?rule=1.2.3.4", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = serve(http.MethodGet, "/v1/whitelist?tenant=x", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestPauseAdmin(t *testing.T) {
+	logger := &mockLogger{}
+	d := &Daemon{fw: newFirewall(t, &mockFirewall{}, logger, testForgivable, firewall.WithDeadManSwitch(time.Hour))}
+	h := adminHandler(t, d)
+
+	serve := func(method, path, body string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	code, _ := serve(http.MethodPost, "/v1/heartbeat", "")
+	assert.Equal(t, http.StatusOK, code)
+
+	logger.wg.Add(1)
+	code, _ = serve(http.MethodPost, "/v1/pause", `{}`)
+	assert.Equal(t, http.StatusOK, code)
+	code, body := serve(http.MethodGet, "/v1/pause", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"paused": true`)
+
+	logger.wg.Add(1)
+	code, _ = serve(http.MethodPost, "/v1/resume", "")
+	assert.Equal(t, http.StatusOK, code)
+	logger.wg.Wait()
+	assert.Equal(t, []string{"pause", "resume"}, logger.actions)
+	assert.False(t, d.fw.Paused())
+
+	// the body is optional
+	logger.wg.Add(1)
+	code, _ = serve(http.MethodPost, "/v1/pause", "")
+	assert.Equal(t, http.StatusOK, code)
+	logger.wg.Wait()
+	assert.True(t, d.fw.Paused())
+
+	code, _ = serve(http.MethodPost, "/v1/pause", `{`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+type mockBackend struct {
+	mockFirewall
+}
+
+func (m *mockBackend) Name() string { return "mock" }
+
+func (m *mockBackend) TryBanIP(ip string, timeoutInMinute int) error {
+	m.BanIP(ip, timeoutInMinute)
+	return nil
+}
+
+func (m *mockBackend) UnbanIP(ip string) error {
+	return nil
+}
+
+func TestHA(t *testing.T) {
+	b := &mockBackend{}
+	d := &Daemon{}
+	_, err := d.setupHA(&config.HA{}, b)
+	assert.ErrorContains(t, err, "one of file_lock, redis or lease")
+	_, err = d.setupHA(&config.HA{FileLock: "a", Redis: &config.HARedis{Address: "localhost:6379"}}, b)
+	assert.Error(t, err)
+
+	gated, err := d.setupHA(&config.HA{ID: "a", FileLock: t.TempDir() + "/leader.lock"}, b)
+	require.NoError(t, err)
+	logger := &mockLogger{}
+	d.fw = newFirewall(t, gated, logger, testForgivable)
+
+	// the follower jails without writing to the backend
+	logger.wg.Add(1)
+	d.fw.BanIP("1.2.3.4", 10, "scanner")
+	logger.wg.Wait()
+	assert.Empty(t, b.banned)
+
+	// the new leader bans its jail on the backend
+	logger.wg.Add(1)
+	d.setLeader(true)
+	logger.wg.Wait()
+	assert.Equal(t, []string{"1.2.3.4"}, b.banned)
+	assert.Equal(t, []string{"ban", "restore"}, logger.actions)
+}
+
+func TestMirror(t *testing.T) {
+	// the backend section is missing, it is not created
+	d, err := New(&config.Config{
+		History: t.TempDir() + "/history.db",
+		Daemon: &config.Daemon{
+			Mirror:     true,
+			Backend:    "opn",
+			WAL:        t.TempDir() + "/bans.wal",
+			Forgivable: config.Forgivable{Duration: config.Duration(time.Minute), Count: 1, BanInMinute: 5},
+			Tenants:    []config.Tenant{{Name: "a", Token: "token-a"}},
+		},
+	})
+	require.NoError(t, err)
+	defer d.close()
+	assert.Nil(t, d.wal)
+
+	d.fw.BanIP("1.2.3.4", 10, "scanner")
+	d.tenants[0].fw.BanIP("1.2.3.5", 10, "scanner")
+	banned, _ := d.fw.IsBanned("1.2.3.4")
+	assert.True(t, banned)
+	banned, _ = d.tenants[0].fw.IsBanned("1.2.3.5")
+	assert.True(t, banned)
+
+	d.closeFirewalls(context.Background())
+	bans, err := d.history.ActiveBans(time.Now())
+	require.NoError(t, err)
+	assert.Len(t, bans, 2)
+}
+
+func TestGeoMetrics(t *testing.T) {
+	city, asn, err := ipgeotest.Write(t.TempDir(), map[string]*ipgeo.IPGeo{
+		"1.2.3.0/24": {CountryISO: "KP", Country: "North Korea"},
+	})
+	require.NoError(t, err)
+	d, err := New(&config.Config{Daemon: &config.Daemon{
+		Mirror:     true,
+		Forgivable: config.Forgivable{Duration: config.Duration(time.Minute), Count: 1, BanInMinute: 5},
+		Geo:        &config.Geo{CityDB: city, UpdatedCityDB: city, ASNDB: asn, UpdatedASNDB: asn},
+		Metrics:    &config.Metrics{Listen: "127.0.0.1:0"},
+	}})
+	require.NoError(t, err)
+	defer d.close()
+
+	w := httptest.NewRecorder()
+	d.metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `firewall_geo_build_timestamp_seconds{db="city"}`)
+	assert.Contains(t, w.Body.String(), `firewall_geo_since_last_update_seconds`)
+}
+
+func TestBlocklists(t *testing.T) {
+	newDaemon := func(b config.Blocklist) (*Daemon, error) {
+		return New(&config.Config{Daemon: &config.Daemon{
+			Mirror:     true,
+			Forgivable: config.Forgivable{Duration: config.Duration(time.Minute), Count: 1, BanInMinute: 5},
+			Blocklists: []config.Blocklist{b},
+		}})
+	}
+
+	_, err := newDaemon(config.Blocklist{Name: "mine"})
+	assert.ErrorContains(t, err, `blocklist "mine" requires name and url`)
+
+	d, err := newDaemon(config.Blocklist{Name: "spamhaus-drop"})
+	require.NoError(t, err)
+	defer d.close()
+	require.Len(t, d.blocklists, 1)
+	assert.Equal(t, "spamhaus-drop", d.blocklists[0].Name())
+}
+
+func TestExport(t *testing.T) {
+	d := &Daemon{fw: newFirewall(t, &mockFirewall{}, firewall.MultiLogger{}, testForgivable)}
+	defer d.fw.Close(context.Background())
+	d.export = tokenHandler("secret", export.Handler(d.fw))
+	h := d.ingestHandler()
+	d.fw.BanIP("10.0.0.1", 10, "scan")
+	require.Eventually(t, func() bool { return len(d.fw.ListBans()) == 1 }, time.Second, 10*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/export?token=secret", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10.0.0.1\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/export?token=nope", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAdminBan(t *testing.T) {
+	h, err := history.Open(t.TempDir() + "/history.db")
+	require.NoError(t, err)
+	defer h.Close()
+	d := &Daemon{fw: newFirewall(t, &mockFirewall{}, h, testForgivable)}
+	srv, err := d.newAdminServer(&config.Admin{
+		Operators:      []config.Operator{{Name: "alice", Token: "token-a"}, {Name: "proxy", Token: "token-p"}},
+		IdentityHeader: "X-Forwarded-User",
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	require.NoError(t, err)
+	handler := srv.srv.Handler
+
+	serve := func(path, body string, header ...string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.RemoteAddr = "10.0.0.2:1234"
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	ban := `{"ip":"1.2.3.4","minutes":10,"reason":"abuse report","ticket":"INC-1"}`
+	assert.Equal(t, http.StatusUnauthorized, serve("/v1/ban", ban))
+	assert.Equal(t, http.StatusUnauthorized, serve("/v1/ban", ban, "Authorization", "Bearer wrong"))
+	assert.Equal(t, http.StatusAccepted, serve("/v1/ban", ban, "Authorization", "Bearer token-a"))
+	// the identity header needs a token too
+	assert.Equal(t, http.StatusUnauthorized, serve("/v1/unban", `{"ip":"1.2.3.4"}`, "X-Forwarded-User", "bob@example.com"))
+	assert.Equal(t, http.StatusOK, serve("/v1/unban", `{"ip":"1.2.3.4"}`, "X-Forwarded-User", "bob@example.com", "Authorization", "Bearer token-p"))
+	assert.Equal(t, http.StatusBadRequest, serve("/v1/ban", `{"ip":"1.2.3.4"}`, "Authorization", "Bearer token-a"))
+
+	require.NoError(t, d.fw.Close(context.Background()))
+	entries, err := h.Timeline("1.2.3.4", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "ban", entries[0].Action)
+	assert.Equal(t, "alice", entries[0].Operator)
+	assert.Equal(t, "INC-1", entries[0].Ticket)
+	assert.Equal(t, "unban", entries[1].Action)
+	assert.Equal(t, "bob@example.com", entries[1].Operator)
+	assert.Equal(t, []string{"unbanned by admin"}, entries[1].Reasons)
+}
+
+func TestAdminAuth(t *testing.T) {
+	d := &Daemon{fw: newFirewall(t, &mockFirewall{}, firewall.MultiLogger{}, testForgivable)}
+	defer d.fw.Close(context.Background())
+
+	for _, c := range []*config.Admin{
+		{},
+		{Operators: []config.Operator{{Name: "alice"}}},
+		{Operators: []config.Operator{{Name: "alice", Token: "a"}}, IdentityHeader: "X-Forwarded-User"},
+		{Operators: []config.Operator{{Name: "alice", Token: "a"}}, IdentityHeader: "X-Forwarded-User", TrustedProxies: []string{"nope"}},
+	} {
+		_, err := d.newAdminServer(c)
+		assert.Error(t, err, "%+v", c)
+	}
+
+	srv, err := d.newAdminServer(&config.Admin{
+		Operators:      []config.Operator{{Name: "alice", Token: "token-a"}},
+		IdentityHeader: "X-Forwarded-User",
+		TrustedProxies: []string{"10.0.0.1"},
+		PProf:          true,
+	})
+	require.NoError(t, err)
+
+	serve := func(path, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.srv.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	for _, path := range []string{"/v1/bans", "/v1/ips/1.2.3.4", "/debug/diagnostics", "/debug/pprof/"} {
+		assert.Equal(t, http.StatusUnauthorized, serve(path, ""), path)
+		assert.Equal(t, http.StatusOK, serve(path, "token-a"), path)
+	}
+}
+
+func TestForgive(t *testing.T) {
+	logger := &mockLogger{}
+	d := &Daemon{fw: newFirewall(t, &mockFirewall{}, logger, firewall.ForgivableError{Duration: time.Hour, Count: 5, BanInMinute: 10})}
+	defer d.fw.Close(context.Background())
+	handler := adminHandler(t, d)
+
+	logger.wg.Add(4)
+	d.fw.LogIPError("1.2.3.4", "bad password")
+	d.fw.BanIP("1.2.3.4", 10, "abuse")
+	require.Eventually(t, func() bool { return len(d.fw.ListBans()) == 1 }, time.Second, 10*time.Millisecond)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/ips/1.2.3.4", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"bad password": 1`)
+	assert.Contains(t, w.Body.String(), `"abuse"`)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/forgive", strings.NewReader(`{"ip":"1.2.3.4","unban":true}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	logger.wg.Wait()
+	assert.Equal(t, []string{"count error", "ban", "unban", "forgiven"}, logger.actions)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/forgive", strings.NewReader(`{"ip":"nope"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// downBackend fails to unban.
+type downBackend struct {
+	mockFirewall
+}
+
+func (m *downBackend) UnbanIP(ip string) error {
+	return errors.New("appliance down")
+}
+
+func TestForgive_BackendError(t *testing.T) {
+	d := &Daemon{fw: newFirewall(t, &downBackend{}, firewall.MultiLogger{}, testForgivable)}
+	defer d.fw.Close(context.Background())
+	handler := adminHandler(t, d)
+
+	d.fw.BanIP("1.2.3.4", 10, "abuse")
+	require.Eventually(t, func() bool { return len(d.fw.ListBans()) == 1 }, time.Second, 10*time.Millisecond)
+
+	// the ip stays banned
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/forgive", strings.NewReader(`{"ip":"1.2.3.4","unban":true}`)))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, w.Body.String(), "appliance down")
+	assert.Len(t, d.fw.ListBans(), 1)
+}
+
+func TestMitigation(t *testing.T) {
+	d := &Daemon{fw: newFirewall(t, &mockFirewall{}, firewall.MultiLogger{}, testForgivable)}
+	defer d.fw.Close(context.Background())
+	handler := adminHandler(t, d)
+	d.fw.BanIP("10.0.0.0", 10, "scan")
+	d.fw.BanIP("10.0.0.1", 10, "scan")
+	require.Eventually(t, func() bool { return len(d.fw.ListBans()) == 2 }, time.Second, 10*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/mitigation/cidr", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `attachment; filename="firewall-cidr.txt"`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "10.0.0.0/31\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/mitigation/cidr?max=1&slack=0.1", nil))
+	assert.Equal(t, "10.0.0.0/31\n", w.Body.String())
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/mitigation/cidr?slack=1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/mitigation/bgp", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSupervisor(t *testing.T) {
+	failing := func(runs *atomic.Int32) func(context.Context) error {
+		return func(context.Context) error {
+			runs.Add(1)
+			return errors.New("boom")
+		}
+	}
+	blocking := func(stopped *atomic.Bool) func(context.Context) error {
+		return func(ctx context.Context) error {
+			<-ctx.Done()
+			stopped.Store(true)
+			return nil
+		}
+	}
+	newSup := func(c *config.Supervisor) *supervisor {
+		s, err := newSupervisor(c)
+		require.NoError(t, err)
+		s.backoff = func(int) time.Duration { return 0 }
+		return s
+	}
+
+	// a critical component failing stops the others
+	s := newSup(nil)
+	var runs atomic.Int32
+	var stopped atomic.Bool
+	s.add(&component{name: "listener", run: failing(&runs), restart: restartOnFailure, maxRestarts: 2, critical: true})
+	s.add(&component{name: "puller", run: blocking(&stopped)})
+	err := s.run(context.Background())
+	assert.ErrorContains(t, err, "listener failed: boom")
+	assert.Equal(t, int32(3), runs.Load())
+	assert.True(t, stopped.Load())
+
+	// a non critical one is given up, overridden by the config
+	s = newSup(&config.Supervisor{Components: map[string]config.Component{
+		"updater": {Restart: "on-failure", MaxRestarts: 1},
+	}})
+	runs.Store(0)
+	stopped.Store(false)
+	s.add(&component{name: "updater", run: failing(&runs)})
+	s.add(&component{name: "puller", run: blocking(&stopped)})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for runs.Load() < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	assert.NoError(t, s.run(ctx))
+	assert.Equal(t, int32(2), runs.Load())
+	assert.True(t, stopped.Load())
+
+	// unless it fails during the startup grace with strict startup
+	s = newSup(&config.Supervisor{StrictStartup: true})
+	s.add(&component{name: "updater", run: failing(&runs)})
+	s.add(&component{name: "puller", run: blocking(&stopped)})
+	assert.ErrorContains(t, s.run(context.Background()), "updater failed")
+
+	// always restarts a component returning early
+	s = newSup(nil)
+	runs.Store(0)
+	s.add(&component{name: "once", run: func(context.Context) error { runs.Add(1); return nil }, restart: restartAlways, maxRestarts: 1, critical: true})
+	assert.ErrorContains(t, s.run(context.Background()), "once failed: returned early")
+	assert.Equal(t, int32(2), runs.Load())
+
+	_, err = newSupervisor(&config.Supervisor{Components: map[string]config.Component{"misp": {Restart: "sometimes"}}})
+	assert.ErrorContains(t, err, `unknown restart policy "sometimes"`)
+}
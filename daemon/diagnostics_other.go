@@ -0,0 +1,8 @@
+//go:build !unix
+
+package daemon
+
+import "context"
+
+// dumpDiagnosticsOnSignal is not supported, there is no SIGUSR1.
+func (d *Daemon) dumpDiagnosticsOnSignal(ctx context.Context) {}
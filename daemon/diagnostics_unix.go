@@ -0,0 +1,36 @@
+//go:build unix
+
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// dumpDiagnosticsOnSignal writes the firewall diagnostics as json to stderr
+// on SIGUSR1 until ctx is done.
+func (d *Daemon) dumpDiagnosticsOnSignal(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ch:
+				b, err := json.MarshalIndent(d.fw.Diagnostics(), "", "  ")
+				if err != nil {
+					log.Printf("marshal diagnostics failed: %v", err)
+					continue
+				}
+				os.Stderr.Write(append(b, '\n'))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/peer"
+)
+
+const defaultFeedInterval = 5 * time.Minute
+
+func (d *Daemon) setupFeed(c *config.Feed) error {
+	if c.PrivateKey != "" {
+		if d.history == nil {
+			return errors.New("feed requires the history store")
+		}
+		key, err := peer.ParsePrivateKey(c.PrivateKey)
+		if err != nil {
+			return err
+		}
+
+		issuer := c.Issuer
+		if issuer == "" {
+			issuer, _ = os.Hostname()
+		}
+		d.feed = peer.Handler(issuer, key, d.activeBans)
+	}
+
+	if len(c.Peers) == 0 {
+		return nil
+	}
+
+	peers := []peer.Peer{}
+	for _, p := range c.Peers {
+		pub, err := peer.ParsePublicKey(p.PublicKey)
+		if err != nil {
+			return fmt.Errorf("peer %s: %w", p.Name, err)
+		}
+		peers = append(peers, peer.Peer{
+			Name:      p.Name,
+			URL:       p.URL,
+			PublicKey: pub,
+			Ban:       p.Ban,
+			Weight:    p.Weight,
+		})
+	}
+
+	interval := time.Duration(c.Interval)
+	if interval <= 0 {
+		interval = defaultFeedInterval
+	}
+	d.consumer = peer.NewConsumer(d.fw, peers, interval)
+	return nil
+}
+
+func (d *Daemon) activeBans() ([]peer.Ban, error) {
+	bans, err := d.history.ActiveBans(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]peer.Ban, 0, len(bans))
+	for _, b := range bans {
+		res = append(res, peer.Ban{
+			IP:      b.IP,
+			Until:   b.JailUntil,
+			Reasons: b.Reasons,
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/ha"
+)
+
+// haState is the leader election of a daemon in HA mode.
+type haState struct {
+	elector  *ha.Elector
+	gate     *ha.Gate
+	mirror   *ha.Mirror
+	interval time.Duration
+}
+
+// setupHA puts the backend b behind the gate of the leader.
+func (d *Daemon) setupHA(hc *config.HA, b Backend) (Backend, error) {
+	var lock ha.Lock
+	n := 0
+	if hc.FileLock != "" {
+		lock = &ha.FileLock{Path: hc.FileLock}
+		n++
+	}
+	if r := hc.Redis; r != nil {
+		lock = &ha.RedisLock{Addr: r.Address, Password: r.Password, Key: r.Key}
+		n++
+	}
+	if l := hc.Lease; l != nil {
+		if l.Namespace == "" || l.Name == "" {
+			return nil, errors.New("ha lease requires namespace and name")
+		}
+		lock = &ha.LeaseLock{Namespace: l.Namespace, Name: l.Name}
+		n++
+	}
+	if n != 1 {
+		return nil, errors.New("ha requires one of file_lock, redis or lease")
+	}
+
+	id := hc.ID
+	if id == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		id = host
+	}
+
+	d.ha = &haState{
+		elector:  &ha.Elector{Lock: lock, ID: id, TTL: time.Duration(hc.TTL)},
+		gate:     ha.NewGate(b),
+		interval: time.Duration(hc.MirrorInterval),
+	}
+	return d.ha.gate, nil
+}
+
+// setupMirror mirrors the jails of the peer to the firewalls, after they
+// are created.
+func (d *Daemon) setupMirror(hc *config.HA) error {
+	if hc.Peer == "" {
+		return nil
+	}
+	if hc.PeerToken == "" {
+		return errors.New("ha peer requires peer_token")
+	}
+	targets := map[string]ha.Target{"": d.fw}
+	for _, t := range d.tenants {
+		targets[t.name] = t.fw
+	}
+	d.ha.mirror = ha.NewMirror(hc.Peer, hc.PeerToken, targets)
+	return nil
+}
+
+// runHA campaigns for the leader until ctx is done, the lock is released
+// after the firewalls are closed.
+func (d *Daemon) runHA(ctx context.Context) {
+	if d.ha.mirror != nil {
+		go d.ha.mirror.Run(ctx, d.ha.interval)
+	}
+	d.ha.elector.Run(ctx, d.setLeader)
+}
+
+// setLeader opens the gate of a new leader and bans its jails on the
+// backend, which the follower only mirrored. A follower mirrors the jails
+// of the leader.
+func (d *Daemon) setLeader(leader bool) {
+	d.ha.gate.SetLeader(leader)
+	if d.ha.mirror != nil {
+		d.ha.mirror.SetActive(!leader)
+	}
+	if !leader {
+		log.Printf("ha: %s follows", d.ha.elector.ID)
+		return
+	}
+
+	log.Printf("ha: %s leads", d.ha.elector.ID)
+	for _, fw := range d.firewalls() {
+		fw.Restore(fw.ListBans())
+	}
+}
+
+// firewalls returns the firewall of the daemon and those of the tenants.
+func (d *Daemon) firewalls() []*firewall.Firewall {
+	fws := []*firewall.Firewall{d.fw}
+	for _, t := range d.tenants {
+		fws = append(fws, t.fw)
+	}
+	return fws
+}
@@ -0,0 +1,112 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/internal/httpapi"
+)
+
+type errorRequest struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+	// Target is what the error is about, e.g. the account of a failed
+	// login, used to detect credential stuffing.
+	Target string `json:"target,omitempty"`
+	// Metadata is logged with the error, e.g. the url path or the user
+	// agent.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type banRequest struct {
+	IP       string            `json:"ip"`
+	Minutes  int               `json:"minutes"`
+	Reason   string            `json:"reason"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (d *Daemon) ingestHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/error", d.handleError)
+	mux.HandleFunc("POST /v1/ban", d.handleBan)
+	if len(d.recipes) > 0 {
+		mux.HandleFunc("POST /v1/log", d.handleLog)
+	}
+	if d.feed != nil {
+		mux.Handle("GET /v1/feed", d.feed)
+	}
+	if d.taxii != nil {
+		mux.Handle("/taxii2/", d.taxii)
+	}
+	if d.export != nil {
+		mux.Handle("GET /v1/export", d.export)
+	}
+	return mux
+}
+
+// checkIngestAuth rejects an ingest api anyone reaching it can ban with:
+// without tenants or an ingest token, it must listen on loopback only.
+func checkIngestAuth(dc *config.Daemon) error {
+	if dc.Listen == "" || len(dc.Tenants) > 0 || dc.IngestToken != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(dc.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen %q: %w", dc.Listen, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("ingest api on %q requires ingest_token or tenants unless it listens on loopback", dc.Listen)
+	}
+	return nil
+}
+
+// tokenHandler requires token as the "token" query parameter if set.
+func tokenHandler(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handleError counts an error of the ip.
+func (d *Daemon) handleError(w http.ResponseWriter, r *http.Request) {
+	fw, source := d.firewallFor(w, r)
+	if fw == nil || !d.checkQuota(w, source, false) {
+		return
+	}
+	req := &errorRequest{}
+	if !httpapi.Decode(w, r, req) || !httpapi.ValidIP(w, &req.IP) {
+		return
+	}
+
+	fw.LogIPErrorWithMetadata(req.IP, req.Target, req.Reason, d.withSource(req.Metadata, source))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleBan bans the ip immediately.
+func (d *Daemon) handleBan(w http.ResponseWriter, r *http.Request) {
+	fw, source := d.firewallFor(w, r)
+	if fw == nil || !d.checkQuota(w, source, true) {
+		return
+	}
+	req := &banRequest{}
+	if !httpapi.Decode(w, r, req) || !httpapi.ValidIP(w, &req.IP) {
+		return
+	}
+	if req.Minutes <= 0 {
+		http.Error(w, "minutes should be positive", http.StatusBadRequest)
+		return
+	}
+
+	fw.BanIPWithMetadata(req.IP, req.Minutes, req.Reason, req.Metadata)
+	w.WriteHeader(http.StatusAccepted)
+}
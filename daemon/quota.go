@@ -0,0 +1,251 @@
+package daemon
+
+import (
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/internal/httpapi"
+)
+
+// sourceMetadataKey tags the errors of an ingest source, so the bans they
+// lead to are charged to its quota, see quotaFilter.
+const sourceMetadataKey = "ingest_source"
+
+// quotaSweepInterval is how often the sources back to a full quota are
+// evicted.
+const quotaSweepInterval = time.Minute
+
+// quotas limit the events and bans of each ingest source, so a misbehaving
+// source can not use up the ban budget of others.
+type quotas struct {
+	def *config.Quota
+	// per source quota config, falls back to def.
+	configs map[string]*config.Quota
+
+	mu      sync.Mutex
+	sources map[string]*sourceQuota
+	// lastSweep is when sources were last evicted, see sweep.
+	lastSweep time.Time
+}
+
+type sourceQuota struct {
+	events *rate.Limiter
+	bans   *rate.Limiter
+
+	accepted       uint64
+	rejectedEvents uint64
+	rejectedBans   uint64
+}
+
+// QuotaInfo is the usage of a source.
+type QuotaInfo struct {
+	Source         string `json:"source"`
+	Accepted       uint64 `json:"accepted"`
+	RejectedEvents uint64 `json:"rejected_events"`
+	RejectedBans   uint64 `json:"rejected_bans"`
+}
+
+func newQuotas(def *config.Quota) *quotas {
+	return &quotas{
+		def:       def,
+		configs:   map[string]*config.Quota{},
+		sources:   map[string]*sourceQuota{},
+		lastSweep: time.Now(),
+	}
+}
+
+func newLimiter(perSecond float64, burst int) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(perSecond))
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+func (q *quotas) source(name string) *sourceQuota {
+	s, ok := q.sources[name]
+	if ok {
+		return s
+	}
+
+	c := q.configs[name]
+	if c == nil {
+		c = q.def
+	}
+	if c == nil {
+		c = &config.Quota{}
+	}
+	s = &sourceQuota{
+		events: newLimiter(c.EventsPerSecond, c.Burst),
+		bans:   newLimiter(float64(c.BansPerHour)/time.Hour.Seconds(), c.BansPerHour),
+	}
+	q.sources[name] = s
+	return s
+}
+
+// allow returns "" if the request of source is in quota, otherwise the kind
+// of quota exceeded: "events" or "bans".
+func (q *quotas) allow(source string, ban bool) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if now := time.Now(); now.Sub(q.lastSweep) >= quotaSweepInterval {
+		q.sweep(now)
+	}
+	s := q.source(source)
+	if !s.events.Allow() {
+		s.rejectedEvents++
+		return "events"
+	}
+	if ban && !s.bans.Allow() {
+		s.rejectedBans++
+		return "bans"
+	}
+	s.accepted++
+	return ""
+}
+
+// allowBan returns whether a ban the errors of source lead to is in its
+// bans quota.
+func (q *quotas) allowBan(source string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := q.source(source)
+	if !s.bans.Allow() {
+		s.rejectedBans++
+		return false
+	}
+	return true
+}
+
+// sweep evicts the sources without config whose quota is full again, so
+// clients rotating their address do not grow the sources without bound.
+// A source evicted starts again with the same full quota, only its counts
+// are lost.
+func (q *quotas) sweep(now time.Time) {
+	q.lastSweep = now
+	for name, s := range q.sources {
+		if q.configs[name] == nil && full(s.events, now) && full(s.bans, now) {
+			delete(q.sources, name)
+		}
+	}
+}
+
+// full returns whether l has all of its burst at now.
+func full(l *rate.Limiter, now time.Time) bool {
+	return l.Limit() == rate.Inf || l.TokensAt(now) >= float64(l.Burst())
+}
+
+func (q *quotas) snapshot() []QuotaInfo {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	res := []QuotaInfo{}
+	for name, s := range q.sources {
+		res = append(res, QuotaInfo{
+			Source:         name,
+			Accepted:       s.accepted,
+			RejectedEvents: s.rejectedEvents,
+			RejectedBans:   s.rejectedBans,
+		})
+	}
+	slices.SortFunc(res, func(a, b QuotaInfo) int {
+		return strings.Compare(a.Source, b.Source)
+	})
+	return res
+}
+
+// remoteSource is the source of a request without tenants.
+func remoteSource(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkQuota responds 429 and returns false if the request exceeds the
+// quota of source.
+func (d *Daemon) checkQuota(w http.ResponseWriter, source string, ban bool) bool {
+	if d.quotas == nil {
+		return true
+	}
+
+	kind := d.quotas.allow(source, ban)
+	if kind == "" {
+		return true
+	}
+	if d.metrics != nil {
+		d.metrics.IngestRejected(source, kind)
+	}
+	http.Error(w, kind+" quota exceeded", http.StatusTooManyRequests)
+	return false
+}
+
+// withSource returns md of an error reported by source, tagged so the ban
+// it leads to is charged to the quota of source. md is returned unchanged
+// without quotas.
+func (d *Daemon) withSource(md map[string]string, source string) map[string]string {
+	if d.quotas == nil {
+		return md
+	}
+	// the tag is kept when the firewall caps the keys.
+	res := map[string]string{}
+	for _, k := range slices.Sorted(maps.Keys(md)) {
+		if len(res) == firewall.MaxMetadataKeys-1 {
+			break
+		}
+		res[k] = md[k]
+	}
+	res[sourceMetadataKey] = source
+	return res
+}
+
+// quotaFilter charges the bans the errors of a source lead to against its
+// bans quota, they are vetoed once it is used up. Bans requested directly
+// are charged by checkQuota.
+type quotaFilter struct {
+	d *Daemon
+	// next is the ban filter of the daemon, nil if none.
+	next firewall.IBanFilter
+}
+
+func (f *quotaFilter) BeforeBan(p *firewall.PendingBan) (bool, error) {
+	var err error
+	if f.next != nil {
+		var ok bool
+		if ok, err = f.next.BeforeBan(p); err == nil && !ok {
+			return false, nil
+		}
+	}
+
+	source, ok := p.Metadata[sourceMetadataKey]
+	if !ok || f.d.quotas.allowBan(source) {
+		return true, err
+	}
+	if f.d.metrics != nil {
+		f.d.metrics.IngestRejected(source, "bans")
+	}
+	return false, nil
+}
+
+func (d *Daemon) handleQuotas(w http.ResponseWriter, r *http.Request) {
+	res := []QuotaInfo{}
+	if d.quotas != nil {
+		res = d.quotas.snapshot()
+	}
+	httpapi.WriteJSON(w, res)
+}
@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/internal/httpapi"
+	"github.com/charleshuang3/firewall/recipe"
+)
+
+type logRequest struct {
+	Recipe string   `json:"recipe"`
+	Lines  []string `json:"lines"`
+}
+
+// setupJails looks up the recipes of the jails and merges their thresholds
+// into the ones by reason, the ones configured by reason win.
+func (d *Daemon) setupJails(dc *config.Daemon) error {
+	d.reasonForgivable = newReasonForgivable(dc.ForgivableByReason)
+	for _, j := range dc.Jails {
+		r, ok := recipe.Get(j.Recipe)
+		if !ok {
+			return fmt.Errorf("unknown recipe %q, one of %s", j.Recipe, strings.Join(recipe.Names(), ", "))
+		}
+		if d.recipes == nil {
+			d.recipes = map[string]*recipe.Recipe{}
+		}
+		d.recipes[r.Name] = r
+
+		if _, ok := dc.ForgivableByReason[r.Name]; ok {
+			continue
+		}
+		f := r.Forgivable
+		if j.Forgivable != nil {
+			f = newForgivable(j.Forgivable)
+		}
+		d.reasonForgivable[r.Name] = f
+	}
+	return nil
+}
+
+// handleLog counts the errors in log lines of a jail, lines matching no
+// pattern are ignored.
+func (d *Daemon) handleLog(w http.ResponseWriter, r *http.Request) {
+	fw, source := d.firewallFor(w, r)
+	if fw == nil || !d.checkQuota(w, source, false) {
+		return
+	}
+	req := &logRequest{}
+	if !httpapi.Decode(w, r, req) {
+		return
+	}
+	rc, ok := d.recipes[req.Recipe]
+	if !ok {
+		http.Error(w, fmt.Sprintf("no jail of recipe %q", req.Recipe), http.StatusBadRequest)
+		return
+	}
+
+	md := d.withSource(nil, source)
+	for _, line := range req.Lines {
+		if m, ok := rc.Match(line); ok {
+			fw.LogIPErrorWithMetadata(m.IP, m.Target, m.Reason, md)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
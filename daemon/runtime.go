@@ -0,0 +1,62 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/charleshuang3/firewall/config"
+)
+
+// tuneRuntime applies the runtime config.
+func tuneRuntime(c *config.Runtime) error {
+	if c.GOMAXPROCS > 0 {
+		runtime.GOMAXPROCS(c.GOMAXPROCS)
+	}
+
+	if c.GCPercent != 0 {
+		debug.SetGCPercent(c.GCPercent)
+	}
+
+	if c.MemoryLimit != "" {
+		limit, err := parseSize(c.MemoryLimit)
+		if err != nil {
+			return err
+		}
+		debug.SetMemoryLimit(limit)
+	}
+
+	log.Printf("runtime: GOMAXPROCS=%d, gc_percent=%d, memory_limit=%q", runtime.GOMAXPROCS(0), c.GCPercent, c.MemoryLimit)
+	return nil
+}
+
+// parseSize parses bytes with optional KiB, MiB or GiB suffix.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		n      int64
+	}{
+		{"KiB", 1 << 10},
+		{"MiB", 1 << 20},
+		{"GiB", 1 << 30},
+	}
+
+	mult := int64(1)
+	num := s
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			mult = u.n
+			num = strings.TrimSuffix(s, u.suffix)
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,194 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/charleshuang3/firewall/config"
+)
+
+const (
+	defaultStartupGrace = 30 * time.Second
+	defaultMaxRestarts  = 5
+	minRestartBackoff   = time.Second
+	maxRestartBackoff   = time.Minute
+)
+
+// restartPolicy is when a component is restarted after it returns.
+type restartPolicy string
+
+const (
+	restartNever     restartPolicy = "never"
+	restartOnFailure restartPolicy = "on-failure"
+	restartAlways    restartPolicy = "always"
+)
+
+// component is a subsystem of the daemon run by the supervisor, e.g. a
+// listener or a puller.
+type component struct {
+	name string
+	// run blocks until ctx is done, an error or a return before fails the
+	// component.
+	run         func(ctx context.Context) error
+	restart     restartPolicy
+	maxRestarts int
+	// critical components stop the daemon when they fail for good.
+	critical bool
+}
+
+// supervisor runs components under an errgroup: a critical component
+// failing for good cancels the others and fails run. Non critical ones are
+// given up after their restarts, unless they fail during the startup grace
+// with strictStartup.
+type supervisor struct {
+	components []*component
+	// overrides are the policies of components by name.
+	overrides     map[string]config.Component
+	strictStartup bool
+	startupGrace  time.Duration
+	// backoff is the delay before restart n, 0 based.
+	backoff func(n int) time.Duration
+}
+
+func newSupervisor(c *config.Supervisor) (*supervisor, error) {
+	s := &supervisor{startupGrace: defaultStartupGrace, backoff: restartBackoff}
+	if c == nil {
+		return s, nil
+	}
+	for name, o := range c.Components {
+		switch restartPolicy(o.Restart) {
+		case "", restartNever, restartOnFailure, restartAlways:
+		default:
+			return nil, fmt.Errorf("component %s: unknown restart policy %q", name, o.Restart)
+		}
+	}
+	s.overrides = c.Components
+	s.strictStartup = c.StrictStartup
+	if c.StartupGrace > 0 {
+		s.startupGrace = time.Duration(c.StartupGrace)
+	}
+	return s, nil
+}
+
+func restartBackoff(n int) time.Duration {
+	return min(minRestartBackoff<<min(n, 16), maxRestartBackoff)
+}
+
+// add adds a component with its default policy, overridden by the config
+// of its name.
+func (s *supervisor) add(c *component) {
+	if o, ok := s.overrides[c.name]; ok {
+		if o.Restart != "" {
+			c.restart = restartPolicy(o.Restart)
+		}
+		if o.MaxRestarts != 0 {
+			c.maxRestarts = o.MaxRestarts
+		}
+		if o.Critical != nil {
+			c.critical = *o.Critical
+		}
+	}
+	if c.restart == "" {
+		c.restart = restartNever
+	}
+	if c.maxRestarts == 0 {
+		c.maxRestarts = defaultMaxRestarts
+	}
+	s.components = append(s.components, c)
+}
+
+// run runs the components until ctx is done or one fails the daemon.
+func (s *supervisor) run(ctx context.Context) error {
+	g, ctx := errgroup.WithContext(ctx)
+	started := time.Now()
+	for _, c := range s.components {
+		g.Go(func() error {
+			return s.supervise(ctx, c, started)
+		})
+	}
+	return g.Wait()
+}
+
+func (s *supervisor) supervise(ctx context.Context, c *component, started time.Time) error {
+	for restarts := 0; ; restarts++ {
+		err := c.run(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			if c.restart != restartAlways {
+				return nil
+			}
+			err = errors.New("returned early")
+		}
+		err = fmt.Errorf("%s failed: %w", c.name, err)
+
+		if c.restart == restartNever || c.maxRestarts >= 0 && restarts >= c.maxRestarts {
+			if c.critical || s.strictStartup && time.Since(started) < s.startupGrace {
+				return err
+			}
+			log.Printf("%v, giving up", err)
+			return nil
+		}
+
+		delay := s.backoff(restarts)
+		log.Printf("%v, restarting in %s", err, delay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// serverComponent serves s until ctx is done, then shuts it down.
+func serverComponent(s *server) *component {
+	return &component{
+		name: s.name,
+		run: func(ctx context.Context) error {
+			errCh := make(chan error, 1)
+			go func() {
+				log.Printf("%s listening on %s", s.name, s.srv.Addr)
+				if s.tls {
+					errCh <- s.srv.ListenAndServeTLS("", "")
+				} else {
+					errCh <- s.srv.ListenAndServe()
+				}
+			}()
+
+			select {
+			case err := <-errCh:
+				return err
+			case <-ctx.Done():
+			}
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			s.srv.Shutdown(shutdownCtx)
+			if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+		critical: true,
+	}
+}
+
+// startCloseComponent runs a subsystem with its own goroutine from start
+// until ctx is done.
+func startCloseComponent(name string, start, close func()) *component {
+	return &component{
+		name: name,
+		run: func(ctx context.Context) error {
+			start()
+			<-ctx.Done()
+			close()
+			return nil
+		},
+	}
+}
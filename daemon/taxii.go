@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"errors"
+	"time"
+
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/stix"
+)
+
+const defaultTAXIIWindow = 24 * time.Hour
+
+func (d *Daemon) setupTAXII(c *config.TAXII) error {
+	if d.history == nil {
+		return errors.New("taxii requires the history store")
+	}
+
+	s := &stix.Server{
+		Title:  c.Title,
+		Window: time.Duration(c.Window),
+		Source: d.bansSince,
+	}
+	if s.Title == "" {
+		s.Title = serviceName + " bans"
+	}
+	if s.Window <= 0 {
+		s.Window = defaultTAXIIWindow
+	}
+	d.taxii = s.Handler()
+	return nil
+}
+
+func (d *Daemon) bansSince(since time.Time) ([]stix.Ban, error) {
+	bans, err := d.history.BansSince(since)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]stix.Ban, 0, len(bans))
+	for _, b := range bans {
+		res = append(res, stix.Ban{
+			IP:      b.IP,
+			Time:    b.Time,
+			Until:   b.JailUntil,
+			Reasons: b.Reasons,
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,191 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/config"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+var (
+	_ firewall.IActionFirewall  = (*sharedBackend)(nil)
+	_ firewall.IRoutingFirewall = (*sharedBackend)(nil)
+)
+
+// tenant has its own firewall, so its jail and error counts are isolated
+// from other tenants.
+type tenant struct {
+	name  string
+	token string
+	fw    *firewall.Firewall
+}
+
+// sharedBackend serializes the calls of the tenant firewalls, backends
+// update the block list by read-modify-write.
+type sharedBackend struct {
+	mu sync.Mutex
+	b  Backend
+}
+
+func (s *sharedBackend) Name() string {
+	return s.b.Name()
+}
+
+func (s *sharedBackend) ExpiresBans() bool {
+	e, ok := s.b.(firewall.IExpiringFirewall)
+	return ok && e.ExpiresBans()
+}
+
+func (s *sharedBackend) BansNetworks() bool {
+	n, ok := s.b.(firewall.INetworkFirewall)
+	return ok && n.BansNetworks()
+}
+
+func (s *sharedBackend) BanIP(ip string, timeoutInMinute int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.b.BanIP(ip, timeoutInMinute)
+}
+
+func (s *sharedBackend) TryBanIP(ip string, timeoutInMinute int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.TryBanIP(ip, timeoutInMinute)
+}
+
+// RouteBanIP passes the reasons of the ban to a backend routing bans, e.g.
+// a firewall.Router of the daemon routes.
+func (s *sharedBackend) RouteBanIP(ip string, timeoutInMinute int, reasons []string) error {
+	return s.ActionBanIP(ip, timeoutInMinute, firewall.BanActionBlock, reasons)
+}
+
+// ActionBanIP passes the action and the reasons of the ban to a backend
+// enforcing actions, e.g. the OPNsense backend of action_list_uuids.
+func (s *sharedBackend) ActionBanIP(ip string, timeoutInMinute int, action firewall.BanAction, reasons []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return firewall.ForwardBan(s.b, ip, timeoutInMinute, action, reasons)
+}
+
+func (s *sharedBackend) UnbanIP(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.UnbanIP(ip)
+}
+
+// tenantState keeps the state of a tenant apart in the shared state store.
+type tenantState struct {
+	s      firewall.IStateStore
+	prefix string
+}
+
+func (t *tenantState) LoadState(key string) ([]byte, error) {
+	return t.s.LoadState(t.prefix + key)
+}
+
+func (t *tenantState) SaveState(key string, value []byte) error {
+	return t.s.SaveState(t.prefix+key, value)
+}
+
+func (d *Daemon) setupTenants(dc *config.Daemon, fw firewall.IFirewall, logger firewall.ILogger, geo *ipgeo.AutoUpdateMMIPGeo) error {
+	seen := map[string]bool{}
+	for _, t := range dc.Tenants {
+		if t.Name == "" || t.Token == "" {
+			return errors.New("tenant requires name and token")
+		}
+		if seen[t.Name] || seen[t.Token] {
+			return fmt.Errorf("duplicated tenant %q", t.Name)
+		}
+		seen[t.Name] = true
+		seen[t.Token] = true
+
+		forgivable := &dc.Forgivable
+		if t.Forgivable != nil {
+			forgivable = t.Forgivable
+		}
+		opts := []firewall.Option{
+			firewall.WithLabels(map[string]string{"tenant": t.Name}),
+		}
+		if t.MaxBanPerMinute > 0 {
+			opts = append(opts, firewall.WithMaxBanRate(t.MaxBanPerMinute))
+		}
+		opts = append(opts, d.firewallOptions(dc)...)
+		if d.history != nil {
+			opts = append(opts, firewall.WithStateStore(&tenantState{s: d.history, prefix: "tenant/" + t.Name + "/"}))
+		}
+
+		if t.Quota != nil && d.quotas != nil {
+			d.quotas.configs[t.Name] = t.Quota
+		}
+
+		whitelist := append(append([]string{}, dc.Whitelist...), t.Whitelist...)
+		tfw, err := firewall.New(whitelist, fw, logger, geo, newForgivable(forgivable), opts...)
+		if err != nil {
+			return fmt.Errorf("tenant %q: %w", t.Name, err)
+		}
+		if d.metrics != nil {
+			d.metrics.Watch(t.Name, tfw)
+		}
+		d.tenants = append(d.tenants, &tenant{
+			name:  t.Name,
+			token: t.Token,
+			fw:    tfw,
+		})
+	}
+	return nil
+}
+
+// firewallFor returns the firewall and the source name of the request.
+// With tenants, the request must carry a tenant token as bearer token,
+// without, the ingest token if set, otherwise it responds 401 and returns
+// nil.
+func (d *Daemon) firewallFor(w http.ResponseWriter, r *http.Request) (*firewall.Firewall, string) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(d.tenants) == 0 {
+		if d.ingestToken != "" && (!ok || subtle.ConstantTimeCompare([]byte(token), []byte(d.ingestToken)) != 1) {
+			http.Error(w, "invalid ingest token", http.StatusUnauthorized)
+			return nil, ""
+		}
+		return d.fw, remoteSource(r)
+	}
+
+	if ok {
+		for _, t := range d.tenants {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t.token)) == 1 {
+				return t.fw, t.name
+			}
+		}
+	}
+
+	http.Error(w, "invalid tenant token", http.StatusUnauthorized)
+	return nil, ""
+}
+
+// tenantFirewall returns the firewall of the tenant name, the daemon
+// firewall if name is empty, or nil if there is no such tenant.
+func (d *Daemon) tenantFirewall(name string) *firewall.Firewall {
+	if name == "" {
+		return d.fw
+	}
+	for _, t := range d.tenants {
+		if t.name == name {
+			return t.fw
+		}
+	}
+	return nil
+}
+
+func hasTenantQuota(tenants []config.Tenant) bool {
+	for _, t := range tenants {
+		if t.Quota != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/history"
+)
+
+// warmUpTimeout bounds the polls of feeds on warm up.
+const warmUpTimeout = 30 * time.Second
+
+// warmUpBans restores the active bans of the history store to the firewall
+// of their tenant, then polls the peer feeds, MISP and the blocklists once,
+// before the ingest api serves.
+func (d *Daemon) warmUpBans(ctx context.Context) {
+	if d.history != nil {
+		bans, err := d.history.ActiveBans(time.Now())
+		if err != nil {
+			log.Printf("warm up: load active bans failed: %v", err)
+		} else {
+			log.Printf("warm up: restored %d bans", d.restore(bans))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, warmUpTimeout)
+	defer cancel()
+	if d.consumer != nil {
+		if err := d.consumer.Poll(ctx); err != nil {
+			log.Printf("warm up: poll peer feeds failed: %v", err)
+		}
+	}
+	if d.mispPuller != nil {
+		if err := d.mispPuller.Pull(ctx); err != nil {
+			log.Printf("warm up: pull misp failed: %v", err)
+		}
+	}
+	for _, b := range d.blocklists {
+		if err := b.Pull(ctx); err != nil {
+			log.Printf("warm up: pull blocklist %s failed: %v", b.Name(), err)
+		}
+	}
+}
+
+// restore jails bans in the firewall of their tenant label, bans of tenants
+// no longer configured are skipped. It returns the number of bans restored.
+func (d *Daemon) restore(bans []history.Ban) int {
+	byTenant := map[string][]firewall.BanInfo{}
+	for _, b := range bans {
+		tenant := b.Labels["tenant"]
+		info := firewall.BanInfo{
+			IP:            b.IP,
+			Until:         b.JailUntil,
+			Reasons:       b.Reasons,
+			CorrelationID: b.CorrelationID,
+			BanAction:     b.BanAction,
+		}
+		if b.Operator != "" || b.Ticket != "" {
+			info.Annotation = &firewall.Annotation{Operator: b.Operator, Ticket: b.Ticket}
+		}
+		byTenant[tenant] = append(byTenant[tenant], info)
+	}
+
+	restored := 0
+	for tenant, infos := range byTenant {
+		fw := d.tenantFirewall(tenant)
+		if fw == nil {
+			log.Printf("warm up: skip %d bans of unknown tenant %q", len(infos), tenant)
+			continue
+		}
+		restored += fw.Restore(infos)
+	}
+	return restored
+}
@@ -0,0 +1,123 @@
+package firewall
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	// bannedLogInterval limits "banned" events, errors of an already banned
+	// ip, to one per ip in the interval.
+	bannedLogInterval = time.Hour
+	// dedupeFlushInterval is how often the dedupe state is pruned and
+	// saved to the state store.
+	dedupeFlushInterval = time.Minute
+	dedupeStateKey      = "dedupe"
+)
+
+// IStateStore persists state of the firewall across restarts. LoadState
+// returns nil without error if key is never saved.
+type IStateStore interface {
+	LoadState(key string) ([]byte, error)
+	SaveState(key string, value []byte) error
+}
+
+// dedupe remembers when an ip is last logged. It is only accessed in the
+// loop goroutine.
+type dedupe struct {
+	lastLogged map[string]time.Time
+	dirty      bool
+	flushedAt  time.Time
+}
+
+func newDedupe(now time.Time) *dedupe {
+	return &dedupe{
+		lastLogged: map[string]time.Time{},
+		flushedAt:  now,
+	}
+}
+
+// allow reports whether ip can be logged at now, and records it if so.
+func (d *dedupe) allow(ip string, now time.Time) bool {
+	if t, ok := d.lastLogged[ip]; ok && now.Sub(t) < bannedLogInterval {
+		return false
+	}
+	d.lastLogged[ip] = now
+	d.dirty = true
+	return true
+}
+
+func (d *dedupe) prune(now time.Time) {
+	for ip, t := range d.lastLogged {
+		if now.Sub(t) >= bannedLogInterval {
+			delete(d.lastLogged, ip)
+			d.dirty = true
+		}
+	}
+}
+
+// marshal encodes the state compactly as ip to unix seconds.
+func (d *dedupe) marshal() ([]byte, error) {
+	m := make(map[string]int64, len(d.lastLogged))
+	for ip, t := range d.lastLogged {
+		m[ip] = t.Unix()
+	}
+	return json.Marshal(m)
+}
+
+func (d *dedupe) unmarshal(b []byte) error {
+	m := map[string]int64{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	for ip, t := range m {
+		d.lastLogged[ip] = time.Unix(t, 0)
+	}
+	return nil
+}
+
+// loadDedupe restores the dedupe state saved before a restart.
+func (s *Firewall) loadDedupe() {
+	b, err := s.state.LoadState(dedupeStateKey)
+	if err != nil {
+		log.Printf("load dedupe state failed: %v", err)
+		return
+	}
+	if b == nil {
+		return
+	}
+	if err := s.bannedLogged.unmarshal(b); err != nil {
+		log.Printf("decode dedupe state failed: %v", err)
+	}
+}
+
+// flushDedupe prunes the dedupe state and saves it if changed, at most once
+// per dedupeFlushInterval.
+func (s *Firewall) flushDedupe(now time.Time) {
+	d := s.bannedLogged
+	if now.Sub(d.flushedAt) < dedupeFlushInterval {
+		return
+	}
+	d.flushedAt = now
+	d.prune(now)
+	s.saveDedupe()
+}
+
+// saveDedupe saves the dedupe state if changed.
+func (s *Firewall) saveDedupe() {
+	d := s.bannedLogged
+	if s.state == nil || !d.dirty {
+		return
+	}
+	b, err := d.marshal()
+	if err != nil {
+		log.Printf("encode dedupe state failed: %v", err)
+		return
+	}
+	if err := s.state.SaveState(dedupeStateKey, b); err != nil {
+		log.Printf("save dedupe state failed: %v", err)
+		return
+	}
+	d.dirty = false
+}
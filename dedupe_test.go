@@ -0,0 +1,74 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MockStateStore is an in-memory IStateStore.
+type MockStateStore struct {
+	m map[string][]byte
+}
+
+func (m *MockStateStore) LoadState(key string) ([]byte, error) {
+	return m.m[key], nil
+}
+
+func (m *MockStateStore) SaveState(key string, value []byte) error {
+	m.m[key] = value
+	return nil
+}
+
+func TestDedupe(t *testing.T) {
+	d := newDedupe(time.Now())
+	now := time.Now()
+
+	assert.True(t, d.allow("1.2.3.4", now))
+	assert.False(t, d.allow("1.2.3.4", now.Add(59*time.Minute)))
+	assert.True(t, d.allow("1.2.3.5", now))
+	assert.True(t, d.allow("1.2.3.4", now.Add(time.Hour)))
+
+	d.prune(now.Add(90 * time.Minute))
+	assert.Equal(t, map[string]time.Time{"1.2.3.4": now.Add(time.Hour)}, d.lastLogged)
+}
+
+func TestBannedDedupe_AcrossRestart(t *testing.T) {
+	store := &MockStateStore{m: map[string][]byte{}}
+	forgivable := testForgivable
+
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, &MockIFirewall{}, mockLogger, nil, forgivable, WithStateStore(store))
+	require.NoError(t, err)
+
+	// count error, ban, banned, the next errors are deduped.
+	mockLogger.Wg.Add(3)
+	for i := 0; i < 5; i++ {
+		fw.LogIPError("1.2.3.4", "r")
+	}
+	fw.do(func() {})
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "banned", mockLogger.Events[2].Action)
+	assert.Len(t, mockLogger.Events, 3)
+
+	fw.do(func() {
+		fw.flushDedupe(time.Now().Add(dedupeFlushInterval))
+	})
+	require.NotNil(t, store.m[dedupeStateKey])
+
+	// after a restart, the ip is banned again, but "banned" stays deduped.
+	mockLogger = &MockEventLogger{}
+	fw, err = New([]string{}, &MockIFirewall{}, mockLogger, nil, forgivable, WithStateStore(store))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	for i := 0; i < 5; i++ {
+		fw.LogIPError("1.2.3.4", "r")
+	}
+	fw.do(func() {})
+	mockLogger.Wg.Wait()
+	assert.Len(t, mockLogger.Events, 2)
+	assert.Equal(t, "ban", mockLogger.Events[1].Action)
+}
@@ -0,0 +1,162 @@
+package firewall
+
+import (
+	"cmp"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+const (
+	diagnosticsTopN       = 10
+	keepLastBackendErrors = 10
+)
+
+// Diagnostics is a snapshot of the internal state, for debugging stuck
+// pipelines.
+type Diagnostics struct {
+	Time       time.Time `json:"time"`
+	Goroutines int       `json:"goroutines"`
+
+	BanQueue   int `json:"ban_queue"`
+	CountQueue int `json:"count_queue"`
+
+	Jailed        int           `json:"jailed"`
+	ErrorCounters int           `json:"error_counters"`
+	TopCounted    []CounterInfo `json:"top_counted"`
+
+	Paused            bool               `json:"paused"`
+	LastBackendErrors []BackendErrorInfo `json:"last_backend_errors"`
+	Enforcement       EnforcementStats   `json:"enforcement"`
+}
+
+// CounterInfo is the error counter of an ip.
+type CounterInfo struct {
+	IP          string    `json:"ip"`
+	Offenses    int       `json:"offenses"`
+	BannedUntil time.Time `json:"banned_until,omitzero"`
+}
+
+// IPInfo is what the firewall knows about an ip.
+type IPInfo struct {
+	IP          string `json:"ip"`
+	Whitelisted bool   `json:"whitelisted"`
+	// Offenses are the counted errors by reason, not expired yet.
+	Offenses map[string]int `json:"offenses,omitempty"`
+	// Ban is the ban of the ip, nil if not jailed.
+	Ban *BanInfo     `json:"ban,omitempty"`
+	Geo *ipgeo.IPGeo `json:"geo,omitempty"`
+}
+
+// BackendErrorInfo is a failed backend call.
+type BackendErrorInfo struct {
+	Time    time.Time `json:"time"`
+	IP      string    `json:"ip"`
+	Backend string    `json:"backend"`
+	Op      string    `json:"op"`
+	Class   string    `json:"class"`
+	Error   string    `json:"error"`
+}
+
+func (s *Firewall) recordBackendError(ip string, be *BackendError) {
+	s.lastBackendErrors = append(s.lastBackendErrors, BackendErrorInfo{
+		Time:    s.now(),
+		IP:      ip,
+		Backend: be.Backend,
+		Op:      be.Op,
+		Class:   be.Class(),
+		Error:   be.Err.Error(),
+	})
+	if n := len(s.lastBackendErrors); n > keepLastBackendErrors {
+		s.lastBackendErrors = slices.Clone(s.lastBackendErrors[n-keepLastBackendErrors:])
+	}
+}
+
+// Diagnostics returns a snapshot of the internal state.
+func (s *Firewall) Diagnostics() *Diagnostics {
+	d := &Diagnostics{
+		Time:        s.now(),
+		Goroutines:  runtime.NumGoroutine(),
+		BanQueue:    len(s.banCh),
+		CountQueue:  len(s.countCh),
+		Enforcement: s.EnforcementStats(),
+	}
+
+	s.do(func() {
+		d.Jailed = len(s.jail)
+		d.ErrorCounters = len(s.errorCount)
+		for ip, ec := range s.errorCount {
+			d.TopCounted = append(d.TopCounted, CounterInfo{
+				IP:          ip,
+				Offenses:    ec.offenses.Size(),
+				BannedUntil: ec.bannedUntil,
+			})
+		}
+		d.Paused = s.valve.isPaused() || s.sharedValve.isPaused()
+		d.LastBackendErrors = slices.Clone(s.lastBackendErrors)
+	})
+
+	slices.SortFunc(d.TopCounted, func(a, b CounterInfo) int {
+		return cmp.Or(
+			cmp.Compare(b.Offenses, a.Offenses),
+			b.BannedUntil.Compare(a.BannedUntil),
+			cmp.Compare(a.IP, b.IP),
+		)
+	})
+	if len(d.TopCounted) > diagnosticsTopN {
+		d.TopCounted = d.TopCounted[:diagnosticsTopN]
+	}
+
+	return d
+}
+
+// InspectIP returns the counters, the ban and the geo data of ip.
+func (s *Firewall) InspectIP(ip string) *IPInfo {
+	ip = normalizeIP(ip)
+	res := &IPInfo{IP: ip}
+	now := s.now()
+	s.do(func() {
+		res.Whitelisted = s.inWhitelist(ip)
+		if ec, ok := s.errorCount[ip]; ok && ec.offenses.Size() > 0 {
+			// the queue has no iterator keeping its elements
+			offenses := ec.offenses.Clear()
+			for _, o := range offenses {
+				ec.offenses.Offer(o)
+			}
+			res.Offenses = countReasons(offenses, maxReasonCountsSize)
+		}
+		if j, ok := s.jail[ip]; ok && j.until.After(now) {
+			res.Ban = &BanInfo{
+				IP:            ip,
+				Until:         j.until,
+				Reasons:       slices.Clone(j.reasons),
+				Geo:           j.geo,
+				CorrelationID: j.correlationID,
+				Annotation:    j.annotation,
+				BanAction:     j.action,
+			}
+		}
+	})
+	res.Geo = s.LookupGeo(ip)
+	return res
+}
+
+// LookupGeo returns the geo data of ip, nil without geo database.
+func (s *Firewall) LookupGeo(ip string) *ipgeo.IPGeo {
+	if s.ipGeo == nil {
+		return nil
+	}
+	return s.ipGeo.GetIPGeo(ip)
+}
+
+// GeoMetadata returns edition and build time of the geo databases in use,
+// false if the geo provider has no metadata, e.g. ipgeo.StaticProvider.
+func (s *Firewall) GeoMetadata() (ipgeo.Metadata, bool) {
+	db, ok := s.ipGeo.(interface{ Metadata() ipgeo.Metadata })
+	if !ok {
+		return ipgeo.Metadata{}, false
+	}
+	return db.Metadata(), true
+}
@@ -0,0 +1,67 @@
+package firewall
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+func TestDiagnostics(t *testing.T) {
+	mockFW := &MockErrorFirewall{Err: errors.New("connection refused")}
+	mockLogger := &MockILogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 3, BanInMinute: 5})
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(5)
+	fw.LogIPError("192.168.1.1", "Invalid password")
+	fw.LogIPError("192.168.1.1", "Invalid password")
+	fw.LogIPError("192.168.1.2", "Invalid password")
+	fw.BanIP("192.168.1.3", 10, "scanner")
+	mockLogger.Wg.Wait()
+
+	d := fw.Diagnostics()
+	assert.Equal(t, 1, d.Jailed)
+	assert.Equal(t, 2, d.ErrorCounters)
+	assert.Equal(t, []CounterInfo{
+		{IP: "192.168.1.1", Offenses: 2},
+		{IP: "192.168.1.2", Offenses: 1},
+	}, d.TopCounted)
+	assert.Len(t, d.LastBackendErrors, 1)
+	assert.Equal(t, "192.168.1.3", d.LastBackendErrors[0].IP)
+	assert.Equal(t, "other", d.LastBackendErrors[0].Class)
+	assert.Equal(t, 1, d.Enforcement.Count)
+	assert.Positive(t, d.Goroutines)
+}
+
+func TestInspectIP(t *testing.T) {
+	mockLogger := &MockILogger{}
+	geo := ipgeo.StaticProvider{"192.168.1.0/24": {CountryISO: "DE"}}
+	fw, err := New([]string{"10.0.0.0/8"}, &MockIFirewall{}, mockLogger, geo, ForgivableError{Duration: time.Minute, Count: 3, BanInMinute: 5})
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(4)
+	fw.LogIPError("192.168.1.1", "Invalid password")
+	fw.LogIPError("192.168.1.1", "Invalid password")
+	fw.LogIPError("192.168.1.1", "scanner")
+	fw.BanIP("192.168.1.3", 10, "scanner")
+	mockLogger.Wg.Wait()
+
+	info := fw.InspectIP("192.168.1.1")
+	assert.Equal(t, map[string]int{"Invalid password": 2, "scanner": 1}, info.Offenses)
+	assert.Nil(t, info.Ban)
+	assert.Equal(t, "DE", info.Geo.CountryISO)
+	// inspecting keeps the offenses
+	assert.Equal(t, info.Offenses, fw.InspectIP("192.168.1.1").Offenses)
+
+	info = fw.InspectIP("192.168.1.3")
+	assert.Equal(t, []string{"scanner"}, info.Ban.Reasons)
+
+	info = fw.InspectIP("10.0.0.1")
+	assert.True(t, info.Whitelisted)
+	assert.Empty(t, info.Offenses)
+}
@@ -0,0 +1,76 @@
+package firewall
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+// DurationPolicy scales the minutes of bans by the origin of the ip, e.g.
+// hosting providers ×4 and residential ISPs ×1. It requires the geo
+// database. If many attributes match, the largest multiplier applies, an
+// ip matching none is banned for the minutes decided.
+type DurationPolicy struct {
+	// ASNs are multipliers by autonomous system number.
+	ASNs map[uint]float64
+	// Organizations are multipliers by a case insensitive substring of the
+	// autonomous system organization, e.g. "hosting".
+	Organizations map[string]float64
+	// Countries are multipliers by country ISO code, e.g. "US".
+	Countries map[string]float64
+	// Proxy is the multiplier of anonymous proxies, 0 for none.
+	Proxy float64
+	// Satellite is the multiplier of satellite providers, 0 for none.
+	Satellite float64
+}
+
+// Multiplier returns the multiplier of geo, 1 if none matches.
+func (p *DurationPolicy) Multiplier(geo *ipgeo.IPGeo) float64 {
+	if geo == nil {
+		return 1
+	}
+
+	m := 0.0
+	if v, ok := p.ASNs[geo.AutonomousSystemNumber]; ok && geo.AutonomousSystemNumber != 0 {
+		m = max(m, v)
+	}
+	org := strings.ToLower(geo.AutonomousSystemOrganization)
+	for sub, v := range p.Organizations {
+		if org != "" && strings.Contains(org, strings.ToLower(sub)) {
+			m = max(m, v)
+		}
+	}
+	if v, ok := p.Countries[geo.CountryISO]; ok && geo.CountryISO != "" {
+		m = max(m, v)
+	}
+	if geo.Proxy {
+		m = max(m, p.Proxy)
+	}
+	if geo.Satellite {
+		m = max(m, p.Satellite)
+	}
+	if m <= 0 {
+		return 1
+	}
+	return m
+}
+
+// scaleBan applies the duration policy to the minutes of b.
+func (s *Firewall) scaleBan(b *ban, geo *ipgeo.IPGeo) {
+	m := s.durations.Multiplier(geo)
+	if m == 1 {
+		return
+	}
+	s.setBanMinutes(b, max(int(math.Round(float64(b.timeoutInMinute)*m)), 1))
+}
+
+// setBanMinutes changes the minutes of b, errors of the ip are not counted
+// until the new end of the ban.
+func (s *Firewall) setBanMinutes(b *ban, minutes int) {
+	b.timeoutInMinute = minutes
+	if ec := s.errorCount[b.ip]; ec != nil && !ec.bannedUntil.IsZero() {
+		ec.bannedUntil = s.now().Add(time.Duration(minutes) * time.Minute)
+	}
+}
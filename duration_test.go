@@ -0,0 +1,59 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+func TestDurationPolicy_Multiplier(t *testing.T) {
+	p := &DurationPolicy{
+		ASNs:          map[uint]float64{64500: 4},
+		Organizations: map[string]float64{"hosting": 3},
+		Countries:     map[string]float64{"XX": 2},
+		Proxy:         5,
+	}
+
+	tests := []struct {
+		name string
+		geo  *ipgeo.IPGeo
+		want float64
+	}{
+		{"no geo", nil, 1},
+		{"residential", &ipgeo.IPGeo{AutonomousSystemNumber: 64501, AutonomousSystemOrganization: "Home ISP", CountryISO: "US"}, 1},
+		{"asn", &ipgeo.IPGeo{AutonomousSystemNumber: 64500}, 4},
+		{"organization", &ipgeo.IPGeo{AutonomousSystemOrganization: "Cheap Hosting Ltd"}, 3},
+		{"country", &ipgeo.IPGeo{CountryISO: "XX"}, 2},
+		{"largest wins", &ipgeo.IPGeo{AutonomousSystemNumber: 64500, CountryISO: "XX"}, 4},
+		{"proxy", &ipgeo.IPGeo{Proxy: true, CountryISO: "XX"}, 5},
+		{"satellite unset", &ipgeo.IPGeo{Satellite: true}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, p.Multiplier(tt.geo))
+		})
+	}
+}
+
+func TestScaleBan(t *testing.T) {
+	p := &DurationPolicy{ASNs: map[uint]float64{64500: 4}, Countries: map[string]float64{"XX": 0.5}}
+	fw, err := New([]string{}, &MockIFirewall{}, &MockILogger{}, nil, testForgivable, WithDurationPolicy(p))
+	require.NoError(t, err)
+
+	fw.do(func() {
+		b := &ban{ip: "1.2.3.4", timeoutInMinute: 10}
+		fw.scaleBan(b, &ipgeo.IPGeo{AutonomousSystemNumber: 64500})
+		assert.Equal(t, 40, b.timeoutInMinute)
+
+		b = &ban{ip: "1.2.3.4", timeoutInMinute: 1}
+		fw.scaleBan(b, &ipgeo.IPGeo{CountryISO: "XX"})
+		assert.Equal(t, 1, b.timeoutInMinute)
+
+		b = &ban{ip: "1.2.3.4", timeoutInMinute: 10}
+		fw.scaleBan(b, nil)
+		assert.Equal(t, 10, b.timeoutInMinute)
+	})
+}
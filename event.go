@@ -0,0 +1,80 @@
+package firewall
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+// Event is a structured log event. Loggers implementing IEventLogger receive
+// the whole event, other loggers receive the fields ILogger.Log accepts.
+type Event struct {
+	IP        string
+	JailUntil time.Time
+	Reasons   []string
+	Action    string
+	Geo       *ipgeo.IPGeo
+
+	// Backend is set on "backend-error" events.
+	Backend *BackendError
+	// Latency is set on "ban" events.
+	Latency *Latency
+	// Offenses are the errors contributing to a "ban", Reasons holds the
+	// same reasons once each, without the time.
+	Offenses []Offense
+	// ReasonCounts counts the errors of a "ban" by reason, it includes the
+	// errors beyond the size cap of Offenses.
+	ReasonCounts map[string]int
+	// CorrelationID is shared by the count errors, the ban and the events
+	// after the ban of a ban decision.
+	CorrelationID string
+	// Labels are set by WithLabels, e.g. the tenant of the firewall.
+	Labels map[string]string
+	// Annotation is set on the events of bans and unbans by an operator,
+	// see BanIPBy and UnbanIPBy.
+	Annotation *Annotation
+	// Metadata is the context passed with the error or the ban, e.g. the
+	// username attempted, see LogIPErrorWithMetadata. Ban events carry the
+	// metadata of the error deciding the ban.
+	Metadata map[string]string
+	// BanAction is set on ban events enforced other than by a block, see
+	// Decision.BanAction.
+	BanAction BanAction
+}
+
+// IEventLogger is an ILogger which accepts structured events.
+type IEventLogger interface {
+	ILogger
+	LogEvent(e *Event)
+}
+
+// IFlushLogger is an ILogger buffering events, flushed on Close of the
+// firewall.
+type IFlushLogger interface {
+	ILogger
+	Flush() error
+}
+
+// newCorrelationID returns a random UUID v4.
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (s *Firewall) log(e *Event) {
+	if e.Labels == nil {
+		e.Labels = s.labels
+	}
+	if l, ok := s.logger.(IEventLogger); ok {
+		l.LogEvent(e)
+	} else {
+		s.logger.Log(e.IP, e.JailUntil, e.Reasons, e.Action, e.Geo)
+	}
+	s.runHook(e)
+	s.publish(e)
+}
@@ -0,0 +1,152 @@
+// Package export serves the jail as a plain-text blocklist, so other
+// firewalls consume it by url, e.g. a pfSense or OPNsense URL table alias,
+// without an API integration.
+package export
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+)
+
+// Source lists the bans to export, a *firewall.Firewall.
+type Source interface {
+	ListBans() []firewall.BanInfo
+}
+
+// Format of an exported list.
+type Format struct {
+	ContentType string
+	// Write writes entries, sorted ips and cidrs, generated at now.
+	Write func(w io.Writer, entries []string, now time.Time) error
+}
+
+// Formats by name.
+var Formats = map[string]Format{
+	"plain":   {"text/plain; charset=utf-8", writePlain},
+	"firehol": {"text/plain; charset=utf-8", writeFireHOL},
+	"pf":      {"text/plain; charset=utf-8", writePF},
+}
+
+// writePlain writes one entry per line without comments, for consumers
+// not skipping them.
+func writePlain(w io.Writer, entries []string, now time.Time) error {
+	for _, e := range entries {
+		if _, err := fmt.Fprintln(w, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFireHOL writes a FireHOL netset, entries after a header of comments.
+func writeFireHOL(w io.Writer, entries []string, now time.Time) error {
+	_, err := fmt.Fprintf(w, `#
+# firewall
+#
+# ips and networks banned by the firewall
+#
+# Generated : %s
+# Entries   : %d
+#
+`, now.UTC().Format(time.RFC1123), len(entries))
+	if err != nil {
+		return err
+	}
+	return writePlain(w, entries, now)
+}
+
+// writePF writes a file loadable by pfctl -T replace -f, or a pf table
+// with file.
+func writePF(w io.Writer, entries []string, now time.Time) error {
+	if _, err := fmt.Fprintf(w, "# firewall bans, %d entries, generated %s\n", len(entries), now.UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return writePlain(w, entries, now)
+}
+
+// Entries returns the ips and cidrs of bans, sorted with ipv4 first. family
+// 4 or 6 keeps only that family, 0 keeps all.
+func Entries(bans []firewall.BanInfo, family int) []string {
+	prefixes := []netip.Prefix{}
+	for _, b := range bans {
+		p, err := netip.ParsePrefix(b.IP)
+		if err != nil {
+			addr, err := netip.ParseAddr(b.IP)
+			if err != nil {
+				continue
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if family == 4 && !p.Addr().Is4() || family == 6 && !p.Addr().Is6() {
+			continue
+		}
+		prefixes = append(prefixes, p)
+	}
+	slices.SortFunc(prefixes, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+
+	res := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		if p.IsSingleIP() {
+			res = append(res, p.Addr().String())
+		} else {
+			res = append(res, p.String())
+		}
+	}
+	return res
+}
+
+// Handler serves the bans of src in the format of the "format" query
+// parameter, plain by default, filtered by the "family" parameter, 4 or 6.
+// It sets an ETag of the entries, so consumers polling an unchanged list
+// get a 304.
+func Handler(src Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := cmp.Or(r.URL.Query().Get("format"), "plain")
+		f, ok := Formats[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown format %q", name), http.StatusNotFound)
+			return
+		}
+		family := 0
+		switch v := r.URL.Query().Get("family"); v {
+		case "":
+		case "4":
+			family = 4
+		case "6":
+			family = 6
+		default:
+			http.Error(w, fmt.Sprintf("invalid family %q", v), http.StatusBadRequest)
+			return
+		}
+
+		entries := Entries(src.ListBans(), family)
+		sum := sha256.Sum256([]byte(name + "\n" + strings.Join(entries, "\n")))
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", f.ContentType)
+		if err := f.Write(w, entries, time.Now()); err != nil {
+			log.Printf("write %s export failed: %v", name, err)
+		}
+	})
+}
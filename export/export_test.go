@@ -0,0 +1,72 @@
+package export
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/charleshuang3/firewall"
+)
+
+type bans []firewall.BanInfo
+
+func (b bans) ListBans() []firewall.BanInfo {
+	return b
+}
+
+var src = bans{
+	{IP: "2001:db8::1"},
+	{IP: "10.0.0.9"},
+	{IP: "10.0.1.0/24"},
+	{IP: "10.0.0.10"},
+	{IP: "not an ip"},
+}
+
+func TestEntries(t *testing.T) {
+	assert.Equal(t, []string{"10.0.0.9", "10.0.0.10", "10.0.1.0/24", "2001:db8::1"}, Entries(src, 0))
+	assert.Equal(t, []string{"10.0.0.9", "10.0.0.10", "10.0.1.0/24"}, Entries(src, 4))
+	assert.Equal(t, []string{"2001:db8::1"}, Entries(src, 6))
+}
+
+func TestHandler(t *testing.T) {
+	h := Handler(src)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?family=4", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10.0.0.9\n10.0.0.10\n10.0.1.0/24\n", w.Body.String())
+
+	// polling an unchanged list
+	etag := w.Header().Get("ETag")
+	r := httptest.NewRequest(http.MethodGet, "/?family=4", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	for _, format := range []string{"firehol", "pf"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?format="+format, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, etag, w.Header().Get("ETag"))
+		assert.True(t, strings.HasPrefix(w.Body.String(), "#"), format)
+		assert.True(t, strings.HasSuffix(w.Body.String(), "\n10.0.1.0/24\n2001:db8::1\n"), format)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?format=csv", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?family=5", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWritePF(t *testing.T) {
+	b := &strings.Builder{}
+	writePF(b, []string{"10.0.0.1"}, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	assert.Equal(t, "# firewall bans, 1 entries, generated 2026-01-02T03:04:05Z\n10.0.0.1\n", b.String())
+}
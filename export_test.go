@@ -0,0 +1,16 @@
+package firewall
+
+import "time"
+
+// WithClock sets the clock of the firewall, so tests of the external
+// package simulate the passing of time.
+func WithClock(now func() time.Time) Option {
+	return func(f *Firewall) {
+		f.now = now
+	}
+}
+
+// Tick runs the periodic work of the loop at the time of the clock.
+func (s *Firewall) Tick() {
+	s.do(func() { s.tick(s.now()) })
+}
@@ -0,0 +1,119 @@
+// Package fail2ban reads bans from a fail2ban sqlite database, for users
+// migrating from fail2ban.
+package fail2ban
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Ban is a row of the fail2ban bans table.
+type Ban struct {
+	Jail      string
+	IP        string
+	TimeOfBan time.Time
+	// BanTime is negative for permanent bans.
+	BanTime time.Duration
+	Count   int
+}
+
+// Permanent returns whether the ban never expires.
+func (b *Ban) Permanent() bool {
+	return b.BanTime < 0
+}
+
+// Until returns when the ban expires, zero for permanent bans.
+func (b *Ban) Until() time.Time {
+	if b.Permanent() {
+		return time.Time{}
+	}
+	return b.TimeOfBan.Add(b.BanTime)
+}
+
+// Active returns whether the ban is still in effect at now.
+func (b *Ban) Active(now time.Time) bool {
+	return b.Permanent() || b.Until().After(now)
+}
+
+// ReadBans reads all bans in the database at path, oldest first. Databases
+// of fail2ban before 0.11 do not record the ban time, defaultBanTime is used
+// for them.
+func ReadBans(path string, defaultBanTime time.Duration) ([]Ban, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("open fail2ban db failed: %w", err)
+	}
+	defer db.Close()
+
+	hasBanTime, err := hasColumns(db, "bantime", "bancount")
+	if err != nil {
+		return nil, fmt.Errorf("read fail2ban schema failed: %w", err)
+	}
+
+	query := `SELECT jail, ip, timeofban, 0, 1 FROM bans ORDER BY timeofban`
+	if hasBanTime {
+		query = `SELECT jail, ip, timeofban, bantime, bancount FROM bans ORDER BY timeofban`
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("read fail2ban bans failed: %w", err)
+	}
+	defer rows.Close()
+
+	res := []Ban{}
+	for rows.Next() {
+		var jail, ip string
+		var timeOfBan, banTime int64
+		var count int
+		if err := rows.Scan(&jail, &ip, &timeOfBan, &banTime, &count); err != nil {
+			return nil, fmt.Errorf("read fail2ban bans failed: %w", err)
+		}
+
+		b := Ban{
+			Jail:      jail,
+			IP:        ip,
+			TimeOfBan: time.Unix(timeOfBan, 0),
+			BanTime:   time.Duration(banTime) * time.Second,
+			Count:     count,
+		}
+		switch {
+		case !hasBanTime:
+			b.BanTime = defaultBanTime
+		case banTime < 0:
+			b.BanTime = -1
+		}
+		res = append(res, b)
+	}
+	return res, rows.Err()
+}
+
+func hasColumns(db *sql.DB, names ...string) (bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('bans')`)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		existing[name] = true
+	}
+	if len(existing) == 0 {
+		return false, fmt.Errorf("no bans table")
+	}
+
+	for _, name := range names {
+		if !existing[name] {
+			return false, rows.Err()
+		}
+	}
+	return true, rows.Err()
+}
@@ -0,0 +1,66 @@
+package fail2ban
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDB(t *testing.T, schema string, rows ...string) string {
+	path := filepath.Join(t.TempDir(), "fail2ban.sqlite3")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(schema)
+	require.NoError(t, err)
+	for _, r := range rows {
+		_, err = db.Exec(r)
+		require.NoError(t, err)
+	}
+	return path
+}
+
+func TestReadBans(t *testing.T) {
+	path := newDB(t,
+		`CREATE TABLE bans(jail TEXT NOT NULL, ip TEXT, timeofban INTEGER NOT NULL, bantime INTEGER NOT NULL, bancount INTEGER NOT NULL default 1, data JSON)`,
+		`INSERT INTO bans VALUES ('sshd', '1.2.3.4', 1000, 600, 2, '{}')`,
+		`INSERT INTO bans VALUES ('nginx', '1.2.3.5', 500, -1, 1, '{}')`,
+	)
+
+	bans, err := ReadBans(path, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, bans, 2)
+
+	assert.Equal(t, Ban{Jail: "nginx", IP: "1.2.3.5", TimeOfBan: time.Unix(500, 0), BanTime: -1, Count: 1}, bans[0])
+	assert.True(t, bans[0].Permanent())
+	assert.True(t, bans[0].Active(time.Now()))
+
+	assert.Equal(t, Ban{Jail: "sshd", IP: "1.2.3.4", TimeOfBan: time.Unix(1000, 0), BanTime: 10 * time.Minute, Count: 2}, bans[1])
+	assert.Equal(t, time.Unix(1600, 0), bans[1].Until())
+	assert.True(t, bans[1].Active(time.Unix(1599, 0)))
+	assert.False(t, bans[1].Active(time.Unix(1600, 0)))
+}
+
+func TestReadBans_Old(t *testing.T) {
+	path := newDB(t,
+		`CREATE TABLE bans(jail TEXT NOT NULL, ip TEXT, timeofban INTEGER NOT NULL, data JSON)`,
+		`INSERT INTO bans VALUES ('sshd', '1.2.3.4', 1000, '{}')`,
+	)
+
+	bans, err := ReadBans(path, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+	assert.Equal(t, time.Hour, bans[0].BanTime)
+}
+
+func TestReadBans_NoTable(t *testing.T) {
+	path := newDB(t, `CREATE TABLE jails(name TEXT)`)
+
+	_, err := ReadBans(path, time.Hour)
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package firewall
+
+import (
+	"log"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+// PendingBan is a ban about to be enforced.
+type PendingBan struct {
+	IP      string
+	Minutes int
+	Reasons []string
+	Geo     *ipgeo.IPGeo
+	// Metadata is the context of the ban request or the error deciding it,
+	// it should not be changed.
+	Metadata map[string]string
+}
+
+// IBanFilter is called before a ban is enforced, e.g. a custom script. It
+// can change Minutes, append Reasons, or veto the ban by returning false.
+// It is called in the loop goroutine, so it should be fast.
+type IBanFilter interface {
+	BeforeBan(b *PendingBan) (bool, error)
+}
+
+// filterBan applies the ban filter to b. Vetoed bans are logged as
+// "ban-vetoed", errors of the filter enforce the ban unchanged.
+func (s *Firewall) filterBan(b *ban, geo *ipgeo.IPGeo) bool {
+	p := &PendingBan{
+		IP:       b.ip,
+		Minutes:  b.timeoutInMinute,
+		Reasons:  reasonsOf(b.offenses),
+		Geo:      geo,
+		Metadata: b.metadata,
+	}
+	n := len(p.Reasons)
+
+	ok, err := s.banFilter.BeforeBan(p)
+	if err != nil {
+		log.Printf("ban filter failed on %s: %v", b.ip, err)
+		return true
+	}
+
+	if !ok {
+		s.countAgain(b.ip)
+		s.log(&Event{
+			IP:            b.ip,
+			Reasons:       p.Reasons,
+			Action:        "ban-vetoed",
+			Offenses:      b.offenses,
+			ReasonCounts:  b.reasons(),
+			Annotation:    b.annotation,
+			Metadata:      b.metadata,
+			BanAction:     b.action,
+			Geo:           geo,
+			CorrelationID: b.correlationID,
+		})
+		return false
+	}
+
+	if p.Minutes > 0 && p.Minutes != b.timeoutInMinute {
+		s.setBanMinutes(b, p.Minutes)
+	}
+	if len(p.Reasons) > n {
+		now := s.now()
+		for _, r := range p.Reasons[n:] {
+			b.offenses = append(b.offenses, Offense{Time: now, Reason: r})
+			if b.reasonCounts != nil {
+				b.reasonCounts[truncateReason(r)]++
+			}
+		}
+		b.offenses = capOffenses(b.offenses, maxOffensesSize)
+	}
+	return true
+}
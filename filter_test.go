@@ -0,0 +1,56 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MockBanFilter vetoes bans of Veto and doubles the others.
+type MockBanFilter struct {
+	Veto string
+}
+
+func (m *MockBanFilter) BeforeBan(b *PendingBan) (bool, error) {
+	if b.IP == m.Veto {
+		return false, nil
+	}
+	b.Minutes *= 2
+	b.Reasons = append(b.Reasons, "doubled")
+	return true, nil
+}
+
+func TestBanFilter(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable,
+		WithBanFilter(&MockBanFilter{Veto: "1.2.3.5"}))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.2.3.4", 10, "scanner")
+	fw.BanIP("1.2.3.5", 10, "scanner")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.4"}, mockFW.BannedIPs)
+	ban := mockLogger.Events[0]
+	assert.Equal(t, "ban", ban.Action)
+	assert.Equal(t, []string{"scanner", "doubled"}, ban.Reasons)
+	assert.WithinDuration(t, time.Now().Add(20*time.Minute), ban.JailUntil, time.Minute)
+	assert.Equal(t, "ban-vetoed", mockLogger.Events[1].Action)
+
+	// a vetoed ban from counted errors lets errors count again.
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("1.2.3.5", "r")
+	fw.LogIPError("1.2.3.5", "r")
+	fw.LogIPError("1.2.3.5", "r")
+	mockLogger.Wg.Wait()
+
+	actions := []string{}
+	for _, e := range mockLogger.Events[2:] {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"count error", "ban-vetoed", "ban-vetoed"}, actions)
+}
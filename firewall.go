@@ -1,7 +1,11 @@
 package firewall
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/adrianbrad/queue"
@@ -14,34 +18,184 @@ type IFirewall interface {
 	BanIP(ip string, timeoutInMinute int)
 }
 
+// IIPGeo looks up the geo data of an ip, e.g. *ipgeo.AutoUpdateMMIPGeo or
+// ipgeo.StaticProvider in tests.
+type IIPGeo interface {
+	GetIPGeo(ip string) *ipgeo.IPGeo
+}
+
+var (
+	_ IIPGeo = (*ipgeo.AutoUpdateMMIPGeo)(nil)
+	_ IIPGeo = (*ipgeo.MMIPGeo)(nil)
+	_ IIPGeo = ipgeo.StaticProvider(nil)
+)
+
 type ILogger interface {
 	Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo)
 }
 
 type Firewall struct {
 	whiteList []*ipMatcher
+	// runtimeWhitelist are the rules added after New, by rule.
+	runtimeWhitelist map[string]*runtimeRule
 
-	ipGeo  *ipgeo.AutoUpdateMMIPGeo
+	ipGeo  IIPGeo
 	logger ILogger
 
 	fw IFirewall
 
 	forgivable ForgivableError
-	errorCount map[string]*errorCounter
+	// reasonForgivable are the thresholds of reason categories by prefix.
+	reasonForgivable map[string]ForgivableError
+	errorCount       map[string]*errorCounter
+	lastCounterGC    time.Time
+	jail             map[string]*jailed
+	// index mirrors jail for IsBannedIP.
+	index *banIndex
+	// now is the clock of decisions and expiries, time.Now except in tests
+	// simulating the passing of time.
+	now func() time.Time
+	// expiry schedules the release of jailed ips.
+	expiry *timingWheel
+	// bannedLogged dedupes "banned" events, it is persisted in state.
+	bannedLogged *dedupe
+	state        IStateStore
 
 	banCh   chan ban
 	countCh chan countingError
+	ctrlCh  chan func()
+	// overflow applies when banCh or countCh is full, dropped counts the
+	// events dropped by it.
+	overflow OverflowPolicy
+	dropped  atomic.Uint64
+
+	valve     *safetyValve
+	labels    map[string]string
+	policy    IPolicy
+	scoring   *Scoring
+	banFilter IBanFilter
+	durations *DurationPolicy
+	asnPolicy *ASNPolicy
+	hooks     Hooks
+	// hookCh queues the hooks for their goroutine, nil without hooks and
+	// once the loop stopped. hooksDone is closed when the goroutine exits.
+	hookCh    chan func()
+	hooksDone chan struct{}
+	// sharedValve is of a SharedValve, see WithSharedValve.
+	sharedValve *safetyValve
+	// asns aggregate the bans by autonomous system for asnPolicy.
+	asns map[uint]*asnState
+	// banCountries are the ISO codes of countries whose ips are banned on
+	// their first error.
+	banCountries map[string]bool
+	// blackList are the ips and networks banned on their first error.
+	blackList []*ipMatcher
+	// asWhitelist and orgWhitelist are the autonomous systems and the
+	// lowercase substrings of their organizations never banned.
+	asWhitelist  map[uint]bool
+	orgWhitelist []string
+	// countryWhitelist are the ISO codes of countries never banned.
+	countryWhitelist map[string]bool
+	// skipPrivateIPs never bans private, loopback and link local ips.
+	skipPrivateIPs bool
+	// jailCapacity logs "jail-capacity" when the jail grows over it, 0 to
+	// disable. overCapacity is set until the jail is back under it.
+	jailCapacity int
+	overCapacity bool
+	// allowlist bans ips outside allowCountries on their first error.
+	allowlist      *CountryAllowlist
+	allowCountries map[string]bool
+	// crawlerCh queues the ips whose crawler verification is pending in
+	// crawlerChecks, see startCrawlerChecks. crawlerChecks is only accessed
+	// in the loop goroutine.
+	crawlerCh     chan string
+	crawlerChecks map[string]*crawlerCheck
+	// pausedBy is why Pause or the dead man's switch paused enforcement,
+	// empty if not paused.
+	pausedBy string
+	// deadManTimeout pauses enforcement when Heartbeat is not called for
+	// it, see WithDeadManSwitch.
+	deadManTimeout time.Duration
+	lastHeartbeat  time.Time
+	// countErrorGeo adds geo data to "count error" events.
+	countErrorGeo bool
+	// whitelistUnban unbans the jailed ips matching a runtime whitelist
+	// rule when it is added.
+	whitelistUnban bool
+
+	lastBackendErrors []BackendErrorInfo
+
+	// closeMu guards closed, senders registers in senders before sending
+	// to the loop unless closed.
+	closeMu   sync.RWMutex
+	closed    bool
+	senders   sync.WaitGroup
+	closeOnce sync.Once
+	// drained is closed when no sender remains after Close, done when the
+	// loop exits.
+	drained  chan struct{}
+	done     chan struct{}
+	closeErr error
+
+	// subs receive the events, see Events. subsClosed is set when the loop
+	// stops, the events after it are not published.
+	subsMu        sync.Mutex
+	subs          []chan Event
+	subsClosed    bool
+	eventsDropped atomic.Uint64
+
+	statsMu sync.Mutex
+	stats   EnforcementStats
+	// whitelistHits counts the bans and errors ignored by the whitelist.
+	whitelistHits atomic.Uint64
+
+	// banWorkers call the backend for bans, see WithBanWorkers. jobs and
+	// results are nil without workers, inflight counts the jobs whose
+	// result is not handled yet, only accessed in the loop goroutine.
+	banWorkers int
+	jobs       chan banJob
+	results    chan func()
+	inflight   int
+
+	// optErrs are the invalid settings of the options, returned by New.
+	optErrs []error
 }
 
 type ban struct {
 	ip              string
 	timeoutInMinute int
-	reasons         []string
+	offenses        []Offense
+	// reasonCounts counts the offenses before they are capped, nil if they
+	// are not, see reasons.
+	reasonCounts map[string]int
+	// decidedAt is when the ban is requested or the threshold is breached.
+	decidedAt time.Time
+	// correlationID is shared by all events of this ban decision.
+	correlationID string
+	// annotation is set on bans by an operator, see BanIPBy.
+	annotation *Annotation
+	// metadata is the context of the ban request or the error deciding it.
+	metadata map[string]string
+	// action is how the ban is enforced, chosen by the policy.
+	action BanAction
+}
+
+// reasons counts the offenses of b by reason.
+func (b *ban) reasons() map[string]int {
+	if b.reasonCounts != nil {
+		return b.reasonCounts
+	}
+	return countReasons(b.offenses, maxReasonCountsSize)
 }
 
 type countingError struct {
 	ip     string
 	reason string
+	// target is what the error is about, e.g. the account of a failed
+	// login, empty if unknown.
+	target   string
+	at       time.Time
+	metadata map[string]string
 }
 
 // ForgivableError represent to the maxium error we can forgive per ip in
@@ -50,144 +204,678 @@ type ForgivableError struct {
 	Duration    time.Duration
 	Count       int
 	BanInMinute int
+	// Warn logs a "ban-warning" event on the error before the one banning
+	// the ip, e.g. to show a captcha or email the account owner.
+	Warn bool
+}
+
+// validate rejects a Duration which is not positive, which never bans, a
+// BanInMinute which is not positive, which bans for no time, and a negative
+// Count. A Count of 0 bans on the first error.
+func (f ForgivableError) validate() error {
+	switch {
+	case f.Duration <= 0:
+		return fmt.Errorf("forgivable duration %v is not positive", f.Duration)
+	case f.BanInMinute <= 0:
+		return fmt.Errorf("forgivable ban in minute %d is not positive", f.BanInMinute)
+	case f.Count < 0:
+		return fmt.Errorf("forgivable count %d is negative", f.Count)
+	}
+	return nil
 }
 
 type errorCounter struct {
 	rateLimiter rate.Limiter
-	reasons     *queue.Linked[string]
+	// categories are the rate limiters of the reason categories set by
+	// WithReasonForgivable.
+	categories  map[string]*rate.Limiter
+	offenses    *queue.Linked[Offense]
 	bannedUntil time.Time
+	// score and when it is updated, set by WithScoring.
+	score    float64
+	scoredAt time.Time
+	// correlationID links the count errors to the ban they lead to. It
+	// rotates after the ban expires.
+	correlationID string
+	// geo caches the geo data of "count error" events, it is looked up
+	// again for a new decision.
+	geo *ipgeo.IPGeo
+	// crawler is whether the ip is a verified crawler, for allowlist.
+	crawler crawlerState
+	// metadata of the last error, the ban it decides carries it.
+	metadata map[string]string
 }
 
+// New returns a firewall banning on fw. A nil fw runs the detection without
+// a backend, bans are only jailed and logged, e.g. to audit it in staging.
+// It fails on a nil logger, an empty or negative forgivable, and an invalid
+// whitelist or blacklist rule.
 func New(whiteList []string,
 	fw IFirewall,
 	logger ILogger,
-	ipGeo *ipgeo.AutoUpdateMMIPGeo,
+	ipGeo IIPGeo,
 	forgivable ForgivableError,
-) *Firewall {
+	opts ...Option,
+) (*Firewall, error) {
 	if logger == nil {
-		log.Fatalln("firewall logger is nil")
+		return nil, errors.New("firewall logger is nil")
+	}
+	if err := forgivable.validate(); err != nil {
+		return nil, err
+	}
+	if g, ok := ipGeo.(*ipgeo.AutoUpdateMMIPGeo); ok && g == nil {
+		// a nil database means no geo lookup
+		ipGeo = nil
 	}
 
 	f := &Firewall{
-		whiteList:  []*ipMatcher{},
-		fw:         fw,
-		ipGeo:      ipGeo,
-		logger:     logger,
-		forgivable: forgivable,
-		errorCount: map[string]*errorCounter{},
-		banCh:      make(chan ban),
-		countCh:    make(chan countingError),
+		whiteList:        []*ipMatcher{},
+		runtimeWhitelist: map[string]*runtimeRule{},
+		fw:               fw,
+		ipGeo:            ipGeo,
+		logger:           logger,
+		forgivable:       forgivable,
+		errorCount:       map[string]*errorCounter{},
+		jail:             map[string]*jailed{},
+		index:            newBanIndex(),
+		asns:             map[uint]*asnState{},
+		now:              time.Now,
+		countErrorGeo:    true,
+		whitelistUnban:   true,
+		banCh:            make(chan ban),
+		countCh:          make(chan countingError),
+		ctrlCh:           make(chan func()),
+		drained:          make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(f)
 	}
 
+	now := f.now()
+	f.expiry = newTimingWheel(now)
+	f.lastHeartbeat = now
+	f.bannedLogged = newDedupe(now)
+
 	for _, it := range whiteList {
-		f.whiteList = append(f.whiteList, newIPMatcher(it))
+		m, err := parseIPMatcher(it)
+		if err != nil {
+			f.optErrs = append(f.optErrs, fmt.Errorf("whitelist: %w", err))
+			continue
+		}
+		f.whiteList = append(f.whiteList, m)
+	}
+	if err := errors.Join(f.optErrs...); err != nil {
+		return nil, err
+	}
+
+	f.startHooks()
+	if f.state != nil {
+		f.loadDedupe()
+		f.loadWhitelist()
+	}
+
+	if f.banWorkers > 0 {
+		f.startWorkers()
+	}
+	if f.allowlist != nil && len(f.allowlist.CrawlerDomains) > 0 {
+		f.startCrawlerChecks()
 	}
 
 	go f.loop()
 
-	return f
+	return f, nil
 }
 
 func (s *Firewall) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.expiry.tick)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case b := <-s.banCh:
-			if s.inWhitelist(b.ip) {
-				// IP is whitelisted, do not log
-				continue
-			}
-			s.doBanIP(&b)
+			s.handleBan(&b)
 		case c := <-s.countCh:
-			if s.inWhitelist(c.ip) {
-				// IP is whitelisted, do not log
-				continue
-			}
-			s.doCountError(&c)
+			s.handleError(&c)
+		case fn := <-s.ctrlCh:
+			fn()
+		case fn := <-s.results:
+			s.finish(fn)
+		case <-ticker.C:
+			s.tick(s.now())
+		case <-s.drained:
+			s.shutdown()
+			return
 		}
 	}
 }
 
+// tick expires the jail, whitelist rules, dedupe windows and idle counters
+// at now.
+func (s *Firewall) tick(now time.Time) {
+	s.release(now)
+	s.expireWhitelist(now)
+	s.flushDedupe(now)
+	s.checkHeartbeat(now)
+	s.gcCounters(now)
+	if s.asnPolicy != nil {
+		s.expireASNs(now)
+	}
+}
+
+func (s *Firewall) handleBan(b *ban) {
+	if s.inWhitelist(b.ip) {
+		// IP is whitelisted, do not log
+		s.whitelistHits.Add(1)
+		return
+	}
+	s.doBanIP(b)
+}
+
+func (s *Firewall) handleError(c *countingError) {
+	if s.inWhitelist(c.ip) {
+		// IP is whitelisted, do not log
+		s.whitelistHits.Add(1)
+		return
+	}
+	s.doCountError(c)
+}
+
+// shutdown handles the events still queued, saves the state and flushes the
+// logger.
+func (s *Firewall) shutdown() {
+	for empty := false; !empty; {
+		select {
+		case b := <-s.banCh:
+			s.handleBan(&b)
+		case c := <-s.countCh:
+			s.handleError(&c)
+		default:
+			empty = true
+		}
+	}
+	if s.jobs != nil {
+		s.stopWorkers()
+	}
+	if s.crawlerCh != nil {
+		close(s.crawlerCh)
+	}
+
+	s.saveDedupe()
+	if f, ok := s.logger.(IFlushLogger); ok {
+		if err := f.Flush(); err != nil {
+			s.closeErr = fmt.Errorf("flush logger failed: %w", err)
+		}
+	}
+	s.closeSubscriptions()
+	s.stopHooks()
+}
+
+// Close stops accepting events, handles the events already queued, flushes
+// the logger if it implements IFlushLogger, closes the channels of Events,
+// stops the loop and waits for the hooks queued. Events after
+// Close are dropped. It returns ctx.Err() if ctx is done first, the loop
+// still stops in background.
+func (s *Firewall) Close(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		s.closeMu.Lock()
+		s.closed = true
+		s.closeMu.Unlock()
+
+		go func() {
+			s.senders.Wait()
+			close(s.drained)
+		}()
+	})
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if s.hooksDone != nil {
+		select {
+		case <-s.hooksDone:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.closeErr
+}
+
+// enter registers a sender to the loop, it returns false after Close.
+func (s *Firewall) enter() bool {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		return false
+	}
+	s.senders.Add(1)
+	return true
+}
+
+// do runs fn in the loop goroutine and waits for it. After Close, fn runs
+// in the caller once the loop stopped.
+func (s *Firewall) do(fn func()) {
+	if !s.enter() {
+		<-s.done
+		s.closeMu.Lock()
+		defer s.closeMu.Unlock()
+		fn()
+		return
+	}
+	defer s.senders.Done()
+
+	done := make(chan struct{})
+	s.ctrlCh <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+}
+
+// WhitelistHits returns the number of bans and errors ignored as their ip
+// is whitelisted, since New.
+func (s *Firewall) WhitelistHits() uint64 {
+	return s.whitelistHits.Load()
+}
+
 func (s *Firewall) inWhitelist(ip string) bool {
+	if s.skipPrivateIPs && isPrivate(ip) {
+		return true
+	}
+	match := keyMatcher(ip)
+	if match == nil {
+		return false
+	}
 	for _, it := range s.whiteList {
-		if it.match(parseIP(ip)) {
+		if match(it) {
 			return true
 		}
 	}
-	return false
+	for _, it := range s.runtimeWhitelist {
+		if match(it.matcher) {
+			return true
+		}
+	}
+	return s.inGeoWhitelist(ip)
 }
 
 func (s *Firewall) doBanIP(b *ban) {
+	var geo *ipgeo.IPGeo
+	if s.ipGeo != nil {
+		geo = s.ipGeo.GetIPGeo(networkAddr(b.ip))
+	}
+
+	if s.durations != nil {
+		s.scaleBan(b, geo)
+	}
+	if s.banFilter != nil && !s.filterBan(b, geo) {
+		return
+	}
+	if s.pausedBy != "" {
+		s.logBanPaused(b, s.now())
+		s.countAgain(b.ip)
+		return
+	}
+	if !s.allowValves(b) {
+		s.countAgain(b.ip)
+		return
+	}
+
+	if s.fw != nil && s.banWorkers > 0 {
+		s.banAsync(b, geo)
+		return
+	}
+
+	start := s.now()
 	if s.fw != nil {
+		s.banBackend(b)
+	}
+	latency := &Latency{
+		Queue:   start.Sub(b.decidedAt),
+		Backend: s.now().Sub(start),
+	}
+	s.recordLatency(latency)
+
+	jailUntil := s.now().Add(time.Duration(b.timeoutInMinute) * time.Minute)
+	s.addJail(b, jailUntil, geo)
+	s.logBan(b, jailUntil, geo, latency)
+}
+
+// countAgain counts the errors of ip again after its ban was not
+// enforced.
+func (s *Firewall) countAgain(ip string) {
+	if ec := s.errorCount[ip]; ec != nil {
+		ec.bannedUntil = time.Time{}
+	}
+}
+
+// banAsync jails b and calls the backend in a worker, so the loop keeps
+// counting errors meanwhile. The ban is logged once the backend returns.
+// When the queue of the workers is full, the ban is dropped and logged as
+// "ban-dropped", the errors of the ip are counted again.
+func (s *Firewall) banAsync(b *ban, geo *ipgeo.IPGeo) {
+	start := s.now()
+	jailUntil := start.Add(time.Duration(b.timeoutInMinute) * time.Minute)
+
+	queued := s.submit(func() func() {
+		errs := s.callBanBackend(b)
+		latency := &Latency{
+			Queue:   start.Sub(b.decidedAt),
+			Backend: s.now().Sub(start),
+		}
+		return func() {
+			s.logBanErrors(b, errs)
+			s.recordLatency(latency)
+			s.logBan(b, jailUntil, geo, latency)
+		}
+	})
+	if !queued {
+		s.dropped.Add(1)
+		s.countAgain(b.ip)
+		s.log(&Event{
+			IP:            b.ip,
+			Reasons:       reasonsOf(b.offenses),
+			Action:        "ban-dropped",
+			Offenses:      b.offenses,
+			ReasonCounts:  b.reasons(),
+			Annotation:    b.annotation,
+			Metadata:      b.metadata,
+			BanAction:     b.action,
+			Geo:           geo,
+			CorrelationID: b.correlationID,
+		})
+		return
+	}
+	s.addJail(b, jailUntil, geo)
+}
+
+// logBan logs the "ban" event of b.
+func (s *Firewall) logBan(b *ban, jailUntil time.Time, geo *ipgeo.IPGeo, latency *Latency) {
+	s.log(&Event{
+		IP:            b.ip,
+		JailUntil:     jailUntil,
+		Reasons:       reasonsOf(b.offenses),
+		Offenses:      b.offenses,
+		ReasonCounts:  b.reasons(),
+		Annotation:    b.annotation,
+		Metadata:      b.metadata,
+		BanAction:     b.action,
+		Action:        "ban",
+		Geo:           geo,
+		Latency:       latency,
+		CorrelationID: b.correlationID,
+	})
+	if s.asnPolicy != nil {
+		s.recordASNBan(b.ip, geo, s.now())
+	}
+}
+
+func (s *Firewall) banBackend(b *ban) {
+	s.logBanErrors(b, s.callBanBackend(b))
+}
+
+// logBanErrors logs the backend errors of the ban b, and "ban-failed" for
+// each backend which gave up retrying, see RetryingFirewall.
+func (s *Firewall) logBanErrors(b *ban, errs []*BackendError) {
+	s.logBackendError(b.ip, errs, b.correlationID)
+	for _, be := range errs {
+		var re *RetryError
+		if !errors.As(be, &re) {
+			continue
+		}
+		s.log(&Event{
+			IP:            b.ip,
+			Reasons:       []string{be.Error()},
+			Action:        "ban-failed",
+			Backend:       be,
+			CorrelationID: b.correlationID,
+		})
+	}
+}
+
+// callBanBackend bans b on the backend and returns its errors. It does not
+// touch the loop state, so workers call it.
+func (s *Firewall) callBanBackend(b *ban) []*BackendError {
+	f, ok := s.fw.(IErrorFirewall)
+	if !ok {
 		s.fw.BanIP(b.ip, b.timeoutInMinute)
+		return nil
 	}
 
-	var geo *ipgeo.IPGeo
-	if s.ipGeo != nil {
-		geo = s.ipGeo.GetIPGeo(b.ip)
+	if err := ForwardBan(f, b.ip, b.timeoutInMinute, b.action, reasonsOf(b.offenses)); err != nil {
+		return backendErrors(f.Name(), "ban", err)
+	}
+	return nil
+}
+
+// logBackendError logs a "backend-error" event for each of errs.
+func (s *Firewall) logBackendError(ip string, errs []*BackendError, correlationID string) {
+	for _, be := range errs {
+		s.recordBackendError(ip, be)
+		s.log(&Event{
+			IP:            ip,
+			Reasons:       []string{be.Error()},
+			Action:        "backend-error",
+			Backend:       be,
+			CorrelationID: correlationID,
+		})
 	}
-	jailUntil := time.Now().Add(time.Duration(b.timeoutInMinute) * time.Minute)
-	s.logger.Log(b.ip, jailUntil, b.reasons, "ban", geo)
 }
 
 // BanIP imimmediately
 func (s *Firewall) BanIP(ip string, timeoutInMinute int, reason string) {
-	s.banCh <- ban{
-		ip:              ip,
-		timeoutInMinute: timeoutInMinute,
-		reasons:         []string{reason},
+	s.banIP(ip, timeoutInMinute, reason, nil, nil)
+}
+
+func (s *Firewall) banIP(ip string, timeoutInMinute int, reason string, a *Annotation, md map[string]string) {
+	if !s.enter() {
+		return
 	}
+	defer s.senders.Done()
+
+	now := s.now()
+	enqueue(s, s.banCh, ban{
+		ip:              normalizeIP(ip),
+		timeoutInMinute: timeoutInMinute,
+		offenses:        []Offense{{Time: now, Reason: reason}},
+		decidedAt:       now,
+		correlationID:   newCorrelationID(),
+		annotation:      a,
+		metadata:        md,
+	})
 }
 
-func (s *Firewall) doCountError(c *countingError) {
-	ec, ok := s.errorCount[c.ip]
+// counter returns the error counter of ip, creating it if not exists.
+func (s *Firewall) counter(ip string) *errorCounter {
+	ec, ok := s.errorCount[ip]
 	if !ok {
 		ec = &errorCounter{
 			rateLimiter: *rate.NewLimiter(rate.Every(s.forgivable.Duration), s.forgivable.Count),
-			reasons:     queue.NewLinked([]string{}),
+			offenses:    queue.NewLinked([]Offense{}),
 		}
-		s.errorCount[c.ip] = ec
+		s.errorCount[ip] = ec
 	}
+	return ec
+}
+
+func (s *Firewall) doCountError(c *countingError) {
+	ec := s.counter(c.ip)
 
-	if ec.bannedUntil.After(time.Now()) {
-		s.logger.Log(c.ip, time.Time{}, []string{c.reason}, "banned", nil)
+	if ec.bannedUntil.After(s.now()) {
+		if !s.bannedLogged.allow(c.ip, c.at) {
+			return
+		}
+		s.log(&Event{
+			IP:            c.ip,
+			Reasons:       []string{c.reason},
+			Action:        "banned",
+			CorrelationID: ec.correlationID,
+			Metadata:      c.metadata,
+		})
 		return
 	}
 
-	ec.reasons.Offer(c.reason)
-	for ec.reasons.Size() > s.forgivable.Count {
-		ec.reasons.Get()
+	if ec.correlationID == "" || !ec.bannedUntil.IsZero() {
+		// new errors after the ban expired start a new decision
+		ec.correlationID = newCorrelationID()
+		ec.bannedUntil = time.Time{}
+		ec.geo = nil
 	}
 
-	if ec.rateLimiter.Allow() {
-		var geo *ipgeo.IPGeo
-		if s.ipGeo != nil {
-			geo = s.ipGeo.GetIPGeo(c.ip)
+	d := Decision{Verdict: VerdictCount}
+	if s.policy != nil {
+		d = s.decide(c, ec)
+	}
+	if d.Verdict == VerdictIgnore {
+		return
+	}
+	if d.Verdict == VerdictCount && s.asnPolicy != nil {
+		if reason := s.asnEscalation(c.ip, ec); reason != "" {
+			d = Decision{Verdict: VerdictBan, Reason: reason}
+		}
+	}
+	if d.Verdict == VerdictCount && len(s.blackList) > 0 {
+		if reason := s.blacklisted(c.ip); reason != "" {
+			d = Decision{Verdict: VerdictBan, Reason: reason}
+		}
+	}
+	if d.Verdict == VerdictCount && len(s.banCountries) > 0 {
+		if reason := s.countryBan(c.ip, ec); reason != "" {
+			d = Decision{Verdict: VerdictBan, Reason: reason}
+		}
+	}
+
+	category, forgivable := s.forgivableOf(c.reason)
+	if d.Verdict == VerdictCount && s.allowlist != nil {
+		if reason := s.allowlistBan(c.ip, ec, forgivable.BanInMinute); reason != "" {
+			d = Decision{Verdict: VerdictBan, Reason: reason}
+		}
+	}
+	ec.offenses.Offer(Offense{Time: c.at, Reason: c.reason})
+	ec.metadata = c.metadata
+	if d.Verdict == VerdictCount && d.Reason != "" {
+		ec.offenses.Offer(Offense{Time: c.at, Reason: d.Reason})
+	}
+	for ec.offenses.Size() > s.offenseLimit() {
+		ec.offenses.Get()
+	}
+
+	weight := max(d.Weight, 1)
+	if now := s.now(); d.Verdict != VerdictBan && s.allowError(ec, c.reason, category, forgivable, weight, now) {
+		reasons := []string{c.reason}
+		if d.Reason != "" {
+			reasons = append(reasons, d.Reason)
+		}
+		s.log(&Event{
+			IP:            c.ip,
+			Reasons:       reasons,
+			Action:        "count error",
+			Geo:           s.counterGeo(c.ip, ec),
+			CorrelationID: ec.correlationID,
+			Metadata:      c.metadata,
+		})
+		if forgivable.Warn && s.bansNext(ec, c.reason, category, forgivable, now) {
+			// the next error bans the ip
+			s.log(&Event{
+				IP:            c.ip,
+				Reasons:       reasons,
+				Action:        "ban-warning",
+				Geo:           s.counterGeo(c.ip, ec),
+				CorrelationID: ec.correlationID,
+				Metadata:      c.metadata,
+			})
 		}
-		s.logger.Log(c.ip, time.Time{}, []string{c.reason}, "count error", geo)
 		return
 	}
 
+	minutes := forgivable.BanInMinute
+	if d.Verdict == VerdictBan {
+		if d.Minutes > 0 {
+			minutes = d.Minutes
+		}
+		if d.Reason != "" {
+			ec.offenses.Offer(Offense{Time: c.at, Reason: d.Reason})
+		}
+	}
+
+	s.banCounted(c.ip, ec, minutes, d.BanAction, c.at)
+}
+
+// banCounted bans ip for minutes with the offenses of its counter.
+func (s *Firewall) banCounted(ip string, ec *errorCounter, minutes int, action BanAction, decidedAt time.Time) {
 	// record this ip is banned until time, no need to handle doCountError until then.
-	ec.bannedUntil = time.Now().Add(time.Duration(s.forgivable.BanInMinute) * time.Minute)
+	ec.bannedUntil = s.now().Add(time.Duration(minutes) * time.Minute)
+	ec.score = 0
 
-	reasons := []string{}
-	for ec.reasons.Size() > 0 {
-		r, _ := ec.reasons.Get()
-		reasons = append(reasons, r)
+	offenses := []Offense{}
+	for ec.offenses.Size() > 0 {
+		o, _ := ec.offenses.Get()
+		offenses = append(offenses, o)
 	}
 
 	s.doBanIP(&ban{
-		ip:              c.ip,
-		timeoutInMinute: s.forgivable.BanInMinute,
-		reasons:         reasons,
+		ip:              ip,
+		timeoutInMinute: minutes,
+		reasonCounts:    countReasons(offenses, maxReasonCountsSize),
+		offenses:        capOffenses(offenses, maxOffensesSize),
+		decidedAt:       decidedAt,
+		correlationID:   ec.correlationID,
+		metadata:        ec.metadata,
+		action:          action,
 	})
+	ec.metadata = nil
+}
+
+// counterGeo returns the geo data of "count error" events of ip, nil if
+// disabled by WithCountErrorGeo.
+func (s *Firewall) counterGeo(ip string, ec *errorCounter) *ipgeo.IPGeo {
+	if !s.countErrorGeo {
+		return nil
+	}
+	return s.errorGeo(ip, ec)
+}
+
+// errorGeo returns the geo data of ip for the checks of its errors, cached
+// in its counter like counterGeo. It returns nil without geo database.
+func (s *Firewall) errorGeo(ip string, ec *errorCounter) *ipgeo.IPGeo {
+	if s.ipGeo == nil {
+		return nil
+	}
+	if ec.geo == nil {
+		ec.geo = s.ipGeo.GetIPGeo(ip)
+	}
+	return ec.geo
 }
 
 // LogIPError counts an error happens on request from given ip, ban the ip
 // reach to the threshold.
 func (s *Firewall) LogIPError(ip string, reason string) {
-	s.countCh <- countingError{
-		ip:     ip,
-		reason: reason,
+	s.LogIPTargetError(ip, "", reason)
+}
+
+// LogIPTargetError is LogIPError with the target of the error, e.g. the
+// account of a failed login, passed to the policy to detect credential
+// stuffing.
+func (s *Firewall) LogIPTargetError(ip string, target string, reason string) {
+	s.logIPError(ip, target, reason, nil)
+}
+
+func (s *Firewall) logIPError(ip string, target string, reason string, md map[string]string) {
+	if !s.enter() {
+		return
 	}
+	defer s.senders.Done()
+
+	enqueue(s, s.countCh, countingError{
+		ip:       normalizeIP(ip),
+		reason:   reason,
+		target:   target,
+		at:       s.now(),
+		metadata: md,
+	})
 }
@@ -1,15 +1,23 @@
 package firewall
 
 import (
+	"context"
+	"fmt"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/charleshuang3/firewall/ipgeo"
 )
 
+// testForgivable bans an ip on its second error in a minute for 5 minutes.
+var testForgivable = ForgivableError{Duration: time.Minute, Count: 1, BanInMinute: 5}
+
 // MockIFirewall is a mock implementation of IFirewall for testing.
 type MockIFirewall struct {
 	BannedIPs []string
@@ -83,7 +91,8 @@ func TestBanIP(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockFW := &MockIFirewall{}
 			mockLogger := &MockILogger{}
-			fw := New(tt.whiteList, mockFW, mockLogger, nil, ForgivableError{}) // ipGeo and forgivableError are not used in BanIP directly
+			fw, err := New(tt.whiteList, mockFW, mockLogger, nil, testForgivable) // ipGeo and forgivableError are not used in BanIP directly
+			require.NoError(t, err)
 
 			if tt.expectedLog != nil { // If we expect a log
 				mockLogger.Wg.Add(1)
@@ -115,6 +124,56 @@ func TestBanIP(t *testing.T) {
 	}
 }
 
+func TestIPv6(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{"2001:db8:1::/48"}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(4)
+	fw.BanIP("2001:db8:1::1", 10, "whitelisted")
+	fw.BanIP("2001:db8:2::1", 10, "scanner")
+	fw.LogIPError("2001:db8:3::1", "r")
+	fw.LogIPError("2001:db8:3::1", "r")
+	// invalid ips are not whitelisted, and do not crash the loop.
+	fw.LogIPError("not an ip", "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, "count error", mockLogger.Events[3].Action)
+	assert.Equal(t, []string{"2001:db8:2::1", "2001:db8:3::1"}, mockFW.BannedIPs)
+	ok, _ := fw.IsBanned("2001:db8:3::1")
+	assert.True(t, ok)
+}
+
+func TestNormalizeIP_EntryPoints(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{"10.0.0.0/8"}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 2, BanInMinute: 5})
+	require.NoError(t, err)
+
+	// the messy forms of one client share its counter
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("::ffff:192.0.2.1", "r")
+	fw.LogIPError("192.0.2.1:5678", "r")
+	fw.LogIPError(" [::ffff:192.0.2.1]:443 ", "r")
+	// whitelisted once normalized
+	fw.LogIPError("::ffff:10.0.0.1", "r")
+	fw.BanIP("10.0.0.2:22", 10, "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, "ban", mockLogger.Events[2].Action)
+	assert.Equal(t, "192.0.2.1", mockLogger.Events[2].IP)
+	assert.Equal(t, []string{"192.0.2.1"}, mockFW.BannedIPs)
+
+	ok, _ := fw.IsBanned("::ffff:192.0.2.1")
+	assert.True(t, ok)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("FE80::1%eth0", 10, "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, []string{"192.0.2.1", "fe80::1"}, mockFW.BannedIPs)
+}
+
 func TestLogIPError(t *testing.T) {
 	tests := []struct {
 		name              string
@@ -177,7 +236,8 @@ func TestLogIPError(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockFW := &MockIFirewall{}
 			mockLogger := &MockILogger{}
-			fw := New(tt.whiteList, mockFW, mockLogger, nil, tt.forgivable) // ipGeo is not used in LogIPError directly
+			fw, err := New(tt.whiteList, mockFW, mockLogger, nil, tt.forgivable) // ipGeo is not used in LogIPError directly
+			require.NoError(t, err)
 
 			if tt.expectLog { // Use the new field from the test struct
 				mockLogger.Wg.Add(tt.errorCount)
@@ -205,3 +265,272 @@ func TestLogIPError(t *testing.T) {
 		})
 	}
 }
+
+func TestCountErrorGeo(t *testing.T) {
+	db, err := ipgeo.NewAutoUpdateMMIPGeo("ipgeo/test-data/GeoLite2-City-Test.mmdb", "ipgeo/test-data/GeoLite2-City-Test.mmdb",
+		"ipgeo/test-data/GeoLite2-ASN-Test.mmdb", "ipgeo/test-data/GeoLite2-ASN-Test.mmdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+
+	mockLogger := &MockILogger{}
+	fw, err := New([]string{}, &MockIFirewall{}, mockLogger, db, forgivable)
+	require.NoError(t, err)
+	mockLogger.Wg.Add(2)
+	fw.LogIPError("81.2.69.160", "r")
+	fw.LogIPError("81.2.69.160", "r")
+	mockLogger.Wg.Wait()
+
+	require.Len(t, mockLogger.Logs, 2)
+	for _, l := range mockLogger.Logs {
+		require.NotNil(t, l.Geo)
+		assert.Equal(t, "GB", l.Geo.CountryISO)
+	}
+	// cached in the counter
+	assert.Equal(t, uint64(1), db.Stats().Lookups)
+
+	mockLogger = &MockILogger{}
+	fw, err = New([]string{}, &MockIFirewall{}, mockLogger, db, forgivable, WithCountErrorGeo(false))
+	require.NoError(t, err)
+	mockLogger.Wg.Add(1)
+	fw.LogIPError("81.2.69.160", "r")
+	mockLogger.Wg.Wait()
+
+	assert.Nil(t, mockLogger.Logs[0].Geo)
+	assert.Equal(t, uint64(1), db.Stats().Lookups)
+}
+
+// MockErrorFirewall is a mock implementation of IErrorFirewall which always
+// fails.
+type MockErrorFirewall struct {
+	MockIFirewall
+	Err error
+}
+
+func (m *MockErrorFirewall) Name() string {
+	return "mock"
+}
+
+func (m *MockErrorFirewall) TryBanIP(ip string, timeoutInMinute int) error {
+	m.BanIP(ip, timeoutInMinute)
+	return m.Err
+}
+
+func TestBanIP_BackendError(t *testing.T) {
+	mockFW := &MockErrorFirewall{Err: &StatusError{Code: 401, Body: "denied"}}
+	mockLogger := &MockILogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("192.168.1.1", 10, "Too many failed logins")
+	mockLogger.Wg.Wait()
+
+	assert.Len(t, mockLogger.Logs, 2)
+	assert.Equal(t, "backend-error", mockLogger.Logs[0].Action)
+	assert.Equal(t, []string{`mock ban failed (attempt 1, auth): code = 401, resp = "denied"`}, mockLogger.Logs[0].Reasons)
+	assert.Equal(t, "ban", mockLogger.Logs[1].Action)
+}
+
+func TestNoBackend(t *testing.T) {
+	mockLogger := &MockILogger{}
+	fw, err := New([]string{}, nil, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 1, BanInMinute: 10}, WithBanWorkers(2))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("192.168.1.1", "bad password")
+	fw.LogIPError("192.168.1.1", "bad password")
+	fw.UnbanIP("192.168.1.1", "false positive")
+	mockLogger.Wg.Wait()
+
+	actions := []string{}
+	for _, l := range mockLogger.Logs {
+		actions = append(actions, l.Action)
+	}
+	assert.Equal(t, []string{"count error", "ban", "unban"}, actions)
+	banned, _ := fw.IsBanned("192.168.1.1")
+	assert.False(t, banned)
+}
+
+func TestNew_InvalidRules(t *testing.T) {
+	_, err := New([]string{"10.0.0.0/8", "10.0.0.0/33"}, &MockIFirewall{}, MultiLogger{}, nil, testForgivable)
+	assert.ErrorContains(t, err, "whitelist")
+
+	_, err = New([]string{}, &MockIFirewall{}, MultiLogger{}, nil, testForgivable, WithBlacklist("1.2.3.4", "not an ip"))
+	assert.ErrorContains(t, err, "blacklist")
+}
+
+func TestNew_InvalidInput(t *testing.T) {
+	forgivable := testForgivable
+	_, err := New([]string{}, &MockIFirewall{}, nil, nil, forgivable)
+	assert.EqualError(t, err, "firewall logger is nil")
+
+	_, err = New([]string{}, &MockIFirewall{}, MultiLogger{}, nil, ForgivableError{})
+	assert.EqualError(t, err, "forgivable duration 0s is not positive")
+
+	_, err = New([]string{}, &MockIFirewall{}, MultiLogger{}, nil, ForgivableError{Count: 1, BanInMinute: 5})
+	assert.EqualError(t, err, "forgivable duration 0s is not positive")
+
+	_, err = New([]string{}, &MockIFirewall{}, MultiLogger{}, nil, ForgivableError{Duration: time.Minute, Count: 1})
+	assert.EqualError(t, err, "forgivable ban in minute 0 is not positive")
+
+	_, err = New([]string{}, &MockIFirewall{}, MultiLogger{}, nil, ForgivableError{Duration: time.Minute, Count: -1, BanInMinute: 5})
+	assert.EqualError(t, err, "forgivable count -1 is negative")
+
+	// a count of 0 bans on the first error
+	fw, err := New([]string{}, &MockIFirewall{}, MultiLogger{}, nil, ForgivableError{Duration: time.Minute, BanInMinute: 5})
+	require.NoError(t, err)
+	fw.Close(context.Background())
+}
+
+func TestInWhitelist_InvalidIP(t *testing.T) {
+	fw, err := New([]string{"0.0.0.0/0", "::/0"}, &MockIFirewall{}, MultiLogger{}, nil, testForgivable, WithSkipPrivateIPs(true))
+	require.NoError(t, err)
+	fw.do(func() {
+		assert.False(t, fw.inWhitelist("not an ip"))
+		assert.False(t, fw.inWhitelist(""))
+		assert.True(t, fw.inWhitelist("1.2.3.4"))
+	})
+}
+
+func TestEnforcementStats(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockILogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.BanIP("192.168.1.1", 10, "Too many failed logins")
+	fw.LogIPError("192.168.1.2", "Invalid password")
+	fw.LogIPError("192.168.1.2", "Invalid password")
+	mockLogger.Wg.Wait()
+
+	stats := fw.EnforcementStats()
+	assert.Equal(t, 2, stats.Count)
+	assert.GreaterOrEqual(t, stats.QueueTotal, stats.QueueMax)
+	assert.GreaterOrEqual(t, stats.BackendTotal, stats.BackendMax)
+}
+
+// MockEventLogger is a mock implementation of IEventLogger for testing.
+type MockEventLogger struct {
+	MockILogger
+	Events []*Event
+}
+
+func (m *MockEventLogger) LogEvent(e *Event) {
+	m.Events = append(m.Events, e)
+	m.Wg.Done()
+}
+
+func TestCorrelationID(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 2, BanInMinute: 5})
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(5)
+	for i := 0; i < 4; i++ {
+		fw.LogIPError("192.168.1.1", "Invalid password")
+	}
+	fw.BanIP("192.168.1.2", 10, "Too many failed logins")
+	mockLogger.Wg.Wait()
+
+	actions := []string{}
+	for _, e := range mockLogger.Events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"count error", "count error", "ban", "banned", "ban"}, actions)
+
+	id := mockLogger.Events[0].CorrelationID
+	assert.Len(t, id, 36)
+	for _, e := range mockLogger.Events[:4] {
+		assert.Equal(t, id, e.CorrelationID)
+	}
+	assert.NotEmpty(t, mockLogger.Events[4].CorrelationID)
+	assert.NotEqual(t, id, mockLogger.Events[4].CorrelationID)
+}
+
+func TestWithLabels(t *testing.T) {
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, &MockIFirewall{}, mockLogger, nil, testForgivable, WithLabels(map[string]string{"tenant": "a"}))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("192.168.1.1", 10, "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, map[string]string{"tenant": "a"}, mockLogger.Events[0].Labels)
+}
+
+// MockFlushLogger is a mock implementation of IFlushLogger for testing.
+type MockFlushLogger struct {
+	MockILogger
+	Flushed int
+}
+
+func (m *MockFlushLogger) Flush() error {
+	m.Flushed++
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	store := &MockStateStore{m: map[string][]byte{}}
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockFlushLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable, WithStateStore(store))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("1.2.3.4", "r")
+	fw.LogIPError("1.2.3.4", "r")
+	fw.LogIPError("1.2.3.4", "r")
+
+	require.NoError(t, fw.Close(context.Background()))
+	mockLogger.Wg.Wait()
+	assert.Equal(t, []string{"1.2.3.4"}, mockFW.BannedIPs)
+	assert.Equal(t, 1, mockLogger.Flushed)
+	// the dedupe state is saved without waiting for the flush interval.
+	assert.NotNil(t, store.m[dedupeStateKey])
+
+	// events after Close are dropped, queries still work.
+	fw.BanIP("1.2.3.5", 10, "r")
+	fw.LogIPError("1.2.3.5", "r")
+	assert.Len(t, mockLogger.Logs, 3)
+	banned, _ := fw.IsBanned("1.2.3.4")
+	assert.True(t, banned)
+
+	assert.NoError(t, fw.Close(context.Background()))
+}
+
+func TestClose_Concurrent(t *testing.T) {
+	mockLogger := &MockILogger{}
+	fw, err := New([]string{}, &MockIFirewall{}, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 100, BanInMinute: 5})
+	require.NoError(t, err)
+
+	sent := atomic.Int32{}
+	wg := sync.WaitGroup{}
+	mockLogger.Wg.Add(1000)
+	for i := range 10 {
+		wg.Go(func() {
+			for j := range 100 {
+				fw.LogIPError(fmt.Sprintf("1.2.%d.%d", i, j), "r")
+				sent.Add(1)
+			}
+		})
+	}
+	for sent.Load() < 100 {
+		runtime.Gosched()
+	}
+
+	// events sent before Close are handled, senders racing with Close do
+	// not block.
+	before := int(sent.Load())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, fw.Close(ctx))
+	wg.Wait()
+
+	assert.GreaterOrEqual(t, len(mockLogger.Logs), before)
+	assert.LessOrEqual(t, len(mockLogger.Logs), 1000)
+}
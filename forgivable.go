@@ -0,0 +1,154 @@
+package firewall
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// counterGCInterval is how often idle error counters are evicted.
+const counterGCInterval = time.Minute
+
+// forgivableOf returns the category of reason set by WithReasonForgivable,
+// the longest prefix of reason, and its threshold. Reasons of no category
+// have the default threshold and category "".
+func (s *Firewall) forgivableOf(reason string) (string, ForgivableError) {
+	category := ""
+	for prefix := range s.reasonForgivable {
+		if strings.HasPrefix(reason, prefix) && len(prefix) > len(category) {
+			category = prefix
+		}
+	}
+	if category == "" {
+		return "", s.forgivable
+	}
+	return category, s.reasonForgivable[category]
+}
+
+// offenseLimit is the number of offenses kept per ip, the largest count of
+// all categories. The error itself is kept even if it bans on the first one.
+func (s *Firewall) offenseLimit() int {
+	n := max(s.forgivable.Count, 1)
+	for _, f := range s.reasonForgivable {
+		n = max(n, f.Count)
+	}
+	if s.scoring != nil {
+		n = max(n, s.scoring.offenseLimit())
+	}
+	return n
+}
+
+// allowError counts an error of reason weight times, and returns whether
+// the ip is still forgiven: under the threshold of its category, or under
+// the score threshold set by WithScoring.
+func (s *Firewall) allowError(ec *errorCounter, reason, category string, f ForgivableError, weight int, now time.Time) bool {
+	if s.scoring != nil {
+		return !s.scoring.addScore(ec, reason, weight, now)
+	}
+	return ec.limiter(category, f).AllowN(now, weight)
+}
+
+// bansNext returns whether the next error of reason bans the ip.
+func (s *Firewall) bansNext(ec *errorCounter, reason, category string, f ForgivableError, now time.Time) bool {
+	if s.scoring != nil {
+		return s.scoring.scoreAt(ec, now)+s.scoring.weightOf(reason) >= s.scoring.Threshold
+	}
+	return ec.limiter(category, f).TokensAt(now) < 1
+}
+
+// limiter returns the rate limiter of the errors of category.
+func (ec *errorCounter) limiter(category string, f ForgivableError) *rate.Limiter {
+	if category == "" {
+		return &ec.rateLimiter
+	}
+	if ec.categories == nil {
+		ec.categories = map[string]*rate.Limiter{}
+	}
+	l, ok := ec.categories[category]
+	if !ok {
+		l = rate.NewLimiter(rate.Every(f.Duration), f.Count)
+		ec.categories[category] = l
+	}
+	return l
+}
+
+// idle returns whether the counter forgot all errors at now: its limiters
+// are refilled and its ban has passed.
+func (ec *errorCounter) idle(now time.Time) bool {
+	if ec.bannedUntil.After(now) || ec.crawler == crawlerVerifying {
+		return false
+	}
+	if ec.rateLimiter.TokensAt(now) < float64(ec.rateLimiter.Burst()) {
+		return false
+	}
+	for _, l := range ec.categories {
+		if l.TokensAt(now) < float64(l.Burst()) {
+			return false
+		}
+	}
+	return true
+}
+
+// gcCounters evicts the idle error counters, so memory does not grow with
+// every ip ever seen. It runs at most every counterGCInterval.
+func (s *Firewall) gcCounters(now time.Time) {
+	if now.Sub(s.lastCounterGC) < counterGCInterval {
+		return
+	}
+	s.lastCounterGC = now
+
+	for ip, ec := range s.errorCount {
+		if ec.idle(now) && (s.scoring == nil || s.scoring.scoreAt(ec, now) < minScore) {
+			delete(s.errorCount, ip)
+		}
+	}
+}
+
+// ForgiveIP forgets the errors of ip, e.g. for a user who locked
+// themselves out, so its next errors are counted from scratch. It logs
+// "forgiven". With unban, the ip is also unbanned like UnbanIP with the
+// reason "forgiven", otherwise a jailed ip stays jailed until its ban
+// expires. It returns the error of the backend unban, the ip is neither
+// forgiven nor unbanned then.
+func (s *Firewall) ForgiveIP(ip string, unban bool) error {
+	ip = normalizeIP(ip)
+	var err error
+	s.do(func() {
+		correlationID := ""
+		if ec, ok := s.errorCount[ip]; ok {
+			correlationID = ec.correlationID
+		}
+		j, jailed := s.jail[ip]
+		if jailed && j.correlationID != "" {
+			correlationID = j.correlationID
+		}
+		if correlationID == "" {
+			correlationID = newCorrelationID()
+		}
+
+		if unban {
+			if err = s.unbanBackend(ip, correlationID); err != nil {
+				return
+			}
+			event := &Event{
+				IP:            ip,
+				Reasons:       []string{"forgiven"},
+				Action:        "unban",
+				CorrelationID: correlationID,
+			}
+			if jailed {
+				s.removeJail(ip)
+				event.Geo = j.geo
+			}
+			s.log(event)
+		}
+		delete(s.errorCount, ip)
+		s.log(&Event{
+			IP:            ip,
+			Action:        "forgiven",
+			CorrelationID: correlationID,
+		})
+	})
+	return err
+}
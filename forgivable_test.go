@@ -0,0 +1,199 @@
+package firewall
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReasonForgivable(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5},
+		WithReasonForgivable(map[string]ForgivableError{
+			"sql injection":      {Duration: time.Minute, Count: 0, BanInMinute: 60},
+			"bad password":       {Duration: time.Minute, Count: 2, BanInMinute: 10},
+			"bad password admin": {Duration: time.Minute, Count: 0, BanInMinute: 30},
+		}))
+	require.NoError(t, err)
+
+	// categories are counted separately
+	mockLogger.Wg.Add(5)
+	fw.LogIPError("1.2.3.4", "bad password")
+	fw.LogIPError("1.2.3.4", "bad password")
+	fw.LogIPError("1.2.3.4", "not found")
+	fw.LogIPError("1.2.3.4", "not found")
+	fw.LogIPError("1.2.3.4", "bad password")
+	mockLogger.Wg.Wait()
+
+	require.Len(t, mockLogger.Events, 5)
+	e := mockLogger.Events[4]
+	assert.Equal(t, "ban", e.Action)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), e.JailUntil, time.Second)
+	assert.Equal(t, []string{"bad password", "not found"}, e.Reasons)
+	assert.Equal(t, map[string]int{"bad password": 3, "not found": 2}, e.ReasonCounts)
+	assert.Len(t, e.Offenses, 5)
+
+	// the longest prefix applies, a count of 0 bans on the first error
+	mockLogger.Wg.Add(2)
+	fw.LogIPError("1.2.3.5", "sql injection in /login")
+	fw.LogIPError("1.2.3.6", "bad password admin")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, "ban", mockLogger.Events[5].Action)
+	assert.Equal(t, []string{"sql injection in /login"}, mockLogger.Events[5].Reasons)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), mockLogger.Events[5].JailUntil, time.Second)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), mockLogger.Events[6].JailUntil, time.Second)
+	assert.Equal(t, []string{"1.2.3.4", "1.2.3.5", "1.2.3.6"}, mockFW.BannedIPs)
+}
+
+func TestReasonForgivable_Invalid(t *testing.T) {
+	for _, bad := range []ForgivableError{
+		{Duration: 0, Count: 2, BanInMinute: 10},
+		{Duration: time.Minute, Count: 2, BanInMinute: 0},
+		{Duration: time.Minute, Count: -1, BanInMinute: 10},
+	} {
+		_, err := New([]string{}, &MockIFirewall{}, &MockEventLogger{}, nil, testForgivable,
+			WithReasonForgivable(map[string]ForgivableError{"bad password": bad}))
+		assert.ErrorContains(t, err, `reason "bad password": forgivable`)
+	}
+}
+
+func TestForgivableWarn(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	warned := []string{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5},
+		WithReasonForgivable(map[string]ForgivableError{
+			"bad password": {Duration: time.Minute, Count: 2, BanInMinute: 10, Warn: true},
+		}),
+		WithHooks(Hooks{OnWarning: func(e *Event) { warned = append(warned, e.IP) }}))
+	require.NoError(t, err)
+
+	// only the category with Warn warns, on its last forgiven error
+	mockLogger.Wg.Add(5)
+	fw.LogIPError("1.2.3.4", "not found")
+	fw.LogIPError("1.2.3.4", "bad password")
+	fw.LogIPError("1.2.3.4", "bad password")
+	fw.LogIPError("1.2.3.4", "bad password")
+	mockLogger.Wg.Wait()
+	waitHooks(fw)
+
+	actions := []string{}
+	for _, e := range mockLogger.Events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"count error", "count error", "count error", "ban-warning", "ban"}, actions)
+	e := mockLogger.Events[3]
+	assert.Equal(t, []string{"bad password"}, e.Reasons)
+	assert.Equal(t, mockLogger.Events[4].CorrelationID, e.CorrelationID)
+	assert.Equal(t, []string{"1.2.3.4"}, warned)
+}
+
+func TestGCCounters(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 2, BanInMinute: 5},
+		WithReasonForgivable(map[string]ForgivableError{
+			"bad password": {Duration: time.Hour, Count: 2, BanInMinute: 10},
+		}))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(6)
+	fw.LogIPError("1.2.3.4", "r")
+	fw.LogIPError("1.2.3.5", "bad password")
+	for range 3 {
+		fw.LogIPError("1.2.3.6", "r")
+	}
+	fw.LogIPError("1.2.3.7", "r")
+	mockLogger.Wg.Wait()
+
+	counters := func() []string {
+		res := []string{}
+		fw.do(func() {
+			for ip := range fw.errorCount {
+				res = append(res, ip)
+			}
+		})
+		slices.Sort(res)
+		return res
+	}
+
+	now := time.Now()
+	fw.do(func() { fw.gcCounters(now) })
+	assert.Equal(t, []string{"1.2.3.4", "1.2.3.5", "1.2.3.6", "1.2.3.7"}, counters())
+
+	// refilled limiters are evicted, the category and the ban keep the others
+	fw.do(func() {
+		fw.lastCounterGC = time.Time{}
+		fw.gcCounters(now.Add(2 * time.Minute))
+	})
+	assert.Equal(t, []string{"1.2.3.5", "1.2.3.6"}, counters())
+
+	fw.do(func() {
+		fw.lastCounterGC = time.Time{}
+		fw.gcCounters(now.Add(2 * time.Hour))
+	})
+	assert.Empty(t, counters())
+}
+
+func TestForgiveIP(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Hour, Count: 2, BanInMinute: 5})
+	require.NoError(t, err)
+
+	// the count starts over
+	mockLogger.Wg.Add(4)
+	fw.LogIPError("1.2.3.4", "bad password")
+	fw.LogIPError("1.2.3.4", "bad password")
+	require.NoError(t, fw.ForgiveIP("1.2.3.4", false))
+	fw.LogIPError("1.2.3.4", "bad password")
+	mockLogger.Wg.Wait()
+	require.Len(t, mockLogger.Events, 4)
+	assert.Equal(t, "forgiven", mockLogger.Events[2].Action)
+	assert.Equal(t, mockLogger.Events[0].CorrelationID, mockLogger.Events[2].CorrelationID)
+	assert.Equal(t, "count error", mockLogger.Events[3].Action)
+
+	// a jailed ip stays jailed without unban
+	mockLogger.Wg.Add(3)
+	fw.BanIP("1.2.3.5", 10, "abuse")
+	require.NoError(t, fw.ForgiveIP("1.2.3.5", false))
+	fw.LogIPError("1.2.3.5", "bad password")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "forgiven", mockLogger.Events[5].Action)
+	assert.Equal(t, "count error", mockLogger.Events[6].Action)
+	assert.True(t, fw.IsBannedIP(net.ParseIP("1.2.3.5")))
+
+	mockLogger.Wg.Add(2)
+	require.NoError(t, fw.ForgiveIP("1.2.3.5", true))
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "unban", mockLogger.Events[7].Action)
+	assert.Equal(t, []string{"forgiven"}, mockLogger.Events[7].Reasons)
+	assert.Equal(t, "forgiven", mockLogger.Events[8].Action)
+	assert.Equal(t, mockLogger.Events[4].CorrelationID, mockLogger.Events[8].CorrelationID)
+	assert.False(t, fw.IsBannedIP(net.ParseIP("1.2.3.5")))
+}
+
+func TestForgiveIP_BackendError(t *testing.T) {
+	mockFW := &MockUnbanFirewall{Err: errors.New("appliance down")}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Hour, Count: 2, BanInMinute: 5})
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.2.3.5", 10, "abuse")
+	mockLogger.Wg.Wait()
+
+	// neither forgiven nor unbanned
+	mockLogger.Wg.Add(1)
+	assert.ErrorContains(t, fw.ForgiveIP("1.2.3.5", true), "appliance down")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "backend-error", mockLogger.Events[1].Action)
+	assert.True(t, fw.IsBannedIP(net.ParseIP("1.2.3.5")))
+}
@@ -2,6 +2,8 @@ package gcplog
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"time"
 
 	"cloud.google.com/go/logging"
@@ -11,14 +13,55 @@ import (
 	"github.com/charleshuang3/firewall/ipgeo"
 )
 
-var _ firewall.ILogger = (*Logger)(nil)
+var (
+	_ firewall.IEventLogger = (*Logger)(nil)
+	_ firewall.IFlushLogger = (*Logger)(nil)
+)
+
+// sink is where the queued entries go, a *logging.Logger.
+type sink interface {
+	Log(e logging.Entry)
+	Flush() error
+}
 
+// Logger logs events to Google Cloud Logging. Entries are sent in batches
+// from a bounded queue, see WithQueueSize.
 type Logger struct {
 	client *logging.Client
-	logger *logging.Logger
+	sink   sink
+
+	queue   *entryQueue
+	onDrop  func(dropped *firewall.Event)
+	drained chan struct{}
+	// optErr is the invalid setting of an option, returned by New.
+	optErr error
+}
+
+// Option configures a Logger.
+type Option func(*Logger)
+
+// WithQueueSize bounds the entries waiting to be sent, 10000 by default,
+// n must be positive. When full, the oldest entry which is not a "ban" is
+// dropped. Bans are never dropped, so a queue full of bans grows past n.
+func WithQueueSize(n int) Option {
+	return func(l *Logger) {
+		if n <= 0 {
+			l.optErr = fmt.Errorf("queue size %d must be positive", n)
+			return
+		}
+		l.queue = newEntryQueue(n)
+	}
+}
+
+// WithDropHook calls fn with each event dropped as the queue is full, e.g.
+// to alert. It is called by LogEvent, so it should be fast.
+func WithDropHook(fn func(dropped *firewall.Event)) Option {
+	return func(l *Logger) {
+		l.onDrop = fn
+	}
 }
 
-func New(authFile, projectID, service string) (*Logger, error) {
+func New(authFile, projectID, service string, opts ...Option) (*Logger, error) {
 	ctx := context.Background()
 	opt := option.WithCredentialsFile(authFile)
 	client, err := logging.NewClient(ctx, projectID, opt)
@@ -26,35 +69,171 @@ func New(authFile, projectID, service string) (*Logger, error) {
 		return nil, err
 	}
 
-	return &Logger{
-		client: client,
-		logger: client.Logger(service),
-	}, nil
+	l, err := newLogger(client.Logger(service), opts...)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	l.client = client
+	return l, nil
+}
+
+func newLogger(sink sink, opts ...Option) (*Logger, error) {
+	l := &Logger{
+		sink:    sink,
+		queue:   newEntryQueue(defaultQueueSize),
+		drained: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.optErr != nil {
+		return nil, l.optErr
+	}
+	go l.drain()
+	return l, nil
+}
+
+// drain sends the queued entries in batches until the queue is closed. A
+// batch is flushed before the next one, so a slow client backs up into
+// the bounded queue instead of its own buffer.
+func (s *Logger) drain() {
+	defer close(s.drained)
+	for {
+		batch, ok := s.queue.take(batchSize)
+		if !ok {
+			return
+		}
+		for _, e := range batch {
+			s.sink.Log(e)
+		}
+		if err := s.sink.Flush(); err != nil {
+			log.Printf("flush gcplog failed: %v", err)
+		}
+		s.queue.done(len(batch))
+	}
 }
 
-// Close Should be call in grateful shutdown
+// Close Should be call in grateful shutdown, it sends the queued entries
+// first.
 func (s *Logger) Close() {
-	s.client.Close()
+	s.queue.close()
+	<-s.drained
+	if s.client != nil {
+		s.client.Close()
+	}
+}
+
+// Flush sends the queued entries.
+func (s *Logger) Flush() error {
+	s.queue.wait()
+	return s.sink.Flush()
+}
+
+// QueueDepth returns the number of entries waiting to be sent.
+func (s *Logger) QueueDepth() int {
+	return s.queue.depth()
+}
+
+// Dropped returns the number of entries dropped as the queue was full.
+func (s *Logger) Dropped() uint64 {
+	return s.queue.droppedCount()
 }
 
 type logEntry struct {
-	IP        string       `json:"ip"`
-	JailUntil string       `json:"jail_until,omitempty"`
-	Reasons   []string     `json:"reasons"`
-	Action    string       `json:"action"`
-	Geo       *ipgeo.IPGeo `json:"geo"`
+	IP        string        `json:"ip"`
+	JailUntil string        `json:"jail_until,omitempty"`
+	Reasons   []string      `json:"reasons"`
+	Action    string        `json:"action"`
+	Geo       *ipgeo.IPGeo  `json:"geo"`
+	Backend   *backendEntry `json:"backend,omitempty"`
+	Latency   *latencyEntry `json:"latency,omitempty"`
+	Offenses  []offense     `json:"offenses,omitempty"`
+	// ReasonCounts counts the errors of a ban by reason.
+	ReasonCounts map[string]int `json:"reason_counts,omitempty"`
+	// Annotation is set on bans and unbans by an operator.
+	Annotation *firewall.Annotation `json:"annotation,omitempty"`
+	// Metadata is the context of the error or the ban, e.g. the username
+	// attempted.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// BanAction is how a ban is enforced if not by a block.
+	BanAction string `json:"ban_action,omitempty"`
+
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+type offense struct {
+	Time   string `json:"time"`
+	Reason string `json:"reason"`
+}
+
+type backendEntry struct {
+	Name    string `json:"name"`
+	Op      string `json:"op"`
+	Attempt int    `json:"attempt"`
+	Class   string `json:"class"`
+	Error   string `json:"error"`
+}
+
+type latencyEntry struct {
+	QueueMS   int64 `json:"queue_ms"`
+	BackendMS int64 `json:"backend_ms"`
+	TotalMS   int64 `json:"total_ms"`
 }
 
 func (s *Logger) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	s.LogEvent(&firewall.Event{
+		IP:        ip,
+		JailUntil: jailUntil,
+		Reasons:   reasons,
+		Action:    action,
+		Geo:       geo,
+	})
+}
+
+func (s *Logger) LogEvent(ev *firewall.Event) {
 	e := &logEntry{
-		IP:      ip,
-		Reasons: reasons,
-		Action:  action,
-		Geo:     geo,
+		IP:            ev.IP,
+		Reasons:       ev.Reasons,
+		Action:        ev.Action,
+		Geo:           ev.Geo,
+		ReasonCounts:  ev.ReasonCounts,
+		Annotation:    ev.Annotation,
+		Metadata:      ev.Metadata,
+		BanAction:     string(ev.BanAction),
+		CorrelationID: ev.CorrelationID,
+		Labels:        ev.Labels,
+	}
+	if !ev.JailUntil.IsZero() {
+		e.JailUntil = ev.JailUntil.Format(time.RFC3339)
 	}
-	if !jailUntil.IsZero() {
-		e.JailUntil = jailUntil.Format(time.RFC3339)
+	if be := ev.Backend; be != nil {
+		e.Backend = &backendEntry{
+			Name:    be.Backend,
+			Op:      be.Op,
+			Attempt: be.Attempt,
+			Class:   be.Class(),
+			Error:   be.Err.Error(),
+		}
 	}
 
-	s.logger.Log(logging.Entry{Payload: e})
+	if l := ev.Latency; l != nil {
+		e.Latency = &latencyEntry{
+			QueueMS:   l.Queue.Milliseconds(),
+			BackendMS: l.Backend.Milliseconds(),
+			TotalMS:   l.Total().Milliseconds(),
+		}
+	}
+
+	for _, o := range ev.Offenses {
+		e.Offenses = append(e.Offenses, offense{
+			Time:   o.Time.Format(time.RFC3339Nano),
+			Reason: o.Reason,
+		})
+	}
+
+	if dropped := s.queue.push(logging.Entry{Payload: e, Labels: ev.Labels}, ev); dropped != nil && s.onDrop != nil {
+		s.onDrop(dropped)
+	}
 }
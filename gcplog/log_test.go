@@ -0,0 +1,97 @@
+package gcplog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+)
+
+// blockingSink records the entries, its Flush blocks until release.
+type blockingSink struct {
+	mu      sync.Mutex
+	ips     []string
+	release chan struct{}
+}
+
+func (s *blockingSink) Log(e logging.Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ips = append(s.ips, e.Payload.(*logEntry).IP)
+}
+
+func (s *blockingSink) Flush() error {
+	<-s.release
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	dropped := []string{}
+	l, err := newLogger(sink, WithQueueSize(3), WithDropHook(func(e *firewall.Event) {
+		dropped = append(dropped, e.IP)
+	}))
+	require.NoError(t, err)
+
+	// the first entry is taken, then the flush blocks
+	l.LogEvent(&firewall.Event{IP: "0", Action: "count error"})
+	require.Eventually(t, func() bool { return l.QueueDepth() == 0 }, time.Second, time.Millisecond)
+
+	l.LogEvent(&firewall.Event{IP: "1", Action: "count error"})
+	l.LogEvent(&firewall.Event{IP: "2", Action: "ban"})
+	l.LogEvent(&firewall.Event{IP: "3", Action: "count error"})
+	// drops the oldest which is not a ban
+	l.LogEvent(&firewall.Event{IP: "4", Action: "ban"})
+	l.LogEvent(&firewall.Event{IP: "5", Action: "ban"})
+	// full of bans, drops the new entry unless a ban
+	l.LogEvent(&firewall.Event{IP: "6", Action: "count error"})
+	l.LogEvent(&firewall.Event{IP: "7", Action: "ban"})
+
+	assert.Equal(t, 4, l.QueueDepth())
+	assert.Equal(t, uint64(3), l.Dropped())
+	assert.Equal(t, []string{"1", "3", "6"}, dropped)
+
+	close(sink.release)
+	require.NoError(t, l.Flush())
+	l.Close()
+	assert.Equal(t, []string{"0", "2", "4", "5", "7"}, sink.ips)
+}
+
+func TestQueue_InvalidSize(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		_, err := newLogger(&blockingSink{}, WithQueueSize(n))
+		assert.Error(t, err)
+	}
+}
+
+func TestQueue_Order(t *testing.T) {
+	q := newEntryQueue(100)
+	for i := range 10 {
+		action := "count error"
+		if i%3 == 0 {
+			action = "ban"
+		}
+		q.push(logging.Entry{Payload: i}, &firewall.Event{IP: fmt.Sprint(i), Action: action})
+	}
+
+	got := []any{}
+	for _, n := range []int{4, 4, 4} {
+		batch, ok := q.take(n)
+		require.True(t, ok)
+		for _, e := range batch {
+			got = append(got, e.Payload)
+		}
+		q.done(len(batch))
+	}
+	assert.Equal(t, []any{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, got)
+
+	q.close()
+	_, ok := q.take(1)
+	assert.False(t, ok)
+}
@@ -0,0 +1,143 @@
+package gcplog
+
+import (
+	"sync"
+
+	"cloud.google.com/go/logging"
+
+	"github.com/charleshuang3/firewall"
+)
+
+const (
+	defaultQueueSize = 10000
+	// batchSize is the most entries sent by one flush.
+	batchSize = 500
+)
+
+type queued struct {
+	seq   uint64
+	entry logging.Entry
+	event *firewall.Event
+}
+
+// entryQueue buffers the entries for the cloud logging client, so a slow
+// client is not buffered without bound during a flood. When full, the
+// oldest entry which is not a "ban" is dropped, bans are never dropped: a
+// ban is queued past size when only bans are queued.
+type entryQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	size int
+	seq  uint64
+	// bans and others are FIFO, merged by seq.
+	bans, others []queued
+	// inflight are the entries taken and not flushed yet.
+	inflight int
+	dropped  uint64
+	closed   bool
+}
+
+func newEntryQueue(size int) *entryQueue {
+	q := &entryQueue{size: size}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues entry of ev. It returns the event dropped to make room, nil
+// if none.
+func (q *entryQueue) push(entry logging.Entry, ev *firewall.Event) *firewall.Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	it := queued{seq: q.seq, entry: entry, event: ev}
+	ban := ev.Action == "ban"
+	var dropped *firewall.Event
+	if len(q.bans)+len(q.others) >= q.size {
+		switch {
+		case len(q.others) > 0:
+			dropped = q.others[0].event
+			q.others[0] = queued{}
+			q.others = q.others[1:]
+		case !ban:
+			// full of bans
+			q.dropped++
+			return ev
+		}
+		if dropped != nil {
+			q.dropped++
+		}
+	}
+	if ban {
+		q.bans = append(q.bans, it)
+	} else {
+		q.others = append(q.others, it)
+	}
+	q.cond.Broadcast()
+	return dropped
+}
+
+// take waits for entries and returns up to n of them in order, false once
+// the queue is closed and empty. done must be called after they are sent.
+func (q *entryQueue) take(n int) ([]logging.Entry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.bans)+len(q.others) == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.cond.Wait()
+	}
+
+	res := []logging.Entry{}
+	for len(res) < n && len(q.bans)+len(q.others) > 0 {
+		if len(q.others) == 0 || len(q.bans) > 0 && q.bans[0].seq < q.others[0].seq {
+			res = append(res, q.bans[0].entry)
+			q.bans[0] = queued{}
+			q.bans = q.bans[1:]
+		} else {
+			res = append(res, q.others[0].entry)
+			q.others[0] = queued{}
+			q.others = q.others[1:]
+		}
+	}
+	q.inflight += len(res)
+	return res, true
+}
+
+// done marks n taken entries as sent.
+func (q *entryQueue) done(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inflight -= n
+	q.cond.Broadcast()
+}
+
+// wait waits until the entries queued are sent.
+func (q *entryQueue) wait() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.bans)+len(q.others)+q.inflight > 0 {
+		q.cond.Wait()
+	}
+}
+
+// close lets take return false once the queue is empty.
+func (q *entryQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (q *entryQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.bans) + len(q.others)
+}
+
+func (q *entryQueue) droppedCount() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
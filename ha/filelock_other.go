@@ -0,0 +1,22 @@
+//go:build !unix
+
+package ha
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// FileLock is not supported, there is no flock.
+type FileLock struct {
+	Path string
+}
+
+func (l *FileLock) TryLock(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return false, errors.New("file lock is not supported on this platform")
+}
+
+func (l *FileLock) Unlock(ctx context.Context, id string) error {
+	return nil
+}
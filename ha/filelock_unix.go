@@ -0,0 +1,58 @@
+//go:build unix
+
+package ha
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FileLock is an exclusive flock of Path, held until Unlock or the process
+// exits, e.g. for a cold standby on the same host or a shared filesystem
+// with working locks. The ttl is not used.
+type FileLock struct {
+	Path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (l *FileLock) TryLock(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		return true, nil
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return false, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, err
+	}
+	// the holder, for operators
+	f.Truncate(0)
+	f.WriteAt([]byte(id+"\n"), 0)
+	l.f = f
+	return true, nil
+}
+
+func (l *FileLock) Unlock(ctx context.Context, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,87 @@
+package ha
+
+import (
+	"sync/atomic"
+
+	"github.com/charleshuang3/firewall"
+)
+
+var (
+	_ firewall.IActionFirewall  = (*Gate)(nil)
+	_ firewall.IRoutingFirewall = (*Gate)(nil)
+)
+
+// Backend is the backend of a daemon.
+type Backend interface {
+	firewall.IErrorFirewall
+	firewall.IUnbanFirewall
+}
+
+// Gate passes the calls to its backend only while this instance is the
+// leader, bans and unbans of a follower succeed without a write. A gate
+// starts closed.
+type Gate struct {
+	b      Backend
+	leader atomic.Bool
+}
+
+func NewGate(b Backend) *Gate {
+	return &Gate{b: b}
+}
+
+// SetLeader opens the gate for the leader and closes it for a follower.
+func (g *Gate) SetLeader(leader bool) {
+	g.leader.Store(leader)
+}
+
+func (g *Gate) IsLeader() bool {
+	return g.leader.Load()
+}
+
+func (g *Gate) Name() string {
+	return g.b.Name()
+}
+
+func (g *Gate) ExpiresBans() bool {
+	e, ok := g.b.(firewall.IExpiringFirewall)
+	return ok && e.ExpiresBans()
+}
+
+func (g *Gate) BansNetworks() bool {
+	n, ok := g.b.(firewall.INetworkFirewall)
+	return ok && n.BansNetworks()
+}
+
+func (g *Gate) BanIP(ip string, timeoutInMinute int) {
+	if g.leader.Load() {
+		g.b.BanIP(ip, timeoutInMinute)
+	}
+}
+
+func (g *Gate) TryBanIP(ip string, timeoutInMinute int) error {
+	if !g.leader.Load() {
+		return nil
+	}
+	return g.b.TryBanIP(ip, timeoutInMinute)
+}
+
+// RouteBanIP passes the reasons of the ban to a backend routing bans.
+func (g *Gate) RouteBanIP(ip string, timeoutInMinute int, reasons []string) error {
+	return g.ActionBanIP(ip, timeoutInMinute, firewall.BanActionBlock, reasons)
+}
+
+// ActionBanIP passes the action and the reasons of the ban to a backend
+// enforcing actions.
+func (g *Gate) ActionBanIP(ip string, timeoutInMinute int, action firewall.BanAction, reasons []string) error {
+	if !g.leader.Load() {
+		return nil
+	}
+	return firewall.ForwardBan(g.b, ip, timeoutInMinute, action, reasons)
+}
+
+func (g *Gate) UnbanIP(ip string) error {
+	if !g.leader.Load() {
+		return nil
+	}
+	return g.b.UnbanIP(ip)
+}
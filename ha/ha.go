@@ -0,0 +1,88 @@
+// Package ha runs two firewall instances as leader and follower. Only the
+// leader writes to the backend, so appliance writes never conflict, while
+// the follower mirrors the jail of the leader and takes over when the
+// leader fails. The leader is elected with a lock shared by the instances:
+// a file lock, a Redis key or a Kubernetes Lease.
+package ha
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultTTL is how long a lock is held without renewal.
+const DefaultTTL = 15 * time.Second
+
+// Lock is held by one instance at a time, until it is released or not
+// renewed for its ttl.
+type Lock interface {
+	// TryLock acquires the lock for id, or renews it if id holds it, for
+	// ttl. It returns whether id holds the lock.
+	TryLock(ctx context.Context, id string, ttl time.Duration) (bool, error)
+	// Unlock releases the lock if id holds it.
+	Unlock(ctx context.Context, id string) error
+}
+
+// Elector campaigns for the lock, it renews the lock every third of TTL.
+type Elector struct {
+	Lock Lock
+	// ID names this instance, e.g. the hostname.
+	ID string
+	// TTL is DefaultTTL if zero.
+	TTL time.Duration
+}
+
+// Run campaigns until ctx is done, then releases the lock. onChange is
+// called when this instance becomes the leader or stops being it. When the
+// lock can not be renewed, the leader steps down before the lock expires,
+// so two leaders never write at once.
+func (e *Elector) Run(ctx context.Context, onChange func(leader bool)) {
+	ttl := e.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	leader := false
+	set := func(l bool) {
+		if l != leader {
+			leader = l
+			onChange(l)
+		}
+	}
+
+	var renewed time.Time
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		ok, err := e.Lock.TryLock(ctx, e.ID, ttl)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("ha: lock failed: %v", err)
+			if leader && time.Since(renewed) > ttl/2 {
+				log.Printf("ha: %s steps down, the lock may expire", e.ID)
+				set(false)
+			}
+		case ok:
+			renewed = start
+			set(true)
+		case err == nil:
+			set(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			if leader {
+				unlockCtx, cancel := context.WithTimeout(context.Background(), ttl/3)
+				if err := e.Lock.Unlock(unlockCtx, e.ID); err != nil {
+					log.Printf("ha: unlock failed: %v", err)
+				}
+				cancel()
+				set(false)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
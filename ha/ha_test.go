@@ -0,0 +1,487 @@
+package ha
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+)
+
+// fakeLock is held by the id in holder, err fails every call.
+type fakeLock struct {
+	mu       sync.Mutex
+	holder   string
+	err      error
+	unlocked bool
+}
+
+func (f *fakeLock) TryLock(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return false, f.err
+	}
+	if f.holder == "" {
+		f.holder = id
+	}
+	return f.holder == id, nil
+}
+
+func (f *fakeLock) Unlock(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.holder == id {
+		f.holder = ""
+		f.unlocked = true
+	}
+	return nil
+}
+
+func (f *fakeLock) set(holder string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.holder = holder
+	f.err = err
+}
+
+func TestElector(t *testing.T) {
+	lock := &fakeLock{holder: "b"}
+	e := &Elector{Lock: lock, ID: "a", TTL: 30 * time.Millisecond}
+	changes := make(chan bool, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Run(ctx, func(leader bool) { changes <- leader })
+	}()
+
+	// b fails, a takes over
+	lock.set("", nil)
+	assert.True(t, <-changes)
+
+	// a can not renew, it steps down before the lock expires
+	lock.set("a", errors.New("down"))
+	assert.False(t, <-changes)
+
+	lock.set("a", nil)
+	assert.True(t, <-changes)
+
+	cancel()
+	<-done
+	assert.False(t, <-changes)
+	assert.True(t, lock.unlocked)
+}
+
+func TestFileLock(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("no flock")
+	}
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	a := &FileLock{Path: path}
+	b := &FileLock{Path: path}
+	ctx := context.Background()
+
+	ok, err := a.TryLock(ctx, "a", time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = a.TryLock(ctx, "a", time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = b.TryLock(ctx, "b", time.Second)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, a.Unlock(ctx, "a"))
+	ok, err = b.TryLock(ctx, "b", time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	b.Unlock(ctx, "b")
+}
+
+// fakeRedis runs the two lock scripts of RedisLock.
+type fakeRedis struct {
+	mu       sync.Mutex
+	password string
+	key      string
+	value    string
+	expires  time.Time
+}
+
+func (f *fakeRedis) serve(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		reply := f.run(args, &authed)
+		f.mu.Unlock()
+		io.WriteString(conn, reply)
+	}
+}
+
+func (f *fakeRedis) run(args []string, authed *bool) string {
+	if args[0] == "AUTH" {
+		if args[1] != f.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+	if !*authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+	if args[0] != "EVAL" || len(args) < 5 {
+		return "-ERR unknown command\r\n"
+	}
+	f.key = args[3]
+	if time.Now().After(f.expires) {
+		f.value = ""
+	}
+	switch args[1] {
+	case redisTryLock:
+		if f.value != "" && f.value != args[4] {
+			return ":0\r\n"
+		}
+		ms, _ := strconv.Atoi(args[5])
+		f.value = args[4]
+		f.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+	case redisUnlock:
+		if f.value == args[4] {
+			f.value = ""
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown script\r\n"
+}
+
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(line[1 : len(line)-2])
+	args := []string{}
+	for range n {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(line[1 : len(line)-2])
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, b); err != nil {
+			return nil, err
+		}
+		args = append(args, string(b[:size]))
+	}
+	return args, nil
+}
+
+func TestRedisLock(t *testing.T) {
+	f := &fakeRedis{password: "secret"}
+	addr := f.serve(t)
+	ctx := context.Background()
+	a := &RedisLock{Addr: addr, Password: "secret"}
+	b := &RedisLock{Addr: addr, Password: "secret"}
+
+	ok, err := a.TryLock(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "firewall:leader", f.key)
+
+	ok, err = b.TryLock(ctx, "b", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// b does not release the lock of a
+	require.NoError(t, b.Unlock(ctx, "b"))
+	require.NoError(t, a.Unlock(ctx, "a"))
+	ok, err = b.TryLock(ctx, "b", 20*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// b is not renewed and expires
+	time.Sleep(30 * time.Millisecond)
+	ok, err = a.TryLock(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = (&RedisLock{Addr: addr, Password: "wrong"}).TryLock(ctx, "c", time.Minute)
+	assert.ErrorContains(t, err, "WRONGPASS")
+}
+
+// fakeAPIServer serves one lease with optimistic updates.
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	lease   *lease
+	version int
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	const path = "/apis/coordination.k8s.io/v1/namespaces/fw/leases"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == path+"/leader":
+		if f.lease == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(f.lease)
+	case r.Method == http.MethodPost && r.URL.Path == path:
+		if f.lease != nil {
+			http.Error(w, "exists", http.StatusConflict)
+			return
+		}
+		f.store(w, r, http.StatusCreated)
+	case r.Method == http.MethodPut && r.URL.Path == path+"/leader":
+		var le lease
+		json.NewDecoder(r.Body).Decode(&le)
+		if f.lease == nil || le.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion {
+			http.Error(w, "conflict", http.StatusConflict)
+			return
+		}
+		f.lease = &le
+		f.version++
+		f.lease.Metadata.ResourceVersion = strconv.Itoa(f.version)
+		json.NewEncoder(w).Encode(f.lease)
+	default:
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}
+}
+
+func (f *fakeAPIServer) store(w http.ResponseWriter, r *http.Request, status int) {
+	var le lease
+	json.NewDecoder(r.Body).Decode(&le)
+	f.version++
+	le.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	f.lease = &le
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(f.lease)
+}
+
+func TestLeaseLock(t *testing.T) {
+	api := &fakeAPIServer{}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	token := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(token, []byte("token\n"), 0o600))
+	newLock := func() *LeaseLock {
+		return &LeaseLock{Namespace: "fw", Name: "leader", Server: srv.URL, TokenFile: token}
+	}
+	a, b := newLock(), newLock()
+	ctx := context.Background()
+
+	ok, err := a.TryLock(ctx, "a", 10*time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a", api.lease.Spec.HolderIdentity)
+	assert.Equal(t, 10, api.lease.Spec.LeaseDurationSeconds)
+
+	ok, err = b.TryLock(ctx, "b", 10*time.Second)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// renew
+	ok, err = a.TryLock(ctx, "a", 10*time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// the lease of a expired
+	api.mu.Lock()
+	api.lease.Spec.RenewTime = microTime(time.Now().Add(-time.Minute))
+	api.mu.Unlock()
+	ok, err = b.TryLock(ctx, "b", 10*time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, api.lease.Spec.LeaseTransitions)
+
+	// a does not release the lease of b
+	require.NoError(t, a.Unlock(ctx, "a"))
+	assert.Equal(t, "b", api.lease.Spec.HolderIdentity)
+	require.NoError(t, b.Unlock(ctx, "b"))
+	ok, err = a.TryLock(ctx, "a", 10*time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+type mockBackend struct {
+	bans   []string
+	unbans []string
+}
+
+func (m *mockBackend) Name() string { return "mock" }
+
+func (m *mockBackend) BanIP(ip string, timeoutInMinute int) {
+	m.bans = append(m.bans, ip)
+}
+
+func (m *mockBackend) TryBanIP(ip string, timeoutInMinute int) error {
+	m.bans = append(m.bans, ip)
+	return nil
+}
+
+func (m *mockBackend) UnbanIP(ip string) error {
+	m.unbans = append(m.unbans, ip)
+	return nil
+}
+
+func TestGate(t *testing.T) {
+	b := &mockBackend{}
+	g := NewGate(b)
+
+	g.BanIP("1.2.3.4", 10)
+	assert.NoError(t, g.TryBanIP("1.2.3.4", 10))
+	assert.NoError(t, g.UnbanIP("1.2.3.4"))
+	assert.Empty(t, b.bans)
+	assert.Empty(t, b.unbans)
+
+	g.SetLeader(true)
+	g.BanIP("1.2.3.5", 10)
+	assert.NoError(t, g.TryBanIP("1.2.3.6", 10))
+	assert.NoError(t, g.UnbanIP("1.2.3.5"))
+	assert.Equal(t, []string{"1.2.3.5", "1.2.3.6"}, b.bans)
+	assert.Equal(t, []string{"1.2.3.5"}, b.unbans)
+	assert.False(t, g.ExpiresBans())
+}
+
+type routingBackend struct {
+	mockBackend
+	reasons [][]string
+}
+
+func (m *routingBackend) RouteBanIP(ip string, timeoutInMinute int, reasons []string) error {
+	m.bans = append(m.bans, ip)
+	m.reasons = append(m.reasons, reasons)
+	return nil
+}
+
+func TestGate_Routes(t *testing.T) {
+	b := &routingBackend{}
+	g := NewGate(b)
+
+	assert.NoError(t, g.RouteBanIP("1.2.3.4", 10, []string{"ssh"}))
+	assert.Empty(t, b.bans)
+
+	g.SetLeader(true)
+	assert.NoError(t, g.RouteBanIP("1.2.3.5", 10, []string{"ssh"}))
+	assert.Equal(t, []string{"1.2.3.5"}, b.bans)
+	assert.Equal(t, [][]string{{"ssh"}}, b.reasons)
+}
+
+func TestGate_Actions(t *testing.T) {
+	block := &mockBackend{}
+	tarpit := &mockBackend{}
+	g := NewGate(firewall.NewActionFirewall(block, map[firewall.BanAction]firewall.IFirewall{firewall.BanActionTarpit: tarpit}))
+
+	assert.NoError(t, g.ActionBanIP("1.2.3.4", 10, firewall.BanActionTarpit, nil))
+	assert.Empty(t, tarpit.bans)
+
+	g.SetLeader(true)
+	assert.NoError(t, g.ActionBanIP("1.2.3.5", 10, firewall.BanActionTarpit, nil))
+	assert.NoError(t, g.TryBanIP("1.2.3.6", 10))
+	assert.Equal(t, []string{"1.2.3.5"}, tarpit.bans)
+	assert.Equal(t, []string{"1.2.3.6"}, block.bans)
+}
+
+type mockTarget struct {
+	restored []firewall.BanInfo
+	unbanned []string
+}
+
+func (m *mockTarget) Restore(bans []firewall.BanInfo) int {
+	m.restored = append(m.restored, bans...)
+	return len(bans)
+}
+
+func (m *mockTarget) UnbanIP(ip string, reason string) {
+	m.unbanned = append(m.unbanned, ip)
+}
+
+func TestMirror(t *testing.T) {
+	until := time.Now().Add(time.Hour).UTC()
+	var mu sync.Mutex
+	bans := map[string][]firewall.BanInfo{
+		"":     {{IP: "1.2.3.4", Until: until}, {IP: "1.2.3.5", Until: until}},
+		"shop": {{IP: "1.2.3.6", Until: until}},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "/v1/bans", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(bans[r.URL.Query().Get("tenant")])
+	}))
+	defer srv.Close()
+
+	main, shop := &mockTarget{}, &mockTarget{}
+	m := NewMirror(srv.URL+"/", "secret", map[string]Target{"": main, "shop": shop})
+	ctx := context.Background()
+
+	// the leader does not mirror
+	require.NoError(t, m.Sync(ctx))
+	assert.Empty(t, main.restored)
+
+	m.SetActive(true)
+	require.NoError(t, m.Sync(ctx))
+	assert.Len(t, main.restored, 2)
+	assert.Equal(t, "1.2.3.6", shop.restored[0].IP)
+
+	// unchanged bans are not restored again, extended and dropped bans are
+	// mirrored
+	mu.Lock()
+	bans[""] = []firewall.BanInfo{{IP: "1.2.3.4", Until: until.Add(time.Hour)}}
+	mu.Unlock()
+	require.NoError(t, m.Sync(ctx))
+	require.Len(t, main.restored, 3)
+	assert.Equal(t, until.Add(time.Hour), main.restored[2].Until)
+	assert.Equal(t, []string{"1.2.3.5"}, main.unbanned)
+	assert.Len(t, shop.restored, 1)
+
+	// a failed leader keeps the mirrored bans
+	srv.Close()
+	assert.Error(t, m.Sync(ctx))
+	assert.Equal(t, []string{"1.2.3.5"}, main.unbanned)
+}
@@ -0,0 +1,231 @@
+package ha
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// in cluster service account of a pod
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// LeaseLock is a coordination.k8s.io/v1 Lease, the lock of the Kubernetes
+// leader election. Updates are optimistic with the resourceVersion, so two
+// instances never both hold it. The service account needs get, create and
+// update on leases in Namespace.
+type LeaseLock struct {
+	Namespace string
+	Name      string
+	// Server is the api server, https://kubernetes.default.svc if empty.
+	Server string
+	// TokenFile and CAFile are those of the service account of the pod if
+	// empty.
+	TokenFile string
+	CAFile    string
+
+	once   sync.Once
+	client *http.Client
+	err    error
+}
+
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+// errConflict is a lost race with the other instance.
+var errConflict = errors.New("lease conflict")
+
+func microTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000Z07:00")
+}
+
+func (l *LeaseLock) TryLock(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	cur, err := l.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	seconds := max(int(ttl.Seconds()), 1)
+	if cur == nil {
+		err = l.write(ctx, http.MethodPost, &lease{
+			Metadata: leaseMetadata{Name: l.Name, Namespace: l.Namespace},
+			Spec: leaseSpec{
+				HolderIdentity:       id,
+				LeaseDurationSeconds: seconds,
+				AcquireTime:          microTime(now),
+				RenewTime:            microTime(now),
+			},
+		})
+	} else {
+		s := &cur.Spec
+		if s.HolderIdentity != id && s.HolderIdentity != "" {
+			renew, err := time.Parse(time.RFC3339Nano, s.RenewTime)
+			if err == nil && now.Before(renew.Add(time.Duration(s.LeaseDurationSeconds)*time.Second)) {
+				return false, nil
+			}
+		}
+		if s.HolderIdentity != id {
+			s.HolderIdentity = id
+			s.AcquireTime = microTime(now)
+			s.LeaseTransitions++
+		}
+		s.LeaseDurationSeconds = seconds
+		s.RenewTime = microTime(now)
+		err = l.write(ctx, http.MethodPut, cur)
+	}
+	if errors.Is(err, errConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *LeaseLock) Unlock(ctx context.Context, id string) error {
+	cur, err := l.get(ctx)
+	if err != nil || cur == nil || cur.Spec.HolderIdentity != id {
+		return err
+	}
+	cur.Spec.HolderIdentity = ""
+	cur.Spec.RenewTime = ""
+	err = l.write(ctx, http.MethodPut, cur)
+	if errors.Is(err, errConflict) {
+		return nil
+	}
+	return err
+}
+
+func (l *LeaseLock) url(name bool) string {
+	server := l.Server
+	if server == "" {
+		server = "https://kubernetes.default.svc"
+	}
+	u := strings.TrimSuffix(server, "/") + "/apis/coordination.k8s.io/v1/namespaces/" + l.Namespace + "/leases"
+	if name {
+		u += "/" + l.Name
+	}
+	return u
+}
+
+// get returns the lease, nil if it does not exist.
+func (l *LeaseLock) get(ctx context.Context) (*lease, error) {
+	resp, err := l.request(ctx, http.MethodGet, l.url(true), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var le lease
+		if err := json.NewDecoder(resp.Body).Decode(&le); err != nil {
+			return nil, err
+		}
+		return &le, nil
+	case http.StatusNotFound:
+		return nil, nil
+	}
+	return nil, statusError(resp)
+}
+
+// write creates the lease with POST or updates it with PUT.
+func (l *LeaseLock) write(ctx context.Context, method string, le *lease) error {
+	le.APIVersion = "coordination.k8s.io/v1"
+	le.Kind = "Lease"
+	body, err := json.Marshal(le)
+	if err != nil {
+		return err
+	}
+	resp, err := l.request(ctx, method, l.url(method == http.MethodPut), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusConflict:
+		return errConflict
+	}
+	return statusError(resp)
+}
+
+func (l *LeaseLock) request(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	l.once.Do(l.init)
+	if l.err != nil {
+		return nil, l.err
+	}
+	tokenFile := l.TokenFile
+	if tokenFile == "" {
+		tokenFile = serviceAccountToken
+	}
+	// the token is rotated by the kubelet
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return l.client.Do(req)
+}
+
+func (l *LeaseLock) init() {
+	caFile := l.CAFile
+	if caFile == "" {
+		caFile = serviceAccountCA
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if strings.HasPrefix(l.url(false), "https://") {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			l.err = err
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			l.err = fmt.Errorf("no certificate in %s", caFile)
+			return
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	l.client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+func statusError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("lease: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
@@ -0,0 +1,150 @@
+package ha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+)
+
+// DefaultMirrorInterval is how often a follower copies the jail of the
+// leader.
+const DefaultMirrorInterval = 10 * time.Second
+
+// Target is the firewall a jail is mirrored to, a *firewall.Firewall whose
+// backend is behind a closed Gate, so the mirror only writes the jail.
+type Target interface {
+	Restore(bans []firewall.BanInfo) int
+	UnbanIP(ip string, reason string)
+}
+
+// Mirror copies the jails of the leader from its admin api, GET /v1/bans,
+// to the firewalls of a follower, so the follower has them when it takes
+// over. Bans the leader drops are unbanned.
+type Mirror struct {
+	leader string
+	token  string
+	// targets by tenant, "" is the default firewall
+	targets map[string]Target
+	client  *http.Client
+
+	mu       sync.Mutex
+	active   bool
+	mirrored map[string]map[string]time.Time
+}
+
+// NewMirror returns a mirror from leaderURL, the admin address of the
+// other instance, e.g. "http://10.0.0.2:9090", authenticated by the token
+// of an operator of its admin api. It starts inactive.
+func NewMirror(leaderURL string, token string, targets map[string]Target) *Mirror {
+	return &Mirror{
+		leader:   strings.TrimSuffix(leaderURL, "/"),
+		token:    token,
+		targets:  targets,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		mirrored: map[string]map[string]time.Time{},
+	}
+}
+
+// SetActive starts mirroring on a follower and stops it on the leader.
+// The mirrored bans are then the bans of the leader, they are forgotten.
+func (m *Mirror) SetActive(active bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active = active
+	if !active {
+		clear(m.mirrored)
+	}
+}
+
+// Run syncs every interval while active until ctx is done.
+func (m *Mirror) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultMirrorInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := m.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ha: mirror failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Sync copies the jails of the leader once if active. A leader which can
+// not be reached keeps the mirrored bans.
+func (m *Mirror) Sync(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.active {
+		return nil
+	}
+	for tenant, t := range m.targets {
+		bans, err := m.fetch(ctx, tenant)
+		if err != nil {
+			return err
+		}
+
+		known := m.mirrored[tenant]
+		if known == nil {
+			known = map[string]time.Time{}
+			m.mirrored[tenant] = known
+		}
+		changed := []firewall.BanInfo{}
+		seen := map[string]bool{}
+		for _, b := range bans {
+			seen[b.IP] = true
+			if !known[b.IP].Equal(b.Until) {
+				changed = append(changed, b)
+				known[b.IP] = b.Until
+			}
+		}
+		if len(changed) > 0 {
+			t.Restore(changed)
+		}
+		for ip := range known {
+			if !seen[ip] {
+				t.UnbanIP(ip, "unbanned by the leader")
+				delete(known, ip)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Mirror) fetch(ctx context.Context, tenant string) ([]firewall.BanInfo, error) {
+	u := m.leader + "/v1/bans"
+	if tenant != "" {
+		u += "?tenant=" + url.QueryEscape(tenant)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	var bans []firewall.BanInfo
+	if err := json.NewDecoder(resp.Body).Decode(&bans); err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	return bans, nil
+}
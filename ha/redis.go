@@ -0,0 +1,128 @@
+package ha
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RedisLock is a Redis key holding the id of the leader, which expires
+// with the ttl. It speaks RESP to a single Redis server, Sentinel and
+// Cluster are not supported.
+type RedisLock struct {
+	// Addr is host:port of the server.
+	Addr     string
+	Password string
+	// Key is "firewall:leader" if empty.
+	Key string
+}
+
+// acquire or renew the key if free or held by id
+const redisTryLock = `
+local v = redis.call('GET', KEYS[1])
+if v == false or v == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+return 0`
+
+// delete the key if held by id
+const redisUnlock = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+func (l *RedisLock) key() string {
+	if l.Key == "" {
+		return "firewall:leader"
+	}
+	return l.Key
+}
+
+func (l *RedisLock) TryLock(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	r, err := l.do(ctx, "EVAL", redisTryLock, "1", l.key(), id, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	return r == int64(1), nil
+}
+
+func (l *RedisLock) Unlock(ctx context.Context, id string) error {
+	_, err := l.do(ctx, "EVAL", redisUnlock, "1", l.key(), id)
+	return err
+}
+
+// do runs the command on a new connection, the lock is renewed a few
+// times a minute.
+func (l *RedisLock) do(ctx context.Context, args ...string) (any, error) {
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", l.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	rd := bufio.NewReader(conn)
+	if l.Password != "" {
+		if _, err := redisCommand(conn, rd, "AUTH", l.Password); err != nil {
+			return nil, err
+		}
+	}
+	return redisCommand(conn, rd, args...)
+}
+
+func redisCommand(w io.Writer, rd *bufio.Reader, args ...string) (any, error) {
+	buf := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, a := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := w.Write(buf); err != nil {
+		return nil, err
+	}
+	return redisReply(rd)
+}
+
+// redisReply reads a simple string, error, integer or bulk string reply.
+func redisReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("redis: bad reply %q", line)
+	}
+	body := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, errors.New("redis: " + body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	}
+	return nil, fmt.Errorf("redis: unsupported reply %q", line)
+}
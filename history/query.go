@@ -0,0 +1,124 @@
+package history
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Offender is an ip with its bans and errors in a time window.
+type Offender struct {
+	IP       string    `json:"ip"`
+	Bans     int       `json:"bans"`
+	Errors   int       `json:"errors"`
+	Country  string    `json:"country"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// TopOffenders returns up to limit ips with the most bans, then errors, at
+// or after t.
+func (s *Store) TopOffenders(t time.Time, limit int) ([]Offender, error) {
+	rows, err := s.db.Query(`
+SELECT ip,
+	SUM(action = 'ban') AS bans,
+	SUM(action IN ('count error', 'banned')) AS errors,
+	MAX(country),
+	MAX(time)
+FROM events WHERE time >= ? AND ip != ''
+GROUP BY ip
+HAVING bans > 0 OR errors > 0
+ORDER BY bans DESC, errors DESC, ip
+LIMIT ?`, t.UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []Offender{}
+	for rows.Next() {
+		o := Offender{}
+		var last int64
+		if err := rows.Scan(&o.IP, &o.Bans, &o.Errors, &o.Country, &last); err != nil {
+			return nil, err
+		}
+		o.LastSeen = time.UnixMilli(last)
+		res = append(res, o)
+	}
+	return res, rows.Err()
+}
+
+// CountryStat is the bans of a country in a time window, Country is empty
+// for bans without geo enrichment.
+type CountryStat struct {
+	Country string `json:"country"`
+	Bans    int    `json:"bans"`
+	IPs     int    `json:"ips"`
+}
+
+// BansByCountry returns the bans at or after t by country, most bans
+// first.
+func (s *Store) BansByCountry(t time.Time) ([]CountryStat, error) {
+	rows, err := s.db.Query(`
+SELECT country, COUNT(*) AS bans, COUNT(DISTINCT ip)
+FROM events WHERE action = 'ban' AND time >= ?
+GROUP BY country
+ORDER BY bans DESC, country`, t.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []CountryStat{}
+	for rows.Next() {
+		c := CountryStat{}
+		if err := rows.Scan(&c.Country, &c.Bans, &c.IPs); err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+// Entry is an event in the history.
+type Entry struct {
+	Time          time.Time `json:"time"`
+	IP            string    `json:"ip"`
+	Action        string    `json:"action"`
+	JailUntil     time.Time `json:"jail_until,omitzero"`
+	Reasons       []string  `json:"reasons"`
+	Country       string    `json:"country,omitempty"`
+	ASNOrg        string    `json:"asn_org,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Operator      string    `json:"operator,omitempty"`
+	Ticket        string    `json:"ticket,omitempty"`
+}
+
+// Timeline returns the events of ip at or after t, oldest first.
+func (s *Store) Timeline(ip string, t time.Time) ([]Entry, error) {
+	rows, err := s.db.Query(`
+SELECT time, ip, action, jail_until, reasons, country, asn_org, correlation_id, operator, ticket
+FROM events WHERE ip = ? AND time >= ?
+ORDER BY time, id`, ip, t.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []Entry{}
+	for rows.Next() {
+		r := Entry{}
+		var tm, jailUntil int64
+		var reasons string
+		if err := rows.Scan(&tm, &r.IP, &r.Action, &jailUntil, &reasons, &r.Country, &r.ASNOrg, &r.CorrelationID, &r.Operator, &r.Ticket); err != nil {
+			return nil, err
+		}
+		r.Time = time.UnixMilli(tm)
+		if jailUntil != 0 {
+			r.JailUntil = time.Unix(jailUntil, 0)
+		}
+		if err := json.Unmarshal([]byte(reasons), &r.Reasons); err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
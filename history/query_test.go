@@ -0,0 +1,80 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+func openWithEvents(t *testing.T, now time.Time) *Store {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	nl := &ipgeo.IPGeo{Country: "NL"}
+	us := &ipgeo.IPGeo{Country: "US"}
+	events := []struct {
+		t   time.Time
+		ip  string
+		a   string
+		geo *ipgeo.IPGeo
+	}{
+		{now.Add(-48 * time.Hour), "10.0.0.9", "ban", nl},
+		{now.Add(-5 * time.Minute), "10.0.0.1", "count error", nl},
+		{now.Add(-4 * time.Minute), "10.0.0.1", "ban", nl},
+		{now.Add(-3 * time.Minute), "10.0.0.1", "banned", nil},
+		{now.Add(-3 * time.Minute), "10.0.0.2", "ban", nl},
+		{now.Add(-2 * time.Minute), "10.0.0.3", "count error", us},
+		{now.Add(-1 * time.Minute), "10.0.0.1", "ban", nl},
+		{now.Add(-1 * time.Minute), "", "resume", nil},
+	}
+	for _, e := range events {
+		require.NoError(t, s.Record(e.t, &firewall.Event{IP: e.ip, Action: e.a, Reasons: []string{"r"}, Geo: e.geo}))
+	}
+	return s
+}
+
+func TestTopOffenders(t *testing.T) {
+	now := time.Now()
+	s := openWithEvents(t, now)
+
+	got, err := s.TopOffenders(now.Add(-time.Hour), 2)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, Offender{IP: "10.0.0.1", Bans: 2, Errors: 2, Country: "NL", LastSeen: time.UnixMilli(now.Add(-time.Minute).UnixMilli())}, got[0])
+	assert.Equal(t, "10.0.0.2", got[1].IP)
+
+	got, err = s.TopOffenders(now.Add(-time.Hour), 10)
+	require.NoError(t, err)
+	assert.Len(t, got, 3)
+}
+
+func TestBansByCountry(t *testing.T) {
+	now := time.Now()
+	s := openWithEvents(t, now)
+
+	got, err := s.BansByCountry(now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []CountryStat{{Country: "NL", Bans: 3, IPs: 2}}, got)
+}
+
+func TestTimeline(t *testing.T) {
+	now := time.Now()
+	s := openWithEvents(t, now)
+
+	got, err := s.Timeline("10.0.0.1", now.Add(-time.Hour))
+	require.NoError(t, err)
+	actions := []string{}
+	for _, e := range got {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"count error", "ban", "banned", "ban"}, actions)
+	assert.Equal(t, "NL", got[0].Country)
+	assert.Equal(t, []string{"r"}, got[0].Reasons)
+}
@@ -0,0 +1,289 @@
+// Package history records firewall events in a sqlite database, so bans can
+// be queried and rolled back later.
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+var (
+	_ firewall.IEventLogger = (*Store)(nil)
+	_ firewall.IStateStore  = (*Store)(nil)
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS events (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	time       INTEGER NOT NULL,
+	ip         TEXT    NOT NULL,
+	action     TEXT    NOT NULL,
+	jail_until INTEGER NOT NULL DEFAULT 0,
+	reasons    TEXT    NOT NULL DEFAULT '[]',
+	country    TEXT    NOT NULL DEFAULT '',
+	asn_org    TEXT    NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS events_time ON events(time);
+CREATE INDEX IF NOT EXISTS events_ip ON events(ip, time);
+CREATE TABLE IF NOT EXISTS state (
+	key   TEXT PRIMARY KEY,
+	value BLOB NOT NULL
+);
+`
+
+// columns added after the first schema, they are added to existing
+// databases on Open.
+var addedColumns = []struct {
+	name string
+	def  string
+}{
+	{"correlation_id", "TEXT NOT NULL DEFAULT ''"},
+	{"labels", "TEXT NOT NULL DEFAULT '{}'"},
+	{"operator", "TEXT NOT NULL DEFAULT ''"},
+	{"ticket", "TEXT NOT NULL DEFAULT ''"},
+	{"ban_action", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// Store is a history store backed by sqlite. It is an ILogger, so it can be
+// given to firewall.New to record every event, and an IStateStore for
+// firewall.WithStateStore.
+type Store struct {
+	db *sql.DB
+}
+
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open history db failed: %w", err)
+	}
+	// sqlite allows one writer.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create history schema failed: %w", err)
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate history schema failed: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('events')`)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range addedColumns {
+		if existing[c.name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE events ADD COLUMN %s %s", c.name, c.def)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// LoadState returns the value saved for key, nil if none.
+func (s *Store) LoadState(key string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow(`SELECT value FROM state WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return value, err
+}
+
+// SaveState saves value for key, replacing the previous value.
+func (s *Store) SaveState(key string, value []byte) error {
+	_, err := s.db.Exec(`INSERT INTO state (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+func (s *Store) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	s.LogEvent(&firewall.Event{
+		IP:        ip,
+		JailUntil: jailUntil,
+		Reasons:   reasons,
+		Action:    action,
+		Geo:       geo,
+	})
+}
+
+func (s *Store) LogEvent(e *firewall.Event) {
+	if err := s.Record(time.Now(), e); err != nil {
+		log.Printf("record history failed: %v", err)
+	}
+}
+
+// Record writes e happened at t.
+func (s *Store) Record(t time.Time, e *firewall.Event) error {
+	reasons, err := json.Marshal(e.Reasons)
+	if err != nil {
+		return err
+	}
+
+	var jailUntil int64
+	if !e.JailUntil.IsZero() {
+		jailUntil = e.JailUntil.Unix()
+	}
+
+	country, asnOrg := "", ""
+	if e.Geo != nil {
+		country = e.Geo.Country
+		asnOrg = e.Geo.AutonomousSystemOrganization
+	}
+
+	labels := []byte("{}")
+	if len(e.Labels) > 0 {
+		if labels, err = json.Marshal(e.Labels); err != nil {
+			return err
+		}
+	}
+
+	operator, ticket := "", ""
+	if a := e.Annotation; a != nil {
+		operator, ticket = a.Operator, a.Ticket
+	}
+
+	_, err = s.db.Exec(`INSERT INTO events (time, ip, action, jail_until, reasons, country, asn_org, correlation_id, labels, operator, ticket, ban_action) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.UnixMilli(), e.IP, e.Action, jailUntil, string(reasons), country, asnOrg, e.CorrelationID, string(labels), operator, ticket, string(e.BanAction))
+	return err
+}
+
+// BannedSince returns the distinct ips banned at or after t, in the order of
+// their first ban.
+func (s *Store) BannedSince(t time.Time) ([]string, error) {
+	rows, err := s.db.Query(`SELECT ip FROM events WHERE action = 'ban' AND time >= ? GROUP BY ip ORDER BY MIN(time)`, t.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ips := []string{}
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+// ActionCounts returns the number of events of each action at or after t.
+func (s *Store) ActionCounts(t time.Time) (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT action, COUNT(*) FROM events WHERE time >= ? GROUP BY action`, t.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := map[string]int{}
+	for rows.Next() {
+		var action string
+		var n int
+		if err := rows.Scan(&action, &n); err != nil {
+			return nil, err
+		}
+		res[action] = n
+	}
+	return res, rows.Err()
+}
+
+// Ban is a ban in the history.
+type Ban struct {
+	Time          time.Time
+	IP            string
+	JailUntil     time.Time
+	Reasons       []string
+	CorrelationID string
+	Labels        map[string]string
+	// Operator and Ticket are set on bans by an operator, see
+	// firewall.Annotation.
+	Operator string
+	Ticket   string
+	// BanAction is how the ban is enforced, see firewall.BanAction.
+	BanAction firewall.BanAction
+}
+
+// ActiveBans returns the ips whose last ban is not expired at now and not
+// unbanned or rolled back since, in the order of the ban.
+func (s *Store) ActiveBans(now time.Time) ([]Ban, error) {
+	rows, err := s.db.Query(`
+SELECT e.time, e.ip, e.jail_until, e.reasons, e.correlation_id, e.labels, e.operator, e.ticket, e.ban_action FROM events e
+WHERE e.action = 'ban' AND e.jail_until > ? AND e.id = (
+	SELECT MAX(id) FROM events WHERE ip = e.ip AND action IN ('ban', 'unban', 'rollback')
+)
+ORDER BY e.time`, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return scanBans(rows)
+}
+
+// BansSince returns the bans at or after t, oldest first.
+func (s *Store) BansSince(t time.Time) ([]Ban, error) {
+	rows, err := s.db.Query(`SELECT time, ip, jail_until, reasons, correlation_id, labels, operator, ticket, ban_action FROM events WHERE action = 'ban' AND time >= ? ORDER BY time`, t.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	return scanBans(rows)
+}
+
+func scanBans(rows *sql.Rows) ([]Ban, error) {
+	defer rows.Close()
+
+	res := []Ban{}
+	for rows.Next() {
+		var ip, reasons, correlationID, labels, operator, ticket, action string
+		var t, jailUntil int64
+		if err := rows.Scan(&t, &ip, &jailUntil, &reasons, &correlationID, &labels, &operator, &ticket, &action); err != nil {
+			return nil, err
+		}
+
+		b := Ban{Time: time.UnixMilli(t), IP: ip, CorrelationID: correlationID, Operator: operator, Ticket: ticket, BanAction: firewall.BanAction(action)}
+		if jailUntil != 0 {
+			b.JailUntil = time.Unix(jailUntil, 0)
+		}
+		if err := json.Unmarshal([]byte(reasons), &b.Reasons); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(labels), &b.Labels); err != nil {
+			return nil, err
+		}
+		res = append(res, b)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,165 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+)
+
+func TestBannedSince(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	events := []struct {
+		t  time.Time
+		ip string
+		a  string
+	}{
+		{now.Add(-time.Hour), "10.0.0.1", "ban"},
+		{now.Add(-5 * time.Minute), "10.0.0.2", "count error"},
+		{now.Add(-4 * time.Minute), "10.0.0.3", "ban"},
+		{now.Add(-3 * time.Minute), "10.0.0.2", "ban"},
+		{now.Add(-2 * time.Minute), "10.0.0.3", "ban"},
+	}
+	for _, e := range events {
+		require.NoError(t, s.Record(e.t, &firewall.Event{IP: e.ip, Action: e.a, Reasons: []string{"r"}}))
+	}
+
+	got, err := s.BannedSince(now.Add(-10 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.3", "10.0.0.2"}, got)
+}
+
+func TestActiveBans(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	events := []struct {
+		t         time.Time
+		ip        string
+		a         string
+		jailUntil time.Time
+	}{
+		{now.Add(-2 * time.Hour), "10.0.0.1", "ban", now.Add(-time.Hour)},
+		{now.Add(-5 * time.Minute), "10.0.0.2", "ban", now.Add(time.Hour)},
+		{now.Add(-4 * time.Minute), "10.0.0.3", "ban", now.Add(time.Hour)},
+		{now.Add(-3 * time.Minute), "10.0.0.3", "rollback", time.Time{}},
+		{now.Add(-2 * time.Minute), "10.0.0.4", "ban", now.Add(time.Hour)},
+		{now.Add(-time.Minute), "10.0.0.2", "banned", time.Time{}},
+	}
+	for _, e := range events {
+		require.NoError(t, s.Record(e.t, &firewall.Event{IP: e.ip, Action: e.a, JailUntil: e.jailUntil, Reasons: []string{"r"}}))
+	}
+
+	got, err := s.ActiveBans(now)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "10.0.0.2", got[0].IP)
+	assert.Equal(t, now.Add(time.Hour).Unix(), got[0].JailUntil.Unix())
+	assert.Equal(t, []string{"r"}, got[0].Reasons)
+	assert.Equal(t, "10.0.0.4", got[1].IP)
+}
+
+func TestAnnotation(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	a := &firewall.Annotation{Operator: "alice", Ticket: "INC-1"}
+	require.NoError(t, s.Record(now, &firewall.Event{IP: "10.0.0.1", Action: "ban", JailUntil: now.Add(time.Hour), Annotation: a}))
+	require.NoError(t, s.Record(now, &firewall.Event{IP: "10.0.0.2", Action: "ban", JailUntil: now.Add(time.Hour)}))
+
+	got, err := s.ActiveBans(now)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "alice", got[0].Operator)
+	assert.Equal(t, "INC-1", got[0].Ticket)
+	assert.Empty(t, got[1].Operator)
+
+	entries, err := s.Timeline("10.0.0.1", now.Add(-time.Minute))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "alice", entries[0].Operator)
+	assert.Equal(t, "INC-1", entries[0].Ticket)
+}
+
+func TestBanAction(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	require.NoError(t, s.Record(now, &firewall.Event{IP: "10.0.0.1", Action: "ban", JailUntil: now.Add(time.Hour), BanAction: firewall.BanActionTarpit}))
+	require.NoError(t, s.Record(now, &firewall.Event{IP: "10.0.0.2", Action: "ban", JailUntil: now.Add(time.Hour)}))
+
+	got, err := s.ActiveBans(now)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, firewall.BanActionTarpit, got[0].BanAction)
+	assert.Equal(t, firewall.BanActionBlock, got[1].BanAction)
+}
+
+func TestBansSince(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	require.NoError(t, s.Record(now.Add(-time.Hour), &firewall.Event{IP: "10.0.0.1", Action: "ban"}))
+	require.NoError(t, s.Record(now.Add(-time.Minute), &firewall.Event{IP: "10.0.0.2", Action: "ban", JailUntil: now.Add(time.Hour)}))
+	require.NoError(t, s.Record(now, &firewall.Event{IP: "10.0.0.2", Action: "banned"}))
+
+	got, err := s.BansSince(now.Add(-10 * time.Minute))
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "10.0.0.2", got[0].IP)
+	assert.Equal(t, now.Add(-time.Minute).UnixMilli(), got[0].Time.UnixMilli())
+	assert.Equal(t, now.Add(time.Hour).Unix(), got[0].JailUntil.Unix())
+}
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s, err := Open(path)
+	require.NoError(t, err)
+
+	got, err := s.LoadState("k")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	require.NoError(t, s.SaveState("k", []byte("v1")))
+	require.NoError(t, s.SaveState("k", []byte("v2")))
+	require.NoError(t, s.Close())
+
+	s, err = Open(path)
+	require.NoError(t, err)
+	defer s.Close()
+	got, err = s.LoadState("k")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), got)
+}
+
+func TestActionCounts(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	require.NoError(t, s.Record(now.Add(-time.Hour), &firewall.Event{IP: "10.0.0.1", Action: "ban"}))
+	require.NoError(t, s.Record(now, &firewall.Event{IP: "10.0.0.1", Action: "ban"}))
+	require.NoError(t, s.Record(now, &firewall.Event{IP: "10.0.0.2", Action: "count error"}))
+	require.NoError(t, s.Record(now, &firewall.Event{IP: "10.0.0.2", Action: "count error"}))
+
+	got, err := s.ActionCounts(now.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"ban": 1, "count error": 2}, got)
+}
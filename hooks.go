@@ -0,0 +1,99 @@
+package firewall
+
+import "log"
+
+// hookBuffer is the number of events waiting for the hooks before events
+// are dropped.
+const hookBuffer = 256
+
+// Hooks are callbacks on the ban lifecycle, e.g. to notify an ops chat or
+// update a dashboard, without writing an ILogger. They run in order in a
+// goroutine of their own after the event is logged, so they may call the
+// Firewall. When they fall 256 events behind, the hooks of the next events
+// are dropped; the firewall never waits for a hook. Close waits for the
+// hooks queued, the events after Close run no hook. A panicking hook is
+// recovered. Events must not be modified.
+type Hooks struct {
+	// OnBan is called after an ip is banned, on "ban" and "restore" events.
+	OnBan func(e *Event)
+	// OnUnban is called after an ip is unbanned, on "unban" and "released"
+	// events.
+	OnUnban func(e *Event)
+	// OnError is called after a backend call failed, on "backend-error"
+	// events, e.Backend has the error.
+	OnError func(e *Event)
+	// OnPause is called after enforcement is paused, on "safety-valve" and
+	// "pause" events.
+	OnPause func(e *Event)
+	// OnWarning is called when the next error of an ip bans it, on
+	// "ban-warning" events of thresholds with Warn set, and when the jail
+	// grows over its capacity, on "jail-capacity" events.
+	OnWarning func(e *Event)
+}
+
+func (h *Hooks) hook(action string) func(e *Event) {
+	switch action {
+	case "ban", "restore":
+		return h.OnBan
+	case "unban", "released":
+		return h.OnUnban
+	case "backend-error":
+		return h.OnError
+	case "safety-valve", "pause":
+		return h.OnPause
+	case "ban-warning", "jail-capacity":
+		return h.OnWarning
+	}
+	return nil
+}
+
+func (h *Hooks) empty() bool {
+	return h.OnBan == nil && h.OnUnban == nil && h.OnError == nil && h.OnPause == nil && h.OnWarning == nil
+}
+
+// startHooks starts the goroutine running the hooks, if any is set.
+func (s *Firewall) startHooks() {
+	if s.hooks.empty() {
+		return
+	}
+	s.hookCh = make(chan func(), hookBuffer)
+	s.hooksDone = make(chan struct{})
+	go func() {
+		defer close(s.hooksDone)
+		for fn := range s.hookCh {
+			fn()
+		}
+	}()
+}
+
+// stopHooks lets the goroutine of the hooks exit once the queued ones ran.
+func (s *Firewall) stopHooks() {
+	if s.hookCh != nil {
+		close(s.hookCh)
+		s.hookCh = nil
+	}
+}
+
+// runHook queues the hook of e if set, it is dropped if the queue is full.
+func (s *Firewall) runHook(e *Event) {
+	fn := s.hooks.hook(e.Action)
+	if fn == nil || s.hookCh == nil {
+		return
+	}
+
+	ev := *e
+	select {
+	case s.hookCh <- func() { callHook(fn, &ev) }:
+	default:
+		log.Printf("hooks are behind, dropped the hook of %s event of %s", e.Action, e.IP)
+	}
+}
+
+func callHook(fn func(e *Event), e *Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("hook panicked on %s event of %s: %v", e.Action, e.IP, r)
+		}
+	}()
+	fn(e)
+}
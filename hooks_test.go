@@ -0,0 +1,88 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitHooks waits for the hooks queued by fw.
+func waitHooks(fw *Firewall) {
+	done := make(chan struct{})
+	fw.do(func() { fw.hookCh <- func() { close(done) } })
+	<-done
+}
+
+func TestHooks(t *testing.T) {
+	var bans, unbans, errs []*Event
+	hooks := Hooks{
+		OnBan:   func(e *Event) { bans = append(bans, e) },
+		OnUnban: func(e *Event) { unbans = append(unbans, e) },
+		OnError: func(e *Event) {
+			errs = append(errs, e)
+			panic("hook failed")
+		},
+	}
+	mockFW := &MockUnbanFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable, WithHooks(hooks))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("1.2.3.4", "r")
+	fw.LogIPError("1.2.3.4", "r")
+	fw.UnbanIP("1.2.3.4", "false positive")
+	mockLogger.Wg.Wait()
+	waitHooks(fw)
+
+	require.Len(t, bans, 1)
+	assert.Equal(t, "1.2.3.4", bans[0].IP)
+	assert.Equal(t, "ban", bans[0].Action)
+	require.Len(t, unbans, 1)
+	assert.Equal(t, []string{"false positive"}, unbans[0].Reasons)
+
+	// a panicking hook does not stop the loop
+	mockFW.Err = errors.New("down")
+	mockLogger.Wg.Add(1)
+	fw.UnbanIP("1.2.3.5", "r")
+	mockLogger.Wg.Wait()
+	waitHooks(fw)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "unban", errs[0].Backend.Op)
+
+	// Close waits for the hooks queued
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.2.3.6", 10, "r")
+	mockLogger.Wg.Wait()
+	require.NoError(t, fw.Close(context.Background()))
+	assert.Len(t, bans, 2)
+}
+
+func TestHooks_CallFirewall(t *testing.T) {
+	var fw *Firewall
+	banned := make(chan bool, 1)
+	hooks := Hooks{
+		// hooks run out of the loop, so they may call the firewall
+		OnBan: func(e *Event) {
+			b, _ := fw.IsBanned(e.IP)
+			banned <- b
+		},
+	}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, &MockIFirewall{}, mockLogger, nil, testForgivable, WithHooks(hooks))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.2.3.4", 10, "r")
+	mockLogger.Wg.Wait()
+	select {
+	case b := <-banned:
+		assert.True(t, b)
+	case <-time.After(time.Second):
+		t.Fatal("hook blocked")
+	}
+}
@@ -0,0 +1,178 @@
+// Package i18n renders firewall events and reports as human readable text
+// from message catalogs, so notifications and reports can be read in the
+// language of the ops team. Events keep their machine readable actions and
+// reason codes, only the rendered text is localized.
+//
+// A catalog maps message ids to text/template templates:
+//
+//   - "event.<action>" renders a *firewall.Event, "event.default" is used
+//     for actions without a message.
+//   - "report" renders a *Report.
+//   - "action.<action>" and "reason.<code>" are the human text of an action
+//     and a reason code, reasons without a message are shown as is.
+//   - "list.separator" joins reasons, "time.layout" formats times.
+//
+// Templates can use the functions action, reason, reasons, time and msg.
+package i18n
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+)
+
+// DefaultLanguage is the fallback of messages missing in other languages.
+const DefaultLanguage = "en"
+
+//go:embed messages/*.json
+var builtin embed.FS
+
+// Catalog is the messages of a language, keyed by message id.
+type Catalog map[string]string
+
+// Languages returns the languages with a builtin catalog.
+func Languages() []string {
+	entries, _ := builtin.ReadDir("messages")
+	res := []string{}
+	for _, e := range entries {
+		res = append(res, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	return res
+}
+
+// LoadCatalog reads a catalog from a json file.
+func LoadCatalog(path string) (Catalog, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := Catalog{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("parse catalog %s failed: %w", path, err)
+	}
+	return c, nil
+}
+
+func builtinCatalog(lang string) (Catalog, bool) {
+	b, err := builtin.ReadFile("messages/" + lang + ".json")
+	if err != nil {
+		return nil, false
+	}
+	c := Catalog{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		panic(fmt.Sprintf("builtin catalog %s: %v", lang, err))
+	}
+	return c, true
+}
+
+// Localizer renders messages of a language.
+type Localizer struct {
+	lang     string
+	messages Catalog
+	tmpl     *template.Template
+}
+
+// New returns the Localizer of lang. Messages are taken from overrides, then
+// the builtin catalog of lang, then the builtin catalog of DefaultLanguage.
+// lang without a builtin catalog requires overrides.
+func New(lang string, overrides ...Catalog) (*Localizer, error) {
+	messages, _ := builtinCatalog(DefaultLanguage)
+	if lang != DefaultLanguage {
+		c, ok := builtinCatalog(lang)
+		if !ok && len(overrides) == 0 {
+			return nil, fmt.Errorf("no catalog for language %q", lang)
+		}
+		for k, v := range c {
+			messages[k] = v
+		}
+	}
+	for _, c := range overrides {
+		for k, v := range c {
+			messages[k] = v
+		}
+	}
+
+	l := &Localizer{lang: lang, messages: messages}
+	l.tmpl = template.New(lang).Funcs(template.FuncMap{
+		"action":  l.Action,
+		"reason":  l.Reason,
+		"reasons": l.Reasons,
+		"time":    l.Time,
+		"msg":     l.msg,
+	})
+	for id, text := range messages {
+		if _, err := l.tmpl.New(id).Parse(text); err != nil {
+			return nil, fmt.Errorf("parse message %q failed: %w", id, err)
+		}
+	}
+	return l, nil
+}
+
+// Language returns the language of l.
+func (l *Localizer) Language() string {
+	return l.lang
+}
+
+func (l *Localizer) msg(id string) string {
+	return l.messages[id]
+}
+
+// Action returns the human text of an event action.
+func (l *Localizer) Action(action string) string {
+	if s, ok := l.messages["action."+action]; ok {
+		return s
+	}
+	return action
+}
+
+// Reason returns the human text of a reason code.
+func (l *Localizer) Reason(code string) string {
+	if s, ok := l.messages["reason."+code]; ok {
+		return s
+	}
+	return code
+}
+
+// Reasons returns the human text of reason codes joined by the list
+// separator.
+func (l *Localizer) Reasons(codes []string) string {
+	texts := make([]string, len(codes))
+	for i, c := range codes {
+		texts[i] = l.Reason(c)
+	}
+	return strings.Join(texts, l.messages["list.separator"])
+}
+
+// Time formats t by the time layout of the catalog.
+func (l *Localizer) Time(t time.Time) string {
+	return t.Format(l.messages["time.layout"])
+}
+
+func (l *Localizer) render(id string, data any) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := l.tmpl.ExecuteTemplate(buf, id, data); err != nil {
+		return "", fmt.Errorf("render message %q failed: %w", id, err)
+	}
+	return buf.String(), nil
+}
+
+// Event renders e, e.g. for a notification.
+func (l *Localizer) Event(e *firewall.Event) (string, error) {
+	id := "event." + e.Action
+	if _, ok := l.messages[id]; !ok {
+		id = "event.default"
+	}
+	return l.render(id, e)
+}
+
+// Report renders r.
+func (l *Localizer) Report(r *Report) (string, error) {
+	return l.render("report", r)
+}
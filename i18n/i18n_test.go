@@ -0,0 +1,113 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+func TestBuiltinCatalogs(t *testing.T) {
+	en, _ := builtinCatalog(DefaultLanguage)
+	for _, lang := range Languages() {
+		c, ok := builtinCatalog(lang)
+		require.True(t, ok)
+		for id := range en {
+			assert.Contains(t, c, id, "%s misses %s", lang, id)
+		}
+		_, err := New(lang)
+		assert.NoError(t, err)
+	}
+}
+
+func TestEvent(t *testing.T) {
+	until := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	e := &firewall.Event{
+		IP:        "1.2.3.4",
+		JailUntil: until,
+		Reasons:   []string{"invalid-password", "scanner"},
+		Action:    "ban",
+		Geo:       &ipgeo.IPGeo{Country: "NL"},
+	}
+
+	l, err := New("en", Catalog{"reason.invalid-password": "invalid password"})
+	require.NoError(t, err)
+	got, err := l.Event(e)
+	require.NoError(t, err)
+	assert.Equal(t, "Banned 1.2.3.4 (NL) until 2024-05-01 12:00:00 UTC: invalid password, scanner", got)
+
+	l, err = New("zh", Catalog{"reason.invalid-password": "密码错误"})
+	require.NoError(t, err)
+	got, err = l.Event(e)
+	require.NoError(t, err)
+	assert.Equal(t, "已封禁 1.2.3.4（NL），直到 2024-05-01 12:00:00 UTC：密码错误、scanner", got)
+
+	// reason codes are not changed.
+	assert.Equal(t, []string{"invalid-password", "scanner"}, e.Reasons)
+
+	got, err = l.Event(&firewall.Event{IP: "1.2.3.4", Action: "custom", Reasons: []string{"r"}})
+	require.NoError(t, err)
+	assert.Equal(t, "custom 1.2.3.4：r", got)
+}
+
+func TestNew_UnknownLanguage(t *testing.T) {
+	_, err := New("xx")
+	assert.Error(t, err)
+
+	// a custom catalog falls back to the default language.
+	l, err := New("xx", Catalog{"event.resume": "resumed!"})
+	require.NoError(t, err)
+	got, err := l.Event(&firewall.Event{Action: "resume"})
+	require.NoError(t, err)
+	assert.Equal(t, "resumed!", got)
+	got, err = l.Event(&firewall.Event{Action: "released", IP: "1.2.3.4"})
+	require.NoError(t, err)
+	assert.Equal(t, "Ban of 1.2.3.4 expired", got)
+}
+
+func TestNew_InvalidTemplate(t *testing.T) {
+	_, err := New("en", Catalog{"event.ban": "{{.IP"})
+	assert.Error(t, err)
+}
+
+func TestLoadCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "de.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"action.ban": "Sperre"}`), 0o600))
+
+	c, err := LoadCatalog(path)
+	require.NoError(t, err)
+	assert.Equal(t, Catalog{"action.ban": "Sperre"}, c)
+}
+
+func TestReport(t *testing.T) {
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	r := &Report{
+		Since:   since,
+		Until:   since.Add(24 * time.Hour),
+		Actions: SortedActions(map[string]int{"ban": 1, "count error": 3}),
+		Bans: []ReportBan{
+			{IP: "1.2.3.4", Time: since, JailUntil: since.Add(time.Hour), Reasons: []string{"scanner"}},
+		},
+	}
+
+	l, err := New("en")
+	require.NoError(t, err)
+	got, err := l.Report(r)
+	require.NoError(t, err)
+	assert.Equal(t, `Firewall report 2024-05-01 00:00:00 UTC - 2024-05-02 00:00:00 UTC
+  counted error: 3
+  ban: 1
+Bans:
+  1.2.3.4 until 2024-05-01 01:00:00 UTC: scanner
+`, got)
+
+	got, err = l.Report(&Report{Since: since, Until: since})
+	require.NoError(t, err)
+	assert.Equal(t, "Firewall report 2024-05-01 00:00:00 UTC - 2024-05-01 00:00:00 UTC\n  no events\n", got)
+}
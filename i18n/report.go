@@ -0,0 +1,41 @@
+package i18n
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// Report summarizes the events in a time window.
+type Report struct {
+	Since   time.Time
+	Until   time.Time
+	Actions []ActionCount
+	Bans    []ReportBan
+}
+
+// ActionCount is the number of events of an action.
+type ActionCount struct {
+	Action string
+	Count  int
+}
+
+// ReportBan is a ban in the window.
+type ReportBan struct {
+	IP        string
+	Time      time.Time
+	JailUntil time.Time
+	Reasons   []string
+}
+
+// SortedActions returns counts by action, most frequent first.
+func SortedActions(counts map[string]int) []ActionCount {
+	res := []ActionCount{}
+	for a, n := range counts {
+		res = append(res, ActionCount{Action: a, Count: n})
+	}
+	slices.SortFunc(res, func(a, b ActionCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Action, b.Action))
+	})
+	return res
+}
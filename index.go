@@ -0,0 +1,102 @@
+package firewall
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// banIndex mirrors the jail for lookups outside of the loop goroutine, e.g.
+// on every accepted connection, without a round trip to the loop. It is
+// only written by the loop goroutine.
+type banIndex struct {
+	mu  sync.RWMutex
+	ips map[string]time.Time
+	// networks are the network bans by cidr.
+	networks map[string]*networkBan
+}
+
+type networkBan struct {
+	network *net.IPNet
+	until   time.Time
+}
+
+func newBanIndex() *banIndex {
+	return &banIndex{
+		ips:      map[string]time.Time{},
+		networks: map[string]*networkBan{},
+	}
+}
+
+// set records key, an ip or a cidr, is banned until.
+func (x *banIndex) set(key string, until time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if ip := clientIP(key); ip != nil {
+		x.ips[ip.String()] = until
+		return
+	}
+	if _, n, err := net.ParseCIDR(key); err == nil {
+		x.networks[key] = &networkBan{network: n, until: until}
+	}
+}
+
+func (x *banIndex) remove(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if ip := clientIP(key); ip != nil {
+		delete(x.ips, ip.String())
+		return
+	}
+	delete(x.networks, key)
+}
+
+// banned reports whether ip, or a network containing it, is banned at now.
+func (x *banIndex) banned(ip net.IP, now time.Time) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if until, ok := x.ips[ip.String()]; ok && until.After(now) {
+		return true
+	}
+	for _, n := range x.networks {
+		if n.until.After(now) && n.network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// size returns the number of ips and networks banned.
+func (x *banIndex) size() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.ips) + len(x.networks)
+}
+
+// JailedCount returns the number of ips and networks jailed by this
+// firewall, without waiting for the loop.
+func (s *Firewall) JailedCount() int {
+	return s.index.size()
+}
+
+// IsBannedIP reports whether ip, or a network containing it, is jailed by
+// this firewall. Unlike IsBanned it does not wait for the loop, so it suits
+// hot paths like accepting connections or receiving packets.
+func (s *Firewall) IsBannedIP(ip net.IP) bool {
+	return s.index.banned(ip, s.now())
+}
+
+// addrIP returns the ip of a network address, nil if it has none.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
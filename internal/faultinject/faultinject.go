@@ -0,0 +1,160 @@
+// Package faultinject wraps backends and loggers to inject errors and
+// latency, for chaos and soak tests.
+package faultinject
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+var (
+	_ firewall.IErrorFirewall = (*Backend)(nil)
+	_ firewall.IUnbanFirewall = (*Backend)(nil)
+	_ firewall.IEventLogger   = (*Logger)(nil)
+)
+
+// ErrInjected is returned by injected failures.
+var ErrInjected = errors.New("injected fault")
+
+// Faults configures the injected faults, rates are between 0 and 1.
+type Faults struct {
+	// ErrorRate of calls failing without reaching the wrapped one.
+	ErrorRate float64
+	// PartialRate of calls reaching the wrapped one but reported as failed,
+	// e.g. a timeout after the device applied the change.
+	PartialRate float64
+	// Latency is added to every call, plus a random Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// Seed of the random source, calls are deterministic for a seed.
+	Seed uint64
+}
+
+type injector struct {
+	f   Faults
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newInjector(f Faults) *injector {
+	return &injector{
+		f:   f,
+		rnd: rand.New(rand.NewPCG(f.Seed, f.Seed)),
+	}
+}
+
+// roll returns whether the call fails before and after the wrapped call,
+// and sleeps the latency.
+func (i *injector) roll() (fail, partial bool) {
+	i.mu.Lock()
+	fail = i.rnd.Float64() < i.f.ErrorRate
+	partial = i.rnd.Float64() < i.f.PartialRate
+	delay := i.f.Latency
+	if i.f.Jitter > 0 {
+		delay += time.Duration(i.rnd.Int64N(int64(i.f.Jitter)))
+	}
+	i.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return fail, partial
+}
+
+// Backend injects faults into the calls of a backend.
+type Backend struct {
+	fw firewall.IErrorFirewall
+	in *injector
+}
+
+func NewBackend(fw firewall.IErrorFirewall, f Faults) *Backend {
+	return &Backend{fw: fw, in: newInjector(f)}
+}
+
+func (b *Backend) Name() string {
+	return b.fw.Name()
+}
+
+func (b *Backend) ExpiresBans() bool {
+	e, ok := b.fw.(firewall.IExpiringFirewall)
+	return ok && e.ExpiresBans()
+}
+
+func (b *Backend) BansNetworks() bool {
+	n, ok := b.fw.(firewall.INetworkFirewall)
+	return ok && n.BansNetworks()
+}
+
+func (b *Backend) BanIP(ip string, timeoutInMinute int) {
+	b.TryBanIP(ip, timeoutInMinute)
+}
+
+func (b *Backend) TryBanIP(ip string, timeoutInMinute int) error {
+	fail, partial := b.in.roll()
+	if fail {
+		return ErrInjected
+	}
+	if err := b.fw.TryBanIP(ip, timeoutInMinute); err != nil {
+		return err
+	}
+	if partial {
+		return ErrInjected
+	}
+	return nil
+}
+
+func (b *Backend) UnbanIP(ip string) error {
+	f, ok := b.fw.(firewall.IUnbanFirewall)
+	if !ok {
+		return errors.New("unban not supported")
+	}
+
+	fail, partial := b.in.roll()
+	if fail {
+		return ErrInjected
+	}
+	if err := f.UnbanIP(ip); err != nil {
+		return err
+	}
+	if partial {
+		return ErrInjected
+	}
+	return nil
+}
+
+// Logger injects latency into a logger and drops events at ErrorRate,
+// loggers have no error to return.
+type Logger struct {
+	l  firewall.ILogger
+	in *injector
+}
+
+func NewLogger(l firewall.ILogger, f Faults) *Logger {
+	return &Logger{l: l, in: newInjector(f)}
+}
+
+func (l *Logger) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	l.LogEvent(&firewall.Event{
+		IP:        ip,
+		JailUntil: jailUntil,
+		Reasons:   reasons,
+		Action:    action,
+		Geo:       geo,
+	})
+}
+
+func (l *Logger) LogEvent(e *firewall.Event) {
+	if fail, _ := l.in.roll(); fail {
+		return
+	}
+	if el, ok := l.l.(firewall.IEventLogger); ok {
+		el.LogEvent(e)
+		return
+	}
+	l.l.Log(e.IP, e.JailUntil, e.Reasons, e.Action, e.Geo)
+}
@@ -0,0 +1,54 @@
+package faultinject
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockBackend struct {
+	banned []string
+}
+
+func (m *mockBackend) Name() string {
+	return "mock"
+}
+
+func (m *mockBackend) BanIP(ip string, timeoutInMinute int) {
+	m.TryBanIP(ip, timeoutInMinute)
+}
+
+func (m *mockBackend) TryBanIP(ip string, timeoutInMinute int) error {
+	m.banned = append(m.banned, ip)
+	return nil
+}
+
+func TestBackend(t *testing.T) {
+	t.Run("error", func(t *testing.T) {
+		m := &mockBackend{}
+		b := NewBackend(m, Faults{ErrorRate: 1})
+		assert.True(t, errors.Is(b.TryBanIP("10.0.0.1", 1), ErrInjected))
+		assert.Empty(t, m.banned)
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		m := &mockBackend{}
+		b := NewBackend(m, Faults{PartialRate: 1})
+		assert.True(t, errors.Is(b.TryBanIP("10.0.0.1", 1), ErrInjected))
+		assert.Equal(t, []string{"10.0.0.1"}, m.banned)
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		m := &mockBackend{}
+		b := NewBackend(m, Faults{ErrorRate: 0.3, Seed: 1})
+		failed := 0
+		for i := 0; i < 1000; i++ {
+			if b.TryBanIP("10.0.0.1", 1) != nil {
+				failed++
+			}
+		}
+		assert.InDelta(t, 300, failed, 60)
+		assert.Equal(t, 1000-failed, len(m.banned))
+	})
+}
@@ -0,0 +1,74 @@
+// Package httpapi has the request and response helpers shared by the http
+// apis of the daemon and package adminapi.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/charleshuang3/firewall"
+)
+
+// MaxBodySize bounds the json body of requests.
+const MaxBodySize = 64 << 10
+
+// Decode decodes the json body of r into v, it writes 400 if the body is
+// invalid.
+func Decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// DecodeOptional is Decode for requests whose fields are all optional, an
+// empty body leaves v unchanged.
+func DecodeOptional(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// ValidIP normalizes *ip, e.g. "::ffff:1.2.3.4" to "1.2.3.4", and rejects
+// the request if it is not an ip.
+func ValidIP(w http.ResponseWriter, ip *string) bool {
+	n, ok := firewall.NormalizeIP(*ip)
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid ip %q", *ip), http.StatusBadRequest)
+		return false
+	}
+	*ip = n
+	return true
+}
+
+// ValidIPOrNetwork is ValidIP accepting the cidr of a network ban too, in
+// the form it is jailed with.
+func ValidIPOrNetwork(w http.ResponseWriter, ip *string) bool {
+	addr, n, err := net.ParseCIDR(*ip)
+	if err != nil {
+		return ValidIP(w, ip)
+	}
+	if ones, bits := n.Mask.Size(); ones == bits {
+		*ip = addr.String()
+		return ValidIP(w, ip)
+	}
+	*ip = n.String()
+	return true
+}
+
+// WriteJSON writes v as indented json.
+func WriteJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
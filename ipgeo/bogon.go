@@ -0,0 +1,60 @@
+package ipgeo
+
+import "net/netip"
+
+// bogons are the reserved and special purpose ranges of RFC 6890 and its
+// updates, never routed on the internet.
+var bogons = func() []netip.Prefix {
+	res := []netip.Prefix{}
+	for _, s := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.0.0.0/24",
+		"192.0.2.0/24",
+		"192.168.0.0/16",
+		"198.18.0.0/15",
+		"198.51.100.0/24",
+		"203.0.113.0/24",
+		"224.0.0.0/4",
+		"240.0.0.0/4",
+		"::/128",
+		"::1/128",
+		"100::/64",
+		"2001:2::/48",
+		"2001:10::/28",
+		"2001:db8::/32",
+		"3fff::/20",
+		"fc00::/7",
+		"fe80::/10",
+		"fec0::/10",
+		"ff00::/8",
+	} {
+		res = append(res, netip.MustParsePrefix(s))
+	}
+	return res
+}()
+
+// globalUnicast is the only ipv6 range allocated by IANA to the RIRs,
+// addresses out of it are unallocated.
+var globalUnicast = netip.MustParsePrefix("2000::/3")
+
+// IsBogon reports whether ip is in a reserved or unallocated range, e.g. a
+// private, documentation or multicast address. Such a source address on the
+// internet is spoofed or misconfigured. An invalid ip is not a bogon.
+func IsBogon(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range bogons {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return addr.Is6() && !globalUnicast.Contains(addr)
+}
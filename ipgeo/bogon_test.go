@@ -0,0 +1,16 @@
+package ipgeo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBogon(t *testing.T) {
+	for _, ip := range []string{"10.1.2.3", "127.0.0.1", "100.64.0.1", "192.0.2.1", "240.0.0.1", "::1", "fe80::1", "2001:db8::1", "::ffff:192.168.1.1", "4000::1"} {
+		assert.True(t, IsBogon(ip), ip)
+	}
+	for _, ip := range []string{"8.8.8.8", "81.2.69.160", "2001:4860::8888", "not an ip"} {
+		assert.False(t, IsBogon(ip), ip)
+	}
+}
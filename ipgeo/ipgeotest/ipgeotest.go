@@ -0,0 +1,168 @@
+// Package ipgeotest builds small maxmind databases from geo data, so
+// policies depending on country or ASN can be tested hermetically against
+// the real lookup code:
+//
+//	city, asn, err := ipgeotest.Write(t.TempDir(), map[string]*ipgeo.IPGeo{
+//		"192.0.2.0/24": {CountryISO: "KP", Country: "North Korea"},
+//		"198.51.100.7": {AutonomousSystemNumber: 64496, AutonomousSystemOrganization: "Hosting"},
+//	})
+//	db, err := ipgeo.NewAutoUpdateMMIPGeo(city, city, asn, asn)
+package ipgeotest
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+const (
+	cityDBType = "GeoLite2-City"
+	asnDBType  = "GeoLite2-ASN"
+)
+
+// Write writes a city and an ASN database of records, by ip or cidr, to
+// dir and returns their files. Names are written in English.
+func Write(dir string, records map[string]*ipgeo.IPGeo) (cityFile string, asnFile string, err error) {
+	cityFile = filepath.Join(dir, cityDBType+".mmdb")
+	if err := WriteCityDB(cityFile, records); err != nil {
+		return "", "", err
+	}
+	asnFile = filepath.Join(dir, asnDBType+".mmdb")
+	if err := WriteASNDB(asnFile, records); err != nil {
+		return "", "", err
+	}
+	return cityFile, asnFile, nil
+}
+
+// WriteCityDB writes the city, subdivision, country and traits of records
+// to a city database at file.
+func WriteCityDB(file string, records map[string]*ipgeo.IPGeo) error {
+	return write(file, cityDBType, records, cityRecord)
+}
+
+// WriteASNDB writes the autonomous systems of records to an ASN database
+// at file.
+func WriteASNDB(file string, records map[string]*ipgeo.IPGeo) error {
+	return write(file, asnDBType, records, asnRecord)
+}
+
+func write(file, dbType string, records map[string]*ipgeo.IPGeo, record func(*ipgeo.IPGeo) mmdbtype.Map) error {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType:            dbType,
+		IncludeReservedNetworks: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	// insert wider networks first, so records of ips in them are kept
+	nets := map[string]*net.IPNet{}
+	keys := []string{}
+	for key := range records {
+		n, err := network(key)
+		if err != nil {
+			return err
+		}
+		nets[key] = n
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		ao, _ := nets[a].Mask.Size()
+		bo, _ := nets[b].Mask.Size()
+		return cmp.Or(ao-bo, strings.Compare(a, b))
+	})
+
+	for _, key := range keys {
+		n, g := nets[key], records[key]
+		r := record(g)
+		if len(r) == 0 {
+			continue
+		}
+		if err := tree.Insert(n, r); err != nil {
+			return fmt.Errorf("insert %s failed: %w", key, err)
+		}
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := tree.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// network parses an ip or a cidr.
+func network(key string) (*net.IPNet, error) {
+	if _, n, err := net.ParseCIDR(key); err == nil {
+		return n, nil
+	}
+	ip := net.ParseIP(key)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an ip or cidr", key)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func names(name string) mmdbtype.Map {
+	return mmdbtype.Map{"en": mmdbtype.String(name)}
+}
+
+func cityRecord(g *ipgeo.IPGeo) mmdbtype.Map {
+	r := mmdbtype.Map{}
+	if g.City != "" {
+		r["city"] = mmdbtype.Map{"names": names(g.City)}
+	}
+	if g.Country != "" || g.CountryISO != "" {
+		country := mmdbtype.Map{"iso_code": mmdbtype.String(g.CountryISO)}
+		if g.Country != "" {
+			country["names"] = names(g.Country)
+		}
+		r["country"] = country
+	}
+	if g.Subdivision != "" {
+		// IPGeo lists the subdivisions from the smallest
+		parts := strings.Split(g.Subdivision, "/")
+		slices.Reverse(parts)
+		subdivisions := mmdbtype.Slice{}
+		for _, s := range parts {
+			subdivisions = append(subdivisions, mmdbtype.Map{"names": names(s)})
+		}
+		r["subdivisions"] = subdivisions
+	}
+	traits := mmdbtype.Map{}
+	if g.Proxy {
+		traits["is_anonymous_proxy"] = mmdbtype.Bool(true)
+	}
+	if g.Satellite {
+		traits["is_satellite_provider"] = mmdbtype.Bool(true)
+	}
+	if len(traits) > 0 {
+		r["traits"] = traits
+	}
+	return r
+}
+
+func asnRecord(g *ipgeo.IPGeo) mmdbtype.Map {
+	if g.AutonomousSystemNumber == 0 {
+		return nil
+	}
+	return mmdbtype.Map{
+		"autonomous_system_number":       mmdbtype.Uint32(g.AutonomousSystemNumber),
+		"autonomous_system_organization": mmdbtype.String(g.AutonomousSystemOrganization),
+	}
+}
@@ -0,0 +1,46 @@
+package ipgeotest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+func TestWrite(t *testing.T) {
+	city, asn, err := Write(t.TempDir(), map[string]*ipgeo.IPGeo{
+		"192.0.2.0/24":  {CountryISO: "KP", Country: "North Korea", City: "Pyongyang", Subdivision: "Central/Pyongyang"},
+		"192.0.2.7":     {CountryISO: "KP", Country: "North Korea", Proxy: true, AutonomousSystemNumber: 64496, AutonomousSystemOrganization: "Hosting"},
+		"2001:db8::/32": {CountryISO: "DE", Country: "Germany", Satellite: true},
+	})
+	require.NoError(t, err)
+
+	db, err := ipgeo.NewMMIPGeo(city, asn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, &ipgeo.IPGeo{
+		IP:          "192.0.2.1",
+		City:        "Pyongyang",
+		Subdivision: "Central/Pyongyang",
+		Country:     "North Korea",
+		CountryISO:  "KP",
+		Bogon:       true,
+	}, db.GetIPGeo("192.0.2.1"))
+
+	g := db.GetIPGeo("192.0.2.7")
+	assert.True(t, g.Proxy)
+	assert.Equal(t, uint(64496), g.AutonomousSystemNumber)
+	assert.Equal(t, "Hosting", g.AutonomousSystemOrganization)
+
+	g = db.GetIPGeo("2001:db8::1")
+	assert.Equal(t, "DE", g.CountryISO)
+	assert.True(t, g.Satellite)
+
+	assert.Equal(t, &ipgeo.IPGeo{IP: "198.51.100.1", Bogon: true}, db.GetIPGeo("198.51.100.1"))
+
+	_, _, err = Write(t.TempDir(), map[string]*ipgeo.IPGeo{"not an ip": {}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package ipgeo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// DBMetadata describes a loaded mmdb database.
+type DBMetadata struct {
+	// Edition is the database type, e.g. "GeoLite2-City".
+	Edition   string    `json:"edition"`
+	BuildTime time.Time `json:"build_time"`
+}
+
+// Metadata of the databases in use.
+type Metadata struct {
+	City DBMetadata `json:"city"`
+	ASN  DBMetadata `json:"asn"`
+}
+
+func (m Metadata) String() string {
+	return fmt.Sprintf("city %s, asn %s", m.City, m.ASN)
+}
+
+func (m DBMetadata) String() string {
+	if m.Edition == "" {
+		return "none"
+	}
+	return fmt.Sprintf("%s built %s", m.Edition, m.BuildTime.Format(time.DateOnly))
+}
+
+func readerMetadata(r *geoip2.Reader) DBMetadata {
+	if r == nil {
+		return DBMetadata{}
+	}
+	m := r.Metadata()
+	return DBMetadata{
+		Edition:   m.DatabaseType,
+		BuildTime: time.Unix(int64(m.BuildEpoch), 0).UTC(),
+	}
+}
+
+// Metadata returns edition and build time of the databases.
+func (mm *MMIPGeo) Metadata() Metadata {
+	return Metadata{
+		City: readerMetadata(mm.cityDB),
+		ASN:  readerMetadata(mm.asnDB),
+	}
+}
+
+// Metadata returns edition and build time of the databases in use.
+func (db *AutoUpdateMMIPGeo) Metadata() Metadata {
+	mm := db.mm.Load()
+	if mm == nil {
+		return Metadata{}
+	}
+	return mm.Metadata()
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/oschwald/geoip2-golang"
@@ -15,20 +17,100 @@ import (
 
 const (
 	checkUpdateInterval = 1 * time.Hour
+
+	// defaultVerifyIP is in both GeoLite2 City and ASN database.
+	defaultVerifyIP = "8.8.8.8"
+
+	// closeReaderDelay is how long a replaced reader is kept open for the
+	// lookups still using it.
+	closeReaderDelay = time.Minute
+
+	defaultNegativeCacheTTL  = 10 * time.Minute
+	defaultNegativeCacheSize = 10000
 )
 
-// AutoUpdateMMIPGeo checks if database should update on GetIPGeo(). It is not locked, don't use on mutli-threading.
+// AutoUpdateMMIPGeo checks if database should update in background every
+// hour. GetIPGeo only reads the current database, it is safe for concurrent
+// use.
 type AutoUpdateMMIPGeo struct {
 	cityDBFile        string
 	updatedCityDBFile string
 	asnDBFile         string
 	updatedASNDBFile  string
-	mm                *MMIPGeo
-	lastCheck         time.Time
+	mm                atomic.Pointer[MMIPGeo]
+	stop              chan struct{}
+	// done is closed when the loop exits.
+	done      chan struct{}
+	closeOnce sync.Once
+	stats     *stats
+	// notFound caches the ips without a record, they are looked up again
+	// on every event otherwise.
+	notFound *negativeCache
+
+	opts []Option
+	o    *options
+}
+
+type options struct {
+	// verifyCityIP and verifyASNIP must be found in an updated database
+	// before it replaces the current one.
+	verifyCityIP string
+	verifyASNIP  string
+	// language of names, fallback to English.
+	language string
+	// negativeTTL and negativeSize bound the cache of ips not found.
+	negativeTTL  time.Duration
+	negativeSize int
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		verifyCityIP: defaultVerifyIP,
+		verifyASNIP:  defaultVerifyIP,
+		language:     "en",
+		negativeTTL:  defaultNegativeCacheTTL,
+		negativeSize: defaultNegativeCacheSize,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Option configures MMIPGeo and AutoUpdateMMIPGeo.
+type Option func(*options)
+
+// WithVerifyIPs sets the ips an updated database must have a record for,
+// default to 8.8.8.8. It only applies to AutoUpdateMMIPGeo.
+func WithVerifyIPs(cityIP, asnIP string) Option {
+	return func(o *options) {
+		o.verifyCityIP = cityIP
+		o.verifyASNIP = asnIP
+	}
 }
 
-func NewAutoUpdateMMIPGeo(cityDBFile, updatedCityDBFile, asnDBFile, updatedASNDBFile string) (*AutoUpdateMMIPGeo, error) {
-	mm, err := NewMMIPGeo(cityDBFile, asnDBFile)
+// WithNegativeCache caches up to size ips not found in the databases for
+// ttl, default to 10000 ips for 10 minutes. A zero ttl or size disables the
+// cache. The cache is cleared when the databases update. It only applies
+// to AutoUpdateMMIPGeo.
+func WithNegativeCache(ttl time.Duration, size int) Option {
+	return func(o *options) {
+		o.negativeTTL = ttl
+		o.negativeSize = size
+	}
+}
+
+// WithLanguage selects the language of city, subdivision and country
+// names, e.g. "de", "zh-CN". Names missing in the language fallback to
+// English.
+func WithLanguage(lang string) Option {
+	return func(o *options) {
+		o.language = lang
+	}
+}
+
+func NewAutoUpdateMMIPGeo(cityDBFile, updatedCityDBFile, asnDBFile, updatedASNDBFile string, opts ...Option) (*AutoUpdateMMIPGeo, error) {
+	mm, err := NewMMIPGeo(cityDBFile, asnDBFile, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -37,15 +119,50 @@ func NewAutoUpdateMMIPGeo(cityDBFile, updatedCityDBFile, asnDBFile, updatedASNDB
 		updatedCityDBFile: updatedCityDBFile,
 		asnDBFile:         asnDBFile,
 		updatedASNDBFile:  updatedASNDBFile,
-		mm:                mm,
-		lastCheck:         time.Time{},
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+		stats:             newStats(),
+		opts:              opts,
+		o:                 newOptions(opts),
 	}
+	db.notFound = newNegativeCache(db.o.negativeTTL, db.o.negativeSize)
+	db.mm.Store(mm)
 
 	db.update()
+	log.Printf("Geo db loaded: %s", db.Metadata())
+
+	go db.loop()
 
 	return db, nil
 }
 
+func (db *AutoUpdateMMIPGeo) loop() {
+	defer close(db.done)
+	t := time.NewTicker(checkUpdateInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			db.update()
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background update and closes the database once the
+// update in progress, if any, is done. Closing again does nothing.
+func (db *AutoUpdateMMIPGeo) Close() {
+	db.closeOnce.Do(func() {
+		close(db.stop)
+		<-db.done
+		if mm := db.mm.Swap(nil); mm != nil {
+			mm.Close()
+		}
+	})
+}
+
 // isFileUpdated compares 2 file last modify date and size
 func isFileUpdated(currentFile, latestFile string) (bool, os.FileInfo, error) {
 	currentStat, err := os.Stat(currentFile)
@@ -91,24 +208,27 @@ func copy(src, dst string, srcStat os.FileInfo) error {
 	return err
 }
 
+// update swaps in the updated database files if they changed. It is only
+// called from the loop goroutine, except in New.
 func (db *AutoUpdateMMIPGeo) update() {
-	// Too early to check
-	if time.Since(db.lastCheck) < checkUpdateInterval {
-		return
-	}
-
-	db.lastCheck = time.Now()
-
-	cityDBUpdated, updatedCityDBStat, err := isFileUpdated(db.cityDBFile, db.updatedCityDBFile)
-	if err != nil {
-		log.Printf("Check city db update failed: %v", err)
-		return
+	var cityDBUpdated, asnDBUpdated bool
+	var updatedCityDBStat, updatedASNDBStat os.FileInfo
+	var err error
+
+	if db.cityDBFile != "" {
+		cityDBUpdated, updatedCityDBStat, err = isFileUpdated(db.cityDBFile, db.updatedCityDBFile)
+		if err != nil {
+			log.Printf("Check city db update failed: %v", err)
+			return
+		}
 	}
 
-	asnDBUpdated, updatedASNDBStat, err := isFileUpdated(db.asnDBFile, db.updatedASNDBFile)
-	if err != nil {
-		log.Printf("Check asn db update failed: %v", err)
-		return
+	if db.asnDBFile != "" {
+		asnDBUpdated, updatedASNDBStat, err = isFileUpdated(db.asnDBFile, db.updatedASNDBFile)
+		if err != nil {
+			log.Printf("Check asn db update failed: %v", err)
+			return
+		}
 	}
 
 	// No need to update
@@ -116,61 +236,139 @@ func (db *AutoUpdateMMIPGeo) update() {
 		return
 	}
 
-	db.mm.Close()
+	// Copy updated files next to the current ones, and only replace the
+	// current ones after the new database is verified.
+	cityDB, asnDB := db.cityDBFile, db.asnDBFile
+	tmpFiles := []string{}
+	defer func() {
+		for _, f := range tmpFiles {
+			os.Remove(f)
+		}
+	}()
 
 	if cityDBUpdated {
-		if err := copy(db.updatedCityDBFile, db.cityDBFile, updatedCityDBStat); err != nil {
+		cityDB = db.cityDBFile + ".new"
+		tmpFiles = append(tmpFiles, cityDB)
+		if err := copy(db.updatedCityDBFile, cityDB, updatedCityDBStat); err != nil {
 			log.Printf("Copy city db failed: %v", err)
+			db.stats.updated(false)
 			return
 		}
 	}
 
 	if asnDBUpdated {
-		if err := copy(db.updatedASNDBFile, db.asnDBFile, updatedASNDBStat); err != nil {
+		asnDB = db.asnDBFile + ".new"
+		tmpFiles = append(tmpFiles, asnDB)
+		if err := copy(db.updatedASNDBFile, asnDB, updatedASNDBStat); err != nil {
 			log.Printf("Copy asn db failed: %v", err)
+			db.stats.updated(false)
 			return
 		}
 	}
 
-	db.mm, err = NewMMIPGeo(db.cityDBFile, db.asnDBFile)
+	mm, err := NewMMIPGeo(cityDB, asnDB, db.opts...)
 	if err != nil {
-		log.Printf("NewMMIPGeo failed: %v", err)
+		log.Printf("db update rejected: %v", err)
+		db.stats.updated(false)
+		return
+	}
+
+	if err := mm.verify(db.o.verifyCityIP, db.o.verifyASNIP); err != nil {
+		mm.Close()
+		log.Printf("db update rejected: %v", err)
+		db.stats.updated(false)
+		return
+	}
+
+	// mmdb is mmaped, renaming the file keeps the reader valid.
+	if cityDBUpdated {
+		if err := os.Rename(cityDB, db.cityDBFile); err != nil {
+			mm.Close()
+			log.Printf("db update rejected: %v", err)
+			db.stats.updated(false)
+			return
+		}
+	}
+	if asnDBUpdated {
+		if err := os.Rename(asnDB, db.asnDBFile); err != nil {
+			mm.Close()
+			log.Printf("db update rejected: %v", err)
+			db.stats.updated(false)
+			return
+		}
 	}
+
+	db.stats.updated(true)
+	log.Printf("Geo db updated: %s", mm.Metadata())
+	if old := db.mm.Swap(mm); old != nil {
+		time.AfterFunc(closeReaderDelay, old.Close)
+	}
+	// new allocations may be in the updated databases
+	db.notFound.clear()
 }
 
 func (db *AutoUpdateMMIPGeo) GetIPGeo(ip string) *IPGeo {
-	db.update()
-
-	if db.mm == nil {
+	mm := db.mm.Load()
+	if mm == nil {
 		log.Printf("db.mm is nil")
 		return &IPGeo{
 			IP: ip,
 		}
 	}
 
-	return db.mm.GetIPGeo(ip)
+	if db.notFound.has(ip) {
+		db.stats.negativeCacheHits.Add(1)
+		return &IPGeo{
+			IP:    ip,
+			Bogon: IsBogon(ip),
+		}
+	}
+
+	start := time.Now()
+	res := mm.GetIPGeo(ip)
+	found := res.found()
+	db.stats.observeLookup(time.Since(start), found)
+	if !found {
+		db.notFound.add(ip)
+	}
+	return res
 }
 
+// MMIPGeo looks up ip in maxmind databases. Either database can be absent,
+// fields from the absent database are left empty.
 type MMIPGeo struct {
-	cityDB *geoip2.Reader
-	asnDB  *geoip2.Reader
+	cityDB   *geoip2.Reader
+	asnDB    *geoip2.Reader
+	language string
 }
 
-func NewMMIPGeo(cityDBFile, asnDBFile string) (*MMIPGeo, error) {
-	cityDB, err := geoip2.Open(cityDBFile)
-	if err != nil {
-		return nil, err
+// NewMMIPGeo opens the databases, an empty file name skips that database.
+func NewMMIPGeo(cityDBFile, asnDBFile string, opts ...Option) (*MMIPGeo, error) {
+	if cityDBFile == "" && asnDBFile == "" {
+		return nil, fmt.Errorf("no city or asn db")
 	}
 
-	asnDB, err := geoip2.Open(asnDBFile)
-	if err != nil {
-		return nil, err
+	mm := &MMIPGeo{
+		language: newOptions(opts).language,
+	}
+	var err error
+
+	if cityDBFile != "" {
+		mm.cityDB, err = geoip2.Open(cityDBFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &MMIPGeo{
-		cityDB: cityDB,
-		asnDB:  asnDB,
-	}, nil
+	if asnDBFile != "" {
+		mm.asnDB, err = geoip2.Open(asnDBFile)
+		if err != nil {
+			mm.Close()
+			return nil, err
+		}
+	}
+
+	return mm, nil
 }
 
 type IPGeo struct {
@@ -178,40 +376,110 @@ type IPGeo struct {
 	City                         string `json:"city"`
 	Subdivision                  string `json:"subdivision"`
 	Country                      string `json:"country"`
+	CountryISO                   string `json:"country_iso"`
 	Proxy                        bool   `json:"proxy"`
 	Anycast                      bool   `json:"anycast"`
 	Satellite                    bool   `json:"satellite"`
+	AutonomousSystemNumber       uint   `json:"autonomous_system_number"`
 	AutonomousSystemOrganization string `json:"autonomous_system_organization"`
+	// Bogon is set for reserved and unallocated ips, see IsBogon.
+	Bogon bool `json:"bogon"`
+}
+
+// found returns whether any database has a record for the ip.
+func (g *IPGeo) found() bool {
+	return g.Country != "" || g.AutonomousSystemOrganization != ""
 }
 
 func (mm *MMIPGeo) GetIPGeo(ip string) *IPGeo {
 	res := &IPGeo{
-		IP: ip,
+		IP:    ip,
+		Bogon: IsBogon(ip),
 	}
 
 	ipAddr := net.ParseIP(ip)
+	if mm.cityDB != nil {
+		mm.fillCity(res, ipAddr)
+	}
+	if mm.asnDB != nil {
+		mm.fillASN(res, ipAddr)
+	}
+
+	return res
+}
+
+func (mm *MMIPGeo) fillCity(res *IPGeo, ipAddr net.IP) {
 	if city, _ := mm.cityDB.City(ipAddr); city != nil {
-		res.City = city.City.Names["en"]
-		res.Country = city.Country.Names["en"]
+		res.City = mm.name(city.City.Names)
+		res.Country = mm.name(city.Country.Names)
+		res.CountryISO = city.Country.IsoCode
 		res.Proxy = city.Traits.IsAnonymousProxy
 		res.Anycast = city.Traits.IsAnonymousProxy
 		res.Satellite = city.Traits.IsSatelliteProvider
 
 		subdivision := []string{}
 		for _, s := range city.Subdivisions {
-			subdivision = append(subdivision, s.Names["en"])
+			subdivision = append(subdivision, mm.name(s.Names))
 		}
 		slices.Reverse(subdivision)
 		res.Subdivision = strings.Join(subdivision, "/")
 	}
+}
+
+// name returns the name in the selected language, fallback to English.
+func (mm *MMIPGeo) name(names map[string]string) string {
+	if n, ok := names[mm.language]; ok {
+		return n
+	}
+	return names["en"]
+}
+
+func (mm *MMIPGeo) fillASN(res *IPGeo, ipAddr net.IP) {
 	if asn, _ := mm.asnDB.ASN(ipAddr); asn != nil {
+		res.AutonomousSystemNumber = asn.AutonomousSystemNumber
 		res.AutonomousSystemOrganization = asn.AutonomousSystemOrganization
 	}
+}
 
-	return res
+// verify checks the databases are the expected type and have records for
+// the given ips.
+func (mm *MMIPGeo) verify(cityIP, asnIP string) error {
+	if mm.cityDB != nil {
+		if t := mm.cityDB.Metadata().DatabaseType; !strings.Contains(t, "City") {
+			return fmt.Errorf("city db has unexpected type %q", t)
+		}
+
+		city, err := mm.cityDB.City(net.ParseIP(cityIP))
+		if err != nil {
+			return fmt.Errorf("lookup %s in city db failed: %w", cityIP, err)
+		}
+		if city.Country.IsoCode == "" {
+			return fmt.Errorf("%s not found in city db", cityIP)
+		}
+	}
+
+	if mm.asnDB != nil {
+		if t := mm.asnDB.Metadata().DatabaseType; !strings.Contains(t, "ASN") {
+			return fmt.Errorf("asn db has unexpected type %q", t)
+		}
+
+		asn, err := mm.asnDB.ASN(net.ParseIP(asnIP))
+		if err != nil {
+			return fmt.Errorf("lookup %s in asn db failed: %w", asnIP, err)
+		}
+		if asn.AutonomousSystemNumber == 0 {
+			return fmt.Errorf("%s not found in asn db", asnIP)
+		}
+	}
+
+	return nil
 }
 
 func (mm *MMIPGeo) Close() {
-	mm.cityDB.Close()
-	mm.asnDB.Close()
+	if mm.cityDB != nil {
+		mm.cityDB.Close()
+	}
+	if mm.asnDB != nil {
+		mm.asnDB.Close()
+	}
 }
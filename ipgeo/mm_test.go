@@ -13,6 +13,9 @@ import (
 const (
 	asnDBFile  = "test-data/GeoLite2-ASN-Test.mmdb"
 	cityDBFile = "test-data/GeoLite2-City-Test.mmdb"
+
+	testCityIP = "81.2.69.160"
+	testASNIP  = "1.0.0.1"
 )
 
 func TestGetIPGeo(t *testing.T) {
@@ -24,6 +27,7 @@ func TestGetIPGeo(t *testing.T) {
 		City:                         "London",
 		Subdivision:                  "England",
 		Country:                      "United Kingdom",
+		CountryISO:                   "GB",
 		Proxy:                        false,
 		Anycast:                      false,
 		Satellite:                    false,
@@ -33,6 +37,59 @@ func TestGetIPGeo(t *testing.T) {
 	assert.Equal(t, want, got)
 }
 
+func TestGetIPGeo_Language(t *testing.T) {
+	db, err := NewMMIPGeo(cityDBFile, asnDBFile, WithLanguage("de"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	got := db.GetIPGeo("81.2.69.160")
+	assert.Equal(t, "Vereinigtes Königreich", got.Country)
+	assert.Equal(t, "GB", got.CountryISO)
+	// no German name in test data, fallback to English
+	assert.Equal(t, "England", got.Subdivision)
+}
+
+func TestGetIPGeo_SingleDB(t *testing.T) {
+	t.Run("city only", func(t *testing.T) {
+		db, err := NewMMIPGeo(cityDBFile, "")
+		require.NoError(t, err)
+		defer db.Close()
+
+		got := db.GetIPGeo("81.2.69.160")
+		assert.Equal(t, "London", got.City)
+		assert.Empty(t, got.AutonomousSystemOrganization)
+		assert.Empty(t, db.Metadata().ASN.Edition)
+	})
+
+	t.Run("asn only", func(t *testing.T) {
+		db, err := NewMMIPGeo("", asnDBFile)
+		require.NoError(t, err)
+		defer db.Close()
+
+		got := db.GetIPGeo("1.0.0.1")
+		assert.Equal(t, "Google Inc.", got.AutonomousSystemOrganization)
+		assert.Equal(t, uint(15169), got.AutonomousSystemNumber)
+		assert.Empty(t, got.City)
+		assert.NoError(t, db.verify("", testASNIP))
+	})
+
+	t.Run("none", func(t *testing.T) {
+		_, err := NewMMIPGeo("", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	db, err := NewMMIPGeo(cityDBFile, asnDBFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	m := db.Metadata()
+	assert.Equal(t, "GeoLite2-City", m.City.Edition)
+	assert.Equal(t, "GeoLite2-ASN", m.ASN.Edition)
+	assert.Equal(t, time.Unix(1704728164, 0).UTC(), m.ASN.BuildTime)
+}
+
 func TestIsFileUpdated(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -136,69 +193,64 @@ func TestAutoUpdateMMIPGeo_update(t *testing.T) {
 	copyFile(asnDBFile, updatedASNDB) // Initially make them the same
 
 	t.Run("no update needed", func(t *testing.T) {
-		db, err := NewAutoUpdateMMIPGeo(currentCityDB, updatedCityDB, currentASNDB, updatedASNDB)
+		db, err := NewAutoUpdateMMIPGeo(currentCityDB, updatedCityDB, currentASNDB, updatedASNDB, WithVerifyIPs(testCityIP, testASNIP))
 		require.NoError(t, err)
-		defer db.mm.Close()
-
-		initialMM := db.mm
+		defer db.Close()
 
-		// Ensure enough time has passed for the check interval
-		db.lastCheck = time.Now().Add(-checkUpdateInterval - time.Minute)
+		initialMM := db.mm.Load()
 
 		db.update()
 
 		// Assert that the underlying MMIPGeo struct has not changed
-		assert.Same(t, initialMM, db.mm)
+		assert.Same(t, initialMM, db.mm.Load())
 
 		got := db.GetIPGeo("81.2.69.160")
 		assert.Equal(t, "London", got.City)
 	})
 
 	t.Run("city db updated", func(t *testing.T) {
-		db, err := NewAutoUpdateMMIPGeo(currentCityDB, updatedCityDB, currentASNDB, updatedASNDB)
+		db, err := NewAutoUpdateMMIPGeo(currentCityDB, updatedCityDB, currentASNDB, updatedASNDB, WithVerifyIPs(testCityIP, testASNIP))
 		require.NoError(t, err)
 
-		initialMM := db.mm
+		initialMM := db.mm.Load()
 
 		// Change modify time of updated city DB to simulate update
 		err = os.Chtimes(updatedCityDB, time.Now(), time.Now())
 		require.NoError(t, err)
 
-		db.lastCheck = time.Now().Add(-checkUpdateInterval - time.Minute)
 		db.update()
 
 		// Assert that the underlying MMIPGeo struct has changed
-		assert.NotSame(t, initialMM, db.mm)
+		assert.NotSame(t, initialMM, db.mm.Load())
 
 		got := db.GetIPGeo("81.2.69.160")
 		assert.Equal(t, "London", got.City)
 	})
 
 	t.Run("asn db updated", func(t *testing.T) {
-		db, err := NewAutoUpdateMMIPGeo(currentCityDB, updatedCityDB, currentASNDB, updatedASNDB)
+		db, err := NewAutoUpdateMMIPGeo(currentCityDB, updatedCityDB, currentASNDB, updatedASNDB, WithVerifyIPs(testCityIP, testASNIP))
 		require.NoError(t, err)
 
-		initialMM := db.mm
+		initialMM := db.mm.Load()
 
 		// Change modify time of updated asn DB to simulate update
 		err = os.Chtimes(updatedASNDB, time.Now(), time.Now())
 		require.NoError(t, err)
 
-		db.lastCheck = time.Now().Add(-checkUpdateInterval - time.Minute)
 		db.update()
 
 		// Assert that the underlying MMIPGeo struct has changed
-		assert.NotSame(t, initialMM, db.mm)
+		assert.NotSame(t, initialMM, db.mm.Load())
 
 		got := db.GetIPGeo("81.2.69.160")
 		assert.Equal(t, "London", got.City)
 	})
 
 	t.Run("both dbs updated", func(t *testing.T) {
-		db, err := NewAutoUpdateMMIPGeo(currentCityDB, updatedCityDB, currentASNDB, updatedASNDB)
+		db, err := NewAutoUpdateMMIPGeo(currentCityDB, updatedCityDB, currentASNDB, updatedASNDB, WithVerifyIPs(testCityIP, testASNIP))
 		require.NoError(t, err)
 
-		initialMM := db.mm
+		initialMM := db.mm.Load()
 
 		// Modify modify time of both updated DBs to simulate update
 		err = os.Chtimes(updatedCityDB, time.Now(), time.Now())
@@ -206,13 +258,131 @@ func TestAutoUpdateMMIPGeo_update(t *testing.T) {
 		err = os.Chtimes(updatedASNDB, time.Now(), time.Now())
 		require.NoError(t, err)
 
-		db.lastCheck = time.Now().Add(-checkUpdateInterval - time.Minute)
 		db.update()
 
 		// Assert that the underlying MMIPGeo struct has changed
-		assert.NotSame(t, initialMM, db.mm)
+		assert.NotSame(t, initialMM, db.mm.Load())
 
 		got := db.GetIPGeo("81.2.69.160")
 		assert.Equal(t, "London", got.City)
 	})
+
+	t.Run("truncated db rejected", func(t *testing.T) {
+		db, err := NewAutoUpdateMMIPGeo(currentCityDB, updatedCityDB, currentASNDB, updatedASNDB, WithVerifyIPs(testCityIP, testASNIP))
+		require.NoError(t, err)
+
+		initialMM := db.mm.Load()
+
+		b, err := os.ReadFile(cityDBFile)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(updatedCityDB, b[:len(b)/2], 0644))
+
+		db.update()
+
+		// Assert the previous reader is kept
+		assert.Same(t, initialMM, db.mm.Load())
+		assert.NoFileExists(t, currentCityDB+".new")
+
+		got := db.GetIPGeo("81.2.69.160")
+		assert.Equal(t, "London", got.City)
+	})
+
+	t.Run("db without verify ip rejected", func(t *testing.T) {
+		copyFile(cityDBFile, updatedCityDB)
+		err := os.Chtimes(updatedCityDB, time.Now(), time.Now())
+		require.NoError(t, err)
+
+		db, err := NewAutoUpdateMMIPGeo(currentCityDB, updatedCityDB, currentASNDB, updatedASNDB, WithVerifyIPs("10.0.0.1", testASNIP))
+		require.NoError(t, err)
+
+		initialMM := db.mm.Load()
+		err = os.Chtimes(updatedCityDB, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+
+		db.update()
+
+		assert.Same(t, initialMM, db.mm.Load())
+	})
+}
+
+func TestAutoUpdateMMIPGeo_Stats(t *testing.T) {
+	db, err := NewAutoUpdateMMIPGeo(cityDBFile, cityDBFile, asnDBFile, asnDBFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	db.GetIPGeo("81.2.69.160")
+	db.GetIPGeo("1.0.0.1")
+	db.GetIPGeo("10.0.0.1")
+
+	s := db.Stats()
+	assert.Equal(t, uint64(3), s.Lookups)
+	assert.Equal(t, uint64(1), s.NotFound)
+	assert.Equal(t, uint64(0), s.UpdateSuccesses)
+	assert.Equal(t, uint64(0), s.UpdateFailures)
+	assert.Equal(t, int64(1704728164), s.ASNBuildEpoch.Unix())
+	assert.False(t, s.CityBuildEpoch.IsZero())
+
+	total := uint64(0)
+	for _, c := range s.LookupLatency {
+		total += c
+	}
+	assert.Equal(t, uint64(3), total)
+}
+
+func TestAutoUpdateMMIPGeo_Close(t *testing.T) {
+	db, err := NewAutoUpdateMMIPGeo(cityDBFile, cityDBFile, asnDBFile, asnDBFile)
+	require.NoError(t, err)
+
+	db.Close()
+	assert.Nil(t, db.mm.Load())
+	// closing again does nothing
+	db.Close()
+}
+
+func TestAutoUpdateMMIPGeo_NegativeCache(t *testing.T) {
+	db, err := NewAutoUpdateMMIPGeo(cityDBFile, cityDBFile, asnDBFile, asnDBFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, &IPGeo{IP: "10.0.0.1", Bogon: true}, db.GetIPGeo("10.0.0.1"))
+	assert.Equal(t, &IPGeo{IP: "10.0.0.1", Bogon: true}, db.GetIPGeo("10.0.0.1"))
+	db.GetIPGeo("81.2.69.160")
+	db.GetIPGeo("81.2.69.160")
+
+	s := db.Stats()
+	assert.Equal(t, uint64(3), s.Lookups)
+	assert.Equal(t, uint64(1), s.NotFound)
+	assert.Equal(t, uint64(1), s.NegativeCacheHits)
+
+	// cleared when the databases update
+	db.notFound.clear()
+	db.GetIPGeo("10.0.0.1")
+	assert.Equal(t, uint64(2), db.Stats().NotFound)
+
+	t.Run("expired", func(t *testing.T) {
+		c := newNegativeCache(time.Millisecond, 2)
+		c.add("10.0.0.1")
+		assert.True(t, c.has("10.0.0.1"))
+		time.Sleep(2 * time.Millisecond)
+		assert.False(t, c.has("10.0.0.1"))
+	})
+
+	t.Run("full", func(t *testing.T) {
+		c := newNegativeCache(time.Hour, 2)
+		c.add("10.0.0.1")
+		c.add("10.0.0.2")
+		c.add("10.0.0.3")
+		assert.False(t, c.has("10.0.0.1"))
+		assert.True(t, c.has("10.0.0.3"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		db, err := NewAutoUpdateMMIPGeo(cityDBFile, cityDBFile, asnDBFile, asnDBFile, WithNegativeCache(0, 0))
+		require.NoError(t, err)
+		defer db.Close()
+
+		db.GetIPGeo("10.0.0.1")
+		db.GetIPGeo("10.0.0.1")
+		assert.Equal(t, uint64(2), db.Stats().NotFound)
+	})
 }
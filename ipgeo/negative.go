@@ -0,0 +1,76 @@
+package ipgeo
+
+import (
+	"sync"
+	"time"
+)
+
+// negativeCache remembers ips not found until they expire. A nil cache
+// remembers nothing.
+type negativeCache struct {
+	ttl  time.Duration
+	size int
+
+	mu sync.Mutex
+	// ip to expire time
+	ips map[string]time.Time
+}
+
+// newNegativeCache returns nil if ttl or size is not positive.
+func newNegativeCache(ttl time.Duration, size int) *negativeCache {
+	if ttl <= 0 || size <= 0 {
+		return nil
+	}
+	return &negativeCache{
+		ttl:  ttl,
+		size: size,
+		ips:  map[string]time.Time{},
+	}
+}
+
+func (c *negativeCache) has(ip string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, ok := c.ips[ip]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(c.ips, ip)
+		return false
+	}
+	return true
+}
+
+func (c *negativeCache) add(ip string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.ips) >= c.size {
+		for k, until := range c.ips {
+			if now.After(until) {
+				delete(c.ips, k)
+			}
+		}
+		// still full, start over rather than tracking the oldest
+		if len(c.ips) >= c.size {
+			clear(c.ips)
+		}
+	}
+	c.ips[ip] = now.Add(c.ttl)
+}
+
+func (c *negativeCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.ips)
+}
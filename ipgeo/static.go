@@ -0,0 +1,30 @@
+package ipgeo
+
+import "net"
+
+// StaticProvider looks up ips in a fixed map of ip or cidr to geo data, so
+// policies depending on country or ASN can be tested deterministically. An
+// ip matches its own entry first, then the longest cidr containing it. Like
+// the databases, ips of no entry have empty geo data.
+type StaticProvider map[string]*IPGeo
+
+func (p StaticProvider) GetIPGeo(ip string) *IPGeo {
+	res := &IPGeo{}
+	if g, ok := p[ip]; ok {
+		*res = *g
+	} else if addr := net.ParseIP(ip); addr != nil {
+		bits := -1
+		for key, g := range p {
+			_, n, err := net.ParseCIDR(key)
+			if err != nil || !n.Contains(addr) {
+				continue
+			}
+			if ones, _ := n.Mask.Size(); ones > bits {
+				bits = ones
+				*res = *g
+			}
+		}
+	}
+	res.IP = ip
+	return res
+}
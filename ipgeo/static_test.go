@@ -0,0 +1,23 @@
+package ipgeo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticProvider(t *testing.T) {
+	p := StaticProvider{
+		"1.2.3.4":    {CountryISO: "DE", AutonomousSystemNumber: 3320},
+		"1.2.0.0/16": {CountryISO: "FR"},
+		"1.2.3.0/24": {CountryISO: "GB"},
+	}
+
+	assert.Equal(t, &IPGeo{IP: "1.2.3.4", CountryISO: "DE", AutonomousSystemNumber: 3320}, p.GetIPGeo("1.2.3.4"))
+	assert.Equal(t, &IPGeo{IP: "1.2.3.5", CountryISO: "GB"}, p.GetIPGeo("1.2.3.5"))
+	assert.Equal(t, &IPGeo{IP: "1.2.4.5", CountryISO: "FR"}, p.GetIPGeo("1.2.4.5"))
+	assert.Equal(t, &IPGeo{IP: "5.6.7.8"}, p.GetIPGeo("5.6.7.8"))
+
+	// entries are not modified
+	assert.Empty(t, p["1.2.3.4"].IP)
+}
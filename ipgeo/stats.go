@@ -0,0 +1,111 @@
+package ipgeo
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LookupLatencyBounds are the upper bounds of the lookup latency histogram
+// buckets, the last bucket counts lookups slower than all bounds.
+var LookupLatencyBounds = []time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+}
+
+// Stats is a snapshot of AutoUpdateMMIPGeo counters, for metrics exporters.
+type Stats struct {
+	// CityBuildEpoch and ASNBuildEpoch are the build time of the loaded
+	// databases, from mmdb metadata.
+	CityBuildEpoch time.Time
+	ASNBuildEpoch  time.Time
+
+	// LastUpdate is when the current databases are loaded.
+	LastUpdate      time.Time
+	UpdateSuccesses uint64
+	UpdateFailures  uint64
+
+	Lookups  uint64
+	NotFound uint64
+	// NegativeCacheHits counts lookups answered by the cache of ips not
+	// found, they are not counted in Lookups.
+	NegativeCacheHits uint64
+	// LookupLatency has len(LookupLatencyBounds)+1 buckets, not cumulative.
+	LookupLatency    []uint64
+	LookupLatencySum time.Duration
+}
+
+type stats struct {
+	lastUpdate      atomic.Int64
+	updateSuccesses atomic.Uint64
+	updateFailures  atomic.Uint64
+
+	lookups          atomic.Uint64
+	notFound         atomic.Uint64
+	lookupLatency    []atomic.Uint64
+	lookupLatencySum atomic.Int64
+
+	negativeCacheHits atomic.Uint64
+}
+
+func newStats() *stats {
+	s := &stats{
+		lookupLatency: make([]atomic.Uint64, len(LookupLatencyBounds)+1),
+	}
+	s.lastUpdate.Store(time.Now().UnixNano())
+	return s
+}
+
+func (s *stats) observeLookup(d time.Duration, found bool) {
+	s.lookups.Add(1)
+	if !found {
+		s.notFound.Add(1)
+	}
+
+	i := 0
+	for i < len(LookupLatencyBounds) && d > LookupLatencyBounds[i] {
+		i++
+	}
+	s.lookupLatency[i].Add(1)
+	s.lookupLatencySum.Add(int64(d))
+}
+
+func (s *stats) updated(ok bool) {
+	if !ok {
+		s.updateFailures.Add(1)
+		return
+	}
+	s.updateSuccesses.Add(1)
+	s.lastUpdate.Store(time.Now().UnixNano())
+}
+
+// Stats returns a snapshot of the counters.
+func (db *AutoUpdateMMIPGeo) Stats() Stats {
+	s := db.stats
+	res := Stats{
+		LastUpdate:       time.Unix(0, s.lastUpdate.Load()),
+		UpdateSuccesses:  s.updateSuccesses.Load(),
+		UpdateFailures:   s.updateFailures.Load(),
+		Lookups:          s.lookups.Load(),
+		NotFound:         s.notFound.Load(),
+		LookupLatency:    make([]uint64, len(s.lookupLatency)),
+		LookupLatencySum: time.Duration(s.lookupLatencySum.Load()),
+
+		NegativeCacheHits: s.negativeCacheHits.Load(),
+	}
+	for i := range s.lookupLatency {
+		res.LookupLatency[i] = s.lookupLatency[i].Load()
+	}
+
+	if mm := db.mm.Load(); mm != nil {
+		m := mm.Metadata()
+		res.CityBuildEpoch = m.City.BuildTime
+		res.ASNBuildEpoch = m.ASN.BuildTime
+	}
+
+	return res
+}
@@ -1,8 +1,9 @@
 package firewall
 
 import (
-	"log"
+	"fmt"
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 )
@@ -12,27 +13,35 @@ type ipMatcher struct {
 	network *net.IPNet
 }
 
-func newIPMatcher(rule string) *ipMatcher {
+// parseIPMatcher parses a whitelist rule, an ip or a cidr.
+func parseIPMatcher(rule string) (*ipMatcher, error) {
 	s := strings.Split(rule, "/")
-	if len(s) == 1 {
-		return &ipMatcher{ip: parseIP(s[0])}
+	if len(s) > 2 {
+		return nil, fmt.Errorf("parse whitelist rule %q failed", rule)
 	}
 
-	if len(s) == 2 {
-		m, err := strconv.Atoi(s[1])
-		if err != nil {
-			log.Fatalf("parse ip mask %q failed: %v", s[1], err)
-		}
-		return &ipMatcher{
-			network: &net.IPNet{
-				IP:   parseIP(s[0]),
-				Mask: net.CIDRMask(m, 32),
-			},
-		}
+	ip := clientIP(s[0])
+	if ip == nil {
+		return nil, fmt.Errorf("net.ParseIP(%q) failed", s[0])
+	}
+	if len(s) == 1 {
+		return &ipMatcher{ip: ip}, nil
 	}
 
-	log.Fatalf("parse whitelist rule %q failed", rule)
-	return nil
+	bits := 8 * len(ip)
+	m, err := strconv.Atoi(s[1])
+	if err != nil {
+		return nil, fmt.Errorf("parse ip mask %q failed: %w", s[1], err)
+	}
+	if m < 0 || m > bits {
+		return nil, fmt.Errorf("mask %d out of range for %d-bit ip", m, bits)
+	}
+	return &ipMatcher{
+		network: &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(m, bits),
+		},
+	}, nil
 }
 
 func (s *ipMatcher) match(ip net.IP) bool {
@@ -46,17 +55,63 @@ func (s *ipMatcher) match(ip net.IP) bool {
 	return false
 }
 
-func parseIP(s string) net.IP {
-	// This is safe to crash, as the ip is from config
+// overlaps returns whether the rule shares an address with n.
+func (s *ipMatcher) overlaps(n *net.IPNet) bool {
+	if s.ip != nil {
+		return n.Contains(s.ip)
+	}
+	return s.network.Contains(n.IP) || n.Contains(s.network.IP)
+}
+
+// keyMatcher returns a func matching rules against key, an ip or a cidr of
+// a network ban which matches the rules it overlaps. It returns nil if key
+// is neither.
+func keyMatcher(key string) func(m *ipMatcher) bool {
+	if ip := clientIP(key); ip != nil {
+		return func(m *ipMatcher) bool { return m.match(ip) }
+	}
+	if _, n, err := net.ParseCIDR(key); err == nil {
+		return func(m *ipMatcher) bool { return m.overlaps(n) }
+	}
+	return nil
+}
+
+// clientIP parses an ip, ipv4 in 4 bytes and ipv6 in 16 bytes. It returns
+// nil if s is not an ip.
+func clientIP(s string) net.IP {
 	ip := net.ParseIP(s)
 	if ip == nil {
-		log.Fatalf("net.ParseIP(%q) failed", s)
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
 	}
+	return ip
+}
 
-	ip = ip.To4()
-	if ip == nil {
-		log.Fatalf("%q is not ipv4", s)
+// NormalizeIP returns the canonical form of an ip from a sloppy caller, so
+// the same client is not counted under different keys. Spaces, a port,
+// brackets and a zone are removed and ipv4 mapped ipv6 addresses become
+// ipv4, e.g. "::ffff:1.2.3.4", "1.2.3.4:5678" and "[::ffff:1.2.3.4]:80" are
+// all "1.2.3.4"; ipv6 is in its compressed form. It returns s and false if
+// s is not an ip, e.g. a cidr.
+func NormalizeIP(s string) (string, bool) {
+	ip := strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
 	}
+	ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	ip, _, _ = strings.Cut(ip, "%")
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return s, false
+	}
+	return addr.Unmap().String(), true
+}
 
+// normalizeIP returns NormalizeIP of ip, or ip as is if it is not one.
+func normalizeIP(ip string) string {
+	ip, _ = NormalizeIP(ip)
 	return ip
 }
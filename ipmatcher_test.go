@@ -5,9 +5,10 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestNewIPMatcher(t *testing.T) {
+func TestParseIPMatcher(t *testing.T) {
 	tests := []struct {
 		name        string
 		rule        string
@@ -27,28 +28,54 @@ func TestNewIPMatcher(t *testing.T) {
 				Mask: net.CIDRMask(8, 32),
 			},
 		},
+		{
+			name:       "single ipv6",
+			rule:       "2001:db8::1",
+			expectedIP: net.ParseIP("2001:db8::1"),
+		},
+		{
+			name: "ipv6 prefix",
+			rule: "2001:db8::/48",
+			expectedNet: &net.IPNet{
+				IP:   net.ParseIP("2001:db8::"),
+				Mask: net.CIDRMask(48, 128),
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			matcher := newIPMatcher(tt.rule)
+			matcher, err := parseIPMatcher(tt.rule)
+			require.NoError(t, err)
 
 			if tt.expectedIP != nil {
-				assert.NotNil(t, matcher.ip, "newIPMatcher(%q) expected ip not to be nil", tt.rule)
-				assert.True(t, matcher.ip.Equal(tt.expectedIP), "newIPMatcher(%q) ip got %v, want %v", tt.rule, matcher.ip, tt.expectedIP)
-				assert.Nil(t, matcher.network, "newIPMatcher(%q) expected network to be nil, got %v", tt.rule, matcher.network)
+				assert.NotNil(t, matcher.ip, "parseIPMatcher(%q) expected ip not to be nil", tt.rule)
+				assert.True(t, matcher.ip.Equal(tt.expectedIP), "parseIPMatcher(%q) ip got %v, want %v", tt.rule, matcher.ip, tt.expectedIP)
+				assert.Nil(t, matcher.network, "parseIPMatcher(%q) expected network to be nil, got %v", tt.rule, matcher.network)
 			}
 
 			if tt.expectedNet != nil {
-				assert.NotNil(t, matcher.network, "newIPMatcher(%q) expected network not to be nil", tt.rule)
-				assert.True(t, matcher.network.IP.Equal(tt.expectedNet.IP), "newIPMatcher(%q) network.IP got %v, want %v", tt.rule, matcher.network.IP, tt.expectedNet.IP)
-				assert.Equal(t, matcher.network.Mask.String(), tt.expectedNet.Mask.String(), "newIPMatcher(%q) network.Mask got %v, want %v", tt.rule, matcher.network.Mask, tt.expectedNet.Mask)
-				assert.Nil(t, matcher.ip, "newIPMatcher(%q) expected ip to be nil, got %v", tt.rule, matcher.ip)
+				assert.NotNil(t, matcher.network, "parseIPMatcher(%q) expected network not to be nil", tt.rule)
+				assert.True(t, matcher.network.IP.Equal(tt.expectedNet.IP), "parseIPMatcher(%q) network.IP got %v, want %v", tt.rule, matcher.network.IP, tt.expectedNet.IP)
+				assert.Equal(t, matcher.network.Mask.String(), tt.expectedNet.Mask.String(), "parseIPMatcher(%q) network.Mask got %v, want %v", tt.rule, matcher.network.Mask, tt.expectedNet.Mask)
+				assert.Nil(t, matcher.ip, "parseIPMatcher(%q) expected ip to be nil, got %v", tt.rule, matcher.ip)
 			}
 		})
 	}
 }
 
+func TestParseIPMatcher_Invalid(t *testing.T) {
+	for _, rule := range []string{"", "not an ip", "10.0.0.0/8/8", "10.0.0.0/33", "2001:db8::/129", "10.0.0.0/x"} {
+		_, err := parseIPMatcher(rule)
+		assert.Error(t, err, rule)
+	}
+
+	_, err := parseIPMatcher("10.0.0.0/33")
+	assert.EqualError(t, err, "mask 33 out of range for 32-bit ip")
+	_, err = parseIPMatcher("2001:db8::/129")
+	assert.EqualError(t, err, "mask 129 out of range for 128-bit ip")
+}
+
 func TestIPMatcher_Match(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -92,16 +119,75 @@ func TestIPMatcher_Match(t *testing.T) {
 			ipToMatch: "192.168.1.255",
 			expected:  true,
 		},
+		{
+			name:      "ipv6 match",
+			rule:      "2001:db8::1",
+			ipToMatch: "2001:DB8:0::1",
+			expected:  true,
+		},
+		{
+			name:      "ipv6 prefix match",
+			rule:      "2001:db8::/32",
+			ipToMatch: "2001:db8:ffff::1",
+			expected:  true,
+		},
+		{
+			name:      "ipv6 prefix no match",
+			rule:      "2001:db8::/32",
+			ipToMatch: "2001:db9::1",
+			expected:  false,
+		},
+		{
+			name:      "ipv4 mapped ipv6 match",
+			rule:      "10.0.0.0/8",
+			ipToMatch: "::ffff:10.1.2.3",
+			expected:  true,
+		},
+		{
+			name:      "ipv4 rule does not match ipv6",
+			rule:      "10.0.0.0/8",
+			ipToMatch: "2001:db8::1",
+			expected:  false,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			matcher := newIPMatcher(tt.rule)
-			ip := net.ParseIP(tt.ipToMatch)
+			matcher, err := parseIPMatcher(tt.rule)
+			require.NoError(t, err)
+			ip := clientIP(tt.ipToMatch)
 			if ip == nil {
 				t.Fatalf("Invalid IP in test case: %s", tt.ipToMatch)
 			}
-			assert.Equal(t, tt.expected, matcher.match(ip.To4()), "ipMatcher.match() for rule %q with IP %q", tt.rule, tt.ipToMatch)
+			assert.Equal(t, tt.expected, matcher.match(ip), "ipMatcher.match() for rule %q with IP %q", tt.rule, tt.ipToMatch)
 		})
 	}
 }
+
+func TestNormalizeIP(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"1.2.3.4", "1.2.3.4", true},
+		{" 1.2.3.4\n", "1.2.3.4", true},
+		{"::ffff:1.2.3.4", "1.2.3.4", true},
+		{"::FFFF:102:304", "1.2.3.4", true},
+		{"1.2.3.4:5678", "1.2.3.4", true},
+		{"[::ffff:1.2.3.4]:80", "1.2.3.4", true},
+		{"2001:DB8:0:0::1", "2001:db8::1", true},
+		{"[2001:db8::1]", "2001:db8::1", true},
+		{"[2001:db8::1]:443", "2001:db8::1", true},
+		{"fe80::1%eth0", "fe80::1", true},
+		{"[fe80::1%eth0]:22", "fe80::1", true},
+		{"1.2.3.0/24", "1.2.3.0/24", false},
+		{"not an ip", "not an ip", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := NormalizeIP(tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+		assert.Equal(t, tt.ok, ok, tt.in)
+	}
+}
@@ -0,0 +1,361 @@
+package firewall
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+// IUnbanFirewall is implemented by backends which can remove an ip from the
+// block list.
+type IUnbanFirewall interface {
+	UnbanIP(ip string) error
+}
+
+// jailed is an ip banned by this firewall. It is only accessed in the loop
+// goroutine.
+type jailed struct {
+	until         time.Time
+	reasons       []string
+	geo           *ipgeo.IPGeo
+	correlationID string
+	annotation    *Annotation
+	action        BanAction
+}
+
+// BanInfo is an ip jailed by the firewall.
+type BanInfo struct {
+	IP            string       `json:"ip"`
+	Until         time.Time    `json:"until"`
+	Reasons       []string     `json:"reasons"`
+	Geo           *ipgeo.IPGeo `json:"geo,omitempty"`
+	CorrelationID string       `json:"correlation_id"`
+	Annotation    *Annotation  `json:"annotation,omitempty"`
+	BanAction     BanAction    `json:"ban_action,omitempty"`
+}
+
+// addJail records the ban of b, a shorter ban does not shorten the jail.
+func (s *Firewall) addJail(b *ban, until time.Time, geo *ipgeo.IPGeo) {
+	if j, ok := s.jail[b.ip]; ok && j.until.After(until) {
+		return
+	}
+	s.jail[b.ip] = &jailed{
+		until:         until,
+		reasons:       reasonsOf(b.offenses),
+		geo:           geo,
+		correlationID: b.correlationID,
+		annotation:    b.annotation,
+		action:        b.action,
+	}
+	s.expiry.add(b.ip, until)
+	s.index.set(b.ip, until)
+	s.checkCapacity(b)
+}
+
+// checkCapacity logs "jail-capacity" once the jail grows over the capacity
+// set by WithJailCapacity, and again after it went back under.
+func (s *Firewall) checkCapacity(b *ban) {
+	if s.jailCapacity <= 0 {
+		return
+	}
+	over := len(s.jail) > s.jailCapacity
+	if over && !s.overCapacity {
+		s.log(&Event{
+			IP:            b.ip,
+			Reasons:       []string{fmt.Sprintf("%d ips jailed, capacity is %d", len(s.jail), s.jailCapacity)},
+			Action:        "jail-capacity",
+			CorrelationID: b.correlationID,
+		})
+	}
+	s.overCapacity = over
+}
+
+// ListBans returns the ips jailed by this firewall, ordered by the end of
+// their ban. Bans by the backend outside of this firewall, e.g. before a
+// restart, are not included.
+func (s *Firewall) ListBans() []BanInfo {
+	now := s.now()
+	res := []BanInfo{}
+	s.do(func() {
+		for ip, j := range s.jail {
+			if !j.until.After(now) {
+				continue
+			}
+			res = append(res, BanInfo{
+				IP:            ip,
+				Until:         j.until,
+				Reasons:       slices.Clone(j.reasons),
+				Geo:           j.geo,
+				CorrelationID: j.correlationID,
+				Annotation:    j.annotation,
+				BanAction:     j.action,
+			})
+		}
+	})
+
+	slices.SortFunc(res, func(a, b BanInfo) int {
+		return cmp.Or(a.Until.Compare(b.Until), cmp.Compare(a.IP, b.IP))
+	})
+	return res
+}
+
+// IsBanned reports whether ip is jailed by this firewall and until when.
+func (s *Firewall) IsBanned(ip string) (bool, time.Time) {
+	ip = normalizeIP(ip)
+	var until time.Time
+	s.do(func() {
+		if j, ok := s.jail[ip]; ok {
+			until = j.until
+		}
+	})
+	if !until.After(s.now()) {
+		return false, time.Time{}
+	}
+	return true, until
+}
+
+// Restore jails bans known before a restart, e.g. from the history store,
+// so errors of their ips are not counted again and their release is
+// scheduled. Each unexpired ban not whitelisted is sent to the backend for
+// its remaining time, bypassing the ban filter and the safety valve, and
+// logs "restore". It returns the number of bans restored.
+func (s *Firewall) Restore(bans []BanInfo) int {
+	restored := 0
+	s.do(func() {
+		now := s.now()
+		for _, it := range bans {
+			if !it.Until.After(now) || s.inWhitelist(it.IP) {
+				continue
+			}
+
+			correlationID := it.CorrelationID
+			if correlationID == "" {
+				correlationID = newCorrelationID()
+			}
+			b := &ban{
+				ip:              it.IP,
+				timeoutInMinute: int(math.Ceil(it.Until.Sub(now).Minutes())),
+				decidedAt:       now,
+				correlationID:   correlationID,
+				annotation:      it.Annotation,
+				action:          it.BanAction,
+			}
+			for _, r := range it.Reasons {
+				b.offenses = append(b.offenses, Offense{Time: now, Reason: r})
+			}
+
+			if s.fw != nil {
+				s.banBackend(b)
+			}
+			s.addJail(b, it.Until, it.Geo)
+			ec := s.counter(it.IP)
+			if ec.bannedUntil.Before(it.Until) {
+				ec.bannedUntil = it.Until
+				ec.correlationID = correlationID
+			}
+			restored++
+			s.log(&Event{
+				IP:            it.IP,
+				JailUntil:     it.Until,
+				Reasons:       it.Reasons,
+				Action:        "restore",
+				Geo:           it.Geo,
+				CorrelationID: correlationID,
+				Annotation:    it.Annotation,
+				BanAction:     it.BanAction,
+			})
+		}
+	})
+	return restored
+}
+
+func (s *Firewall) removeJail(ip string) {
+	delete(s.jail, ip)
+	s.expiry.remove(ip)
+	s.index.remove(ip)
+}
+
+// release removes the ips whose jail expired at now and logs "released".
+// Backends not expiring bans by themselves are asked to unban them.
+func (s *Firewall) release(now time.Time) {
+	e, ok := s.fw.(IExpiringFirewall)
+	expiring := ok && e.ExpiresBans()
+	for _, ip := range s.expiry.advance(now) {
+		j, ok := s.jail[ip]
+		if !ok {
+			continue
+		}
+		delete(s.jail, ip)
+		s.index.remove(ip)
+
+		if !expiring {
+			s.unbanBackend(ip, j.correlationID)
+		}
+		s.log(&Event{
+			IP:            ip,
+			JailUntil:     j.until,
+			Action:        "released",
+			Geo:           j.geo,
+			CorrelationID: j.correlationID,
+		})
+	}
+}
+
+// UnbanIP removes the ban of ip early, e.g. a false positive. Backends
+// implementing IUnbanFirewall are asked to unban it, the ip is unbanned even
+// if it is not jailed by this firewall, e.g. banned before a restart, and its
+// error count is reset. It logs "unban", or "backend-error" if the backend
+// fails.
+func (s *Firewall) UnbanIP(ip string, reason string) {
+	s.unbanIP(ip, reason, nil)
+}
+
+// unbanIP returns the error of the backend, the ip stays banned then.
+func (s *Firewall) unbanIP(ip string, reason string, a *Annotation) error {
+	ip = normalizeIP(ip)
+	var err error
+	s.do(func() {
+		correlationID := newCorrelationID()
+		j, jailed := s.jail[ip]
+		if jailed && j.correlationID != "" {
+			correlationID = j.correlationID
+		}
+
+		if err = s.unbanBackend(ip, correlationID); err != nil {
+			return
+		}
+
+		event := &Event{
+			IP:            ip,
+			Reasons:       []string{reason},
+			Action:        "unban",
+			CorrelationID: correlationID,
+			Annotation:    a,
+		}
+		if jailed {
+			s.removeJail(ip)
+			event.Geo = j.geo
+		}
+		// a false positive starts over with no error counted.
+		delete(s.errorCount, ip)
+		s.log(event)
+	})
+	return err
+}
+
+// UnbanSubnet unbans all jailed ips and networks in the cidr, e.g.
+// "1.2.3.0/24". It returns the ips and networks unbanned.
+func (s *Firewall) UnbanSubnet(cidr string) ([]string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.unbanBulk("subnet "+network.String(), func(key string, _ *jailed) bool {
+		return containsKey(network, key)
+	}), nil
+}
+
+// containsKey returns whether the jail key, an ip or the cidr of a network
+// ban, is within network.
+func containsKey(network *net.IPNet, key string) bool {
+	if ip := clientIP(key); ip != nil {
+		return network.Contains(ip)
+	}
+	_, n, err := net.ParseCIDR(key)
+	if err != nil {
+		return false
+	}
+	ones, bits := n.Mask.Size()
+	netOnes, netBits := network.Mask.Size()
+	return bits == netBits && ones >= netOnes && network.Contains(n.IP)
+}
+
+// UnbanASN unbans all jailed ips in the autonomous system. It requires the
+// geo database as the ASN is taken from the geo enrichment of the ban.
+func (s *Firewall) UnbanASN(asn uint) ([]string, error) {
+	if s.ipGeo == nil {
+		return nil, fmt.Errorf("unban AS%d: no geo database", asn)
+	}
+
+	return s.unbanBulk(fmt.Sprintf("AS%d", asn), func(_ string, j *jailed) bool {
+		return j.geo != nil && j.geo.AutonomousSystemNumber == asn
+	}), nil
+}
+
+// unbanBulk unbans the jailed ips matched. Each ip gets an "unban" event,
+// followed by a "bulk-unban" event summarizing the operation, all share
+// one correlation id.
+func (s *Firewall) unbanBulk(target string, match func(ip string, j *jailed) bool) []string {
+	unbanned := []string{}
+	s.do(func() {
+		correlationID := newCorrelationID()
+
+		for ip, j := range s.jail {
+			if !match(ip, j) {
+				continue
+			}
+			if s.unbanBackend(ip, correlationID) != nil {
+				continue
+			}
+
+			s.removeJail(ip)
+			if ec, ok := s.errorCount[ip]; ok {
+				ec.bannedUntil = time.Time{}
+			}
+			unbanned = append(unbanned, ip)
+			s.log(&Event{
+				IP:            ip,
+				Reasons:       []string{"bulk unban " + target},
+				Action:        "unban",
+				Geo:           j.geo,
+				CorrelationID: correlationID,
+			})
+		}
+
+		s.log(&Event{
+			Reasons:       []string{fmt.Sprintf("bulk unban %s: %d ips", target, len(unbanned))},
+			Action:        "bulk-unban",
+			CorrelationID: correlationID,
+		})
+	})
+	return unbanned
+}
+
+// unbanBackend removes ip from the backend, backends without unban support
+// only forget the ip in the firewall.
+func (s *Firewall) unbanBackend(ip string, correlationID string) error {
+	return s.unbanResult(ip, s.callUnbanBackend(ip), correlationID)
+}
+
+// callUnbanBackend asks the backend to unban ip, it does not touch the state
+// of the loop so it can run in a worker.
+func (s *Firewall) callUnbanBackend(ip string) error {
+	f, ok := s.fw.(IUnbanFirewall)
+	if !ok {
+		return nil
+	}
+	return f.UnbanIP(ip)
+}
+
+// unbanResult logs err of the backend unbanning ip, and returns it as a
+// BackendError.
+func (s *Firewall) unbanResult(ip string, err error, correlationID string) error {
+	if err == nil {
+		return nil
+	}
+	s.logBackendError(ip, backendErrors(backendName(s.fw), "unban", err), correlationID)
+	return newBackendError(backendName(s.fw), "unban", err)
+}
+
+func backendName(fw IFirewall) string {
+	if f, ok := fw.(IErrorFirewall); ok {
+		return f.Name()
+	}
+	return fmt.Sprintf("%T", fw)
+}
@@ -0,0 +1,344 @@
+package firewall
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MockUnbanFirewall is a mock implementation of IUnbanFirewall for testing.
+type MockUnbanFirewall struct {
+	MockIFirewall
+	UnbannedIPs []string
+	Err         error
+}
+
+func (m *MockUnbanFirewall) UnbanIP(ip string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	m.UnbannedIPs = append(m.UnbannedIPs, ip)
+	return nil
+}
+
+func TestUnbanIP(t *testing.T) {
+	mockFW := &MockUnbanFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.LogIPError("1.2.3.4", "r")
+	fw.LogIPError("1.2.3.4", "r")
+	mockLogger.Wg.Wait()
+	require.Equal(t, "ban", mockLogger.Events[1].Action)
+
+	mockLogger.Wg.Add(1)
+	fw.UnbanIP("1.2.3.4", "false positive")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.4"}, mockFW.UnbannedIPs)
+	e := mockLogger.Events[2]
+	assert.Equal(t, "unban", e.Action)
+	assert.Equal(t, []string{"false positive"}, e.Reasons)
+	assert.Equal(t, mockLogger.Events[1].CorrelationID, e.CorrelationID)
+
+	// the ip is no longer jailed and errors are counted again.
+	mockLogger.Wg.Add(1)
+	fw.LogIPError("1.2.3.4", "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "count error", mockLogger.Events[3].Action)
+	fw.do(func() {
+		assert.NotContains(t, fw.jail, "1.2.3.4")
+	})
+
+	// an ip not jailed is unbanned on the backend as well.
+	mockLogger.Wg.Add(1)
+	fw.UnbanIP("5.6.7.8", "manual")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, []string{"1.2.3.4", "5.6.7.8"}, mockFW.UnbannedIPs)
+	assert.NotEmpty(t, mockLogger.Events[4].CorrelationID)
+}
+
+func TestUnbanIP_BackendError(t *testing.T) {
+	mockFW := &MockUnbanFirewall{Err: errors.New("down")}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.2.3.4", 10, "r")
+	mockLogger.Wg.Wait()
+
+	mockLogger.Wg.Add(1)
+	fw.UnbanIP("1.2.3.4", "false positive")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, "backend-error", mockLogger.Events[1].Action)
+	fw.do(func() {
+		assert.Contains(t, fw.jail, "1.2.3.4")
+	})
+}
+
+func TestListBans(t *testing.T) {
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, &MockUnbanFirewall{}, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	ok, _ := fw.IsBanned("1.2.3.4")
+	assert.False(t, ok)
+	assert.Empty(t, fw.ListBans())
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.2.3.4", 20, "scanner")
+	fw.BanIP("1.2.3.5", 10, "sqli")
+	mockLogger.Wg.Wait()
+
+	bans := fw.ListBans()
+	require.Len(t, bans, 2)
+	assert.Equal(t, "1.2.3.5", bans[0].IP)
+	assert.Equal(t, []string{"sqli"}, bans[0].Reasons)
+	assert.Equal(t, mockLogger.Events[1].CorrelationID, bans[0].CorrelationID)
+	assert.Equal(t, mockLogger.Events[1].JailUntil, bans[0].Until)
+	assert.Equal(t, "1.2.3.4", bans[1].IP)
+
+	ok, until := fw.IsBanned("1.2.3.4")
+	assert.True(t, ok)
+	assert.Equal(t, mockLogger.Events[0].JailUntil, until)
+
+	mockLogger.Wg.Add(1)
+	fw.UnbanIP("1.2.3.4", "false positive")
+	mockLogger.Wg.Wait()
+	ok, _ = fw.IsBanned("1.2.3.4")
+	assert.False(t, ok)
+	assert.Len(t, fw.ListBans(), 1)
+}
+
+func TestUnbanSubnet(t *testing.T) {
+	mockFW := &MockUnbanFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.BanIP("1.2.3.4", 10, "r")
+	fw.BanIP("1.2.3.5", 10, "r")
+	fw.BanIP("1.2.4.4", 10, "r")
+	mockLogger.Wg.Wait()
+
+	mockLogger.Wg.Add(3)
+	got, err := fw.UnbanSubnet("1.2.3.0/24")
+	require.NoError(t, err)
+	mockLogger.Wg.Wait()
+
+	sort.Strings(got)
+	assert.Equal(t, []string{"1.2.3.4", "1.2.3.5"}, got)
+	sort.Strings(mockFW.UnbannedIPs)
+	assert.Equal(t, got, mockFW.UnbannedIPs)
+
+	events := mockLogger.Events[3:]
+	assert.Equal(t, "unban", events[0].Action)
+	assert.Equal(t, "unban", events[1].Action)
+	assert.Equal(t, "bulk-unban", events[2].Action)
+	assert.Equal(t, []string{"bulk unban subnet 1.2.3.0/24: 2 ips"}, events[2].Reasons)
+	assert.Equal(t, events[0].CorrelationID, events[2].CorrelationID)
+
+	// unbanned ips are not unbanned again
+	mockLogger.Wg.Add(2)
+	got, err = fw.UnbanSubnet("1.2.0.0/16")
+	require.NoError(t, err)
+	mockLogger.Wg.Wait()
+	assert.Equal(t, []string{"1.2.4.4"}, got)
+
+	_, err = fw.UnbanSubnet("1.2.3.0")
+	assert.Error(t, err)
+}
+
+func TestUnbanSubnet_Network(t *testing.T) {
+	mockFW := &MockNetworkFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	require.NoError(t, fw.BanNetwork("1.2.3.0/28", 10, "scan"))
+	require.NoError(t, fw.BanNetwork("1.2.0.0/16", 10, "scan"))
+	require.NoError(t, fw.BanNetwork("2001:db8::/64", 10, "scan"))
+	mockLogger.Wg.Wait()
+
+	// networks within the subnet are unbanned, larger ones are not
+	mockLogger.Wg.Add(2)
+	got, err := fw.UnbanSubnet("1.2.3.0/24")
+	require.NoError(t, err)
+	mockLogger.Wg.Wait()
+	assert.Equal(t, []string{"1.2.3.0/28"}, got)
+	assert.Equal(t, []string{"1.2.3.0/28"}, mockFW.UnbannedIPs)
+
+	mockLogger.Wg.Add(2)
+	got, err = fw.UnbanSubnet("2001:db8::/32")
+	require.NoError(t, err)
+	mockLogger.Wg.Wait()
+	assert.Equal(t, []string{"2001:db8::/64"}, got)
+	banned, _ := fw.IsBanned("1.2.0.0/16")
+	assert.True(t, banned)
+}
+
+func TestUnbanSubnet_BackendError(t *testing.T) {
+	mockFW := &MockUnbanFirewall{Err: errors.New("boom")}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.2.3.4", 10, "r")
+	mockLogger.Wg.Wait()
+
+	mockLogger.Wg.Add(2)
+	got, err := fw.UnbanSubnet("1.2.3.0/24")
+	require.NoError(t, err)
+	mockLogger.Wg.Wait()
+
+	assert.Empty(t, got)
+	assert.Equal(t, "backend-error", mockLogger.Events[1].Action)
+	assert.Equal(t, "unban", mockLogger.Events[1].Backend.Op)
+	assert.Equal(t, []string{"bulk unban subnet 1.2.3.0/24: 0 ips"}, mockLogger.Events[2].Reasons)
+}
+
+func TestUnbanASN_NoGeo(t *testing.T) {
+	fw, err := New([]string{}, &MockIFirewall{}, &MockILogger{}, nil, testForgivable)
+	require.NoError(t, err)
+
+	_, err = fw.UnbanASN(64500)
+	assert.Error(t, err)
+}
+
+// MockExpiringFirewall is a mock backend which expires bans by itself.
+type MockExpiringFirewall struct {
+	MockUnbanFirewall
+}
+
+func (m *MockExpiringFirewall) ExpiresBans() bool {
+	return true
+}
+
+func TestRelease(t *testing.T) {
+	mockFW := &MockUnbanFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.2.3.4", 10, "r")
+	fw.BanIP("1.2.3.5", 20, "r")
+	mockLogger.Wg.Wait()
+
+	mockLogger.Wg.Add(1)
+	fw.do(func() {
+		fw.release(time.Now().Add(11 * time.Minute))
+	})
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.4"}, mockFW.UnbannedIPs)
+	e := mockLogger.Events[2]
+	assert.Equal(t, "released", e.Action)
+	assert.Equal(t, "1.2.3.4", e.IP)
+	assert.Equal(t, mockLogger.Events[0].CorrelationID, e.CorrelationID)
+
+	mockLogger.Wg.Add(1)
+	got, err := fw.UnbanSubnet("1.2.3.4/32")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestRelease_ExpiringBackend(t *testing.T) {
+	mockFW := &MockExpiringFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.2.3.4", 10, "r")
+	mockLogger.Wg.Wait()
+
+	mockLogger.Wg.Add(1)
+	fw.do(func() {
+		fw.release(time.Now().Add(11 * time.Minute))
+	})
+	mockLogger.Wg.Wait()
+
+	assert.Empty(t, mockFW.UnbannedIPs)
+	assert.Equal(t, "released", mockLogger.Events[1].Action)
+}
+
+func TestRestore(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{"10.0.0.0/8"}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	mockLogger.Wg.Add(1)
+	n := fw.Restore([]BanInfo{
+		{IP: "1.2.3.4", Until: until, Reasons: []string{"scanner"}, CorrelationID: "c1"},
+		{IP: "1.2.3.5", Until: time.Now().Add(-time.Minute)},
+		{IP: "10.0.0.1", Until: until},
+	})
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"1.2.3.4"}, mockFW.BannedIPs)
+	e := mockLogger.Events[0]
+	assert.Equal(t, "restore", e.Action)
+	assert.Equal(t, until, e.JailUntil)
+	assert.Equal(t, []string{"scanner"}, e.Reasons)
+	assert.Equal(t, "c1", e.CorrelationID)
+
+	banned, got := fw.IsBanned("1.2.3.4")
+	assert.True(t, banned)
+	assert.Equal(t, until, got)
+
+	// errors of a restored ban are not counted again.
+	mockLogger.Wg.Add(1)
+	fw.LogIPError("1.2.3.4", "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "banned", mockLogger.Events[1].Action)
+	assert.Equal(t, "c1", mockLogger.Events[1].CorrelationID)
+}
+
+func TestJailCapacity(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable, WithJailCapacity(2))
+	require.NoError(t, err)
+
+	// the third ban is over capacity, the fourth is not logged again
+	mockLogger.Wg.Add(5)
+	for _, ip := range []string{"1.2.3.1", "1.2.3.2", "1.2.3.3", "1.2.3.4"} {
+		fw.BanIP(ip, 10, "r")
+	}
+	mockLogger.Wg.Wait()
+
+	actions := []string{}
+	for _, e := range mockLogger.Events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"ban", "ban", "jail-capacity", "ban", "ban"}, actions)
+	assert.Equal(t, "1.2.3.3", mockLogger.Events[2].IP)
+	assert.Equal(t, []string{"3 ips jailed, capacity is 2"}, mockLogger.Events[2].Reasons)
+
+	// back under capacity, it is logged again when over
+	mockLogger.Wg.Add(4)
+	for _, ip := range []string{"1.2.3.1", "1.2.3.2", "1.2.3.3"} {
+		fw.UnbanIP(ip, "r")
+	}
+	fw.BanIP("1.2.3.5", 10, "r")
+	mockLogger.Wg.Wait()
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.2.3.6", 10, "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "jail-capacity", mockLogger.Events[len(mockLogger.Events)-2].Action)
+}
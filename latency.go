@@ -0,0 +1,44 @@
+package firewall
+
+import "time"
+
+// Latency is the time to enforce a ban. Queue is from the ban decision (the
+// BanIP call or the error breaching the threshold) to the event loop
+// handling it, Backend is the time the backend takes to confirm the ban.
+type Latency struct {
+	Queue   time.Duration
+	Backend time.Duration
+}
+
+// Total returns the time attackers remain unblocked after the decision.
+func (l *Latency) Total() time.Duration {
+	return l.Queue + l.Backend
+}
+
+// EnforcementStats aggregates Latency of all bans since New.
+type EnforcementStats struct {
+	Count        int
+	QueueTotal   time.Duration
+	QueueMax     time.Duration
+	BackendTotal time.Duration
+	BackendMax   time.Duration
+}
+
+func (s *Firewall) recordLatency(l *Latency) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.stats.Count++
+	s.stats.QueueTotal += l.Queue
+	s.stats.QueueMax = max(s.stats.QueueMax, l.Queue)
+	s.stats.BackendTotal += l.Backend
+	s.stats.BackendMax = max(s.stats.BackendMax, l.Backend)
+}
+
+// EnforcementStats returns a snapshot of ban latency stats.
+func (s *Firewall) EnforcementStats() EnforcementStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	return s.stats
+}
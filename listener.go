@@ -0,0 +1,66 @@
+package firewall
+
+import "net"
+
+// Listen wraps inner so the connections of ips jailed by fw are closed as
+// soon as they are accepted, before any TLS handshake or protocol
+// processing. It protects servers which are not http, e.g. SMTP or game
+// servers.
+func Listen(inner net.Listener, fw *Firewall) net.Listener {
+	return &listener{Listener: inner, fw: fw}
+}
+
+type listener struct {
+	net.Listener
+	fw *Firewall
+}
+
+// Accept returns the next connection of an ip not banned.
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if ip := addrIP(c.RemoteAddr()); ip != nil && l.fw.IsBannedIP(ip) {
+			c.Close()
+			continue
+		}
+		return c, nil
+	}
+}
+
+// IsBannedAddr reports whether the ip of addr is jailed by this firewall.
+// It fits quic-go's Transport.VerifySourceAddress, which makes jailed ips
+// validate their address with a Retry before any handshake work. Use
+// ListenPacket to drop their packets instead.
+func (s *Firewall) IsBannedAddr(addr net.Addr) bool {
+	ip := addrIP(addr)
+	return ip != nil && s.IsBannedIP(ip)
+}
+
+// ListenPacket wraps inner so packets from ips jailed by fw are dropped on
+// receive, e.g. the UDP socket passed to a quic-go Transport or a DNS
+// server. HTTP middleware can not protect QUIC endpoints as the handshake
+// happens before any request.
+//
+// The wrapper hides the optional interfaces of *net.UDPConn, so quic-go
+// falls back to plain reads without ECN or GSO.
+func ListenPacket(inner net.PacketConn, fw *Firewall) net.PacketConn {
+	return &packetConn{PacketConn: inner, fw: fw}
+}
+
+type packetConn struct {
+	net.PacketConn
+	fw *Firewall
+}
+
+// ReadFrom returns the next packet from an ip not banned.
+func (c *packetConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || !c.fw.IsBannedAddr(addr) {
+			return n, addr, err
+		}
+	}
+}
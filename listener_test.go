@@ -0,0 +1,125 @@
+package firewall
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockConn struct {
+	net.Conn
+	remote net.Addr
+	closed bool
+}
+
+func (c *mockConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *mockConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+type mockListener struct {
+	net.Listener
+	conns []net.Conn
+}
+
+func (l *mockListener) Accept() (net.Conn, error) {
+	if len(l.conns) == 0 {
+		return nil, net.ErrClosed
+	}
+	c := l.conns[0]
+	l.conns = l.conns[1:]
+	return c, nil
+}
+
+func TestListen(t *testing.T) {
+	mockFW := &MockNetworkFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.2.3.4", 10, "r")
+	require.NoError(t, fw.BanNetwork("2001:db8::/32", 10, "r"))
+	mockLogger.Wg.Wait()
+
+	banned := &mockConn{remote: &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1}}
+	bannedNetwork := &mockConn{remote: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}}
+	allowed := &mockConn{remote: &net.TCPAddr{IP: net.ParseIP("1.2.3.5"), Port: 1}}
+	l := Listen(&mockListener{conns: []net.Conn{banned, bannedNetwork, allowed}}, fw)
+
+	c, err := l.Accept()
+	require.NoError(t, err)
+	assert.Same(t, allowed, c)
+	assert.True(t, banned.closed)
+	assert.True(t, bannedNetwork.closed)
+	assert.False(t, allowed.closed)
+
+	_, err = l.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+
+	// unbanned ips are accepted again
+	mockLogger.Wg.Add(1)
+	fw.UnbanIP("1.2.3.4", "false positive")
+	mockLogger.Wg.Wait()
+	assert.False(t, fw.IsBannedIP(net.ParseIP("1.2.3.4")))
+	assert.True(t, fw.IsBannedIP(net.ParseIP("2001:db8:1::1")))
+	assert.False(t, fw.IsBannedIP(net.ParseIP("::ffff:1.2.3.4")))
+}
+
+type mockPacket struct {
+	data string
+	addr net.Addr
+}
+
+type mockPacketConn struct {
+	net.PacketConn
+	packets []mockPacket
+}
+
+func (c *mockPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	if len(c.packets) == 0 {
+		return 0, nil, net.ErrClosed
+	}
+	pkt := c.packets[0]
+	c.packets = c.packets[1:]
+	return copy(p, pkt.data), pkt.addr, nil
+}
+
+func TestListenPacket(t *testing.T) {
+	mockFW := &MockNetworkFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.2.3.4", 10, "r")
+	require.NoError(t, fw.BanNetwork("2001:db8::/32", 10, "r"))
+	mockLogger.Wg.Wait()
+
+	banned := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1}
+	allowed := &net.UDPAddr{IP: net.ParseIP("1.2.3.5"), Port: 1}
+	assert.True(t, fw.IsBannedAddr(banned))
+	assert.True(t, fw.IsBannedAddr(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}))
+	assert.False(t, fw.IsBannedAddr(allowed))
+
+	c := ListenPacket(&mockPacketConn{packets: []mockPacket{
+		{data: "a", addr: banned},
+		{data: "b", addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}},
+		{data: "c", addr: allowed},
+	}}, fw)
+
+	buf := make([]byte, 8)
+	n, addr, err := c.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "c", string(buf[:n]))
+	assert.Equal(t, allowed, addr)
+
+	_, _, err = c.ReadFrom(buf)
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
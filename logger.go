@@ -0,0 +1,62 @@
+package firewall
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+var (
+	_ IEventLogger = MultiLogger(nil)
+	_ IFlushLogger = MultiLogger(nil)
+)
+
+// MultiLogger sends events to all loggers, e.g. GCP logging and a local
+// zerolog file. A logger panicking is recovered, so the other loggers and
+// the firewall loop keep running.
+type MultiLogger []ILogger
+
+func (m MultiLogger) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	m.LogEvent(&Event{
+		IP:        ip,
+		JailUntil: jailUntil,
+		Reasons:   reasons,
+		Action:    action,
+		Geo:       geo,
+	})
+}
+
+func (m MultiLogger) LogEvent(e *Event) {
+	for _, l := range m {
+		logTo(l, e)
+	}
+}
+
+// Flush flushes the loggers implementing IFlushLogger.
+func (m MultiLogger) Flush() error {
+	var errs []error
+	for _, l := range m {
+		if f, ok := l.(IFlushLogger); ok {
+			if err := f.Flush(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func logTo(l ILogger, e *Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("logger %T panicked on %s event: %v", l, e.Action, r)
+		}
+	}()
+
+	if el, ok := l.(IEventLogger); ok {
+		el.LogEvent(e)
+		return
+	}
+	l.Log(e.IP, e.JailUntil, e.Reasons, e.Action, e.Geo)
+}
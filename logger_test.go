@@ -0,0 +1,37 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+type panicLogger struct{}
+
+func (panicLogger) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	panic("sink down")
+}
+
+func TestMultiLogger(t *testing.T) {
+	plain := &MockILogger{}
+	events := &MockEventLogger{}
+	mockFW := &MockIFirewall{}
+	fw, err := New([]string{}, mockFW, MultiLogger{panicLogger{}, plain, events}, nil, testForgivable, WithLabels(map[string]string{"tenant": "a"}))
+	require.NoError(t, err)
+
+	plain.Wg.Add(2)
+	events.Wg.Add(2)
+	fw.BanIP("1.2.3.4", 10, "r")
+	fw.BanIP("1.2.3.5", 10, "r")
+	plain.Wg.Wait()
+	events.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.4", "1.2.3.5"}, mockFW.BannedIPs)
+	assert.Equal(t, "ban", plain.Logs[1].Action)
+	assert.Equal(t, "1.2.3.5", events.Events[1].IP)
+	assert.Equal(t, map[string]string{"tenant": "a"}, events.Events[1].Labels)
+}
@@ -0,0 +1,258 @@
+// Package luahook runs Lua scripts at the hooks of the firewall, so errors
+// and bans can be handled by custom logic without recompiling, like the
+// actions of fail2ban.
+//
+// A script defines any of the global functions:
+//
+//	-- e: ip, reason, target, time, offenses, country, asn, bogon
+//	-- returns "count" (default), "ignore", or "ban" with optional minutes,
+//	-- reason and action ("block", "rate-limit", "tarpit" or "tag").
+//	function on_error(e) return "ban", 60, "sql injection", "tarpit" end
+//
+//	-- b: ip, minutes, reasons, country, asn, bogon. Change b.minutes, append to
+//	-- b.reasons, return false to veto the ban.
+//	function before_ban(b) b.minutes = b.minutes * 2 end
+//
+//	-- e: ip, until, reasons, country, asn, bogon, correlation_id
+//	function after_ban(e) print("banned " .. e.ip) end
+//
+// Scripts are sandboxed: only the base, table, string and math libraries
+// are loaded, without loading files or code, and each call is bounded by
+// callTimeout.
+package luahook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+var (
+	_ firewall.IPolicy      = (*Script)(nil)
+	_ firewall.IBanFilter   = (*Script)(nil)
+	_ firewall.IEventLogger = (*Script)(nil)
+)
+
+// callTimeout bounds a hook call, hooks run in the firewall loop.
+const callTimeout = 100 * time.Millisecond
+
+// unsafe base functions removed from the sandbox.
+var removedGlobals = []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage"}
+
+// Script is a loaded Lua script. Calls are serialized, a Lua state is
+// single threaded.
+type Script struct {
+	name string
+
+	mu sync.Mutex
+	l  *lua.LState
+}
+
+// Open loads the script at path.
+func Open(path string) (*Script, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(filepath.Base(path), string(b))
+}
+
+// Load runs source in a new sandbox, defining its hooks.
+func Load(name, source string) (*Script, error) {
+	l := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		l.Push(l.NewFunction(lib.fn))
+		l.Push(lua.LString(lib.name))
+		l.Call(1, 0)
+	}
+	for _, g := range removedGlobals {
+		l.SetGlobal(g, lua.LNil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	l.SetContext(ctx)
+	defer l.RemoveContext()
+
+	if err := l.DoString(source); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("load %s failed: %w", name, err)
+	}
+	return &Script{name: name, l: l}, nil
+}
+
+// Close releases the Lua state.
+func (s *Script) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.l.Close()
+}
+
+// call calls the global function fn with arg, it returns nil values if fn
+// is not defined.
+func (s *Script) call(fn string, arg *lua.LTable, nret int) ([]lua.LValue, error) {
+	f, ok := s.l.GetGlobal(fn).(*lua.LFunction)
+	if !ok {
+		return make([]lua.LValue, nret), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	s.l.SetContext(ctx)
+	defer s.l.RemoveContext()
+
+	if err := s.l.CallByParam(lua.P{Fn: f, NRet: nret, Protect: true}, arg); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", s.name, fn, err)
+	}
+	res := make([]lua.LValue, nret)
+	for i := nret - 1; i >= 0; i-- {
+		res[i] = s.l.Get(-1)
+		s.l.Pop(1)
+	}
+	return res, nil
+}
+
+func setGeo(t *lua.LTable, geo *ipgeo.IPGeo) {
+	if geo == nil {
+		return
+	}
+	t.RawSetString("country", lua.LString(geo.Country))
+	t.RawSetString("asn", lua.LNumber(geo.AutonomousSystemNumber))
+	t.RawSetString("bogon", lua.LBool(geo.Bogon))
+}
+
+func (s *Script) stringList(list []string) *lua.LTable {
+	t := s.l.NewTable()
+	for _, it := range list {
+		t.Append(lua.LString(it))
+	}
+	return t
+}
+
+// Decide calls on_error.
+func (s *Script) Decide(in *firewall.PolicyInput) (firewall.Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.l.NewTable()
+	e.RawSetString("ip", lua.LString(in.IP))
+	e.RawSetString("reason", lua.LString(in.Reason))
+	e.RawSetString("target", lua.LString(in.Target))
+	e.RawSetString("time", lua.LNumber(in.Time.Unix()))
+	e.RawSetString("offenses", lua.LNumber(in.Offenses))
+	setGeo(e, in.Geo)
+
+	res, err := s.call("on_error", e, 4)
+	if err != nil {
+		return firewall.Decision{}, err
+	}
+
+	d := firewall.Decision{}
+	switch v := res[0]; v {
+	case nil, lua.LNil, lua.LString("count"):
+		d.Verdict = firewall.VerdictCount
+	case lua.LString("ignore"):
+		d.Verdict = firewall.VerdictIgnore
+	case lua.LString("ban"):
+		d.Verdict = firewall.VerdictBan
+	default:
+		return firewall.Decision{}, fmt.Errorf("%s on_error: unknown verdict %s", s.name, v)
+	}
+	if n, ok := res[1].(lua.LNumber); ok {
+		d.Minutes = int(n)
+	}
+	if r, ok := res[2].(lua.LString); ok {
+		d.Reason = string(r)
+	}
+	if a, ok := res[3].(lua.LString); ok {
+		if d.BanAction, err = firewall.ParseBanAction(string(a)); err != nil {
+			return firewall.Decision{}, fmt.Errorf("%s on_error: %w", s.name, err)
+		}
+	}
+	return d, nil
+}
+
+// BeforeBan calls before_ban.
+func (s *Script) BeforeBan(b *firewall.PendingBan) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.l.NewTable()
+	t.RawSetString("ip", lua.LString(b.IP))
+	t.RawSetString("minutes", lua.LNumber(b.Minutes))
+	t.RawSetString("reasons", s.stringList(b.Reasons))
+	setGeo(t, b.Geo)
+
+	res, err := s.call("before_ban", t, 1)
+	if err != nil {
+		return true, err
+	}
+	if res[0] == lua.LFalse {
+		return false, nil
+	}
+
+	n, ok := t.RawGetString("minutes").(lua.LNumber)
+	if !ok {
+		return true, errors.New(s.name + " before_ban: minutes should be a number")
+	}
+	reasons, ok := t.RawGetString("reasons").(*lua.LTable)
+	if !ok {
+		return true, errors.New(s.name + " before_ban: reasons should be a table")
+	}
+
+	b.Minutes = int(n)
+	b.Reasons = []string{}
+	reasons.ForEach(func(_, v lua.LValue) {
+		b.Reasons = append(b.Reasons, v.String())
+	})
+	return true, nil
+}
+
+func (s *Script) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	s.LogEvent(&firewall.Event{
+		IP:        ip,
+		JailUntil: jailUntil,
+		Reasons:   reasons,
+		Action:    action,
+		Geo:       geo,
+	})
+}
+
+// LogEvent calls after_ban on "ban" events.
+func (s *Script) LogEvent(e *firewall.Event) {
+	if e.Action != "ban" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.l.NewTable()
+	t.RawSetString("ip", lua.LString(e.IP))
+	t.RawSetString("until", lua.LNumber(e.JailUntil.Unix()))
+	t.RawSetString("reasons", s.stringList(e.Reasons))
+	t.RawSetString("correlation_id", lua.LString(e.CorrelationID))
+	setGeo(t, e.Geo)
+
+	if _, err := s.call("after_ban", t, 0); err != nil {
+		log.Printf("%v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package luahook
+
+import (
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+const script = `
+banned = {}
+
+function on_error(e)
+  if string.find(e.reason, "sql injection") then
+    return "ban", 1440, "lua: sql injection"
+  end
+  if e.reason == "healthcheck" then
+    return "ignore"
+  end
+  if e.reason == "scanner" then
+    return "ban", nil, nil, "tarpit"
+  end
+end
+
+function before_ban(b)
+  if b.ip == "10.0.0.1" then
+    return false
+  end
+  if b.country == "XX" then
+    b.minutes = b.minutes * 2
+    table.insert(b.reasons, "lua: country XX")
+  end
+end
+
+function after_ban(e)
+  banned[#banned + 1] = e.ip
+end
+`
+
+func TestDecide(t *testing.T) {
+	s, err := Load("test.lua", script)
+	require.NoError(t, err)
+	defer s.Close()
+
+	tests := []struct {
+		reason string
+		want   firewall.Decision
+	}{
+		{"invalid password", firewall.Decision{Verdict: firewall.VerdictCount}},
+		{"sql injection in /login", firewall.Decision{Verdict: firewall.VerdictBan, Minutes: 1440, Reason: "lua: sql injection"}},
+		{"healthcheck", firewall.Decision{Verdict: firewall.VerdictIgnore}},
+		{"scanner", firewall.Decision{Verdict: firewall.VerdictBan, BanAction: firewall.BanActionTarpit}},
+	}
+	for _, tt := range tests {
+		got, err := s.Decide(&firewall.PolicyInput{IP: "1.2.3.4", Reason: tt.reason, Time: time.Now()})
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.reason)
+	}
+}
+
+func TestBeforeBan(t *testing.T) {
+	s, err := Load("test.lua", script)
+	require.NoError(t, err)
+	defer s.Close()
+
+	b := &firewall.PendingBan{IP: "1.2.3.4", Minutes: 10, Reasons: []string{"scanner"}, Geo: &ipgeo.IPGeo{Country: "XX"}}
+	ok, err := s.BeforeBan(b)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 20, b.Minutes)
+	assert.Equal(t, []string{"scanner", "lua: country XX"}, b.Reasons)
+
+	ok, err = s.BeforeBan(&firewall.PendingBan{IP: "10.0.0.1", Minutes: 10})
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestAfterBan(t *testing.T) {
+	s, err := Load("test.lua", script)
+	require.NoError(t, err)
+	defer s.Close()
+
+	s.LogEvent(&firewall.Event{IP: "1.2.3.4", Action: "count error"})
+	s.LogEvent(&firewall.Event{IP: "1.2.3.5", Action: "ban"})
+
+	banned := s.l.GetGlobal("banned").(*lua.LTable)
+	assert.Equal(t, 1, banned.Len())
+	assert.Equal(t, "1.2.3.5", banned.RawGetInt(1).String())
+}
+
+func TestNoHooks(t *testing.T) {
+	s, err := Load("empty.lua", "")
+	require.NoError(t, err)
+	defer s.Close()
+
+	d, err := s.Decide(&firewall.PolicyInput{IP: "1.2.3.4"})
+	require.NoError(t, err)
+	assert.Equal(t, firewall.VerdictCount, d.Verdict)
+
+	b := &firewall.PendingBan{IP: "1.2.3.4", Minutes: 10, Reasons: []string{"r"}}
+	ok, err := s.BeforeBan(b)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 10, b.Minutes)
+	assert.Equal(t, []string{"r"}, b.Reasons)
+}
+
+func TestSandbox(t *testing.T) {
+	for _, src := range []string{
+		`os.exit(1)`,
+		`io.open("/etc/passwd")`,
+		`dofile("/etc/passwd")`,
+		`require("os")`,
+		`load("return 1")()`,
+	} {
+		_, err := Load("bad.lua", src)
+		assert.Error(t, err, src)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	_, err := Load("loop.lua", `while true do end`)
+	assert.Error(t, err)
+
+	s, err := Load("loop.lua", `function on_error(e) while true do end end`)
+	require.NoError(t, err)
+	defer s.Close()
+
+	start := time.Now()
+	_, err = s.Decide(&firewall.PolicyInput{IP: "1.2.3.4"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// the script is still usable after a timeout.
+	_, err = s.Decide(&firewall.PolicyInput{IP: "1.2.3.4"})
+	assert.Error(t, err)
+}
+
+func TestInvalidVerdict(t *testing.T) {
+	s, err := Load("bad.lua", `function on_error(e) return "maybe" end`)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.Decide(&firewall.PolicyInput{IP: "1.2.3.4"})
+	assert.ErrorContains(t, err, "unknown verdict")
+}
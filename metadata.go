@@ -0,0 +1,39 @@
+package firewall
+
+import (
+	"maps"
+	"slices"
+)
+
+// MaxMetadataKeys caps the keys of metadata, the first keys in order are
+// kept. Values are capped like reasons.
+const MaxMetadataKeys = 16
+
+// BanIPWithMetadata bans ip like BanIP, the ban and its events carry md,
+// e.g. {"path": "/wp-login.php", "user_agent": "..."}.
+func (s *Firewall) BanIPWithMetadata(ip string, timeoutInMinute int, reason string, md map[string]string) {
+	s.banIP(ip, timeoutInMinute, reason, nil, capMetadata(md))
+}
+
+// LogIPErrorWithMetadata counts an error like LogIPTargetError, its "count
+// error" event and the offense in the ban it leads to carry md, e.g.
+// {"username": "admin"}.
+func (s *Firewall) LogIPErrorWithMetadata(ip string, target string, reason string, md map[string]string) {
+	s.logIPError(ip, target, reason, capMetadata(md))
+}
+
+// capMetadata copies md, callers may reuse their map, with at most
+// MaxMetadataKeys keys and values truncated. It returns nil for empty md.
+func capMetadata(md map[string]string) map[string]string {
+	if len(md) == 0 {
+		return nil
+	}
+	res := map[string]string{}
+	for _, k := range slices.Sorted(maps.Keys(md)) {
+		if len(res) == MaxMetadataKeys {
+			break
+		}
+		res[truncateReason(k)] = truncateReason(md[k])
+	}
+	return res
+}
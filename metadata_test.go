@@ -0,0 +1,51 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, &MockIFirewall{}, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 2, BanInMinute: 10})
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.LogIPErrorWithMetadata("1.2.3.4", "", "bad password", map[string]string{"username": "root"})
+	fw.LogIPErrorWithMetadata("1.2.3.4", "", "bad password", map[string]string{"username": "admin"})
+	fw.LogIPErrorWithMetadata("1.2.3.4", "", "bad password", map[string]string{"username": "oracle"})
+	mockLogger.Wg.Wait()
+	mockLogger.Wg.Add(1)
+	fw.BanIPWithMetadata("1.2.3.5", 10, "scanner", map[string]string{"path": "/wp-login.php"})
+	mockLogger.Wg.Wait()
+
+	require.Len(t, mockLogger.Events, 4)
+	assert.Equal(t, "count error", mockLogger.Events[0].Action)
+	assert.Equal(t, map[string]string{"username": "root"}, mockLogger.Events[0].Metadata)
+	// the ban carries the metadata of the error deciding it
+	assert.Equal(t, "ban", mockLogger.Events[2].Action)
+	assert.Equal(t, map[string]string{"username": "oracle"}, mockLogger.Events[2].Metadata)
+	assert.Equal(t, "ban", mockLogger.Events[3].Action)
+	assert.Equal(t, map[string]string{"path": "/wp-login.php"}, mockLogger.Events[3].Metadata)
+}
+
+func TestCapMetadata(t *testing.T) {
+	assert.Nil(t, capMetadata(map[string]string{}))
+
+	md := map[string]string{"user_agent": strings.Repeat("a", 1000)}
+	for i := range 20 {
+		md[fmt.Sprintf("k%02d", i)] = "v"
+	}
+	got := capMetadata(md)
+	assert.Len(t, got, MaxMetadataKeys)
+	assert.NotContains(t, got, "user_agent")
+	assert.Equal(t, "v", got["k00"])
+
+	got = capMetadata(map[string]string{"user_agent": strings.Repeat("a", 1000)})
+	assert.Len(t, got["user_agent"], maxReasonLen+3)
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/charleshuang3/firewall"
+)
+
+// firewallCollector reads the state of the watched firewalls on scrape.
+type firewallCollector struct {
+	mu  sync.Mutex
+	fws map[string]*firewall.Firewall
+
+	jailed        *prometheus.Desc
+	whitelistHits *prometheus.Desc
+	dropped       *prometheus.Desc
+}
+
+func newFirewallCollector() *firewallCollector {
+	return &firewallCollector{
+		fws: map[string]*firewall.Firewall{},
+		jailed: prometheus.NewDesc("firewall_jailed",
+			"Ips and networks currently jailed, by tenant.", []string{"tenant"}, nil),
+		whitelistHits: prometheus.NewDesc("firewall_whitelist_hits_total",
+			"Bans and errors ignored as the ip is whitelisted, by tenant.", []string{"tenant"}, nil),
+		dropped: prometheus.NewDesc("firewall_queue_dropped_total",
+			"Bans and errors dropped as the queue to the firewall loop was full, by tenant.", []string{"tenant"}, nil),
+	}
+}
+
+// Watch exports the jailed ips, whitelist hits and queue drops of fw with
+// the tenant label, empty for the firewall of no tenant.
+func (m *Metrics) Watch(tenant string, fw *firewall.Firewall) {
+	m.firewalls.mu.Lock()
+	defer m.firewalls.mu.Unlock()
+	m.firewalls.fws[tenant] = fw
+}
+
+func (c *firewallCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.jailed
+	ch <- c.whitelistHits
+	ch <- c.dropped
+}
+
+func (c *firewallCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for tenant, fw := range c.fws {
+		ch <- prometheus.MustNewConstMetric(c.jailed, prometheus.GaugeValue, float64(fw.JailedCount()), tenant)
+		ch <- prometheus.MustNewConstMetric(c.whitelistHits, prometheus.CounterValue, float64(fw.WhitelistHits()), tenant)
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(fw.Dropped()), tenant)
+	}
+}
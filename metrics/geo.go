@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+// GeoDB is a geo database reporting its stats, e.g.
+// ipgeo.AutoUpdateMMIPGeo.
+type GeoDB interface {
+	Stats() ipgeo.Stats
+}
+
+// geoCollector reads the stats of a geo database on scrape.
+type geoCollector struct {
+	db GeoDB
+
+	buildTime        *prometheus.Desc
+	lastUpdate       *prometheus.Desc
+	sinceLastUpdate  *prometheus.Desc
+	updates          *prometheus.Desc
+	lookups          *prometheus.Desc
+	notFound         *prometheus.Desc
+	negativeCacheHit *prometheus.Desc
+	lookupLatency    *prometheus.Desc
+}
+
+// WatchGeo exports the build time of the databases of db, the time since
+// their last update, update results, lookups, ips not found and the lookup
+// latency, so a stale database gets noticed.
+func (m *Metrics) WatchGeo(db GeoDB) {
+	m.reg.MustRegister(&geoCollector{
+		db: db,
+		buildTime: prometheus.NewDesc("firewall_geo_build_timestamp_seconds",
+			"Build time of the loaded geo database, by database: city or asn.", []string{"db"}, nil),
+		lastUpdate: prometheus.NewDesc("firewall_geo_last_update_timestamp_seconds",
+			"Time the geo databases were last loaded.", nil, nil),
+		sinceLastUpdate: prometheus.NewDesc("firewall_geo_since_last_update_seconds",
+			"Time since the geo databases were last loaded.", nil, nil),
+		updates: prometheus.NewDesc("firewall_geo_updates_total",
+			"Geo database updates by result: success or failure.", []string{"result"}, nil),
+		lookups: prometheus.NewDesc("firewall_geo_lookups_total",
+			"Geo lookups in the databases.", nil, nil),
+		notFound: prometheus.NewDesc("firewall_geo_not_found_total",
+			"Geo lookups of ips missing from the databases.", nil, nil),
+		negativeCacheHit: prometheus.NewDesc("firewall_geo_negative_cache_hits_total",
+			"Geo lookups answered by the cache of ips missing from the databases.", nil, nil),
+		lookupLatency: prometheus.NewDesc("firewall_geo_lookup_latency_seconds",
+			"Latency of geo lookups in the databases.", nil, nil),
+	})
+}
+
+func (c *geoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.buildTime
+	ch <- c.lastUpdate
+	ch <- c.sinceLastUpdate
+	ch <- c.updates
+	ch <- c.lookups
+	ch <- c.notFound
+	ch <- c.negativeCacheHit
+	ch <- c.lookupLatency
+}
+
+func (c *geoCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.db.Stats()
+
+	// the asn database is optional
+	if !s.CityBuildEpoch.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.buildTime, prometheus.GaugeValue, unixSeconds(s.CityBuildEpoch), "city")
+	}
+	if !s.ASNBuildEpoch.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.buildTime, prometheus.GaugeValue, unixSeconds(s.ASNBuildEpoch), "asn")
+	}
+	ch <- prometheus.MustNewConstMetric(c.lastUpdate, prometheus.GaugeValue, unixSeconds(s.LastUpdate))
+	ch <- prometheus.MustNewConstMetric(c.sinceLastUpdate, prometheus.GaugeValue, time.Since(s.LastUpdate).Seconds())
+	ch <- prometheus.MustNewConstMetric(c.updates, prometheus.CounterValue, float64(s.UpdateSuccesses), "success")
+	ch <- prometheus.MustNewConstMetric(c.updates, prometheus.CounterValue, float64(s.UpdateFailures), "failure")
+	ch <- prometheus.MustNewConstMetric(c.lookups, prometheus.CounterValue, float64(s.Lookups))
+	ch <- prometheus.MustNewConstMetric(c.notFound, prometheus.CounterValue, float64(s.NotFound))
+	ch <- prometheus.MustNewConstMetric(c.negativeCacheHit, prometheus.CounterValue, float64(s.NegativeCacheHits))
+
+	// Stats buckets are not cumulative, the last one is above all bounds
+	buckets := map[float64]uint64{}
+	var count uint64
+	for i, n := range s.LookupLatency {
+		count += n
+		if i < len(ipgeo.LookupLatencyBounds) {
+			buckets[ipgeo.LookupLatencyBounds[i].Seconds()] = count
+		}
+	}
+	ch <- prometheus.MustNewConstHistogram(c.lookupLatency, count, s.LookupLatencySum.Seconds(), buckets)
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
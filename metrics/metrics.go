@@ -0,0 +1,156 @@
+// Package metrics exposes firewall events as prometheus metrics in the
+// OpenMetrics format.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+var _ firewall.IEventLogger = (*Metrics)(nil)
+
+// Metrics is an ILogger which counts events and observes ban latency. Ban
+// latency samples carry the correlation id of the ban as exemplar.
+type Metrics struct {
+	reg *prometheus.Registry
+
+	events         *prometheus.CounterVec
+	bans           *prometheus.CounterVec
+	backendErrors  *prometheus.CounterVec
+	banLatency     *prometheus.HistogramVec
+	ingestRejected *prometheus.CounterVec
+	firewalls      *firewallCollector
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		reg: prometheus.NewRegistry(),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "firewall",
+			Name:      "events_total",
+			Help:      "Firewall events by action.",
+		}, []string{"action"}),
+		bans: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "firewall",
+			Name:      "bans_total",
+			Help:      "Bans by country ISO code, empty without geo data.",
+		}, []string{"country"}),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "firewall",
+			Name:      "backend_errors_total",
+			Help:      "Failed backend calls by backend and error class.",
+		}, []string{"backend", "class"}),
+		banLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "firewall",
+			Name:      "ban_latency_seconds",
+			Help:      "Time from ban decision to backend confirmation, by stage: queue, backend and total.",
+			Buckets:   []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"stage"}),
+		ingestRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "firewall",
+			Name:      "ingest_rejected_total",
+			Help:      "Ingest requests rejected by quota, by source and kind: events or bans.",
+		}, []string{"source", "kind"}),
+		firewalls: newFirewallCollector(),
+	}
+
+	m.reg.MustRegister(m.events, m.bans, m.backendErrors, m.banLatency, m.ingestRejected, m.firewalls)
+	return m
+}
+
+// Registry returns the registry of the metrics, to register more
+// collectors.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.reg
+}
+
+// LogQueue is a logger sending events from a bounded queue, e.g.
+// gcplog.Logger.
+type LogQueue interface {
+	QueueDepth() int
+	Dropped() uint64
+}
+
+// WatchLogQueue exports the depth and drops of the queue of logger.
+func (m *Metrics) WatchLogQueue(logger string, q LogQueue) {
+	labels := prometheus.Labels{"logger": logger}
+	m.reg.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   "firewall",
+			Name:        "log_queue_depth",
+			Help:        "Events waiting to be sent by the logger.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(q.QueueDepth()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   "firewall",
+			Name:        "log_dropped_total",
+			Help:        "Events dropped by the logger as its queue was full.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(q.Dropped()) }),
+	)
+}
+
+// Handler serves the metrics, OpenMetrics is negotiated so exemplars are
+// included.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})
+}
+
+func (m *Metrics) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	m.LogEvent(&firewall.Event{
+		IP:        ip,
+		JailUntil: jailUntil,
+		Reasons:   reasons,
+		Action:    action,
+		Geo:       geo,
+	})
+}
+
+func (m *Metrics) LogEvent(e *firewall.Event) {
+	m.events.WithLabelValues(e.Action).Inc()
+	switch e.Action {
+	case "ban":
+		country := ""
+		if e.Geo != nil {
+			country = e.Geo.CountryISO
+		}
+		m.bans.WithLabelValues(country).Inc()
+	case "backend-error":
+		if be := e.Backend; be != nil {
+			m.backendErrors.WithLabelValues(be.Backend, be.Class()).Inc()
+		}
+	}
+
+	if l := e.Latency; l != nil {
+		var exemplar prometheus.Labels
+		if e.CorrelationID != "" {
+			exemplar = prometheus.Labels{"correlation_id": e.CorrelationID}
+		}
+		m.observe("queue", l.Queue, exemplar)
+		m.observe("backend", l.Backend, exemplar)
+		m.observe("total", l.Total(), exemplar)
+	}
+}
+
+func (m *Metrics) observe(stage string, d time.Duration, exemplar prometheus.Labels) {
+	o := m.banLatency.WithLabelValues(stage)
+	if exemplar == nil {
+		o.Observe(d.Seconds())
+		return
+	}
+	o.(prometheus.ExemplarObserver).ObserveWithExemplar(d.Seconds(), exemplar)
+}
+
+// IngestRejected counts an ingest request of source rejected by the quota
+// of kind.
+func (m *Metrics) IngestRejected(source, kind string) {
+	m.ingestRejected.WithLabelValues(source, kind).Inc()
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+	"github.com/charleshuang3/firewall/ipgeo/ipgeotest"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.Log("10.0.0.1", time.Time{}, []string{"r"}, "count error", nil)
+	m.LogEvent(&firewall.Event{
+		IP:            "10.0.0.1",
+		Action:        "ban",
+		Latency:       &firewall.Latency{Queue: time.Millisecond, Backend: 2 * time.Second},
+		CorrelationID: "3f1c2a9e-0b7d-4c55-9a0e-2f4b6d8c1e3a",
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	assert.Contains(t, body, `firewall_events_total{action="ban"} 1`)
+	assert.Contains(t, body, `firewall_events_total{action="count error"} 1`)
+	assert.Contains(t, body, `firewall_ban_latency_seconds_count{stage="backend"} 1`)
+
+	// exemplar on the bucket the sample falls in
+	found := false
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, `firewall_ban_latency_seconds_bucket{stage="backend",le="2.5"}`) {
+			found = strings.Contains(line, `# {correlation_id="3f1c2a9e-0b7d-4c55-9a0e-2f4b6d8c1e3a"} 2`)
+		}
+	}
+	assert.True(t, found, body)
+}
+
+func TestMetrics_BansAndBackendErrors(t *testing.T) {
+	m := New()
+	m.LogEvent(&firewall.Event{IP: "10.0.0.1", Action: "ban", Geo: &ipgeo.IPGeo{CountryISO: "KP"}})
+	m.LogEvent(&firewall.Event{IP: "10.0.0.2", Action: "ban", Geo: &ipgeo.IPGeo{CountryISO: "KP"}})
+	m.LogEvent(&firewall.Event{IP: "10.0.0.3", Action: "ban"})
+	m.LogEvent(&firewall.Event{
+		IP:      "10.0.0.1",
+		Action:  "backend-error",
+		Backend: &firewall.BackendError{Backend: "opn", Op: "ban", Attempt: 1, Err: &firewall.StatusError{Code: http.StatusForbidden}},
+	})
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `firewall_bans_total{country="KP"} 2`)
+	assert.Contains(t, body, `firewall_bans_total{country=""} 1`)
+	assert.Contains(t, body, `firewall_backend_errors_total{backend="opn",class="auth"} 1`)
+}
+
+func TestMetrics_Watch(t *testing.T) {
+	fw, err := firewall.New([]string{"10.0.0.0/8"}, &mockFirewall{}, firewall.MultiLogger{}, nil, firewall.ForgivableError{Duration: time.Minute, Count: 1, BanInMinute: 5})
+	require.NoError(t, err)
+	defer fw.Close(context.Background())
+
+	fw.BanIP("1.2.3.4", 10, "r")
+	fw.BanIP("10.0.0.1", 10, "r")
+	fw.LogIPError("10.0.0.2", "r")
+	// IsBanned waits for the loop
+	banned, _ := fw.IsBanned("1.2.3.4")
+	require.True(t, banned)
+
+	m := New()
+	m.Watch("blog", fw)
+	body := scrape(t, m)
+	assert.Contains(t, body, `firewall_jailed{tenant="blog"} 1`)
+	assert.Contains(t, body, `firewall_whitelist_hits_total{tenant="blog"} 2`)
+	assert.Contains(t, body, `firewall_queue_dropped_total{tenant="blog"} 0`)
+}
+
+type logQueue struct{}
+
+func (logQueue) QueueDepth() int { return 7 }
+func (logQueue) Dropped() uint64 { return 3 }
+
+func TestMetrics_WatchLogQueue(t *testing.T) {
+	m := New()
+	m.WatchLogQueue("gcplog", logQueue{})
+	body := scrape(t, m)
+	assert.Contains(t, body, `firewall_log_queue_depth{logger="gcplog"} 7`)
+	assert.Contains(t, body, `firewall_log_dropped_total{logger="gcplog"} 3`)
+}
+
+func TestMetrics_WatchGeo(t *testing.T) {
+	city, asn, err := ipgeotest.Write(t.TempDir(), map[string]*ipgeo.IPGeo{
+		"1.2.3.0/24": {CountryISO: "KP", Country: "North Korea"},
+	})
+	require.NoError(t, err)
+	geo, err := ipgeo.NewAutoUpdateMMIPGeo(city, city, asn, asn)
+	require.NoError(t, err)
+	defer geo.Close()
+
+	require.NotNil(t, geo.GetIPGeo("1.2.3.4"))
+	geo.GetIPGeo("5.6.7.8")
+
+	m := New()
+	m.WatchGeo(geo)
+	body := scrape(t, m)
+	assert.Contains(t, body, `firewall_geo_build_timestamp_seconds{db="city"}`)
+	assert.Contains(t, body, `firewall_geo_build_timestamp_seconds{db="asn"}`)
+	assert.Contains(t, body, `firewall_geo_last_update_timestamp_seconds`)
+	assert.Contains(t, body, `firewall_geo_since_last_update_seconds`)
+	assert.Contains(t, body, `firewall_geo_updates_total{result="failure"} 0`)
+	assert.Contains(t, body, `firewall_geo_lookups_total 2`)
+	assert.Contains(t, body, `firewall_geo_not_found_total 1`)
+	assert.Contains(t, body, `firewall_geo_lookup_latency_seconds_count 2`)
+	assert.Contains(t, body, `firewall_geo_lookup_latency_seconds_bucket{le="+Inf"} 2`)
+}
+
+type mockFirewall struct{}
+
+func (mockFirewall) BanIP(ip string, timeoutInMinute int) {}
+
+func scrape(t *testing.T, m *Metrics) string {
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// TLSConfig of the metrics listener. ClientCAFile enables mTLS: scrapers must
+// present a certificate signed by it.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+func (c *TLSConfig) build() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load metrics tls cert failed: %w", err)
+	}
+
+	res := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		b, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read metrics client ca failed: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate in %s", c.ClientCAFile)
+		}
+		res.ClientCAs = pool
+		res.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return res, nil
+}
+
+// NewServer returns a dedicated http server for the metrics on addr. With
+// tlsConfig, the server must be started with ListenAndServeTLS("", "").
+func NewServer(addr string, h http.Handler, tlsConfig *TLSConfig) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if tlsConfig != nil {
+		c, err := tlsConfig.build()
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = c
+	}
+
+	return srv, nil
+}
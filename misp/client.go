@@ -0,0 +1,157 @@
+// Package misp pushes banned ips to a MISP event and pulls MISP attributes as
+// a blocklist source.
+package misp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/charleshuang3/firewall"
+)
+
+// Client is a MISP api client authenticated with an api key. Requests are
+// rate limited, they wait for the limiter.
+type Client struct {
+	url     string
+	key     string
+	client  *http.Client
+	limiter *rate.Limiter
+}
+
+// NewClient returns a client allowing perMinute requests a minute.
+func NewClient(url, key string, perMinute int) *Client {
+	if perMinute <= 0 {
+		perMinute = 60
+	}
+	return &Client{
+		url:     url,
+		key:     key,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		limiter: rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), 1),
+	}
+}
+
+func (c *Client) do(ctx context.Context, path string, req, resp any) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", c.key)
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return &firewall.StatusError{Code: res.StatusCode, Body: string(body)}
+	}
+
+	if resp == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return fmt.Errorf("decode misp response failed: %w", err)
+	}
+	return nil
+}
+
+type tag struct {
+	Name string `json:"name"`
+}
+
+type attribute struct {
+	Type     string `json:"type"`
+	Category string `json:"category"`
+	Value    string `json:"value"`
+	Comment  string `json:"comment,omitempty"`
+	ToIDS    bool   `json:"to_ids"`
+	Tag      []tag  `json:"Tag,omitempty"`
+}
+
+// AddAttribute adds ip as an ip-src attribute to the event.
+func (c *Client) AddAttribute(ctx context.Context, eventID, ip, comment string, tags []string) error {
+	a := &attribute{
+		Type:     "ip-src",
+		Category: "Network activity",
+		Value:    ip,
+		Comment:  comment,
+		ToIDS:    true,
+	}
+	for _, t := range tags {
+		a.Tag = append(a.Tag, tag{Name: t})
+	}
+	return c.do(ctx, "/attributes/add/"+eventID, a, nil)
+}
+
+// Indicator is an ip attribute found in MISP.
+type Indicator struct {
+	IP   string
+	Tags []string
+}
+
+type searchRequest struct {
+	ReturnFormat string   `json:"returnFormat"`
+	Type         []string `json:"type"`
+	ToIDS        bool     `json:"to_ids"`
+	Tags         []string `json:"tags,omitempty"`
+	Last         string   `json:"last,omitempty"`
+}
+
+type searchResponse struct {
+	Response struct {
+		Attribute []struct {
+			Value string `json:"value"`
+			Tag   []tag  `json:"Tag"`
+		} `json:"Attribute"`
+	} `json:"response"`
+}
+
+// SearchIPs returns ip attributes for ids with any of tags, published in
+// the last duration, e.g. "1d".
+func (c *Client) SearchIPs(ctx context.Context, tags []string, last string) ([]Indicator, error) {
+	resp := &searchResponse{}
+	err := c.do(ctx, "/attributes/restSearch", &searchRequest{
+		ReturnFormat: "json",
+		Type:         []string{"ip-src", "ip-dst"},
+		ToIDS:        true,
+		Tags:         tags,
+		Last:         last,
+	}, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []Indicator{}
+	for _, a := range resp.Response.Attribute {
+		i := Indicator{IP: a.Value}
+		for _, t := range a.Tag {
+			i.Tags = append(i.Tags, t.Name)
+		}
+		res = append(res, i)
+	}
+	return res, nil
+}
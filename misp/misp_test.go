@@ -0,0 +1,101 @@
+package misp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+)
+
+type fakeMISP struct {
+	mu         sync.Mutex
+	attributes []attribute
+}
+
+func (f *fakeMISP) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /attributes/add/42", func(w http.ResponseWriter, r *http.Request) {
+		a := attribute{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&a))
+		f.mu.Lock()
+		f.attributes = append(f.attributes, a)
+		f.mu.Unlock()
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("POST /attributes/restSearch", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"Attribute":[
+			{"value":"1.2.3.4","Tag":[{"name":"tlp:white"}]},
+			{"value":"2001:db8::1","Tag":[{"name":"tlp:white"}]},
+			{"value":"example.com"}
+		]}}`))
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "key" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func TestPusher(t *testing.T) {
+	f := &fakeMISP{}
+	srv := httptest.NewServer(f.handler(t))
+	defer srv.Close()
+
+	p := NewPusher(NewClient(srv.URL, "key", 6000), "42", map[string]string{
+		"ssh":          "ssh",
+		"ssh: invalid": "brute-force",
+	}, []string{"source:firewall"})
+	p.LogEvent(&firewall.Event{IP: "1.2.3.4", Action: "count error", Reasons: []string{"ssh: invalid user"}})
+	p.LogEvent(&firewall.Event{IP: "1.2.3.5", Action: "ban", Reasons: []string{"ssh: invalid user", "web"}})
+	p.Close()
+
+	require.Len(t, f.attributes, 1)
+	a := f.attributes[0]
+	assert.Equal(t, "1.2.3.5", a.Value)
+	assert.Equal(t, "ip-src", a.Type)
+	assert.Equal(t, "ssh: invalid user; web", a.Comment)
+	assert.Equal(t, []tag{{"source:firewall"}, {"brute-force"}}, a.Tag)
+}
+
+type mockTarget struct {
+	bans    []string
+	reasons []string
+}
+
+func (m *mockTarget) BanIP(ip string, timeoutInMinute int, reason string) {
+	m.bans = append(m.bans, ip)
+	m.reasons = append(m.reasons, reason)
+}
+
+func TestPuller(t *testing.T) {
+	srv := httptest.NewServer((&fakeMISP{}).handler(t))
+	defer srv.Close()
+
+	target := &mockTarget{}
+	p := NewPuller(NewClient(srv.URL, "key", 6000), target, []string{"tlp:white"}, "1d", 60, time.Hour)
+
+	require.NoError(t, p.Pull(context.Background()))
+	require.NoError(t, p.Pull(context.Background()))
+	assert.Equal(t, []string{"1.2.3.4", "2001:db8::1"}, target.bans)
+	assert.Equal(t, []string{"misp: tlp:white", "misp: tlp:white"}, target.reasons)
+}
+
+func TestClient_Auth(t *testing.T) {
+	srv := httptest.NewServer((&fakeMISP{}).handler(t))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "wrong", 6000).SearchIPs(context.Background(), nil, "")
+	var se *firewall.StatusError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, http.StatusForbidden, se.Code)
+}
@@ -0,0 +1,106 @@
+package misp
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Target is where pulled ips are banned, a *firewall.Firewall.
+type Target interface {
+	BanIP(ip string, timeoutInMinute int, reason string)
+}
+
+// Puller bans the ip attributes of MISP with the given tags. An ip is
+// banned again only after its previous ban expired.
+type Puller struct {
+	client          *Client
+	target          Target
+	tags            []string
+	last            string
+	timeoutInMinute int
+	interval        time.Duration
+
+	mu sync.Mutex
+	// banned ips to ban expire time
+	banned map[string]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewPuller polls attributes with tags published in last, e.g. "1d", every
+// interval.
+func NewPuller(client *Client, target Target, tags []string, last string, timeoutInMinute int, interval time.Duration) *Puller {
+	return &Puller{
+		client:          client,
+		target:          target,
+		tags:            tags,
+		last:            last,
+		timeoutInMinute: timeoutInMinute,
+		interval:        interval,
+		banned:          map[string]time.Time{},
+	}
+}
+
+// Start pulls in background until Close.
+func (p *Puller) Start() {
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			if err := p.Pull(context.Background()); err != nil {
+				log.Printf("pull misp failed: %v", err)
+			}
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *Puller) Close() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+}
+
+// Pull fetches the attributes and bans the new ips.
+func (p *Puller) Pull(ctx context.Context) error {
+	indicators, err := p.client.SearchIPs(ctx, p.tags, p.last)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for ip, until := range p.banned {
+		if !until.After(now) {
+			delete(p.banned, ip)
+		}
+	}
+
+	for _, i := range indicators {
+		if net.ParseIP(i.IP) == nil {
+			continue
+		}
+		if _, ok := p.banned[i.IP]; ok {
+			continue
+		}
+		p.banned[i.IP] = now.Add(time.Duration(p.timeoutInMinute) * time.Minute)
+		p.target.BanIP(i.IP, p.timeoutInMinute, "misp: "+strings.Join(i.Tags, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package misp
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+var _ firewall.IEventLogger = (*Pusher)(nil)
+
+const pushQueueSize = 1024
+
+// Pusher is an IEventLogger adding banned ips to a MISP event. Bans are
+// queued and pushed in background, they are dropped when the queue is full
+// so a slow MISP never blocks the firewall.
+type Pusher struct {
+	client  *Client
+	eventID string
+	// tags maps a reason prefix to a MISP tag.
+	tags        map[string]string
+	defaultTags []string
+
+	queue chan *firewall.Event
+	done  chan struct{}
+}
+
+func NewPusher(client *Client, eventID string, tags map[string]string, defaultTags []string) *Pusher {
+	p := &Pusher{
+		client:      client,
+		eventID:     eventID,
+		tags:        tags,
+		defaultTags: defaultTags,
+		queue:       make(chan *firewall.Event, pushQueueSize),
+		done:        make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+// Close pushes the queued bans and stops.
+func (p *Pusher) Close() {
+	close(p.queue)
+	<-p.done
+}
+
+func (p *Pusher) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	p.LogEvent(&firewall.Event{
+		IP:        ip,
+		JailUntil: jailUntil,
+		Reasons:   reasons,
+		Action:    action,
+		Geo:       geo,
+	})
+}
+
+func (p *Pusher) LogEvent(e *firewall.Event) {
+	if e.Action != "ban" {
+		return
+	}
+	select {
+	case p.queue <- e:
+	default:
+		log.Printf("misp queue full, drop ban of %s", e.IP)
+	}
+}
+
+func (p *Pusher) loop() {
+	defer close(p.done)
+	for e := range p.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := p.client.AddAttribute(ctx, p.eventID, e.IP, strings.Join(e.Reasons, "; "), p.tagsOf(e.Reasons))
+		cancel()
+		if err != nil {
+			log.Printf("push %s to misp failed: %v", e.IP, err)
+		}
+	}
+}
+
+// tagsOf maps reasons to tags, a reason matches the tag of the longest
+// prefix.
+func (p *Pusher) tagsOf(reasons []string) []string {
+	res := append([]string{}, p.defaultTags...)
+	seen := map[string]bool{}
+	for _, t := range res {
+		seen[t] = true
+	}
+
+	for _, r := range reasons {
+		best := ""
+		for prefix := range p.tags {
+			if strings.HasPrefix(r, prefix) && len(prefix) > len(best) {
+				best = prefix
+			}
+		}
+		if best == "" {
+			continue
+		}
+		if t := p.tags[best]; !seen[t] {
+			seen[t] = true
+			res = append(res, t)
+		}
+	}
+	return res
+}
@@ -0,0 +1,182 @@
+// Package mitigation writes the jail as artifacts for manual submission to
+// upstream providers during large attacks: an ACL CSV for an upstream or
+// Arbor style filter, a Cloudflare IP list and a plain CIDR list. Bans are
+// aggregated into the fewest prefixes, see package cidr.
+package mitigation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/cidr"
+)
+
+// cloudflareMinV6Bits is the longest ipv6 prefix a Cloudflare IP list
+// accepts, longer ones are widened to it.
+const cloudflareMinV6Bits = 64
+
+// Entry is an aggregated prefix and the bans it covers.
+type Entry struct {
+	Prefix netip.Prefix
+	// Bans is the number of jailed ips and networks in Prefix.
+	Bans int
+	// Until is the end of the longest ban in Prefix.
+	Until time.Time
+	// Reasons of the bans in Prefix, by frequency.
+	Reasons []string
+}
+
+// Options of the aggregation, the zero value is exact.
+type Options struct {
+	// Slack is the fraction of the addresses of a prefix which may not be
+	// banned, see cidr.AggregateSlack.
+	Slack float64
+	// MaxEntries limits the number of prefixes if positive, see
+	// cidr.Limit.
+	MaxEntries int
+}
+
+// Aggregate groups bans by the aggregated prefixes covering them. Bans
+// which are not an ip or a cidr are skipped. maxV6Bits widens longer ipv6
+// prefixes, 128 keeps them.
+func Aggregate(bans []firewall.BanInfo, maxV6Bits int, o Options) []Entry {
+	prefixes := make([]netip.Prefix, 0, len(bans))
+	parsed := make([]netip.Prefix, len(bans))
+	for i, b := range bans {
+		p, ok := cidr.Parse(b.IP)
+		if !ok {
+			continue
+		}
+		if p.Addr().Is6() && p.Bits() > maxV6Bits {
+			p = netip.PrefixFrom(p.Addr(), maxV6Bits).Masked()
+		}
+		parsed[i] = p
+		prefixes = append(prefixes, p)
+	}
+
+	agg := cidr.AggregateSlack(prefixes, o.Slack)
+	if o.MaxEntries > 0 && len(agg) > o.MaxEntries {
+		agg = cidr.Limit(agg, o.MaxEntries)
+	}
+	entries := make([]Entry, len(agg))
+	counts := make([]map[string]int, len(agg))
+	for i, p := range agg {
+		entries[i].Prefix = p
+		counts[i] = map[string]int{}
+	}
+	for i, b := range bans {
+		p := parsed[i]
+		if !p.IsValid() {
+			continue
+		}
+		// the aggregated prefixes are sorted and disjoint
+		j, _ := slices.BinarySearchFunc(agg, p, func(a, t netip.Prefix) int {
+			return a.Addr().Compare(t.Addr())
+		})
+		if j == len(agg) || !agg[j].Contains(p.Addr()) {
+			j--
+		}
+		e := &entries[j]
+		e.Bans++
+		if b.Until.After(e.Until) {
+			e.Until = b.Until
+		}
+		for _, r := range b.Reasons {
+			counts[j][r]++
+		}
+	}
+	for i := range entries {
+		entries[i].Reasons = byFrequency(counts[i])
+	}
+	return entries
+}
+
+func byFrequency(counts map[string]int) []string {
+	res := make([]string, 0, len(counts))
+	for r := range counts {
+		res = append(res, r)
+	}
+	slices.SortFunc(res, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	return res
+}
+
+// comment summarizes e in one line.
+func comment(e *Entry) string {
+	reasons := e.Reasons
+	if len(reasons) > 3 {
+		reasons = reasons[:3]
+	}
+	return fmt.Sprintf("%d bans: %s", e.Bans, strings.Join(reasons, "; "))
+}
+
+// WriteACL writes a CSV of deny rules with a header: prefix, action,
+// bans, expires (RFC 3339) and comment, the format of upstream ACL and
+// Arbor style filter list imports.
+func WriteACL(w io.Writer, bans []firewall.BanInfo, o Options) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"prefix", "action", "bans", "expires", "comment"})
+	for _, e := range Aggregate(bans, 128, o) {
+		cw.Write([]string{
+			e.Prefix.String(),
+			"deny",
+			strconv.Itoa(e.Bans),
+			e.Until.UTC().Format(time.RFC3339),
+			comment(&e),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCloudflare writes a Cloudflare IP list bulk upload, "ip,description"
+// lines without header. Single ips are written without prefix length and
+// ipv6 prefixes longer than /64 are widened to /64.
+func WriteCloudflare(w io.Writer, bans []firewall.BanInfo, o Options) error {
+	cw := csv.NewWriter(w)
+	for _, e := range Aggregate(bans, cloudflareMinV6Bits, o) {
+		item := e.Prefix.String()
+		if e.Prefix.IsSingleIP() {
+			item = e.Prefix.Addr().String()
+		}
+		cw.Write([]string{item, comment(&e)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCIDRs writes one aggregated prefix per line.
+func WriteCIDRs(w io.Writer, bans []firewall.BanInfo, o Options) error {
+	for _, e := range Aggregate(bans, 128, o) {
+		if _, err := fmt.Fprintln(w, e.Prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Format is an artifact served for download.
+type Format struct {
+	// Filename of the download.
+	Filename    string
+	ContentType string
+	Write       func(w io.Writer, bans []firewall.BanInfo, o Options) error
+}
+
+// Formats by name.
+var Formats = map[string]Format{
+	"acl":        {"acl.csv", "text/csv", WriteACL},
+	"cloudflare": {"cloudflare.csv", "text/csv", WriteCloudflare},
+	"cidr":       {"cidr.txt", "text/plain", WriteCIDRs},
+}
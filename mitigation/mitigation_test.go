@@ -0,0 +1,87 @@
+package mitigation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	now  = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	bans = []firewall.BanInfo{
+		{IP: "10.0.0.1", Until: now, Reasons: []string{"ssh", "http"}},
+		{IP: "10.0.0.0", Until: now.Add(time.Hour), Reasons: []string{"ssh"}},
+		{IP: "10.0.1.0/24", Until: now, Reasons: []string{"scan"}},
+		{IP: "2001:db8::1", Until: now, Reasons: []string{"http"}},
+		{IP: "2001:db8::ff", Until: now, Reasons: []string{"http"}},
+		{IP: "not an ip", Until: now},
+	}
+)
+
+func TestAggregate(t *testing.T) {
+	entries := Aggregate(bans, 128, Options{})
+	require.Len(t, entries, 4)
+
+	assert.Equal(t, "10.0.0.0/31", entries[0].Prefix.String())
+	assert.Equal(t, 2, entries[0].Bans)
+	assert.Equal(t, now.Add(time.Hour), entries[0].Until)
+	assert.Equal(t, []string{"ssh", "http"}, entries[0].Reasons)
+	assert.Equal(t, "10.0.1.0/24", entries[1].Prefix.String())
+	assert.Equal(t, "2001:db8::1/128", entries[2].Prefix.String())
+	assert.Equal(t, "2001:db8::ff/128", entries[3].Prefix.String())
+
+	entries = Aggregate(bans, 64, Options{})
+	require.Len(t, entries, 3)
+	assert.Equal(t, "2001:db8::/64", entries[2].Prefix.String())
+	assert.Equal(t, 2, entries[2].Bans)
+
+	// the /31 and the /24 are merged into 10.0.0.0/23
+	entries = Aggregate(bans, 128, Options{MaxEntries: 3})
+	require.Len(t, entries, 3)
+	assert.Equal(t, "10.0.0.0/23", entries[0].Prefix.String())
+	assert.Equal(t, 3, entries[0].Bans)
+	assert.Equal(t, []string{"ssh", "http", "scan"}, entries[0].Reasons)
+
+	// 254 of the 512 addresses of the /23 are not banned
+	entries = Aggregate(bans, 128, Options{Slack: 0.4})
+	require.Len(t, entries, 4)
+	entries = Aggregate(bans, 128, Options{Slack: 0.5})
+	require.Len(t, entries, 3)
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"acl", `prefix,action,bans,expires,comment
+10.0.0.0/31,deny,2,2026-01-02T04:04:05Z,2 bans: ssh; http
+10.0.1.0/24,deny,1,2026-01-02T03:04:05Z,1 bans: scan
+2001:db8::1/128,deny,1,2026-01-02T03:04:05Z,1 bans: http
+2001:db8::ff/128,deny,1,2026-01-02T03:04:05Z,1 bans: http
+`},
+		{"cloudflare", `10.0.0.0/31,2 bans: ssh; http
+10.0.1.0/24,1 bans: scan
+2001:db8::/64,2 bans: http
+`},
+		{"cidr", `10.0.0.0/31
+10.0.1.0/24
+2001:db8::1/128
+2001:db8::ff/128
+`},
+	}
+	for _, tt := range tests {
+		b := &strings.Builder{}
+		require.NoError(t, Formats[tt.format].Write(b, bans, Options{}))
+		assert.Equal(t, tt.want, b.String(), tt.format)
+	}
+
+	// single ips are written bare for Cloudflare
+	b := &strings.Builder{}
+	require.NoError(t, WriteCloudflare(b, bans[:1], Options{}))
+	assert.Equal(t, "10.0.0.1,1 bans: http; ssh\n", b.String())
+}
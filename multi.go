@@ -0,0 +1,130 @@
+package firewall
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	_ IErrorFirewall    = (*MultiFirewall)(nil)
+	_ IUnbanFirewall    = (*MultiFirewall)(nil)
+	_ IExpiringFirewall = (*MultiFirewall)(nil)
+	_ INetworkFirewall  = (*MultiFirewall)(nil)
+)
+
+// MultiFirewall applies bans to many backends, e.g. an edge router and an
+// access point. Backends are called concurrently, a failing backend does not
+// block the others, its error is reported as a BackendError of its own.
+type MultiFirewall struct {
+	backends []IFirewall
+}
+
+// NewMultiFirewall returns a MultiFirewall of backends.
+func NewMultiFirewall(backends ...IFirewall) *MultiFirewall {
+	return &MultiFirewall{backends: backends}
+}
+
+// Name joins the names of the backends.
+func (m *MultiFirewall) Name() string {
+	names := []string{}
+	for _, fw := range m.backends {
+		names = append(names, backendName(fw))
+	}
+	return strings.Join(names, ",")
+}
+
+// ExpiresBans returns true if all backends expire bans by themselves.
+func (m *MultiFirewall) ExpiresBans() bool {
+	for _, fw := range m.backends {
+		if e, ok := fw.(IExpiringFirewall); !ok || !e.ExpiresBans() {
+			return false
+		}
+	}
+	return true
+}
+
+// BansNetworks returns true if all backends accept cidr bans.
+func (m *MultiFirewall) BansNetworks() bool {
+	for _, fw := range m.backends {
+		if !bansNetworks(fw) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MultiFirewall) BanIP(ip string, timeoutInMinute int) {
+	m.each("ban", func(fw IFirewall) error {
+		fw.BanIP(ip, timeoutInMinute)
+		return nil
+	})
+}
+
+// TryBanIP bans ip on all backends, backends without errors are called by
+// BanIP. The returned error joins a BackendError for each failed backend.
+func (m *MultiFirewall) TryBanIP(ip string, timeoutInMinute int) error {
+	return m.each("ban", func(fw IFirewall) error {
+		f, ok := fw.(IErrorFirewall)
+		if !ok {
+			fw.BanIP(ip, timeoutInMinute)
+			return nil
+		}
+		return f.TryBanIP(ip, timeoutInMinute)
+	})
+}
+
+// UnbanIP unbans ip on the backends implementing IUnbanFirewall. The
+// returned error joins a BackendError for each failed backend.
+func (m *MultiFirewall) UnbanIP(ip string) error {
+	return m.each("unban", func(fw IFirewall) error {
+		f, ok := fw.(IUnbanFirewall)
+		if !ok {
+			return nil
+		}
+		return f.UnbanIP(ip)
+	})
+}
+
+func (m *MultiFirewall) each(op string, fn func(fw IFirewall) error) error {
+	errs := make([]error, len(m.backends))
+	wg := sync.WaitGroup{}
+	for i, fw := range m.backends {
+		wg.Go(func() {
+			if err := fn(fw); err != nil {
+				errs[i] = newBackendError(backendName(fw), op, err)
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// backendErrors splits err of backend name into a BackendError for each
+// failed backend, err joins them if it is returned by MultiFirewall.
+func backendErrors(name, op string, err error) []*BackendError {
+	res := []*BackendError{}
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		for _, e := range joined.Unwrap() {
+			be := &BackendError{}
+			if !errors.As(e, &be) {
+				be = newBackendError(name, op, e)
+			}
+			res = append(res, be)
+		}
+		return res
+	}
+	return append(res, newBackendError(name, op, err))
+}
+
+// newBackendError returns the BackendError of err, with the attempts of a
+// RetryError.
+func newBackendError(name, op string, err error) *BackendError {
+	be := &BackendError{Backend: name, Op: op, Attempt: 1, Err: err}
+	var re *RetryError
+	if errors.As(err, &re) {
+		be.Attempt = re.Attempts
+	}
+	return be
+}
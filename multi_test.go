@@ -0,0 +1,56 @@
+package firewall
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiFirewall(t *testing.T) {
+	ok := &MockUnbanFirewall{}
+	failing := &MockErrorFirewall{Err: &StatusError{Code: 500, Body: "down"}}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, NewMultiFirewall(ok, failing), mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.2.3.4", 10, "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.4"}, ok.BannedIPs)
+	assert.Equal(t, []string{"1.2.3.4"}, failing.BannedIPs)
+	require.Len(t, mockLogger.Events, 2)
+	assert.Equal(t, "backend-error", mockLogger.Events[0].Action)
+	assert.Equal(t, "mock", mockLogger.Events[0].Backend.Backend)
+	assert.Equal(t, []string{`mock ban failed (attempt 1, http): code = 500, resp = "down"`}, mockLogger.Events[0].Reasons)
+	assert.Equal(t, "ban", mockLogger.Events[1].Action)
+
+	mockLogger.Wg.Add(1)
+	fw.UnbanIP("1.2.3.4", "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.4"}, ok.UnbannedIPs)
+	assert.Equal(t, "unban", mockLogger.Events[2].Action)
+}
+
+func TestMultiFirewall_UnbanErrors(t *testing.T) {
+	a := &MockUnbanFirewall{Err: errors.New("a failed")}
+	b := &MockUnbanFirewall{Err: errors.New("b failed")}
+	m := NewMultiFirewall(a, b, &MockIFirewall{})
+
+	errs := backendErrors(m.Name(), "unban", m.UnbanIP("1.2.3.4"))
+	require.Len(t, errs, 2)
+	assert.Equal(t, "a failed", errs[0].Err.Error())
+	assert.Equal(t, "b failed", errs[1].Err.Error())
+	assert.Equal(t, "unban", errs[0].Op)
+
+	assert.NoError(t, NewMultiFirewall(&MockUnbanFirewall{}, &MockIFirewall{}).UnbanIP("1.2.3.4"))
+}
+
+func TestMultiFirewall_ExpiresBans(t *testing.T) {
+	assert.True(t, NewMultiFirewall(&MockExpiringFirewall{}, &MockExpiringFirewall{}).ExpiresBans())
+	assert.False(t, NewMultiFirewall(&MockExpiringFirewall{}, &MockUnbanFirewall{}).ExpiresBans())
+	assert.Equal(t, "mock,*firewall.MockIFirewall", NewMultiFirewall(&MockErrorFirewall{}, &MockIFirewall{}).Name())
+}
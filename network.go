@@ -0,0 +1,39 @@
+package firewall
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// BanNetwork bans a whole cidr range, e.g. "1.2.3.0/24" when an attacker
+// rotates through its addresses. The network is jailed, released and
+// logged like an ip with the cidr in its canonical form, and the backend
+// adds the prefix to its block list. It fails if the backend does not
+// implement INetworkFirewall. A network overlapping a whitelist rule is not
+// banned. A cidr of a single address bans the ip.
+//
+// Errors of ips in the network are still counted by ip, IsBanned only
+// reports the network itself.
+func (s *Firewall) BanNetwork(cidr string, timeoutInMinute int, reason string) error {
+	ip, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	if s.fw != nil && !bansNetworks(s.fw) {
+		return errors.New("backend does not support network bans")
+	}
+
+	if ones, bits := n.Mask.Size(); ones == bits {
+		s.BanIP(ip.String(), timeoutInMinute, reason)
+		return nil
+	}
+	s.BanIP(n.String(), timeoutInMinute, reason)
+	return nil
+}
+
+// networkAddr returns the address of a network ban, or ip as is.
+func networkAddr(ip string) string {
+	addr, _, _ := strings.Cut(ip, "/")
+	return addr
+}
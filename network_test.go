@@ -0,0 +1,84 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MockNetworkFirewall is a mock backend accepting cidr bans.
+type MockNetworkFirewall struct {
+	MockUnbanFirewall
+}
+
+func (m *MockNetworkFirewall) BansNetworks() bool {
+	return true
+}
+
+func TestBanNetwork(t *testing.T) {
+	mockFW := &MockNetworkFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{"10.1.1.1"}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	assert.Error(t, fw.BanNetwork("1.2.3.4", 10, "r"))
+	assert.Error(t, fw.BanNetwork("1.2.3.0/33", 10, "r"))
+
+	mockLogger.Wg.Add(2)
+	require.NoError(t, fw.BanNetwork("1.2.3.4/24", 10, "scan"))
+	require.NoError(t, fw.BanNetwork("2001:db8::1/128", 10, "scan"))
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.0/24", "2001:db8::1"}, mockFW.BannedIPs)
+	assert.Equal(t, "ban", mockLogger.Events[0].Action)
+	assert.Equal(t, "1.2.3.0/24", mockLogger.Events[0].IP)
+	banned, _ := fw.IsBanned("1.2.3.0/24")
+	assert.True(t, banned)
+
+	// a network overlapping the whitelist is not banned
+	require.NoError(t, fw.BanNetwork("10.0.0.0/8", 10, "scan"))
+	fw.do(func() {})
+	assert.Len(t, mockFW.BannedIPs, 2)
+
+	// whitelisting an ip of the network releases it
+	mockLogger.Wg.Add(2)
+	require.NoError(t, fw.AddToWhitelist("1.2.3.9"))
+	mockLogger.Wg.Wait()
+	assert.Equal(t, []string{"1.2.3.0/24"}, mockFW.UnbannedIPs)
+	assert.Equal(t, "unban", mockLogger.Events[3].Action)
+}
+
+func TestBanNetwork_Unsupported(t *testing.T) {
+	fw, err := New([]string{}, &MockUnbanFirewall{}, &MockEventLogger{}, nil, testForgivable)
+	require.NoError(t, err)
+	assert.EqualError(t, fw.BanNetwork("1.2.3.0/24", 10, "r"), "backend does not support network bans")
+
+	assert.False(t, NewMultiFirewall(&MockNetworkFirewall{}, V1(&mockV2Firewall{}, 0)).BansNetworks())
+	assert.True(t, NewRouter(&MockNetworkFirewall{}).BansNetworks())
+}
+
+func TestKeyMatcher(t *testing.T) {
+	tests := []struct {
+		key  string
+		rule string
+		want bool
+	}{
+		{"1.2.3.4", "1.2.3.0/24", true},
+		{"1.2.3.0/24", "1.2.3.4", true},
+		{"1.2.3.0/24", "1.2.4.4", false},
+		{"1.2.0.0/16", "1.2.3.0/24", true},
+		{"1.2.3.0/24", "1.0.0.0/8", true},
+		{"1.2.3.0/24", "1.2.4.0/24", false},
+		{"2001:db8::/32", "2001:db8:1::1", true},
+		{"2001:db8::/32", "1.2.3.4", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+" "+tt.rule, func(t *testing.T) {
+			m, err := parseIPMatcher(tt.rule)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keyMatcher(tt.key)(m))
+		})
+	}
+	assert.Nil(t, keyMatcher("not an ip"))
+}
@@ -0,0 +1,100 @@
+package firewall
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxOffensesSize caps the serialized size of offenses in a ban event.
+	maxOffensesSize = 4096
+	// maxReasonLen caps a single reason in a ban event.
+	maxReasonLen = 256
+	// maxReasonCountsSize caps the serialized size of the reason counts in a
+	// ban event.
+	maxReasonCountsSize = 2048
+	// reasonCountOverhead approximates the json size of a reason count
+	// without the reason: the count, quotes and separators.
+	reasonCountOverhead = 8
+	// offenseOverhead approximates the json size of an offense without the
+	// reason: the RFC3339 time, keys and quotes.
+	offenseOverhead = 64
+)
+
+// Offense is a reason and when it happens.
+type Offense struct {
+	Time   time.Time
+	Reason string
+}
+
+// reasonsOf returns the reasons of offenses once each, in the order they
+// first happen.
+func reasonsOf(offenses []Offense) []string {
+	reasons := make([]string, 0, len(offenses))
+	seen := map[string]bool{}
+	for _, o := range offenses {
+		if !seen[o.Reason] {
+			seen[o.Reason] = true
+			reasons = append(reasons, o.Reason)
+		}
+	}
+	return reasons
+}
+
+// countReasons counts offenses by truncated reason. The least frequent
+// reasons are dropped until the approximate serialized size fits in
+// maxBytes, the most frequent one is always kept.
+func countReasons(offenses []Offense, maxBytes int) map[string]int {
+	counts := map[string]int{}
+	for _, o := range offenses {
+		counts[truncateReason(o.Reason)]++
+	}
+
+	size := 0
+	for r := range counts {
+		size += len(r) + reasonCountOverhead
+	}
+	for size > maxBytes && len(counts) > 1 {
+		least := ""
+		for r, n := range counts {
+			if least == "" || n < counts[least] || n == counts[least] && r > least {
+				least = r
+			}
+		}
+		size -= len(least) + reasonCountOverhead
+		delete(counts, least)
+	}
+	return counts
+}
+
+// capOffenses truncates long reasons and drops the oldest offenses until
+// the approximate serialized size fits in maxBytes. The latest offense is
+// always kept.
+func capOffenses(offenses []Offense, maxBytes int) []Offense {
+	size := 0
+	start := len(offenses)
+	for i := len(offenses) - 1; i >= 0; i-- {
+		offenses[i].Reason = truncateReason(offenses[i].Reason)
+		n := len(offenses[i].Reason) + offenseOverhead
+		if size+n > maxBytes && start < len(offenses) {
+			break
+		}
+		size += n
+		start = i
+	}
+	return offenses[start:]
+}
+
+func truncateReason(r string) string {
+	if len(r) <= maxReasonLen {
+		return r
+	}
+	// invalid bytes become U+FFFD, then do not split a multi-byte rune
+	r = strings.ToValidUTF8(r[:min(len(r), maxReasonLen+utf8.UTFMax)], "\uFFFD")
+	n := min(len(r), maxReasonLen)
+	for n > 0 && n < len(r) && !utf8.RuneStart(r[n]) {
+		n--
+	}
+	return r[:n] + "..."
+}
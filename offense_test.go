@@ -0,0 +1,72 @@
+package firewall
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapOffenses(t *testing.T) {
+	now := time.Now()
+	newOffenses := func(n int, reason string) []Offense {
+		res := []Offense{}
+		for i := 0; i < n; i++ {
+			res = append(res, Offense{Time: now.Add(time.Duration(i) * time.Second), Reason: reason})
+		}
+		return res
+	}
+
+	t.Run("fits", func(t *testing.T) {
+		got := capOffenses(newOffenses(3, "Invalid password"), maxOffensesSize)
+		assert.Len(t, got, 3)
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		offenses := newOffenses(10, strings.Repeat("a", 100))
+		got := capOffenses(offenses, 3*(100+offenseOverhead))
+		assert.Equal(t, offenses[7:], got)
+	})
+
+	t.Run("keep latest even too large", func(t *testing.T) {
+		got := capOffenses(newOffenses(2, strings.Repeat("a", 100)), 10)
+		assert.Len(t, got, 1)
+		assert.Equal(t, now.Add(time.Second), got[0].Time)
+	})
+
+	t.Run("truncate long reason", func(t *testing.T) {
+		got := capOffenses(newOffenses(1, strings.Repeat("好", maxReasonLen)), maxOffensesSize)
+		assert.Len(t, got, 1)
+		assert.True(t, strings.HasSuffix(got[0].Reason, "..."))
+		assert.LessOrEqual(t, len(got[0].Reason), maxReasonLen+3)
+	})
+
+	t.Run("truncate invalid utf8 reason", func(t *testing.T) {
+		got := capOffenses(newOffenses(1, strings.Repeat("\xff", 2*maxReasonLen)), maxOffensesSize)
+		assert.Len(t, got, 1)
+		assert.True(t, utf8.ValidString(got[0].Reason))
+		assert.True(t, strings.HasSuffix(got[0].Reason, "..."))
+	})
+}
+
+func TestCountReasons(t *testing.T) {
+	offenses := []Offense{}
+	for range 20 {
+		offenses = append(offenses, Offense{Reason: "invalid password"})
+	}
+	offenses = append(offenses, Offense{Reason: "not found"})
+	assert.Equal(t, map[string]int{"invalid password": 20, "not found": 1}, countReasons(offenses, maxReasonCountsSize))
+	assert.Equal(t, []string{"invalid password", "not found"}, reasonsOf(offenses))
+
+	// the least frequent reasons are dropped, the most frequent is kept
+	offenses = append(offenses, Offense{Reason: strings.Repeat("a", 100)}, Offense{Reason: strings.Repeat("a", 100)})
+	got := countReasons(offenses, len("invalid password")+100+2*reasonCountOverhead)
+	assert.Equal(t, map[string]int{"invalid password": 20, strings.Repeat("a", 100): 2}, got)
+	got = countReasons(offenses, 1)
+	assert.Equal(t, map[string]int{"invalid password": 20}, got)
+
+	got = countReasons([]Offense{{Reason: strings.Repeat("a", 2*maxReasonLen)}}, maxReasonCountsSize)
+	assert.Equal(t, map[string]int{strings.Repeat("a", maxReasonLen) + "...": 1}, got)
+}
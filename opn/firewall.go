@@ -2,42 +2,102 @@ package opn
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charleshuang3/firewall"
 )
 
-var _ firewall.IFirewall = (*API)(nil)
+var (
+	_ firewall.IFirewallV2      = (*API)(nil)
+	_ firewall.IUnbanFirewallV2 = (*API)(nil)
+	_ firewall.INetworkFirewall = (*API)(nil)
+)
+
+const (
+	blockListName = "block_list"
+)
 
 type API struct {
 	address  string
-	user     string
-	pass     string
+	creds    firewall.ICredentialProvider
 	listUUID string
+	// moreUUIDs are aliases written along with the block list, e.g. of a
+	// DMZ interface.
+	moreUUIDs []string
+	// capacity is the most entries of an alias, 0 for no limit. Bans over
+	// it go to the chunk aliases block_list_1..chunks.
+	capacity int
+	chunks   int
+
+	// writeMu serializes the read-modify-write of the aliases, so
+	// concurrent bans do not overwrite each other.
+	writeMu sync.Mutex
+
+	mu sync.Mutex
+	// chunkUUIDs caches the uuids of the chunks found or created, in order.
+	chunkUUIDs []string
 }
 
+// ErrFull is returned when a ban does not fit in the block list alias and
+// its chunks.
+var ErrFull = errors.New("block list aliases are full")
+
 type ban struct {
-	ip              string
-	timeoutInMinute int
+	ip  string
+	dur time.Duration
 }
 
-func New(address, user, pass, listUUID string) *API {
+// New returns the API writing bans to the alias listUUID, and to
+// moreUUIDs if any, e.g. separate aliases for WAN and DMZ rules.
+func New(address, user, pass, listUUID string, moreUUIDs ...string) *API {
 	api := &API{
-		address:  address,
-		user:     user,
-		pass:     pass,
-		listUUID: listUUID,
+		address:   address,
+		creds:     firewall.StaticCredentials{User: user, Pass: pass},
+		listUUID:  listUUID,
+		moreUUIDs: moreUUIDs,
 	}
 
 	return api
 }
 
+// WithChunks limits each alias to capacity entries, OPNsense slows down
+// reloading huge aliases. Bans over it are split across the aliases
+// block_list_1..block_list_<chunks>, created when first needed, rules
+// should match all of them, e.g. with a nested alias. A ban fails with
+// ErrFull when all are full. More aliases of New are not chunked.
+func (s *API) WithChunks(capacity, chunks int) *API {
+	s.capacity = capacity
+	s.chunks = chunks
+	return s
+}
+
+// WithCredentials replaces the user and pass of New by p, asked on every
+// call, e.g. to pick up rotated api keys.
+func (s *API) WithCredentials(p firewall.ICredentialProvider) *API {
+	s.creds = p
+	return s
+}
+
+// auth sets the current credentials on r.
+func (s *API) auth(r *http.Request) error {
+	c, err := s.creds.Credentials(r.Context())
+	if err != nil {
+		return fmt.Errorf("get credentials failed: %w", err)
+	}
+	r.SetBasicAuth(c.User, c.Pass)
+	return nil
+}
+
 type Value struct {
 	Value    string `json:"value"`
 	Selected int    `json:"selected"`
@@ -89,33 +149,165 @@ type UpdateAliasRequest struct {
 	NetworkContent string `json:"network_content"`
 }
 
-func (s *API) request(b *ban) {
-	// read current block list first
-	bl, err := s.readBlockList()
+// uuids returns the uuids of all aliases bans are written to.
+func (s *API) uuids() []string {
+	return append([]string{s.listUUID}, s.moreUUIDs...)
+}
+
+// eachAlias runs fn on uuids concurrently, errors are joined.
+func eachAlias(uuids []string, fn func(uuid string) error) error {
+	if len(uuids) == 1 {
+		return fn(uuids[0])
+	}
+
+	errs := make([]error, len(uuids))
+	wg := sync.WaitGroup{}
+	for i, uuid := range uuids {
+		wg.Go(func() {
+			if err := fn(uuid); err != nil {
+				errs[i] = fmt.Errorf("alias %s: %w", uuid, err)
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *API) request(ctx context.Context, b *ban) error {
+	uuids := s.uuids()
+	if s.capacity > 0 {
+		// the block list is chunked, the more aliases are not
+		uuids[0] = ""
+	}
+	return eachAlias(uuids, func(uuid string) error {
+		if uuid == "" {
+			return s.requestChunked(ctx, b)
+		}
+
+		// read current block list first
+		bl, err := s.readBlockList(ctx, uuid)
+		if err != nil {
+			return err
+		}
+
+		// remove expired and add new block
+		r, err := newUpdateRequest(bl, b)
+		if err != nil {
+			return err
+		}
+
+		return s.updateAlias(ctx, uuid, r)
+	})
+}
+
+// requestChunked writes b to the chunk having its ip, else to the first
+// with room, creating the next chunk if all are full.
+func (s *API) requestChunked(ctx context.Context, b *ban) error {
+	chunks, err := s.chunkList()
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 
-	// remove expired and add new block
+	now := time.Now().Unix()
+	target := ""
+	for _, uuid := range chunks {
+		bl, err := s.readBlockList(ctx, uuid)
+		if err != nil {
+			return err
+		}
+		banned, err := readExpiries(bl)
+		if err != nil {
+			return err
+		}
+		if banned.Expiries[b.ip] > now {
+			target = uuid
+			break
+		}
+		if target == "" && liveCount(banned, now) < s.capacity {
+			target = uuid
+		}
+	}
+
+	if target == "" {
+		target, err = s.addChunk()
+		if err != nil {
+			return err
+		}
+	}
+
+	bl, err := s.readBlockList(ctx, target)
+	if err != nil {
+		return err
+	}
 	r, err := newUpdateRequest(bl, b)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
+	return s.updateAlias(ctx, target, r)
+}
 
-	if err = s.updateAlias(r); err != nil {
-		log.Println(err)
+// liveCount returns the number of not expired bans.
+func liveCount(banned *IPsAndExpiries, now int64) int {
+	n := 0
+	for _, exp := range banned.Expiries {
+		if exp > now {
+			n++
+		}
 	}
+	return n
 }
 
-func (s *API) readBlockList() (*Alias, error) {
-	r, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/api/firewall/alias/getItem/%s", s.address, s.listUUID), nil)
+// chunkName returns the name of the alias of chunk i.
+func chunkName(i int) string {
+	return fmt.Sprintf("%s_%d", blockListName, i)
+}
+
+// chunkList returns the uuids of the block list and of its chunks created,
+// chunks are created in order so the first missing one ends the list.
+func (s *API) chunkList() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.chunkUUIDs) + 1; i <= s.chunks; i++ {
+		uuid, err := s.findAliasUUID(chunkName(i))
+		if err != nil {
+			return nil, err
+		}
+		if uuid == "" {
+			break
+		}
+		s.chunkUUIDs = append(s.chunkUUIDs, uuid)
+	}
+
+	return append([]string{s.listUUID}, s.chunkUUIDs...), nil
+}
+
+// addChunk creates the next chunk, or fails with ErrFull.
+func (s *API) addChunk() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := len(s.chunkUUIDs) + 1
+	if i > s.chunks {
+		return "", ErrFull
+	}
+	uuid, err := s.addAlias(chunkName(i))
+	if err != nil {
+		return "", err
+	}
+	s.chunkUUIDs = append(s.chunkUUIDs, uuid)
+	return uuid, nil
+}
+
+func (s *API) readBlockList(ctx context.Context, uuid string) (*Alias, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/api/firewall/alias/getItem/%s", s.address, uuid), nil)
 	if err != nil {
 		// it should not happen unless config invalid.
 		return nil, fmt.Errorf("new request failed: %w", err)
 	}
-	r.SetBasicAuth(s.user, s.pass)
+	if err := s.auth(r); err != nil {
+		return nil, err
+	}
 
 	resp, err := http.DefaultClient.Do(r)
 	if err != nil {
@@ -128,7 +320,7 @@ func (s *API) readBlockList() (*Alias, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get alias failed: code = %d, resp = %q", resp.StatusCode, string(b))
+		return nil, fmt.Errorf("get alias failed: %w", &firewall.StatusError{Code: resp.StatusCode, Body: string(b)})
 	}
 
 	o := &GetAliasResponse{}
@@ -136,25 +328,88 @@ func (s *API) readBlockList() (*Alias, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unmarshal get alias response failed: %w", err)
 	}
+	if o.Alias == nil {
+		return nil, fmt.Errorf("no alias in get alias response: %q", b)
+	}
 
 	return o.Alias, nil
 }
 
-func newUpdateRequest(a *Alias, b *ban) (*UpdateAliasRequest, error) {
+func readExpiries(a *Alias) (*IPsAndExpiries, error) {
 	banned := &IPsAndExpiries{
 		Expiries: map[string]int64{},
 	}
 	if len(a.Description) != 0 {
 		if err := json.Unmarshal([]byte(a.Description), banned); err != nil {
-			return nil, fmt.Errorf("unmarshal Description failed: %d", err)
+			return nil, fmt.Errorf("unmarshal Description failed: %w", err)
+		}
+	}
+	if banned.Expiries == nil {
+		// "expiries": null
+		banned.Expiries = map[string]int64{}
+	}
+
+	// the description is editable on the appliance, only keep ips and
+	// cidrs, anything else would be written into the alias content.
+	for ip := range banned.Expiries {
+		if !validAddress(ip) {
+			delete(banned.Expiries, ip)
 		}
 	}
+	return banned, nil
+}
+
+func validAddress(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
 
+// aliasType returns "network" if ips has a cidr, opnsense rejects cidrs in
+// a host alias but accepts single addresses in a network alias.
+func aliasType(ips []string) string {
+	for _, ip := range ips {
+		if strings.Contains(ip, "/") {
+			return "network"
+		}
+	}
+	return "host"
+}
+
+func newUpdateRequest(a *Alias, b *ban) (*UpdateAliasRequest, error) {
+	banned, err := readExpiries(a)
+	if err != nil {
+		return nil, err
+	}
+
+	// add new ban, it never shortens an existing one.
+	exp := time.Now().Add(b.dur).Unix()
+	if exp > banned.Expiries[b.ip] {
+		banned.Expiries[b.ip] = exp
+	}
+
+	return newSetRequest(a, banned)
+}
+
+func newUnbanRequest(a *Alias, ip string) (*UpdateAliasRequest, error) {
+	banned, err := readExpiries(a)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(banned.Expiries, ip)
+
+	return newSetRequest(a, banned)
+}
+
+// newSetRequest writes banned into the alias, expired ban are removed.
+func newSetRequest(a *Alias, banned *IPsAndExpiries) (*UpdateAliasRequest, error) {
 	ips := []string{}
 
 	// remove expiried ban
-	now := time.Now()
-	nowTs := now.Unix()
+	nowTs := time.Now().Unix()
 	for k, v := range banned.Expiries {
 		if v > nowTs {
 			ips = append(ips, k)
@@ -163,11 +418,7 @@ func newUpdateRequest(a *Alias, b *ban) (*UpdateAliasRequest, error) {
 
 		delete(banned.Expiries, k)
 	}
-
-	// add new ban
-	exp := now.Add(time.Minute * time.Duration(b.timeoutInMinute))
-	banned.Expiries[b.ip] = exp.Unix()
-	ips = append(ips, b.ip)
+	slices.Sort(ips)
 
 	// write description
 	d, err := json.Marshal(banned)
@@ -181,7 +432,7 @@ func newUpdateRequest(a *Alias, b *ban) (*UpdateAliasRequest, error) {
 	res.Alias.Counters = a.Counters
 	res.Alias.Proto = ""
 	res.Alias.Updatefreq = a.Updatefreq
-	res.Alias.Type = "host"
+	res.Alias.Type = aliasType(ips)
 
 	res.Alias.Content = strings.Join(ips, "\n")
 	res.Alias.Description = string(d)
@@ -189,19 +440,21 @@ func newUpdateRequest(a *Alias, b *ban) (*UpdateAliasRequest, error) {
 	return res, nil
 }
 
-func (s *API) updateAlias(o *UpdateAliasRequest) error {
+func (s *API) updateAlias(ctx context.Context, uuid string, o *UpdateAliasRequest) error {
 	b, err := json.Marshal(o)
 	if err != nil {
 		return fmt.Errorf("json.Marshal failed: %w", err)
 	}
 
-	r, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/api/firewall/alias/setItem/%s", s.address, s.listUUID), bytes.NewReader(b))
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s/api/firewall/alias/setItem/%s", s.address, uuid), bytes.NewReader(b))
 	if err != nil {
 		// it should not happen unless config invalid.
 		return fmt.Errorf("new request failed: %w", err)
 	}
 
-	r.SetBasicAuth(s.user, s.pass)
+	if err := s.auth(r); err != nil {
+		return err
+	}
 	r.Header.Set("Content-Type", "application/json")
 
 	resp, err := http.DefaultClient.Do(r)
@@ -214,12 +467,221 @@ func (s *API) updateAlias(o *UpdateAliasRequest) error {
 		if err != nil {
 			return fmt.Errorf("update alias response failed: %w", err)
 		}
-		return fmt.Errorf("update alias failed: code = %d, resp = %q", resp.StatusCode, string(b))
+		return fmt.Errorf("update alias failed: %w", &firewall.StatusError{Code: resp.StatusCode, Body: string(b)})
+	}
+
+	return nil
+}
+
+func (s *API) Name() string {
+	return "opn"
+}
+
+// BansNetworks returns true, the alias is turned into a network alias when
+// it holds a cidr.
+func (s *API) BansNetworks() bool {
+	return true
+}
+
+// BanIP adds ip to the block list aliases for dur.
+func (s *API) BanIP(ctx context.Context, ip string, dur time.Duration) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.request(ctx, &ban{ip: ip, dur: dur})
+}
+
+// UnbanIP removes ip from the block list aliases and chunks.
+func (s *API) UnbanIP(ctx context.Context, ip string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	uuids, err := s.allUUIDs()
+	if err != nil {
+		return err
+	}
+
+	return eachAlias(uuids, func(uuid string) error {
+		bl, err := s.readBlockList(ctx, uuid)
+		if err != nil {
+			return err
+		}
+
+		r, err := newUnbanRequest(bl, ip)
+		if err != nil {
+			return err
+		}
+
+		return s.updateAlias(ctx, uuid, r)
+	})
+}
+
+// allUUIDs returns the uuids of the block list aliases and chunks.
+func (s *API) allUUIDs() ([]string, error) {
+	if s.capacity <= 0 {
+		return s.uuids(), nil
+	}
+	chunks, err := s.chunkList()
+	if err != nil {
+		return nil, err
+	}
+	return append(chunks, s.moreUUIDs...), nil
+}
+
+// ListBans returns the not expired ips in the block list aliases and
+// chunks, with the latest expiry of an ip in many aliases.
+func (s *API) ListBans() ([]firewall.BackendBan, error) {
+	uuids, err := s.allUUIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	expiries := map[string]int64{}
+	for _, uuid := range uuids {
+		bl, err := s.readBlockList(context.Background(), uuid)
+		if err != nil {
+			return nil, err
+		}
+
+		banned, err := readExpiries(bl)
+		if err != nil {
+			return nil, err
+		}
+		for ip, exp := range banned.Expiries {
+			expiries[ip] = max(expiries[ip], exp)
+		}
+	}
+
+	now := time.Now()
+	res := []firewall.BackendBan{}
+	for ip, exp := range expiries {
+		expireAt := time.Unix(exp, 0)
+		if !expireAt.After(now) {
+			continue
+		}
+		res = append(res, firewall.BackendBan{IP: ip, ExpireAt: expireAt})
+	}
+	slices.SortFunc(res, func(a, b firewall.BackendBan) int { return strings.Compare(a.IP, b.IP) })
+
+	return res, nil
+}
+
+// ListUUID returns the uuid of the block list alias, it may be filled by
+// EnsureAlias.
+func (s *API) ListUUID() string {
+	return s.listUUID
+}
+
+// EnsureAlias makes sure the block list alias exists. If no list uuid is
+// configured, it looks up the alias named "block_list" and creates it when
+// missing. More aliases are only checked, they are not created.
+func (s *API) EnsureAlias() error {
+	for _, uuid := range s.moreUUIDs {
+		if _, err := s.readBlockList(context.Background(), uuid); err != nil {
+			return err
+		}
 	}
 
+	if s.listUUID != "" {
+		_, err := s.readBlockList(context.Background(), s.listUUID)
+		return err
+	}
+
+	uuid, err := s.findAliasUUID(blockListName)
+	if err != nil {
+		return err
+	}
+	if uuid == "" {
+		uuid, err = s.addAlias(blockListName)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.listUUID = uuid
 	return nil
 }
 
-func (s *API) BanIP(ip string, timeoutInMinute int) {
-	s.request(&ban{ip: ip, timeoutInMinute: timeoutInMinute})
+// Ping checks the API is reachable and the credentials are accepted.
+func (s *API) Ping() error {
+	_, err := s.do(http.MethodGet, "/api/firewall/alias/getItem", nil)
+	return err
+}
+
+func (s *API) findAliasUUID(name string) (string, error) {
+	b, err := s.do(http.MethodGet, "/api/firewall/alias/getAliasUUID/"+name, nil)
+	if err != nil {
+		return "", fmt.Errorf("get alias uuid failed: %w", err)
+	}
+
+	o := struct {
+		UUID string `json:"uuid"`
+	}{}
+	// OPNsense returns an empty array when the alias does not exist.
+	if err := json.Unmarshal(b, &o); err != nil {
+		return "", nil
+	}
+
+	return o.UUID, nil
+}
+
+func (s *API) addAlias(name string) (string, error) {
+	r := &UpdateAliasRequest{}
+	r.Alias.Enabled = "1"
+	r.Alias.Name = name
+	r.Alias.Type = "host"
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal failed: %w", err)
+	}
+
+	b, err := s.do(http.MethodPost, "/api/firewall/alias/addItem", body)
+	if err != nil {
+		return "", fmt.Errorf("add alias failed: %w", err)
+	}
+
+	o := struct {
+		Result string `json:"result"`
+		UUID   string `json:"uuid"`
+	}{}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return "", fmt.Errorf("unmarshal add alias response failed: %w", err)
+	}
+	if o.UUID == "" {
+		return "", fmt.Errorf("add alias failed: resp = %q", string(b))
+	}
+
+	return o.UUID, nil
+}
+
+// do sends a request to the API and returns the response body of a 200
+// response.
+func (s *API) do(method, path string, body []byte) ([]byte, error) {
+	r, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", s.address, path), bytes.NewReader(body))
+	if err != nil {
+		// it should not happen unless config invalid.
+		return nil, fmt.Errorf("new request failed: %w", err)
+	}
+	if err := s.auth(r); err != nil {
+		return nil, err
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &firewall.StatusError{Code: resp.StatusCode, Body: string(b)}
+	}
+
+	return b, nil
 }
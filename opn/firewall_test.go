@@ -0,0 +1,264 @@
+package opn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+)
+
+func TestNewUpdateRequest(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name        string
+		description string
+		want        string
+		err         bool
+	}{
+		{"empty", "", "10.9.9.9", false},
+		{"null expiries", `{"expiries":null}`, "10.9.9.9", false},
+		{"expired removed", fmt.Sprintf(`{"expiries":{"10.0.0.1":%d,"10.0.0.2":%d}}`, now+600, now-600), "10.0.0.1\n10.9.9.9", false},
+		{"not ip", fmt.Sprintf(`{"expiries":{"10.0.0.1\n10.0.0.3":%d}}`, now+600), "10.9.9.9", false},
+		{"cidr", fmt.Sprintf(`{"expiries":{"10.1.0.0/16":%d}}`, now+600), "10.1.0.0/16\n10.9.9.9", false},
+		{"not json", "blocked by firewall", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newUpdateRequest(&Alias{Name: blockListName, Description: tt.description}, &ban{ip: "10.9.9.9", dur: time.Minute})
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, r.Alias.Content)
+		})
+	}
+}
+
+func TestNewUpdateRequest_Network(t *testing.T) {
+	r, err := newUpdateRequest(&Alias{Name: blockListName}, &ban{ip: "10.9.9.9", dur: time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, "host", r.Alias.Type)
+
+	r, err = newUpdateRequest(&Alias{Name: blockListName}, &ban{ip: "2001:db8::/48", dur: time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, "network", r.Alias.Type)
+	assert.Equal(t, "2001:db8::/48", r.Alias.Content)
+}
+
+func FuzzNewUpdateRequest(f *testing.F) {
+	now := time.Now().Unix()
+	f.Add("")
+	f.Add(`{"expiries":null}`)
+	f.Add(fmt.Sprintf(`{"expiries":{"10.0.0.1":%d,"10.0.0.2":%d}}`, now+600, now-600))
+	f.Add(`{"expiries":{"":1,"a b":99999999999}}`)
+
+	f.Fuzz(func(t *testing.T, description string) {
+		r, err := newUpdateRequest(&Alias{Name: blockListName, Description: description}, &ban{ip: "10.9.9.9", dur: time.Minute})
+		if err != nil {
+			return
+		}
+
+		ips := strings.Split(r.Alias.Content, "\n")
+		if !slices.IsSorted(ips) || !slices.Contains(ips, "10.9.9.9") {
+			t.Fatalf("invalid content %q", r.Alias.Content)
+		}
+		for _, ip := range ips {
+			if !validAddress(ip) {
+				t.Fatalf("invalid ip %q", ip)
+			}
+		}
+
+		// the description round trips
+		banned := &IPsAndExpiries{}
+		if err := json.Unmarshal([]byte(r.Alias.Description), banned); err != nil {
+			t.Fatal(err)
+		}
+		if len(banned.Expiries) != len(ips) {
+			t.Fatalf("%d expiries, %d ips", len(banned.Expiries), len(ips))
+		}
+	})
+}
+
+// fakeOPN serves the alias getItem and setItem endpoints of aliases by uuid,
+// and getAliasUUID and addItem by name.
+type fakeOPN struct {
+	mu      sync.Mutex
+	aliases map[string]*Alias
+	// pass is the api secret required if set.
+	pass string
+}
+
+func (f *fakeOPN) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, pass, _ := r.BasicAuth(); f.pass != "" && pass != f.pass {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch path.Dir(r.URL.Path) {
+	case "/api/firewall/alias/getAliasUUID":
+		for uuid, a := range f.aliases {
+			if a.Name == path.Base(r.URL.Path) {
+				json.NewEncoder(w).Encode(map[string]string{"uuid": uuid})
+				return
+			}
+		}
+		w.Write([]byte("[]"))
+		return
+	case "/api/firewall/alias":
+		req := &UpdateAliasRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		uuid := "uuid-" + req.Alias.Name
+		f.aliases[uuid] = &Alias{Name: req.Alias.Name}
+		json.NewEncoder(w).Encode(map[string]string{"result": "saved", "uuid": uuid})
+		return
+	}
+
+	uuid := path.Base(r.URL.Path)
+	a, ok := f.aliases[uuid]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch path.Dir(r.URL.Path) {
+	case "/api/firewall/alias/getItem":
+		json.NewEncoder(w).Encode(&GetAliasResponse{Alias: a})
+	case "/api/firewall/alias/setItem":
+		req := &UpdateAliasRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.Description = req.Alias.Description
+	}
+}
+
+func TestMultipleAliases(t *testing.T) {
+	f := &fakeOPN{aliases: map[string]*Alias{"wan": {Name: "wan"}, "dmz": {Name: "dmz"}}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	api := New(address, "key", "secret", "wan", "dmz")
+	require.NoError(t, api.BanIP(t.Context(), "10.9.9.9", time.Hour))
+	for _, uuid := range []string{"wan", "dmz"} {
+		assert.Contains(t, f.aliases[uuid].Description, "10.9.9.9", uuid)
+	}
+
+	bans, err := api.ListBans()
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+	assert.Equal(t, "10.9.9.9", bans[0].IP)
+
+	require.NoError(t, api.UnbanIP(t.Context(), "10.9.9.9"))
+	for _, uuid := range []string{"wan", "dmz"} {
+		assert.NotContains(t, f.aliases[uuid].Description, "10.9.9.9", uuid)
+	}
+
+	// a missing alias fails, the others are still written
+	api = New(address, "key", "secret", "wan", "gone")
+	err = api.BanIP(t.Context(), "10.9.9.8", time.Hour)
+	assert.ErrorContains(t, err, "alias gone: get alias failed")
+	assert.Contains(t, f.aliases["wan"].Description, "10.9.9.8")
+	assert.Error(t, api.EnsureAlias())
+}
+
+func TestConcurrentBans(t *testing.T) {
+	f := &fakeOPN{aliases: map[string]*Alias{"wan": {Name: "wan"}}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	// bans do not overwrite each other
+	api := New(address, "key", "secret", "wan")
+	wg := sync.WaitGroup{}
+	for i := range 10 {
+		wg.Go(func() {
+			assert.NoError(t, api.BanIP(t.Context(), fmt.Sprintf("10.9.9.%d", i), time.Hour))
+		})
+	}
+	wg.Wait()
+
+	bans, err := api.ListBans()
+	require.NoError(t, err)
+	assert.Len(t, bans, 10)
+}
+
+func TestChunks(t *testing.T) {
+	f := &fakeOPN{aliases: map[string]*Alias{"wan": {Name: blockListName}}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	api := New(address, "key", "secret", "wan").WithChunks(2, 1)
+	for _, ip := range []string{"10.9.9.1", "10.9.9.2", "10.9.9.3", "10.9.9.1", "10.9.9.4"} {
+		require.NoError(t, api.BanIP(t.Context(), ip, time.Hour))
+	}
+	require.Contains(t, f.aliases, "uuid-block_list_1")
+	assert.Contains(t, f.aliases["wan"].Description, "10.9.9.1")
+	assert.Contains(t, f.aliases["wan"].Description, "10.9.9.2")
+	assert.Contains(t, f.aliases["uuid-block_list_1"].Description, "10.9.9.3")
+
+	err := api.BanIP(t.Context(), "10.9.9.5", time.Hour)
+	assert.ErrorIs(t, err, ErrFull)
+
+	bans, err := api.ListBans()
+	require.NoError(t, err)
+	assert.Len(t, bans, 4)
+
+	// the chunk is found by a new api, and a ban fits after an unban
+	api = New(address, "key", "secret", "wan").WithChunks(2, 1)
+	require.NoError(t, api.UnbanIP(t.Context(), "10.9.9.3"))
+	assert.NotContains(t, f.aliases["uuid-block_list_1"].Description, "10.9.9.3")
+	require.NoError(t, api.BanIP(t.Context(), "10.9.9.5", time.Hour))
+	assert.Contains(t, f.aliases["uuid-block_list_1"].Description, "10.9.9.5")
+}
+
+func TestWithCredentials(t *testing.T) {
+	f := &fakeOPN{aliases: map[string]*Alias{"wan": {Name: "wan"}}, pass: "secret-1"}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	var mu sync.Mutex
+	creds := firewall.Credentials{User: "key", Pass: "secret-1"}
+	api := New(address, "", "", "wan").WithCredentials(firewall.CredentialsFunc(func(ctx context.Context) (firewall.Credentials, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return creds, nil
+	}))
+	require.NoError(t, api.BanIP(t.Context(), "10.9.9.9", time.Hour))
+
+	// the secret is rotated on the device and in the provider
+	f.mu.Lock()
+	f.pass = "secret-2"
+	f.mu.Unlock()
+	assert.Error(t, api.BanIP(t.Context(), "10.9.9.8", time.Hour))
+
+	mu.Lock()
+	creds.Pass = "secret-2"
+	mu.Unlock()
+	require.NoError(t, api.BanIP(t.Context(), "10.9.9.8", time.Hour))
+	assert.Contains(t, f.aliases["wan"].Description, "10.9.9.8")
+}
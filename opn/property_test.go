@@ -0,0 +1,69 @@
+package opn
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"pgregory.net/rapid"
+)
+
+// margin keeps generated expiries away from now, newUpdateRequest reads the
+// clock itself.
+const margin = 5
+
+func TestProperty_NewUpdateRequest(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		now := time.Now().Unix()
+		expiries := rapid.MapOf(
+			rapid.Custom(func(t *rapid.T) string {
+				return fmt.Sprintf("10.0.0.%d", rapid.IntRange(1, 16).Draw(t, "ip"))
+			}),
+			rapid.Custom(func(t *rapid.T) int64 {
+				offset := rapid.Int64Range(margin, 1e7).Draw(t, "offset")
+				if rapid.Bool().Draw(t, "expired") {
+					offset = -offset
+				}
+				return now + offset
+			}),
+		).Draw(t, "expiries")
+
+		d, _ := json.Marshal(&IPsAndExpiries{Expiries: expiries})
+		ip := fmt.Sprintf("10.0.0.%d", rapid.IntRange(1, 16).Draw(t, "ban"))
+		minutes := rapid.IntRange(1, 1e5).Draw(t, "minutes")
+
+		r, err := newUpdateRequest(&Alias{Name: blockListName, Description: string(d)}, &ban{ip: ip, dur: time.Duration(minutes) * time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := &IPsAndExpiries{}
+		if err := json.Unmarshal([]byte(r.Alias.Description), got); err != nil {
+			t.Fatal(err)
+		}
+		content := map[string]bool{}
+		for _, it := range strings.Split(r.Alias.Content, "\n") {
+			content[it] = true
+		}
+
+		for k, exp := range expiries {
+			if exp <= now {
+				if k != ip && (content[k] || got.Expiries[k] != 0) {
+					t.Fatalf("expired %s survived", k)
+				}
+				continue
+			}
+			if !content[k] {
+				t.Fatalf("active %s dropped", k)
+			}
+			if got.Expiries[k] < exp {
+				t.Fatalf("expiry of %s moved from %d to %d", k, exp, got.Expiries[k])
+			}
+		}
+		if !content[ip] || got.Expiries[ip] < now+int64(minutes)*60-margin {
+			t.Fatalf("ban of %s missing", ip)
+		}
+	})
+}
@@ -0,0 +1,261 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Option configures optional behaviors of Firewall.
+type Option func(*Firewall)
+
+// WithMaxBanRate installs a safety valve: when more than n bans happen in a
+// minute, enforcement is paused until Resume is called. Errors are still
+// counted while paused, bans are logged as "ban-paused" but not sent to the
+// backend.
+func WithMaxBanRate(n int) Option {
+	return func(f *Firewall) {
+		if n <= 0 {
+			f.optErrs = append(f.optErrs, fmt.Errorf("max ban rate %d is not positive", n))
+			return
+		}
+		f.valve = &safetyValve{maxPerMinute: n}
+	}
+}
+
+// WithSharedValve checks the bans against v too, after the valve of
+// WithMaxBanRate if any: when more than its bans happen in a minute across
+// the firewalls sharing it, enforcement of all of them is paused until
+// Resume is called on any of them.
+func WithSharedValve(v *SharedValve) Option {
+	return func(f *Firewall) {
+		if v == nil {
+			f.optErrs = append(f.optErrs, errors.New("shared valve is nil"))
+			return
+		}
+		if v.v.maxPerMinute <= 0 {
+			f.optErrs = append(f.optErrs, fmt.Errorf("shared max ban rate %d is not positive", v.v.maxPerMinute))
+			return
+		}
+		f.sharedValve = &v.v
+	}
+}
+
+// WithLabels sets labels on every event, e.g. the tenant when firewalls
+// share loggers.
+func WithLabels(labels map[string]string) Option {
+	return func(f *Firewall) {
+		f.labels = labels
+	}
+}
+
+// WithStateStore persists state across restarts in store, e.g. when "banned"
+// events of an ip are last logged, so they stay deduped after a restart.
+func WithStateStore(store IStateStore) Option {
+	return func(f *Firewall) {
+		f.state = store
+	}
+}
+
+// WithReasonForgivable sets the thresholds of reason categories, e.g.
+// "bad password" forgiving 5 errors while "sql injection" bans on the first
+// one with Count 0. A category is a prefix of the reason, the longest one
+// matching applies, other reasons have the threshold passed to New. Each
+// category is counted separately per ip, a ban takes the BanInMinute of the
+// category of the error breaching it.
+func WithReasonForgivable(m map[string]ForgivableError) Option {
+	return func(f *Firewall) {
+		for _, category := range slices.Sorted(maps.Keys(m)) {
+			if err := m[category].validate(); err != nil {
+				f.optErrs = append(f.optErrs, fmt.Errorf("reason %q: %w", category, err))
+			}
+		}
+		f.reasonForgivable = m
+	}
+}
+
+// WithPolicy lets p decide whether an error is counted, ignored or bans the
+// ip immediately.
+func WithPolicy(p IPolicy) Option {
+	return func(f *Firewall) {
+		f.policy = p
+	}
+}
+
+// WithScoring bans ips by the decaying score of their errors instead of
+// counting them against the forgivable thresholds, see Scoring.
+func WithScoring(sc Scoring) Option {
+	return func(f *Firewall) {
+		if err := sc.validate(); err != nil {
+			f.optErrs = append(f.optErrs, err)
+		}
+		f.scoring = &sc
+	}
+}
+
+// WithBanFilter lets f change or veto bans before they are enforced.
+func WithBanFilter(filter IBanFilter) Option {
+	return func(f *Firewall) {
+		f.banFilter = filter
+	}
+}
+
+// WithDurationPolicy scales the minutes of bans by the origin of the ip,
+// before the ban filter.
+func WithDurationPolicy(p *DurationPolicy) Option {
+	return func(f *Firewall) {
+		f.durations = p
+	}
+}
+
+// WithASNPolicy escalates autonomous systems with many banned ips, see
+// ASNPolicy.
+func WithASNPolicy(p *ASNPolicy) Option {
+	return func(f *Firewall) {
+		f.asnPolicy = p
+	}
+}
+
+// WithBanCountries bans the ips of the countries of isoCodes, e.g. "KP",
+// on their first error instead of counting it. It requires the geo
+// database, the ban has the reason "country KP".
+func WithBanCountries(isoCodes ...string) Option {
+	return func(f *Firewall) {
+		f.banCountries = map[string]bool{}
+		for _, c := range isoCodes {
+			f.banCountries[strings.ToUpper(c)] = true
+		}
+	}
+}
+
+// WithCountryAllowlist bans the ips outside the countries of a on their
+// first error, except verified crawlers. It requires the geo database, the
+// ban has the reason "country XX not allowed".
+func WithCountryAllowlist(a CountryAllowlist) Option {
+	return func(f *Firewall) {
+		f.allowlist = &a
+		f.allowCountries = map[string]bool{}
+		for _, c := range a.Countries {
+			f.allowCountries[strings.ToUpper(c)] = true
+		}
+	}
+}
+
+// WithBlacklist bans the ips matching rules, ips or cidrs, on their first
+// error regardless of the forgivable thresholds. Whitelisted ips are never
+// banned. The ban has the reason "blacklisted".
+func WithBlacklist(rules ...string) Option {
+	return func(f *Firewall) {
+		f.blackList = []*ipMatcher{}
+		for _, r := range rules {
+			m, err := parseIPMatcher(r)
+			if err != nil {
+				f.optErrs = append(f.optErrs, fmt.Errorf("blacklist: %w", err))
+				continue
+			}
+			f.blackList = append(f.blackList, m)
+		}
+	}
+}
+
+// WithASWhitelist never bans the ips of the autonomous systems of rules,
+// by number, "AS15169", or by a case insensitive substring of the
+// organization, "Google", e.g. health checkers and corporate egress whose
+// ips change. It requires the geo database with the ASN database.
+func WithASWhitelist(rules ...string) Option {
+	return func(f *Firewall) {
+		f.asWhitelist, f.orgWhitelist = parseASWhitelist(rules)
+	}
+}
+
+// WithCountryWhitelist never bans the ips of the countries of isoCodes,
+// e.g. the own country of a LAN-only service. It requires the geo
+// database.
+func WithCountryWhitelist(isoCodes ...string) Option {
+	return func(f *Firewall) {
+		f.countryWhitelist = map[string]bool{}
+		for _, c := range isoCodes {
+			f.countryWhitelist[strings.ToUpper(c)] = true
+		}
+	}
+}
+
+// WithSkipPrivateIPs never bans private (10.0.0.0/8, 172.16.0.0/12,
+// 192.168.0.0/16 and fc00::/7), loopback and link local ips, e.g. a
+// reverse proxy or a health checker locked out by accident.
+func WithSkipPrivateIPs(enabled bool) Option {
+	return func(f *Firewall) {
+		f.skipPrivateIPs = enabled
+	}
+}
+
+// WithJailCapacity logs a "jail-capacity" event when more than n ips are
+// jailed, e.g. set it under what the backend alias holds before the
+// appliance slows down. It is logged again once the jail went back under n.
+func WithJailCapacity(n int) Option {
+	return func(f *Firewall) {
+		f.jailCapacity = n
+	}
+}
+
+// WithHooks runs h on bans, unbans and backend errors.
+func WithHooks(h Hooks) Option {
+	return func(f *Firewall) {
+		f.hooks = h
+	}
+}
+
+// WithCountErrorGeo toggles the geo data of "count error" events, on by
+// default. It is looked up once per decision of an ip and cached in its
+// counter, disable it to save the lookups on busy instances.
+func WithCountErrorGeo(enabled bool) Option {
+	return func(f *Firewall) {
+		f.countErrorGeo = enabled
+	}
+}
+
+// WithWhitelistUnban toggles unbanning the jailed ips matching a whitelist
+// rule added at runtime, on by default. When disabled they stay banned until
+// released, and each is logged as "whitelist-overlap".
+func WithWhitelistUnban(enabled bool) Option {
+	return func(f *Firewall) {
+		f.whitelistUnban = enabled
+	}
+}
+
+// WithQueue buffers up to size bans and size errors for the loop, so
+// callers do not wait for a slow backend call, and sets what happens when a
+// queue is full.
+func WithQueue(size int, overflow OverflowPolicy) Option {
+	return func(f *Firewall) {
+		f.banCh = make(chan ban, max(size, 0))
+		f.countCh = make(chan countingError, max(size, 0))
+		f.overflow = overflow
+	}
+}
+
+// WithBanWorkers calls the backend for bans in n goroutines instead of the
+// loop, so a slow backend call does not delay counting errors of other ips.
+// The ip is jailed when the ban is decided and the "ban" event is logged
+// once the backend returns. Errors are still counted in the loop. The
+// backend must be safe for concurrent use if n > 1, the opn and pf
+// backends serialize their alias writes. Bans wait for a worker in a
+// bounded queue, when it is full they are dropped and logged as
+// "ban-dropped", counted by Dropped.
+func WithBanWorkers(n int) Option {
+	return func(f *Firewall) {
+		f.banWorkers = max(n, 0)
+	}
+}
+
+// WithDeadManSwitch pauses enforcement, see Pause, when Heartbeat is not
+// called for timeout, e.g. the daemon lost contact with its config source.
+// It stays paused until Resume is called, heartbeats do not resume it.
+func WithDeadManSwitch(timeout time.Duration) Option {
+	return func(f *Firewall) {
+		f.deadManTimeout = timeout
+	}
+}
@@ -0,0 +1,63 @@
+package firewall
+
+import (
+	"fmt"
+	"time"
+)
+
+// Pause stops enforcement, e.g. during a migration where false positives
+// are likely. Errors are still counted, new bans are logged as "ban-paused"
+// but neither sent to the backend nor jailed, existing bans persist until
+// they are released. It logs the "pause" alert, enforcement stays paused
+// until Resume is called.
+func (s *Firewall) Pause(reason string) {
+	s.do(func() {
+		s.pause(reason)
+	})
+}
+
+func (s *Firewall) pause(reason string) {
+	if s.pausedBy != "" {
+		return
+	}
+	s.pausedBy = reason
+	s.log(&Event{
+		Reasons: []string{reason},
+		Action:  "pause",
+	})
+}
+
+// Heartbeat tells the dead man's switch set by WithDeadManSwitch that the
+// caller, e.g. the config source of the daemon, is alive.
+func (s *Firewall) Heartbeat() {
+	s.do(func() {
+		s.lastHeartbeat = s.now()
+	})
+}
+
+// checkHeartbeat pauses enforcement if no heartbeat arrived within the
+// timeout of the dead man's switch.
+func (s *Firewall) checkHeartbeat(now time.Time) {
+	if s.deadManTimeout <= 0 || s.pausedBy != "" {
+		return
+	}
+	if now.Sub(s.lastHeartbeat) > s.deadManTimeout {
+		s.pause(fmt.Sprintf("no heartbeat for %s", s.deadManTimeout))
+	}
+}
+
+// logBanPaused logs b as "ban-paused", it is not enforced.
+func (s *Firewall) logBanPaused(b *ban, now time.Time) {
+	s.log(&Event{
+		IP:            b.ip,
+		JailUntil:     now.Add(time.Duration(b.timeoutInMinute) * time.Minute),
+		Reasons:       reasonsOf(b.offenses),
+		Action:        "ban-paused",
+		Offenses:      b.offenses,
+		ReasonCounts:  b.reasons(),
+		Annotation:    b.annotation,
+		Metadata:      b.metadata,
+		BanAction:     b.action,
+		CorrelationID: b.correlationID,
+	})
+}
@@ -0,0 +1,108 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPause(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	paused := []string{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable, WithHooks(Hooks{
+		OnPause: func(e *Event) { paused = append(paused, e.Reasons[0]) },
+	}))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.2.3.4", 10, "r")
+	mockLogger.Wg.Wait()
+
+	mockLogger.Wg.Add(2)
+	fw.Pause("migration")
+	fw.Pause("again")
+	fw.BanIP("1.2.3.5", 10, "r")
+	mockLogger.Wg.Wait()
+	waitHooks(fw)
+
+	assert.True(t, fw.Paused())
+	assert.Equal(t, []string{"1.2.3.4"}, mockFW.BannedIPs)
+	assert.Equal(t, "pause", mockLogger.Events[1].Action)
+	assert.Equal(t, []string{"migration"}, mockLogger.Events[1].Reasons)
+	assert.Equal(t, "ban-paused", mockLogger.Events[2].Action)
+	assert.Equal(t, []string{"migration"}, paused)
+
+	// existing bans persist, paused ones are not jailed
+	banned, _ := fw.IsBanned("1.2.3.4")
+	assert.True(t, banned)
+	banned, _ = fw.IsBanned("1.2.3.5")
+	assert.False(t, banned)
+
+	mockLogger.Wg.Add(2)
+	fw.Resume()
+	fw.BanIP("1.2.3.5", 10, "r")
+	mockLogger.Wg.Wait()
+	assert.False(t, fw.Paused())
+	assert.Equal(t, "resume", mockLogger.Events[3].Action)
+	assert.Equal(t, []string{"1.2.3.4", "1.2.3.5"}, mockFW.BannedIPs)
+}
+
+func TestDeadManSwitch(t *testing.T) {
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, &MockIFirewall{}, mockLogger, nil, testForgivable, WithDeadManSwitch(time.Minute))
+	require.NoError(t, err)
+
+	fw.Heartbeat()
+	fw.do(func() {
+		fw.checkHeartbeat(time.Now().Add(30 * time.Second))
+	})
+	assert.False(t, fw.Paused())
+
+	mockLogger.Wg.Add(1)
+	fw.do(func() {
+		fw.checkHeartbeat(time.Now().Add(2 * time.Minute))
+	})
+	mockLogger.Wg.Wait()
+	assert.True(t, fw.Paused())
+	require.Len(t, mockLogger.Events, 1)
+	assert.Equal(t, "pause", mockLogger.Events[0].Action)
+	assert.Equal(t, []string{"no heartbeat for 1m0s"}, mockLogger.Events[0].Reasons)
+
+	// heartbeats do not resume enforcement
+	fw.Heartbeat()
+	assert.True(t, fw.Paused())
+
+	mockLogger.Wg.Add(1)
+	fw.Resume()
+	mockLogger.Wg.Wait()
+	fw.do(func() {
+		fw.checkHeartbeat(time.Now().Add(30 * time.Second))
+	})
+	assert.False(t, fw.Paused())
+}
+
+func TestPause_CountsErrors(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(4)
+	fw.Pause("migration")
+	fw.LogIPError("1.2.3.4", "bad password")
+	fw.LogIPError("1.2.3.4", "bad password")
+	fw.LogIPError("1.2.3.4", "bad password")
+	mockLogger.Wg.Wait()
+
+	// the paused ban does not mark the ip banned, its errors are still
+	// counted.
+	actions := []string{}
+	for _, e := range mockLogger.Events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"pause", "count error", "ban-paused", "ban-paused"}, actions)
+	assert.Empty(t, mockFW.BannedIPs)
+}
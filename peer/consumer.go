@@ -0,0 +1,191 @@
+package peer
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFeedAge rejects replayed feeds.
+	maxFeedAge = time.Hour
+	// maxBanMinutes caps bans taken from a peer.
+	maxBanMinutes = 7 * 24 * 60
+	maxFeedSize   = 16 << 20
+)
+
+// Peer is a deployment whose feed is consumed.
+type Peer struct {
+	Name      string
+	URL       string
+	PublicKey ed25519.PublicKey
+	// Ban applies the peer's bans directly. Otherwise each ban counts as
+	// Weight errors of the ip, a watchlist.
+	Ban    bool
+	Weight int
+}
+
+// Target is where consumed bans go, a *firewall.Firewall.
+type Target interface {
+	BanIP(ip string, timeoutInMinute int, reason string)
+	LogIPError(ip string, reason string)
+}
+
+// Consumer polls the peers' feeds. Each ban of a feed is applied once.
+type Consumer struct {
+	peers    []Peer
+	target   Target
+	client   *http.Client
+	interval time.Duration
+
+	mu sync.Mutex
+	// applied bans per peer, ip to until.
+	applied map[string]map[string]time.Time
+	// generated time of the last feed per peer.
+	last map[string]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewConsumer(target Target, peers []Peer, interval time.Duration) *Consumer {
+	return &Consumer{
+		peers:    peers,
+		target:   target,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		interval: interval,
+		applied:  map[string]map[string]time.Time{},
+		last:     map[string]time.Time{},
+	}
+}
+
+// Start polls in background until Close.
+func (c *Consumer) Start() {
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			if err := c.Poll(context.Background()); err != nil {
+				log.Printf("poll peer feeds failed: %v", err)
+			}
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *Consumer) Close() {
+	if c.stop == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+}
+
+// Poll fetches and applies the feed of every peer.
+func (c *Consumer) Poll(ctx context.Context) error {
+	var errs []error
+	for i := range c.peers {
+		p := &c.peers[i]
+		f, err := c.fetch(ctx, p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("peer %s: %w", p.Name, err))
+			continue
+		}
+		c.apply(p, f)
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Consumer) fetch(ctx context.Context, p *Peer) (*Feed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("code = %d, resp = %q", resp.StatusCode, body)
+	}
+
+	f, err := Verify(body, p.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.Sub(f.Generated) > maxFeedAge {
+		return nil, fmt.Errorf("feed generated at %s is too old", f.Generated)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !f.Generated.After(c.last[p.Name]) {
+		return nil, fmt.Errorf("feed generated at %s is not newer than the last one", f.Generated)
+	}
+	c.last[p.Name] = f.Generated
+	return f, nil
+}
+
+func (c *Consumer) apply(p *Peer, f *Feed) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	applied := c.applied[p.Name]
+	if applied == nil {
+		applied = map[string]time.Time{}
+		c.applied[p.Name] = applied
+	}
+	for ip, until := range applied {
+		if !until.After(now) {
+			delete(applied, ip)
+		}
+	}
+
+	for _, b := range f.Bans {
+		if !b.Until.After(now) {
+			continue
+		}
+		if net.ParseIP(b.IP) == nil {
+			continue
+		}
+		if until, ok := applied[b.IP]; ok && !b.Until.After(until) {
+			continue
+		}
+		applied[b.IP] = b.Until
+
+		reason := fmt.Sprintf("peer %s: %s", p.Name, strings.Join(b.Reasons, "; "))
+		if p.Ban {
+			minutes := int(math.Ceil(b.Until.Sub(now).Minutes()))
+			c.target.BanIP(b.IP, min(minutes, maxBanMinutes), reason)
+			continue
+		}
+		for i := 0; i < p.Weight; i++ {
+			c.target.LogIPError(b.IP, reason)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package peer shares bans between trusted deployments. A deployment
+// publishes its active bans as an ed25519 signed JSON feed, peers consume
+// it either as direct bans or as a watchlist.
+package peer
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Ban is an entry of a feed.
+type Ban struct {
+	IP      string    `json:"ip"`
+	Until   time.Time `json:"until"`
+	Reasons []string  `json:"reasons,omitempty"`
+}
+
+// Feed is the bans of a deployment at Generated.
+type Feed struct {
+	Issuer    string    `json:"issuer"`
+	Generated time.Time `json:"generated"`
+	Bans      []Ban     `json:"bans"`
+}
+
+// signedFeed is the wire format, the signature is over the feed bytes as
+// is.
+type signedFeed struct {
+	Feed      json.RawMessage `json:"feed"`
+	Signature []byte          `json:"signature"`
+}
+
+// Sign encodes f signed with key.
+func Sign(f *Feed, key ed25519.PrivateKey) ([]byte, error) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&signedFeed{
+		Feed:      b,
+		Signature: ed25519.Sign(key, b),
+	})
+}
+
+// Verify decodes a signed feed, it fails if the signature is not made by
+// pub.
+func Verify(data []byte, pub ed25519.PublicKey) (*Feed, error) {
+	sf := &signedFeed{}
+	if err := json.Unmarshal(data, sf); err != nil {
+		return nil, fmt.Errorf("decode feed failed: %w", err)
+	}
+	if !ed25519.Verify(pub, sf.Feed, sf.Signature) {
+		return nil, errors.New("invalid feed signature")
+	}
+
+	f := &Feed{}
+	if err := json.Unmarshal(sf.Feed, f); err != nil {
+		return nil, fmt.Errorf("decode feed failed: %w", err)
+	}
+	return f, nil
+}
+
+// ParsePrivateKey parses a base64 encoded ed25519 private key.
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key")
+	}
+	return ed25519.PrivateKey(b), nil
+}
+
+// ParsePublicKey parses a base64 encoded ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid ed25519 public key")
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// Handler serves the bans returned by source as a signed feed.
+func Handler(issuer string, key ed25519.PrivateKey, source func() ([]Ban, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bans, err := source()
+		if err != nil {
+			log.Printf("read bans for feed failed: %v", err)
+			http.Error(w, "read bans failed", http.StatusInternalServerError)
+			return
+		}
+
+		b, err := Sign(&Feed{
+			Issuer:    issuer,
+			Generated: time.Now(),
+			Bans:      bans,
+		}, key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
@@ -0,0 +1,109 @@
+package peer
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	f := &Feed{
+		Issuer:    "site-a",
+		Generated: time.Unix(1000, 0).UTC(),
+		Bans:      []Ban{{IP: "1.2.3.4", Until: time.Unix(2000, 0).UTC(), Reasons: []string{"scanner"}}},
+	}
+	b, err := Sign(f, key)
+	require.NoError(t, err)
+
+	got, err := Verify(b, pub)
+	require.NoError(t, err)
+	assert.Equal(t, f, got)
+
+	_, err = Verify(b, otherPub)
+	assert.Error(t, err)
+}
+
+type mockTarget struct {
+	bans   []string
+	errors []string
+}
+
+func (m *mockTarget) BanIP(ip string, timeoutInMinute int, reason string) {
+	m.bans = append(m.bans, ip)
+}
+
+func (m *mockTarget) LogIPError(ip string, reason string) {
+	m.errors = append(m.errors, ip)
+}
+
+func TestConsumer(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	bans := []Ban{
+		{IP: "1.2.3.4", Until: time.Now().Add(time.Hour)},
+		{IP: "1.2.3.5", Until: time.Now().Add(-time.Minute)},
+		{IP: "2001:db8::1", Until: time.Now().Add(time.Hour)},
+		{IP: "not an ip", Until: time.Now().Add(time.Hour)},
+	}
+	srv := httptest.NewServer(Handler("site-a", key, func() ([]Ban, error) {
+		return bans, nil
+	}))
+	defer srv.Close()
+
+	target := &mockTarget{}
+	c := NewConsumer(target, []Peer{
+		{Name: "ban", URL: srv.URL, PublicKey: pub, Ban: true},
+		{Name: "watch", URL: srv.URL, PublicKey: pub, Weight: 2},
+	}, time.Minute)
+
+	require.NoError(t, c.Poll(context.Background()))
+	assert.Equal(t, []string{"1.2.3.4", "2001:db8::1"}, target.bans)
+	assert.Equal(t, []string{"1.2.3.4", "1.2.3.4", "2001:db8::1", "2001:db8::1"}, target.errors)
+
+	// bans already applied are skipped
+	bans = append(bans, Ban{IP: "1.2.3.6", Until: time.Now().Add(time.Hour)})
+	require.NoError(t, c.Poll(context.Background()))
+	assert.Equal(t, []string{"1.2.3.4", "2001:db8::1", "1.2.3.6"}, target.bans)
+}
+
+func TestConsumer_InvalidFeed(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(Handler("site-a", key, func() ([]Ban, error) {
+		return []Ban{{IP: "1.2.3.4", Until: time.Now().Add(time.Hour)}}, nil
+	}))
+	defer srv.Close()
+
+	old, err := Sign(&Feed{Generated: time.Now().Add(-2 * time.Hour)}, key)
+	require.NoError(t, err)
+	oldSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(old)
+	}))
+	defer oldSrv.Close()
+
+	target := &mockTarget{}
+	c := NewConsumer(target, []Peer{
+		{Name: "wrong-key", URL: srv.URL, PublicKey: otherPub, Ban: true},
+		{Name: "replay", URL: oldSrv.URL, PublicKey: key.Public().(ed25519.PublicKey), Ban: true},
+	}, time.Minute)
+
+	err = c.Poll(context.Background())
+	assert.ErrorContains(t, err, "invalid feed signature")
+	assert.ErrorContains(t, err, "too old")
+	assert.Empty(t, target.bans)
+}
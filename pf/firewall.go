@@ -2,19 +2,27 @@ package pf
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charleshuang3/firewall"
 )
 
-var _ firewall.IFirewall = (*API)(nil)
+var (
+	_ firewall.IFirewallV2      = (*API)(nil)
+	_ firewall.IUnbanFirewallV2 = (*API)(nil)
+	_ firewall.INetworkFirewall = (*API)(nil)
+)
 
 const (
 	blockListName = "block_list"
@@ -23,25 +31,63 @@ const (
 
 type API struct {
 	address string
-	user    string
-	pass    string
+	creds   firewall.ICredentialProvider
+	// capacity is the most entries of an alias, 0 for no limit. Bans over
+	// it go to the chunk aliases block_list_1..chunks.
+	capacity int
+	chunks   int
+
+	// writeMu serializes the read-modify-write of the aliases, so
+	// concurrent bans do not overwrite each other.
+	writeMu sync.Mutex
 }
 
+// ErrFull is returned when a ban does not fit in the block list alias and
+// its chunks.
+var ErrFull = errors.New("block list aliases are full")
+
 type ban struct {
-	ip              string
-	timeoutInMinute int
+	ip  string
+	dur time.Duration
 }
 
 func New(address, user, pass string) *API {
 	api := &API{
 		address: address,
-		user:    user,
-		pass:    pass,
+		creds:   firewall.StaticCredentials{User: user, Pass: pass},
 	}
 
 	return api
 }
 
+// WithChunks limits each alias to capacity entries, pfSense slows down
+// reloading huge aliases. Bans over it are split across the aliases
+// block_list_1..block_list_<chunks>, created when first needed, rules
+// should match all of them, e.g. with a nested alias. A ban fails with
+// ErrFull when all are full.
+func (s *API) WithChunks(capacity, chunks int) *API {
+	s.capacity = capacity
+	s.chunks = chunks
+	return s
+}
+
+// WithCredentials replaces the client id and token of New by p, asked on
+// every call, e.g. to pick up rotated api keys.
+func (s *API) WithCredentials(p firewall.ICredentialProvider) *API {
+	s.creds = p
+	return s
+}
+
+// auth sets the current credentials on r.
+func (s *API) auth(r *http.Request) error {
+	c, err := s.creds.Credentials(r.Context())
+	if err != nil {
+		return fmt.Errorf("get credentials failed: %w", err)
+	}
+	r.Header.Add("Authorization", c.User+" "+c.Pass)
+	return nil
+}
+
 type GetAliasResponse struct {
 	Status  string   `json:"status"`
 	Code    int      `json:"code"`
@@ -69,36 +115,120 @@ type UpdateAliasRequest struct {
 	Detail  []string `json:"detail"`
 }
 
-func (s *API) request(b *ban) {
-	// read current block list first
-	alias, err := s.readAlias()
+func (s *API) request(ctx context.Context, b *ban) error {
+	// read current block lists first
+	reqs, err := s.readChunks(ctx)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
+	}
+
+	i := s.chunkOf(reqs, b.ip)
+	if i < 0 {
+		return fmt.Errorf("ban %s failed: %w", b.ip, ErrFull)
+	}
+
+	method := http.MethodPut
+	if reqs[i] == nil {
+		// the chunk is created with the ban
+		method = http.MethodPost
+		reqs[i] = &UpdateAliasRequest{Name: chunkName(i), Type: "host", Address: []string{}, Detail: []string{}}
 	}
 
 	// remove expired and add new block
-	r := newUpdateRequest(alias)
-	r.Address = append(r.Address, b.ip)
-	r.Detail = append(r.Detail, strconv.FormatInt(time.Now().Add(time.Duration(b.timeoutInMinute)*time.Minute).Unix(), 10))
+	reqs[i].extend(b.ip, expiryAt(time.Now(), b.dur))
+
+	return s.sendAlias(ctx, method, reqs[i])
+}
+
+// chunkName returns the name of the alias of chunk i, 0 is the block list.
+func chunkName(i int) string {
+	if i == 0 {
+		return blockListName
+	}
+	return fmt.Sprintf("%s_%d", blockListName, i)
+}
 
-	if err = s.updateAlias(r); err != nil {
-		log.Println(err)
+// chunkOf returns the chunk to write ip to: the one having it, else the
+// first with room, else the first missing one, or -1 if all are full.
+func (s *API) chunkOf(reqs []*UpdateAliasRequest, ip string) int {
+	for i, r := range reqs {
+		if r != nil && slices.Contains(r.Address, ip) {
+			return i
+		}
+	}
+	for i, r := range reqs {
+		if r != nil && (s.capacity <= 0 || len(r.Address) < s.capacity) {
+			return i
+		}
 	}
+	for i, r := range reqs {
+		if r == nil {
+			return i
+		}
+	}
+	return -1
 }
 
-func (s *API) readAlias() (*Alias, error) {
-	r, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/api/v1/firewall/alias", s.address), nil)
+// readChunks returns the parsed block list and its chunks, nil for the
+// chunks not created yet.
+func (s *API) readChunks(ctx context.Context) ([]*UpdateAliasRequest, error) {
+	aliases, err := s.readAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := 0
+	if s.capacity > 0 {
+		chunks = max(s.chunks, 0)
+	}
+	reqs := make([]*UpdateAliasRequest, chunks+1)
+	for _, a := range aliases {
+		for i := range reqs {
+			if a.Name == chunkName(i) {
+				reqs[i] = newUpdateRequest(a)
+			}
+		}
+	}
+	if reqs[0] == nil {
+		return nil, errNoAlias
+	}
+
+	return reqs, nil
+}
+
+var errNoAlias = fmt.Errorf("no '%s' alias in pfsense", blockListName)
+
+func (s *API) readAlias(ctx context.Context) (*Alias, error) {
+	aliases, err := s.readAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range aliases {
+		if a.Name == blockListName {
+			return a, nil
+		}
+	}
+
+	return nil, errNoAlias
+}
+
+// readAliases returns all aliases of the appliance.
+func (s *API) readAliases(ctx context.Context) ([]*Alias, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/api/v1/firewall/alias", s.address), nil)
 	if err != nil {
 		// it should not happen unless config invalid.
 		return nil, fmt.Errorf("new request failed: %w", err)
 	}
-	r.Header.Add("Authorization", s.user+" "+s.pass)
+	if err := s.auth(r); err != nil {
+		return nil, err
+	}
 
 	resp, err := http.DefaultClient.Do(r)
 	if err != nil {
 		return nil, fmt.Errorf("get alias failed: %w", err)
 	}
+	defer resp.Body.Close()
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -106,7 +236,7 @@ func (s *API) readAlias() (*Alias, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get alias failed: code = %d, resp = %q", resp.StatusCode, string(b))
+		return nil, fmt.Errorf("get alias failed: %w", &firewall.StatusError{Code: resp.StatusCode, Body: string(b)})
 	}
 
 	o := &GetAliasResponse{}
@@ -116,98 +246,208 @@ func (s *API) readAlias() (*Alias, error) {
 	}
 
 	if o.Code != http.StatusOK {
-		return nil, fmt.Errorf("get alias failed: code = %d, resp = %q", o.Code, string(b))
+		return nil, fmt.Errorf("get alias failed: %w", &firewall.StatusError{Code: o.Code, Body: string(b)})
 	}
 
-	for _, a := range o.Data {
-		if a.Name == blockListName {
-			return a, nil
-		}
-	}
-
-	return nil, fmt.Errorf("no 'block_list' alias in pfsense")
+	return o.Data, nil
 }
 
+// newUpdateRequest parses the alias, Address is space separated ips and
+// Detail is "||" separated expiries of them. Both are editable on the
+// appliance: entries not being an ip or cidr are dropped, ips without a
+// valid expiry get defaultTTL, duplicated ips keep the latest expiry and
+// expired ips are removed.
 func newUpdateRequest(a *Alias) *UpdateAliasRequest {
 	r := &UpdateAliasRequest{
-		ID:    a.Name,
-		Name:  a.Name,
-		Descr: a.Descr,
-		Type:  a.Type,
-	}
-
-	type banned struct {
-		ip     string
-		expiry int64
+		ID:      a.Name,
+		Name:    a.Name,
+		Descr:   a.Descr,
+		Type:    a.Type,
+		Address: []string{},
+		Detail:  []string{},
 	}
 
-	var curr []banned
-	for _, ip := range strings.Split(a.Address, " ") {
-		curr = append(curr, banned{ip: ip})
+	var expiries []string
+	if a.Detail != "" {
+		expiries = strings.Split(a.Detail, "||")
 	}
 
 	now := time.Now()
-	expiries := strings.Split(a.Detail, "||")
-	for i := 0; i < len(expiries); i++ {
-		if i >= len(curr) {
-			break
-		}
-		exp, err := strconv.ParseInt(expiries[i], 10, 64)
-		if err != nil {
-			exp = now.Add(defaultTTL).Unix()
+	nowTs := now.Unix()
+	for i, ip := range strings.Fields(a.Address) {
+		if !validAddress(ip) {
+			continue
 		}
-		curr[i].expiry = exp
-	}
 
-	for _, c := range curr {
-		if c.expiry == 0 {
-			c.expiry = now.Add(defaultTTL).Unix()
+		exp := now.Add(defaultTTL).Unix()
+		if i < len(expiries) {
+			if v, err := strconv.ParseInt(strings.TrimSpace(expiries[i]), 10, 64); err == nil {
+				exp = v
+			}
 		}
-	}
 
-	// remove expiried banned ip
-	nowTs := now.Unix()
-	for _, c := range curr {
-		if c.expiry <= nowTs {
+		if exp <= nowTs {
+			// remove expiried banned ip
 			continue
 		}
-		r.Address = append(r.Address, c.ip)
-		r.Detail = append(r.Detail, strconv.FormatInt(c.expiry, 10))
+		r.extend(ip, exp)
 	}
 
 	return r
 }
 
-func (s *API) updateAlias(o *UpdateAliasRequest) error {
+// expiryAt returns the unix time dur after now.
+func expiryAt(now time.Time, dur time.Duration) int64 {
+	return now.Add(dur).Unix()
+}
+
+func validAddress(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+// extend adds ip, or moves its expiry later if it exists. A ban never
+// shortens an existing one.
+func (r *UpdateAliasRequest) extend(ip string, expiry int64) {
+	d := strconv.FormatInt(expiry, 10)
+	if i := slices.Index(r.Address, ip); i >= 0 {
+		if curr, err := strconv.ParseInt(r.Detail[i], 10, 64); err != nil || curr < expiry {
+			r.Detail[i] = d
+		}
+		return
+	}
+	r.Address = append(r.Address, ip)
+	r.Detail = append(r.Detail, d)
+}
+
+// remove deletes ip if exists.
+func (r *UpdateAliasRequest) remove(ip string) {
+	if i := slices.Index(r.Address, ip); i >= 0 {
+		r.Address = slices.Delete(r.Address, i, i+1)
+		r.Detail = slices.Delete(r.Detail, i, i+1)
+	}
+}
+
+func (s *API) updateAlias(ctx context.Context, o *UpdateAliasRequest) error {
+	return s.sendAlias(ctx, http.MethodPut, o)
+}
+
+func (s *API) sendAlias(ctx context.Context, method string, o *UpdateAliasRequest) error {
 	b, err := json.Marshal(o)
 	if err != nil {
 		return fmt.Errorf("json.Marshal failed: %w", err)
 	}
 
-	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/api/v1/firewall/alias", s.address), bytes.NewReader(b))
+	r, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("http://%s/api/v1/firewall/alias", s.address), bytes.NewReader(b))
 	if err != nil {
 		// it should not happen unless config invalid.
 		return fmt.Errorf("new request failed: %w", err)
 	}
 
-	r.Header.Add("Authorization", s.user+" "+s.pass)
+	if err := s.auth(r); err != nil {
+		return err
+	}
 
 	resp, err := http.DefaultClient.Do(r)
 	if err != nil {
 		return fmt.Errorf("update alias failed: %w", err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		b, err := io.ReadAll(resp.Body)
 		if err != nil {
 			return fmt.Errorf("update get alias response failed: %w", err)
 		}
-		return fmt.Errorf("update alias failed: code = %d, resp = %q", resp.StatusCode, string(b))
+		return fmt.Errorf("update alias failed: %w", &firewall.StatusError{Code: resp.StatusCode, Body: string(b)})
 	}
 
 	return nil
 }
 
-func (s *API) BanIP(ip string, timeoutInMinute int) {
-	s.request(&ban{ip: ip, timeoutInMinute: timeoutInMinute})
+func (s *API) Name() string {
+	return "pf"
+}
+
+// BansNetworks returns true, the alias accepts cidrs.
+func (s *API) BansNetworks() bool {
+	return true
+}
+
+// BanIP adds ip to the block list alias for dur.
+func (s *API) BanIP(ctx context.Context, ip string, dur time.Duration) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.request(ctx, &ban{ip: ip, dur: dur})
+}
+
+// UnbanIP removes ip from the block list alias and its chunks.
+func (s *API) UnbanIP(ctx context.Context, ip string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	reqs, err := s.readChunks(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, r := range reqs {
+		if r == nil || (i > 0 && !slices.Contains(r.Address, ip)) {
+			continue
+		}
+		r.remove(ip)
+		if err := s.updateAlias(ctx, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListBans returns the not expired ips in the block list alias and its
+// chunks.
+func (s *API) ListBans() ([]firewall.BackendBan, error) {
+	reqs, err := s.readChunks(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	res := []firewall.BackendBan{}
+	for _, r := range reqs {
+		if r == nil {
+			continue
+		}
+		for i, ip := range r.Address {
+			exp, _ := strconv.ParseInt(r.Detail[i], 10, 64)
+			res = append(res, firewall.BackendBan{IP: ip, ExpireAt: time.Unix(exp, 0)})
+		}
+	}
+
+	return res, nil
+}
+
+// EnsureAlias creates the "block_list" alias if it does not exist.
+func (s *API) EnsureAlias() error {
+	_, err := s.readAlias(context.Background())
+	if !errors.Is(err, errNoAlias) {
+		return err
+	}
+
+	return s.sendAlias(context.Background(), http.MethodPost, &UpdateAliasRequest{
+		Name:    blockListName,
+		Type:    "host",
+		Address: []string{},
+		Detail:  []string{},
+	})
+}
+
+// Ping checks the API is reachable and the credentials are accepted.
+func (s *API) Ping() error {
+	_, err := s.readAlias(context.Background())
+	if errors.Is(err, errNoAlias) {
+		return nil
+	}
+	return err
 }
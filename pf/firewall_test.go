@@ -0,0 +1,149 @@
+package pf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUpdateRequest(t *testing.T) {
+	now := time.Now().Unix()
+	future := strconv.FormatInt(now+600, 10)
+	past := strconv.FormatInt(now-600, 10)
+
+	tests := []struct {
+		name    string
+		address string
+		detail  string
+		want    []string
+	}{
+		{"empty", "", "", []string{}},
+		{"expired removed", "10.0.0.1 10.0.0.2", future + "||" + past, []string{"10.0.0.1"}},
+		{"missing detail", "10.0.0.1 10.0.0.2", future, []string{"10.0.0.1", "10.0.0.2"}},
+		{"extra detail", "10.0.0.1", future + "||" + future, []string{"10.0.0.1"}},
+		{"invalid detail", "10.0.0.1", "x", []string{"10.0.0.1"}},
+		{"extra spaces", " 10.0.0.1  10.0.0.2 ", future + "||" + future, []string{"10.0.0.1", "10.0.0.2"}},
+		{"not ip", "host.example 10.0.0.0/8", future + "||" + future, []string{"10.0.0.0/8"}},
+		{"duplicated", "10.0.0.1 10.0.0.1", future + "||" + past, []string{"10.0.0.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newUpdateRequest(&Alias{Name: blockListName, Address: tt.address, Detail: tt.detail})
+			assert.Equal(t, tt.want, r.Address)
+			assert.Len(t, r.Detail, len(r.Address))
+		})
+	}
+}
+
+func FuzzNewUpdateRequest(f *testing.F) {
+	now := time.Now().Unix()
+	f.Add("", "")
+	f.Add("10.0.0.1 10.0.0.2", strconv.FormatInt(now+600, 10)+"||"+strconv.FormatInt(now-600, 10))
+	f.Add("10.0.0.1  ", "||")
+	f.Add("a b c", "1||2||3||4")
+
+	f.Fuzz(func(t *testing.T, address, detail string) {
+		r := newUpdateRequest(&Alias{Name: blockListName, Address: address, Detail: detail})
+		r.extend("10.9.9.9", now+60)
+
+		if len(r.Address) != len(r.Detail) {
+			t.Fatalf("%d addresses, %d details", len(r.Address), len(r.Detail))
+		}
+		seen := map[string]bool{}
+		for i, a := range r.Address {
+			if !validAddress(a) {
+				t.Fatalf("invalid address %q", a)
+			}
+			if seen[a] {
+				t.Fatalf("duplicated address %q", a)
+			}
+			seen[a] = true
+
+			exp, err := strconv.ParseInt(r.Detail[i], 10, 64)
+			if err != nil || exp <= now-1 {
+				t.Fatalf("invalid detail %q of %q", r.Detail[i], a)
+			}
+		}
+		if !seen["10.9.9.9"] {
+			t.Fatal("new ban missing")
+		}
+	})
+}
+
+// fakePF serves the alias endpoint, aliases are replaced by name on PUT and
+// added on POST.
+type fakePF struct {
+	mu      sync.Mutex
+	aliases []*Alias
+}
+
+func (f *fakePF) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(&GetAliasResponse{Code: http.StatusOK, Data: f.aliases})
+		return
+	}
+
+	req := &UpdateAliasRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a := &Alias{Name: req.Name, Type: req.Type, Address: strings.Join(req.Address, " "), Detail: strings.Join(req.Detail, "||")}
+	for i, old := range f.aliases {
+		if old.Name == req.Name {
+			f.aliases[i] = a
+			return
+		}
+	}
+	f.aliases = append(f.aliases, a)
+}
+
+// address returns the space separated ips of alias name.
+func (f *fakePF) address(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, a := range f.aliases {
+		if a.Name == name {
+			return a.Address
+		}
+	}
+	return ""
+}
+
+func TestChunks(t *testing.T) {
+	f := &fakePF{aliases: []*Alias{{Name: blockListName, Type: "host"}}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	api := New(address, "id", "token").WithChunks(2, 1)
+	for _, ip := range []string{"10.9.9.1", "10.9.9.2", "10.9.9.3", "10.9.9.1", "10.9.9.4"} {
+		require.NoError(t, api.BanIP(t.Context(), ip, time.Hour))
+	}
+	assert.Equal(t, "10.9.9.1 10.9.9.2", f.address(blockListName))
+	assert.Equal(t, "10.9.9.3 10.9.9.4", f.address("block_list_1"))
+
+	err := api.BanIP(t.Context(), "10.9.9.5", time.Hour)
+	assert.ErrorIs(t, err, ErrFull)
+
+	bans, err := api.ListBans()
+	require.NoError(t, err)
+	assert.Len(t, bans, 4)
+
+	// a ban fits after an unban
+	require.NoError(t, api.UnbanIP(t.Context(), "10.9.9.3"))
+	require.NoError(t, api.BanIP(t.Context(), "10.9.9.5", time.Hour))
+	assert.Equal(t, "10.9.9.4 10.9.9.5", f.address("block_list_1"))
+}
@@ -0,0 +1,101 @@
+package pf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"pgregory.net/rapid"
+)
+
+// margin keeps generated expiries away from now, newUpdateRequest reads the
+// clock itself.
+const margin = 5
+
+type entry struct {
+	ip     string
+	expiry int64
+}
+
+func TestProperty_NewUpdateRequest(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		now := time.Now().Unix()
+		entries := rapid.SliceOf(rapid.Custom(func(t *rapid.T) entry {
+			ip := fmt.Sprintf("10.0.0.%d", rapid.IntRange(1, 8).Draw(t, "ip"))
+			offset := rapid.Int64Range(margin, 1e7).Draw(t, "offset")
+			if rapid.Bool().Draw(t, "expired") {
+				offset = -offset
+			}
+			return entry{ip: ip, expiry: now + offset}
+		})).Draw(t, "entries")
+
+		addresses, details := []string{}, []string{}
+		latest := map[string]int64{}
+		for _, e := range entries {
+			addresses = append(addresses, e.ip)
+			details = append(details, strconv.FormatInt(e.expiry, 10))
+			latest[e.ip] = max(latest[e.ip], e.expiry)
+		}
+
+		r := newUpdateRequest(&Alias{
+			Name:    blockListName,
+			Address: strings.Join(addresses, " "),
+			Detail:  strings.Join(details, "||"),
+		})
+
+		got := map[string]int64{}
+		for i, ip := range r.Address {
+			got[ip], _ = strconv.ParseInt(r.Detail[i], 10, 64)
+		}
+		for ip, exp := range latest {
+			if exp <= now {
+				if _, ok := got[ip]; ok {
+					t.Fatalf("expired %s survived", ip)
+				}
+				continue
+			}
+			if got[ip] != exp {
+				t.Fatalf("active %s: got expiry %d, want %d", ip, got[ip], exp)
+			}
+		}
+		if len(got) != len(r.Address) {
+			t.Fatalf("duplicated addresses %v", r.Address)
+		}
+
+		// re-ban never moves the expiry earlier
+		ip := fmt.Sprintf("10.0.0.%d", rapid.IntRange(1, 8).Draw(t, "reban"))
+		before := got[ip]
+		minutes := rapid.IntRange(1, 1e5).Draw(t, "minutes")
+		r.extend(ip, expiryAt(time.Now(), time.Duration(minutes)*time.Minute))
+		for i, a := range r.Address {
+			if a != ip {
+				continue
+			}
+			after, _ := strconv.ParseInt(r.Detail[i], 10, 64)
+			if after < before {
+				t.Fatalf("re-ban of %s moved expiry from %d to %d", ip, before, after)
+			}
+		}
+	})
+}
+
+func TestProperty_ExpiryAt(t *testing.T) {
+	zones := []string{"UTC", "America/New_York", "Europe/London", "Australia/Lord_Howe", "Asia/Kathmandu"}
+
+	rapid.Check(t, func(t *rapid.T) {
+		loc, err := time.LoadLocation(rapid.SampledFrom(zones).Draw(t, "zone"))
+		if err != nil {
+			t.Skip(err)
+		}
+		now := time.Unix(rapid.Int64Range(0, 4e9).Draw(t, "now"), 0)
+		minutes := rapid.IntRange(0, 1e6).Draw(t, "minutes")
+
+		// a ban across DST changes lasts exactly the given minutes
+		got := expiryAt(now.In(loc), time.Duration(minutes)*time.Minute)
+		if want := now.Unix() + int64(minutes)*60; got != want {
+			t.Fatalf("expiry in %s: got %d, want %d", loc, got, want)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package firewall
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+// Verdict of a policy on an error.
+type Verdict int
+
+const (
+	// VerdictCount counts the error against the forgivable threshold.
+	VerdictCount Verdict = iota
+	// VerdictIgnore drops the error.
+	VerdictIgnore
+	// VerdictBan bans the ip immediately.
+	VerdictBan
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case VerdictCount:
+		return "count"
+	case VerdictIgnore:
+		return "ignore"
+	case VerdictBan:
+		return "ban"
+	}
+	return "unknown"
+}
+
+// PolicyInput is an error of an ip not banned yet.
+type PolicyInput struct {
+	IP     string
+	Reason string
+	// Target is what the error is about, e.g. the account of a failed
+	// login, empty if unknown.
+	Target string
+	Time   time.Time
+	// Offenses is the number of errors counted before this one.
+	Offenses int
+	// Geo is set if the firewall has a geo database.
+	Geo *ipgeo.IPGeo
+}
+
+// Decision of a policy.
+type Decision struct {
+	Verdict Verdict
+	// Minutes of a VerdictBan, default ForgivableError.BanInMinute.
+	Minutes int
+	// Reason is added to the reasons of a VerdictBan, or of the error of a
+	// VerdictCount.
+	Reason string
+	// Weight counts the error of a VerdictCount as Weight errors, default 1.
+	Weight int
+	// BanAction of a VerdictBan, default BanActionBlock. Bans by the
+	// threshold are blocks.
+	BanAction BanAction
+}
+
+// IPolicy decides how an error is handled, e.g. a custom detection rule.
+// It is called in the loop goroutine, so it should be fast.
+type IPolicy interface {
+	Decide(in *PolicyInput) (Decision, error)
+}
+
+// decide asks the policy about c, errors of the policy count the error as
+// usual.
+func (s *Firewall) decide(c *countingError, ec *errorCounter) Decision {
+	in := &PolicyInput{
+		IP:       c.ip,
+		Reason:   c.reason,
+		Target:   c.target,
+		Time:     c.at,
+		Offenses: ec.offenses.Size(),
+	}
+	if s.ipGeo != nil {
+		in.Geo = s.ipGeo.GetIPGeo(c.ip)
+	}
+
+	d, err := s.policy.Decide(in)
+	if err != nil {
+		log.Printf("policy failed on %s: %v", c.ip, err)
+		return Decision{Verdict: VerdictCount}
+	}
+	return d
+}
+
+// PolicyChain asks the policies in order, the first decision other than
+// counting the error once wins.
+type PolicyChain []IPolicy
+
+func (c PolicyChain) Decide(in *PolicyInput) (Decision, error) {
+	var errs []error
+	for _, p := range c {
+		d, err := p.Decide(in)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if d.Verdict != VerdictCount || d.Weight > 1 || d.Reason != "" {
+			return d, nil
+		}
+	}
+	return Decision{Verdict: VerdictCount}, errors.Join(errs...)
+}
@@ -0,0 +1,101 @@
+package firewall
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MockPolicy decides by the reason of the error.
+type MockPolicy struct {
+	Decisions map[string]Decision
+	Inputs    []PolicyInput
+}
+
+func (m *MockPolicy) Decide(in *PolicyInput) (Decision, error) {
+	m.Inputs = append(m.Inputs, *in)
+	if in.Reason == "fail" {
+		return Decision{}, errors.New("fail")
+	}
+	return m.Decisions[in.Reason], nil
+}
+
+func TestPolicy(t *testing.T) {
+	policy := &MockPolicy{Decisions: map[string]Decision{
+		"ignore": {Verdict: VerdictIgnore},
+		"ban":    {Verdict: VerdictBan, Minutes: 30, Reason: "rule"},
+	}}
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}, WithPolicy(policy))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("1.2.3.4", "ignore")
+	fw.LogIPError("1.2.3.4", "fail")
+	fw.LogIPError("1.2.3.4", "count")
+	fw.LogIPError("1.2.3.4", "ban")
+	mockLogger.Wg.Wait()
+
+	actions := []string{}
+	for _, e := range mockLogger.Events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"count error", "count error", "ban"}, actions)
+
+	ban := mockLogger.Events[2]
+	assert.Equal(t, []string{"fail", "count", "ban", "rule"}, ban.Reasons)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), ban.JailUntil, time.Minute)
+	assert.Equal(t, []string{"1.2.3.4"}, mockFW.BannedIPs)
+
+	offenses := []int{}
+	for _, in := range policy.Inputs {
+		offenses = append(offenses, in.Offenses)
+	}
+	assert.Equal(t, []int{0, 0, 1, 2}, offenses)
+}
+
+func TestPolicy_Weight(t *testing.T) {
+	policy := &MockPolicy{Decisions: map[string]Decision{
+		"stuffing": {Verdict: VerdictCount, Weight: 3, Reason: "credential stuffing"},
+	}}
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 4, BanInMinute: 5}, WithPolicy(policy))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.LogIPTargetError("1.2.3.4", "alice", "stuffing")
+	fw.LogIPTargetError("1.2.3.4", "bob", "count")
+	fw.LogIPTargetError("1.2.3.4", "carol", "stuffing")
+	mockLogger.Wg.Wait()
+
+	actions := []string{}
+	for _, e := range mockLogger.Events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"count error", "count error", "ban"}, actions)
+	assert.Equal(t, []string{"stuffing", "credential stuffing"}, mockLogger.Events[0].Reasons)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, []string{policy.Inputs[0].Target, policy.Inputs[1].Target, policy.Inputs[2].Target})
+}
+
+func TestPolicyChain(t *testing.T) {
+	count := &MockPolicy{}
+	ban := &MockPolicy{Decisions: map[string]Decision{"r": {Verdict: VerdictBan}}}
+
+	d, err := PolicyChain{count, ban}.Decide(&PolicyInput{Reason: "r"})
+	assert.NoError(t, err)
+	assert.Equal(t, VerdictBan, d.Verdict)
+
+	d, err = PolicyChain{count, ban}.Decide(&PolicyInput{Reason: "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, Decision{Verdict: VerdictCount}, d)
+
+	d, err = PolicyChain{count, ban}.Decide(&PolicyInput{Reason: "fail"})
+	assert.Error(t, err)
+	assert.Equal(t, VerdictCount, d.Verdict)
+	assert.Len(t, ban.Inputs, 3)
+}
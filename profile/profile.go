@@ -0,0 +1,200 @@
+// Package profile moves the portable settings of a daemon, its whitelist,
+// detection policies and recipes, between deployments, e.g. from a homelab
+// to a VPS. A profile is signed with an ed25519 key like the peer feed, and
+// imported into the config of another deployment reporting the settings in
+// conflict.
+package profile
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/charleshuang3/firewall/config"
+)
+
+// Profile is the portable part of a config.Daemon. Listeners, backends and
+// secrets stay with the deployment.
+type Profile struct {
+	Issuer    string    `json:"issuer,omitempty"`
+	Generated time.Time `json:"generated"`
+
+	Whitelist          []string `json:"whitelist,omitempty"`
+	WhitelistAS        []string `json:"whitelist_as,omitempty"`
+	WhitelistCountries []string `json:"whitelist_countries,omitempty"`
+	Blacklist          []string `json:"blacklist,omitempty"`
+	BanCountries       []string `json:"ban_countries,omitempty"`
+
+	Forgivable         *config.Forgivable           `json:"forgivable,omitempty"`
+	ForgivableByReason map[string]config.Forgivable `json:"forgivable_by_reason,omitempty"`
+	Scoring            *config.Scoring              `json:"scoring,omitempty"`
+	Durations          *config.Durations            `json:"durations,omitempty"`
+	Stuffing           *config.Stuffing             `json:"stuffing,omitempty"`
+	ASN                *config.ASN                  `json:"asn,omitempty"`
+	AllowCountries     *config.AllowCountries       `json:"allow_countries,omitempty"`
+	Jails              []config.Jail                `json:"jails,omitempty"`
+}
+
+// FromConfig returns the profile of d.
+func FromConfig(issuer string, d *config.Daemon) *Profile {
+	p := &Profile{
+		Issuer:             issuer,
+		Generated:          time.Now().UTC(),
+		Whitelist:          slices.Clone(d.Whitelist),
+		WhitelistAS:        slices.Clone(d.WhitelistAS),
+		WhitelistCountries: slices.Clone(d.WhitelistCountries),
+		Blacklist:          slices.Clone(d.Blacklist),
+		BanCountries:       slices.Clone(d.BanCountries),
+		ForgivableByReason: maps.Clone(d.ForgivableByReason),
+		Scoring:            d.Scoring,
+		Durations:          d.Durations,
+		Stuffing:           d.Stuffing,
+		ASN:                d.ASN,
+		AllowCountries:     d.AllowCountries,
+		Jails:              slices.Clone(d.Jails),
+	}
+	if d.Forgivable != (config.Forgivable{}) {
+		f := d.Forgivable
+		p.Forgivable = &f
+	}
+	return p
+}
+
+// signedProfile is the file format, the signature is over the profile
+// bytes as is.
+type signedProfile struct {
+	Profile   json.RawMessage `json:"profile"`
+	Signature []byte          `json:"signature"`
+}
+
+// Sign encodes p signed with key.
+func Sign(p *Profile, key ed25519.PrivateKey) ([]byte, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&signedProfile{
+		Profile:   b,
+		Signature: ed25519.Sign(key, b),
+	})
+}
+
+// Verify decodes a signed profile, it fails if the signature is not made by
+// pub.
+func Verify(data []byte, pub ed25519.PublicKey) (*Profile, error) {
+	sp := &signedProfile{}
+	if err := json.Unmarshal(data, sp); err != nil {
+		return nil, fmt.Errorf("decode profile failed: %w", err)
+	}
+	if !ed25519.Verify(pub, sp.Profile, sp.Signature) {
+		return nil, errors.New("invalid profile signature")
+	}
+
+	p := &Profile{}
+	if err := json.Unmarshal(sp.Profile, p); err != nil {
+		return nil, fmt.Errorf("decode profile failed: %w", err)
+	}
+	return p, nil
+}
+
+// Conflict is a setting both in the config and the profile with different
+// values, in json.
+type Conflict struct {
+	Field   string
+	Local   string
+	Profile string
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: local %s, profile %s", c.Field, c.Local, c.Profile)
+}
+
+// Apply imports p into d. Lists are merged, settings missing in d are taken
+// from p. Settings of both with different values are returned as
+// conflicts, overwrite takes the value of p for them, d keeps its own
+// otherwise.
+func Apply(d *config.Daemon, p *Profile, overwrite bool) []Conflict {
+	m := &merger{overwrite: overwrite}
+
+	d.Whitelist = union(d.Whitelist, p.Whitelist)
+	d.WhitelistAS = union(d.WhitelistAS, p.WhitelistAS)
+	d.WhitelistCountries = union(d.WhitelistCountries, p.WhitelistCountries)
+	d.Blacklist = union(d.Blacklist, p.Blacklist)
+	d.BanCountries = union(d.BanCountries, p.BanCountries)
+
+	if p.Forgivable != nil {
+		merge(m, "forgivable", &d.Forgivable, *p.Forgivable)
+	}
+	for _, reason := range slices.Sorted(maps.Keys(p.ForgivableByReason)) {
+		if d.ForgivableByReason == nil {
+			d.ForgivableByReason = map[string]config.Forgivable{}
+		}
+		f := d.ForgivableByReason[reason]
+		merge(m, fmt.Sprintf("forgivable_by_reason[%q]", reason), &f, p.ForgivableByReason[reason])
+		d.ForgivableByReason[reason] = f
+	}
+	merge(m, "scoring", &d.Scoring, p.Scoring)
+	merge(m, "durations", &d.Durations, p.Durations)
+	merge(m, "stuffing", &d.Stuffing, p.Stuffing)
+	merge(m, "asn", &d.ASN, p.ASN)
+	merge(m, "allow_countries", &d.AllowCountries, p.AllowCountries)
+
+	for _, j := range p.Jails {
+		i := slices.IndexFunc(d.Jails, func(l config.Jail) bool { return l.Recipe == j.Recipe })
+		if i < 0 {
+			d.Jails = append(d.Jails, j)
+			continue
+		}
+		merge(m, fmt.Sprintf("jails[%q].forgivable", j.Recipe), &d.Jails[i].Forgivable, j.Forgivable)
+	}
+
+	return m.conflicts
+}
+
+type merger struct {
+	overwrite bool
+	conflicts []Conflict
+}
+
+// merge sets *local to imported if it is unset, an unset imported changes
+// nothing.
+func merge[T any](m *merger, field string, local *T, imported T) {
+	if reflect.ValueOf(&imported).Elem().IsZero() {
+		return
+	}
+	if reflect.ValueOf(local).Elem().IsZero() {
+		*local = imported
+		return
+	}
+	if reflect.DeepEqual(*local, imported) {
+		return
+	}
+
+	m.conflicts = append(m.conflicts, Conflict{Field: field, Local: toJSON(*local), Profile: toJSON(imported)})
+	if m.overwrite {
+		*local = imported
+	}
+}
+
+func toJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// union appends the entries of imported missing in local.
+func union(local, imported []string) []string {
+	for _, it := range imported {
+		if !slices.Contains(local, it) {
+			local = append(local, it)
+		}
+	}
+	return local
+}
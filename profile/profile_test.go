@@ -0,0 +1,80 @@
+package profile
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall/config"
+)
+
+func TestSignVerify(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	d := &config.Daemon{
+		Whitelist:  []string{"10.0.0.0/8"},
+		Forgivable: config.Forgivable{Duration: config.Duration(time.Minute), Count: 5, BanInMinute: 60},
+		Jails:      []config.Jail{{Recipe: "sshd"}},
+		Listen:     ":8080",
+	}
+	b, err := Sign(FromConfig("homelab", d), key)
+	require.NoError(t, err)
+
+	p, err := Verify(b, pub)
+	require.NoError(t, err)
+	assert.Equal(t, "homelab", p.Issuer)
+	assert.Equal(t, []string{"10.0.0.0/8"}, p.Whitelist)
+	assert.Equal(t, 5, p.Forgivable.Count)
+	assert.Equal(t, []config.Jail{{Recipe: "sshd"}}, p.Jails)
+
+	other, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, err = Verify(b, other)
+	assert.ErrorContains(t, err, "invalid profile signature")
+}
+
+func TestApply(t *testing.T) {
+	p := &Profile{
+		Whitelist:          []string{"10.0.0.0/8", "192.168.0.0/16"},
+		Forgivable:         &config.Forgivable{Count: 5, BanInMinute: 60},
+		ForgivableByReason: map[string]config.Forgivable{"sql": {BanInMinute: 600}, "ssh": {Count: 3}},
+		Scoring:            &config.Scoring{Threshold: 10},
+		Jails:              []config.Jail{{Recipe: "sshd", Forgivable: &config.Forgivable{Count: 2}}, {Recipe: "wordpress"}},
+	}
+	newDaemon := func() *config.Daemon {
+		return &config.Daemon{
+			Listen:             ":8080",
+			Whitelist:          []string{"192.168.0.0/16", "172.16.0.0/12"},
+			Forgivable:         config.Forgivable{Count: 3, BanInMinute: 60},
+			ForgivableByReason: map[string]config.Forgivable{"sql": {BanInMinute: 600}},
+			Jails:              []config.Jail{{Recipe: "sshd"}},
+		}
+	}
+
+	d := newDaemon()
+	conflicts := Apply(d, p, false)
+	assert.Equal(t, []Conflict{{
+		Field:   "forgivable",
+		Local:   `{"duration":"0s","count":3,"ban_in_minute":60}`,
+		Profile: `{"duration":"0s","count":5,"ban_in_minute":60}`,
+	}}, conflicts)
+	assert.Equal(t, []string{"192.168.0.0/16", "172.16.0.0/12", "10.0.0.0/8"}, d.Whitelist)
+	assert.Equal(t, 3, d.Forgivable.Count)
+	assert.Equal(t, map[string]config.Forgivable{"sql": {BanInMinute: 600}, "ssh": {Count: 3}}, d.ForgivableByReason)
+	assert.Equal(t, 10.0, d.Scoring.Threshold)
+	// a jail with the recipe defaults takes the tuned threshold
+	assert.Equal(t, []config.Jail{{Recipe: "sshd", Forgivable: &config.Forgivable{Count: 2}}, {Recipe: "wordpress"}}, d.Jails)
+	assert.Equal(t, ":8080", d.Listen)
+
+	d = newDaemon()
+	d.Jails[0].Forgivable = &config.Forgivable{Count: 4}
+	conflicts = Apply(d, p, true)
+	require.Len(t, conflicts, 2)
+	assert.Equal(t, `jails["sshd"].forgivable`, conflicts[1].Field)
+	assert.Equal(t, 5, d.Forgivable.Count)
+	assert.Equal(t, 2, d.Jails[0].Forgivable.Count)
+}
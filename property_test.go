@@ -0,0 +1,38 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"pgregory.net/rapid"
+)
+
+func TestProperty_JailNeverShortened(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		logger := &MockILogger{}
+		fw, err := New([]string{}, &MockIFirewall{}, logger, nil, testForgivable)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		minutes := rapid.SliceOfN(rapid.IntRange(1, 1e5), 1, 10).Draw(t, "minutes")
+		var deadline time.Time
+		for _, m := range minutes {
+			logger.Wg.Add(1)
+			start := time.Now()
+			fw.BanIP("10.0.0.1", m, "r")
+			logger.Wg.Wait()
+
+			var until time.Time
+			fw.do(func() {
+				until = fw.jail["10.0.0.1"].until
+			})
+			if d := start.Add(time.Duration(m) * time.Minute); d.After(deadline) {
+				deadline = d
+			}
+			if until.Before(deadline) {
+				t.Fatalf("ban for %d minutes shortened the jail to %s, want %s", m, until, deadline)
+			}
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package firewall
+
+// OverflowPolicy is what BanIP and LogIPError do when their queue to the
+// loop is full, see WithQueue.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until the loop takes the event, so a slow
+	// backend slows down the callers.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued event to make room, so
+	// callers never wait. Drops are counted by Dropped.
+	OverflowDropOldest
+)
+
+// enqueue sends v to ch by the overflow policy.
+func enqueue[T any](s *Firewall, ch chan T, v T) {
+	if s.overflow != OverflowDropOldest || cap(ch) == 0 {
+		ch <- v
+		return
+	}
+	for {
+		select {
+		case ch <- v:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+			s.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// Dropped returns the number of bans and errors dropped as their queue was
+// full, the one to the loop or the one to the ban workers, since New.
+func (s *Firewall) Dropped() uint64 {
+	return s.dropped.Load()
+}
@@ -0,0 +1,85 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingFirewall blocks bans until release is closed.
+type blockingFirewall struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingFirewall) BanIP(ip string, timeoutInMinute int) {
+	close(b.entered)
+	<-b.release
+}
+
+func TestWithQueue_DropOldest(t *testing.T) {
+	backend := &blockingFirewall{entered: make(chan struct{}), release: make(chan struct{})}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{}, backend, mockLogger, nil, forgivable, WithQueue(2, OverflowDropOldest))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.BanIP("1.1.1.1", 10, "r")
+	<-backend.entered
+
+	// the loop is stuck in the backend, callers do not wait
+	done := make(chan struct{})
+	go func() {
+		for _, ip := range []string{"1.2.3.1", "1.2.3.2", "1.2.3.3", "1.2.3.4", "1.2.3.5"} {
+			fw.LogIPError(ip, "r")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("LogIPError blocked")
+	}
+	assert.Equal(t, uint64(3), fw.Dropped())
+
+	close(backend.release)
+	mockLogger.Wg.Wait()
+
+	ips := []string{}
+	for _, e := range mockLogger.Events {
+		ips = append(ips, e.IP)
+	}
+	assert.Equal(t, []string{"1.1.1.1", "1.2.3.4", "1.2.3.5"}, ips)
+}
+
+func TestWithQueue_Block(t *testing.T) {
+	backend := &blockingFirewall{entered: make(chan struct{}), release: make(chan struct{})}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{}, backend, mockLogger, nil, forgivable, WithQueue(1, OverflowBlock))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.BanIP("1.1.1.1", 10, "r")
+	<-backend.entered
+
+	fw.LogIPError("1.2.3.1", "r")
+	done := make(chan struct{})
+	go func() {
+		fw.LogIPError("1.2.3.2", "r")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("LogIPError did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(backend.release)
+	<-done
+	mockLogger.Wg.Wait()
+	assert.Zero(t, fw.Dropped())
+}
@@ -0,0 +1,130 @@
+// Package recipe has pre-tuned jails of common services, e.g. "sshd": the
+// patterns of their log lines to count as errors and the threshold and ban
+// duration of these errors. Users get sensible protection by naming a
+// recipe instead of writing regexes and tuning a firewall.ForgivableError.
+//
+// The reasons of a recipe are prefixed by its name, e.g. "sshd: invalid
+// user", so its threshold applies through firewall.WithReasonForgivable
+// with the category Name.
+package recipe
+
+import (
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+)
+
+// Rule matches a log line, the "ip" group of Pattern is the offending ip
+// and the optional "user" group is the target of the error.
+type Rule struct {
+	Reason  string
+	Pattern *regexp.Regexp
+}
+
+// Recipe is a pre-tuned jail of a service.
+type Recipe struct {
+	Name        string
+	Description string
+	Rules       []Rule
+	// Forgivable is the threshold and ban duration of the errors matched.
+	Forgivable firewall.ForgivableError
+}
+
+// Match is an error found in a log line.
+type Match struct {
+	IP     string
+	Target string
+	Reason string
+}
+
+// Match returns the error of line by the first rule matching, false if
+// none matches or the ip is invalid.
+func (r *Recipe) Match(line string) (Match, bool) {
+	for _, rule := range r.Rules {
+		m := rule.Pattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		res := Match{Reason: rule.Reason}
+		for i, name := range rule.Pattern.SubexpNames() {
+			switch name {
+			case "ip":
+				res.IP = m[i]
+			case "user":
+				res.Target = m[i]
+			}
+		}
+		ip, ok := firewall.NormalizeIP(res.IP)
+		if !ok {
+			return Match{}, false
+		}
+		res.IP = ip
+		return res, true
+	}
+	return Match{}, false
+}
+
+// newRecipe prefixes the reasons of rules by name and compiles their
+// patterns, it panics on an invalid pattern.
+func newRecipe(name, description string, f firewall.ForgivableError, rules ...[2]string) *Recipe {
+	r := &Recipe{Name: name, Description: description, Forgivable: f}
+	for _, rule := range rules {
+		r.Rules = append(r.Rules, Rule{
+			Reason:  name + ": " + rule[0],
+			Pattern: regexp.MustCompile(rule[1]),
+		})
+	}
+	return r
+}
+
+var recipes = map[string]*Recipe{}
+
+func register(r *Recipe) {
+	recipes[r.Name] = r
+}
+
+// Get returns the recipe name.
+func Get(name string) (*Recipe, bool) {
+	r, ok := recipes[name]
+	return r, ok
+}
+
+// Names returns the names of all recipes, sorted.
+func Names() []string {
+	res := []string{}
+	for name := range recipes {
+		res = append(res, name)
+	}
+	slices.Sort(res)
+	return res
+}
+
+func init() {
+	register(newRecipe("sshd", "OpenSSH server, from its syslog or journal lines",
+		firewall.ForgivableError{Duration: 10 * time.Minute, Count: 5, BanInMinute: 60},
+		[2]string{"failed password", `Failed (?:password|publickey|keyboard-interactive/pam) for (?:invalid user )?(?P<user>\S*) from (?P<ip>\S+) port`},
+		[2]string{"invalid user", `Invalid user (?P<user>\S*) from (?P<ip>\S+)`},
+		[2]string{"preauth disconnect", `(?:Connection closed|Disconnected) by (?:authenticating|invalid) user (?P<user>\S*) (?P<ip>\S+) port \d+ \[preauth\]`},
+		[2]string{"no identification", `Did not receive identification string from (?P<ip>\S+)`},
+		[2]string{"negotiation failed", `Unable to negotiate with (?P<ip>\S+) port \d+: no matching`},
+	))
+
+	register(newRecipe("nextcloud", "Nextcloud, from nextcloud.log",
+		firewall.ForgivableError{Duration: 15 * time.Minute, Count: 5, BanInMinute: 60},
+		[2]string{"login failed", `"remoteAddr":"(?P<ip>[^"]+)".*"message":"Login failed: '?(?P<user>[^' ]*)`},
+		[2]string{"login failed", `Login failed: '?(?P<user>[^' ]*)'? \(Remote IP: '?(?P<ip>[0-9A-Fa-f.:]+)`},
+		[2]string{"untrusted domain", `"remoteAddr":"(?P<ip>[^"]+)".*"message":"Trusted domain error`},
+	))
+
+	// a login posts to wp-login.php too, the threshold allows a few typos
+	// but not a brute force.
+	register(newRecipe("wordpress", "WordPress, from the access log of the web server in common or combined format",
+		firewall.ForgivableError{Duration: 10 * time.Minute, Count: 10, BanInMinute: 60},
+		[2]string{"login attempt", `^(?P<ip>\S+) \S+ \S+ \[[^\]]*\] "POST /(?:\S*/)?wp-login\.php`},
+		[2]string{"xmlrpc", `^(?P<ip>\S+) \S+ \S+ \[[^\]]*\] "POST /(?:\S*/)?xmlrpc\.php`},
+		[2]string{"probe", `^(?P<ip>\S+) \S+ \S+ \[[^\]]*\] "[A-Z]+ /(?:\S*/)?(?:wp-config\.php|\.env|\.git/)`},
+	))
+}
@@ -0,0 +1,50 @@
+package recipe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		recipe string
+		line   string
+		want   Match
+	}{
+		{"sshd", "sshd[812]: Failed password for root from 203.0.113.5 port 52144 ssh2", Match{IP: "203.0.113.5", Target: "root", Reason: "sshd: failed password"}},
+		{"sshd", "sshd[812]: Failed password for invalid user admin from 2001:db8::5 port 52144 ssh2", Match{IP: "2001:db8::5", Target: "admin", Reason: "sshd: failed password"}},
+		{"sshd", "sshd[812]: Invalid user oracle from 203.0.113.6 port 40022", Match{IP: "203.0.113.6", Target: "oracle", Reason: "sshd: invalid user"}},
+		{"sshd", "sshd[812]: Connection closed by authenticating user git 203.0.113.7 port 3321 [preauth]", Match{IP: "203.0.113.7", Target: "git", Reason: "sshd: preauth disconnect"}},
+		{"sshd", "sshd[812]: Unable to negotiate with 203.0.113.8 port 3321: no matching key exchange method found.", Match{IP: "203.0.113.8", Reason: "sshd: negotiation failed"}},
+		{"nextcloud", `{"reqId":"x","level":2,"remoteAddr":"203.0.113.9","user":"--","app":"core","message":"Login failed: 'alice' (Remote IP: '203.0.113.9')"}`, Match{IP: "203.0.113.9", Target: "alice", Reason: "nextcloud: login failed"}},
+		{"nextcloud", `Login failed: bob (Remote IP: 203.0.113.10)`, Match{IP: "203.0.113.10", Target: "bob", Reason: "nextcloud: login failed"}},
+		{"wordpress", `203.0.113.11 - - [02/Jan/2026:03:04:05 +0000] "POST /wp-login.php HTTP/1.1" 200 4521 "-" "curl/8.0"`, Match{IP: "203.0.113.11", Reason: "wordpress: login attempt"}},
+		{"wordpress", `203.0.113.12 - - [02/Jan/2026:03:04:05 +0000] "POST /blog/xmlrpc.php HTTP/1.1" 200 0`, Match{IP: "203.0.113.12", Reason: "wordpress: xmlrpc"}},
+		{"wordpress", `203.0.113.13 - - [02/Jan/2026:03:04:05 +0000] "GET /.env HTTP/1.1" 404 0`, Match{IP: "203.0.113.13", Reason: "wordpress: probe"}},
+	}
+	for _, tt := range tests {
+		r, ok := Get(tt.recipe)
+		require.True(t, ok, tt.recipe)
+		got, ok := r.Match(tt.line)
+		assert.True(t, ok, tt.line)
+		assert.Equal(t, tt.want, got, tt.line)
+	}
+
+	for recipe, line := range map[string]string{
+		"sshd":      "sshd[812]: Accepted publickey for root from 203.0.113.5 port 52144 ssh2",
+		"nextcloud": `{"remoteAddr":"unknown","message":"Login failed: 'alice'"}`,
+		"wordpress": `203.0.113.11 - - [02/Jan/2026:03:04:05 +0000] "GET /wp-login.php HTTP/1.1" 200 4521`,
+	} {
+		r, _ := Get(recipe)
+		_, ok := r.Match(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, []string{"nextcloud", "sshd", "wordpress"}, Names())
+	_, ok := Get("nope")
+	assert.False(t, ok)
+}
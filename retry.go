@@ -0,0 +1,135 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy is how RetryingFirewall retries a failed call: up to
+// MaxAttempts calls in total, waiting InitialBackoff after the first failure
+// and twice as long after each next one, at most MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy tries 3 times, waiting 1s and 2s in between.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+}
+
+// backoff returns the wait after the attempt-th failure.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for range attempt - 1 {
+		d *= 2
+		if d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return min(d, p.MaxBackoff)
+}
+
+// RetryError is returned by RetryingFirewall when it gave up, Err is the
+// error of the last attempt. Its BackendError has the number of attempts,
+// and the firewall logs "ban-failed" after it.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	_ IFirewallV2       = (*RetryingFirewall)(nil)
+	_ IUnbanFirewallV2  = (*RetryingFirewall)(nil)
+	_ IExpiringFirewall = (*RetryingFirewall)(nil)
+	_ INetworkFirewall  = (*RetryingFirewall)(nil)
+)
+
+// RetryingFirewall retries the failed calls of a backend with exponential
+// backoff, e.g. while a router reboots. Retries stop when the context is
+// done, so the timeout of V1 bounds all attempts of a call.
+type RetryingFirewall struct {
+	fw     IFirewallV2
+	policy RetryPolicy
+}
+
+// Retry wraps fw to retry by policy, zero fields of policy are taken from
+// DefaultRetryPolicy.
+func Retry(fw IFirewallV2, policy RetryPolicy) *RetryingFirewall {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = max(DefaultRetryPolicy.MaxBackoff, policy.InitialBackoff)
+	}
+	return &RetryingFirewall{fw: fw, policy: policy}
+}
+
+func (r *RetryingFirewall) Name() string {
+	return r.fw.Name()
+}
+
+// ExpiresBans returns whether the wrapped backend expires bans.
+func (r *RetryingFirewall) ExpiresBans() bool {
+	e, ok := r.fw.(IExpiringFirewall)
+	return ok && e.ExpiresBans()
+}
+
+// BansNetworks returns whether the wrapped backend accepts cidr bans.
+func (r *RetryingFirewall) BansNetworks() bool {
+	n, ok := r.fw.(INetworkFirewall)
+	return ok && n.BansNetworks()
+}
+
+func (r *RetryingFirewall) BanIP(ctx context.Context, ip string, dur time.Duration) error {
+	return r.retry(ctx, func() error {
+		return r.fw.BanIP(ctx, ip, dur)
+	})
+}
+
+// UnbanIP unbans ip if the wrapped backend implements IUnbanFirewallV2,
+// otherwise it does nothing.
+func (r *RetryingFirewall) UnbanIP(ctx context.Context, ip string) error {
+	f, ok := r.fw.(IUnbanFirewallV2)
+	if !ok {
+		return nil
+	}
+	return r.retry(ctx, func() error {
+		return f.UnbanIP(ctx, ip)
+	})
+}
+
+func (r *RetryingFirewall) retry(ctx context.Context, call func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = call(); err == nil {
+			return nil
+		}
+		if attempt >= r.policy.MaxAttempts || errors.Is(err, context.Canceled) {
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+
+		t := time.NewTimer(r.policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return &RetryError{Attempts: attempt, Err: err}
+		case <-t.C:
+		}
+	}
+}
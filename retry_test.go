@@ -0,0 +1,86 @@
+package firewall
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyV2Firewall fails its first failures calls.
+type flakyV2Firewall struct {
+	failures int
+	calls    int
+}
+
+func (f *flakyV2Firewall) Name() string {
+	return "flaky"
+}
+
+func (f *flakyV2Firewall) BanIP(ctx context.Context, ip string, dur time.Duration) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return &StatusError{Code: 503, Body: "rebooting"}
+	}
+	return nil
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	got := []time.Duration{}
+	for attempt := 1; attempt <= 5; attempt++ {
+		got = append(got, p.backoff(attempt))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, got)
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	flaky := &flakyV2Firewall{failures: 2}
+	require.NoError(t, Retry(flaky, policy).BanIP(t.Context(), "1.2.3.4", time.Minute))
+	assert.Equal(t, 3, flaky.calls)
+
+	flaky = &flakyV2Firewall{failures: 5}
+	err := Retry(flaky, policy).BanIP(t.Context(), "1.2.3.4", time.Minute)
+	re := &RetryError{}
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, 3, re.Attempts)
+	assert.Equal(t, 3, flaky.calls)
+	assert.EqualError(t, err, `code = 503, resp = "rebooting"`)
+
+	// the context bounds the retries
+	flaky = &flakyV2Firewall{failures: 5}
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	err = Retry(flaky, RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Hour}).BanIP(ctx, "1.2.3.4", time.Minute)
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, 1, re.Attempts)
+
+	flaky = &flakyV2Firewall{failures: 5}
+	assert.NoError(t, Retry(flaky, policy).UnbanIP(t.Context(), "1.2.3.4"))
+	assert.Equal(t, 0, flaky.calls)
+}
+
+func TestRetry_BanFailed(t *testing.T) {
+	flaky := &flakyV2Firewall{failures: 5}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, V1(Retry(flaky, RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}), 0), mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.BanIP("1.2.3.4", 10, "scanner")
+	mockLogger.Wg.Wait()
+
+	actions := []string{}
+	for _, e := range mockLogger.Events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"backend-error", "ban-failed", "ban"}, actions)
+	be := mockLogger.Events[1].Backend
+	assert.Equal(t, 2, be.Attempt)
+	assert.Equal(t, "http", be.Class())
+	assert.Equal(t, []string{`flaky ban failed (attempt 2, http): code = 503, resp = "rebooting"`}, mockLogger.Events[1].Reasons)
+}
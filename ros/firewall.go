@@ -1,44 +1,212 @@
 package ros
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-routeros/routeros/v3"
 
 	"github.com/charleshuang3/firewall"
 )
 
-var _ firewall.IFirewall = (*API)(nil)
+var (
+	_ firewall.IFirewallV2       = (*API)(nil)
+	_ firewall.IUnbanFirewallV2  = (*API)(nil)
+	_ firewall.IExpiringFirewall = (*API)(nil)
+	_ firewall.INetworkFirewall  = (*API)(nil)
+)
+
+const (
+	blockListName = "black-list"
+
+	ipv4AddressList = "/ip/firewall/address-list"
+	ipv6AddressList = "/ipv6/firewall/address-list"
+)
+
+// addressList returns the address list menu of ip, or of a cidr, and the
+// address as routeros prints it, ipv6 addresses are kept with their prefix
+// length.
+func addressList(ip string) (string, string) {
+	if _, n, err := net.ParseCIDR(ip); err == nil {
+		if n.IP.To4() == nil {
+			return ipv6AddressList, ip
+		}
+		return ipv4AddressList, ip
+	}
+	if p := net.ParseIP(ip); p != nil && p.To4() == nil {
+		return ipv6AddressList, ip + "/128"
+	}
+	return ipv4AddressList, ip
+}
 
 type API struct {
 	address string
-	user    string
-	pass    string
+	creds   firewall.ICredentialProvider
 }
 
 func New(address, user, pass string) *API {
 	return &API{
 		address: address,
-		user:    user,
-		pass:    pass,
+		creds:   firewall.StaticCredentials{User: user, Pass: pass},
+	}
+}
+
+// WithCredentials replaces the user and pass of New by p, asked on every
+// connection, e.g. to pick up rotated passwords.
+func (s *API) WithCredentials(p firewall.ICredentialProvider) *API {
+	s.creds = p
+	return s
+}
+
+func (s *API) client(ctx context.Context) (*routeros.Client, error) {
+	c, err := s.creds.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get credentials failed: %w", err)
 	}
+	return routeros.DialContext(ctx, s.address, c.User, c.Pass)
+}
+
+func (s *API) Name() string {
+	return "ros"
+}
+
+// BanIP adds ip to the address list, routeros removes it after dur.
+func (s *API) BanIP(ctx context.Context, ip string, dur time.Duration) error {
+	c, err := s.client(ctx)
+	if err != nil {
+		return fmt.Errorf("routeros.Dial failed: %w", err)
+	}
+	defer c.Close()
+
+	menu, _ := addressList(ip)
+	if _, err := c.RunContext(ctx, menu+"/add", "=list="+blockListName, "=address="+ip, fmt.Sprintf("=timeout=%ds", int64(dur/time.Second))); err != nil {
+		return fmt.Errorf("add %s to address list failed: %w", ip, err)
+	}
+	return nil
+}
+
+// ExpiresBans returns true, routeros removes the address after the timeout.
+func (s *API) ExpiresBans() bool {
+	return true
+}
+
+// BansNetworks returns true, address lists accept cidrs.
+func (s *API) BansNetworks() bool {
+	return true
 }
 
-func (s *API) client() (*routeros.Client, error) {
-	return routeros.Dial(s.address, s.user, s.pass)
+// UnbanIP removes ip from the address list.
+func (s *API) UnbanIP(ctx context.Context, ip string) error {
+	c, err := s.client(ctx)
+	if err != nil {
+		return fmt.Errorf("routeros.Dial failed: %w", err)
+	}
+	defer c.Close()
+
+	menu, address := addressList(ip)
+	reply, err := c.RunContext(ctx, menu+"/print", "?list="+blockListName, "?address="+address, "=.proplist=.id")
+	if err != nil {
+		return fmt.Errorf("print address list failed: %w", err)
+	}
+
+	for _, re := range reply.Re {
+		if _, err := c.RunContext(ctx, menu+"/remove", "=.id="+re.Map[".id"]); err != nil {
+			return fmt.Errorf("remove %s from address list failed: %w", ip, err)
+		}
+	}
+
+	return nil
 }
 
-func (s *API) BanIP(ip string, timeoutInMinute int) {
-	c, err := s.client()
+// ListBans returns the ips in the ipv4 and ipv6 address lists.
+func (s *API) ListBans() ([]firewall.BackendBan, error) {
+	c, err := s.client(context.Background())
 	if err != nil {
-		log.Printf("routeros.Dial failed: %v", err)
-		return
+		return nil, fmt.Errorf("routeros.Dial failed: %w", err)
 	}
 	defer c.Close()
 
-	reply, err := c.Run("/ip/firewall/address-list/add", "=list=black-list", "=address="+ip, fmt.Sprintf("=timeout=%dm", timeoutInMinute))
+	now := time.Now()
+	res := []firewall.BackendBan{}
+	for _, menu := range []string{ipv4AddressList, ipv6AddressList} {
+		reply, err := c.Run(menu+"/print", "?list="+blockListName, "=.proplist=address,timeout")
+		if err != nil {
+			return nil, fmt.Errorf("print address list failed: %w", err)
+		}
+
+		for _, re := range reply.Re {
+			b := firewall.BackendBan{IP: strings.TrimSuffix(re.Map["address"], "/128")}
+			if t := re.Map["timeout"]; t != "" {
+				d, err := parseDuration(t)
+				if err != nil {
+					return nil, err
+				}
+				b.ExpireAt = now.Add(d)
+			}
+			res = append(res, b)
+		}
+	}
+
+	return res, nil
+}
+
+// EnsureAlias is a no-op, routeros creates the address list on first add.
+func (s *API) EnsureAlias() error {
+	return nil
+}
+
+// Ping checks the API is reachable and the credentials are accepted.
+func (s *API) Ping() error {
+	c, err := s.client(context.Background())
 	if err != nil {
-		log.Println(reply)
+		return fmt.Errorf("routeros.Dial failed: %w", err)
 	}
+	defer c.Close()
+
+	if _, err := c.Run("/system/identity/print"); err != nil {
+		return fmt.Errorf("print identity failed: %w", err)
+	}
+	return nil
+}
+
+// parseDuration parses routeros duration like "1w2d3h4m5s".
+func parseDuration(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'w': 7 * 24 * time.Hour,
+		'd': 24 * time.Hour,
+		'h': time.Hour,
+		'm': time.Minute,
+		's': time.Second,
+	}
+
+	var d time.Duration
+	num := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= '0' && c <= '9' {
+			num = num*10 + int(c-'0')
+			continue
+		}
+		u, ok := units[c]
+		if !ok {
+			return 0, fmt.Errorf("parse routeros duration %q failed", s)
+		}
+		d += time.Duration(num) * u
+		num = 0
+	}
+
+	if num != 0 {
+		// plain seconds, or the "hh:mm:ss" form is not supported.
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("parse routeros duration %q failed", s)
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+
+	return d, nil
 }
@@ -0,0 +1,60 @@
+package ros
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"pgregory.net/rapid"
+)
+
+// format writes d like routeros does, e.g. "1w2d3h4m5s".
+func format(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	s := ""
+	for _, u := range []struct {
+		c byte
+		d time.Duration
+	}{{'w', 7 * 24 * time.Hour}, {'d', 24 * time.Hour}, {'h', time.Hour}, {'m', time.Minute}, {'s', time.Second}} {
+		if n := d / u.d; n > 0 {
+			s += fmt.Sprintf("%d%c", n, u.c)
+			d -= n * u.d
+		}
+	}
+	return s
+}
+
+func TestProperty_ParseDuration(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		d := time.Duration(rapid.Int64Range(0, 10*365*24*3600).Draw(t, "seconds")) * time.Second
+
+		got, err := parseDuration(format(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != d {
+			t.Fatalf("parseDuration(%q) = %s, want %s", format(d), got, d)
+		}
+	})
+}
+
+func TestAddressList(t *testing.T) {
+	menu, address := addressList("1.2.3.4")
+	if menu != ipv4AddressList || address != "1.2.3.4" {
+		t.Errorf("addressList(1.2.3.4) = %s, %s", menu, address)
+	}
+	menu, address = addressList("2001:db8::1")
+	if menu != ipv6AddressList || address != "2001:db8::1/128" {
+		t.Errorf("addressList(2001:db8::1) = %s, %s", menu, address)
+	}
+	menu, address = addressList("1.2.3.0/24")
+	if menu != ipv4AddressList || address != "1.2.3.0/24" {
+		t.Errorf("addressList(1.2.3.0/24) = %s, %s", menu, address)
+	}
+	menu, address = addressList("2001:db8::/48")
+	if menu != ipv6AddressList || address != "2001:db8::/48" {
+		t.Errorf("addressList(2001:db8::/48) = %s, %s", menu, address)
+	}
+}
@@ -0,0 +1,125 @@
+package firewall
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strings"
+)
+
+var (
+	_ IActionFirewall   = (*Router)(nil)
+	_ IRoutingFirewall  = (*Router)(nil)
+	_ IUnbanFirewall    = (*Router)(nil)
+	_ IExpiringFirewall = (*Router)(nil)
+	_ INetworkFirewall  = (*Router)(nil)
+)
+
+// IRoutingFirewall is a backend choosing where to enforce a ban by its
+// reasons, see Router. The firewall passes the reasons of every ban.
+type IRoutingFirewall interface {
+	IErrorFirewall
+	RouteBanIP(ip string, timeoutInMinute int, reasons []string) error
+}
+
+// Route sends bans with a reason starting with Prefix to Backend.
+type Route struct {
+	Prefix  string
+	Backend IFirewall
+}
+
+// Router routes bans to backends by their reasons, e.g. ssh offenders to the
+// edge router and web scanners to a CDN. A ban is applied on the backends of
+// all matching routes, or on the fallback backend if no route matches. The
+// backends are called concurrently like MultiFirewall.
+type Router struct {
+	routes   []Route
+	fallback IFirewall
+	// all are the distinct backends, unbans are sent to all as the route of
+	// a ban is not known after a restart.
+	all *MultiFirewall
+}
+
+// NewRouter returns a Router of routes, bans matching no route go to
+// fallback. fallback can be nil to drop them with an error.
+func NewRouter(fallback IFirewall, routes ...Route) *Router {
+	all := []IFirewall{}
+	if fallback != nil {
+		all = append(all, fallback)
+	}
+	for _, r := range routes {
+		if !slices.Contains(all, r.Backend) {
+			all = append(all, r.Backend)
+		}
+	}
+	return &Router{
+		routes:   routes,
+		fallback: fallback,
+		all:      NewMultiFirewall(all...),
+	}
+}
+
+// Name joins the names of the backends.
+func (r *Router) Name() string {
+	return r.all.Name()
+}
+
+// ExpiresBans returns true if all backends expire bans by themselves.
+func (r *Router) ExpiresBans() bool {
+	return r.all.ExpiresBans()
+}
+
+// BansNetworks returns true if all backends accept cidr bans.
+func (r *Router) BansNetworks() bool {
+	return r.all.BansNetworks()
+}
+
+func (r *Router) BanIP(ip string, timeoutInMinute int) {
+	if err := r.TryBanIP(ip, timeoutInMinute); err != nil {
+		log.Println(err)
+	}
+}
+
+// TryBanIP bans ip without reasons, on the fallback backend.
+func (r *Router) TryBanIP(ip string, timeoutInMinute int) error {
+	return r.RouteBanIP(ip, timeoutInMinute, nil)
+}
+
+// RouteBanIP blocks ip on the backends of the routes matching reasons.
+func (r *Router) RouteBanIP(ip string, timeoutInMinute int, reasons []string) error {
+	return r.ActionBanIP(ip, timeoutInMinute, BanActionBlock, reasons)
+}
+
+// ActionBanIP bans ip on the backends of the routes matching reasons, the
+// action is passed to backends enforcing actions, e.g. an ActionFirewall.
+// The returned error joins a BackendError for each failed backend.
+func (r *Router) ActionBanIP(ip string, timeoutInMinute int, action BanAction, reasons []string) error {
+	backends := r.route(reasons)
+	if len(backends) == 0 {
+		return errors.New("no route for reasons: " + strings.Join(reasons, ", "))
+	}
+	return NewMultiFirewall(backends...).each("ban", func(fw IFirewall) error {
+		return ForwardBan(fw, ip, timeoutInMinute, action, reasons)
+	})
+}
+
+// UnbanIP unbans ip on all backends implementing IUnbanFirewall.
+func (r *Router) UnbanIP(ip string) error {
+	return r.all.UnbanIP(ip)
+}
+
+func (r *Router) route(reasons []string) []IFirewall {
+	res := []IFirewall{}
+	for _, rt := range r.routes {
+		if slices.Contains(res, rt.Backend) {
+			continue
+		}
+		if slices.ContainsFunc(reasons, func(reason string) bool { return strings.HasPrefix(reason, rt.Prefix) }) {
+			res = append(res, rt.Backend)
+		}
+	}
+	if len(res) == 0 && r.fallback != nil {
+		res = append(res, r.fallback)
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	edge := &MockUnbanFirewall{}
+	cdn := &MockUnbanFirewall{}
+	local := &MockUnbanFirewall{}
+	r := NewRouter(local, Route{Prefix: "ssh", Backend: edge}, Route{Prefix: "scanner", Backend: cdn}, Route{Prefix: "ssh root", Backend: cdn})
+
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, r, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+	mockLogger.Wg.Add(3)
+	fw.BanIP("1.2.3.4", 10, "ssh bad password")
+	fw.BanIP("1.2.3.5", 10, "scanner")
+	fw.BanIP("1.2.3.6", 10, "smtp relay")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.4"}, edge.BannedIPs)
+	assert.Equal(t, []string{"1.2.3.5"}, cdn.BannedIPs)
+	assert.Equal(t, []string{"1.2.3.6"}, local.BannedIPs)
+
+	// all matching routes apply
+	require.NoError(t, r.RouteBanIP("1.2.3.7", 10, []string{"ssh root login"}))
+	assert.Equal(t, []string{"1.2.3.4", "1.2.3.7"}, edge.BannedIPs)
+	assert.Equal(t, []string{"1.2.3.5", "1.2.3.7"}, cdn.BannedIPs)
+
+	// unbans go to every backend
+	require.NoError(t, r.UnbanIP("1.2.3.4"))
+	for _, b := range []*MockUnbanFirewall{edge, cdn, local} {
+		assert.Equal(t, []string{"1.2.3.4"}, b.UnbannedIPs)
+	}
+	assert.Equal(t, "*firewall.MockUnbanFirewall,*firewall.MockUnbanFirewall,*firewall.MockUnbanFirewall", r.Name())
+}
+
+func TestRouter_NoFallback(t *testing.T) {
+	edge := &MockUnbanFirewall{}
+	r := NewRouter(nil, Route{Prefix: "ssh", Backend: edge})
+
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, r, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.2.3.4", 10, "scanner")
+	mockLogger.Wg.Wait()
+
+	assert.Empty(t, edge.BannedIPs)
+	assert.Equal(t, "backend-error", mockLogger.Events[0].Action)
+	assert.Equal(t, []string{"*firewall.MockUnbanFirewall ban failed (attempt 1, other): no route for reasons: scanner"}, mockLogger.Events[0].Reasons)
+	assert.Equal(t, "ban", mockLogger.Events[1].Action)
+}
@@ -0,0 +1,104 @@
+package firewall
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultScoreHalfLife = 10 * time.Minute
+	// minScore is the score below which a counter is forgotten.
+	minScore = 0.01
+	// maxScoredOffenses caps the offenses kept per ip in scoring mode.
+	maxScoredOffenses = 256
+)
+
+// Scoring replaces counting errors against the forgivable thresholds: each
+// error adds the weight of its reason to the score of the ip, the score
+// halves every HalfLife, and the ip is banned when it reaches Threshold. A
+// severe reason, e.g. an exploit attempt, can ban on its own while minor
+// ones, e.g. 404s, accumulate slowly. The ban has the BanInMinute of the
+// forgivable threshold of the reason.
+type Scoring struct {
+	// Weights of reasons by prefix, the longest prefix wins.
+	Weights map[string]float64
+	// DefaultWeight is the weight of other reasons, default 1.
+	DefaultWeight float64
+	// HalfLife defaults to 10 minutes.
+	HalfLife  time.Duration
+	Threshold float64
+}
+
+// validate rejects a Threshold which is not positive, which bans on the
+// first error, and negative weights. A weight of 0 ignores the reasons of
+// its prefix, except for the "" prefix of all reasons.
+func (sc *Scoring) validate() error {
+	if sc.Threshold <= 0 {
+		return fmt.Errorf("scoring threshold %v is not positive", sc.Threshold)
+	}
+	for _, prefix := range slices.Sorted(maps.Keys(sc.Weights)) {
+		w := sc.Weights[prefix]
+		if w < 0 || (prefix == "" && w == 0) {
+			return fmt.Errorf("scoring weight %v of %q is not positive", w, prefix)
+		}
+	}
+	return nil
+}
+
+func (sc *Scoring) weightOf(reason string) float64 {
+	prefix := ""
+	w := sc.DefaultWeight
+	if w <= 0 {
+		w = 1
+	}
+	for p, pw := range sc.Weights {
+		if strings.HasPrefix(reason, p) && len(p) >= len(prefix) {
+			prefix = p
+			w = pw
+		}
+	}
+	return w
+}
+
+func (sc *Scoring) halfLife() time.Duration {
+	if sc.HalfLife <= 0 {
+		return defaultScoreHalfLife
+	}
+	return sc.HalfLife
+}
+
+// scoreAt returns the score of ec decayed to now.
+func (sc *Scoring) scoreAt(ec *errorCounter, now time.Time) float64 {
+	if ec.score == 0 {
+		return 0
+	}
+	elapsed := now.Sub(ec.scoredAt)
+	if elapsed <= 0 {
+		return ec.score
+	}
+	return ec.score * math.Exp2(-float64(elapsed)/float64(sc.halfLife()))
+}
+
+// offenseLimit is the number of offenses kept per ip: enough errors of the
+// lightest weight to reach the threshold, up to maxScoredOffenses.
+func (sc *Scoring) offenseLimit() int {
+	lightest := sc.weightOf("")
+	for _, w := range sc.Weights {
+		if w > 0 {
+			lightest = min(lightest, w)
+		}
+	}
+	return min(int(math.Ceil(sc.Threshold/lightest)), maxScoredOffenses)
+}
+
+// addScore adds the error of reason counted weight times to the score of
+// ec, and returns whether it reaches the threshold.
+func (sc *Scoring) addScore(ec *errorCounter, reason string, weight int, now time.Time) bool {
+	ec.score = sc.scoreAt(ec, now) + sc.weightOf(reason)*float64(weight)
+	ec.scoredAt = now
+	return ec.score >= sc.Threshold
+}
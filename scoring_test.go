@@ -0,0 +1,83 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScoring(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, ForgivableError{Duration: time.Minute, Count: 1, BanInMinute: 5, Warn: true},
+		WithScoring(Scoring{
+			Weights:       map[string]float64{"exploit": 100, "exploit probe": 5, "not found": 0.55},
+			DefaultWeight: 2,
+			HalfLife:      time.Hour,
+			Threshold:     10,
+		}))
+	require.NoError(t, err)
+
+	// a severe reason bans on its own, the longest prefix applies
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("1.2.3.4", "exploit /cgi-bin")
+	fw.LogIPError("1.2.3.5", "exploit probe")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "ban", mockLogger.Events[0].Action)
+	assert.Equal(t, "count error", mockLogger.Events[1].Action)
+	// the next probe reaches 10
+	assert.Equal(t, "ban-warning", mockLogger.Events[2].Action)
+
+	// minor reasons accumulate, beyond the count of the forgivable threshold
+	mockLogger.Wg.Add(20)
+	for range 19 {
+		fw.LogIPError("1.2.3.6", "not found")
+	}
+	mockLogger.Wg.Wait()
+	events := mockLogger.Events[3:]
+	require.Len(t, events, 20)
+	for _, e := range events[:18] {
+		assert.Equal(t, "count error", e.Action)
+	}
+	// 9.9 after the 18th error, the next one passes 10
+	assert.Equal(t, "ban-warning", events[18].Action)
+	assert.Equal(t, "ban", events[19].Action)
+	assert.Equal(t, map[string]int{"not found": 19}, events[19].ReasonCounts)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), events[19].JailUntil, time.Second)
+
+	assert.Equal(t, []string{"1.2.3.4", "1.2.3.6"}, mockFW.BannedIPs)
+}
+
+func TestScoreDecay(t *testing.T) {
+	sc := &Scoring{Threshold: 4, HalfLife: time.Minute}
+	ec := &errorCounter{}
+	now := time.Now()
+
+	assert.False(t, sc.addScore(ec, "a", 2, now))
+	assert.InDelta(t, 1, sc.scoreAt(ec, now.Add(time.Minute)), 1e-9)
+	assert.False(t, sc.addScore(ec, "a", 1, now.Add(2*time.Minute)))
+	assert.InDelta(t, 1.5, ec.score, 1e-9)
+	assert.True(t, sc.addScore(ec, "a", 3, now.Add(2*time.Minute)))
+
+	assert.Equal(t, 4, sc.offenseLimit())
+	sc.Weights = map[string]float64{"minor": 0.01, "ignored": 0}
+	assert.Equal(t, maxScoredOffenses, sc.offenseLimit())
+}
+
+func TestScoring_Invalid(t *testing.T) {
+	for _, sc := range []Scoring{
+		{},
+		{Threshold: -1},
+		{Threshold: 5, Weights: map[string]float64{"": 0}},
+		{Threshold: 5, Weights: map[string]float64{"": -1}},
+		{Threshold: 5, Weights: map[string]float64{"minor": -1}},
+	} {
+		_, err := New([]string{}, &MockIFirewall{}, &MockILogger{}, nil, testForgivable, WithScoring(sc))
+		assert.Error(t, err, "%+v", sc)
+	}
+
+	_, err := New([]string{}, &MockIFirewall{}, &MockILogger{}, nil, testForgivable, WithScoring(Scoring{Threshold: 5, Weights: map[string]float64{"ignored": 0}}))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,58 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/option"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// GCP reads secret versions of Google Cloud Secret Manager, the reference
+// is the resource name, e.g. "projects/p/secrets/opn", of the latest version
+// if it has no "/versions/".
+type GCP struct {
+	// AuthFile is a service account key, the application default
+	// credentials are used if empty.
+	AuthFile string
+
+	once sync.Once
+	svc  *secretmanager.Service
+	err  error
+}
+
+func (g *GCP) service(ctx context.Context) (*secretmanager.Service, error) {
+	g.once.Do(func() {
+		opts := []option.ClientOption{}
+		if g.AuthFile != "" {
+			opts = append(opts, option.WithCredentialsFile(g.AuthFile))
+		}
+		// the client outlives the context of the first secret
+		g.svc, g.err = secretmanager.NewService(context.WithoutCancel(ctx), opts...)
+	})
+	return g.svc, g.err
+}
+
+func (g *GCP) Secret(ctx context.Context, ref string) (string, error) {
+	svc, err := g.service(ctx)
+	if err != nil {
+		return "", fmt.Errorf("create secret manager client failed: %w", err)
+	}
+
+	name := ref
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	resp, err := svc.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("access %s failed: %w", name, err)
+	}
+	b, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("decode %s failed: %w", name, err)
+	}
+	return string(b), nil
+}
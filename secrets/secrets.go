@@ -0,0 +1,248 @@
+// Package secrets resolves secret:// references in the config, so backend
+// passwords and keys are kept in a secret manager instead of the config
+// file. A reference names its provider and the secret:
+//
+//	secret://vault/secret/data/opn#pass  key pass of a Vault KV v2 secret
+//	secret://gcp/projects/p/secrets/opn  latest version in GCP Secret Manager
+//	secret://env/OPN_PASS                environment variable
+//	secret://file/run/secrets/opn        file content, trimmed
+//
+// Secrets are cached for a ttl and read again after it; if the provider
+// fails then, the cached value is kept.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/config"
+)
+
+const (
+	// Scheme prefixes secret references.
+	Scheme = "secret://"
+
+	// DefaultTTL is how long a secret is cached.
+	DefaultTTL = 5 * time.Minute
+)
+
+// Provider reads a secret, ref is the reference after the provider name,
+// e.g. "secret/data/opn#pass".
+type Provider interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+// ProviderFunc adapts a function to Provider.
+type ProviderFunc func(ctx context.Context, ref string) (string, error)
+
+func (f ProviderFunc) Secret(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+type cached struct {
+	value     string
+	fetchedAt time.Time
+}
+
+// Resolver resolves references with the providers registered by name.
+type Resolver struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	providers map[string]Provider
+	cache     map[string]*cached
+}
+
+// NewResolver returns a Resolver with the env and file providers, secrets
+// are cached for ttl, DefaultTTL if not positive.
+func NewResolver(ttl time.Duration) *Resolver {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	r := &Resolver{
+		ttl:       ttl,
+		providers: map[string]Provider{},
+		cache:     map[string]*cached{},
+	}
+	r.Register("env", ProviderFunc(func(ctx context.Context, ref string) (string, error) {
+		v, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("no environment variable %s", ref)
+		}
+		return v, nil
+	}))
+	r.Register("file", ProviderFunc(func(ctx context.Context, ref string) (string, error) {
+		b, err := os.ReadFile("/" + ref)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}))
+	return r
+}
+
+// New returns a Resolver with the providers of c, which can be nil.
+func New(c *config.Secrets) *Resolver {
+	if c == nil {
+		return NewResolver(0)
+	}
+
+	r := NewResolver(time.Duration(c.TTL))
+	if v := c.Vault; v != nil {
+		r.Register("vault", &Vault{Address: v.Address, TokenFile: v.TokenFile})
+	}
+	if g := c.GCP; g != nil {
+		r.Register("gcp", &GCP{AuthFile: g.AuthFile})
+	}
+	return r
+}
+
+// Register adds the provider of name, replacing a provider of the same
+// name.
+func (r *Resolver) Register(name string, p Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[name] = p
+}
+
+// IsReference returns whether s is a secret reference.
+func IsReference(s string) bool {
+	return strings.HasPrefix(s, Scheme)
+}
+
+// Resolve returns the secret s refers to, or s if it is not a reference.
+func (r *Resolver) Resolve(ctx context.Context, s string) (string, error) {
+	if !IsReference(s) {
+		return s, nil
+	}
+
+	name, ref, _ := strings.Cut(strings.TrimPrefix(s, Scheme), "/")
+	r.mu.Lock()
+	p, ok := r.providers[name]
+	c := r.cache[s]
+	r.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("%s: no secret provider %q", s, name)
+	}
+	if c != nil && time.Since(c.fetchedAt) < r.ttl {
+		return c.value, nil
+	}
+
+	v, err := p.Secret(ctx, ref)
+	if err != nil {
+		if c != nil {
+			log.Printf("renew %s failed, keep the cached secret: %v", s, err)
+			return c.value, nil
+		}
+		return "", fmt.Errorf("%s: %w", s, err)
+	}
+
+	r.mu.Lock()
+	r.cache[s] = &cached{value: v, fetchedAt: time.Now()}
+	r.mu.Unlock()
+	return v, nil
+}
+
+// ResolveAll replaces the references in the strings of v, a pointer to a
+// struct, slice or map, recursively. Struct fields tagged `secrets:"-"`
+// are left as is, they are resolved when used.
+func (r *Resolver) ResolveAll(ctx context.Context, v any) error {
+	return r.resolveValue(ctx, reflect.ValueOf(v))
+}
+
+func (r *Resolver) resolveValue(ctx context.Context, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return r.resolveValue(ctx, v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := range v.NumField() {
+			if !t.Field(i).IsExported() || t.Field(i).Tag.Get("secrets") == "-" {
+				continue
+			}
+			if err := r.resolveValue(ctx, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			if err := r.resolveValue(ctx, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			e := v.MapIndex(k)
+			if e.Kind() == reflect.String {
+				s, err := r.Resolve(ctx, e.String())
+				if err != nil {
+					return err
+				}
+				v.SetMapIndex(k, reflect.ValueOf(s).Convert(e.Type()))
+				continue
+			}
+			// map values are not addressable, resolve a copy
+			c := reflect.New(e.Type()).Elem()
+			c.Set(e)
+			if err := r.resolveValue(ctx, c); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, c)
+		}
+	case reflect.String:
+		if !v.CanSet() || !IsReference(v.String()) {
+			return nil
+		}
+		s, err := r.Resolve(ctx, v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	}
+	return nil
+}
+
+// Credentials returns the backend credentials of the references user and
+// pass, resolved on every call so renewed secrets are picked up.
+func (r *Resolver) Credentials(user, pass string) firewall.ICredentialProvider {
+	return firewall.CredentialsFunc(func(ctx context.Context) (firewall.Credentials, error) {
+		u, err := r.Resolve(ctx, user)
+		if err != nil {
+			return firewall.Credentials{}, err
+		}
+		p, err := r.Resolve(ctx, pass)
+		if err != nil {
+			return firewall.Credentials{}, err
+		}
+		return firewall.Credentials{User: u, Pass: p}, nil
+	})
+}
+
+// ResolveConfig resolves the references in c with the providers of its
+// secrets section.
+func ResolveConfig(ctx context.Context, c *config.Config) error {
+	return New(c.Secrets).ResolveAll(ctx, c)
+}
+
+// Load reads the config at path like config.Load and resolves its
+// references.
+func Load(ctx context.Context, path string) (*config.Config, error) {
+	c, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := ResolveConfig(ctx, c); err != nil {
+		return nil, fmt.Errorf("resolve secrets failed: %w", err)
+	}
+	return c, nil
+}
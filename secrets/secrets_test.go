@@ -0,0 +1,183 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/config"
+)
+
+func TestResolve(t *testing.T) {
+	t.Setenv("FW_TEST_PASS", "env-pass")
+	path := filepath.Join(t.TempDir(), "pass")
+	require.NoError(t, os.WriteFile(path, []byte("file-pass\n"), 0o600))
+
+	r := NewResolver(0)
+	tests := map[string]string{
+		"plain":                     "plain",
+		"":                          "",
+		"secret://env/FW_TEST_PASS": "env-pass",
+		"secret://file" + path:      "file-pass",
+	}
+	for in, want := range tests {
+		got, err := r.Resolve(t.Context(), in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	_, err := r.Resolve(t.Context(), "secret://env/FW_TEST_MISSING")
+	assert.Error(t, err)
+	_, err = r.Resolve(t.Context(), "secret://vault/secret/data/opn#pass")
+	assert.ErrorContains(t, err, `no secret provider "vault"`)
+}
+
+func TestResolve_CacheAndRenew(t *testing.T) {
+	value := "v1"
+	var fail error
+	calls := 0
+	r := NewResolver(time.Hour)
+	r.Register("test", ProviderFunc(func(ctx context.Context, ref string) (string, error) {
+		calls++
+		assert.Equal(t, "key", ref)
+		return value, fail
+	}))
+
+	v, err := r.Resolve(t.Context(), "secret://test/key")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", v)
+
+	// cached until the ttl passes
+	value = "v2"
+	v, _ = r.Resolve(t.Context(), "secret://test/key")
+	assert.Equal(t, "v1", v)
+	assert.Equal(t, 1, calls)
+
+	r.cache["secret://test/key"].fetchedAt = time.Now().Add(-2 * time.Hour)
+	v, _ = r.Resolve(t.Context(), "secret://test/key")
+	assert.Equal(t, "v2", v)
+
+	// the cached secret is kept while the provider fails
+	fail = errors.New("unavailable")
+	r.cache["secret://test/key"].fetchedAt = time.Now().Add(-2 * time.Hour)
+	v, err = r.Resolve(t.Context(), "secret://test/key")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", v)
+	assert.Equal(t, 3, calls)
+}
+
+func TestVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/secret/data/opn", r.URL.Path)
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data": {"data": {"user": "key", "pass": "secret-1"}}}`))
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("s.token\n"), 0o600))
+
+	r := New(&config.Secrets{Vault: &config.SecretsVault{Address: srv.URL, TokenFile: tokenFile}})
+	v, err := r.Resolve(t.Context(), "secret://vault/secret/data/opn#pass")
+	require.NoError(t, err)
+	assert.Equal(t, "secret-1", v)
+
+	_, err = r.Resolve(t.Context(), "secret://vault/secret/data/opn#missing")
+	assert.ErrorContains(t, err, `no string key "missing"`)
+	_, err = r.Resolve(t.Context(), "secret://vault/secret/data/opn")
+	assert.ErrorContains(t, err, "has no #key")
+
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "bad")
+	_, err = New(&config.Secrets{Vault: &config.SecretsVault{}}).Resolve(t.Context(), "secret://vault/secret/data/opn#pass")
+	sErr := &firewall.StatusError{}
+	require.ErrorAs(t, err, &sErr)
+	assert.Equal(t, http.StatusForbidden, sErr.Code)
+}
+
+func TestVaultCredentials(t *testing.T) {
+	pass := "secret-1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/secret/data/opn", r.URL.Path)
+		w.Write([]byte(`{"data": {"data": {"user": "key", "pass": "` + pass + `"}, "metadata": {"version": 2}}}`))
+	}))
+	defer srv.Close()
+
+	p := VaultCredentials(&config.Vault{Address: srv.URL + "/", Path: "/secret/data/opn", TTL: config.Duration(time.Hour)})
+	c, err := p.Credentials(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, firewall.Credentials{User: "key", Pass: "secret-1"}, c)
+
+	// cached for the ttl
+	pass = "secret-2"
+	c, err = p.Credentials(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "secret-1", c.Pass)
+}
+
+func TestResolveConfig(t *testing.T) {
+	t.Setenv("FW_TEST_PASS", "opn-pass")
+	t.Setenv("FW_TEST_KEY", "misp-key")
+
+	c := &config.Config{
+		OPN: &config.OPN{
+			User: "key",
+			Pass: "secret://env/FW_TEST_PASS",
+			Credentials: &config.Credentials{
+				Pass: "secret://env/FW_TEST_PASS",
+			},
+		},
+		MISP: &config.MISP{
+			Key:  "secret://env/FW_TEST_KEY",
+			Tags: map[string]string{"ssh": "secret://env/FW_TEST_KEY"},
+		},
+	}
+	require.NoError(t, ResolveConfig(t.Context(), c))
+	assert.Equal(t, "key", c.OPN.User)
+	assert.Equal(t, "opn-pass", c.OPN.Pass)
+	assert.Equal(t, "misp-key", c.MISP.Key)
+	assert.Equal(t, "misp-key", c.MISP.Tags["ssh"])
+	// resolved on every call instead
+	assert.Equal(t, "secret://env/FW_TEST_PASS", c.OPN.Credentials.Pass)
+
+	c.MISP.Key = "secret://env/FW_TEST_MISSING"
+	assert.Error(t, ResolveConfig(t.Context(), c))
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv("FW_TEST_PASS", "pf-pass")
+	path := filepath.Join(t.TempDir(), "firewalld.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"pf": {"address": "https://pf", "user": "admin", "pass": "secret://env/FW_TEST_PASS"}}`), 0o600))
+
+	c, err := Load(t.Context(), path)
+	require.NoError(t, err)
+	assert.Equal(t, "pf-pass", c.PF.Pass)
+}
+
+func TestCredentials(t *testing.T) {
+	t.Setenv("FW_TEST_PASS", "pass-1")
+
+	p := NewResolver(time.Nanosecond).Credentials("api", "secret://env/FW_TEST_PASS")
+	c, err := p.Credentials(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, firewall.Credentials{User: "api", Pass: "pass-1"}, c)
+
+	// renewed once the ttl passes
+	t.Setenv("FW_TEST_PASS", "pass-2")
+	time.Sleep(time.Millisecond)
+	c, err = p.Credentials(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "pass-2", c.Pass)
+}
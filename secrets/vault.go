@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/config"
+)
+
+// Vault reads keys of HashiCorp Vault KV version 2 secrets, the reference
+// is the path of the secret and the key, e.g. "secret/data/opn#pass".
+type Vault struct {
+	// Address of vault, the VAULT_ADDR environment variable if empty.
+	Address string
+	// TokenFile is read on every request, e.g. renewed by vault agent. The
+	// VAULT_TOKEN environment variable is used if empty.
+	TokenFile string
+}
+
+func (v *Vault) Secret(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || key == "" {
+		return "", fmt.Errorf("vault reference %q has no #key", ref)
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if v.TokenFile != "" {
+		b, err := os.ReadFile(v.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("read vault token failed: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	address := v.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", fmt.Errorf("new request failed: %w", err)
+	}
+	r.Header.Set("X-Vault-Token", token)
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return "", fmt.Errorf("read vault secret failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &firewall.StatusError{Code: resp.StatusCode, Body: string(b)}
+	}
+
+	secret := struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(b, &secret); err != nil {
+		return "", fmt.Errorf("decode vault secret failed: %w", err)
+	}
+	s, ok := secret.Data.Data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string key %q", path, key)
+	}
+	return s, nil
+}
+
+// VaultCredentials returns the backend credentials of the keys user and
+// pass of the secret of v, resolved like secret://vault references and
+// cached for the ttl of v.
+func VaultCredentials(v *config.Vault) firewall.ICredentialProvider {
+	r := NewResolver(time.Duration(v.TTL))
+	r.Register("vault", &Vault{Address: v.Address, TokenFile: v.TokenFile})
+	ref := Scheme + "vault/" + strings.TrimPrefix(v.Path, "/")
+	return r.Credentials(ref+"#user", ref+"#pass")
+}
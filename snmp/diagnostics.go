@@ -0,0 +1,46 @@
+package snmp
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+)
+
+// backendHealthWindow is how long a backend error marks the backend as
+// failing.
+const backendHealthWindow = 5 * time.Minute
+
+// DiagnosticsVars maps d to vars:
+//
+//	1.0 jailed ips, gauge
+//	2.0 bans since start, counter, the ban rate is its delta
+//	3.0 ips with counted errors, gauge
+//	4.0 backend health, integer: 1 ok, 2 failing in the last 5 minutes
+//	5.0 backend errors in the last 5 minutes, gauge
+//	6.0 enforcement paused by the safety valve, TruthValue: 1 true, 2 false
+func DiagnosticsVars(d *firewall.Diagnostics) []Var {
+	recentErrors := 0
+	for _, e := range d.LastBackendErrors {
+		if d.Time.Sub(e.Time) < backendHealthWindow {
+			recentErrors++
+		}
+	}
+	health := 1
+	if recentErrors > 0 {
+		health = 2
+	}
+	paused := 2
+	if d.Paused {
+		paused = 1
+	}
+
+	return []Var{
+		{OID: "1.0", Type: "gauge", Value: strconv.Itoa(d.Jailed)},
+		{OID: "2.0", Type: "counter", Value: strconv.FormatUint(uint64(uint32(d.Enforcement.Count)), 10)},
+		{OID: "3.0", Type: "gauge", Value: strconv.Itoa(d.ErrorCounters)},
+		{OID: "4.0", Type: "integer", Value: strconv.Itoa(health)},
+		{OID: "5.0", Type: "gauge", Value: strconv.Itoa(recentErrors)},
+		{OID: "6.0", Type: "integer", Value: strconv.Itoa(paused)},
+	}
+}
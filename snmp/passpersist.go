@@ -0,0 +1,145 @@
+// Package snmp exposes the firewall to SNMP monitoring through the
+// pass_persist protocol of net-snmp: snmpd runs `fw snmp-pass` and forwards
+// the requests under its OID to it, e.g. in snmpd.conf:
+//
+//	pass_persist .1.3.6.1.4.1.8072.9999.9999.1 /usr/local/bin/fw snmp-pass
+package snmp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Var is a variable served under the base OID.
+type Var struct {
+	// OID is relative to the base, e.g. "1.0".
+	OID string
+	// Type is a pass_persist type: integer, gauge, counter, timeticks or
+	// string.
+	Type  string
+	Value string
+}
+
+type oid []int
+
+func parseOID(s string) (oid, error) {
+	s = strings.Trim(s, ".")
+	if s == "" {
+		return oid{}, nil
+	}
+	res := oid{}
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid oid %q", s)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+func (o oid) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.Itoa(n)
+	}
+	return "." + strings.Join(parts, ".")
+}
+
+// Server answers pass_persist requests under Base with the vars returned
+// by Fetch, which is called for each get or getnext request.
+type Server struct {
+	Base  string
+	Fetch func() ([]Var, error)
+}
+
+type entry struct {
+	oid oid
+	v   Var
+}
+
+// vars returns the vars with full OIDs in order.
+func (s *Server) vars(base oid) ([]entry, error) {
+	vars, err := s.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	res := []entry{}
+	for _, v := range vars {
+		rel, err := parseOID(v.OID)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, entry{oid: append(slices.Clone(base), rel...), v: v})
+	}
+	slices.SortFunc(res, func(a, b entry) int { return slices.Compare(a.oid, b.oid) })
+	return res, nil
+}
+
+// Serve handles requests from r until EOF.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	base, err := parseOID(s.Base)
+	if err != nil {
+		return err
+	}
+
+	in := bufio.NewScanner(r)
+	next := func() (string, bool) {
+		if !in.Scan() {
+			return "", false
+		}
+		return strings.TrimSpace(in.Text()), true
+	}
+
+	for {
+		cmd, ok := next()
+		if !ok || cmd == "" {
+			return in.Err()
+		}
+
+		var resp string
+		switch strings.ToLower(cmd) {
+		case "ping":
+			resp = "PONG\n"
+		case "get", "getnext":
+			arg, ok := next()
+			if !ok {
+				return in.Err()
+			}
+			resp = s.lookup(base, arg, cmd == "getnext")
+		case "set":
+			// oid and value
+			next()
+			next()
+			resp = "not-writable\n"
+		default:
+			resp = "NONE\n"
+		}
+		if _, err := io.WriteString(w, resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) lookup(base oid, arg string, getNext bool) string {
+	target, err := parseOID(arg)
+	if err != nil {
+		return "NONE\n"
+	}
+	vars, err := s.vars(base)
+	if err != nil {
+		return "NONE\n"
+	}
+
+	for _, e := range vars {
+		c := slices.Compare(e.oid, target)
+		if (!getNext && c == 0) || (getNext && c > 0) {
+			return fmt.Sprintf("%s\n%s\n%s\n", e.oid, e.v.Type, e.v.Value)
+		}
+	}
+	return "NONE\n"
+}
@@ -0,0 +1,86 @@
+package snmp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+)
+
+func TestServe(t *testing.T) {
+	s := &Server{
+		Base: ".1.3.6.1.4.1.8072.9999.9999.1",
+		Fetch: func() ([]Var, error) {
+			return []Var{
+				{OID: "2.0", Type: "counter", Value: "7"},
+				{OID: "1.0", Type: "gauge", Value: "3"},
+				{OID: "10.0", Type: "integer", Value: "1"},
+			}, nil
+		},
+	}
+
+	in := strings.Join([]string{
+		"PING",
+		"get", ".1.3.6.1.4.1.8072.9999.9999.1.1.0",
+		"get", ".1.3.6.1.4.1.8072.9999.9999.1.3.0",
+		"getnext", ".1.3.6.1.4.1.8072.9999.9999.1",
+		"getnext", ".1.3.6.1.4.1.8072.9999.9999.1.2.0",
+		"getnext", ".1.3.6.1.4.1.8072.9999.9999.1.10.0",
+		"set", ".1.3.6.1.4.1.8072.9999.9999.1.1.0", "gauge 1",
+		"",
+	}, "\n")
+	out := &bytes.Buffer{}
+	require.NoError(t, s.Serve(strings.NewReader(in), out))
+
+	assert.Equal(t, strings.Join([]string{
+		"PONG",
+		".1.3.6.1.4.1.8072.9999.9999.1.1.0", "gauge", "3",
+		"NONE",
+		".1.3.6.1.4.1.8072.9999.9999.1.1.0", "gauge", "3",
+		// 10 sorts after 2 numerically.
+		".1.3.6.1.4.1.8072.9999.9999.1.10.0", "integer", "1",
+		"NONE",
+		"not-writable",
+		"",
+	}, "\n"), out.String())
+}
+
+func TestServe_FetchError(t *testing.T) {
+	s := &Server{
+		Base:  ".1.3.6.1.4.1.8072.9999.9999.1",
+		Fetch: func() ([]Var, error) { return nil, errors.New("down") },
+	}
+	out := &bytes.Buffer{}
+	require.NoError(t, s.Serve(strings.NewReader("get\n.1.3.6.1.4.1.8072.9999.9999.1.1.0\n"), out))
+	assert.Equal(t, "NONE\n", out.String())
+}
+
+func TestDiagnosticsVars(t *testing.T) {
+	now := time.Now()
+	d := &firewall.Diagnostics{
+		Time:          now,
+		Jailed:        3,
+		ErrorCounters: 5,
+		Paused:        true,
+		LastBackendErrors: []firewall.BackendErrorInfo{
+			{Time: now.Add(-time.Hour)},
+			{Time: now.Add(-time.Minute)},
+		},
+		Enforcement: firewall.EnforcementStats{Count: 9},
+	}
+
+	assert.Equal(t, []Var{
+		{OID: "1.0", Type: "gauge", Value: "3"},
+		{OID: "2.0", Type: "counter", Value: "9"},
+		{OID: "3.0", Type: "gauge", Value: "5"},
+		{OID: "4.0", Type: "integer", Value: "2"},
+		{OID: "5.0", Type: "gauge", Value: "1"},
+		{OID: "6.0", Type: "integer", Value: "1"},
+	}, DiagnosticsVars(d))
+}
@@ -0,0 +1,24 @@
+//go:build soak
+
+package firewall_test
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Run with: go test -tags soak -run 'TestSoak$' -timeout 1h .
+// SOAK_EVENTS_PER_SECOND scales the simulated 24h of attack traffic. Each
+// ban is synced to the WAL, so higher rates mostly measure fsync.
+func TestSoak(t *testing.T) {
+	perSecond := 3
+	if v := os.Getenv("SOAK_EVENTS_PER_SECOND"); v != "" {
+		n, err := strconv.Atoi(v)
+		require.NoError(t, err)
+		perSecond = n
+	}
+	soak{hours: 24, perSecond: perSecond, botnet: 4096}.run(t)
+}
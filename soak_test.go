@@ -0,0 +1,192 @@
+package firewall_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/internal/faultinject"
+	"github.com/charleshuang3/firewall/ipgeo"
+	"github.com/charleshuang3/firewall/wal"
+)
+
+// recordBackend records every ip it ever banned, unbans are accepted but
+// not recorded so lost bans are found after their release.
+type recordBackend struct {
+	mu     sync.Mutex
+	banned map[string]bool
+}
+
+func (r *recordBackend) Name() string {
+	return "record"
+}
+
+func (r *recordBackend) BanIP(ip string, timeoutInMinute int) {
+	r.TryBanIP(ip, timeoutInMinute)
+}
+
+func (r *recordBackend) TryBanIP(ip string, timeoutInMinute int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.banned[ip] = true
+	return nil
+}
+
+func (r *recordBackend) UnbanIP(ip string) error {
+	return nil
+}
+
+// decisionLogger records the bans decided and counts the bans and
+// releases before passing events to a faulty logger.
+type decisionLogger struct {
+	next     firewall.IEventLogger
+	decided  map[string]bool
+	bans     int
+	released int
+}
+
+func (d *decisionLogger) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	d.LogEvent(&firewall.Event{IP: ip, JailUntil: jailUntil, Reasons: reasons, Action: action, Geo: geo})
+}
+
+func (d *decisionLogger) LogEvent(e *firewall.Event) {
+	switch e.Action {
+	case "ban":
+		d.decided[e.IP] = true
+		d.bans++
+	case "released":
+		d.released++
+	}
+	d.next.LogEvent(e)
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+}
+
+func (discardLogger) LogEvent(e *firewall.Event) {}
+
+// simClock is a clock moved by the test.
+type simClock struct {
+	t atomic.Int64
+}
+
+func (c *simClock) set(t time.Time) {
+	c.t.Store(t.UnixNano())
+}
+
+func (c *simClock) now() time.Time {
+	return time.Unix(0, c.t.Load())
+}
+
+// soak is simulated attack traffic: a botnet erroring again and again,
+// banned and released every hour, plus scanners erroring once from ips
+// never seen again.
+type soak struct {
+	hours     int
+	perSecond int
+	botnet    int
+}
+
+// run sends the traffic of every simulated second and runs the periodic
+// work of the loop every simulated minute, so bans are released and idle
+// counters evicted as in a long run. Failed bans are replayed from the WAL
+// every minute like the daemon does.
+func (s soak) run(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	inner := &recordBackend{banned: map[string]bool{}}
+	faulty := faultinject.NewBackend(inner, faultinject.Faults{
+		ErrorRate:   0.2,
+		PartialRate: 0.1,
+		Jitter:      200 * time.Microsecond,
+		Seed:        1,
+	})
+	w, err := wal.Open(filepath.Join(t.TempDir(), "wal"), faulty)
+	require.NoError(t, err)
+	defer w.Close()
+
+	logger := &decisionLogger{
+		next:    faultinject.NewLogger(discardLogger{}, faultinject.Faults{ErrorRate: 0.05, Seed: 2}),
+		decided: map[string]bool{},
+	}
+	clock := &simClock{}
+	start := time.Now()
+	clock.set(start)
+	fw, err := firewall.New(nil, w, logger, nil, firewall.ForgivableError{
+		Duration:    time.Hour,
+		Count:       5,
+		BanInMinute: 60,
+	}, firewall.WithClock(clock.now))
+	require.NoError(t, err)
+
+	rnd := rand.New(rand.NewPCG(3, 3))
+	scanner := 0
+	maxCounters, maxJailed := 0, 0
+	for sec := 0; sec < s.hours*3600; sec++ {
+		clock.set(start.Add(time.Duration(sec) * time.Second))
+		for range s.perSecond {
+			// a third of the errors are from scanners
+			if rnd.IntN(3) == 0 {
+				scanner++
+				fw.LogIPError(fmt.Sprintf("11.%d.%d.%d", scanner>>16&0xff, scanner>>8&0xff, scanner&0xff), "invalid password")
+				continue
+			}
+			n := rnd.IntN(s.botnet)
+			fw.LogIPError(fmt.Sprintf("10.%d.%d.1", n>>8, n&0xff), "invalid password")
+		}
+
+		if sec%60 == 59 {
+			w.Replay()
+			fw.Tick()
+
+			d := fw.Diagnostics()
+			maxCounters = max(maxCounters, d.ErrorCounters)
+			maxJailed = max(maxJailed, d.Jailed)
+		}
+	}
+
+	for i := 0; i < 100 && w.Pending() > 0; i++ {
+		w.Replay()
+	}
+	assert.Zero(t, w.Pending())
+
+	inner.mu.Lock()
+	for ip := range logger.decided {
+		assert.True(t, inner.banned[ip], "ban of %s lost", ip)
+	}
+	inner.mu.Unlock()
+	assert.NotEmpty(t, logger.decided)
+
+	// the botnet is banned again after its release
+	assert.Greater(t, logger.released, s.botnet)
+	assert.Greater(t, logger.bans, 2*s.botnet)
+	// counters of scanners are evicted within the hour of their error,
+	// without gc every scanner would keep one
+	perHour := s.perSecond * 3600
+	assert.Less(t, maxCounters, s.botnet+perHour/2, "%d counters of %d scanners", maxCounters, scanner)
+	assert.LessOrEqual(t, maxJailed, s.botnet)
+
+	require.NoError(t, fw.Close(context.Background()))
+	assert.LessOrEqual(t, runtime.NumGoroutine(), baseline)
+
+	runtime.GC()
+	m := runtime.MemStats{}
+	runtime.ReadMemStats(&m)
+	assert.Less(t, m.HeapAlloc, uint64(256<<20), "heap %d MiB", m.HeapAlloc>>20)
+}
+
+func TestSoak_Short(t *testing.T) {
+	soak{hours: 3, perSecond: 1, botnet: 128}.run(t)
+}
@@ -0,0 +1,72 @@
+// Package stix exports bans as STIX 2.1 indicators over a minimal read only
+// TAXII 2.1 server with one collection.
+package stix
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// namespace of the deterministic indicator ids.
+var namespace = uuid.MustParse("6f1f5a3e-1c52-4d0b-9b1e-5c1d1f6c0b7a")
+
+// Ban is a ban to export.
+type Ban struct {
+	IP      string
+	Time    time.Time
+	Until   time.Time
+	Reasons []string
+}
+
+// Indicator is a STIX 2.1 indicator object.
+type Indicator struct {
+	Type           string    `json:"type"`
+	SpecVersion    string    `json:"spec_version"`
+	ID             string    `json:"id"`
+	Created        time.Time `json:"created"`
+	Modified       time.Time `json:"modified"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description,omitempty"`
+	IndicatorTypes []string  `json:"indicator_types"`
+	Pattern        string    `json:"pattern"`
+	PatternType    string    `json:"pattern_type"`
+	ValidFrom      time.Time `json:"valid_from"`
+	ValidUntil     time.Time `json:"valid_until,omitzero"`
+}
+
+// pattern matches ip as an ipv4-addr or ipv6-addr.
+func pattern(ip string) string {
+	typ := "ipv4-addr"
+	if p := net.ParseIP(ip); p != nil && p.To4() == nil {
+		typ = "ipv6-addr"
+	}
+	return fmt.Sprintf("[%s:value = '%s']", typ, ip)
+}
+
+// NewIndicator converts b, the validity window is the jail time. The id is
+// derived from the ip and ban time, so the same ban always exports the same
+// object.
+func NewIndicator(b *Ban) *Indicator {
+	t := b.Time.UTC().Truncate(time.Millisecond)
+	i := &Indicator{
+		Type:           "indicator",
+		SpecVersion:    "2.1",
+		ID:             "indicator--" + uuid.NewSHA1(namespace, []byte(fmt.Sprintf("%s|%d", b.IP, t.UnixMilli()))).String(),
+		Created:        t,
+		Modified:       t,
+		Name:           "banned ip " + b.IP,
+		Description:    strings.Join(b.Reasons, "; "),
+		IndicatorTypes: []string{"malicious-activity"},
+		Pattern:        pattern(b.IP),
+		PatternType:    "stix",
+		ValidFrom:      t,
+	}
+	if !b.Until.IsZero() && b.Until.After(b.Time) {
+		i.ValidUntil = b.Until.UTC().Truncate(time.Millisecond)
+	}
+	return i
+}
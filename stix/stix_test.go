@@ -0,0 +1,81 @@
+package stix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIndicator(t *testing.T) {
+	b := &Ban{
+		IP:      "1.2.3.4",
+		Time:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Until:   time.Date(2024, 1, 2, 4, 4, 5, 0, time.UTC),
+		Reasons: []string{"invalid password", "scanner"},
+	}
+
+	got := NewIndicator(b)
+	assert.Equal(t, "[ipv4-addr:value = '1.2.3.4']", got.Pattern)
+
+	v6 := NewIndicator(&Ban{IP: "2001:db8::1", Time: b.Time})
+	assert.Equal(t, "[ipv6-addr:value = '2001:db8::1']", v6.Pattern)
+	assert.Equal(t, "invalid password; scanner", got.Description)
+	assert.Equal(t, b.Time, got.ValidFrom)
+	assert.Equal(t, b.Until, got.ValidUntil)
+	assert.Equal(t, NewIndicator(b).ID, got.ID)
+	assert.Regexp(t, `^indicator--[0-9a-f-]{36}$`, got.ID)
+
+	// no expire
+	b.Until = time.Time{}
+	j, err := json.Marshal(NewIndicator(b))
+	require.NoError(t, err)
+	assert.NotContains(t, string(j), "valid_until")
+}
+
+func TestServer(t *testing.T) {
+	var gotSince time.Time
+	s := &Server{
+		Title:  "bans",
+		Window: time.Hour,
+		Source: func(since time.Time) ([]Ban, error) {
+			gotSince = since
+			return []Ban{{IP: "1.2.3.4", Time: time.Now()}}, nil
+		},
+	}
+	h := s.Handler()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/taxii2/")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, contentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"api_roots":["/taxii2/api/"]`)
+
+	w = get("/taxii2/api/collections/")
+	assert.Contains(t, w.Body.String(), CollectionID)
+
+	assert.Equal(t, http.StatusNotFound, get("/taxii2/api/collections/unknown/objects/").Code)
+
+	w = get("/taxii2/api/collections/" + CollectionID + "/objects/")
+	assert.Equal(t, http.StatusOK, w.Code)
+	env := &envelope{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), env))
+	require.Len(t, env.Objects, 1)
+	assert.Equal(t, "[ipv4-addr:value = '1.2.3.4']", env.Objects[0].Pattern)
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), gotSince, time.Minute)
+
+	after := time.Now().Add(-time.Minute).UTC().Truncate(time.Millisecond)
+	get("/taxii2/api/collections/" + CollectionID + "/objects/?added_after=" + after.Format(time.RFC3339Nano))
+	assert.Equal(t, after.Add(time.Millisecond), gotSince.UTC())
+
+	assert.Equal(t, http.StatusBadRequest, get("/taxii2/api/collections/"+CollectionID+"/objects/?added_after=x").Code)
+}
@@ -0,0 +1,149 @@
+package stix
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	contentType = "application/taxii+json;version=2.1"
+
+	// CollectionID of the ban collection.
+	CollectionID = "3d1c6f0e-5c5e-4f43-9a3d-5f2b0f8a6b11"
+)
+
+// Server is a read only TAXII 2.1 server under /taxii2/, the api root is
+// /taxii2/api/.
+type Server struct {
+	// Title of the server and the collection.
+	Title string
+	// Source returns the bans at or after since.
+	Source func(since time.Time) ([]Ban, error)
+	// Window is how far back bans are exported.
+	Window time.Duration
+}
+
+type discovery struct {
+	Title    string   `json:"title"`
+	Default  string   `json:"default"`
+	APIRoots []string `json:"api_roots"`
+}
+
+type apiRoot struct {
+	Title            string   `json:"title"`
+	Versions         []string `json:"versions"`
+	MaxContentLength int      `json:"max_content_length"`
+}
+
+type collection struct {
+	ID         string   `json:"id"`
+	Title      string   `json:"title"`
+	CanRead    bool     `json:"can_read"`
+	CanWrite   bool     `json:"can_write"`
+	MediaTypes []string `json:"media_types"`
+}
+
+type collections struct {
+	Collections []collection `json:"collections"`
+}
+
+type envelope struct {
+	More    bool         `json:"more"`
+	Objects []*Indicator `json:"objects"`
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /taxii2/", s.handleDiscovery)
+	mux.HandleFunc("GET /taxii2/api/", s.handleAPIRoot)
+	mux.HandleFunc("GET /taxii2/api/collections/", s.handleCollections)
+	mux.HandleFunc("GET /taxii2/api/collections/{id}/", s.handleCollection)
+	mux.HandleFunc("GET /taxii2/api/collections/{id}/objects/", s.handleObjects)
+	return mux
+}
+
+func (s *Server) collection() collection {
+	return collection{
+		ID:         CollectionID,
+		Title:      s.Title,
+		CanRead:    true,
+		MediaTypes: []string{"application/stix+json;version=2.1"},
+	}
+}
+
+func write(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", contentType)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/taxii2/" {
+		http.NotFound(w, r)
+		return
+	}
+	write(w, &discovery{
+		Title:    s.Title,
+		Default:  "/taxii2/api/",
+		APIRoots: []string{"/taxii2/api/"},
+	})
+}
+
+func (s *Server) handleAPIRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/taxii2/api/" {
+		http.NotFound(w, r)
+		return
+	}
+	write(w, &apiRoot{
+		Title:            s.Title,
+		Versions:         []string{contentType},
+		MaxContentLength: 0,
+	})
+}
+
+func (s *Server) handleCollections(w http.ResponseWriter, r *http.Request) {
+	write(w, &collections{Collections: []collection{s.collection()}})
+}
+
+func (s *Server) handleCollection(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("id") != CollectionID {
+		http.NotFound(w, r)
+		return
+	}
+	write(w, s.collection())
+}
+
+// handleObjects serves the indicators, added_after narrows the window.
+func (s *Server) handleObjects(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("id") != CollectionID {
+		http.NotFound(w, r)
+		return
+	}
+
+	since := time.Now().Add(-s.Window)
+	if v := r.URL.Query().Get("added_after"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			http.Error(w, "invalid added_after", http.StatusBadRequest)
+			return
+		}
+		if t.After(since) {
+			// added_after is exclusive
+			since = t.Truncate(time.Millisecond).Add(time.Millisecond)
+		}
+	}
+
+	bans, err := s.Source(since)
+	if err != nil {
+		log.Printf("read bans for taxii failed: %v", err)
+		http.Error(w, "read bans failed", http.StatusInternalServerError)
+		return
+	}
+
+	res := &envelope{Objects: []*Indicator{}}
+	for i := range bans {
+		res.Objects = append(res.Objects, NewIndicator(&bans[i]))
+	}
+	write(w, res)
+}
@@ -0,0 +1,182 @@
+// Package stuffing detects credential stuffing and password spraying from
+// the targets of errors, e.g. the accounts of failed logins. It is a
+// firewall.IPolicy keeping statistics of a sliding window: the distinct
+// targets per ip, the distinct ips per target and the entropy of the
+// targets of an ip. Errors matching a pattern count as Weight errors, so
+// the ip is banned sooner than by the plain counter.
+package stuffing
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+)
+
+var _ firewall.IPolicy = (*Detector)(nil)
+
+const (
+	defaultWindow    = 10 * time.Minute
+	defaultMinErrors = 10
+	defaultWeight    = 5
+	// maxRecords bounds the errors kept in the window, the oldest are
+	// dropped first.
+	maxRecords = 100000
+)
+
+// Config of a Detector, zero thresholds disable their check.
+type Config struct {
+	// Window of the statistics, default 10m.
+	Window time.Duration
+	// TargetsPerIP is the distinct targets of an ip to detect credential
+	// stuffing.
+	TargetsPerIP int
+	// IPsPerTarget is the distinct ips on a target to detect a distributed
+	// attack on it.
+	IPsPerTarget int
+	// MinEntropy is the entropy in bits of the targets of an ip to detect
+	// password spraying, a user mistyping a password has an entropy of 0.
+	MinEntropy float64
+	// MinErrors of an ip before its entropy is checked, default 10.
+	MinErrors int
+	// Weight is the number of errors a detected error counts as, default 5.
+	Weight int
+}
+
+// Stats of an error in the window, including it.
+type Stats struct {
+	// TargetsOfIP is the distinct targets of the ip.
+	TargetsOfIP int
+	// IPsOfTarget is the distinct ips on the target.
+	IPsOfTarget int
+	// Errors of the ip.
+	Errors int
+	// Entropy in bits of the targets of the ip.
+	Entropy float64
+}
+
+type record struct {
+	ip     string
+	target string
+	at     time.Time
+}
+
+// Detector is safe for concurrent use.
+type Detector struct {
+	cfg Config
+
+	mu sync.Mutex
+	// records in the window, oldest first.
+	records []record
+	// byIP counts the errors of an ip per target, byTarget the errors of
+	// a target per ip.
+	byIP     map[string]map[string]int
+	byTarget map[string]map[string]int
+}
+
+func New(cfg Config) *Detector {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultWindow
+	}
+	if cfg.MinErrors <= 0 {
+		cfg.MinErrors = defaultMinErrors
+	}
+	if cfg.Weight <= 0 {
+		cfg.Weight = defaultWeight
+	}
+	return &Detector{
+		cfg:      cfg,
+		byIP:     map[string]map[string]int{},
+		byTarget: map[string]map[string]int{},
+	}
+}
+
+// Decide counts errors without a target as usual. Others are added to the
+// window and count as Weight errors if they match a pattern.
+func (d *Detector) Decide(in *firewall.PolicyInput) (firewall.Decision, error) {
+	if in.Target == "" {
+		return firewall.Decision{Verdict: firewall.VerdictCount}, nil
+	}
+
+	st := d.Add(in.IP, in.Target, in.Time)
+	reason := ""
+	switch {
+	case d.cfg.TargetsPerIP > 0 && st.TargetsOfIP >= d.cfg.TargetsPerIP:
+		reason = fmt.Sprintf("credential stuffing: %d targets in %s", st.TargetsOfIP, d.cfg.Window)
+	case d.cfg.IPsPerTarget > 0 && st.IPsOfTarget >= d.cfg.IPsPerTarget:
+		reason = fmt.Sprintf("distributed attack on %s: %d ips in %s", in.Target, st.IPsOfTarget, d.cfg.Window)
+	case d.cfg.MinEntropy > 0 && st.Errors >= d.cfg.MinErrors && st.Entropy >= d.cfg.MinEntropy:
+		reason = fmt.Sprintf("password spraying: target entropy %.2f bits", st.Entropy)
+	default:
+		return firewall.Decision{Verdict: firewall.VerdictCount}, nil
+	}
+	return firewall.Decision{Verdict: firewall.VerdictCount, Weight: d.cfg.Weight, Reason: reason}, nil
+}
+
+// Add records an error of ip on target at t and returns its stats.
+func (d *Detector) Add(ip, target string, t time.Time) Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prune(t.Add(-d.cfg.Window))
+	if len(d.records) >= maxRecords {
+		d.drop(1)
+	}
+	d.records = append(d.records, record{ip: ip, target: target, at: t})
+	inc(d.byIP, ip, target)
+	inc(d.byTarget, target, ip)
+
+	targets := d.byIP[ip]
+	st := Stats{
+		TargetsOfIP: len(targets),
+		IPsOfTarget: len(d.byTarget[target]),
+	}
+	for _, n := range targets {
+		st.Errors += n
+	}
+	for _, n := range targets {
+		p := float64(n) / float64(st.Errors)
+		st.Entropy -= p * math.Log2(p)
+	}
+	return st
+}
+
+// prune drops the records before cutoff.
+func (d *Detector) prune(cutoff time.Time) {
+	n := 0
+	for n < len(d.records) && d.records[n].at.Before(cutoff) {
+		n++
+	}
+	d.drop(n)
+}
+
+// drop drops the oldest n records.
+func (d *Detector) drop(n int) {
+	for _, r := range d.records[:n] {
+		dec(d.byIP, r.ip, r.target)
+		dec(d.byTarget, r.target, r.ip)
+	}
+	d.records = d.records[n:]
+}
+
+func inc(m map[string]map[string]int, k1, k2 string) {
+	inner, ok := m[k1]
+	if !ok {
+		inner = map[string]int{}
+		m[k1] = inner
+	}
+	inner[k2]++
+}
+
+func dec(m map[string]map[string]int, k1, k2 string) {
+	inner := m[k1]
+	inner[k2]--
+	if inner[k2] <= 0 {
+		delete(inner, k2)
+	}
+	if len(inner) == 0 {
+		delete(m, k1)
+	}
+}
@@ -0,0 +1,78 @@
+package stuffing
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/charleshuang3/firewall"
+)
+
+func decide(d *Detector, ip, target string, t time.Time) firewall.Decision {
+	res, _ := d.Decide(&firewall.PolicyInput{IP: ip, Target: target, Reason: "bad password", Time: t})
+	return res
+}
+
+func TestDetector_TargetsPerIP(t *testing.T) {
+	d := New(Config{TargetsPerIP: 3, Weight: 4})
+	now := time.Now()
+
+	assert.Equal(t, firewall.Decision{Verdict: firewall.VerdictCount}, decide(d, "1.2.3.4", "alice", now))
+	assert.Equal(t, firewall.Decision{Verdict: firewall.VerdictCount}, decide(d, "1.2.3.4", "alice", now))
+	assert.Equal(t, firewall.Decision{Verdict: firewall.VerdictCount}, decide(d, "1.2.3.4", "bob", now))
+
+	got := decide(d, "1.2.3.4", "carol", now)
+	assert.Equal(t, firewall.VerdictCount, got.Verdict)
+	assert.Equal(t, 4, got.Weight)
+	assert.Equal(t, "credential stuffing: 3 targets in 10m0s", got.Reason)
+
+	// other ips are not affected
+	assert.Zero(t, decide(d, "1.2.3.5", "carol", now).Weight)
+	// errors without target are counted as usual
+	assert.Zero(t, decide(d, "1.2.3.4", "", now).Weight)
+}
+
+func TestDetector_IPsPerTarget(t *testing.T) {
+	d := New(Config{IPsPerTarget: 3})
+	now := time.Now()
+
+	decide(d, "1.2.3.1", "admin", now)
+	decide(d, "1.2.3.2", "admin", now)
+	got := decide(d, "1.2.3.3", "admin", now)
+	assert.Equal(t, defaultWeight, got.Weight)
+	assert.Equal(t, "distributed attack on admin: 3 ips in 10m0s", got.Reason)
+}
+
+func TestDetector_Entropy(t *testing.T) {
+	d := New(Config{MinEntropy: 2, MinErrors: 4})
+	now := time.Now()
+
+	// a user mistyping a password
+	for range 10 {
+		assert.Zero(t, decide(d, "1.2.3.4", "alice", now).Weight)
+	}
+
+	for i := range 3 {
+		assert.Zero(t, decide(d, "1.2.3.5", fmt.Sprint("user", i), now).Weight)
+	}
+	got := decide(d, "1.2.3.5", "user3", now)
+	assert.Equal(t, "password spraying: target entropy 2.00 bits", got.Reason)
+}
+
+func TestDetector_Window(t *testing.T) {
+	d := New(Config{Window: time.Minute})
+	now := time.Now()
+
+	d.Add("1.2.3.4", "alice", now)
+	d.Add("1.2.3.5", "alice", now)
+	st := d.Add("1.2.3.4", "bob", now.Add(30*time.Second))
+	assert.Equal(t, Stats{TargetsOfIP: 2, IPsOfTarget: 1, Errors: 2, Entropy: 1}, st)
+
+	st = d.Add("1.2.3.4", "carol", now.Add(70*time.Second))
+	assert.Equal(t, Stats{TargetsOfIP: 2, IPsOfTarget: 1, Errors: 2, Entropy: 1}, st)
+	assert.Len(t, d.records, 2)
+	assert.NotContains(t, d.byTarget, "alice")
+	assert.NotContains(t, d.byIP, "1.2.3.5")
+}
@@ -0,0 +1,68 @@
+package firewall
+
+import "slices"
+
+// eventBuffer is the number of events a subscriber of Events can fall
+// behind before it misses events.
+const eventBuffer = 256
+
+// Events returns a channel receiving every event logged from now on, e.g.
+// "ban", "unban" and "count error", so dashboards and exporters consume
+// them without writing an ILogger. A subscriber falling more than 256
+// events behind misses events, they are counted by EventsDropped; the
+// firewall never waits for a subscriber. The channel is closed by
+// Unsubscribe or Close. Events must not be modified.
+func (s *Firewall) Events() <-chan Event {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	ch := make(chan Event, eventBuffer)
+	if s.subsClosed {
+		close(ch)
+		return ch
+	}
+	s.subs = append(s.subs, ch)
+	return ch
+}
+
+// Unsubscribe stops and closes ch, a channel returned by Events.
+func (s *Firewall) Unsubscribe(ch <-chan Event) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for i, sub := range s.subs {
+		if sub == ch {
+			close(sub)
+			s.subs = slices.Delete(s.subs, i, i+1)
+			return
+		}
+	}
+}
+
+// EventsDropped returns the number of events missed by slow subscribers of
+// Events, since New.
+func (s *Firewall) EventsDropped() uint64 {
+	return s.eventsDropped.Load()
+}
+
+// publish sends e to the subscribers which have room for it.
+func (s *Firewall) publish(e *Event) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for _, sub := range s.subs {
+		select {
+		case sub <- *e:
+		default:
+			s.eventsDropped.Add(1)
+		}
+	}
+}
+
+// closeSubscriptions closes the channels of Events when the loop stops.
+func (s *Firewall) closeSubscriptions() {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for _, sub := range s.subs {
+		close(sub)
+	}
+	s.subs = nil
+	s.subsClosed = true
+}
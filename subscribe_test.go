@@ -0,0 +1,62 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	fw, err := New([]string{}, &MockIFirewall{}, MultiLogger{}, nil, ForgivableError{Duration: time.Minute, Count: 1, BanInMinute: 10})
+	require.NoError(t, err)
+	events := fw.Events()
+	other := fw.Events()
+
+	fw.LogIPError("1.2.3.4", "bad password")
+	fw.LogIPError("1.2.3.4", "bad password")
+	fw.UnbanIP("1.2.3.4", "false positive")
+
+	for _, ch := range []<-chan Event{events, other} {
+		actions := []string{}
+		for range 3 {
+			e := <-ch
+			assert.Equal(t, "1.2.3.4", e.IP)
+			actions = append(actions, e.Action)
+		}
+		assert.Equal(t, []string{"count error", "ban", "unban"}, actions)
+	}
+
+	fw.Unsubscribe(other)
+	_, ok := <-other
+	assert.False(t, ok)
+
+	require.NoError(t, fw.Close(context.Background()))
+	_, ok = <-events
+	assert.False(t, ok)
+	_, ok = <-fw.Events()
+	assert.False(t, ok)
+	assert.Zero(t, fw.EventsDropped())
+}
+
+func TestEvents_SlowSubscriber(t *testing.T) {
+	fw, err := New([]string{}, &MockIFirewall{}, MultiLogger{}, nil, testForgivable)
+	require.NoError(t, err)
+	events := fw.Events()
+
+	for i := range eventBuffer + 10 {
+		fw.BanIP(fmt.Sprintf("10.0.%d.%d", i/256, i%256), 10, "scanner")
+	}
+	require.NoError(t, fw.Close(context.Background()))
+
+	// the firewall does not wait for the subscriber
+	n := 0
+	for range events {
+		n++
+	}
+	assert.Equal(t, eventBuffer, n)
+	assert.Equal(t, uint64(10), fw.EventsDropped())
+}
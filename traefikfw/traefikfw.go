@@ -0,0 +1,359 @@
+// Package traefikfw is a Traefik middleware plugin enforcing the bans of a
+// firewalld without a forward-auth hop. It polls the bans from the signed
+// feed or the admin api, rejects banned clients with 403 and reports the
+// failed requests of the others to the ingest api.
+//
+// Traefik runs plugins in Yaegi, so the package only uses the standard
+// library.
+package traefikfw
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRefreshInterval = 10 * time.Second
+	requestTimeout         = 10 * time.Second
+	// reportQueueSize bounds the failures waiting to be reported, more are
+	// dropped while the ingest api is slow.
+	reportQueueSize = 1024
+)
+
+// Config of the plugin. Bans are read from FeedURL if set, verified with
+// FeedPublicKey, otherwise from AdminURL.
+type Config struct {
+	// FeedURL is the signed ban feed, e.g. "http://firewalld:8080/v1/feed".
+	FeedURL string `json:"feedURL,omitempty"`
+	// FeedPublicKey is the base64 ed25519 public key of the feed.
+	FeedPublicKey string `json:"feedPublicKey,omitempty"`
+	// AdminURL is the admin listener, e.g. "http://firewalld:8081".
+	AdminURL string `json:"adminURL,omitempty"`
+	// AdminToken is the bearer token of an operator on the admin api,
+	// required with AdminURL.
+	AdminToken string `json:"adminToken,omitempty"`
+	// IngestURL receives the failures as errors, e.g.
+	// "http://firewalld:8080". They are not reported if empty.
+	IngestURL string `json:"ingestURL,omitempty"`
+	// Token is the bearer token of a tenant on the ingest api.
+	Token string `json:"token,omitempty"`
+	// RefreshInterval of the bans, default "10s".
+	RefreshInterval string `json:"refreshInterval,omitempty"`
+	// Statuses counted as failures, default 401 and 403.
+	Statuses []int `json:"statuses,omitempty"`
+	// Reason of the errors, default "http <status>".
+	Reason string `json:"reason,omitempty"`
+	// IPHeader is the header with the client ip set by a trusted proxy in
+	// front of Traefik, e.g. "X-Real-Ip". The remote address is used if
+	// empty.
+	IPHeader string `json:"ipHeader,omitempty"`
+}
+
+// CreateConfig returns the default config.
+func CreateConfig() *Config {
+	return &Config{
+		RefreshInterval: defaultRefreshInterval.String(),
+		Statuses:        []int{http.StatusUnauthorized, http.StatusForbidden},
+	}
+}
+
+// Firewall is the middleware.
+type Firewall struct {
+	next   http.Handler
+	name   string
+	config *Config
+	pub    ed25519.PublicKey
+	client *http.Client
+
+	mu       sync.RWMutex
+	ips      map[string]bool
+	networks []*net.IPNet
+
+	reports chan errorReport
+}
+
+type errorReport struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+}
+
+// New creates the middleware and starts polling the bans until ctx is
+// done.
+func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	if config.FeedURL == "" && config.AdminURL == "" {
+		return nil, errors.New("feedURL or adminURL is required")
+	}
+	if config.FeedURL == "" && config.AdminToken == "" {
+		return nil, errors.New("adminToken is required with adminURL")
+	}
+	interval := defaultRefreshInterval
+	if config.RefreshInterval != "" {
+		d, err := time.ParseDuration(config.RefreshInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid refreshInterval %q", config.RefreshInterval)
+		}
+		interval = d
+	}
+
+	f := &Firewall{
+		next:    next,
+		name:    name,
+		config:  config,
+		client:  &http.Client{Timeout: requestTimeout},
+		ips:     map[string]bool{},
+		reports: make(chan errorReport, reportQueueSize),
+	}
+	if config.FeedURL != "" {
+		b, err := base64.StdEncoding.DecodeString(config.FeedPublicKey)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid feedPublicKey")
+		}
+		f.pub = ed25519.PublicKey(b)
+	}
+
+	go f.poll(ctx, interval)
+	if config.IngestURL != "" {
+		go f.report(ctx)
+	}
+	return f, nil
+}
+
+func (f *Firewall) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ip := f.clientIP(r)
+	if ip != nil && f.banned(ip) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	sw := &statusWriter{ResponseWriter: w}
+	f.next.ServeHTTP(sw, r)
+
+	if ip == nil || f.config.IngestURL == "" || !f.failure(sw.status) {
+		return
+	}
+	reason := f.config.Reason
+	if reason == "" {
+		reason = fmt.Sprintf("http %d", sw.status)
+	}
+	select {
+	case f.reports <- errorReport{IP: ip.String(), Reason: reason}:
+	default:
+		log.Printf("%s: report queue full, dropped the error of %s", f.name, ip)
+	}
+}
+
+func (f *Firewall) clientIP(r *http.Request) net.IP {
+	if f.config.IPHeader != "" {
+		v := r.Header.Get(f.config.IPHeader)
+		// the proxy in front appends the client to a list.
+		if i := strings.LastIndex(v, ","); i >= 0 {
+			v = v[i+1:]
+		}
+		return net.ParseIP(strings.TrimSpace(v))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func (f *Firewall) failure(status int) bool {
+	for _, s := range f.config.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Firewall) banned(ip net.IP) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.ips[ip.String()] {
+		return true
+	}
+	for _, n := range f.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// poll refreshes the bans every interval until ctx is done.
+func (f *Firewall) poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := f.refresh(ctx); err != nil {
+			log.Printf("%s: refresh bans failed: %v", f.name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ban is an entry of the feed or of the admin api.
+type ban struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// refresh replaces the bans, they are kept if the source is unavailable.
+func (f *Firewall) refresh(ctx context.Context) error {
+	var bans []ban
+	var err error
+	if f.config.FeedURL != "" {
+		bans, err = f.readFeed(ctx)
+	} else {
+		err = f.get(ctx, strings.TrimSuffix(f.config.AdminURL, "/")+"/v1/bans", f.config.AdminToken, &bans)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ips := map[string]bool{}
+	networks := []*net.IPNet{}
+	for _, b := range bans {
+		if !b.Until.IsZero() && b.Until.Before(now) {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(b.IP); err == nil {
+			networks = append(networks, n)
+			continue
+		}
+		if ip := net.ParseIP(b.IP); ip != nil {
+			ips[ip.String()] = true
+		}
+	}
+
+	f.mu.Lock()
+	f.ips = ips
+	f.networks = networks
+	f.mu.Unlock()
+	return nil
+}
+
+// signedFeed is the wire format of the feed, see package peer.
+type signedFeed struct {
+	Feed      json.RawMessage `json:"feed"`
+	Signature []byte          `json:"signature"`
+}
+
+func (f *Firewall) readFeed(ctx context.Context) ([]ban, error) {
+	sf := &signedFeed{}
+	if err := f.get(ctx, f.config.FeedURL, "", sf); err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(f.pub, sf.Feed, sf.Signature) {
+		return nil, errors.New("invalid feed signature")
+	}
+
+	feed := struct {
+		Bans []ban `json:"bans"`
+	}{}
+	if err := json.Unmarshal(sf.Feed, &feed); err != nil {
+		return nil, fmt.Errorf("decode feed failed: %w", err)
+	}
+	return feed.Bans, nil
+}
+
+// get decodes the json at url, with token as the bearer token if set.
+func (f *Firewall) get(ctx context.Context, url, token string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("code = %d, resp = %q", resp.StatusCode, b)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// report sends the queued failures to the ingest api until ctx is done.
+func (f *Firewall) report(ctx context.Context) {
+	url := strings.TrimSuffix(f.config.IngestURL, "/") + "/v1/error"
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-f.reports:
+			if err := f.post(ctx, url, &e); err != nil {
+				log.Printf("%s: report error of %s failed: %v", f.name, e.IP, err)
+			}
+		}
+	}
+}
+
+func (f *Firewall) post(ctx context.Context, url string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if f.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.config.Token)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("code = %d, resp = %q", resp.StatusCode, b)
+	}
+	return nil
+}
+
+// statusWriter records the status of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= http.StatusOK {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
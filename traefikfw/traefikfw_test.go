@@ -0,0 +1,123 @@
+package traefikfw
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirewall(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/bans", r.URL.Path)
+		assert.Equal(t, "Bearer admin-secret", r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode([]ban{
+			{IP: "1.2.3.4", Until: time.Now().Add(time.Hour)},
+			{IP: "1.2.4.0/24", Until: time.Now().Add(time.Hour)},
+			{IP: "1.2.5.5", Until: time.Now().Add(-time.Hour)},
+		})
+	}))
+	defer admin.Close()
+
+	reported := make(chan errorReport, 1)
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/error", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		e := errorReport{}
+		json.NewDecoder(r.Body).Decode(&e)
+		reported <- e
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ingest.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := CreateConfig()
+	c.AdminURL = admin.URL
+	c.AdminToken = "admin-secret"
+	c.IngestURL = ingest.URL
+	c.Token = "secret"
+	c.IPHeader = "X-Forwarded-For"
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	h, err := New(ctx, next, c, "fw")
+	require.NoError(t, err)
+	require.NoError(t, h.(*Firewall).refresh(ctx))
+
+	serve := func(ip, path string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("X-Forwarded-For", "10.0.0.1, "+ip)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, serve("1.2.3.4", "/"))
+	assert.Equal(t, http.StatusForbidden, serve("1.2.4.9", "/"))
+	assert.Equal(t, http.StatusOK, serve("1.2.5.5", "/"))
+
+	assert.Equal(t, http.StatusUnauthorized, serve("1.2.5.5", "/login"))
+	select {
+	case e := <-reported:
+		assert.Equal(t, errorReport{IP: "1.2.5.5", Reason: "http 401"}, e)
+	case <-time.After(time.Second):
+		t.Fatal("error not reported")
+	}
+}
+
+func TestFirewall_Feed(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	feed, _ := json.Marshal(map[string]any{
+		"issuer": "fw",
+		"bans":   []ban{{IP: "1.2.3.4", Until: time.Now().Add(time.Hour)}},
+	})
+	signature := ed25519.Sign(key, feed)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&signedFeed{Feed: feed, Signature: signature})
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := CreateConfig()
+	c.FeedURL = srv.URL
+	c.FeedPublicKey = base64.StdEncoding.EncodeToString(pub)
+	h, err := New(ctx, http.NotFoundHandler(), c, "fw")
+	require.NoError(t, err)
+	require.NoError(t, h.(*Firewall).refresh(ctx))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "1.2.3.4:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// a feed signed by another key is rejected
+	other, _, _ := ed25519.GenerateKey(nil)
+	c.FeedPublicKey = base64.StdEncoding.EncodeToString(other)
+	h, err = New(ctx, http.NotFoundHandler(), c, "fw")
+	require.NoError(t, err)
+	assert.EqualError(t, h.(*Firewall).refresh(ctx), "invalid feed signature")
+
+	_, err = New(ctx, http.NotFoundHandler(), CreateConfig(), "fw")
+	assert.Error(t, err)
+
+	c = CreateConfig()
+	c.AdminURL = "http://localhost:8081"
+	_, err = New(ctx, http.NotFoundHandler(), c, "fw")
+	assert.EqualError(t, err, "adminToken is required with adminURL")
+}
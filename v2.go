@@ -0,0 +1,94 @@
+package firewall
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// IFirewallV2 is a backend whose calls take a context, so slow router calls
+// can be observed and canceled, and which reports errors. Pass it to New
+// with V1.
+type IFirewallV2 interface {
+	// Name of the backend, used in log events.
+	Name() string
+	BanIP(ctx context.Context, ip string, dur time.Duration) error
+}
+
+// IUnbanFirewallV2 is implemented by v2 backends which can remove an ip
+// from the block list.
+type IUnbanFirewallV2 interface {
+	UnbanIP(ctx context.Context, ip string) error
+}
+
+// DefaultCallTimeout bounds a backend call made through V1.
+const DefaultCallTimeout = 30 * time.Second
+
+var (
+	_ IErrorFirewall    = (*V1Firewall)(nil)
+	_ IUnbanFirewall    = (*V1Firewall)(nil)
+	_ IExpiringFirewall = (*V1Firewall)(nil)
+	_ INetworkFirewall  = (*V1Firewall)(nil)
+)
+
+// V1Firewall adapts an IFirewallV2 to IErrorFirewall and IUnbanFirewall,
+// each call is bounded by a timeout.
+type V1Firewall struct {
+	fw      IFirewallV2
+	timeout time.Duration
+}
+
+// V1 adapts fw to the IFirewall interfaces, calls time out after timeout,
+// DefaultCallTimeout if not positive.
+func V1(fw IFirewallV2, timeout time.Duration) *V1Firewall {
+	if timeout <= 0 {
+		timeout = DefaultCallTimeout
+	}
+	return &V1Firewall{fw: fw, timeout: timeout}
+}
+
+// V2 returns the adapted backend.
+func (v *V1Firewall) V2() IFirewallV2 {
+	return v.fw
+}
+
+func (v *V1Firewall) Name() string {
+	return v.fw.Name()
+}
+
+// ExpiresBans returns whether the adapted backend expires bans.
+func (v *V1Firewall) ExpiresBans() bool {
+	e, ok := v.fw.(IExpiringFirewall)
+	return ok && e.ExpiresBans()
+}
+
+// BansNetworks returns whether the adapted backend accepts cidr bans.
+func (v *V1Firewall) BansNetworks() bool {
+	n, ok := v.fw.(INetworkFirewall)
+	return ok && n.BansNetworks()
+}
+
+func (v *V1Firewall) BanIP(ip string, timeoutInMinute int) {
+	if err := v.TryBanIP(ip, timeoutInMinute); err != nil {
+		log.Println(err)
+	}
+}
+
+func (v *V1Firewall) TryBanIP(ip string, timeoutInMinute int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+	return v.fw.BanIP(ctx, ip, time.Duration(timeoutInMinute)*time.Minute)
+}
+
+// UnbanIP unbans ip if the adapted backend implements IUnbanFirewallV2,
+// otherwise it does nothing and the ip is only released by the firewall.
+func (v *V1Firewall) UnbanIP(ip string) error {
+	f, ok := v.fw.(IUnbanFirewallV2)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+	return f.UnbanIP(ctx, ip)
+}
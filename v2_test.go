@@ -0,0 +1,67 @@
+package firewall
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockV2Firewall struct {
+	dur      time.Duration
+	deadline time.Time
+	unbanned []string
+}
+
+func (m *mockV2Firewall) Name() string {
+	return "v2"
+}
+
+func (m *mockV2Firewall) BanIP(ctx context.Context, ip string, dur time.Duration) error {
+	m.dur = dur
+	m.deadline, _ = ctx.Deadline()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type mockV2UnbanFirewall struct {
+	mockV2Firewall
+}
+
+func (m *mockV2UnbanFirewall) UnbanIP(ctx context.Context, ip string) error {
+	m.unbanned = append(m.unbanned, ip)
+	return nil
+}
+
+func (m *mockV2UnbanFirewall) ExpiresBans() bool {
+	return true
+}
+
+func TestV1(t *testing.T) {
+	v2 := &mockV2Firewall{}
+	fw := V1(v2, 10*time.Millisecond)
+
+	start := time.Now()
+	err := fw.TryBanIP("1.2.3.4", 3)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 3*time.Minute, v2.dur)
+	assert.WithinDuration(t, start.Add(10*time.Millisecond), v2.deadline, 5*time.Millisecond)
+
+	assert.Equal(t, "v2", fw.Name())
+	assert.False(t, fw.ExpiresBans())
+	// a backend without unban only forgets the ip
+	assert.NoError(t, fw.UnbanIP("1.2.3.4"))
+	assert.Same(t, v2, fw.V2())
+}
+
+func TestV1_Unban(t *testing.T) {
+	v2 := &mockV2UnbanFirewall{}
+	fw := V1(v2, 0)
+	require.Equal(t, DefaultCallTimeout, fw.timeout)
+
+	require.NoError(t, fw.UnbanIP("1.2.3.4"))
+	assert.Equal(t, []string{"1.2.3.4"}, v2.unbanned)
+	assert.True(t, fw.ExpiresBans())
+}
@@ -0,0 +1,159 @@
+package firewall
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// safetyValve stops enforcement when bans happen too fast, e.g. a caller
+// bug or a log parser mis-extracting ips. It is locked as a SharedValve is
+// accessed by the loops of many firewalls.
+type safetyValve struct {
+	maxPerMinute int
+	// shared is set for a SharedValve.
+	shared bool
+
+	mu sync.Mutex
+	// bans in the last minute
+	recent []time.Time
+	paused bool
+}
+
+// SharedValve is a safety valve shared by firewalls, e.g. the tenants of
+// the daemon, see WithSharedValve.
+type SharedValve struct {
+	v safetyValve
+}
+
+// NewSharedValve returns a valve pausing the firewalls sharing it when
+// more than n bans happen in a minute across them.
+func NewSharedValve(n int) *SharedValve {
+	return &SharedValve{v: safetyValve{maxPerMinute: n, shared: true}}
+}
+
+// check returns whether a ban at now is within the rate of v, and trips
+// v if not. It is called with v locked.
+func (v *safetyValve) check(now time.Time) (ok, tripped bool) {
+	if v.paused {
+		return false, false
+	}
+
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(v.recent) && !v.recent[i].After(cutoff) {
+		i++
+	}
+	v.recent = v.recent[i:]
+	if len(v.recent) < v.maxPerMinute {
+		return true, false
+	}
+
+	v.paused = true
+	v.recent = nil
+	return false, true
+}
+
+// allowValves returns whether b can be enforced by the valve of s and the
+// shared one. The ban is recorded in the valves only when both allow it. It
+// logs the alert when a valve trips, and "ban-paused" for bans suppressed.
+func (s *Firewall) allowValves(b *ban) bool {
+	valves := []*safetyValve{}
+	for _, v := range []*safetyValve{s.valve, s.sharedValve} {
+		if v != nil {
+			valves = append(valves, v)
+		}
+	}
+	if len(valves) == 0 {
+		return true
+	}
+
+	now := s.now()
+	for _, v := range valves {
+		v.mu.Lock()
+	}
+	var rejected *safetyValve
+	tripped := false
+	for _, v := range valves {
+		ok, t := v.check(now)
+		if !ok {
+			rejected, tripped = v, t
+			break
+		}
+	}
+	if rejected == nil {
+		for _, v := range valves {
+			v.recent = append(v.recent, now)
+		}
+	}
+	for _, v := range valves {
+		v.mu.Unlock()
+	}
+	if rejected == nil {
+		return true
+	}
+
+	if tripped {
+		scope := ""
+		if rejected.shared {
+			scope = " across firewalls"
+		}
+		s.log(&Event{
+			IP:            b.ip,
+			Reasons:       []string{fmt.Sprintf("more than %d bans in a minute%s, enforcement paused", rejected.maxPerMinute, scope)},
+			Action:        "safety-valve",
+			CorrelationID: b.correlationID,
+		})
+	}
+
+	s.logBanPaused(b, now)
+	return false
+}
+
+// isPaused returns whether v is set and tripped.
+func (v *safetyValve) isPaused() bool {
+	if v == nil {
+		return false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.paused
+}
+
+// resume resets v if set.
+func (v *safetyValve) resume() {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.paused = false
+}
+
+// Paused returns whether enforcement is paused by the safety valve, Pause
+// or the dead man's switch.
+func (s *Firewall) Paused() bool {
+	paused := false
+	s.do(func() {
+		paused = s.pausedBy != "" || s.valve.isPaused() || s.sharedValve.isPaused()
+	})
+	return paused
+}
+
+// Resume enforcement paused by the safety valve, Pause or the dead man's
+// switch, whose timeout restarts. A SharedValve is resumed for all the
+// firewalls sharing it. Bans suppressed while paused are not replayed.
+func (s *Firewall) Resume() {
+	s.do(func() {
+		if s.pausedBy == "" && !s.valve.isPaused() && !s.sharedValve.isPaused() {
+			return
+		}
+		s.valve.resume()
+		s.sharedValve.resume()
+		s.pausedBy = ""
+		s.lastHeartbeat = s.now()
+		s.log(&Event{
+			Action: "resume",
+		})
+	})
+}
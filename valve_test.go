@@ -0,0 +1,142 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafetyValve(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockILogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable, WithMaxBanRate(2))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(4)
+	fw.BanIP("192.168.1.1", 10, "r")
+	fw.BanIP("192.168.1.2", 10, "r")
+	fw.BanIP("192.168.1.3", 10, "r")
+	mockLogger.Wg.Wait()
+
+	assert.True(t, fw.Paused())
+	assert.Equal(t, []string{"192.168.1.1", "192.168.1.2"}, mockFW.BannedIPs)
+	actions := []string{}
+	for _, l := range mockLogger.Logs {
+		actions = append(actions, l.Action)
+	}
+	assert.Equal(t, []string{"ban", "ban", "safety-valve", "ban-paused"}, actions)
+
+	mockLogger.Wg.Add(2)
+	fw.Resume()
+	fw.BanIP("192.168.1.4", 10, "r")
+	mockLogger.Wg.Wait()
+
+	assert.False(t, fw.Paused())
+	assert.Equal(t, []string{"192.168.1.1", "192.168.1.2", "192.168.1.4"}, mockFW.BannedIPs)
+	assert.Equal(t, "resume", mockLogger.Logs[4].Action)
+	assert.Equal(t, "ban", mockLogger.Logs[5].Action)
+}
+
+func TestSafetyValve_CountsErrors(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockILogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable, WithMaxBanRate(1))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(3)
+	fw.BanIP("192.168.1.1", 10, "r")
+	fw.BanIP("192.168.1.2", 10, "r")
+	mockLogger.Wg.Wait()
+	require.True(t, fw.Paused())
+
+	// the suppressed ban does not mark the ip banned, its errors are still
+	// counted.
+	mockLogger.Wg.Add(3)
+	fw.LogIPError("192.168.1.3", "bad password")
+	fw.LogIPError("192.168.1.3", "bad password")
+	fw.LogIPError("192.168.1.3", "bad password")
+	mockLogger.Wg.Wait()
+
+	actions := []string{}
+	for _, l := range mockLogger.Logs[3:] {
+		actions = append(actions, l.Action)
+	}
+	assert.Equal(t, []string{"count error", "ban-paused", "ban-paused"}, actions)
+	assert.Equal(t, []string{"192.168.1.1"}, mockFW.BannedIPs)
+}
+
+func TestSharedValve(t *testing.T) {
+	v := NewSharedValve(1)
+	mockFW1 := &MockIFirewall{}
+	mockLogger1 := &MockILogger{}
+	fw1, err := New([]string{}, mockFW1, mockLogger1, nil, testForgivable, WithSharedValve(v))
+	require.NoError(t, err)
+	mockFW2 := &MockIFirewall{}
+	mockLogger2 := &MockILogger{}
+	fw2, err := New([]string{}, mockFW2, mockLogger2, nil, testForgivable, WithSharedValve(v))
+	require.NoError(t, err)
+
+	mockLogger1.Wg.Add(1)
+	fw1.BanIP("192.168.1.1", 10, "r")
+	mockLogger1.Wg.Wait()
+	mockLogger2.Wg.Add(2)
+	fw2.BanIP("192.168.1.2", 10, "r")
+	mockLogger2.Wg.Wait()
+
+	// the second ban trips the valve of both firewalls.
+	assert.True(t, fw1.Paused())
+	assert.True(t, fw2.Paused())
+	assert.Equal(t, []string{"192.168.1.1"}, mockFW1.BannedIPs)
+	assert.Empty(t, mockFW2.BannedIPs)
+	assert.Equal(t, "safety-valve", mockLogger2.Logs[0].Action)
+	assert.Equal(t, "ban-paused", mockLogger2.Logs[1].Action)
+
+	mockLogger2.Wg.Add(1)
+	fw2.Resume()
+	mockLogger2.Wg.Wait()
+	assert.False(t, fw1.Paused())
+	assert.False(t, fw2.Paused())
+}
+
+func TestSharedValve_RecordsOnlyAllowedBans(t *testing.T) {
+	v := NewSharedValve(1)
+	otherLogger := &MockILogger{}
+	other, err := New([]string{}, &MockIFirewall{}, otherLogger, nil, testForgivable, WithSharedValve(v))
+	require.NoError(t, err)
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockILogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable, WithMaxBanRate(2), WithSharedValve(v))
+	require.NoError(t, err)
+
+	otherLogger.Wg.Add(1)
+	other.BanIP("192.168.1.1", 10, "r")
+	otherLogger.Wg.Wait()
+
+	// the shared valve trips, the bans it suppresses are not counted by the
+	// valve of fw.
+	mockLogger.Wg.Add(4)
+	fw.BanIP("192.168.1.2", 10, "r")
+	fw.BanIP("192.168.1.3", 10, "r")
+	fw.BanIP("192.168.1.4", 10, "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "safety-valve", mockLogger.Logs[0].Action)
+	assert.False(t, fw.valve.isPaused())
+	assert.Empty(t, fw.valve.recent)
+
+	mockLogger.Wg.Add(2)
+	fw.Resume()
+	fw.BanIP("192.168.1.5", 10, "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, []string{"192.168.1.5"}, mockFW.BannedIPs)
+	assert.Len(t, fw.valve.recent, 1)
+}
+
+func TestMaxBanRate_Invalid(t *testing.T) {
+	_, err := New([]string{}, &MockIFirewall{}, &MockILogger{}, nil, testForgivable, WithMaxBanRate(0))
+	assert.Error(t, err)
+	_, err = New([]string{}, &MockIFirewall{}, &MockILogger{}, nil, testForgivable, WithSharedValve(nil))
+	assert.Error(t, err)
+	_, err = New([]string{}, &MockIFirewall{}, &MockILogger{}, nil, testForgivable, WithSharedValve(NewSharedValve(-1)))
+	assert.Error(t, err)
+}
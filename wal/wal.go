@@ -0,0 +1,340 @@
+// Package wal keeps a write-ahead log of bans sent to a backend. A ban is
+// appended and synced before calling the backend and acknowledged after the
+// backend accepted it, bans not acknowledged are replayed on Open and by
+// Replay, so a decided ban eventually reaches the backend even if the
+// process crashes in between.
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/charleshuang3/firewall"
+)
+
+var (
+	_ firewall.IActionFirewall  = (*Backend)(nil)
+	_ firewall.IRoutingFirewall = (*Backend)(nil)
+	_ firewall.IUnbanFirewall   = (*Backend)(nil)
+	_ firewall.INetworkFirewall = (*Backend)(nil)
+)
+
+// compactAfter is the number of records appended before the log is
+// rewritten with the pending bans only.
+const compactAfter = 1024
+
+const (
+	opBan   = "ban"
+	opAck   = "ack"
+	opUnban = "unban"
+)
+
+type record struct {
+	Op string `json:"op"`
+	ID uint64 `json:"id,omitempty"`
+	IP string `json:"ip,omitempty"`
+	// Until is when the ban expires, the remaining time is used on replay.
+	Until time.Time `json:"until,omitzero"`
+	// Reasons route the ban if the backend is a firewall.IRoutingFirewall.
+	Reasons []string `json:"reasons,omitempty"`
+	// Action is passed if the backend is a firewall.IActionFirewall.
+	Action firewall.BanAction `json:"action,omitempty"`
+}
+
+// Backend wraps a backend with the write-ahead log.
+type Backend struct {
+	fw   firewall.IErrorFirewall
+	path string
+
+	mu      sync.Mutex
+	f       *os.File
+	nextID  uint64
+	pending map[uint64]*record
+	// records in the file
+	records int
+}
+
+// Open opens the log at path, creating it if not exists, and replays the
+// pending bans to fw.
+func Open(path string, fw firewall.IErrorFirewall) (*Backend, error) {
+	b := &Backend{
+		fw:      fw,
+		path:    path,
+		nextID:  1,
+		pending: map[uint64]*record{},
+	}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	if err := b.compact(); err != nil {
+		return nil, err
+	}
+
+	if err := b.Replay(); err != nil {
+		log.Printf("replay wal failed: %v", err)
+	}
+	return b, nil
+}
+
+// load reads the records, a partial last line from a crash is ignored.
+func (b *Backend) load() error {
+	f, err := os.Open(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open wal failed: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		r := &record{}
+		if err := json.Unmarshal(sc.Bytes(), r); err != nil {
+			log.Printf("skip corrupted wal record: %v", err)
+			continue
+		}
+		b.apply(r)
+	}
+	return sc.Err()
+}
+
+func (b *Backend) apply(r *record) {
+	if r.ID >= b.nextID {
+		b.nextID = r.ID + 1
+	}
+	switch r.Op {
+	case opBan:
+		b.pending[r.ID] = r
+	case opAck:
+		delete(b.pending, r.ID)
+	case opUnban:
+		for id, p := range b.pending {
+			if p.IP == r.IP {
+				delete(b.pending, id)
+			}
+		}
+	}
+}
+
+// compact rewrites the log with the pending bans only.
+func (b *Backend) compact() error {
+	tmp := b.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("compact wal failed: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range b.pending {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return fmt.Errorf("compact wal failed: %w", err)
+		}
+	}
+	if err := w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("compact wal failed: %w", err)
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, b.path); err != nil {
+		return fmt.Errorf("compact wal failed: %w", err)
+	}
+
+	if b.f != nil {
+		b.f.Close()
+	}
+	b.f, err = os.OpenFile(b.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open wal failed: %w", err)
+	}
+	b.records = len(b.pending)
+	return nil
+}
+
+// append writes records, synced if sync.
+func (b *Backend) append(sync bool, records ...*record) error {
+	buf := []byte{}
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+		b.apply(r)
+	}
+
+	if _, err := b.f.Write(buf); err != nil {
+		return fmt.Errorf("append wal failed: %w", err)
+	}
+	b.records += len(records)
+	if sync {
+		if err := b.f.Sync(); err != nil {
+			return fmt.Errorf("sync wal failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// ack records the ban reached the backend. Acks are not synced, a lost ack
+// only replays a ban again.
+func (b *Backend) ack(id uint64) {
+	if err := b.append(false, &record{Op: opAck, ID: id}); err != nil {
+		log.Printf("%v", err)
+	}
+	if b.records >= compactAfter {
+		if err := b.compact(); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
+
+func (b *Backend) Name() string {
+	return b.fw.Name()
+}
+
+func (b *Backend) ExpiresBans() bool {
+	e, ok := b.fw.(firewall.IExpiringFirewall)
+	return ok && e.ExpiresBans()
+}
+
+func (b *Backend) BansNetworks() bool {
+	n, ok := b.fw.(firewall.INetworkFirewall)
+	return ok && n.BansNetworks()
+}
+
+func (b *Backend) BanIP(ip string, timeoutInMinute int) {
+	if err := b.TryBanIP(ip, timeoutInMinute); err != nil {
+		log.Printf("ban %s failed: %v", ip, err)
+	}
+}
+
+// TryBanIP logs the ban before sending it to the backend. A failed ban stays
+// pending and is sent again by Replay.
+func (b *Backend) TryBanIP(ip string, timeoutInMinute int) error {
+	return b.RouteBanIP(ip, timeoutInMinute, nil)
+}
+
+// RouteBanIP is TryBanIP with the reasons of the ban, they are logged and
+// passed to the backend if it routes bans.
+func (b *Backend) RouteBanIP(ip string, timeoutInMinute int, reasons []string) error {
+	return b.ActionBanIP(ip, timeoutInMinute, firewall.BanActionBlock, reasons)
+}
+
+// ActionBanIP is RouteBanIP with the action of the ban, it is logged and
+// passed to the backend if it enforces actions.
+func (b *Backend) ActionBanIP(ip string, timeoutInMinute int, action firewall.BanAction, reasons []string) error {
+	b.mu.Lock()
+	r := &record{
+		Op:      opBan,
+		ID:      b.nextID,
+		IP:      ip,
+		Until:   time.Now().Add(time.Duration(timeoutInMinute) * time.Minute),
+		Reasons: reasons,
+		Action:  action,
+	}
+	err := b.append(true, r)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// the backend is called without the lock, so a slow backend does not
+	// hold up other bans. Replay may send the pending ban again meanwhile.
+	if err := b.send(r, timeoutInMinute); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ack(r.ID)
+	return nil
+}
+
+func (b *Backend) send(r *record, timeoutInMinute int) error {
+	return firewall.ForwardBan(b.fw, r.IP, timeoutInMinute, r.Action, r.Reasons)
+}
+
+// UnbanIP cancels the pending bans of ip and unbans it on the backend if it
+// implements IUnbanFirewall, otherwise the ip is only released by the
+// firewall.
+func (b *Backend) UnbanIP(ip string) error {
+	b.mu.Lock()
+	err := b.append(true, &record{Op: opUnban, IP: ip})
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, ok := b.fw.(firewall.IUnbanFirewall)
+	if !ok {
+		return nil
+	}
+	return f.UnbanIP(ip)
+}
+
+// Pending returns the number of bans not acknowledged by the backend.
+func (b *Backend) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// Replay sends the pending bans to the backend with their remaining time,
+// expired ones are dropped.
+func (b *Backend) Replay() error {
+	b.mu.Lock()
+	pending := maps.Clone(b.pending)
+	b.mu.Unlock()
+
+	now := time.Now()
+	var errs []error
+	for _, id := range slices.Sorted(maps.Keys(pending)) {
+		r := pending[id]
+		remaining := r.Until.Sub(now)
+		if remaining > 0 && b.isPending(id) {
+			// the backend is called without the lock, as in ActionBanIP
+			minutes := int(math.Ceil(remaining.Minutes()))
+			if err := b.send(r, minutes); err != nil {
+				errs = append(errs, fmt.Errorf("replay ban %s failed: %w", r.IP, err))
+				continue
+			}
+		}
+
+		b.mu.Lock()
+		// an unban may have cancelled it meanwhile
+		if _, ok := b.pending[id]; ok {
+			b.ack(id)
+		}
+		b.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// isPending returns whether the ban id is not acknowledged or cancelled.
+func (b *Backend) isPending(id uint64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.pending[id]
+	return ok
+}
+
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.f.Close()
+}
@@ -0,0 +1,211 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+)
+
+type mockBackend struct {
+	err      error
+	banned   []string
+	minutes  []int
+	unbanned []string
+}
+
+func (m *mockBackend) Name() string {
+	return "mock"
+}
+
+func (m *mockBackend) BanIP(ip string, timeoutInMinute int) {
+	m.TryBanIP(ip, timeoutInMinute)
+}
+
+func (m *mockBackend) TryBanIP(ip string, timeoutInMinute int) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.banned = append(m.banned, ip)
+	m.minutes = append(m.minutes, timeoutInMinute)
+	return nil
+}
+
+func (m *mockBackend) UnbanIP(ip string) error {
+	m.unbanned = append(m.unbanned, ip)
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+
+	down := &mockBackend{err: errors.New("down")}
+	b, err := Open(path, down)
+	require.NoError(t, err)
+	assert.Error(t, b.TryBanIP("10.0.0.1", 60))
+	assert.Error(t, b.TryBanIP("10.0.0.2", 60))
+	assert.Error(t, b.TryBanIP("10.0.0.3", 60))
+	require.NoError(t, b.UnbanIP("10.0.0.3"))
+	assert.Equal(t, 2, b.Pending())
+	// crash without close, with a partial record at the end
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	f.WriteString(`{"op":"ban","id":9,"ip":"10.`)
+	f.Close()
+
+	up := &mockBackend{}
+	b, err = Open(path, up)
+	require.NoError(t, err)
+	defer b.Close()
+
+	assert.ElementsMatch(t, []string{"10.0.0.1", "10.0.0.2"}, up.banned)
+	assert.Equal(t, []int{60, 60}, up.minutes)
+	assert.Equal(t, 0, b.Pending())
+
+	// acked bans are not replayed again
+	require.NoError(t, b.TryBanIP("10.0.0.4", 60))
+	require.NoError(t, b.Replay())
+	assert.Len(t, up.banned, 3)
+}
+
+// banOnlyBackend is a backend without unban.
+type banOnlyBackend struct {
+	m *mockBackend
+}
+
+func (b *banOnlyBackend) Name() string { return b.m.Name() }
+
+func (b *banOnlyBackend) BanIP(ip string, timeoutInMinute int) { b.m.BanIP(ip, timeoutInMinute) }
+
+func (b *banOnlyBackend) TryBanIP(ip string, timeoutInMinute int) error {
+	return b.m.TryBanIP(ip, timeoutInMinute)
+}
+
+func TestUnban_WithoutUnbanBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	b, err := Open(path, &banOnlyBackend{m: &mockBackend{err: errors.New("down")}})
+	require.NoError(t, err)
+	defer b.Close()
+
+	assert.Error(t, b.TryBanIP("10.0.0.1", 60))
+	// the pending ban is still canceled
+	require.NoError(t, b.UnbanIP("10.0.0.1"))
+	assert.Equal(t, 0, b.Pending())
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	b, err := Open(path, &mockBackend{})
+	require.NoError(t, err)
+	defer b.Close()
+
+	for i := 0; i < compactAfter; i++ {
+		require.NoError(t, b.TryBanIP("10.0.0.1", 60))
+	}
+
+	st, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Less(t, st.Size(), int64(compactAfter*10))
+}
+
+func TestReplay_Routed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+
+	down := &mockBackend{err: errors.New("down")}
+	b, err := Open(path, firewall.NewRouter(down))
+	require.NoError(t, err)
+	assert.Error(t, b.RouteBanIP("10.0.0.1", 60, []string{"ssh"}))
+	b.Close()
+
+	// the reasons of the pending ban route it on replay
+	edge := &mockBackend{}
+	other := &mockBackend{}
+	_, err = Open(path, firewall.NewRouter(other, firewall.Route{Prefix: "ssh", Backend: edge}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, edge.banned)
+	assert.Empty(t, other.banned)
+}
+
+func TestReplay_Action(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+
+	down := &mockBackend{err: errors.New("down")}
+	b, err := Open(path, firewall.NewActionFirewall(down, nil))
+	require.NoError(t, err)
+	assert.Error(t, b.ActionBanIP("10.0.0.1", 60, firewall.BanActionTarpit, []string{"scanner"}))
+	b.Close()
+
+	// the action of the pending ban is enforced on replay
+	block := &mockBackend{}
+	tarpit := &mockBackend{}
+	_, err = Open(path, firewall.NewActionFirewall(block, map[firewall.BanAction]firewall.IFirewall{firewall.BanActionTarpit: tarpit}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, tarpit.banned)
+	assert.Empty(t, block.banned)
+}
+
+// slowBackend blocks the bans of block until release is closed.
+type slowBackend struct {
+	mockBackend
+	block   string
+	release chan struct{}
+	// waiting counts the bans blocked.
+	waiting atomic.Int32
+}
+
+func (m *slowBackend) TryBanIP(ip string, timeoutInMinute int) error {
+	if ip == m.block {
+		m.waiting.Add(1)
+		<-m.release
+	}
+	return nil
+}
+
+func TestBan_SlowBackend(t *testing.T) {
+	fw := &slowBackend{block: "10.0.0.1", release: make(chan struct{})}
+	b, err := Open(filepath.Join(t.TempDir(), "wal"), fw)
+	require.NoError(t, err)
+	defer b.Close()
+
+	done := make(chan error)
+	go func() { done <- b.TryBanIP("10.0.0.1", 60) }()
+	require.Eventually(t, func() bool { return b.Pending() == 1 }, time.Second, time.Millisecond)
+
+	// a slow ban does not hold up the others
+	require.NoError(t, b.TryBanIP("10.0.0.2", 60))
+	assert.Equal(t, 1, b.Pending())
+
+	close(fw.release)
+	require.NoError(t, <-done)
+	assert.Equal(t, 0, b.Pending())
+}
+
+func TestReplay_SlowBackend(t *testing.T) {
+	fw := &slowBackend{block: "10.0.0.1", release: make(chan struct{})}
+	b, err := Open(filepath.Join(t.TempDir(), "wal"), fw)
+	require.NoError(t, err)
+	defer b.Close()
+
+	done := make(chan error, 2)
+	go func() { done <- b.TryBanIP("10.0.0.1", 60) }()
+	require.Eventually(t, func() bool { return fw.waiting.Load() == 1 }, time.Second, time.Millisecond)
+	go func() { done <- b.Replay() }()
+	require.Eventually(t, func() bool { return fw.waiting.Load() == 2 }, time.Second, time.Millisecond)
+
+	// a slow replay does not hold up bans and unbans
+	require.NoError(t, b.TryBanIP("10.0.0.2", 60))
+	require.NoError(t, b.UnbanIP("10.0.0.2"))
+	assert.Equal(t, 1, b.Pending())
+
+	close(fw.release)
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+	assert.Equal(t, 0, b.Pending())
+}
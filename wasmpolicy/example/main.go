@@ -0,0 +1,68 @@
+//go:build wasip1
+
+// Command example is a detection rule for wasmpolicy. It bans sql injection
+// attempts immediately and ignores errors of health checks. Build it as a
+// reactor module:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o rule.wasm ./wasmpolicy/example
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unsafe"
+)
+
+type input struct {
+	IP       string `json:"ip"`
+	Reason   string `json:"reason"`
+	Time     int64  `json:"time"`
+	Offenses int    `json:"offenses"`
+	Country  string `json:"country"`
+	ASN      uint   `json:"asn"`
+}
+
+type output struct {
+	Verdict string `json:"verdict"`
+	Minutes int    `json:"minutes,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// in and out are kept in globals, so they are not collected while the host
+// reads or writes them.
+var in, out []byte
+
+//go:wasmimport firewall log
+func hostLog(ptr, size uint32)
+
+func logf(s string) {
+	b := []byte(s)
+	hostLog(uint32(uintptr(unsafe.Pointer(&b[0]))), uint32(len(b)))
+}
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+	in = make([]byte, size+1)
+	return uint32(uintptr(unsafe.Pointer(&in[0])))
+}
+
+//go:wasmexport decide
+func decide(ptr, size uint32) uint64 {
+	req := &input{}
+	resp := &output{Verdict: "count"}
+	if err := json.Unmarshal(in[:size], req); err != nil {
+		logf("invalid input: " + err.Error())
+	} else {
+		switch {
+		case strings.Contains(strings.ToLower(req.Reason), "sql injection"):
+			resp = &output{Verdict: "ban", Minutes: 24 * 60, Reason: "example: sql injection"}
+		case strings.HasPrefix(req.Reason, "healthcheck"):
+			resp.Verdict = "ignore"
+		}
+	}
+
+	out, _ = json.Marshal(resp)
+	return uint64(uintptr(unsafe.Pointer(&out[0])))<<32 | uint64(len(out))
+}
+
+func main() {}
@@ -0,0 +1,200 @@
+// Package wasmpolicy runs detection rules compiled to WebAssembly as a
+// firewall.IPolicy, so custom rules can be deployed without recompiling the
+// daemon.
+//
+// A rule module exports its memory and
+//
+//	alloc(size u32) u32           returns a buffer of size bytes
+//	decide(ptr u32, len u32) u64  decides the input in the buffer
+//
+// The input is json {"ip", "reason", "target", "time" (unix seconds),
+// "offenses", "country", "asn", "bogon"}. decide returns the json output, packed as
+// ptr<<32 | len, {"verdict": "count" | "ignore" | "ban", "minutes",
+// "reason", "action": "block" | "rate-limit" | "tarpit" | "tag"}. The host module "firewall" provides log(ptr u32, len u32) to
+// print a message. WASI is available, _initialize of reactor modules is
+// called on load. See example/ for a rule written in Go.
+package wasmpolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/charleshuang3/firewall"
+)
+
+var _ firewall.IPolicy = (*Policy)(nil)
+
+// decideTimeout bounds a decide call, it runs in the firewall loop. A module
+// exceeding it is closed and its later calls fail.
+const decideTimeout = 100 * time.Millisecond
+
+type input struct {
+	IP       string `json:"ip"`
+	Reason   string `json:"reason"`
+	Target   string `json:"target,omitempty"`
+	Time     int64  `json:"time"`
+	Offenses int    `json:"offenses"`
+	Country  string `json:"country,omitempty"`
+	ASN      uint   `json:"asn,omitempty"`
+	Bogon    bool   `json:"bogon,omitempty"`
+}
+
+type output struct {
+	Verdict string `json:"verdict"`
+	Minutes int    `json:"minutes,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Action  string `json:"action,omitempty"`
+}
+
+// Policy is a loaded rule module. Calls are serialized, a module instance
+// is single threaded.
+type Policy struct {
+	name string
+
+	mu      sync.Mutex
+	runtime wazero.Runtime
+	mod     api.Module
+	alloc   api.Function
+	decide  api.Function
+}
+
+// Open loads the rule module at path.
+func Open(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(context.Background(), filepath.Base(path), b)
+}
+
+// Load compiles and instantiates the rule module wasm.
+func Load(ctx context.Context, name string, wasm []byte) (*Policy, error) {
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	p := &Policy{name: name, runtime: r}
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	_, err := r.NewHostModuleBuilder("firewall").
+		NewFunctionBuilder().WithFunc(p.log).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+
+	cfg := wazero.NewModuleConfig().
+		WithName(name).
+		WithStartFunctions("_initialize").
+		WithStdout(os.Stdout).
+		WithStderr(os.Stderr)
+	mod, err := r.InstantiateWithConfig(ctx, wasm, cfg)
+	if err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("instantiate %s failed: %w", name, err)
+	}
+	p.mod = mod
+	p.alloc = mod.ExportedFunction("alloc")
+	p.decide = mod.ExportedFunction("decide")
+	if p.alloc == nil || p.decide == nil || mod.Memory() == nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("%s should export memory, alloc and decide", name)
+	}
+	return p, nil
+}
+
+func (p *Policy) log(_ context.Context, m api.Module, ptr, size uint32) {
+	if b, ok := m.Memory().Read(ptr, size); ok {
+		log.Printf("%s: %s", p.name, b)
+	}
+}
+
+// Decide passes in to the decide function of the module.
+func (p *Policy) Decide(in *firewall.PolicyInput) (firewall.Decision, error) {
+	req := &input{
+		IP:       in.IP,
+		Reason:   in.Reason,
+		Target:   in.Target,
+		Time:     in.Time.Unix(),
+		Offenses: in.Offenses,
+	}
+	if in.Geo != nil {
+		req.Country = in.Geo.Country
+		req.ASN = in.Geo.AutonomousSystemNumber
+		req.Bogon = in.Geo.Bogon
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return firewall.Decision{}, err
+	}
+
+	out, err := p.call(b)
+	if err != nil {
+		return firewall.Decision{}, fmt.Errorf("%s: %w", p.name, err)
+	}
+
+	resp := &output{}
+	if err := json.Unmarshal(out, resp); err != nil {
+		return firewall.Decision{}, fmt.Errorf("%s: invalid output: %w", p.name, err)
+	}
+	d := firewall.Decision{Minutes: resp.Minutes, Reason: resp.Reason}
+	switch resp.Verdict {
+	case "", "count":
+		d.Verdict = firewall.VerdictCount
+	case "ignore":
+		d.Verdict = firewall.VerdictIgnore
+	case "ban":
+		d.Verdict = firewall.VerdictBan
+	default:
+		return firewall.Decision{}, fmt.Errorf("%s: unknown verdict %q", p.name, resp.Verdict)
+	}
+	if d.BanAction, err = firewall.ParseBanAction(resp.Action); err != nil {
+		return firewall.Decision{}, fmt.Errorf("%s: %w", p.name, err)
+	}
+	return d, nil
+}
+
+func (p *Policy) call(in []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), decideTimeout)
+	defer cancel()
+
+	res, err := p.alloc.Call(ctx, uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("alloc failed: %w", err)
+	}
+	ptr := uint32(res[0])
+	if !p.mod.Memory().Write(ptr, in) {
+		return nil, errors.New("alloc returned an invalid buffer")
+	}
+
+	res, err = p.decide.Call(ctx, uint64(ptr), uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("decide failed: %w", err)
+	}
+	out, ok := p.mod.Memory().Read(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return nil, errors.New("decide returned an invalid buffer")
+	}
+	// out is a view of the module memory, it is changed by the next call.
+	return append([]byte{}, out...), nil
+}
+
+// Close releases the module.
+func (p *Policy) Close() error {
+	return p.runtime.Close(context.Background())
+}
@@ -0,0 +1,66 @@
+package wasmpolicy
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/charleshuang3/firewall"
+	"github.com/charleshuang3/firewall/ipgeo"
+)
+
+// buildExample compiles example/ to wasm.
+func buildExample(t *testing.T) string {
+	if testing.Short() {
+		t.Skip("building the example rule is slow")
+	}
+	out := filepath.Join(t.TempDir(), "rule.wasm")
+	cmd := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "build", "-buildmode=c-shared", "-o", out, "./example")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	b, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(b))
+	return out
+}
+
+func TestPolicy(t *testing.T) {
+	p, err := Open(buildExample(t))
+	require.NoError(t, err)
+	defer p.Close()
+
+	tests := []struct {
+		reason string
+		want   firewall.Decision
+	}{
+		{"invalid password", firewall.Decision{Verdict: firewall.VerdictCount}},
+		{"SQL injection in /login", firewall.Decision{Verdict: firewall.VerdictBan, Minutes: 1440, Reason: "example: sql injection"}},
+		{"healthcheck 502", firewall.Decision{Verdict: firewall.VerdictIgnore}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			got, err := p.Decide(&firewall.PolicyInput{
+				IP:     "1.2.3.4",
+				Reason: tt.reason,
+				Time:   time.Now(),
+				Geo:    &ipgeo.IPGeo{Country: "NL", AutonomousSystemNumber: 64496},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLoad_MissingExports(t *testing.T) {
+	// an empty module.
+	_, err := Load(context.Background(), "empty", []byte("\x00asm\x01\x00\x00\x00"))
+	assert.ErrorContains(t, err, "should export memory, alloc and decide")
+
+	_, err = Load(context.Background(), "garbage", []byte("garbage"))
+	assert.Error(t, err)
+}
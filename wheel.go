@@ -0,0 +1,130 @@
+package firewall
+
+import (
+	"time"
+)
+
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+	// wheelSpan is the number of ticks the wheel covers, later deadlines
+	// are parked in the last level until they come in range.
+	wheelSpan = 1 << (wheelBits * wheelLevels)
+)
+
+// timingWheel is a hierarchical timing wheel of 1s ticks. Level 0 slots are
+// a tick, level n slots are 64^n ticks; entries cascade to lower levels as
+// time advances. Add, remove and expire are O(1) per entry. It is only
+// accessed in the loop goroutine.
+type timingWheel struct {
+	tick time.Duration
+	// current is the last tick advanced to.
+	current int64
+	slots   [wheelLevels][wheelSlots]map[string]struct{}
+	entries map[string]*wheelEntry
+}
+
+type wheelEntry struct {
+	deadline int64
+	level    int
+	slot     int
+}
+
+func newTimingWheel(now time.Time) *timingWheel {
+	w := &timingWheel{
+		tick:    time.Second,
+		entries: map[string]*wheelEntry{},
+	}
+	w.current = w.toTick(now)
+	return w
+}
+
+// toTick rounds t up, so entries never fire early.
+func (w *timingWheel) toTick(t time.Time) int64 {
+	n := t.UnixNano()
+	d := int64(w.tick)
+	return (n + d - 1) / d
+}
+
+// add schedules key at t, replacing the previous schedule of key.
+func (w *timingWheel) add(key string, t time.Time) {
+	w.remove(key)
+	e := &wheelEntry{deadline: w.toTick(t)}
+	w.entries[key] = e
+	w.place(key, e)
+}
+
+func (w *timingWheel) place(key string, e *wheelEntry) {
+	delta := e.deadline - w.current
+	switch {
+	case delta <= 0:
+		// past due, fire on the next tick
+		e.level, e.slot = 0, int((w.current+1)&wheelMask)
+	case delta >= wheelSpan:
+		e.level = wheelLevels - 1
+		e.slot = int(((w.current + wheelSpan - 1) >> (wheelBits * e.level)) & wheelMask)
+	default:
+		level := 0
+		for delta >= 1<<(wheelBits*(level+1)) {
+			level++
+		}
+		e.level = level
+		e.slot = int((e.deadline >> (wheelBits * level)) & wheelMask)
+	}
+
+	s := w.slots[e.level][e.slot]
+	if s == nil {
+		s = map[string]struct{}{}
+		w.slots[e.level][e.slot] = s
+	}
+	s[key] = struct{}{}
+}
+
+func (w *timingWheel) remove(key string) {
+	e, ok := w.entries[key]
+	if !ok {
+		return
+	}
+	delete(w.slots[e.level][e.slot], key)
+	delete(w.entries, key)
+}
+
+func (w *timingWheel) len() int {
+	return len(w.entries)
+}
+
+// advance moves the wheel to now and returns the keys expired.
+func (w *timingWheel) advance(now time.Time) []string {
+	target := now.UnixNano() / int64(w.tick)
+	var expired []string
+	for w.current < target {
+		w.current++
+
+		// cascade the higher level slots reaching their range
+		for level := 1; level < wheelLevels; level++ {
+			if w.current&(1<<(wheelBits*level)-1) != 0 {
+				break
+			}
+			slot := int((w.current >> (wheelBits * level)) & wheelMask)
+			s := w.slots[level][slot]
+			w.slots[level][slot] = nil
+			for key := range s {
+				w.place(key, w.entries[key])
+			}
+		}
+
+		slot := int(w.current & wheelMask)
+		s := w.slots[0][slot]
+		if len(s) == 0 {
+			continue
+		}
+		w.slots[0][slot] = nil
+		for key := range s {
+			delete(w.entries, key)
+			expired = append(expired, key)
+		}
+	}
+	return expired
+}
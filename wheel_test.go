@@ -0,0 +1,88 @@
+package firewall
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimingWheel(t *testing.T) {
+	start := time.Unix(1000, 0)
+	w := newTimingWheel(start)
+
+	w.add("a", start.Add(3*time.Second))
+	w.add("b", start.Add(100*time.Second))
+	w.add("c", start.Add(5000*time.Second))
+	w.add("d", start.Add(3*time.Second))
+	w.add("d", start.Add(200*time.Second))
+	w.add("e", start.Add(10*time.Second))
+	w.remove("e")
+	w.add("f", start.Add(-time.Second))
+	assert.Equal(t, 5, w.len())
+
+	assert.Equal(t, []string{"f"}, w.advance(start.Add(time.Second)))
+	assert.Empty(t, w.advance(start.Add(2*time.Second)))
+	assert.Equal(t, []string{"a"}, w.advance(start.Add(3*time.Second)))
+	assert.Empty(t, w.advance(start.Add(99*time.Second)))
+	assert.Equal(t, []string{"b"}, w.advance(start.Add(150*time.Second)))
+	assert.Equal(t, []string{"d"}, w.advance(start.Add(4999*time.Second)))
+	assert.Equal(t, []string{"c"}, w.advance(start.Add(5000*time.Second)))
+	assert.Zero(t, w.len())
+}
+
+func TestTimingWheel_Deadlines(t *testing.T) {
+	start := time.Unix(1234567, 0)
+	w := newTimingWheel(start)
+
+	// deadlines across all levels and beyond the span
+	offsets := []int64{1, 63, 64, 65, 4095, 4096, 4097, 262143, 262144, wheelSpan - 1, wheelSpan, 3 * wheelSpan}
+	for _, o := range offsets {
+		w.add(fmt.Sprint(o), start.Add(time.Duration(o)*time.Second))
+	}
+
+	for _, o := range offsets {
+		at := start.Add(time.Duration(o) * time.Second)
+		assert.Empty(t, w.advance(at.Add(-time.Second)), "before %d", o)
+		assert.Equal(t, []string{fmt.Sprint(o)}, w.advance(at), "at %d", o)
+	}
+}
+
+func BenchmarkTimingWheel(b *testing.B) {
+	const n = 100_000
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("10.%d.%d.%d", i>>16, (i>>8)&0xff, i&0xff)
+	}
+
+	for b.Loop() {
+		start := time.Unix(0, 0)
+		w := newTimingWheel(start)
+		for i, k := range keys {
+			// up to a day
+			w.add(k, start.Add(time.Duration(i%86400)*time.Second))
+		}
+		expired := 0
+		for s := 1; s <= 86400; s++ {
+			expired += len(w.advance(start.Add(time.Duration(s) * time.Second)))
+		}
+		if expired != n {
+			b.Fatalf("expired %d", expired)
+		}
+	}
+}
+
+func BenchmarkTimingWheel_Add(b *testing.B) {
+	start := time.Unix(0, 0)
+	w := newTimingWheel(start)
+	for i := 0; i < 100_000; i++ {
+		w.add(fmt.Sprint(i), start.Add(time.Duration(i)*time.Second))
+	}
+
+	i := 0
+	for b.Loop() {
+		w.add(fmt.Sprint(i%100_000), start.Add(time.Duration(i)*time.Second))
+		i++
+	}
+}
@@ -0,0 +1,302 @@
+package firewall
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const whitelistStateKey = "whitelist"
+
+// WhitelistEntry is a whitelist rule added at runtime.
+type WhitelistEntry struct {
+	// Rule is an ip or a cidr.
+	Rule string `json:"rule"`
+	// ExpireAt is when the rule is removed, zero if never.
+	ExpireAt time.Time `json:"expire_at,omitzero"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// runtimeRule is a whitelist rule added at runtime. It is only accessed in
+// the loop goroutine.
+type runtimeRule struct {
+	entry   WhitelistEntry
+	matcher *ipMatcher
+}
+
+// AddToWhitelist whitelists rule, an ip or a cidr, until it is removed by
+// RemoveFromWhitelist.
+func (s *Firewall) AddToWhitelist(rule string) error {
+	return s.AddTempWhitelist(rule, 0, "")
+}
+
+// AddTempWhitelist whitelists rule for ttl, e.g. the ip of a contractor for
+// 8 hours, forever if ttl is not positive. Adding a rule again replaces its
+// ttl and reason. Runtime rules are persisted in the state store. It logs
+// "whitelist-add", and "whitelist-expired" once the rule expires.
+//
+// Ips jailed by this firewall matching the rule are unbanned, or only
+// warned about as "whitelist-overlap" if disabled by WithWhitelistUnban.
+func (s *Firewall) AddTempWhitelist(rule string, ttl time.Duration, reason string) error {
+	m, err := parseIPMatcher(rule)
+	if err != nil {
+		return err
+	}
+	e := WhitelistEntry{Rule: rule, Reason: reason}
+	if ttl > 0 {
+		e.ExpireAt = s.now().Add(ttl)
+	}
+
+	s.do(func() {
+		s.runtimeWhitelist[rule] = &runtimeRule{entry: e, matcher: m}
+		s.saveWhitelist()
+
+		reasons := []string{}
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+		s.log(&Event{
+			IP:        rule,
+			JailUntil: e.ExpireAt,
+			Reasons:   reasons,
+			Action:    "whitelist-add",
+		})
+		s.releaseWhitelisted(rule, m)
+	})
+	return nil
+}
+
+// releaseWhitelisted unbans the jailed ips and networks matching a new
+// whitelist rule, or warns about them if whitelistUnban is disabled.
+func (s *Firewall) releaseWhitelisted(rule string, m *ipMatcher) {
+	reasons := []string{"whitelisted by " + rule}
+	for ip, j := range s.jail {
+		if match := keyMatcher(ip); match == nil || !match(m) {
+			continue
+		}
+
+		if !s.whitelistUnban {
+			s.log(&Event{
+				IP:            ip,
+				JailUntil:     j.until,
+				Reasons:       reasons,
+				Action:        "whitelist-overlap",
+				Geo:           j.geo,
+				CorrelationID: j.correlationID,
+			})
+			continue
+		}
+
+		s.unbanWhitelisted(ip, j, reasons)
+	}
+}
+
+// unbanWhitelisted unbans the jailed ip matching a new whitelist rule. With
+// WithBanWorkers the backend is called in a worker, so the loop does not
+// wait for it, unless their queue is full. The ip stays jailed until the
+// backend returns.
+func (s *Firewall) unbanWhitelisted(ip string, j *jailed, reasons []string) {
+	finish := func(err error) {
+		if s.unbanResult(ip, err, j.correlationID) != nil {
+			return
+		}
+		// unbanned meanwhile, e.g. by UnbanIP
+		if s.jail[ip] != j {
+			return
+		}
+		s.removeJail(ip)
+		if ec, ok := s.errorCount[ip]; ok {
+			ec.bannedUntil = time.Time{}
+		}
+		s.log(&Event{
+			IP:            ip,
+			Reasons:       reasons,
+			Action:        "unban",
+			Geo:           j.geo,
+			CorrelationID: j.correlationID,
+		})
+	}
+
+	if s.banWorkers > 0 && s.fw != nil {
+		queued := s.submit(func() func() {
+			err := s.callUnbanBackend(ip)
+			return func() { finish(err) }
+		})
+		if queued {
+			return
+		}
+	}
+	finish(s.callUnbanBackend(ip))
+}
+
+// RemoveFromWhitelist removes a rule added at runtime and logs
+// "whitelist-remove". Rules passed to New can not be removed.
+func (s *Firewall) RemoveFromWhitelist(rule string) error {
+	var err error
+	s.do(func() {
+		if _, ok := s.runtimeWhitelist[rule]; !ok {
+			err = fmt.Errorf("%q is not a runtime whitelist rule", rule)
+			return
+		}
+		delete(s.runtimeWhitelist, rule)
+		s.saveWhitelist()
+		s.log(&Event{
+			IP:     rule,
+			Action: "whitelist-remove",
+		})
+	})
+	return err
+}
+
+// RuntimeWhitelist returns the rules added at runtime, ordered by rule.
+func (s *Firewall) RuntimeWhitelist() []WhitelistEntry {
+	res := []WhitelistEntry{}
+	s.do(func() {
+		for _, r := range s.runtimeWhitelist {
+			res = append(res, r.entry)
+		}
+	})
+	slices.SortFunc(res, func(a, b WhitelistEntry) int {
+		return cmp.Compare(a.Rule, b.Rule)
+	})
+	return res
+}
+
+// expireWhitelist removes the runtime rules expired at now and logs
+// "whitelist-expired".
+func (s *Firewall) expireWhitelist(now time.Time) {
+	expired := false
+	for rule, r := range s.runtimeWhitelist {
+		if r.entry.ExpireAt.IsZero() || r.entry.ExpireAt.After(now) {
+			continue
+		}
+		delete(s.runtimeWhitelist, rule)
+		expired = true
+
+		reasons := []string{}
+		if r.entry.Reason != "" {
+			reasons = append(reasons, r.entry.Reason)
+		}
+		s.log(&Event{
+			IP:        rule,
+			JailUntil: r.entry.ExpireAt,
+			Reasons:   reasons,
+			Action:    "whitelist-expired",
+		})
+	}
+	if expired {
+		s.saveWhitelist()
+	}
+}
+
+// loadWhitelist restores the runtime rules saved before a restart, rules
+// expired meanwhile are removed on the next tick.
+func (s *Firewall) loadWhitelist() {
+	b, err := s.state.LoadState(whitelistStateKey)
+	if err != nil {
+		log.Printf("load whitelist state failed: %v", err)
+		return
+	}
+	if b == nil {
+		return
+	}
+
+	entries := []WhitelistEntry{}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		log.Printf("decode whitelist state failed: %v", err)
+		return
+	}
+	for _, e := range entries {
+		m, err := parseIPMatcher(e.Rule)
+		if err != nil {
+			log.Printf("restore whitelist rule failed: %v", err)
+			continue
+		}
+		s.runtimeWhitelist[e.Rule] = &runtimeRule{entry: e, matcher: m}
+	}
+}
+
+// saveWhitelist saves the runtime rules to the state store if any.
+func (s *Firewall) saveWhitelist() {
+	if s.state == nil {
+		return
+	}
+
+	entries := []WhitelistEntry{}
+	for _, r := range s.runtimeWhitelist {
+		entries = append(entries, r.entry)
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		log.Printf("encode whitelist state failed: %v", err)
+		return
+	}
+	if err := s.state.SaveState(whitelistStateKey, b); err != nil {
+		log.Printf("save whitelist state failed: %v", err)
+	}
+}
+
+// parseASWhitelist splits rules of WithASWhitelist into autonomous system
+// numbers, "AS15169", and lowercase organization substrings.
+func parseASWhitelist(rules []string) (map[uint]bool, []string) {
+	asns := map[uint]bool{}
+	orgs := []string{}
+	for _, r := range rules {
+		if n, ok := strings.CutPrefix(strings.ToUpper(r), "AS"); ok {
+			if asn, err := strconv.ParseUint(n, 10, 32); err == nil {
+				asns[uint(asn)] = true
+				continue
+			}
+		}
+		if r != "" {
+			orgs = append(orgs, strings.ToLower(r))
+		}
+	}
+	return asns, orgs
+}
+
+// isPrivate returns whether ip, or the address of a network, is private,
+// loopback or link local, see WithSkipPrivateIPs.
+func isPrivate(ip string) bool {
+	a, err := netip.ParseAddr(networkAddr(ip))
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	return a.IsPrivate() || a.IsLoopback() || a.IsLinkLocalUnicast()
+}
+
+// inGeoWhitelist returns whether the country of ip is whitelisted by
+// WithCountryWhitelist, or its autonomous system by WithASWhitelist, by
+// number or organization.
+func (s *Firewall) inGeoWhitelist(ip string) bool {
+	if s.ipGeo == nil || len(s.countryWhitelist) == 0 && len(s.asWhitelist) == 0 && len(s.orgWhitelist) == 0 {
+		return false
+	}
+	geo := s.ipGeo.GetIPGeo(networkAddr(ip))
+	if geo == nil {
+		return false
+	}
+	if geo.CountryISO != "" && s.countryWhitelist[geo.CountryISO] {
+		return true
+	}
+	if geo.AutonomousSystemNumber != 0 && s.asWhitelist[geo.AutonomousSystemNumber] {
+		return true
+	}
+	org := strings.ToLower(geo.AutonomousSystemOrganization)
+	if org == "" {
+		return false
+	}
+	for _, o := range s.orgWhitelist {
+		if strings.Contains(org, o) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package firewall
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charleshuang3/firewall/ipgeo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeWhitelist(t *testing.T) {
+	store := &MockStateStore{m: map[string][]byte{}}
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{"10.0.0.0/8"}, mockFW, mockLogger, nil, testForgivable, WithStateStore(store))
+	require.NoError(t, err)
+
+	assert.Error(t, fw.AddToWhitelist("1.2.3.4/33"))
+	assert.Error(t, fw.AddToWhitelist("not an ip"))
+
+	mockLogger.Wg.Add(2)
+	require.NoError(t, fw.AddTempWhitelist("1.2.3.0/24", 8*time.Hour, "contractor"))
+	require.NoError(t, fw.AddToWhitelist("1.2.4.4"))
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, "whitelist-add", mockLogger.Events[0].Action)
+	assert.Equal(t, "1.2.3.0/24", mockLogger.Events[0].IP)
+	assert.Equal(t, []string{"contractor"}, mockLogger.Events[0].Reasons)
+	assert.WithinDuration(t, time.Now().Add(8*time.Hour), mockLogger.Events[0].JailUntil, time.Second)
+	assert.True(t, mockLogger.Events[1].JailUntil.IsZero())
+
+	entries := fw.RuntimeWhitelist()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "1.2.3.0/24", entries[0].Rule)
+	assert.Equal(t, "1.2.4.4", entries[1].Rule)
+
+	fw.BanIP("1.2.3.4", 10, "r")
+	fw.BanIP("1.2.4.4", 10, "r")
+	fw.do(func() {})
+	assert.Empty(t, mockFW.BannedIPs)
+
+	// rules are restored after a restart
+	restarted, err := New(nil, mockFW, mockLogger, nil, testForgivable, WithStateStore(store))
+	require.NoError(t, err)
+	restored := restarted.RuntimeWhitelist()
+	require.Len(t, restored, 2)
+	assert.Equal(t, "contractor", restored[0].Reason)
+	assert.True(t, entries[0].ExpireAt.Equal(restored[0].ExpireAt))
+	assert.Equal(t, "1.2.4.4", restored[1].Rule)
+
+	// expired rules are removed and audited
+	mockLogger.Wg.Add(1)
+	fw.do(func() {
+		fw.expireWhitelist(time.Now().Add(9 * time.Hour))
+	})
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "whitelist-expired", mockLogger.Events[2].Action)
+	assert.Equal(t, "1.2.3.0/24", mockLogger.Events[2].IP)
+	assert.Len(t, fw.RuntimeWhitelist(), 1)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.2.3.4", 10, "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, []string{"1.2.3.4"}, mockFW.BannedIPs)
+
+	// only runtime rules can be removed
+	assert.Error(t, fw.RemoveFromWhitelist("10.0.0.0/8"))
+	mockLogger.Wg.Add(1)
+	require.NoError(t, fw.RemoveFromWhitelist("1.2.4.4"))
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "whitelist-remove", mockLogger.Events[4].Action)
+	assert.Empty(t, fw.RuntimeWhitelist())
+
+	restarted, err = New(nil, mockFW, mockLogger, nil, testForgivable, WithStateStore(store))
+
+	require.NoError(t, err)
+	assert.Empty(t, restarted.RuntimeWhitelist())
+}
+
+func TestRuntimeWhitelist_UnbansJailed(t *testing.T) {
+	mockFW := &MockUnbanFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable)
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.2.3.4", 10, "r")
+	fw.BanIP("1.2.4.4", 10, "r")
+	mockLogger.Wg.Wait()
+
+	mockLogger.Wg.Add(2)
+	require.NoError(t, fw.AddToWhitelist("1.2.3.0/24"))
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.4"}, mockFW.UnbannedIPs)
+	e := mockLogger.Events[3]
+	assert.Equal(t, "unban", e.Action)
+	assert.Equal(t, "1.2.3.4", e.IP)
+	assert.Equal(t, []string{"whitelisted by 1.2.3.0/24"}, e.Reasons)
+	assert.Equal(t, mockLogger.Events[0].CorrelationID, e.CorrelationID)
+
+	banned, _ := fw.IsBanned("1.2.3.4")
+	assert.False(t, banned)
+	banned, _ = fw.IsBanned("1.2.4.4")
+	assert.True(t, banned)
+}
+
+func TestRuntimeWhitelist_Overlap(t *testing.T) {
+	mockFW := &MockUnbanFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable, WithWhitelistUnban(false))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.2.3.4", 10, "r")
+	mockLogger.Wg.Wait()
+
+	mockLogger.Wg.Add(2)
+	require.NoError(t, fw.AddToWhitelist("1.2.3.4"))
+	mockLogger.Wg.Wait()
+
+	assert.Empty(t, mockFW.UnbannedIPs)
+	e := mockLogger.Events[2]
+	assert.Equal(t, "whitelist-overlap", e.Action)
+	assert.Equal(t, "1.2.3.4", e.IP)
+	assert.Equal(t, []string{"whitelisted by 1.2.3.4"}, e.Reasons)
+
+	banned, _ := fw.IsBanned("1.2.3.4")
+	assert.True(t, banned)
+}
+
+func TestRuntimeWhitelist_Concurrent(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	fw, err := New([]string{}, mockFW, MultiLogger{}, nil, testForgivable)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		rule := fmt.Sprintf("1.2.3.%d", i)
+		wg.Go(func() {
+			for range 50 {
+				assert.NoError(t, fw.AddToWhitelist(rule))
+				fw.LogIPError(rule, "r")
+				assert.NoError(t, fw.RemoveFromWhitelist(rule))
+			}
+		})
+	}
+	wg.Wait()
+	assert.Empty(t, fw.RuntimeWhitelist())
+}
+
+func TestASWhitelist(t *testing.T) {
+	geo := ipgeo.StaticProvider{
+		"8.8.8.0/24":    {AutonomousSystemNumber: 15169, AutonomousSystemOrganization: "GOOGLE"},
+		"20.0.0.0/8":    {AutonomousSystemNumber: 8075, AutonomousSystemOrganization: "Microsoft Corporation"},
+		"1.2.3.0/24":    {AutonomousSystemNumber: 64500, AutonomousSystemOrganization: "Example Hosting"},
+		"2001:db8::/32": {AutonomousSystemNumber: 64501},
+	}
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, geo, testForgivable, WithASWhitelist("as15169", "microsoft", "AS64501"))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("8.8.8.8", 10, "r")
+	fw.BanIP("20.1.2.3", 10, "r")
+	fw.BanIP("2001:db8::1", 10, "r")
+	fw.LogIPError("8.8.8.8", "r")
+	fw.BanIP("1.2.3.4", 10, "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"1.2.3.4"}, mockFW.BannedIPs)
+	assert.Equal(t, uint64(4), fw.WhitelistHits())
+
+	asns, orgs := parseASWhitelist([]string{"AS15169", "as1", "ASUS", "AS", ""})
+	assert.Equal(t, map[uint]bool{15169: true, 1: true}, asns)
+	assert.Equal(t, []string{"asus", "as"}, orgs)
+}
+
+func TestCountryWhitelist(t *testing.T) {
+	geo := ipgeo.StaticProvider{
+		"1.2.3.0/24": {CountryISO: "DE"},
+		"5.6.7.0/24": {CountryISO: "FR"},
+	}
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, geo, ForgivableError{Duration: time.Minute, Count: 0, BanInMinute: 10}, WithCountryWhitelist("de"))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.2.3.4", 10, "r")
+	fw.LogIPError("1.2.3.5", "r")
+	fw.BanIP("::ffff:1.2.3.6", 10, "r")
+	fw.BanIP("5.6.7.8", 10, "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, []string{"5.6.7.8"}, mockFW.BannedIPs)
+	assert.Equal(t, uint64(3), fw.WhitelistHits())
+}
+
+func TestSkipPrivateIPs(t *testing.T) {
+	mockFW := &MockIFirewall{}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable, WithSkipPrivateIPs(true))
+	require.NoError(t, err)
+
+	for _, ip := range []string{"10.1.2.3", "172.16.0.1", "192.168.1.1", "127.0.0.1", "169.254.0.1", "::1", "fe80::1", "fd00::1", "::ffff:192.168.1.1"} {
+		assert.True(t, isPrivate(ip), ip)
+		fw.BanIP(ip, 10, "r")
+	}
+	for _, ip := range []string{"172.32.0.1", "8.8.8.8", "2001:db8::1", "not an ip"} {
+		assert.False(t, isPrivate(ip), ip)
+	}
+	assert.True(t, isPrivate("10.0.0.0/24"))
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("8.8.8.8", 10, "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, []string{"8.8.8.8"}, mockFW.BannedIPs)
+	assert.Equal(t, uint64(9), fw.WhitelistHits())
+}
+
+// stuckUnbanFirewall blocks every unban until release is closed.
+type stuckUnbanFirewall struct {
+	MockIFirewall
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (m *stuckUnbanFirewall) UnbanIP(ip string) error {
+	close(m.entered)
+	<-m.release
+	return nil
+}
+
+func TestRuntimeWhitelist_UnbanInWorker(t *testing.T) {
+	mockFW := &stuckUnbanFirewall{entered: make(chan struct{}), release: make(chan struct{})}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, mockFW, mockLogger, nil, testForgivable, WithBanWorkers(1))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.2.3.4", 10, "r")
+	require.NoError(t, fw.AddToWhitelist("1.2.3.0/24"))
+	<-mockFW.entered
+
+	// the loop counts errors while the backend unbans
+	mockLogger.Wg.Add(1)
+	fw.LogIPError("1.2.4.4", "r")
+	mockLogger.Wg.Wait()
+	banned, _ := fw.IsBanned("1.2.3.4")
+	assert.True(t, banned)
+
+	mockLogger.Wg.Add(1)
+	close(mockFW.release)
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "unban", mockLogger.Events[3].Action)
+	assert.Equal(t, []string{"whitelisted by 1.2.3.0/24"}, mockLogger.Events[3].Reasons)
+	banned, _ = fw.IsBanned("1.2.3.4")
+	assert.False(t, banned)
+}
@@ -0,0 +1,50 @@
+package firewall
+
+// banQueuePerWorker is how many bans wait for each worker before bans are
+// dropped, see submit.
+const banQueuePerWorker = 64
+
+// banJob calls the backend out of the loop and returns the rest of the ban,
+// or unban, to finish in the loop.
+type banJob func() func()
+
+// startWorkers starts the goroutines calling the backend for bans, see
+// WithBanWorkers.
+func (s *Firewall) startWorkers() {
+	s.jobs = make(chan banJob, s.banWorkers*banQueuePerWorker)
+	s.results = make(chan func())
+	for range s.banWorkers {
+		go func() {
+			for job := range s.jobs {
+				s.results <- job()
+			}
+		}()
+	}
+}
+
+// submit queues job for the workers. It returns false if the queue is
+// full, so the loop never waits for a slow backend.
+func (s *Firewall) submit(job banJob) bool {
+	select {
+	case s.jobs <- job:
+		s.inflight++
+		return true
+	default:
+		return false
+	}
+}
+
+// finish runs the result of a job in the loop.
+func (s *Firewall) finish(fn func()) {
+	s.inflight--
+	fn()
+}
+
+// stopWorkers waits for the jobs in flight, handles their results and stops
+// the workers.
+func (s *Firewall) stopWorkers() {
+	for s.inflight > 0 {
+		s.finish(<-s.results)
+	}
+	close(s.jobs)
+}
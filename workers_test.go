@@ -0,0 +1,127 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithBanWorkers(t *testing.T) {
+	backend := &blockingFirewall{entered: make(chan struct{}), release: make(chan struct{})}
+	mockLogger := &MockEventLogger{}
+	forgivable := ForgivableError{Duration: time.Minute, Count: 5, BanInMinute: 5}
+	fw, err := New([]string{}, backend, mockLogger, nil, forgivable, WithBanWorkers(2))
+	require.NoError(t, err)
+
+	fw.BanIP("1.1.1.1", 10, "r")
+	<-backend.entered
+
+	// errors are counted while the backend is stuck, the ip is jailed
+	mockLogger.Wg.Add(2)
+	fw.LogIPError("1.2.3.1", "r")
+	fw.LogIPError("1.2.3.2", "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "count error", mockLogger.Events[0].Action)
+	assert.Equal(t, "count error", mockLogger.Events[1].Action)
+	ok, _ := fw.IsBanned("1.1.1.1")
+	assert.True(t, ok)
+
+	mockLogger.Wg.Add(1)
+	close(backend.release)
+	mockLogger.Wg.Wait()
+	e := mockLogger.Events[2]
+	assert.Equal(t, "ban", e.Action)
+	assert.Equal(t, "1.1.1.1", e.IP)
+	require.NotNil(t, e.Latency)
+	assert.Equal(t, 1, fw.EnforcementStats().Count)
+}
+
+func TestWithBanWorkers_BackendError(t *testing.T) {
+	backend := &MockErrorFirewall{Err: assert.AnError}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, backend, mockLogger, nil, testForgivable, WithBanWorkers(1))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.1.1.1", 10, "r")
+	mockLogger.Wg.Wait()
+
+	assert.Equal(t, "backend-error", mockLogger.Events[0].Action)
+	assert.Equal(t, "ban", mockLogger.Events[1].Action)
+}
+
+func TestWithBanWorkers_Close(t *testing.T) {
+	backend := &blockingFirewall{entered: make(chan struct{}), release: make(chan struct{})}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, backend, mockLogger, nil, testForgivable, WithBanWorkers(1))
+	require.NoError(t, err)
+
+	mockLogger.Wg.Add(1)
+	fw.BanIP("1.1.1.1", 10, "r")
+	<-backend.entered
+
+	// Close waits for the ban in flight
+	closed := make(chan error)
+	go func() {
+		closed <- fw.Close(context.Background())
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the ban finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(backend.release)
+	require.NoError(t, <-closed)
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "ban", mockLogger.Events[0].Action)
+}
+
+// stuckFirewall blocks every ban until release is closed.
+type stuckFirewall struct {
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *stuckFirewall) BanIP(ip string, timeoutInMinute int) {
+	b.once.Do(func() { close(b.entered) })
+	<-b.release
+}
+
+func TestWithBanWorkers_QueueFull(t *testing.T) {
+	backend := &stuckFirewall{entered: make(chan struct{}), release: make(chan struct{})}
+	mockLogger := &MockEventLogger{}
+	fw, err := New([]string{}, backend, mockLogger, nil, testForgivable, WithBanWorkers(1))
+	require.NoError(t, err)
+
+	fw.BanIP("1.1.1.1", 10, "r")
+	<-backend.entered
+	for i := range banQueuePerWorker {
+		fw.BanIP(fmt.Sprintf("1.1.2.%d", i), 10, "r")
+	}
+
+	// the loop does not wait for a worker, the ban is dropped
+	mockLogger.Wg.Add(2)
+	fw.BanIP("1.1.3.1", 10, "r")
+	fw.LogIPError("1.2.3.1", "r")
+	mockLogger.Wg.Wait()
+	assert.Equal(t, "ban-dropped", mockLogger.Events[0].Action)
+	assert.Equal(t, "1.1.3.1", mockLogger.Events[0].IP)
+	assert.Equal(t, "count error", mockLogger.Events[1].Action)
+	assert.Equal(t, uint64(1), fw.Dropped())
+	ok, _ := fw.IsBanned("1.1.3.1")
+	assert.False(t, ok)
+	ok, _ = fw.IsBanned("1.1.2.0")
+	assert.True(t, ok)
+
+	mockLogger.Wg.Add(1 + banQueuePerWorker)
+	close(backend.release)
+	mockLogger.Wg.Wait()
+	require.NoError(t, fw.Close(context.Background()))
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/charleshuang3/firewall/ipgeo"
 )
 
-var _ firewall.ILogger = (*ZeroLog)(nil)
+var _ firewall.IEventLogger = (*ZeroLog)(nil)
 
 type ZeroLog struct {
 	logger zlog.Logger
@@ -26,20 +26,94 @@ func New(logger zlog.Logger, level zlog.Level, service string) *ZeroLog {
 }
 
 func (z *ZeroLog) Log(ip string, jailUntil time.Time, reasons []string, action string, geo *ipgeo.IPGeo) {
+	z.LogEvent(&firewall.Event{
+		IP:        ip,
+		JailUntil: jailUntil,
+		Reasons:   reasons,
+		Action:    action,
+		Geo:       geo,
+	})
+}
+
+func (z *ZeroLog) LogEvent(ev *firewall.Event) {
 	var b []byte
-	if geo != nil {
-		b, _ = json.Marshal(geo)
+	if ev.Geo != nil {
+		b, _ = json.Marshal(ev.Geo)
 	}
 
 	e := z.logger.WithLevel(z.level).
-		Str("ip", ip).
-		Time("jail_until", jailUntil).
-		Strs("reasons", reasons).
-		Str("action", action)
+		Str("ip", ev.IP).
+		Time("jail_until", ev.JailUntil).
+		Strs("reasons", ev.Reasons).
+		Str("action", ev.Action)
 
 	if b != nil {
 		e.RawJSON("geo", b)
 	}
 
+	if ev.CorrelationID != "" {
+		e.Str("correlation_id", ev.CorrelationID)
+	}
+
+	if a := ev.Annotation; a != nil {
+		e.Dict("annotation", zlog.Dict().
+			Str("operator", a.Operator).
+			Str("ticket", a.Ticket))
+	}
+
+	if len(ev.Labels) > 0 {
+		d := zlog.Dict()
+		for k, v := range ev.Labels {
+			d.Str(k, v)
+		}
+		e.Dict("labels", d)
+	}
+
+	if len(ev.Metadata) > 0 {
+		d := zlog.Dict()
+		for k, v := range ev.Metadata {
+			d.Str(k, v)
+		}
+		e.Dict("metadata", d)
+	}
+
+	if ev.BanAction != firewall.BanActionBlock {
+		e.Str("ban_action", string(ev.BanAction))
+	}
+
+	if be := ev.Backend; be != nil {
+		e.Dict("backend", zlog.Dict().
+			Str("name", be.Backend).
+			Str("op", be.Op).
+			Int("attempt", be.Attempt).
+			Str("class", be.Class()).
+			AnErr("error", be.Err))
+	}
+
+	if l := ev.Latency; l != nil {
+		e.Dict("latency", zlog.Dict().
+			Dur("queue", l.Queue).
+			Dur("backend", l.Backend).
+			Dur("total", l.Total()))
+	}
+
+	if len(ev.ReasonCounts) > 0 {
+		d := zlog.Dict()
+		for r, n := range ev.ReasonCounts {
+			d.Int(r, n)
+		}
+		e.Dict("reason_counts", d)
+	}
+
+	if len(ev.Offenses) > 0 {
+		arr := zlog.Arr()
+		for _, o := range ev.Offenses {
+			arr.Dict(zlog.Dict().
+				Time("time", o.Time).
+				Str("reason", o.Reason))
+		}
+		e.Array("offenses", arr)
+	}
+
 	e.Msg("") // emit the log
 }